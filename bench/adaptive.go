@@ -0,0 +1,93 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+
+	"golang.org/x/perf/benchmath"
+)
+
+// adaptiveUnit is the unit the sequential analysis looks at.
+const adaptiveUnit = "sec/op"
+
+// diffInterval returns the confidence interval in percent of the change of
+// head relative to base. It returns false, when there are not enough samples
+// of both sources.
+func (b *bench) diffInterval(confidence float64) (lo, hi float64, ok bool) {
+	var base, head []float64
+	for _, s := range b.samples {
+		if s.Unit != adaptiveUnit {
+			continue
+		}
+		switch s.Source {
+		case benchSourceBase.String():
+			base = append(base, s.Value)
+		case benchSourceHead.String():
+			head = append(head, s.Value)
+		}
+	}
+	if len(base) == 0 || len(head) == 0 {
+		return 0, 0, false
+	}
+
+	baseSummary := benchmath.AssumeNothing.Summary(benchmath.NewSample(base, &benchmath.DefaultThresholds), confidence)
+	headSummary := benchmath.AssumeNothing.Summary(benchmath.NewSample(head, &benchmath.DefaultThresholds), confidence)
+	// too few samples for a confidence interval yield infinite bounds
+	for _, v := range []float64{baseSummary.Lo, baseSummary.Hi, headSummary.Lo, headSummary.Hi} {
+		if math.IsInf(v, 0) {
+			return 0, 0, false
+		}
+	}
+	if baseSummary.Lo <= 0 {
+		return 0, 0, false
+	}
+	lo = (headSummary.Lo/baseSummary.Hi - 1) * 100
+	hi = (headSummary.Hi/baseSummary.Lo - 1) * 100
+	return lo, hi, true
+}
+
+// conclusive returns true, when the confidence interval of the change lies
+// either completely beyond the threshold in one direction or completely
+// within it. With a target width, the interval needs to be narrower than it
+// instead.
+func (b *bench) conclusive(threshold, width float64) bool {
+	lo, hi, ok := b.diffInterval(0.95)
+	if !ok {
+		return false
+	}
+	if width > 0 {
+		return hi-lo <= width
+	}
+	switch {
+	case lo > threshold, hi < -threshold:
+		// changed by more than the threshold
+		return true
+	case lo >= -threshold && hi <= threshold:
+		// changed by less than the threshold
+		return true
+	}
+	return false
+}
+
+// nextCount returns how many more samples of the benchmark to collect, after
+// total samples have been collected so far. It returns 0 once the result is
+// conclusive or maxCount is reached.
+func (b *bench) nextCount(step, total, maxCount uint16, threshold, width float64) uint16 {
+	if b.base == nil || b.head == nil || b.timedOut || total >= maxCount {
+		return 0
+	}
+	if b.conclusive(threshold, width) {
+		return 0
+	}
+	return min(step, maxCount-total)
+}
+
+// inconclusiveWarning is shown, when the maximum count or the time budget has
+// been reached without a conclusive result.
+func (b *bench) inconclusiveWarning(total uint16, threshold, width float64) string {
+	lo, hi, ok := b.diffInterval(0.95)
+	if !ok || b.conclusive(threshold, width) {
+		return ""
+	}
+	return fmt.Sprintf("still inconclusive after %d runs: the change of %s is between %.2f %% and %.2f %%", total, adaptiveUnit, lo, hi)
+}
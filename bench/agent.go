@@ -0,0 +1,459 @@
+package bench
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	executorAgent = "agent"
+
+	agentInfoPath = "/v1/info"
+	agentRunPath  = "/v1/run"
+
+	// agentMaxBundleSize limits the size of the bundles an agent accepts.
+	agentMaxBundleSize = 2 << 30
+
+	// agentTimeoutCode is the exit code reported for a test binary that
+	// exceeded the timeout of its spec, like the one of timeout(1).
+	agentTimeoutCode = 124
+)
+
+// agentSpec describes how the agent runs the test binary of a bundle.
+type agentSpec struct {
+	Args    []string      `json:"args"`
+	Env     []string      `json:"env"`
+	Timeout time.Duration `json:"timeout"` // no timeout when zero
+}
+
+// agentInfo describes the machine of an agent.
+type agentInfo struct {
+	GOOS   string `json:"goos"`
+	GOARCH string `json:"goarch"`
+	CPUs   int    `json:"cpus"`
+}
+
+type AgentArgs struct {
+	Listen      string
+	Token       string
+	WorkDir     string // where bundles are extracted to, the temporary directory when empty
+	Concurrency int    // test binaries running at the same time
+	ScrubEnv    *ScrubEnvArgs
+}
+
+func AddAgentCommand(app *kingpin.Application) (*kingpin.CmdClause, *AgentArgs) {
+	cmd := app.Command("agent", "Run the test binaries sent by pyrobench with --executor agent, e.g. on a quiet dedicated benchmark machine.")
+	args := &AgentArgs{}
+	cmd.Flag("listen", "Address to accept runs on.").Default(":7070").StringVar(&args.Listen)
+	cmd.Flag("token", "Bearer token the orchestrating pyrobench needs to authenticate with.").Envar("PYROBENCH_AGENT_TOKEN").Required().StringVar(&args.Token)
+	cmd.Flag("work-dir", "Directory to extract the test binaries and packages to.").ExistingDirVar(&args.WorkDir)
+	cmd.Flag("concurrency", "How many test binaries run at the same time, further runs are queued.").Default("1").IntVar(&args.Concurrency)
+	args.ScrubEnv = addScrubEnvArgs(cmd, true) // the test binaries are sent by others
+	return cmd, args
+}
+
+// Agent accepts runs of test binaries until the context is canceled.
+func (b *Benchmark) Agent(ctx context.Context, args *AgentArgs) error {
+	if args.Concurrency < 1 {
+		return fmt.Errorf("invalid concurrency %d", args.Concurrency)
+	}
+	srv := &http.Server{
+		Addr:              args.Listen,
+		Handler:           newAgentHandler(b.logger, args.Token, args.WorkDir, args.Concurrency, agentEnviron(b.scrubEnvContext(ctx, args.ScrubEnv))),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	level.Info(b.logger).Log("msg", "waiting for test binaries to run", "listen", args.Listen, "concurrency", args.Concurrency)
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// cancels the runs in progress
+		return srv.Close()
+	}
+	return nil
+}
+
+// agentEnviron returns the environment the test binaries run with on the
+// agent, which never includes the token of the agent.
+func agentEnviron(ctx context.Context) []string {
+	var env []string
+	for _, e := range environFromContext(ctx) {
+		if !strings.HasPrefix(e, "PYROBENCH_AGENT_TOKEN=") {
+			env = append(env, e)
+		}
+	}
+	return env
+}
+
+type agentHandler struct {
+	logger  log.Logger
+	token   string
+	workDir string
+	slots   chan struct{}
+	environ []string // of the test binaries
+}
+
+func newAgentHandler(logger log.Logger, token, workDir string, concurrency int, environ []string) http.Handler {
+	h := &agentHandler{
+		logger:  logger,
+		token:   token,
+		workDir: workDir,
+		slots:   make(chan struct{}, concurrency),
+		environ: environ,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(agentInfoPath, h.info)
+	mux.HandleFunc(agentRunPath, h.run)
+	return h.authenticate(mux)
+}
+
+func (h *agentHandler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *agentHandler) info(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(agentInfo{GOOS: runtime.GOOS, GOARCH: runtime.GOARCH, CPUs: runtime.NumCPU()})
+}
+
+// run extracts the bundle of the request, runs its test binary and responds
+// with the result in the format of the Kubernetes pods. The timeout of the
+// spec starts once the run left the queue.
+func (h *agentHandler) run(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// queue the run, so concurrent test binaries do not disturb each other
+	select {
+	case h.slots <- struct{}{}:
+	case <-r.Context().Done():
+		return
+	}
+	defer func() { <-h.slots }()
+
+	dir, err := os.MkdirTemp(h.workDir, "pyrobench-agent-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	spec, err := extractBundle(http.MaxBytesReader(w, r.Body, agentMaxBundleSize), dir)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid bundle: %v", err), http.StatusBadRequest)
+		return
+	}
+	level.Debug(h.logger).Log("msg", "running test binary", "args", strings.Join(spec.Args, " "))
+	ctx := addEnvironToContext(r.Context(), h.environ)
+	code, err := runAgentSpec(ctx, h.logger, dir, spec)
+	if err != nil {
+		level.Warn(h.logger).Log("msg", "error running test binary", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	if err := writeAgentResult(w, dir, code); err != nil {
+		// the status has been sent already, the client fails on the truncated archive
+		level.Warn(h.logger).Log("msg", "error sending result", "err", err)
+	}
+}
+
+// extractBundle extracts the package directory, the test binary and the
+// output directory of a bundle to dir and returns its spec.
+func extractBundle(r io.Reader, dir string) (*agentSpec, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	var spec *agentSpec
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		name := path.Clean(h.Name)
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		switch {
+		case name == "spec.json":
+			spec = &agentSpec{}
+			err = json.NewDecoder(tr).Decode(spec)
+		case name == "pyrobench.test" && h.Typeflag == tar.TypeReg:
+			if err = extractFile(tr, p, dir); err == nil {
+				err = os.Chmod(p, 0o755)
+			}
+		case !strings.HasPrefix(name, "pkg/") && !strings.HasPrefix(name, "out/"):
+			// neither part of the package nor of its output
+		case h.Typeflag == tar.TypeDir:
+			err = os.MkdirAll(p, 0o755)
+		case h.Typeflag == tar.TypeReg:
+			if err = extractFile(tr, p, dir); err == nil {
+				err = os.Chmod(p, h.FileInfo().Mode().Perm())
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if spec == nil {
+		return nil, errors.New("bundle lacks spec.json")
+	}
+	for _, d := range []string{"pkg", "out"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return spec, nil
+}
+
+// runAgentSpec runs the test binary extracted to dir and returns its exit
+// code. Its output is written to the files stdout and stderr in dir.
+func runAgentSpec(ctx context.Context, logger log.Logger, dir string, spec *agentSpec) (int, error) {
+	if spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, spec.Timeout)
+		defer cancel()
+	}
+	// removes the scratch directories of the run
+	cleaner := &cleaner{}
+	ctx = addCleanupToContext(ctx, cleaner.add)
+	defer func() {
+		if err := cleaner.cleanup(); err != nil {
+			level.Warn(logger).Log("msg", "error cleaning up", "err", err)
+		}
+	}()
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		return 0, err
+	}
+	defer stdout.Close()
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		return 0, err
+	}
+	defer stderr.Close()
+
+	p := &Package{
+		logger:     logger,
+		meta:       &packageMeta{Dir: filepath.Join(dir, "pkg")},
+		testBinary: filepath.Join(dir, "pyrobench.test"),
+	}
+	cmd := &benchCommand{
+		args:   spec.Args,
+		env:    spec.Env,
+		outDir: filepath.Join(dir, "out"),
+		stdout: stdout,
+		stderr: stderr,
+		active: func() bool { return true },
+	}
+	var code int
+	if _, err := (localExecutor{}).run(ctx, p, cmd); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return 0, err
+		}
+		switch code = exitErr.ExitCode(); {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			code = agentTimeoutCode
+		case code < 0:
+			// killed by a signal
+			code = 128 + 9
+		}
+	}
+	return code, nil
+}
+
+// writeAgentResult streams the result archive with the output, the exit code
+// and the files written by the test binary run in dir to w.
+func writeAgentResult(w io.Writer, dir string, code int) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, name := range []string{"stdout", "stderr"} {
+		if err := addTarFile(tw, filepath.Join(dir, name), name, 0o644); err != nil {
+			return err
+		}
+	}
+	exitCode := []byte(strconv.Itoa(code) + "\n")
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "exitcode", Mode: 0o644, Size: int64(len(exitCode)), ModTime: time.Now()}); err != nil {
+		return err
+	}
+	if _, err := tw.Write(exitCode); err != nil {
+		return err
+	}
+	if err := addTarFiles(tw, filepath.Join(dir, "out"), "out"); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+type AgentExecutorArgs struct {
+	URL   string
+	Token string
+}
+
+func addAgentExecutorArgs(cmd *kingpin.CmdClause, args *AgentExecutorArgs) {
+	cmd.Flag("agent-url", "URL of the 'pyrobench agent' running the test binaries with --executor agent.").PlaceHolder("URL").StringVar(&args.URL)
+	cmd.Flag("agent-token", "Bearer token to authenticate at the agent with.").Envar("PYROBENCH_AGENT_TOKEN").StringVar(&args.Token)
+}
+
+// agentExecutor sends the test binaries to a 'pyrobench agent', which runs
+// them on its machine.
+type agentExecutor struct {
+	logger log.Logger
+	args   *AgentExecutorArgs
+	client *http.Client
+}
+
+func newAgentExecutor(logger log.Logger, args *AgentExecutorArgs) (*agentExecutor, error) {
+	if args == nil || args.URL == "" {
+		return nil, errors.New("--executor agent requires --agent-url")
+	}
+	u, err := url.Parse(args.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported agent URL %q, expected http(s)", args.URL)
+	}
+	return &agentExecutor{logger: logger, args: args, client: &http.Client{}}, nil
+}
+
+func (a *agentExecutor) do(ctx context.Context, method, p string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(a.args.URL, "/")+p, body)
+	if err != nil {
+		return nil, err
+	}
+	if a.args.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.args.Token)
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, p, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (a *agentExecutor) info(ctx context.Context) (*agentInfo, error) {
+	resp, err := a.do(ctx, http.MethodGet, agentInfoPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var info agentInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// agentRequestContext returns the context of a run request. The deadline of
+// ctx is left to the agent, which starts the timeout of the spec once the run
+// left its queue, so the time spent queued does not count against the run.
+func agentRequestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	reqCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			cancel()
+		}
+	})
+	return reqCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+func (a *agentExecutor) run(ctx context.Context, p *Package, cmd *benchCommand) (*execution, error) {
+	args, env := relocateCommand(cmd)
+	spec := agentSpec{Args: args, Env: env, Timeout: cmd.remoteTimeout(ctx)}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := agentRequestContext(ctx)
+	defer cancel()
+
+	// streams the bundle, which can be larger than what fits into memory
+	var bundleErr error
+	bundled := make(chan struct{})
+	pr, pw := io.Pipe()
+	go func() {
+		defer close(bundled)
+		bundleErr = writeBundle(pw, p, cmd.outDir, data)
+		pw.CloseWithError(bundleErr)
+	}()
+	defer func() {
+		pr.Close()
+		<-bundled
+	}()
+
+	e := &execution{started: time.Now(), remote: true}
+	resp, err := a.do(ctx, http.MethodPost, agentRunPath, pr)
+	if err != nil {
+		pr.Close()
+		<-bundled
+		if bundleErr != nil && !errors.Is(bundleErr, io.ErrClosedPipe) {
+			return nil, fmt.Errorf("failed to bundle test binary: %w", bundleErr)
+		}
+		return nil, fmt.Errorf("failed to run test binary on agent: %w", err)
+	}
+	defer resp.Body.Close()
+	code, err := extractResult(resp.Body, cmd)
+	e.exited = time.Now()
+	if err != nil {
+		return e, fmt.Errorf("failed to receive result: %w", err)
+	}
+	if code == agentTimeoutCode && spec.Timeout > 0 {
+		return e, fmt.Errorf("test binary exceeded its timeout of %s on the agent: %w", spec.Timeout, context.DeadlineExceeded)
+	}
+	if code != 0 {
+		return e, fmt.Errorf("test binary exited with code %d", code)
+	}
+	return e, nil
+}
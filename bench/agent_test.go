@@ -0,0 +1,157 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+)
+
+// compileAgentTestPackage compiles a package, whose benchmark reads its
+// testdata and checks that the token of the agent did not leak.
+func compileAgentTestPackage(t *testing.T) (context.Context, *Package) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.22\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "remote", "testdata"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "remote", "remote.go"), []byte("package remote\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "remote", "testdata", "input.txt"), []byte("input"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "remote", "remote_test.go"), []byte(`package remote
+
+import (
+	"os"
+	"testing"
+)
+
+func BenchmarkRead(b *testing.B) {
+	if os.Getenv("PYROBENCH_AGENT_TOKEN") != "" {
+		b.Fatal("the token of the agent leaked into the test binary")
+	}
+	for i := 0; i < b.N; i++ {
+		if _, err := os.ReadFile("testdata/input.txt"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+`), 0o644))
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	t.Cleanup(func() {
+		require.NoError(t, cleaner.cleanup())
+	})
+
+	pkgs, err := discoverPackages(ctx, log.NewNopLogger(), dir, []string{"./..."}, nil)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	p := &pkgs[0]
+	require.NoError(t, p.compileTest(ctx))
+	return ctx, p
+}
+
+func TestAgentExecutor(t *testing.T) {
+	ctx, p := compileAgentTestPackage(t)
+
+	t.Setenv("PYROBENCH_AGENT_TOKEN", "secret")
+	workDir := t.TempDir()
+	srv := httptest.NewServer(newAgentHandler(log.NewNopLogger(), "secret", workDir, 1, agentEnviron(ctx)))
+	t.Cleanup(srv.Close)
+
+	// the token is required
+	_, err := newAgentExecutor(log.NewNopLogger(), &AgentExecutorArgs{})
+	require.Error(t, err)
+	unauthorized, err := newAgentExecutor(log.NewNopLogger(), &AgentExecutorArgs{URL: srv.URL, Token: "wrong"})
+	require.NoError(t, err)
+	_, err = unauthorized.info(ctx)
+	require.ErrorContains(t, err, "401")
+
+	e, err := newAgentExecutor(log.NewNopLogger(), &AgentExecutorArgs{URL: srv.URL, Token: "secret"})
+	require.NoError(t, err)
+	info, err := e.info(ctx)
+	require.NoError(t, err)
+	require.Positive(t, info.CPUs)
+
+	var uploads int
+	ctx = addExecutorToContext(ctx, e)
+	ctx = addUploaderToContext(ctx, uploaderFunc(func(context.Context, log.Logger, io.Reader) (*profileResponse, error) {
+		uploads++
+		return &profileResponse{Key: fmt.Sprintf("key-%d", uploads)}, nil
+	}))
+
+	res, err := p.runBenchmark(ctx, runOptions{benchTime: "10x", count: 2, timeout: time.Minute}, "BenchmarkRead")
+	require.NoError(t, err)
+	require.Len(t, res.RawResult, 2)
+	require.NotEmpty(t, res.CPU.Key)
+	require.NotEmpty(t, res.AllocSpace.Key)
+
+	// the bundles are removed once the run finished
+	entries, err := os.ReadDir(workDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestAgentConcurrentRuns(t *testing.T) {
+	ctx, p := compileAgentTestPackage(t)
+
+	// spare capacity, which appending to the shared environment must not use
+	environ := append(make([]string, 0, 64), os.Environ()...)
+	srv := httptest.NewServer(newAgentHandler(log.NewNopLogger(), "secret", t.TempDir(), 2, environ))
+	t.Cleanup(srv.Close)
+	e, err := newAgentExecutor(log.NewNopLogger(), &AgentExecutorArgs{URL: srv.URL, Token: "secret"})
+	require.NoError(t, err)
+	ctx = addExecutorToContext(ctx, e)
+	ctx = addUploaderToContext(ctx, uploaderFunc(func(context.Context, log.Logger, io.Reader) (*profileResponse, error) {
+		return &profileResponse{Key: "key"}, nil
+	}))
+
+	var g errgroup.Group
+	for range 2 {
+		g.Go(func() error {
+			_, err := p.runBenchmark(ctx, runOptions{benchTime: "10x", count: 1, timeout: time.Minute}, "BenchmarkRead")
+			return err
+		})
+	}
+	require.NoError(t, g.Wait())
+	require.Len(t, environ, len(os.Environ()))
+	require.Empty(t, environ[len(environ):cap(environ)][0])
+}
+
+func TestAgentHandlerRejectsInvalidBundles(t *testing.T) {
+	srv := httptest.NewServer(newAgentHandler(log.NewNopLogger(), "secret", t.TempDir(), 1, nil))
+	t.Cleanup(srv.Close)
+
+	e, err := newAgentExecutor(log.NewNopLogger(), &AgentExecutorArgs{URL: srv.URL, Token: "secret"})
+	require.NoError(t, err)
+	_, err = e.do(context.Background(), http.MethodPost, agentRunPath, nil)
+	require.ErrorContains(t, err, "400")
+	_, err = e.do(context.Background(), http.MethodGet, agentRunPath, nil)
+	require.ErrorContains(t, err, "405")
+}
+
+func TestAgentRequestContext(t *testing.T) {
+	// the deadline is left to the agent
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	reqCtx, reqCancel := agentRequestContext(ctx)
+	defer reqCancel()
+	<-ctx.Done()
+	_, ok := reqCtx.Deadline()
+	require.False(t, ok)
+	require.NoError(t, reqCtx.Err())
+
+	// cancellation is not
+	ctx, cancel = context.WithCancel(context.Background())
+	reqCtx, reqCancel = agentRequestContext(ctx)
+	defer reqCancel()
+	cancel()
+	<-reqCtx.Done()
+	require.ErrorIs(t, reqCtx.Err(), context.Canceled)
+}
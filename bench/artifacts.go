@@ -0,0 +1,134 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"golang.org/x/perf/benchfmt"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+const (
+	// artifactsManifestFile is the name of the file describing the content of
+	// the artifacts directory.
+	artifactsManifestFile = "manifest.json"
+
+	// artifactsOutput, artifactsStderr and artifactsResults are the files
+	// the raw output of a run is kept in, next to its profiles.
+	artifactsOutput  = "output.txt"
+	artifactsStderr  = "stderr.txt"
+	artifactsResults = "results.txt"
+)
+
+// artifactsManifest describes the content of the artifacts directory, so it
+// can be analyzed offline.
+type artifactsManifest struct {
+	Created     time.Time           `json:"created"`
+	Base        artifactsRef        `json:"base"`
+	Head        artifactsRef        `json:"head"`
+	Environment *report.Environment `json:"environment"`
+	Benchmarks  []artifactsBench    `json:"benchmarks"`
+}
+
+type artifactsRef struct {
+	Ref    string `json:"ref,omitempty"` // as given, empty for the working directory
+	Commit string `json:"commit"`
+}
+
+type artifactsBench struct {
+	Package string   `json:"package"`
+	Name    string   `json:"name"`
+	Reason  string   `json:"reason"`
+	Runs    []string `json:"runs"` // directories relative to the manifest
+}
+
+// runArtifacts returns the directory the raw output of a run of base or head
+// is kept in, e.g. <dir>/<package>/<benchmark>/head-2, and records it for the
+// manifest. It returns an empty path, when artifacts are disabled or the
+// directory can not be created.
+func (b *Benchmark) runArtifacts(dir string, r *benchWithKey, src benchSource, run, gomaxprocs int) string {
+	if dir == "" {
+		return ""
+	}
+	name := fmt.Sprintf("%s-%d", src, run)
+	if gomaxprocs > 0 {
+		name += fmt.Sprintf("-cpu%d", gomaxprocs)
+	}
+	path, err := artifactPath(dir, r.key, name)
+	if err == nil {
+		err = os.Mkdir(path, 0o755)
+	}
+	if err != nil && !os.IsExist(err) {
+		level.Warn(b.logger).Log("msg", "failed to create artifacts directory", "package", r.key.packagePath, "benchmark", r.key.benchmark, "err", err)
+		return ""
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return path
+	}
+	r.artifacts = append(r.artifacts, filepath.ToSlash(rel))
+	return path
+}
+
+// writeRunArtifacts keeps the raw output of the test binary and the benchfmt
+// records parsed from it.
+func writeRunArtifacts(dir string, stdout, stderr []byte, results []*benchfmt.Result) error {
+	if err := os.WriteFile(filepath.Join(dir, artifactsOutput), stdout, 0o644); err != nil {
+		return err
+	}
+	if len(stderr) > 0 {
+		if err := os.WriteFile(filepath.Join(dir, artifactsStderr), stderr, 0o644); err != nil {
+			return err
+		}
+	}
+	buf := new(bytes.Buffer)
+	w := benchfmt.NewWriter(buf)
+	for _, res := range results {
+		if err := w.Write(res); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, artifactsResults), buf.Bytes(), 0o644)
+}
+
+// writeArtifactsManifest writes the commits, the environment and the runs of
+// every benchmark to the manifest of the artifacts directory.
+func (b *Benchmark) writeArtifactsManifest(ctx context.Context, args *CompareArgs, benchmarkGroups [][]*benchWithKey) error {
+	env := b.environment
+	if env == nil {
+		env = machineEnvironment(ctx)
+	}
+	m := artifactsManifest{
+		Created:     time.Now().UTC(),
+		Base:        artifactsRef{Ref: args.BaseRef, Commit: b.baseCommit},
+		Head:        artifactsRef{Ref: args.HeadRef, Commit: b.headCommit},
+		Environment: b.reportEnvironment(env),
+		Benchmarks:  []artifactsBench{},
+	}
+	for _, benchmarks := range benchmarkGroups {
+		for _, r := range benchmarks {
+			m.Benchmarks = append(m.Benchmarks, artifactsBench{
+				Package: r.key.packagePath,
+				Name:    r.key.benchmark,
+				Reason:  r.reason,
+				Runs:    r.artifacts,
+			})
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(args.ArtifactsDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(args.ArtifactsDir, artifactsManifestFile), append(data, '\n'), 0o644)
+}
@@ -0,0 +1,78 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.22\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a", "a_test.go"), []byte(`package a
+
+import "testing"
+
+func BenchmarkA(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+	}
+}
+`), 0o644))
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	t.Cleanup(func() {
+		require.NoError(t, cleaner.cleanup())
+	})
+	ctx = addUploaderToContext(ctx, uploaderFunc(func(context.Context, log.Logger, io.Reader) (*profileResponse, error) {
+		return &profileResponse{Key: "key"}, nil
+	}))
+
+	pkgs, err := discoverPackages(ctx, log.NewNopLogger(), dir, []string{"./..."}, nil)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	p := &pkgs[0]
+	require.NoError(t, p.compileTest(ctx))
+
+	b, err := New(log.NewNopLogger())
+	require.NoError(t, err)
+	b.baseCommit, b.headCommit = "base-sha", "head-sha"
+	r := &benchWithKey{key: benchKey{"example.com/m/a", "BenchmarkA"}, bench: &bench{head: p, reason: "benchmark does not exist in base"}}
+
+	artifactsDir := t.TempDir()
+	runDir := b.runArtifacts(artifactsDir, r, benchSourceHead, 1, 0)
+	require.Equal(t, filepath.Join(artifactsDir, "example.com", "m", "a", "BenchmarkA", "head-1"), runDir)
+
+	_, err = p.runBenchmark(ctx, runOptions{benchTime: "10x", count: 2, timeout: time.Minute, artifacts: runDir}, "BenchmarkA")
+	require.NoError(t, err)
+	for _, name := range []string{"cpu.pprof", "mem.pprof", artifactsOutput, artifactsResults} {
+		require.FileExists(t, filepath.Join(runDir, name))
+	}
+	results, err := os.ReadFile(filepath.Join(runDir, artifactsResults))
+	require.NoError(t, err)
+	require.Regexp(t, `(?m)^BenchmarkA\S* +10 `, string(results))
+
+	require.NoError(t, b.writeArtifactsManifest(ctx, &CompareArgs{BaseRef: "main", ArtifactsDir: artifactsDir}, [][]*benchWithKey{{r}}))
+	data, err := os.ReadFile(filepath.Join(artifactsDir, artifactsManifestFile))
+	require.NoError(t, err)
+	var m artifactsManifest
+	require.NoError(t, json.Unmarshal(data, &m))
+	require.Equal(t, artifactsRef{Ref: "main", Commit: "base-sha"}, m.Base)
+	require.Equal(t, artifactsRef{Commit: "head-sha"}, m.Head)
+	require.NotNil(t, m.Environment)
+	require.NotEmpty(t, m.Environment.GoVersion)
+	require.Equal(t, []artifactsBench{{
+		Package: "example.com/m/a",
+		Name:    "BenchmarkA",
+		Reason:  "benchmark does not exist in base",
+		Runs:    []string{"example.com/m/a/BenchmarkA/head-1"},
+	}}, m.Benchmarks)
+}
@@ -0,0 +1,149 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"text/template"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/history"
+	"github.com/grafana/pyrobench/report"
+)
+
+type BaselineArgs struct {
+	*CompareArgs
+
+	Branch   string
+	MaxDrift float64
+}
+
+func AddBaselineCommand(app *kingpin.Application) (*kingpin.CmdClause, *BaselineArgs) {
+	cmd := app.Command("baseline", "Benchmark the tip of the default branch on a schedule, record the results in the history and measure the drift of the machines.")
+	args := &BaselineArgs{
+		CompareArgs: addCompareArgs(cmd),
+	}
+	cmd.Flag("branch", "Revision of the default branch to benchmark.").Default("HEAD").StringVar(&args.Branch)
+	cmd.Flag("max-drift", "Percentage the results of the same commit may move between baseline runs, before a warning is logged.").Default("5").Float64Var(&args.MaxDrift)
+	return cmd, args
+}
+
+// Baseline compares the tip of the branch against the commit benchmarked by
+// the previous baseline run, or against itself on the first run. Measuring
+// that commit again shows how much the machines drifted since, which is
+// printed and taken into account by later comparisons.
+func (b *Benchmark) Baseline(ctx context.Context, args *BaselineArgs) error {
+	if !args.History.Enabled() {
+		return errors.New("baseline requires a history file to record the results in")
+	}
+	store, err := history.NewStore(args.History)
+	if err != nil {
+		return err
+	}
+	records, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+	tip, err := b.gitRevParse(ctx, args.Branch)
+	if err != nil {
+		return err
+	}
+	base := tip
+	if previous := lastBaselineCommit(records); previous != "" {
+		if _, err := b.gitRevParse(ctx, previous); err != nil {
+			level.Warn(b.logger).Log("msg", "commit of the previous baseline run not found, measuring the drift on the tip", "commit", previous, "err", err)
+		} else {
+			base = previous
+		}
+	}
+	level.Info(b.logger).Log("msg", "running baseline", "branch", args.Branch, "commit", tip, "previous", base)
+
+	compareArgs := *args.CompareArgs
+	compareArgs.BaseRef = base
+	compareArgs.HeadRef = tip
+	// the results are recorded as baseline below
+	compareArgs.History = nil
+	filter, err := compareArgs.filters(nil)
+	if err != nil {
+		return err
+	}
+
+	ctx, closeEvents, err := b.openEvents(ctx, compareArgs.Report)
+	if err != nil {
+		return err
+	}
+	defer closeEvents()
+
+	updateCh := make(chan *report.BenchmarkReport)
+	reporter, err := b.newReporter(&compareArgs, updateCh)
+	if err != nil {
+		return err
+	}
+	rpt, err := b.compareWithReporter(ctx, &compareArgs, updateCh, filter...)
+	if stopErr := reporter.Stop(); stopErr != nil {
+		level.Warn(b.logger).Log("msg", "error stopping reporter", "err", stopErr)
+	}
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		// incomplete results would distort the drift
+		return context.Cause(ctx)
+	}
+
+	now := time.Now()
+	newRecords := historyRecords(rpt, now, history.BaselineRef)
+	if err := store.Append(ctx, newRecords...); err != nil {
+		return err
+	}
+
+	var drifts []history.Drift
+	for _, d := range history.Drifts(append(records, newRecords...)) {
+		if !d.Time.Equal(now) {
+			continue
+		}
+		if math.Abs(d.Diff) > args.MaxDrift {
+			level.Warn(b.logger).Log("msg", "machines drifted", "benchmark", d.Benchmark, "resource", d.Resource, "commit", d.Commit, "diff", d.Diff)
+		}
+		drifts = append(drifts, d)
+	}
+	return writeDrifts(b.output, drifts)
+}
+
+// lastBaselineCommit returns the newest commit recorded by a baseline run.
+func lastBaselineCommit(records []history.Record) string {
+	var last *history.Record
+	for i := range records {
+		r := &records[i]
+		if r.Ref != history.BaselineRef {
+			continue
+		}
+		if last == nil || !r.Time.Before(last.Time) {
+			last = r
+		}
+	}
+	if last == nil {
+		return ""
+	}
+	return last.Commit
+}
+
+var driftTemplate = template.Must(template.New("drift").Funcs(digestFuncs).Parse(`## Machine drift
+{{ if . }}
+| Benchmark | Resource | Commit | Previous | Current | Diff % |
+|-----------|----------|--------|---------:|--------:|-------:|
+{{- range . }}
+| ` + "`{{.Benchmark}}`" + ` | {{.Resource}} | ` + "`{{short .Commit}}`" + ` | {{value .Previous .Unit}} | {{value .Current .Unit}} | {{percent .Diff}} |
+{{- end }}
+{{ else }}
+No commit has been measured by an earlier baseline run yet.
+{{ end -}}
+`))
+
+func writeDrifts(w io.Writer, drifts []history.Drift) error {
+	return driftTemplate.Execute(w, drifts)
+}
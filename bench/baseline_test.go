@@ -0,0 +1,75 @@
+package bench
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/history"
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestLastBaselineCommit(t *testing.T) {
+	now := time.Date(2024, 8, 20, 2, 0, 0, 0, time.UTC)
+	require.Empty(t, lastBaselineCommit(nil))
+	require.Equal(t, "c2", lastBaselineCommit([]history.Record{
+		{Time: now.Add(-48 * time.Hour), Commit: "c1", Ref: history.BaselineRef},
+		{Time: now.Add(-24 * time.Hour), Commit: "c1", Ref: history.BaselineRef},
+		{Time: now.Add(-24 * time.Hour), Commit: "c2", Ref: history.BaselineRef},
+		// pull requests are no baseline runs
+		{Time: now, Commit: "pr"},
+	}))
+}
+
+func TestHistoryRecords(t *testing.T) {
+	now := time.Date(2024, 8, 20, 2, 0, 0, 0, time.UTC)
+	records := historyRecords(&report.BenchmarkReport{
+		BaseRef: "c1",
+		HeadRef: "c2",
+		Runs: []report.BenchmarkRun{{
+			Name: "pkg.BenchmarkA",
+			Results: []report.BenchmarkResult{
+				{
+					Name:      "cpu",
+					Unit:      "ns",
+					BaseValue: report.BenchmarkValue{ProfileValue: 100, FlamegraphKey: "a"},
+					HeadValue: report.BenchmarkValue{ProfileValue: 110, FlamegraphKey: "b"},
+				},
+				// not measured by head
+				{
+					Name:      "alloc_space",
+					Unit:      "bytes",
+					BaseValue: report.BenchmarkValue{ProfileValue: 10, FlamegraphKey: "c"},
+				},
+			},
+		}},
+	}, now, history.BaselineRef)
+	require.Equal(t, []history.Record{
+		{Time: now, Commit: "c1", Ref: history.BaselineRef, Benchmark: "pkg.BenchmarkA", Resource: "cpu", Unit: "ns", Value: 100},
+		{Time: now, Commit: "c2", Ref: history.BaselineRef, Benchmark: "pkg.BenchmarkA", Resource: "cpu", Unit: "ns", Value: 110},
+		{Time: now, Commit: "c1", Ref: history.BaselineRef, Benchmark: "pkg.BenchmarkA", Resource: "alloc_space", Unit: "bytes", Value: 10},
+	}, records)
+}
+
+func TestWriteDrifts(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeDrifts(&buf, nil))
+	require.Equal(t, "## Machine drift\n\nNo commit has been measured by an earlier baseline run yet.\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeDrifts(&buf, []history.Drift{{
+		Benchmark: "pkg.BenchmarkA",
+		Resource:  "cpu",
+		Unit:      "ns",
+		Commit:    "0123456789abcdef",
+		Previous:  2000000,
+		Current:   2100000,
+		Diff:      5,
+	}}))
+	require.Equal(t, "## Machine drift\n\n"+
+		"| Benchmark | Resource | Commit | Previous | Current | Diff % |\n"+
+		"|-----------|----------|--------|---------:|--------:|-------:|\n"+
+		"| `pkg.BenchmarkA` | cpu | `0123456789ab` | 2 ms | 2.1 ms | +5 % |\n", buf.String())
+}
@@ -5,11 +5,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -25,6 +30,17 @@ type contextKey uint8
 
 const (
 	contextKeyCleanup contextKey = iota
+	contextKeyProgress
+	contextKeyMetricExtractors
+	contextKeyUploader
+	contextKeyExecutor
+	contextKeyPyroscope
+	contextKeyServiceMetrics
+	contextKeyBinaryCache
+	contextKeyUploadPool
+	contextKeyGoEnv
+	contextKeyEnviron
+	contextKeyServices
 )
 
 type cleaner struct {
@@ -64,8 +80,28 @@ func cleanupFromContext(ctx context.Context) cleanupHookFunc {
 	return cleanup
 }
 
+func addProgressToContext(ctx context.Context, p report.Progress) context.Context {
+	return context.WithValue(ctx, contextKeyProgress, p)
+}
+
+func progressFromContext(ctx context.Context) report.Progress {
+	p, ok := ctx.Value(contextKeyProgress).(report.Progress)
+	if !ok {
+		return report.NewNoopProgress()
+	}
+	return p
+}
+
 type Benchmark struct {
-	logger log.Logger
+	logger   log.Logger
+	progress report.Progress
+	output   io.Writer
+	quiet    bool
+
+	// dir is the working directory of the repository, the one of the
+	// process when empty. The server sets it per job, instead of changing
+	// the working directory of the long-lived process.
+	dir string
 
 	baseDir      string
 	baseCommit   string
@@ -74,7 +110,20 @@ type Benchmark struct {
 	headCommit   string
 	headPackages []Package
 
+	criticalFunctions map[string]struct{} // symbol names of functions marked as critical
+	metricExtractors  []MetricExtractor
+	sampleTypes       []string // of the profiles to report, all when empty
+
+	environment   *report.Environment // recorded by the preflight checks
+	runEnv        []string            // normalized environment of the test binaries, nil to inherit it
+	throttleCount uint64              // thermal throttling events at the preflight checks
+	toolchains    toolchains          // Go toolchains of base and head
+	request       *report.Request     // authorized request of a comment, recorded in the reports
+
 	statBuilders map[string]*StatBuilder
+
+	codeSize []report.PackageCodeSize // of the packages whose test binaries changed
+	builds   []report.PackageBuild    // of the packages whose test binaries changed
 }
 
 type BenchmarkResult struct {
@@ -83,14 +132,52 @@ type BenchmarkResult struct {
 	Benchmark *benchmarkResult `json:"benchmark"`
 }
 
-func New(logger log.Logger) (*Benchmark, error) {
+type Option func(*Benchmark)
+
+// WithProgress reports the progress of compiling, running and uploading.
+func WithProgress(p report.Progress) Option {
+	return func(b *Benchmark) {
+		b.progress = p
+	}
+}
+
+// WithOutput sets where the results get printed to. When quiet is set only a
+// final summary is printed.
+func WithOutput(w io.Writer, quiet bool) Option {
+	return func(b *Benchmark) {
+		b.output = w
+		b.quiet = quiet
+	}
+}
+
+func New(logger log.Logger, opts ...Option) (*Benchmark, error) {
 	b := &Benchmark{
 		logger:       logger,
+		progress:     report.NewNoopProgress(),
+		output:       os.Stdout,
 		statBuilders: make(map[string]*StatBuilder),
 	}
+	for _, o := range opts {
+		o(b)
+	}
 	return b, nil
 }
 
+// fresh returns a Benchmark with the same options, but without the state of
+// an earlier comparison. Progress is not reported, as it is meant for
+// repeated comparisons in the background.
+func (b *Benchmark) fresh() *Benchmark {
+	return &Benchmark{
+		logger:           b.logger,
+		progress:         report.NewNoopProgress(),
+		output:           b.output,
+		quiet:            b.quiet,
+		dir:              b.dir,
+		metricExtractors: b.metricExtractors,
+		statBuilders:     make(map[string]*StatBuilder),
+	}
+}
+
 func (b *Benchmark) prerequisites(_ context.Context) error {
 	return errors.Join(
 		func() error {
@@ -104,8 +191,25 @@ func (b *Benchmark) prerequisites(_ context.Context) error {
 	)
 }
 
+// workDir returns the absolute path of the working directory of the
+// repository.
+func (b *Benchmark) workDir() (string, error) {
+	if b.dir != "" {
+		return filepath.Abs(b.dir)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("error getting working directory: %w", err)
+	}
+	return filepath.Abs(dir)
+}
+
+// gitRevParse resolves a commit, branch or tag to the hash of its commit.
 func (b *Benchmark) gitRevParse(ctx context.Context, rev string) (string, error) {
-	c, err := exec.CommandContext(ctx, "git", "rev-parse", rev).Output()
+	// peel annotated tags to the commit they point to
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "--end-of-options", rev+"^{commit}")
+	cmd.Dir = b.dir
+	c, err := cmd.Output()
 	if err != nil {
 		return "", err
 	}
@@ -124,28 +228,55 @@ func (b *Benchmark) addBenchStatResults(res *benchmarkResult, src benchSource) {
 		b.statBuilders[res.Name] = builder
 	}
 
-	if builder.Units == nil {
-		builder.Units = res.Units
+	builder.add(b.logger, res, src)
+}
+
+// add adds the results of a run of the source to the tables.
+func (sb *StatBuilder) add(logger log.Logger, res *benchmarkResult, src benchSource) {
+	// Merge the unit metadata, so units only reported by one side keep
+	// their declared properties.
+	if sb.Units == nil {
+		sb.Units = make(benchfmt.UnitMetadataMap, len(res.Units))
+	}
+	for k, v := range res.Units {
+		if _, ok := sb.Units[k]; !ok {
+			sb.Units[k] = v
+		}
 	}
 
 	for _, r := range res.RawResult {
-		ok, err := builder.Filter.Apply(r)
+		ok, err := sb.Filter.Apply(r)
 		if !ok && err != nil {
 			// Non-fatal error, let's just skip this result.
-			level.Error(b.logger).Log("msg", "error applying filter", "err", err)
+			level.Error(logger).Log("msg", "error applying filter", "err", err)
 			continue
 		}
 
 		r.SetConfig("source", src.String())
-		builder.Stats.Add(r)
+		sb.Stats.Add(r)
 	}
 }
 
 func git(args ...string) ([]byte, error) {
+	return gitWithEnv(nil, args...)
+}
+
+// gitWithEnv runs git with additional environment variables.
+func gitWithEnv(env []string, args ...string) ([]byte, error) {
+	return gitIn("", env, args...)
+}
+
+// gitIn runs git in dir with additional environment variables, in the
+// working directory when dir is empty.
+func gitIn(dir string, env []string, args ...string) ([]byte, error) {
 	bufOut := new(bytes.Buffer)
 	bufErr := new(bytes.Buffer)
 	cmd := append([]string{"git"}, args...)
 	c := exec.Command(cmd[0], cmd[1:]...)
+	c.Dir = dir
+	if len(env) > 0 {
+		c.Env = append(os.Environ(), env...)
+	}
 	c.Stdout = bufOut
 	c.Stderr = bufErr
 
@@ -156,25 +287,47 @@ func git(args ...string) ([]byte, error) {
 	return bufOut.Bytes(), nil
 }
 
-func (b *Benchmark) gitCheckoutBase(ctx context.Context) error {
-	dir, err := os.MkdirTemp("", "pyrobench-base")
+// gitWorktree checks out the commit into a new temporary worktree and
+// returns its directory, including its submodules and LFS objects. Unless
+// checkURLs is nil, it has to accept the worktree before they are fetched.
+// The worktree is removed on cleanup.
+func (b *Benchmark) gitWorktree(ctx context.Context, prefix, commit string, checkURLs func(dir string) error) (string, error) {
+	dir, err := tempDir(prefix)
 	if err != nil {
-		return err
+		return "", err
 	}
-	b.baseDir = dir
 
-	err = exec.CommandContext(ctx, "git", "worktree", "add", b.baseDir, b.baseCommit).Run()
+	add := exec.CommandContext(ctx, "git", "worktree", "add", "--detach", dir, commit)
+	add.Dir = b.dir
+	err = add.Run()
 	if err != nil {
-		return err
+		return "", err
 	}
+	var submodules bool
 	cleanupFromContext(ctx)(func() error {
-		err := exec.Command("git", "worktree", "remove", b.baseDir).Run()
+		args := []string{"worktree", "remove", dir}
+		if submodules {
+			// git refuses to remove worktrees with submodules otherwise
+			args = append(args, "--force")
+		}
+		remove := exec.Command("git", args...)
+		remove.Dir = b.dir
+		err := remove.Run()
 		if err != nil {
 			return fmt.Errorf("failed to cleanup git workdir: %w", err)
 		}
 		return nil
 	})
-	return nil
+	if checkURLs != nil {
+		if err := checkURLs(dir); err != nil {
+			return "", err
+		}
+	}
+	submodules, err = b.initWorktree(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	return dir, nil
 }
 
 func countPackagesWithTests(packages []Package) int {
@@ -197,13 +350,103 @@ type benchWithKey struct {
 	key benchKey
 }
 
+// roundKey identifies the runs of the rounds of an adaptive benchmark, whose
+// profiles get merged.
+type roundKey struct {
+	src        benchSource
+	gomaxprocs int
+}
+
 type bench struct {
-	base   *Package
-	head   *Package
-	reason string
+	base     *Package
+	head     *Package
+	reason   string
+	timedOut bool
+
+	// latest results, used to diff the profiles. With adaptive counts they
+	// hold the profiles of all rounds.
+	baseResult *benchmarkResult
+	headResult *benchmarkResult
+	rounds     map[roundKey]*benchmarkResult // results of all rounds so far, merged by mergeRounds
+
+	tables      *benchtab.Tables
+	preliminary bool // tables are the ones of the quick estimate
+	results     []report.BenchmarkResult
+	samples     []report.Sample
+	cpu         []report.CPUUsage
+	testMain    []report.TestMainTiming
+	resources   []report.ResourceUsage
+	warnings    []string
+	traces      []report.Trace
+	hotspots    []report.FunctionDelta
+	hotLines    []report.HotLine
+	artifacts   []string // directories of the runs in the artifacts directory
+
+	sampleTypes map[string]struct{} // names of the results derived from sample types
+
+	pendingUploads []pendingUpload // runs, whose profile results are added once uploaded
+
+	cpuSampling map[benchSource]*cpuSampling   // of the latest run per source
+	goroutines  map[benchSource]*goroutineLeak // of the latest run per source
+	leftovers   map[benchSource][]string       // left behind by the first run per source leaving any
+
+	running  bool
+	finished bool
+	skipped  bool // not run, as the total time budget has been exhausted
+	started  time.Time
+	elapsed  time.Duration // of all runs, once finished
+	estimate time.Duration // expected duration of all runs, 0 if unknown
+}
 
-	tables  *benchtab.Tables
-	results []report.BenchmarkResult
+// setCPUUsage records the CPU usage of the latest run of the source.
+func (b *bench) setCPUUsage(source benchSource, u *cpuUsage) {
+	r := u.report(source)
+	for idx := range b.cpu {
+		if b.cpu[idx].Source == r.Source {
+			b.cpu[idx] = r
+			return
+		}
+	}
+	b.cpu = append(b.cpu, r)
+	sort.Slice(b.cpu, func(i, j int) bool {
+		return b.cpu[i].Source < b.cpu[j].Source
+	})
+}
+
+// runWarnings returns the warnings of the benchmark, including the ones
+// derived from its CPU usage.
+func (b *bench) runWarnings() []string {
+	warnings := slices.Clone(b.warnings)
+	var base, head *report.CPUUsage
+	for idx := range b.cpu {
+		switch b.cpu[idx].Source {
+		case benchSourceBase.String():
+			base = &b.cpu[idx]
+		case benchSourceHead.String():
+			head = &b.cpu[idx]
+		}
+	}
+	if w := cpuFrequencyWarning(base, head); w != "" {
+		warnings = append(warnings, w)
+	}
+
+	// the side with fewer samples limits the comparison
+	var sparse *cpuSampling
+	var sparseSource benchSource
+	for _, src := range []benchSource{benchSourceBase, benchSourceHead} {
+		if s, ok := b.cpuSampling[src]; ok && (sparse == nil || s.samples < sparse.samples) {
+			sparse, sparseSource = s, src
+		}
+	}
+	if w := cpuSamplingWarning(sparseSource, sparse); w != "" {
+		warnings = append(warnings, w)
+	}
+	for _, src := range []benchSource{benchSourceBase, benchSourceHead} {
+		if w := leftoversWarning(src, b.leftovers[src]); w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings
 }
 
 type benchSource uint8
@@ -212,6 +455,7 @@ const (
 	benchSourceUnknown benchSource = iota
 	benchSourceHead
 	benchSourceBase
+	benchSourcePGO // head compiled with the CPU profile of base
 )
 
 func (b benchSource) String() string {
@@ -220,25 +464,52 @@ func (b benchSource) String() string {
 		return "base"
 	case benchSourceHead:
 		return "head"
+	case benchSourcePGO:
+		return "pgo"
 	default:
 		return "unknown"
 	}
 }
 
 func (b *bench) addResult(source benchSource, res *benchmarkResult) {
-	m := map[string]struct {
-		unit string
-		res  *profileResult
-	}{
-		"cpu":           {"ns", &res.CPU},
-		"alloc_space":   {"bytes", &res.AllocSpace},
-		"alloc_objects": {"", &res.AllocObjects},
+	// results of different GOMAXPROCS are kept in separate rows
+	gomaxprocs := res.GOMAXPROCS
+	type resource struct {
+		unit  string
+		res   *profileResult
+		perOp bool // accumulated over the iterations, unlike the heap retained after them
+	}
+	m := map[string]resource{
+		"cpu":           {"ns", &res.CPU, true},
+		"alloc_space":   {"bytes", &res.AllocSpace, true},
+		"alloc_objects": {"", &res.AllocObjects, true},
+		"inuse_space":   {"bytes", &res.InuseSpace, false},
+		"inuse_objects": {"", &res.InuseObjects, false},
+	}
+	for _, c := range res.Custom {
+		m[c.Type] = resource{sampleUnit(c.Unit), &c.profileResult, true}
+	}
+	if b.sampleTypes == nil {
+		b.sampleTypes = make(map[string]struct{}, len(m))
+	}
+	for name := range m {
+		b.sampleTypes[name] = struct{}{}
 	}
 
-	addValue := func(xres *report.BenchmarkResult, xprof *profileResult) {
+	// base and head run a different number of iterations with a time based
+	// -benchtime, so their totals are only comparable per iteration
+	iterations := res.iterations()
+	addValue := func(xres *report.BenchmarkResult, xprof *profileResult, perOp bool) {
+		value := xprof.Total
+		if perOp && iterations > 0 {
+			value = int64(math.Round(float64(value) / float64(iterations)))
+		}
 		v := report.BenchmarkValue{
-			ProfileValue:  xprof.Total,
-			FlamegraphKey: xprof.Key,
+			ProfileValue:     value,
+			FlamegraphKey:    xprof.Key,
+			ExploreURL:       xprof.ExploreURL,
+			DownsampleFactor: xprof.DownsampleFactor,
+			Flamegraph:       flamegraphTree(xprof.profile),
 		}
 		if source == benchSourceBase {
 			xres.BaseValue = v
@@ -252,6 +523,9 @@ func (b *bench) addResult(source benchSource, res *benchmarkResult) {
 
 	for idx := range b.results {
 		res := &b.results[idx]
+		if res.GOMAXPROCS != gomaxprocs {
+			continue
+		}
 		prof, ok := m[res.Name]
 		if !ok {
 			continue
@@ -261,7 +535,7 @@ func (b *bench) addResult(source benchSource, res *benchmarkResult) {
 			continue
 		}
 
-		addValue(res, prof.res)
+		addValue(res, prof.res, prof.perOp)
 
 		delete(m, res.Name)
 		// if not empty
@@ -270,18 +544,56 @@ func (b *bench) addResult(source benchSource, res *benchmarkResult) {
 
 	for name, prof := range m {
 		res := report.BenchmarkResult{
-			Name: name,
-			Unit: prof.unit,
+			Name:       name,
+			Unit:       prof.unit,
+			GOMAXPROCS: gomaxprocs,
 		}
-		addValue(&res, prof.res)
+		addValue(&res, prof.res, prof.perOp)
 		b.results = append(b.results, res)
 	}
 
-	sort.Slice(b.results, func(i, j int) bool {
-		return b.results[i].Name == b.results[j].Name
+	// custom metrics
+	for _, metric := range res.Metrics {
+		prof := &profileResult{Key: metric.Key, Total: metric.Value}
+		idx := slices.IndexFunc(b.results, func(r report.BenchmarkResult) bool {
+			return r.Name == metric.Name && r.GOMAXPROCS == gomaxprocs
+		})
+		if idx < 0 {
+			b.results = append(b.results, report.BenchmarkResult{
+				Name:       metric.Name,
+				Unit:       metric.Unit,
+				GOMAXPROCS: gomaxprocs,
+			})
+			idx = len(b.results) - 1
+		}
+		addValue(&b.results[idx], prof, metric.PerOp)
+	}
+
+	sort.SliceStable(b.results, func(i, j int) bool {
+		return b.results[i].GOMAXPROCS < b.results[j].GOMAXPROCS
 	})
 }
 
+// addSamples records every measurement of the benchmark result.
+func (b *bench) addSamples(source benchSource, res *benchmarkResult) {
+	for _, r := range res.RawResult {
+		config := make(map[string]string, len(r.Config))
+		for _, c := range r.Config {
+			config[c.Key] = string(c.Value)
+		}
+		for _, v := range r.Values {
+			b.samples = append(b.samples, report.Sample{
+				Source:     source.String(),
+				Unit:       v.Unit,
+				Value:      v.Value,
+				Iterations: r.Iters,
+				Config:     config,
+				Time:       res.Finished,
+			})
+		}
+	}
+}
+
 type benchMap struct {
 	m       map[benchKey]int
 	results []bench
@@ -304,91 +616,128 @@ func (r *benchMap) get(k benchKey) *bench {
 	return &r.results[idx]
 }
 
-// note(bryan): Only pass tables on the last call to generateReport.
 func (b *Benchmark) generateReport(benchmarkGroups [][]*benchWithKey) *report.BenchmarkReport {
 	rpt := &report.BenchmarkReport{
-		BaseRef: b.baseCommit,
-		HeadRef: b.headCommit,
+		BaseRef:     b.baseCommit,
+		HeadRef:     b.headCommit,
+		Environment: b.environment,
+		CodeSize:    b.codeSize,
+		Build:       b.builds,
+		Request:     b.request,
 	}
+	if rpt.Environment == nil {
+		// the platform is recorded without the preflight checks as well
+		rpt.Environment = platformEnvironment()
+	}
+	rpt.Environment = b.reportEnvironment(rpt.Environment)
 
 	for _, results := range benchmarkGroups {
 		for _, res := range results {
-			for i, r := range res.bench.results {
-
-				switch r.Unit {
-				case "ns":
-					const unit = "sec/op"
-					table := getTableForUnit(res.tables, unit)
-					if table == nil {
-						continue
-					}
-
-					if !strings.HasSuffix(res.bench.results[i].Name, ")") {
-						res.bench.results[i].Name = fmt.Sprintf("%s (%s)", r.Name, unit)
-					}
-					for _, col := range table.Cols {
-						switch col.String() {
-						case "source:base":
-							res.bench.results[i].BaseValue.ProfileValue = int64(table.Summary[col].Summary * 1e9)
-						case "source:head":
-							res.bench.results[i].HeadValue.ProfileValue = int64(table.Summary[col].Summary * 1e9)
-						}
-					}
-
-					// TODO(bryan): Add diff value to report.BenchmarkResult do
-					// use benchstat's diff value instead of calculating our
-					// own.
-				case "bytes":
-					const unit = "B/op"
-					table := getTableForUnit(res.tables, "B/op")
-					if table == nil {
-						continue
-					}
-
-					if !strings.HasSuffix(res.bench.results[i].Name, ")") {
-						res.bench.results[i].Name = fmt.Sprintf("%s (%s)", r.Name, unit)
-					}
-					for _, col := range table.Cols {
-						switch col.String() {
-						case "source:base":
-							res.bench.results[i].BaseValue.ProfileValue = int64(table.Summary[col].Summary)
-						case "source:head":
-							res.bench.results[i].HeadValue.ProfileValue = int64(table.Summary[col].Summary)
-						}
-					}
-				case "": // allocs
-					const unit = "allocs/op"
-					table := getTableForUnit(res.tables, unit)
-					if table == nil {
-						continue
-					}
-
-					if !strings.HasSuffix(res.bench.results[i].Name, ")") {
-						res.bench.results[i].Name = fmt.Sprintf("%s (%s)", r.Name, unit)
-					}
-					for _, col := range table.Cols {
-						switch col.String() {
-						case "source:base":
-							res.bench.results[i].BaseValue.ProfileValue = int64(table.Summary[col].Summary)
-						case "source:head":
-							res.bench.results[i].HeadValue.ProfileValue = int64(table.Summary[col].Summary)
-						}
-					}
-				}
-			}
-
-			rpt.Runs = append(rpt.Runs, report.BenchmarkRun{
+			run := report.BenchmarkRun{
 				Name:            fmt.Sprintf("%s.%s", res.key.packagePath, res.key.benchmark),
+				Module:          res.modulePath(),
 				Reason:          res.bench.reason,
-				Results:         res.bench.results,
+				TimedOut:        res.bench.timedOut,
+				Running:         res.bench.running,
+				Skipped:         res.bench.skipped,
+				Preliminary:     res.bench.preliminary,
+				Removed:         res.bench.base != nil && res.bench.head == nil,
+				Results:         b.reportedResults(res.bench),
 				BenchStatTables: res.tables,
-			})
+				Metrics:         benchmarkMetrics(res.tables),
+				PGODelta:        pgoDelta(res.tables),
+				Samples:         res.bench.samples,
+				CPU:             res.bench.cpu,
+				TestMain:        res.bench.testMain,
+				Resources:       res.bench.resources,
+				Warnings:        res.bench.runWarnings(),
+				GoroutineLeak:   res.bench.goroutineLeak(),
+				Traces:          res.bench.traces,
+				Hotspots:        res.bench.hotspots,
+				HotLines:        res.bench.hotLines,
+			}
+			run.File, run.Line = b.benchmarkLocation(res)
+			rpt.Runs = append(rpt.Runs, run)
 		}
 	}
+	if len(rpt.Runs) > 0 {
+		rpt.Runs = append(rpt.Runs, b.constrainedRuns()...)
+	}
+	if len(rpt.Runs) > 0 {
+		rpt.Progress = runProgress(benchmarkGroups, time.Now())
+	}
 	return rpt
 }
 
+// modulePath returns the module of the benchmark's package, preferring head.
+func (b *bench) modulePath() string {
+	for _, p := range []*Package{b.head, b.base} {
+		if p != nil {
+			return p.modulePath()
+		}
+	}
+	return ""
+}
+
+// benchmarkLocation returns the file relative to the repository root and
+// line of the benchmark function, preferring the head's location.
+func (b *Benchmark) benchmarkLocation(res *benchWithKey) (string, int) {
+	for _, x := range []struct {
+		p   *Package
+		dir string
+	}{
+		{res.head, b.headDir},
+		{res.base, b.baseDir},
+	} {
+		if x.p == nil {
+			continue
+		}
+		pos := x.p.benchmarkPosition(res.key.benchmark)
+		if pos == nil {
+			continue
+		}
+		file, err := filepath.Rel(x.dir, pos.Filename)
+		if err != nil {
+			continue
+		}
+		return filepath.ToSlash(file), pos.Line
+	}
+	return "", 0
+}
+
+// reasonUnchanged is the reason of benchmarks, which are skipped as their test
+// binaries did not change.
+const reasonUnchanged = "unchanged test binary"
+
+// reasonConstrained is the reason of benchmarks, which are skipped as build
+// constraints exclude their test files.
+const reasonConstrained = "build constraints"
+
+// The reasons of benchmarks, which are run.
+const (
+	reasonNew     = "benchmark does not exist in base"
+	reasonRemoved = "benchmark does not exist in head"
+	reasonChanged = "code changed"
+)
+
+// compareResult returns the benchmarks to be run.
 func (b *Benchmark) compareResult() []*benchWithKey {
+	all := b.plannedBenchmarks()
+	benchmarkToBeRun := make([]*benchWithKey, 0, len(all))
+	for _, x := range all {
+		if x.reason != reasonUnchanged {
+			benchmarkToBeRun = append(benchmarkToBeRun, x)
+		}
+	}
+	if len(benchmarkToBeRun) == 0 {
+		return nil
+	}
+	return benchmarkToBeRun
+}
+
+// plannedBenchmarks returns all benchmarks of base and head along with the
+// reason why they are run or skipped.
+func (b *Benchmark) plannedBenchmarks() []*benchWithKey {
 	r := newBenchMap(len(b.headPackages))
 
 	resultFromPackages(func(k benchKey, p *Package) {
@@ -409,30 +758,28 @@ func (b *Benchmark) compareResult() []*benchWithKey {
 		keys[v] = k
 	}
 
-	benchmarkToBeRun := make([]*benchWithKey, 0, len(r.results))
+	benchmarks := make([]*benchWithKey, 0, len(r.results))
 	for idx := range r.results {
 		res := &r.results[idx]
 		k := keys[idx]
 
-		if res.base != nil && res.head != nil && len(res.base.testBinaryHash) > 0 && len(res.head.testBinaryHash) > 0 {
-			// compare hash
-			if bytes.Equal(res.base.testBinaryHash, res.head.testBinaryHash) {
-				continue
-			}
-		}
-
 		if res.base == nil {
-			res.reason = "benchmark does not exist in base"
+			res.reason = reasonNew
 		} else if res.head == nil {
-			res.reason = "benchmark does not exist in head"
+			res.reason = reasonRemoved
 		} else if len(res.base.testBinaryHash) > 0 && len(res.head.testBinaryHash) > 0 {
-			res.reason = "code changed"
+			// compare hash
+			if bytes.Equal(res.base.testBinaryHash, res.head.testBinaryHash) {
+				res.reason = reasonUnchanged
+			} else {
+				res.reason = reasonChanged
+			}
 		} else {
 			res.reason = "tbd"
 		}
 
-		benchmarkToBeRun = append(
-			benchmarkToBeRun,
+		benchmarks = append(
+			benchmarks,
 			&benchWithKey{
 				key:   k,
 				bench: res,
@@ -440,16 +787,7 @@ func (b *Benchmark) compareResult() []*benchWithKey {
 		)
 	}
 
-	return benchmarkToBeRun
-}
-
-func getTableForUnit(tables *benchtab.Tables, unit string) *benchtab.Table {
-	for _, table := range tables.Tables {
-		if table.Unit == unit {
-			return table
-		}
-	}
-	return nil
+	return benchmarks
 }
 
 func resultFromPackages(f func(benchKey, *Package), pkgs []Package) {
@@ -461,6 +799,49 @@ func resultFromPackages(f func(benchKey, *Package), pkgs []Package) {
 	}
 }
 
+// benchmarkMetrics summarizes the values reported by the benchmark per unit.
+func benchmarkMetrics(tables *benchtab.Tables) []report.BenchmarkMetric {
+	if tables == nil {
+		return nil
+	}
+
+	var metrics []report.BenchmarkMetric
+	for _, t := range tables.Tables {
+		var baseCol, headCol benchproc.Key
+		var hasBaseCol, hasHeadCol bool
+		for _, col := range t.Cols {
+			switch col.String() {
+			case "source:base":
+				baseCol, hasBaseCol = col, true
+			case "source:head":
+				headCol, hasHeadCol = col, true
+			}
+		}
+
+		for _, row := range t.Rows {
+			m := report.BenchmarkMetric{Unit: t.Unit, Better: t.Opts.Units.GetBetter(t.Unit)}
+			var base, head *benchtab.TableCell
+			if hasBaseCol {
+				base = t.Cells[benchtab.TableKey{Row: row, Col: baseCol}]
+			}
+			if hasHeadCol {
+				head = t.Cells[benchtab.TableKey{Row: row, Col: headCol}]
+			}
+			if base != nil {
+				m.Base, m.HasBase = base.Summary.Center, true
+			}
+			if head != nil {
+				m.Head, m.HasHead = head.Summary.Center, true
+			}
+			if base != nil && head != nil && head.Baseline == base {
+				m.Delta = head.Comparison.FormatDelta(base.Summary.Center, head.Summary.Center)
+			}
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
 type StatBuilder struct {
 	Stats  *benchtab.Builder
 	Filter *benchproc.Filter
@@ -3,8 +3,12 @@ package bench
 import (
 	"bytes"
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -36,10 +40,17 @@ func run(t testing.TB, cmd []string) {
 	}
 }
 
-// TODO: This should locally mock flamegraph.com
 func TestBenchmarkE2E(t *testing.T) {
 	ctx := context.Background()
 
+	// mock flamegraph.com
+	var uploads atomic.Int64
+	flamegraph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := fmt.Sprintf("key-%d", uploads.Add(1))
+		fmt.Fprintf(w, `{"key":%q,"url":"https://flamegraph.com/share/%s"}`, key, key)
+	}))
+	defer flamegraph.Close()
+
 	logger := log.NewLogfmtLogger(os.Stderr)
 
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
@@ -74,12 +85,14 @@ func TestBenchmarkE2E(t *testing.T) {
 				Token:   os.Getenv("GITHUB_TOKEN"),
 				Context: os.Getenv("GITHUB_CONTEXT"),
 			},
-			GitBase:    "HEAD~1",
-			BenchTime:  "200ms",
-			BenchCount: 6,
+			BaseRef:       "HEAD~1",
+			FlamegraphURL: flamegraph.URL,
+			BenchTime:     "200ms",
+			BenchCount:    6,
 			Report: &report.Args{
 				GitHubCommenter: os.Getenv("PYROBENCH_GITHUB_REPORT") == "true",
 			},
 		}))
+		require.Positive(t, uploads.Load(), "profiles are uploaded to the mock")
 	}
 }
@@ -0,0 +1,273 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/perf/benchfmt"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func parseBenchmarkResult(t *testing.T, name, output string) *benchmarkResult {
+	t.Helper()
+	res := &benchmarkResult{Name: name}
+	reader := benchfmt.NewReader(strings.NewReader(output), "")
+	for reader.Scan() {
+		r, ok := reader.Result().(*benchfmt.Result)
+		if !ok {
+			continue
+		}
+		r = r.Clone()
+		r.SetConfig("name", name)
+		res.RawResult = append(res.RawResult, r)
+	}
+	require.NoError(t, reader.Err())
+	res.Units = reader.Units()
+	return res
+}
+
+func TestBenchmarkMetrics(t *testing.T) {
+	b, err := New(nil)
+	require.NoError(t, err)
+
+	base := parseBenchmarkResult(t, "BenchmarkA", `goos: linux
+BenchmarkA-8   	 100	     1000 ns/op	     64 B/op	       2 allocs/op
+BenchmarkA-8   	 100	     1010 ns/op	     64 B/op	       2 allocs/op
+BenchmarkA-8   	 100	      990 ns/op	     64 B/op	       2 allocs/op
+BenchmarkA-8   	 100	     1005 ns/op	     64 B/op	       2 allocs/op
+BenchmarkA-8   	 100	      995 ns/op	     64 B/op	       2 allocs/op
+BenchmarkA-8   	 100	     1000 ns/op	     64 B/op	       2 allocs/op
+`)
+	head := parseBenchmarkResult(t, "BenchmarkA", `goos: linux
+Unit rows/op better=higher
+BenchmarkA-8   	 100	     2000 ns/op	     64 B/op	       2 allocs/op	   500.0 rows/op
+BenchmarkA-8   	 100	     2020 ns/op	     64 B/op	       2 allocs/op	   510.0 rows/op
+BenchmarkA-8   	 100	     1980 ns/op	     64 B/op	       2 allocs/op	   490.0 rows/op
+BenchmarkA-8   	 100	     2010 ns/op	     64 B/op	       2 allocs/op	   505.0 rows/op
+BenchmarkA-8   	 100	     1990 ns/op	     64 B/op	       2 allocs/op	   495.0 rows/op
+BenchmarkA-8   	 100	     2000 ns/op	     64 B/op	       2 allocs/op	   500.0 rows/op
+`)
+	b.addBenchStatResults(base, benchSourceBase)
+	b.addBenchStatResults(head, benchSourceHead)
+
+	metrics := benchmarkMetrics(b.statBuilders["BenchmarkA"].ToTables())
+	byUnit := make(map[string]report.BenchmarkMetric)
+	for _, m := range metrics {
+		byUnit[m.Unit] = m
+	}
+	require.Len(t, byUnit, 4)
+
+	secPerOp := byUnit["sec/op"]
+	require.True(t, secPerOp.HasBase)
+	require.True(t, secPerOp.HasHead)
+	require.InDelta(t, 1000e-9, secPerOp.Base, 1e-12)
+	require.InDelta(t, 2000e-9, secPerOp.Head, 1e-12)
+	require.Equal(t, "+100.00%", secPerOp.Delta)
+	require.Equal(t, -1, secPerOp.Better)
+	require.True(t, secPerOp.Regressed())
+
+	require.Equal(t, "~", byUnit["B/op"].Delta)
+	require.Equal(t, "~", byUnit["allocs/op"].Delta)
+
+	rows := byUnit["rows/op"]
+	require.False(t, rows.HasBase)
+	require.True(t, rows.HasHead)
+	require.Empty(t, rows.Delta)
+	require.Equal(t, 1, rows.Better)
+}
+
+func TestGitWorktreeOfRefs(t *testing.T) {
+	repo := t.TempDir()
+	runGit(t, repo, "init", "--initial-branch", "main", ".")
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "file"), []byte("v1.2.0"), 0o644))
+	runGit(t, repo, "add", "file")
+	runGit(t, repo, "commit", "-m", "first")
+	runGit(t, repo, "tag", "-a", "v1.2.0", "-m", "release v1.2.0")
+	first := runGit(t, repo, "rev-parse", "HEAD")
+
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "file"), []byte("v1.3.0"), 0o644))
+	runGit(t, repo, "commit", "-am", "second")
+	runGit(t, repo, "branch", "release-1.3")
+	second := runGit(t, repo, "rev-parse", "HEAD")
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(repo))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	b, err := New(nil)
+	require.NoError(t, err)
+
+	for ref, expected := range map[string]string{
+		"v1.2.0":      first,
+		"release-1.3": second,
+		first[:8]:     first,
+	} {
+		commit, err := b.gitRevParse(ctx, ref)
+		require.NoError(t, err, ref)
+		require.Equal(t, expected, commit, ref)
+
+		dir, err := b.gitWorktree(ctx, "pyrobench-test", commit, nil)
+		require.NoError(t, err)
+		require.Equal(t, expected, runGit(t, dir, "rev-parse", "HEAD"))
+	}
+	_, err = b.gitRevParse(ctx, "v9.9.9")
+	require.Error(t, err)
+
+	require.NoError(t, cleaner.cleanup())
+	require.Len(t, strings.Split(runGit(t, repo, "worktree", "list"), "\n"), 1, "worktrees are removed")
+}
+
+func TestAdaptiveCount(t *testing.T) {
+	samples := func(src benchSource, values ...float64) []report.Sample {
+		var s []report.Sample
+		for _, v := range values {
+			s = append(s, report.Sample{Source: src.String(), Unit: "sec/op", Value: v})
+		}
+		return s
+	}
+	base := samples(benchSourceBase, 100, 101, 99, 100, 102, 98)
+
+	for _, tc := range []struct {
+		name     string
+		head     []report.Sample
+		total    uint16
+		expected uint16
+	}{
+		{
+			name:     "clearly regressed",
+			head:     samples(benchSourceHead, 200, 201, 199, 200, 202, 198),
+			total:    6,
+			expected: 0,
+		},
+		{
+			name:     "clearly unchanged",
+			head:     samples(benchSourceHead, 100, 101, 99, 100, 102, 98),
+			total:    6,
+			expected: 0,
+		},
+		{
+			name:     "noisy",
+			head:     samples(benchSourceHead, 80, 130, 95, 120, 90, 110),
+			total:    6,
+			expected: 6,
+		},
+		{
+			name:     "noisy close to max count",
+			head:     samples(benchSourceHead, 80, 130, 95, 120, 90, 110),
+			total:    16,
+			expected: 4,
+		},
+		{
+			name:     "too few samples",
+			head:     samples(benchSourceHead, 200),
+			total:    1,
+			expected: 6,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := &bench{
+				base:    &Package{},
+				head:    &Package{},
+				samples: append(slices.Clone(base), tc.head...),
+			}
+			require.Equal(t, tc.expected, b.nextCount(6, tc.total, 20, 5, 0))
+		})
+	}
+
+	// only benchmarks present on both sides can be compared
+	b := &bench{head: &Package{}, samples: samples(benchSourceHead, 1)}
+	require.Equal(t, uint16(0), b.nextCount(6, 6, 20, 5, 0))
+
+	// with a target width, a clear regression is sampled until it is precise
+	b = &bench{
+		base:    &Package{},
+		head:    &Package{},
+		samples: append(slices.Clone(base), samples(benchSourceHead, 200, 201, 199, 200, 202, 198)...),
+	}
+	require.Equal(t, uint16(6), b.nextCount(6, 6, 20, 5, 1))
+	require.Equal(t, uint16(0), b.nextCount(6, 6, 20, 5, 15))
+	require.NotEmpty(t, b.inconclusiveWarning(6, 5, 1))
+	require.Empty(t, b.inconclusiveWarning(6, 5, 15))
+}
+
+func TestAddResultInuse(t *testing.T) {
+	var b bench
+	b.addResult(benchSourceBase, &benchmarkResult{
+		AllocSpace: profileResult{Key: "alloc-base", Total: 1000},
+		InuseSpace: profileResult{Key: "inuse-base", Total: 100},
+	})
+	b.addResult(benchSourceHead, &benchmarkResult{
+		AllocSpace: profileResult{Key: "alloc-head", Total: 1000},
+		InuseSpace: profileResult{Key: "inuse-head", Total: 200},
+	})
+
+	results := make(map[string]report.BenchmarkResult)
+	for _, r := range b.results {
+		results[r.Name] = r
+	}
+
+	// allocation churn and retained heap are separate rows
+	alloc := results["alloc_space"]
+	diff, ok := alloc.Diff()
+	require.True(t, ok)
+	require.Equal(t, 0.0, diff)
+	inuse := results["inuse_space"]
+	require.Equal(t, "bytes", inuse.Unit)
+	diff, ok = inuse.Diff()
+	require.True(t, ok)
+	require.Equal(t, 100.0, diff)
+}
+
+func TestAddResultPerOp(t *testing.T) {
+	var b bench
+	// a time based -benchtime runs base twice as many iterations as the
+	// slower head
+	b.addResult(benchSourceBase, &benchmarkResult{
+		CPU:          profileResult{Key: "cpu-base", Total: 2_000_000_000},
+		AllocSpace:   profileResult{Key: "alloc-base", Total: 64_000_000},
+		AllocObjects: profileResult{Key: "objects-base", Total: 2_000_000},
+		InuseSpace:   profileResult{Key: "inuse-base", Total: 4096},
+		RawResult:    []*benchfmt.Result{{Iters: 600_000}, {Iters: 400_000}},
+	})
+	b.addResult(benchSourceHead, &benchmarkResult{
+		CPU:          profileResult{Key: "cpu-head", Total: 2_000_000_000},
+		AllocSpace:   profileResult{Key: "alloc-head", Total: 32_000_000},
+		AllocObjects: profileResult{Key: "objects-head", Total: 1_000_000},
+		InuseSpace:   profileResult{Key: "inuse-head", Total: 4096},
+		RawResult:    []*benchfmt.Result{{Iters: 500_000}},
+	})
+
+	results := make(map[string]report.BenchmarkResult)
+	for _, r := range b.results {
+		results[r.Name] = r
+	}
+	for _, tc := range []struct {
+		name       string
+		base, head int64
+		diff       float64
+	}{
+		{name: "cpu", base: 2000, head: 4000, diff: 100},
+		{name: "alloc_space", base: 64, head: 64, diff: 0},
+		{name: "alloc_objects", base: 2, head: 2, diff: 0},
+		// retained after the benchmark, regardless of its iterations
+		{name: "inuse_space", base: 4096, head: 4096, diff: 0},
+	} {
+		r := results[tc.name]
+		require.Equal(t, tc.base, r.BaseValue.ProfileValue, tc.name)
+		require.Equal(t, tc.head, r.HeadValue.ProfileValue, tc.name)
+		diff, ok := r.Diff()
+		require.True(t, ok, tc.name)
+		require.Equal(t, tc.diff, diff, tc.name)
+	}
+}
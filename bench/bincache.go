@@ -0,0 +1,675 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log/level"
+)
+
+type BinaryCacheArgs struct {
+	URL  string // oci://, s3:// or http(s):// location of the cache, disabled when empty
+	Auth string // user:password for basic auth, otherwise a bearer token, not used for S3
+
+	ReadOnly bool // only fetch binaries, never push the compiled ones
+}
+
+// addBinaryCacheArgs adds the flags of the binary cache. A read-only cache is
+// only read from, so binaries compiled from untrusted code never end up in
+// the cache trusted runs fetch from.
+func addBinaryCacheArgs(cmd *kingpin.CmdClause, readOnly bool) *BinaryCacheArgs {
+	args := &BinaryCacheArgs{ReadOnly: readOnly}
+	help := "Share compiled test binaries between runs, keyed by the hash of their sources, the Go version, GOOS/GOARCH and the build flags. Either an OCI registry repository like oci://ghcr.io/my-org/pyrobench-cache, an S3 bucket like s3://bucket/prefix using the AWS_* environment variables, or an HTTP store accepting GET and PUT."
+	authHelp := "Credentials for the OCI registry or HTTP store of the binary cache, either user:password for basic auth or a bearer token."
+	if readOnly {
+		help += " Binaries are only fetched from the cache, never pushed, fill it with trusted runs of the compare command."
+		authHelp += " Only read access is needed, don't pass credentials allowing to push."
+	}
+	cmd.Flag("binary-cache", help).PlaceHolder("URL").StringVar(&args.URL)
+	cmd.Flag("binary-cache-auth", authHelp).Envar("PYROBENCH_BINARY_CACHE_AUTH").StringVar(&args.Auth)
+	return args
+}
+
+// readOnlyBinaryCache fetches from the wrapped cache and drops all pushes.
+type readOnlyBinaryCache struct {
+	binaryCache
+}
+
+func (readOnlyBinaryCache) put(context.Context, string, []byte) error {
+	return nil
+}
+
+// binaryCache stores compiled test binaries by their cache key.
+type binaryCache interface {
+	// get returns the binary, nil when it is not cached.
+	get(ctx context.Context, key string) ([]byte, error)
+	put(ctx context.Context, key string, data []byte) error
+}
+
+func newBinaryCache(args *BinaryCacheArgs) (binaryCache, error) {
+	u, err := url.Parse(args.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid binary cache URL: %w", err)
+	}
+	client := &http.Client{Timeout: 5 * time.Minute}
+	switch u.Scheme {
+	case "http", "https":
+		return &httpBinaryCache{url: u, auth: args.Auth, client: client}, nil
+	case "s3":
+		return newS3BinaryCache(u, client)
+	case "oci":
+		repo := strings.Trim(u.Path, "/")
+		if u.Host == "" || repo == "" {
+			return nil, fmt.Errorf("invalid binary cache URL %q, expected oci://<registry>/<repository>", args.URL)
+		}
+		scheme := "https"
+		if host, _, _ := net.SplitHostPort(u.Host); host == "localhost" || host == "127.0.0.1" {
+			scheme = "http"
+		}
+		return &ociBinaryCache{
+			registry: &url.URL{Scheme: scheme, Host: u.Host},
+			repo:     repo,
+			auth:     args.Auth,
+			client:   client,
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported binary cache URL %q, expected oci://, s3:// or http(s)://", args.URL)
+}
+
+func addBinaryCacheToContext(ctx context.Context, c binaryCache) context.Context {
+	return context.WithValue(ctx, contextKeyBinaryCache, c)
+}
+
+// binaryCacheFromContext returns the cache, nil when caching is disabled.
+func binaryCacheFromContext(ctx context.Context) binaryCache {
+	c, _ := ctx.Value(contextKeyBinaryCache).(binaryCache)
+	return c
+}
+
+func (b *Benchmark) binaryCacheContext(ctx context.Context, args *BinaryCacheArgs) (context.Context, error) {
+	if args == nil || args.URL == "" {
+		return ctx, nil
+	}
+	c, err := newBinaryCache(args)
+	if err != nil {
+		return nil, err
+	}
+	if args.ReadOnly {
+		level.Info(b.logger).Log("msg", "fetching test binaries from the read-only binary cache", "url", args.URL)
+		return addBinaryCacheToContext(ctx, readOnlyBinaryCache{c}), nil
+	}
+	level.Info(b.logger).Log("msg", "sharing test binaries through the binary cache", "url", args.URL)
+	return addBinaryCacheToContext(ctx, c), nil
+}
+
+// binaryCacheEnv lists the settings of the go tool, which change the compiled
+// test binary without changing its sources.
+var binaryCacheEnv = []string{"GOVERSION", "GOOS", "GOARCH", "GOAMD64", "GOARM", "GOARM64", "GO386", "GOEXPERIMENT", "GOFLAGS", "CGO_ENABLED", "CC", "CXX", "CGO_CFLAGS", "CGO_LDFLAGS"}
+
+// listedPackage holds the fields of go list, the cache key is derived from.
+type listedPackage struct {
+	Dir        string
+	ImportPath string
+	Standard   bool
+	Module     *struct {
+		Path    string
+		Version string
+		Replace *struct {
+			Path    string
+			Version string
+		}
+	}
+	GoFiles, CgoFiles, CFiles, CXXFiles, HFiles, SFiles, SysoFiles []string
+	EmbedFiles, TestGoFiles, XTestGoFiles, TestEmbedFiles          []string
+	XTestEmbedFiles                                                []string
+}
+
+// binaryCacheKey returns the key of the package's test binary. It covers the
+// toolchain and its settings, the build flags, the versions of all module
+// dependencies and the content of all other source files the test binary is
+// compiled from.
+func (p *Package) binaryCacheKey(ctx context.Context) (string, error) {
+	h := sha256.New()
+
+	c := goCommand(ctx, p.meta.Root, append([]string{"env"}, binaryCacheEnv...)...)
+	env, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("error reading go env: %w", err)
+	}
+	h.Write(env)
+	for _, arg := range p.buildArgs {
+		fmt.Fprintf(h, "arg %s\x00", arg)
+	}
+
+	relativePath, err := filepath.Rel(p.meta.Root, p.meta.Dir)
+	if err != nil {
+		return "", err
+	}
+	cmd := append([]string{"go", "list", "-deps", "-test", "-json"}, p.buildArgs...)
+	cmd = append(cmd, "./"+filepath.ToSlash(relativePath))
+	c = goCommand(ctx, p.meta.Root, cmd[1:]...)
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("error listing the dependencies of %s: %w", p.meta.ImportPath, err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var lp listedPackage
+		if err := dec.Decode(&lp); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if err := lp.hash(h); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hash writes what identifies the package to h. Packages of the standard
+// library are identified by the Go version and those of module dependencies
+// by their version, the files of all others are hashed.
+func (lp *listedPackage) hash(h io.Writer) error {
+	fmt.Fprintf(h, "package %s\x00", lp.ImportPath)
+	if lp.Standard {
+		return nil
+	}
+	if m := lp.Module; m != nil {
+		if m.Replace != nil && m.Replace.Version != "" {
+			fmt.Fprintf(h, "module %s@%s\x00", m.Replace.Path, m.Replace.Version)
+			return nil
+		}
+		if m.Replace == nil && m.Version != "" {
+			fmt.Fprintf(h, "module %s@%s\x00", m.Path, m.Version)
+			return nil
+		}
+	}
+
+	var files []string
+	for _, fs := range [][]string{lp.GoFiles, lp.CgoFiles, lp.CFiles, lp.CXXFiles, lp.HFiles, lp.SFiles, lp.SysoFiles, lp.EmbedFiles, lp.TestGoFiles, lp.XTestGoFiles, lp.TestEmbedFiles, lp.XTestEmbedFiles} {
+		files = append(files, fs...)
+	}
+	sort.Strings(files)
+	for i, name := range files {
+		if i > 0 && files[i-1] == name {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(lp.Dir, name))
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(h, "file %s %x\x00", name, sum)
+	}
+	return nil
+}
+
+// fetchCachedBinary writes the cached test binary to path. It returns false,
+// when the binary is not cached.
+func fetchCachedBinary(ctx context.Context, c binaryCache, key, path string) (bool, error) {
+	data, err := c.get(ctx, key)
+	if err != nil || data == nil {
+		return false, err
+	}
+	if err := os.WriteFile(path, data, 0o755); err != nil {
+		return false, err
+	}
+	// the file already existed with the permissions of a temporary file
+	return true, os.Chmod(path, 0o755)
+}
+
+// httpBinaryCache keeps the binaries as objects of an HTTP store accepting
+// GET and PUT, below the URL.
+type httpBinaryCache struct {
+	url    *url.URL
+	auth   string
+	client *http.Client
+	sign   func(*http.Request) // authorizes the requests instead of auth, when set
+}
+
+func (c *httpBinaryCache) objectURL(key string) string {
+	u := *c.url
+	u.Path = path.Join("/", u.Path, key)
+	return u.String()
+}
+
+func (c *httpBinaryCache) do(ctx context.Context, method, key string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("user-agent", "pyrobench")
+	if body != nil {
+		req.Header.Set("content-type", "application/octet-stream")
+	}
+	if c.sign != nil {
+		c.sign(req)
+	} else {
+		setAuthorization(req, c.auth)
+	}
+	return c.client.Do(req)
+}
+
+func (c *httpBinaryCache) get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("GET %s: unexpected status %s", key, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (c *httpBinaryCache) put(ctx context.Context, key string, data []byte) error {
+	resp, err := c.do(ctx, http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: unexpected status %s", key, resp.Status)
+	}
+	return nil
+}
+
+// setAuthorization authorizes the request with basic auth for user:password,
+// otherwise with a bearer token.
+func setAuthorization(req *http.Request, auth string) {
+	if auth == "" {
+		return
+	}
+	if user, password, ok := strings.Cut(auth, ":"); ok {
+		req.SetBasicAuth(user, password)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+auth)
+}
+
+// newS3BinaryCache keeps the binaries in an S3 bucket. The credentials, the
+// region and optionally the endpoint of S3 compatible stores are taken from
+// the environment variables of the AWS SDKs.
+func newS3BinaryCache(u *url.URL, client *http.Client) (*httpBinaryCache, error) {
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("the S3 binary cache requires AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	// path style requests work with S3 compatible stores as well
+	base.Path = path.Join("/", base.Path, u.Host, u.Path)
+	s := &sigV4{
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		region:       region,
+		now:          time.Now,
+	}
+	return &httpBinaryCache{url: base, client: client, sign: s.sign}, nil
+}
+
+// sigV4 signs S3 requests with AWS Signature Version 4. The payload is not
+// signed, which S3 allows for requests over TLS.
+type sigV4 struct {
+	accessKey, secretKey, sessionToken string
+	region                             string
+	now                                func() time.Time
+}
+
+func (s *sigV4) sign(req *http.Request) {
+	const payloadHash = "UNSIGNED-PAYLOAD"
+	t := s.now().UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := map[string]string{"host": req.URL.Host, "x-amz-content-sha256": payloadHash, "x-amz-date": amzDate}
+	if s.sessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.sessionToken)
+		headers = append(headers, "x-amz-security-token")
+		values["x-amz-security-token"] = s.sessionToken
+	}
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		canonicalHeaders.WriteString(h + ":" + values[h] + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, v := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// The media types of the OCI artifacts the binaries are stored as.
+const (
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	ociArtifactType = "application/vnd.grafana.pyrobench.test-binary.v1"
+	ociEmptyType    = "application/vnd.oci.empty.v1+json"
+)
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int    `json:"size"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	ArtifactType  string          `json:"artifactType,omitempty"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociBinaryCache keeps every binary as an artifact of an OCI registry
+// repository, tagged with its key.
+type ociBinaryCache struct {
+	registry *url.URL
+	repo     string
+	auth     string
+	client   *http.Client
+
+	mtx   sync.Mutex
+	token string // obtained from the registry's token service
+}
+
+func ociDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (c *ociBinaryCache) get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/manifests/"+key, nil, "", ociManifestType)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET manifest %s: unexpected status %s", key, resp.Status)
+	}
+	var m ociManifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("error decoding manifest %s: %w", key, err)
+	}
+	if len(m.Layers) != 1 {
+		return nil, fmt.Errorf("manifest %s has %d layers, expected 1", key, len(m.Layers))
+	}
+
+	resp, err = c.do(ctx, http.MethodGet, "/blobs/"+m.Layers[0].Digest, nil, "", "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET blob %s: unexpected status %s", m.Layers[0].Digest, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if d := ociDigest(data); d != m.Layers[0].Digest {
+		return nil, fmt.Errorf("blob of %s has digest %s, expected %s", key, d, m.Layers[0].Digest)
+	}
+	return data, nil
+}
+
+func (c *ociBinaryCache) put(ctx context.Context, key string, data []byte) error {
+	empty := []byte("{}")
+	for _, blob := range [][]byte{empty, data} {
+		if err := c.pushBlob(ctx, blob); err != nil {
+			return err
+		}
+	}
+	manifest, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestType,
+		ArtifactType:  ociArtifactType,
+		Config:        ociDescriptor{MediaType: ociEmptyType, Digest: ociDigest(empty), Size: len(empty)},
+		Layers:        []ociDescriptor{{MediaType: "application/octet-stream", Digest: ociDigest(data), Size: len(data)}},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPut, "/manifests/"+key, manifest, ociManifestType, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("PUT manifest %s: unexpected status %s", key, resp.Status)
+	}
+	return nil
+}
+
+// pushBlob uploads the blob in a single request, unless the registry has it
+// already.
+func (c *ociBinaryCache) pushBlob(ctx context.Context, data []byte) error {
+	digest := ociDigest(data)
+	resp, err := c.do(ctx, http.MethodHead, "/blobs/"+digest, nil, "", "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	resp, err = c.do(ctx, http.MethodPost, "/blobs/uploads/", nil, "", "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("POST blob upload: unexpected status %s", resp.Status)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return fmt.Errorf("blob upload without location: %w", err)
+	}
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+
+	resp, err = c.doURL(ctx, http.MethodPut, location, data, "application/octet-stream", "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("PUT blob %s: unexpected status %s", digest, resp.Status)
+	}
+	return nil
+}
+
+func (c *ociBinaryCache) do(ctx context.Context, method, path string, body []byte, contentType, accept string) (*http.Response, error) {
+	u := *c.registry
+	u.Path = "/v2/" + c.repo + path
+	return c.doURL(ctx, method, &u, body, contentType, accept)
+}
+
+// doURL sends the request, authorized with the token of the registry. On the
+// first unauthorized response a token is requested from the token service the
+// registry points to and the request is retried.
+func (c *ociBinaryCache) doURL(ctx context.Context, method string, u *url.URL, body []byte, contentType, accept string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("user-agent", "pyrobench")
+		if contentType != "" {
+			req.Header.Set("content-type", contentType)
+		}
+		if accept != "" {
+			req.Header.Set("accept", accept)
+		}
+		c.mtx.Lock()
+		token := c.token
+		c.mtx.Unlock()
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			setAuthorization(req, c.auth)
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 || !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if err := c.fetchToken(ctx, challenge); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fetchToken requests a token from the service of the bearer challenge.
+func (c *ociBinaryCache) fetchToken(ctx context.Context, challenge string) error {
+	params := parseChallenge(challenge[len("bearer "):])
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Host == "" {
+		return fmt.Errorf("invalid token realm in %q", challenge)
+	}
+	q := realm.Query()
+	if s := params["service"]; s != "" {
+		q.Set("service", s)
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + c.repo + ":pull,push"
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("user-agent", "pyrobench")
+	if user, password, ok := strings.Cut(c.auth, ":"); ok {
+		req.SetBasicAuth(user, password)
+	} else if c.auth != "" {
+		// registries like ghcr.io accept tokens as password of any user
+		req.SetBasicAuth("pyrobench", c.auth)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET token: unexpected status %s", resp.Status)
+	}
+	var t struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return fmt.Errorf("error decoding token: %w", err)
+	}
+	if t.Token == "" {
+		t.Token = t.AccessToken
+	}
+	if t.Token == "" {
+		return errors.New("token service returned no token")
+	}
+	c.mtx.Lock()
+	c.token = t.Token
+	c.mtx.Unlock()
+	return nil
+}
+
+// parseChallenge parses the comma separated key="value" parameters of a
+// WWW-Authenticate challenge.
+func parseChallenge(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		k, rest, ok := strings.Cut(strings.TrimLeft(s, " ,"), "=")
+		if !ok {
+			break
+		}
+		var v string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+			v, rest = rest[1:end+1], rest[end+2:]
+		} else {
+			v, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(k))] = v
+		s = rest
+	}
+	return params
+}
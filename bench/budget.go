@@ -0,0 +1,87 @@
+package bench
+
+import (
+	"sort"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+func addMaxTotalDurationArg(cmd *kingpin.CmdClause, d *time.Duration) {
+	cmd.Flag("max-total-duration", "Budget for the whole comparison, including compiling. Benchmarks most likely affected by the change run first, those whose estimated duration does not fit into the remaining budget are skipped and reported as such. Runs still going at the end of the budget are stopped. 0 disables the budget.").Default("0").DurationVar(d)
+}
+
+// totalBudget limits the time spent on a whole comparison.
+type totalBudget struct {
+	max     time.Duration // 0 for no limit
+	started time.Time
+}
+
+// exhausted returns true, once no time is left.
+func (t totalBudget) exhausted(now time.Time) bool {
+	return t.max > 0 && now.Sub(t.started) >= t.max
+}
+
+// fits returns true, if a benchmark expected to run for estimate finishes
+// within the budget. Benchmarks with an unknown estimate fit as long as there
+// is time left.
+func (t totalBudget) fits(estimate time.Duration, now time.Time) bool {
+	if t.max <= 0 {
+		return true
+	}
+	return now.Sub(t.started)+estimate <= t.max && !t.exhausted(now)
+}
+
+// runTimeout returns the timeout of a run starting at now, which is timeout
+// shortened to the time left, so a run can not exceed the budget. A timeout
+// of 0 stands for none.
+func (t totalBudget) runTimeout(timeout time.Duration, now time.Time) time.Duration {
+	if t.max <= 0 {
+		return timeout
+	}
+	// a run starting without any time left times out right away
+	left := max(t.max-now.Sub(t.started), time.Millisecond)
+	if timeout > 0 && timeout < left {
+		return timeout
+	}
+	return left
+}
+
+// benchmarkPriority ranks benchmarks by how likely the change affects them,
+// lower ranks first. New benchmarks come first, removed ones last.
+func benchmarkPriority(reason string) int {
+	switch reason {
+	case reasonNew:
+		return 0
+	case reasonChanged:
+		return 1
+	case reasonRemoved:
+		return 3
+	default:
+		return 2
+	}
+}
+
+// prioritizeBenchmarks orders the benchmarks of every group by their
+// priority, keeping the order of benchmarks of the same priority.
+func prioritizeBenchmarks(benchmarkGroups [][]*benchWithKey) {
+	for _, benchmarks := range benchmarkGroups {
+		sort.SliceStable(benchmarks, func(i, j int) bool {
+			return benchmarkPriority(benchmarks[i].reason) < benchmarkPriority(benchmarks[j].reason)
+		})
+	}
+}
+
+// skippedBenchmarks returns the names of the benchmarks skipped because of
+// the total budget.
+func skippedBenchmarks(benchmarkGroups [][]*benchWithKey) []string {
+	var names []string
+	for _, benchmarks := range benchmarkGroups {
+		for _, r := range benchmarks {
+			if r.skipped {
+				names = append(names, r.key.packagePath+"."+r.key.benchmark)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,55 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTotalBudget(t *testing.T) {
+	now := time.Now()
+	unlimited := totalBudget{started: now.Add(-time.Hour)}
+	require.False(t, unlimited.exhausted(now))
+	require.True(t, unlimited.fits(time.Hour, now))
+
+	budget := totalBudget{max: 10 * time.Minute, started: now.Add(-8 * time.Minute)}
+	require.False(t, budget.exhausted(now))
+	require.True(t, budget.fits(2*time.Minute, now))
+	require.False(t, budget.fits(3*time.Minute, now))
+	// unknown estimates fit while there is time left
+	require.True(t, budget.fits(0, now))
+	require.True(t, budget.exhausted(now.Add(2*time.Minute)))
+	require.False(t, budget.fits(0, now.Add(2*time.Minute)))
+
+	// runs are cut off at the end of the budget
+	require.Equal(t, time.Minute, unlimited.runTimeout(time.Minute, now))
+	require.Zero(t, unlimited.runTimeout(0, now))
+	require.Equal(t, 2*time.Minute, budget.runTimeout(0, now))
+	require.Equal(t, 2*time.Minute, budget.runTimeout(5*time.Minute, now))
+	require.Equal(t, time.Minute, budget.runTimeout(time.Minute, now))
+	require.Equal(t, time.Millisecond, budget.runTimeout(time.Minute, now.Add(3*time.Minute)))
+}
+
+func TestPrioritizeBenchmarks(t *testing.T) {
+	newBench := func(name, reason string) *benchWithKey {
+		return &benchWithKey{key: benchKey{"example.com/m", name}, bench: &bench{reason: reason}}
+	}
+	groups := [][]*benchWithKey{{
+		newBench("BenchmarkRemoved", reasonRemoved),
+		newBench("BenchmarkUnknown", "tbd"),
+		newBench("BenchmarkChangedA", reasonChanged),
+		newBench("BenchmarkNew", reasonNew),
+		newBench("BenchmarkChangedB", reasonChanged),
+	}}
+	prioritizeBenchmarks(groups)
+	var names []string
+	for _, r := range groups[0] {
+		names = append(names, r.key.benchmark)
+	}
+	require.Equal(t, []string{"BenchmarkNew", "BenchmarkChangedA", "BenchmarkChangedB", "BenchmarkUnknown", "BenchmarkRemoved"}, names)
+
+	require.Empty(t, skippedBenchmarks(groups))
+	groups[0][4].skipped = true
+	require.Equal(t, []string{"example.com/m.BenchmarkRemoved"}, skippedBenchmarks(groups))
+}
@@ -0,0 +1,74 @@
+package bench
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// compareBuilds compares the size and compile time of the test binaries
+// compiled on both sides. Packages with identical test binaries are skipped,
+// the remaining are sorted by the change of their binary size, largest first.
+func (b *Benchmark) compareBuilds() []report.PackageBuild {
+	builds := make(map[string]*report.PackageBuild)
+	hashes := make(map[string][]byte)
+	identical := make(map[string]bool)
+	for _, x := range []struct {
+		pkgs []Package
+		head bool
+	}{
+		{b.basePackages, false},
+		{b.headPackages, true},
+	} {
+		for idx := range x.pkgs {
+			p := &x.pkgs[idx]
+			if p.build == nil {
+				continue
+			}
+			s, ok := builds[p.meta.ImportPath]
+			if !ok {
+				s = &report.PackageBuild{ImportPath: p.meta.ImportPath}
+				builds[p.meta.ImportPath] = s
+			}
+			if x.head {
+				s.Head = p.build
+				identical[p.meta.ImportPath] = bytes.Equal(hashes[p.meta.ImportPath], p.testBinaryHash)
+			} else {
+				s.Base = p.build
+				hashes[p.meta.ImportPath] = p.testBinaryHash
+			}
+		}
+	}
+
+	var result []report.PackageBuild
+	for path, s := range builds {
+		if s.Base != nil && s.Head != nil && identical[path] {
+			continue
+		}
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		di, dj := binarySizeDiff(&result[i]), binarySizeDiff(&result[j])
+		if di != dj {
+			return di > dj
+		}
+		return result[i].ImportPath < result[j].ImportPath
+	})
+	return result
+}
+
+// binarySizeDiff returns the absolute change of the binary size in bytes.
+func binarySizeDiff(s *report.PackageBuild) int64 {
+	var d int64
+	if s.Base != nil {
+		d -= s.Base.BinarySize
+	}
+	if s.Head != nil {
+		d += s.Head.BinarySize
+	}
+	if d < 0 {
+		return -d
+	}
+	return d
+}
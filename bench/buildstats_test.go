@@ -0,0 +1,46 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestCompareBuilds(t *testing.T) {
+	pkg := func(importPath, hash string, size int64) Package {
+		return Package{
+			meta:           &packageMeta{ImportPath: importPath},
+			testBinaryHash: []byte(hash),
+			build:          &report.Build{BinarySize: size, CompileTime: time.Second},
+		}
+	}
+
+	b, err := New(nil)
+	require.NoError(t, err)
+	b.basePackages = []Package{
+		pkg("example.com/m/a", "a1", 1000),
+		pkg("example.com/m/b", "b1", 2000),
+		pkg("example.com/m/c", "c1", 3000),
+		{meta: &packageMeta{ImportPath: "example.com/m/notests"}},
+	}
+	b.headPackages = []Package{
+		pkg("example.com/m/a", "a2", 1100),
+		pkg("example.com/m/b", "b1", 2000),
+		pkg("example.com/m/c", "c2", 5000),
+		pkg("example.com/m/d", "d2", 50),
+	}
+
+	builds := b.compareBuilds()
+	var paths []string
+	for _, s := range builds {
+		paths = append(paths, s.ImportPath)
+	}
+	// unchanged binaries are skipped, the largest change comes first
+	require.Equal(t, []string{"example.com/m/c", "example.com/m/a", "example.com/m/d"}, paths)
+	require.Equal(t, "example.com/m/c: binary 2.9 KiB → 4.9 KiB (+66.7 %), compile time 1s → 1s (+0.0 %)", builds[0].String())
+	require.Nil(t, builds[2].Base)
+	require.Equal(t, "n/a", builds[2].BinarySizeDiffMarkdown())
+}
@@ -0,0 +1,66 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/alecthomas/units"
+	"github.com/go-kit/log/level"
+)
+
+type CgroupArgs struct {
+	Parent    string           // delegated cgroup v2 directory to create the cgroups in, disabled when empty
+	CPUMax    float64          // CPUs a test binary may use, 0 for no limit
+	MemoryMax units.Base2Bytes // memory a test binary may use, 0 for no limit
+}
+
+func addCgroupArgs(cmd *kingpin.CmdClause) *CgroupArgs {
+	args := &CgroupArgs{}
+	cmd.Flag("cgroup-parent", "Run every test binary in a dedicated cgroup created below this cgroup v2 directory, e.g. /sys/fs/cgroup/pyrobench.slice. It must be delegated to the user running pyrobench and must not contain processes itself. Linux and the local executor only.").PlaceHolder("DIR").StringVar(&args.Parent)
+	cmd.Flag("cgroup-cpu-max", "CPUs a test binary may use, written to cpu.max of its cgroup. Fractions like 1.5 are allowed, 0 does not limit them.").Default("0").Float64Var(&args.CPUMax)
+	cmd.Flag("cgroup-memory-max", "Memory a test binary may use, written to memory.max of its cgroup, e.g. 4GB. Swap is disabled, 0 does not limit it.").Default("0").BytesVar(&args.MemoryMax)
+	return args
+}
+
+// enabled returns true, when the test binaries are run in cgroups.
+func (args *CgroupArgs) enabled() bool {
+	return args != nil && args.Parent != ""
+}
+
+func (args *CgroupArgs) validate() error {
+	if !args.enabled() {
+		if args != nil && (args.CPUMax != 0 || args.MemoryMax != 0) {
+			return errors.New("--cgroup-cpu-max and --cgroup-memory-max require --cgroup-parent")
+		}
+		return nil
+	}
+	if args.CPUMax < 0 {
+		return fmt.Errorf("invalid --cgroup-cpu-max %g", args.CPUMax)
+	}
+	if args.MemoryMax < 0 {
+		return fmt.Errorf("invalid --cgroup-memory-max %s", args.MemoryMax)
+	}
+	return nil
+}
+
+// cgroupContext replaces the local executor with one running every test
+// binary in a cgroup of its own.
+func (b *Benchmark) cgroupContext(ctx context.Context, executor string, args *CgroupArgs) (context.Context, error) {
+	if err := args.validate(); err != nil {
+		return nil, err
+	}
+	if !args.enabled() {
+		return ctx, nil
+	}
+	if executor != executorLocal {
+		return nil, fmt.Errorf("--cgroup-parent is not supported by the %s executor", executor)
+	}
+	l, err := newCgroupLimits(args)
+	if err != nil {
+		return nil, fmt.Errorf("error preparing cgroup %s: %w", args.Parent, err)
+	}
+	level.Info(b.logger).Log("msg", "running test binaries in cgroups", "parent", args.Parent, "cpu_max", args.CPUMax, "memory_max", args.MemoryMax)
+	return addExecutorToContext(ctx, localExecutor{cgroups: l}), nil
+}
@@ -0,0 +1,167 @@
+//go:build linux
+
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// cpuMaxPeriod is the period of cpu.max in microseconds, the quota is given
+// relative to it.
+const cpuMaxPeriod = 100000
+
+// cgroupLimits creates a cgroup per test binary below the parent with the
+// same limits, so every run gets the same resources.
+type cgroupLimits struct {
+	parent    string
+	cpuMax    float64
+	memoryMax int64
+
+	seq atomic.Int64 // makes the names of the cgroups unique
+}
+
+func newCgroupLimits(args *CgroupArgs) (*cgroupLimits, error) {
+	l := &cgroupLimits{
+		parent:    args.Parent,
+		cpuMax:    args.CPUMax,
+		memoryMax: int64(args.MemoryMax),
+	}
+
+	data, err := os.ReadFile(filepath.Join(l.parent, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("not a cgroup v2 directory: %w", err)
+	}
+	available := strings.Fields(string(data))
+	var controllers []string
+	if l.cpuMax > 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if l.memoryMax > 0 {
+		controllers = append(controllers, "memory")
+	}
+	for _, c := range controllers {
+		if !slices.Contains(available, c) {
+			return nil, fmt.Errorf("the %s controller is not available, available are: %s", c, strings.Join(available, ", "))
+		}
+		// the cgroups of the test binaries need the controller enabled by their parent
+		if err := os.WriteFile(filepath.Join(l.parent, "cgroup.subtree_control"), []byte("+"+c), 0o644); err != nil {
+			if errors.Is(err, syscall.EBUSY) {
+				return nil, fmt.Errorf("enabling the %s controller failed, the cgroup must not contain processes: %w", c, err)
+			}
+			return nil, fmt.Errorf("enabling the %s controller failed: %w", c, err)
+		}
+	}
+	return l, nil
+}
+
+// cgroup is the dedicated cgroup of a single test binary run.
+type cgroup struct {
+	dir string
+	fd  *os.File // of the directory, to start the test binary in
+}
+
+// create creates a new cgroup with the limits.
+func (l *cgroupLimits) create() (*cgroup, error) {
+	dir := filepath.Join(l.parent, fmt.Sprintf("pyrobench-%d-%d", os.Getpid(), l.seq.Add(1)))
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &cgroup{dir: dir}
+	if err := l.configure(c); err != nil {
+		_ = c.remove()
+		return nil, err
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		_ = c.remove()
+		return nil, err
+	}
+	c.fd = fd
+	return c, nil
+}
+
+func (l *cgroupLimits) configure(c *cgroup) error {
+	if l.cpuMax > 0 {
+		quota := int64(l.cpuMax * cpuMaxPeriod)
+		if err := c.write("cpu.max", fmt.Sprintf("%d %d", quota, cpuMaxPeriod)); err != nil {
+			return err
+		}
+	}
+	if l.memoryMax > 0 {
+		if err := c.write("memory.max", strconv.FormatInt(l.memoryMax, 10)); err != nil {
+			return err
+		}
+		// swapping would hide exceeding the limit and skew the results,
+		// without swap accounting the file is missing
+		if err := c.write("memory.swap.max", "0"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *cgroup) write(file, value string) error {
+	return os.WriteFile(filepath.Join(c.dir, file), []byte(value), 0o644)
+}
+
+// apply starts the command directly in the cgroup. It needs to be called
+// after the other process attributes have been set.
+func (c *cgroup) apply(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.fd.Fd())
+}
+
+// oomKilled returns true, when a process of the cgroup has been killed for
+// exceeding memory.max.
+func (c *cgroup) oomKilled() bool {
+	data, err := os.ReadFile(filepath.Join(c.dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	return parseOOMKills(data) > 0
+}
+
+// parseOOMKills returns the oom_kill counter of memory.events.
+func parseOOMKills(data []byte) int {
+	for _, line := range strings.Split(string(data), "\n") {
+		if v, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			n, _ := strconv.Atoi(strings.TrimSpace(v))
+			return n
+		}
+	}
+	return 0
+}
+
+// remove kills the processes left in the cgroup and removes it.
+func (c *cgroup) remove() error {
+	if c.fd != nil {
+		_ = c.fd.Close()
+		c.fd = nil
+	}
+	// children of the test binary might still be exiting, cgroup.kill
+	// requires Linux 5.14
+	_ = c.write("cgroup.kill", "1")
+	var err error
+	for i := 0; i < 10; i++ {
+		err = os.Remove(c.dir)
+		if err == nil || errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+//go:build linux
+
+package bench
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroupLimits(t *testing.T) {
+	parent := t.TempDir()
+
+	_, err := newCgroupLimits(&CgroupArgs{Parent: parent, CPUMax: 1})
+	require.ErrorContains(t, err, "not a cgroup v2 directory")
+
+	require.NoError(t, os.WriteFile(filepath.Join(parent, "cgroup.controllers"), []byte("cpuset cpu io\n"), 0o644))
+	_, err = newCgroupLimits(&CgroupArgs{Parent: parent, CPUMax: 1, MemoryMax: 1 << 30})
+	require.EqualError(t, err, "the memory controller is not available, available are: cpuset, cpu, io")
+
+	require.NoError(t, os.WriteFile(filepath.Join(parent, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0o644))
+	l, err := newCgroupLimits(&CgroupArgs{Parent: parent, CPUMax: 1.5, MemoryMax: 1 << 30})
+	require.NoError(t, err)
+
+	c, err := l.create()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = c.fd.Close()
+	})
+	require.Equal(t, filepath.Join(parent, fmt.Sprintf("pyrobench-%d-1", os.Getpid())), c.dir)
+	for file, expected := range map[string]string{
+		"cpu.max":         "150000 100000",
+		"memory.max":      "1073741824",
+		"memory.swap.max": "0",
+	} {
+		data, err := os.ReadFile(filepath.Join(c.dir, file))
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data), file)
+	}
+
+	cmd := exec.Command("true")
+	setProcessGroup(cmd)
+	c.apply(cmd)
+	require.True(t, cmd.SysProcAttr.Setpgid)
+	require.True(t, cmd.SysProcAttr.UseCgroupFD)
+	require.Equal(t, int(c.fd.Fd()), cmd.SysProcAttr.CgroupFD)
+
+	require.False(t, c.oomKilled())
+	require.NoError(t, os.WriteFile(filepath.Join(c.dir, "memory.events"), []byte("low 0\nhigh 0\nmax 12\noom 1\noom_kill 1\noom_group_kill 0\n"), 0o644))
+	require.True(t, c.oomKilled())
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package bench
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// cgroupLimits are not implemented on this platform.
+type cgroupLimits struct{}
+
+func newCgroupLimits(_ *CgroupArgs) (*cgroupLimits, error) {
+	return nil, errors.New("cgroups are only supported on Linux")
+}
+
+type cgroup struct{}
+
+func (l *cgroupLimits) create() (*cgroup, error) {
+	return nil, errors.New("cgroups are only supported on Linux")
+}
+
+func (c *cgroup) apply(_ *exec.Cmd) {}
+
+func (c *cgroup) oomKilled() bool { return false }
+
+func (c *cgroup) remove() error { return nil }
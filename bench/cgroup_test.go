@@ -0,0 +1,30 @@
+package bench
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCgroupContext(t *testing.T) {
+	b, err := New(log.NewNopLogger())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// disabled
+	actual, err := b.cgroupContext(ctx, executorLocal, &CgroupArgs{})
+	require.NoError(t, err)
+	require.Equal(t, ctx, actual)
+	actual, err = b.cgroupContext(ctx, executorLocal, nil)
+	require.NoError(t, err)
+	require.Equal(t, ctx, actual)
+
+	_, err = b.cgroupContext(ctx, executorLocal, &CgroupArgs{CPUMax: 2})
+	require.EqualError(t, err, "--cgroup-cpu-max and --cgroup-memory-max require --cgroup-parent")
+	_, err = b.cgroupContext(ctx, executorLocal, &CgroupArgs{Parent: t.TempDir(), CPUMax: -1})
+	require.EqualError(t, err, "invalid --cgroup-cpu-max -1")
+	_, err = b.cgroupContext(ctx, executorKubernetes, &CgroupArgs{Parent: t.TempDir()})
+	require.EqualError(t, err, "--cgroup-parent is not supported by the kubernetes executor")
+}
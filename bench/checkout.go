@@ -0,0 +1,236 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// checkoutBase resolves the base commit and checks it out into a separate
+// worktree, unless it has been checked out already. Without a base nothing is
+// checked out.
+func (b *Benchmark) checkoutBase(ctx context.Context, args *CompareArgs) error {
+	if args.HeadOnly {
+		return nil
+	}
+	var err error
+	if args.BaseDir != "" {
+		b.baseDir, b.baseCommit, err = b.checkedOutDir(ctx, args.BaseDir)
+		return err
+	}
+
+	b.baseCommit, err = b.gitRevParse(ctx, args.BaseRef)
+	if err != nil {
+		return fmt.Errorf("error resolving base git rev %s: %w", args.BaseRef, err)
+	}
+	b.baseDir, err = b.gitWorktree(ctx, "pyrobench-base", b.baseCommit, nil)
+	if err != nil {
+		return fmt.Errorf("error checking out base commit %s: %w", b.baseCommit, err)
+	}
+	return nil
+}
+
+// checkoutHead resolves the head commit. Without a head ref the working
+// directory is used as head, otherwise it gets checked out into a separate
+// worktree.
+func (b *Benchmark) checkoutHead(ctx context.Context, args *CompareArgs) error {
+	var err error
+	if args.HeadDir != "" {
+		b.headDir, b.headCommit, err = b.checkedOutDir(ctx, args.HeadDir)
+		return err
+	}
+
+	headRef := args.HeadRef
+	if headRef == "" {
+		headRef = "HEAD"
+	}
+	b.headCommit, err = b.gitRevParse(ctx, headRef)
+	if err != nil {
+		return fmt.Errorf("error resolving head git rev %s: %w", headRef, err)
+	}
+
+	if args.HeadRef == "" {
+		b.headDir, err = b.workDir()
+		return err
+	}
+
+	b.headDir, err = b.gitWorktree(ctx, "pyrobench-head", b.headCommit, func(dir string) error {
+		return b.checkWorktreeURLs(dir, args.CheckoutHosts)
+	})
+	if err != nil {
+		return fmt.Errorf("error checking out head commit %s: %w", b.headCommit, err)
+	}
+	return nil
+}
+
+// checkedOutDir returns the absolute path of a directory checked out outside
+// of pyrobench and, if it is a git repository, the commit checked out. The
+// commit is only used for the report, so failing to read it is not fatal.
+func (b *Benchmark) checkedOutDir(ctx context.Context, dir string) (string, string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", fmt.Errorf("error getting absolute path of %s: %w", dir, err)
+	}
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "HEAD")
+	cmd.Dir = abs
+	out, err := cmd.Output()
+	if err != nil {
+		level.Debug(b.logger).Log("msg", "unable to determine commit of checked out directory", "dir", abs, "err", err)
+		return abs, "", nil
+	}
+	return abs, strings.TrimSpace(string(out)), nil
+}
+
+// initWorktree checks out the submodules and pulls the LFS objects of a new
+// worktree, which git worktree add leaves out. It returns whether submodules
+// have been checked out.
+func (b *Benchmark) initWorktree(ctx context.Context, dir string) (bool, error) {
+	_, err := os.Stat(filepath.Join(dir, ".gitmodules"))
+	submodules := err == nil
+	if submodules {
+		if err := b.gitProgress(ctx, dir, "updating submodules", "submodule", "update", "--init", "--recursive"); err != nil {
+			return true, err
+		}
+	}
+	if usesLFS(dir) {
+		if _, err := exec.LookPath("git-lfs"); err != nil {
+			level.Warn(b.logger).Log("msg", "repository uses git LFS, but git-lfs is not installed, the LFS files are left as pointers", "dir", dir)
+			return submodules, nil
+		}
+		if err := b.gitProgress(ctx, dir, "pulling LFS objects", "lfs", "pull"); err != nil {
+			return submodules, err
+		}
+	}
+	return submodules, nil
+}
+
+// gitProgress runs git in dir and logs when it has started and finished.
+func (b *Benchmark) gitProgress(ctx context.Context, dir, msg string, args ...string) error {
+	level.Info(b.logger).Log("msg", msg, "dir", dir)
+	started := time.Now()
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w\n%s", msg, err, out)
+	}
+	level.Info(b.logger).Log("msg", "finished "+msg, "dir", dir, "duration", time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// usesLFS returns whether the .gitattributes of the worktree's root assign
+// the LFS filter to any file.
+func usesLFS(dir string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, ".gitattributes"))
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(data, []byte("filter=lfs"))
+}
+
+// worktreeURLConfigs match the keys of the files of a worktree, which name the
+// URLs its submodules and LFS objects are fetched from.
+var worktreeURLConfigs = []struct{ file, keys string }{
+	{file: ".gitmodules", keys: `^submodule\..*\.url$`},
+	{file: ".lfsconfig", keys: `^(lfs\.url|remote\..*\.lfsurl)$`},
+}
+
+// worktreeURLs returns the submodule and LFS URLs configured by the worktree.
+func worktreeURLs(dir string) ([]string, error) {
+	var urls []string
+	for _, c := range worktreeURLConfigs {
+		path := filepath.Join(dir, c.file)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		out, err := gitIn(dir, nil, "config", "--file", path, "--get-regexp", c.keys)
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			// no key matches
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", c.file, err)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			if _, u, ok := strings.Cut(line, " "); ok {
+				urls = append(urls, strings.TrimSpace(u))
+			}
+		}
+	}
+	return urls, nil
+}
+
+// urlHost returns the lower case host of a git URL, either with a scheme or
+// scp-like as in git@github.com:org/repo.git, empty for local paths and
+// relative URLs as well as remote helpers like ext::.
+func urlHost(raw string) string {
+	if strings.HasPrefix(raw, "./") || strings.HasPrefix(raw, "../") || strings.Contains(raw, "::") {
+		return ""
+	}
+	if strings.Contains(raw, "://") {
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme == "file" {
+			return ""
+		}
+		return strings.ToLower(u.Hostname())
+	}
+	host, _, ok := strings.Cut(raw, ":")
+	if !ok || strings.Contains(host, "/") {
+		return ""
+	}
+	if _, h, ok := strings.Cut(host, "@"); ok {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// checkWorktreeURLs refuses to fetch the submodules and LFS objects of a
+// worktree from other hosts than the one of the origin remote, those base
+// fetches from and the allowed ones. The URLs come from the checked out
+// commit, which for head might be a pull request steering the runner and the
+// credentials of git towards any server. Relative URLs resolve against the
+// origin remote, URLs configured by base as well are always accepted.
+func (b *Benchmark) checkWorktreeURLs(dir string, allowedHosts []string) error {
+	urls, err := worktreeURLs(dir)
+	if err != nil || len(urls) == 0 {
+		return err
+	}
+
+	hosts := make(map[string]bool)
+	for _, h := range allowedHosts {
+		hosts[strings.ToLower(h)] = true
+	}
+	if origin, err := gitIn(b.dir, nil, "remote", "get-url", "origin"); err == nil {
+		hosts[urlHost(strings.TrimSpace(string(origin)))] = true
+	}
+	known := make(map[string]bool)
+	if b.baseDir != "" {
+		baseURLs, err := worktreeURLs(b.baseDir)
+		if err != nil {
+			return err
+		}
+		for _, u := range baseURLs {
+			known[u] = true
+			hosts[urlHost(u)] = true
+		}
+	}
+	delete(hosts, "")
+
+	for _, u := range urls {
+		if known[u] || strings.HasPrefix(u, "./") || strings.HasPrefix(u, "../") {
+			continue
+		}
+		if h := urlHost(u); h == "" || !hosts[h] {
+			return fmt.Errorf("refusing to fetch %s configured by head, its host is neither the one of origin nor one base fetches from, allow it with --checkout-host", u)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckedOutDirs(t *testing.T) {
+	base := t.TempDir()
+	runGit(t, base, "init", "--initial-branch", "main", ".")
+	require.NoError(t, os.WriteFile(filepath.Join(base, "go.mod"), []byte("module example.com/m\n"), 0o644))
+	runGit(t, base, "add", "go.mod")
+	runGit(t, base, "commit", "-m", "base")
+	baseCommit := runGit(t, base, "rev-parse", "HEAD")
+
+	// e.g. a source archive without git metadata
+	head := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(head, "go.mod"), []byte("module example.com/m\n"), 0o644))
+
+	b, err := New(log.NewNopLogger())
+	require.NoError(t, err)
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+
+	args := &CompareArgs{
+		BaseRef: "does-not-exist",
+		BaseDir: base,
+		HeadDir: head,
+	}
+	require.NoError(t, b.checkoutBase(ctx, args))
+	require.NoError(t, b.checkoutHead(ctx, args))
+	require.Equal(t, base, b.baseDir)
+	require.Equal(t, baseCommit, b.baseCommit)
+	require.Equal(t, head, b.headDir)
+	require.Empty(t, b.headCommit)
+
+	require.NoError(t, cleaner.cleanup())
+	require.Equal(t, baseCommit, runGit(t, base, "rev-parse", "HEAD"), "checked out dirs are left alone")
+}
+
+func TestWorktreeSubmodules(t *testing.T) {
+	// the submodule is cloned from the local file system
+	t.Setenv("GIT_CONFIG_COUNT", "1")
+	t.Setenv("GIT_CONFIG_KEY_0", "protocol.file.allow")
+	t.Setenv("GIT_CONFIG_VALUE_0", "always")
+
+	sub := t.TempDir()
+	runGit(t, sub, "init", "--initial-branch", "main", ".")
+	require.NoError(t, os.WriteFile(filepath.Join(sub, "fixture.txt"), []byte("fixture"), 0o644))
+	runGit(t, sub, "add", "fixture.txt")
+	runGit(t, sub, "commit", "-m", "fixture")
+
+	repo := t.TempDir()
+	runGit(t, repo, "init", "--initial-branch", "main", ".")
+	runGit(t, repo, "submodule", "add", sub, "testdata")
+	runGit(t, repo, "commit", "-m", "base")
+	commit := runGit(t, repo, "rev-parse", "HEAD")
+	require.False(t, usesLFS(repo))
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(repo))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+
+	b, err := New(log.NewNopLogger())
+	require.NoError(t, err)
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	dir, err := b.gitWorktree(ctx, "pyrobench-test", commit, nil)
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, "testdata", "fixture.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "fixture", string(data))
+
+	require.NoError(t, cleaner.cleanup())
+	require.NoDirExists(t, dir)
+
+	lfs := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(lfs, ".gitattributes"), []byte("*.bin filter=lfs diff=lfs merge=lfs -text\n"), 0o644))
+	require.True(t, usesLFS(lfs))
+}
+
+func TestURLHost(t *testing.T) {
+	for raw, expected := range map[string]string{
+		"https://GitHub.com/org/repo.git":      "github.com",
+		"ssh://git@gitlab.com:2222/org/repo":   "gitlab.com",
+		"git@github.com:org/repo.git":          "github.com",
+		"github.com:org/repo.git":              "github.com",
+		"../fixtures.git":                      "",
+		"./fixtures":                           "",
+		"/srv/git/fixtures.git":                "",
+		"file:///srv/git/fixtures.git":         "",
+		"ext::sh -c touch% /tmp/pwned":         "",
+		"https://lfs.example.com/org/repo.git": "lfs.example.com",
+	} {
+		require.Equal(t, expected, urlHost(raw), raw)
+	}
+}
+
+func TestCheckWorktreeURLs(t *testing.T) {
+	repo := t.TempDir()
+	runGit(t, repo, "init", "--initial-branch", "main", ".")
+	runGit(t, repo, "remote", "add", "origin", "git@github.com:org/repo.git")
+
+	base := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(base, ".gitmodules"), []byte(`[submodule "testdata"]
+	path = testdata
+	url = /srv/git/fixtures.git
+[submodule "vendor"]
+	url = https://gitlab.com/org/vendor.git
+`), 0o644))
+	b := &Benchmark{logger: log.NewNopLogger(), dir: repo, baseDir: base}
+
+	head := t.TempDir()
+	writeHead := func(gitmodules, lfsconfig string) {
+		require.NoError(t, os.WriteFile(filepath.Join(head, ".gitmodules"), []byte(gitmodules), 0o644))
+		require.NoError(t, os.WriteFile(filepath.Join(head, ".lfsconfig"), []byte(lfsconfig), 0o644))
+	}
+
+	writeHead(`[submodule "testdata"]
+	url = /srv/git/fixtures.git
+[submodule "relative"]
+	url = ../other.git
+[submodule "origin"]
+	url = https://github.com/org/other.git
+[submodule "base"]
+	url = https://gitlab.com/org/other.git
+`, "[lfs]\n\turl = https://github.com/org/repo.git/info/lfs\n")
+	require.NoError(t, b.checkWorktreeURLs(head, nil))
+
+	writeHead("", "[lfs]\n\turl = https://attacker.example.com/lfs\n")
+	require.ErrorContains(t, b.checkWorktreeURLs(head, nil), "refusing to fetch https://attacker.example.com/lfs configured by head")
+	require.NoError(t, b.checkWorktreeURLs(head, []string{"Attacker.example.com"}))
+
+	writeHead("[submodule \"local\"]\n\turl = /etc/secrets.git\n", "")
+	require.ErrorContains(t, b.checkWorktreeURLs(head, nil), "refusing to fetch /etc/secrets.git")
+
+	writeHead("[submodule \"pwned\"]\n\turl = ext::sh -c touch% /tmp/pwned\n", "")
+	require.ErrorContains(t, b.checkWorktreeURLs(head, nil), "refusing to fetch ext::sh")
+}
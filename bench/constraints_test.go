@@ -0,0 +1,105 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestListConstrainedBenchmarks(t *testing.T) {
+	dir := t.TempDir()
+	for name, src := range map[string]string{
+		"pkg_test.go": `package pkg
+
+import "testing"
+
+func BenchmarkPortable(b *testing.B) {}
+`,
+		"linux_test.go": `//go:build linux && amd64
+
+package pkg
+
+import "testing"
+
+func BenchmarkEpoll(b *testing.B) {}
+
+func BenchmarkPortable(b *testing.B) {}
+`,
+		"poll_darwin_test.go": `package pkg
+
+import "testing"
+
+func BenchmarkEpoll(b *testing.B) {}
+
+func BenchmarkKqueue(b *testing.B) {}
+`,
+		"gen_test.go": `//go:build ignore
+
+package main
+
+import "testing"
+
+func BenchmarkGenerator(b *testing.B) {}
+`,
+		"integration_test.go": `//go:build integration
+
+package pkg
+
+import "testing"
+
+func BenchmarkDatabase(b *testing.B) {}
+`,
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644))
+	}
+
+	p := &Package{meta: &packageMeta{
+		Dir:            dir,
+		ImportPath:     "example.com/repo/pkg",
+		TestGoFiles:    []string{"pkg_test.go"},
+		IgnoredGoFiles: []string{"gen_test.go", "integration_test.go", "linux_test.go", "poll_darwin_test.go"},
+	}}
+	require.NoError(t, p.listBenchmarksAst(context.Background(), nil))
+	require.NoError(t, p.listConstrainedBenchmarks([]*BenchmarkFilter{{Filter: regexp.MustCompile("Database|Epoll|Kqueue|Portable|Generator")}}))
+
+	constraints := make(map[string]string)
+	for _, c := range p.constrained {
+		constraints[c.Name] = c.Constraint
+	}
+	require.Equal(t, map[string]string{
+		"BenchmarkDatabase": "integration",
+		"BenchmarkEpoll":    "linux && amd64 || darwin",
+		"BenchmarkKqueue":   "darwin",
+	}, constraints)
+
+	b := &Benchmark{headDir: dir, headPackages: []Package{*p}}
+	runs := b.constrainedRuns()
+	require.Len(t, runs, 3)
+	require.Equal(t, "example.com/repo/pkg.BenchmarkDatabase", runs[0].Name)
+	require.Equal(t, "integration_test.go", runs[0].File)
+	require.Equal(t, "(skipped: build constraints integration)", runs[0].Status())
+}
+
+func TestFileNameConstraint(t *testing.T) {
+	for name, expected := range map[string]string{
+		"poll_test.go":             "",
+		"poll_linux_test.go":       "linux",
+		"poll_arm64_test.go":       "arm64",
+		"poll_linux_arm64_test.go": "linux && arm64",
+		"linux_test.go":            "",
+		"poll_other_test.go":       "",
+	} {
+		require.Equal(t, expected, fileNameConstraint(name), name)
+	}
+}
+
+func TestValidatePlatform(t *testing.T) {
+	require.NoError(t, (*GoEnvArgs)(nil).validatePlatform(executorLocal))
+	require.NoError(t, (&GoEnvArgs{Platform: "linux/arm64"}).validatePlatform(executorKubernetes))
+	require.EqualError(t, (&GoEnvArgs{Platform: "linux"}).validatePlatform(executorKubernetes), `invalid --target-platform "linux", expected GOOS/GOARCH`)
+	require.Error(t, (&GoEnvArgs{Platform: "plan9/mips"}).validatePlatform(executorLocal))
+}
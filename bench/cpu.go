@@ -0,0 +1,75 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// cpuFrequencyTolerance is the relative difference of the average CPU
+// frequency between base and head, above which the results are flagged.
+const cpuFrequencyTolerance = 0.1
+
+// cpuUsage describes on which cores a benchmark ran and their frequencies
+// while it was running.
+type cpuUsage struct {
+	cores map[int]struct{}
+
+	freqSamples int
+	freqSum     uint64 // in kHz
+	freqMin     uint64
+	freqMax     uint64
+}
+
+func (u *cpuUsage) addCore(core int) {
+	if u.cores == nil {
+		u.cores = make(map[int]struct{})
+	}
+	u.cores[core] = struct{}{}
+}
+
+func (u *cpuUsage) addFrequency(khz uint64) {
+	if u.freqSamples == 0 || khz < u.freqMin {
+		u.freqMin = khz
+	}
+	if khz > u.freqMax {
+		u.freqMax = khz
+	}
+	u.freqSum += khz
+	u.freqSamples++
+}
+
+func (u *cpuUsage) report(source benchSource) report.CPUUsage {
+	r := report.CPUUsage{
+		Source:     source.String(),
+		MinFreqKHz: u.freqMin,
+		MaxFreqKHz: u.freqMax,
+	}
+	for c := range u.cores {
+		r.Cores = append(r.Cores, c)
+	}
+	sort.Ints(r.Cores)
+	if u.freqSamples > 0 {
+		r.AvgFreqKHz = u.freqSum / uint64(u.freqSamples)
+	}
+	return r
+}
+
+// cpuFrequencyWarning returns a warning, when the average CPU frequency of
+// base and head differ by more than the tolerance.
+func cpuFrequencyWarning(base, head *report.CPUUsage) string {
+	if base == nil || head == nil || base.AvgFreqKHz == 0 || head.AvgFreqKHz == 0 {
+		return ""
+	}
+	diff := math.Abs(float64(head.AvgFreqKHz)-float64(base.AvgFreqKHz)) / float64(base.AvgFreqKHz)
+	if diff <= cpuFrequencyTolerance {
+		return ""
+	}
+	return fmt.Sprintf(
+		"CPU frequency differed between base (avg %s) and head (avg %s), the results might be skewed by frequency scaling.",
+		report.FormatFrequency(base.AvgFreqKHz),
+		report.FormatFrequency(head.AvgFreqKHz),
+	)
+}
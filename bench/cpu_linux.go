@@ -0,0 +1,117 @@
+//go:build linux
+
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const cpuSampleInterval = 100 * time.Millisecond
+
+// cpuSampler periodically samples on which cores the running threads of a
+// process are scheduled and the current frequency of these cores, while
+// active returns true.
+type cpuSampler struct {
+	pid    int
+	active func() bool
+	usage  cpuUsage
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func startCPUSampler(pid int, active func() bool) *cpuSampler {
+	s := &cpuSampler{
+		pid:    pid,
+		active: active,
+		stopCh: make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(cpuSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stopCh:
+				return
+			case <-ticker.C:
+				if s.active() {
+					s.sample()
+				}
+			}
+		}
+	}()
+	return s
+}
+
+// stop stops the sampling and returns the usage observed, nil if nothing
+// could be observed.
+func (s *cpuSampler) stop() *cpuUsage {
+	close(s.stopCh)
+	s.wg.Wait()
+	if len(s.usage.cores) == 0 {
+		return nil
+	}
+	return &s.usage
+}
+
+func (s *cpuSampler) sample() {
+	tasks, err := os.ReadDir(fmt.Sprintf("/proc/%d/task", s.pid))
+	if err != nil {
+		return
+	}
+	for _, t := range tasks {
+		stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(s.pid), "task", t.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		core, running := parseTaskStat(stat)
+		if !running {
+			continue
+		}
+		s.usage.addCore(core)
+		if khz, ok := coreFrequency(core); ok {
+			s.usage.addFrequency(khz)
+		}
+	}
+}
+
+// parseTaskStat returns the core a task was last scheduled on and whether
+// it is currently running. See proc(5) for the format.
+func parseTaskStat(stat []byte) (int, bool) {
+	// the command name might contain spaces and parentheses
+	idx := bytes.LastIndexByte(stat, ')')
+	if idx < 0 {
+		return 0, false
+	}
+	fields := strings.Fields(string(stat[idx+1:]))
+	// fields start with the state (3rd field), the processor is the 39th
+	const stateField, processorField = 0, 39 - 3
+	if len(fields) <= processorField || fields[stateField] != "R" {
+		return 0, false
+	}
+	core, err := strconv.Atoi(fields[processorField])
+	if err != nil {
+		return 0, false
+	}
+	return core, true
+}
+
+func coreFrequency(core int) (uint64, bool) {
+	data, err := os.ReadFile(fmt.Sprintf("/sys/devices/system/cpu/cpu%d/cpufreq/scaling_cur_freq", core))
+	if err != nil {
+		return 0, false
+	}
+	khz, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return khz, true
+}
@@ -0,0 +1,23 @@
+//go:build linux
+
+package bench
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTaskStat(t *testing.T) {
+	const stat = "1234 (pkg.test (x)) R 1 1234 1234 0 -1 4194304 1000 0 0 0 150 10 0 0 20 0 8 0 5000 1000000 500 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 5 0 0 0 0 0 0 0 0 0 0 0 0 0"
+
+	core, running := parseTaskStat([]byte(stat))
+	require.True(t, running)
+	require.Equal(t, 5, core)
+
+	_, running = parseTaskStat([]byte("1234 (pkg.test) S 1 1234 1234 0 -1 4194304 1000 0 0 0 150 10 0 0 20 0 8 0 5000 1000000 500 18446744073709551615 1 1 0 0 0 0 0 0 0 0 0 0 17 5 0 0"))
+	require.False(t, running)
+
+	_, running = parseTaskStat([]byte("garbage"))
+	require.False(t, running)
+}
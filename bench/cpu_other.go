@@ -0,0 +1,10 @@
+//go:build !linux
+
+package bench
+
+// cpuSampler is not implemented on this platform.
+type cpuSampler struct{}
+
+func startCPUSampler(_ int, _ func() bool) *cpuSampler { return &cpuSampler{} }
+
+func (s *cpuSampler) stop() *cpuUsage { return nil }
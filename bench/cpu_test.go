@@ -0,0 +1,43 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestCPUUsageReport(t *testing.T) {
+	var u cpuUsage
+	for _, c := range []int{3, 0, 1, 2, 6, 1} {
+		u.addCore(c)
+	}
+	for _, f := range []uint64{3000000, 2000000, 2500000} {
+		u.addFrequency(f)
+	}
+
+	r := u.report(benchSourceHead)
+	require.Equal(t, report.CPUUsage{
+		Source:     "head",
+		Cores:      []int{0, 1, 2, 3, 6},
+		MinFreqKHz: 2000000,
+		MaxFreqKHz: 3000000,
+		AvgFreqKHz: 2500000,
+	}, r)
+	require.Equal(t, "head on cores 0-3,6 at 2.00-3.00 GHz", r.String())
+}
+
+func TestCPUFrequencyWarning(t *testing.T) {
+	usage := func(avg uint64) *report.CPUUsage {
+		return &report.CPUUsage{AvgFreqKHz: avg}
+	}
+
+	require.Empty(t, cpuFrequencyWarning(nil, usage(2000000)))
+	require.Empty(t, cpuFrequencyWarning(usage(0), usage(2000000)))
+	require.Empty(t, cpuFrequencyWarning(usage(2000000), usage(2150000)))
+	require.Equal(t,
+		"CPU frequency differed between base (avg 2.00 GHz) and head (avg 3.00 GHz), the results might be skewed by frequency scaling.",
+		cpuFrequencyWarning(usage(2000000), usage(3000000)),
+	)
+}
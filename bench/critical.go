@@ -0,0 +1,168 @@
+package bench
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/log/level"
+	"github.com/google/pprof/profile"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// criticalDirective marks performance sensitive functions. Changes to their
+// resource usage are reported, even when the benchmark as a whole stays
+// below the percentage threshold.
+const criticalDirective = "//pyrobench:critical"
+
+// listCriticalFunctions records the symbol names of all functions of the
+// package, which are marked with the critical directive. Files failing to
+// parse are skipped, as the directives are only an aid to the report.
+func (p *Package) listCriticalFunctions() {
+	fset := token.NewFileSet()
+	for _, fileName := range p.meta.GoFiles {
+		file, err := parser.ParseFile(fset, filepath.Join(p.meta.Dir, fileName), nil, parser.ParseComments)
+		if err != nil {
+			level.Warn(p.logger).Log("msg", "error parsing file for critical functions, skipping it", "file", fileName, "err", err)
+			continue
+		}
+
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || !hasCriticalDirective(fn.Doc) {
+				continue
+			}
+			p.criticalFunctions = append(p.criticalFunctions, symbolName(p.symbolPrefix(), fn))
+		}
+	}
+}
+
+func hasCriticalDirective(doc *ast.CommentGroup) bool {
+	if doc == nil {
+		return false
+	}
+	for _, c := range doc.List {
+		if strings.TrimSpace(c.Text) == criticalDirective {
+			return true
+		}
+	}
+	return false
+}
+
+// symbolPrefix returns the prefix of the package's symbols.
+func (p *Package) symbolPrefix() string {
+	if p.meta.Name == "main" {
+		return "main"
+	}
+	return escapeImportPath(p.meta.ImportPath)
+}
+
+// symbolName returns the name of the function as it shows up in profiles.
+func symbolName(prefix string, fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) == 0 {
+		return prefix + "." + fn.Name.Name
+	}
+
+	typ := fn.Recv.List[0].Type
+	star, ok := typ.(*ast.StarExpr)
+	if ok {
+		typ = star.X
+	}
+	var generic bool
+	switch t := typ.(type) {
+	case *ast.IndexExpr:
+		typ, generic = t.X, true
+	case *ast.IndexListExpr:
+		typ, generic = t.X, true
+	}
+
+	var recv string
+	if ident, ok := typ.(*ast.Ident); ok {
+		recv = ident.Name
+	}
+	if generic {
+		recv += "[...]"
+	}
+	if star != nil {
+		recv = "(*" + recv + ")"
+	}
+	return prefix + "." + recv + "." + fn.Name.Name
+}
+
+// functionTotals returns the cumulative values of the given functions, all
+// when names is nil, in a profile with a single sample type.
+func functionTotals(p *profile.Profile, names map[string]struct{}) map[string]int64 {
+	totals := make(map[string]int64)
+	for _, s := range p.Sample {
+		// recursive functions must only be counted once per sample
+		seen := make(map[string]struct{})
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				if line.Function == nil {
+					continue
+				}
+				name := line.Function.Name
+				if _, ok := names[name]; !ok && names != nil {
+					continue
+				}
+				if _, ok := seen[name]; ok {
+					continue
+				}
+				seen[name] = struct{}{}
+				totals[name] += s.Value[0]
+			}
+		}
+	}
+	return totals
+}
+
+// criticalFunctionChanges returns the critical functions, whose cumulative
+// value changed by more than threshold percent between base and head.
+func criticalFunctionChanges(base, head *profile.Profile, baseScale float64, names map[string]struct{}, threshold float64) []report.CriticalFunction {
+	baseTotals := functionTotals(base, names)
+	headTotals := functionTotals(head, names)
+
+	var result []report.CriticalFunction
+	for name, baseTotal := range baseTotals {
+		scaled := float64(baseTotal) * baseScale
+		if scaled == 0 {
+			continue
+		}
+		diff := (float64(headTotals[name]) - scaled) / scaled * 100
+		if diff > threshold || diff < -threshold {
+			result = append(result, report.CriticalFunction{Name: name, Diff: diff})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// checkCriticalFunctions records the critical functions, which changed
+// significantly, on the results of the benchmark.
+func (b *Benchmark) checkCriticalFunctions(r *benchWithKey, threshold float64) {
+	if len(b.criticalFunctions) == 0 {
+		return
+	}
+	baseScale, ok := r.baseScale()
+	if !ok {
+		return
+	}
+
+	for _, x := range r.profilePairs() {
+		if x.base.profile == nil || x.head.profile == nil {
+			continue
+		}
+		changes := criticalFunctionChanges(x.base.profile, x.head.profile, baseScale, b.criticalFunctions, threshold)
+		for idx := range r.results {
+			if r.results[idx].Name == x.name {
+				r.results[idx].CriticalFunctions = changes
+			}
+		}
+	}
+}
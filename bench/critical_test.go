@@ -0,0 +1,104 @@
+package bench
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestCriticalFunctionSymbols(t *testing.T) {
+	src := `package foo
+
+//pyrobench:critical
+func Plain() {}
+
+// Method is documented.
+//
+//pyrobench:critical
+func (t *T) Method() {}
+
+//pyrobench:critical
+func (t T) Value() {}
+
+//pyrobench:critical
+func (l *List[E]) Push(e E) {}
+
+func NotCritical() {}
+`
+	file, err := parser.ParseFile(token.NewFileSet(), "foo.go", src, parser.ParseComments)
+	require.NoError(t, err)
+
+	var names []string
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || !hasCriticalDirective(fn.Doc) {
+			continue
+		}
+		names = append(names, symbolName("example.com/foo", fn))
+	}
+	require.Equal(t, []string{
+		"example.com/foo.Plain",
+		"example.com/foo.(*T).Method",
+		"example.com/foo.T.Value",
+		"example.com/foo.(*List[...]).Push",
+	}, names)
+}
+
+func TestCriticalFunctionChanges(t *testing.T) {
+	names := map[string]struct{}{"main.f0": {}, "main.f1": {}}
+
+	for _, tc := range []struct {
+		name      string
+		base      []int64
+		head      []int64
+		baseScale float64
+		expected  []report.CriticalFunction
+	}{
+		{
+			name:      "below threshold",
+			base:      []int64{1000, 1000},
+			head:      []int64{1005, 995},
+			baseScale: 1,
+		},
+		{
+			name:      "critical function regressed",
+			base:      []int64{1000, 1000},
+			head:      []int64{1000, 1100},
+			baseScale: 1,
+			expected:  []report.CriticalFunction{{Name: "main.f1", Diff: 10}},
+		},
+		{
+			name:      "base is scaled",
+			base:      []int64{500, 500},
+			head:      []int64{1000, 800},
+			baseScale: 2,
+			expected:  []report.CriticalFunction{{Name: "main.f1", Diff: -20}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			changes := criticalFunctionChanges(testCPUProfile(tc.base...), testCPUProfile(tc.head...), tc.baseScale, names, 1)
+			require.Equal(t, tc.expected, changes)
+		})
+	}
+}
+
+func TestListCriticalFunctionsSkipsUnparsableFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.go"), []byte("package foo\n\n//pyrobench:critical\nfunc Hot() {}\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "b.go"), []byte("package foo\n\nfunc Broken( {}\n"), 0o644))
+
+	p := &Package{
+		logger: log.NewNopLogger(),
+		meta:   &packageMeta{Dir: dir, ImportPath: "example.com/foo", Name: "foo", GoFiles: []string{"b.go", "a.go"}},
+	}
+	p.listCriticalFunctions()
+	require.Equal(t, []string{"example.com/foo.Hot"}, p.criticalFunctions)
+}
@@ -0,0 +1,116 @@
+package bench
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/google/pprof/profile"
+)
+
+const (
+	// profileDiffLocal computes the difference between base and head
+	// profiles locally and keeps it in the artifacts directory.
+	profileDiffLocal = "local"
+	// profileDiffRemote only links to the comparison view of flamegraph.com.
+	profileDiffRemote = "flamegraph.com"
+)
+
+func addProfileDiffArgs(cmd *kingpin.CmdClause, diff, artifactsDir *string) {
+	cmd.Flag("profile-diff", "How to show what changed between the base and head profiles. 'local' computes a diff profile (head - base) and keeps it in --artifacts-dir without uploading it, 'flamegraph.com' relies on its comparison view.").Default(profileDiffLocal).EnumVar(diff, profileDiffLocal, profileDiffRemote)
+	cmd.Flag("artifacts-dir", "Directory to keep the raw profiles, test output and benchfmt records of every run in, along with generated artifacts like diff profiles and a manifest.json of the commits and the environment.").PlaceHolder("DIR").StringVar(artifactsDir)
+}
+
+// artifactPath returns the path of an artifact belonging to a benchmark and
+// ensures its parent directory exists.
+func artifactPath(dir string, key benchKey, name string) (string, error) {
+	path := filepath.Join(
+		dir,
+		filepath.FromSlash(key.packagePath),
+		strings.ReplaceAll(key.benchmark, "/", "_"),
+		name,
+	)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// diffProfiles computes the diff profiles for every resource of a benchmark,
+// that has profiles for base and head, and writes them to the artifacts
+// directory. They are not uploaded, so no profile data leaves the machine for
+// them. Errors are only logged, as the comparison view of flamegraph.com is
+// still available.
+func (b *Benchmark) diffProfiles(r *benchWithKey, artifactsDir string) {
+	baseScale, ok := r.baseScale()
+	if !ok {
+		return
+	}
+
+	logger := log.With(b.logger, "package", r.key.packagePath, "benchmark", r.key.benchmark)
+	for _, x := range r.profilePairs() {
+		if x.base.profile == nil || x.head.profile == nil {
+			continue
+		}
+
+		if err := b.writeDiffProfile(r.key, x.name, x.base.profile, x.head.profile, baseScale, artifactsDir); err != nil {
+			level.Warn(logger).Log("msg", "error creating diff profile", "resource", x.name, "err", err)
+		}
+	}
+}
+
+// baseScale returns the factor to scale the base profiles with, to compare
+// them to the head profiles. The profiles cover all iterations of the
+// benchmark, so they are scaled by the ratio of iterations.
+func (r *bench) baseScale() (float64, bool) {
+	if r.baseResult == nil || r.headResult == nil {
+		return 0, false
+	}
+	baseIters, headIters := r.baseResult.iterations(), r.headResult.iterations()
+	if baseIters == 0 || headIters == 0 {
+		return 0, false
+	}
+	return float64(headIters) / float64(baseIters), true
+}
+
+type profilePair struct {
+	name       string
+	base, head *profileResult
+}
+
+// profilePairs returns the base and head profile results per resource. It
+// must only be called once both results are available.
+func (r *bench) profilePairs() []profilePair {
+	return []profilePair{
+		{"cpu", &r.baseResult.CPU, &r.headResult.CPU},
+		{"alloc_space", &r.baseResult.AllocSpace, &r.headResult.AllocSpace},
+		{"alloc_objects", &r.baseResult.AllocObjects, &r.headResult.AllocObjects},
+	}
+}
+
+func (b *Benchmark) writeDiffProfile(key benchKey, name string, base, head *profile.Profile, baseScale float64, artifactsDir string) error {
+	diff, err := diffProfile(base, head, baseScale)
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := diff.Write(buf); err != nil {
+		return fmt.Errorf("failed to write diff profile: %w", err)
+	}
+
+	path, err := artifactPath(artifactsDir, key, "diff-"+name+".pb.gz")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	level.Debug(b.logger).Log("msg", "wrote diff profile", "path", path)
+	return nil
+}
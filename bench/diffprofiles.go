@@ -0,0 +1,269 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/dustin/go-humanize"
+	"github.com/google/pprof/profile"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+const (
+	diffFormatMarkdown = "markdown"
+	diffFormatJSON     = "json"
+)
+
+type DiffProfilesArgs struct {
+	Base      string
+	Head      string
+	Format    string
+	BaseScale float64
+	Top       int
+	Upload    bool
+}
+
+func AddDiffProfilesCommand(app *kingpin.Application) (*kingpin.CmdClause, *DiffProfilesArgs) {
+	cmd := app.Command("diff-profiles", "Compare two pprof profiles and print the same comparison tables as the benchmark reports.")
+	args := &DiffProfilesArgs{}
+	cmd.Arg("base", "Path of the base profile.").Required().ExistingFileVar(&args.Base)
+	cmd.Arg("head", "Path of the head profile.").Required().ExistingFileVar(&args.Head)
+	cmd.Flag("format", "Output format.").Default(diffFormatMarkdown).EnumVar(&args.Format, diffFormatMarkdown, diffFormatJSON)
+	cmd.Flag("base-scale", "Factor to multiply the base values with, e.g. the ratio of head to base iterations.").Default("1").Float64Var(&args.BaseScale)
+	cmd.Flag("top", "Number of functions with the largest change to list per sample type.").Default("10").IntVar(&args.Top)
+	cmd.Flag("upload", "Upload the profiles and their diff to flamegraph.com and link them.").Default("false").BoolVar(&args.Upload)
+	return cmd, args
+}
+
+// profileComparison compares a single sample type of two profiles.
+type profileComparison struct {
+	Resource string `json:"resource"`
+	Unit     string `json:"unit"`
+	Base     int64  `json:"base"` // already scaled
+	Head     int64  `json:"head"`
+	// DiffPercent is the change relative to base, nil when base is zero.
+	DiffPercent *float64 `json:"diff_percent,omitempty"`
+
+	BaseFlamegraphKey string `json:"base_flamegraph_key,omitempty"`
+	HeadFlamegraphKey string `json:"head_flamegraph_key,omitempty"`
+	DiffFlamegraphKey string `json:"diff_flamegraph_key,omitempty"`
+
+	Functions []functionComparison `json:"functions,omitempty"`
+}
+
+// functionComparison compares the cumulative value of a function.
+type functionComparison struct {
+	Name        string   `json:"name"`
+	Base        int64    `json:"base"` // already scaled
+	Head        int64    `json:"head"`
+	DiffPercent *float64 `json:"diff_percent,omitempty"`
+}
+
+func diffPercent(base, head int64) *float64 {
+	if base == 0 {
+		return nil
+	}
+	d := float64(head-base) / float64(base) * 100
+	return &d
+}
+
+// compareProfiles compares every sample type present in both profiles. The
+// base values get multiplied by baseScale first.
+func compareProfiles(base, head *profile.Profile, baseScale float64, top int) ([]profileComparison, error) {
+	baseSubs := splitProfile(base)
+	headSubs := splitProfile(head)
+
+	var result []profileComparison
+	for _, st := range head.SampleType {
+		baseSub, ok := baseSubs[st.Type]
+		if !ok {
+			continue
+		}
+		headSub := headSubs[st.Type]
+
+		scaled := baseSub.Copy()
+		scaled.Scale(baseScale)
+
+		c := profileComparison{
+			Resource: st.Type,
+			Unit:     sampleUnit(st.Unit),
+			Base:     sumProfiles(scaled, 0),
+			Head:     sumProfiles(headSub, 0),
+		}
+		c.DiffPercent = diffPercent(c.Base, c.Head)
+		c.Functions = functionChanges(scaled, headSub, top)
+		result = append(result, c)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("the profiles have no sample type in common")
+	}
+	return result, nil
+}
+
+// functionChanges returns the top functions, whose cumulative value changed
+// the most in absolute terms.
+func functionChanges(base, head *profile.Profile, top int) []functionComparison {
+	if top <= 0 {
+		return nil
+	}
+	changes := topChanges(functionTotals(base, nil), functionTotals(head, nil), 1, top)
+	result := make([]functionComparison, 0, len(changes))
+	for _, c := range changes {
+		result = append(result, functionComparison{
+			Name:        c.Name,
+			Base:        c.Base,
+			Head:        c.Head,
+			DiffPercent: diffPercent(c.Base, c.Head),
+		})
+	}
+	return result
+}
+
+func readProfile(path string) (*profile.Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p, err := profile.Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse profile %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// DiffProfiles compares two profiles outside of a benchmark run and prints
+// the comparison to the output.
+func (b *Benchmark) DiffProfiles(ctx context.Context, args *DiffProfilesArgs) error {
+	base, err := readProfile(args.Base)
+	if err != nil {
+		return err
+	}
+	head, err := readProfile(args.Head)
+	if err != nil {
+		return err
+	}
+
+	comparisons, err := compareProfiles(base, head, args.BaseScale, args.Top)
+	if err != nil {
+		return err
+	}
+
+	if args.Upload {
+		if err := b.uploadComparisons(ctx, base, head, args.BaseScale, comparisons); err != nil {
+			return err
+		}
+	}
+
+	if args.Format == diffFormatJSON {
+		enc := json.NewEncoder(b.output)
+		enc.SetIndent("", "  ")
+		return enc.Encode(comparisons)
+	}
+	return writeComparisonsMarkdown(b.output, comparisons)
+}
+
+// uploadComparisons uploads base, head and diff profile of every sample type
+// and records their keys.
+func (b *Benchmark) uploadComparisons(ctx context.Context, base, head *profile.Profile, baseScale float64, comparisons []profileComparison) error {
+	baseSubs := splitProfile(base)
+	headSubs := splitProfile(head)
+
+	upload := func(p *profile.Profile) (string, error) {
+		buf := new(bytes.Buffer)
+		if err := p.Write(buf); err != nil {
+			return "", err
+		}
+		res, err := uploadProfile(ctx, b.logger, buf)
+		if err != nil {
+			return "", err
+		}
+		return res.Key, nil
+	}
+
+	for idx := range comparisons {
+		c := &comparisons[idx]
+		baseSub, headSub := baseSubs[c.Resource], headSubs[c.Resource]
+
+		var err error
+		if c.BaseFlamegraphKey, err = upload(baseSub); err != nil {
+			return fmt.Errorf("failed to upload base %s profile: %w", c.Resource, err)
+		}
+		if c.HeadFlamegraphKey, err = upload(headSub); err != nil {
+			return fmt.Errorf("failed to upload head %s profile: %w", c.Resource, err)
+		}
+		diff, err := diffProfile(baseSub, headSub, baseScale)
+		if err != nil {
+			return err
+		}
+		if c.DiffFlamegraphKey, err = upload(diff); err != nil {
+			return fmt.Errorf("failed to upload diff %s profile: %w", c.Resource, err)
+		}
+	}
+	return nil
+}
+
+var comparisonsMarkdownTemplate = template.Must(template.New("diff-profiles").Funcs(template.FuncMap{
+	"value": func(v int64, unit, key string) string {
+		s := (&report.BenchmarkValue{ProfileValue: v}).Format(unit)
+		if key == "" {
+			return s
+		}
+		return fmt.Sprintf("[%s](%s)", s, (&report.BenchmarkValue{FlamegraphKey: key}).FlamegraphURL())
+	},
+	"delta": func(base, head int64, unit string) string {
+		sign := "+"
+		d := head - base
+		if d < 0 {
+			sign = "-"
+		}
+		return sign + (&report.BenchmarkValue{ProfileValue: abs(d)}).Format(unit)
+	},
+	"percent": func(d *float64) string {
+		if d == nil {
+			return "n/a"
+		}
+		return humanize.CommafWithDigits(*d, 2) + " %"
+	},
+	"share": func(base, head string) string {
+		return (&report.BenchmarkResult{
+			BaseValue: report.BenchmarkValue{FlamegraphKey: base},
+			HeadValue: report.BenchmarkValue{FlamegraphKey: head},
+		}).DiffFlamegraphURL()
+	},
+	"changes": func(key string) string {
+		return (&report.BenchmarkResult{DiffFlamegraphKey: key}).ChangesFlamegraphURL()
+	},
+}).Parse(`| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+{{- range . }}
+| {{.Resource}} | {{value .Base .Unit .BaseFlamegraphKey}} | {{value .Head .Unit .HeadFlamegraphKey}} | {{ if and .BaseFlamegraphKey .HeadFlamegraphKey }}[{{percent .DiffPercent}}]({{share .BaseFlamegraphKey .HeadFlamegraphKey}}){{ else }}{{percent .DiffPercent}}{{ end }}{{ with .DiffFlamegraphKey }} ([what changed]({{changes .}})){{ end }} |
+{{- end }}
+{{- range . }}
+{{- if .Functions }}
+{{- $unit := .Unit }}
+
+<details>
+    <summary>{{.Resource}}: functions with the largest change</summary>
+
+| Function | Base | Head | Delta | Diff % |
+|----------|-----:|-----:|------:|-------:|
+{{- range .Functions }}
+| ` + "`{{.Name}}`" + ` | {{value .Base $unit ""}} | {{value .Head $unit ""}} | {{delta .Base .Head $unit}} | {{percent .DiffPercent}} |
+{{- end }}
+</details>
+{{- end }}
+{{- end }}
+`))
+
+func writeComparisonsMarkdown(w io.Writer, comparisons []profileComparison) error {
+	return comparisonsMarkdownTemplate.Execute(w, comparisons)
+}
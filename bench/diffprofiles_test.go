@@ -0,0 +1,77 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestProfile(t *testing.T, p *profile.Profile) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "profile.pb.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, p.Write(f))
+	return path
+}
+
+func TestDiffProfilesMarkdown(t *testing.T) {
+	out := new(bytes.Buffer)
+	b, err := New(nil, WithOutput(out, false))
+	require.NoError(t, err)
+
+	require.NoError(t, b.DiffProfiles(context.Background(), &DiffProfilesArgs{
+		Base:      writeTestProfile(t, testCPUProfile(10000000, 20000000, 5000000)),
+		Head:      writeTestProfile(t, testCPUProfile(10000000, 35000000, 0)),
+		Format:    diffFormatMarkdown,
+		BaseScale: 1,
+		Top:       10,
+	}))
+	require.Equal(t, "| Resource | Base | Head | Diff % |\n"+
+		"|----------|-----:|-----:|-------:|\n"+
+		"| cpu | 35 ms | 45 ms | 28.57 % |\n"+
+		"\n"+
+		"<details>\n"+
+		"    <summary>cpu: functions with the largest change</summary>\n"+
+		"\n"+
+		"| Function | Base | Head | Delta | Diff % |\n"+
+		"|----------|-----:|-----:|------:|-------:|\n"+
+		"| `main.f1` | 20 ms | 35 ms | +15 ms | 75 % |\n"+
+		"| `main.f2` | 5 ms | 0 s | -5 ms | -100 % |\n"+
+		"</details>\n", out.String())
+}
+
+func TestDiffProfilesJSON(t *testing.T) {
+	out := new(bytes.Buffer)
+	b, err := New(nil, WithOutput(out, false))
+	require.NoError(t, err)
+
+	require.NoError(t, b.DiffProfiles(context.Background(), &DiffProfilesArgs{
+		Base:      writeTestProfile(t, testCPUProfile(100, 200)),
+		Head:      writeTestProfile(t, testCPUProfile(150, 300)),
+		Format:    diffFormatJSON,
+		BaseScale: 2,
+		Top:       1,
+	}))
+
+	var comparisons []profileComparison
+	require.NoError(t, json.Unmarshal(out.Bytes(), &comparisons))
+	require.Len(t, comparisons, 1)
+	c := comparisons[0]
+	require.Equal(t, "cpu", c.Resource)
+	require.Equal(t, "ns", c.Unit)
+	require.Equal(t, int64(600), c.Base)
+	require.Equal(t, int64(450), c.Head)
+	require.InDelta(t, -25, *c.DiffPercent, 0.01)
+	require.Equal(t, []functionComparison{
+		{Name: "main.f1", Base: 400, Head: 300, DiffPercent: c.Functions[0].DiffPercent},
+	}, c.Functions)
+	require.InDelta(t, -25, *c.Functions[0].DiffPercent, 0.01)
+}
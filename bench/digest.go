@@ -0,0 +1,212 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/dustin/go-humanize"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/history"
+	"github.com/grafana/pyrobench/report"
+)
+
+const (
+	digestFormatMarkdown = "markdown"
+	digestFormatHTML     = "html"
+)
+
+type DigestArgs struct {
+	History   *history.Args
+	Since     string
+	Branch    string
+	Format    string
+	Top       int
+	Threshold float64
+}
+
+func AddDigestCommand(app *kingpin.Application) (*kingpin.CmdClause, *DigestArgs) {
+	cmd := app.Command("digest", "Summarize the performance movements on a branch recorded in the history.")
+	args := &DigestArgs{
+		History: history.AddArgs(cmd),
+	}
+	cmd.Flag("since", "Start of the period, either a duration like 7d, 2w, 36h or a date like 2024-08-01.").Default("7d").StringVar(&args.Since)
+	cmd.Flag("branch", "Branch whose first-parent history is summarized.").Default("HEAD").StringVar(&args.Branch)
+	cmd.Flag("format", "Output format.").Default(digestFormatMarkdown).EnumVar(&args.Format, digestFormatMarkdown, digestFormatHTML)
+	cmd.Flag("top", "Number of benchmarks to list per section.").Default("5").IntVar(&args.Top)
+	cmd.Flag("threshold", "Percentage a benchmark needs to move to be listed as regression or improvement.").Default("5").Float64Var(&args.Threshold)
+	return cmd, args
+}
+
+// parseSince parses the start of a period relative to now. Next to Go
+// durations, days and weeks are understood.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, s, now.Location()); err == nil {
+		return t, nil
+	}
+	var d time.Duration
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		days, err := strconv.Atoi(n)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid period %q", s)
+		}
+		d = time.Duration(days) * 24 * time.Hour
+	} else if n, ok := strings.CutSuffix(s, "w"); ok {
+		weeks, err := strconv.Atoi(n)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid period %q", s)
+		}
+		d = time.Duration(weeks) * 7 * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return time.Time{}, fmt.Errorf("invalid period %q", s)
+		}
+	}
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid period %q", s)
+	}
+	return now.Add(-d), nil
+}
+
+// Digest prints a summary of the largest regressions, improvements and the
+// noisiest benchmarks recorded for the branch within the period.
+func (b *Benchmark) Digest(ctx context.Context, args *DigestArgs) error {
+	if !args.History.Enabled() {
+		return errors.New("digest requires a history file to read the results from")
+	}
+	now := time.Now()
+	since, err := parseSince(args.Since, now)
+	if err != nil {
+		return err
+	}
+	store, err := history.NewStore(args.History)
+	if err != nil {
+		return err
+	}
+	records, err := store.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Pull requests record their head commits, too. Only the commits of the
+	// branch are of interest.
+	var commits []string
+	out, err := git("rev-list", "--first-parent", "--since", since.Format(time.RFC3339), "--end-of-options", args.Branch)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "unable to list commits of branch, using all records", "branch", args.Branch, "err", err)
+	} else if commits = strings.Fields(string(out)); len(commits) == 0 {
+		level.Warn(b.logger).Log("msg", "no commits on branch within the period", "branch", args.Branch, "since", since)
+		return writeDigest(b.output, args.Format, args.Branch, &history.Digest{Since: since, Until: now})
+	}
+
+	d := history.BuildDigest(records, since, now, commits, args.Threshold, args.Top)
+	return writeDigest(b.output, args.Format, args.Branch, d)
+}
+
+var digestFuncs = map[string]any{
+	"value": func(v float64, unit string) string {
+		if s := (&report.BenchmarkValue{ProfileValue: int64(v)}).Format(unit); s != "" {
+			return s
+		}
+		return strings.TrimSpace(humanize.CommafWithDigits(v, 2) + " " + unit)
+	},
+	"percent": func(d float64) string {
+		s := humanize.CommafWithDigits(d, 2) + " %"
+		if d > 0 {
+			s = "+" + s
+		}
+		return s
+	},
+	"short": func(commit string) string {
+		if len(commit) > 12 {
+			return commit[:12]
+		}
+		return commit
+	},
+	"date": func(t time.Time) string {
+		return t.Format(time.DateOnly)
+	},
+}
+
+var digestMarkdownTemplate = template.Must(template.New("digest").Funcs(digestFuncs).Parse(`
+{{- define "movements" }}
+| Benchmark | Resource | First | Last | Diff % | Largest step |
+|-----------|----------|------:|-----:|-------:|--------------|
+{{- range . }}
+| ` + "`{{.Benchmark}}`" + ` | {{.Resource}} | {{value .First .Unit}} | {{value .Last .Unit}} | {{percent .Diff}} | {{ if .Commit }}{{percent .StepDiff}} at ` + "`{{short .Commit}}`" + `{{ end }} |
+{{- end }}
+{{- end -}}
+
+## Performance digest of ` + "`{{.Branch}}`" + ` from {{date .Digest.Since}} to {{date .Digest.Until}}
+
+### Top regressions
+{{ if .Digest.Regressions }}{{ template "movements" .Digest.Regressions }}{{ else }}
+No regressions.{{ end }}
+
+### Top improvements
+{{ if .Digest.Improvements }}{{ template "movements" .Digest.Improvements }}{{ else }}
+No improvements.{{ end }}
+
+### Noisiest benchmarks
+{{ if .Digest.Noisiest }}
+| Benchmark | Resource | Median change between commits | Commits |
+|-----------|----------|------------------------------:|--------:|
+{{- range .Digest.Noisiest }}
+| ` + "`{{.Benchmark}}`" + ` | {{.Resource}} | {{percent .Noise}} | {{.Commits}} |
+{{- end }}{{ else }}
+Not enough measurements.{{ end }}
+`))
+
+var digestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest").Funcs(digestFuncs).Parse(`
+{{- define "movements" }}
+<table>
+<tr><th>Benchmark</th><th>Resource</th><th>First</th><th>Last</th><th>Diff %</th><th>Largest step</th></tr>
+{{- range . }}
+<tr><td><tt>{{.Benchmark}}</tt></td><td>{{.Resource}}</td><td>{{value .First .Unit}}</td><td>{{value .Last .Unit}}</td><td>{{percent .Diff}}</td><td>{{ if .Commit }}{{percent .StepDiff}} at <code>{{short .Commit}}</code>{{ end }}</td></tr>
+{{- end }}
+</table>
+{{- end -}}
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Performance digest of {{.Branch}}</title>
+</head>
+<body>
+<h2>Performance digest of <code>{{.Branch}}</code> from {{date .Digest.Since}} to {{date .Digest.Until}}</h2>
+<h3>Top regressions</h3>
+{{ if .Digest.Regressions }}{{ template "movements" .Digest.Regressions }}{{ else }}<p>No regressions.</p>{{ end }}
+<h3>Top improvements</h3>
+{{ if .Digest.Improvements }}{{ template "movements" .Digest.Improvements }}{{ else }}<p>No improvements.</p>{{ end }}
+<h3>Noisiest benchmarks</h3>
+{{ if .Digest.Noisiest }}
+<table>
+<tr><th>Benchmark</th><th>Resource</th><th>Median change between commits</th><th>Commits</th></tr>
+{{- range .Digest.Noisiest }}
+<tr><td><tt>{{.Benchmark}}</tt></td><td>{{.Resource}}</td><td>{{percent .Noise}}</td><td>{{.Commits}}</td></tr>
+{{- end }}
+</table>
+{{ else }}<p>Not enough measurements.</p>{{ end }}
+</body>
+</html>
+`))
+
+func writeDigest(w io.Writer, format, branch string, d *history.Digest) error {
+	data := struct {
+		Branch string
+		Digest *history.Digest
+	}{branch, d}
+	if format == digestFormatHTML {
+		return digestHTMLTemplate.Execute(w, data)
+	}
+	return digestMarkdownTemplate.Execute(w, data)
+}
@@ -0,0 +1,79 @@
+package bench
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/history"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 8, 20, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		in       string
+		expected time.Time
+		err      bool
+	}{
+		{in: "7d", expected: now.Add(-7 * 24 * time.Hour)},
+		{in: "2w", expected: now.Add(-14 * 24 * time.Hour)},
+		{in: "36h", expected: now.Add(-36 * time.Hour)},
+		{in: "2024-08-01", expected: time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{in: "xd", err: true},
+		{in: "-1d", err: true},
+		{in: "last week", err: true},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			actual, err := parseSince(tc.in, now)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestWriteDigest(t *testing.T) {
+	d := &history.Digest{
+		Since: time.Date(2024, 8, 13, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2024, 8, 20, 0, 0, 0, 0, time.UTC),
+		Regressions: []history.Movement{{
+			Benchmark: "pkg.BenchmarkA",
+			Resource:  "cpu",
+			Unit:      "ns",
+			First:     1e6,
+			Last:      1.5e6,
+			Diff:      50,
+			Commits:   4,
+			Commit:    "0123456789abcdef",
+			StepDiff:  48.5,
+		}},
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, writeDigest(buf, digestFormatMarkdown, "main", d))
+	require.Equal(t, "## Performance digest of `main` from 2024-08-13 to 2024-08-20\n"+
+		"\n"+
+		"### Top regressions\n"+
+		"\n"+
+		"| Benchmark | Resource | First | Last | Diff % | Largest step |\n"+
+		"|-----------|----------|------:|-----:|-------:|--------------|\n"+
+		"| `pkg.BenchmarkA` | cpu | 1 ms | 1.5 ms | +50 % | +48.5 % at `0123456789ab` |\n"+
+		"\n"+
+		"### Top improvements\n"+
+		"\n"+
+		"No improvements.\n"+
+		"\n"+
+		"### Noisiest benchmarks\n"+
+		"\n"+
+		"Not enough measurements.\n", buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeDigest(buf, digestFormatHTML, "<main>", d))
+	require.Contains(t, buf.String(), "<code>&lt;main&gt;</code>")
+	require.Contains(t, buf.String(), "<td>&#43;48.5 % at <code>0123456789ab</code></td>")
+}
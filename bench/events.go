@@ -0,0 +1,49 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
+	"github.com/grafana/pyrobench/report/events"
+)
+
+// openEvents opens the lifecycle events stream selected by the report
+// arguments and adds it to the context. The returned function closes it.
+func (b *Benchmark) openEvents(ctx context.Context, args *report.Args) (context.Context, func(), error) {
+	if args == nil || args.EventsPath == "" {
+		return ctx, func() {}, nil
+	}
+	w, err := events.Open(args.EventsPath)
+	if err != nil {
+		return ctx, nil, fmt.Errorf("error opening the events output: %w", err)
+	}
+	args.Events = w
+	return events.NewContext(ctx, w), func() {
+		if err := w.Close(); err != nil {
+			level.Warn(b.logger).Log("msg", "error writing events", "err", err)
+		}
+	}, nil
+}
+
+// emitDiscovery reports the number of packages and benchmarks to compare.
+func emitDiscovery(ctx context.Context, benchmarks []*benchWithKey) {
+	pkgs := make(map[string]struct{})
+	for _, r := range benchmarks {
+		pkgs[r.key.packagePath] = struct{}{}
+	}
+	events.FromContext(ctx).Emit(events.Event{
+		Type:       events.DiscoveryComplete,
+		Packages:   len(pkgs),
+		Benchmarks: len(benchmarks),
+	})
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,124 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// benchCommand is a single invocation of a package's test binary.
+type benchCommand struct {
+	args   []string // of the test binary
+	env    []string // added to the environment of the test binary
+	outDir string   // where the test binary writes its files to, referenced by args and env
+
+	timeout time.Duration // of the run, 0 when only the deadline of the context applies
+
+	stdout, stderr io.Writer
+	active         func() bool // whether a benchmark is running right now
+}
+
+// execution describes how the test binary ran.
+type execution struct {
+	state           *os.ProcessState // nil when the test binary ran remotely
+	cpu             *cpuUsage        // nil when not observed
+	started, exited time.Time
+	remote          bool     // setup and scheduling overheads are included in the times
+	leftovers       []string // files left in the scratch directories, nil when the test binary ran remotely
+}
+
+// executor runs test binaries. The files written to the command's outDir
+// must be available there, once run returns.
+type executor interface {
+	run(ctx context.Context, p *Package, cmd *benchCommand) (*execution, error)
+}
+
+// remoteTimeout returns the timeout a remote executor enforces for the run:
+// the one of the command, otherwise what is left until the deadline of ctx,
+// 0 without either. Unlike the deadline, the timeout does not shrink by the
+// time spent uploading the test binary.
+func (cmd *benchCommand) remoteTimeout(ctx context.Context) time.Duration {
+	if cmd.timeout > 0 {
+		return cmd.timeout
+	}
+	if d, ok := ctx.Deadline(); ok {
+		return time.Until(d)
+	}
+	return 0
+}
+
+func addExecutorToContext(ctx context.Context, e executor) context.Context {
+	return context.WithValue(ctx, contextKeyExecutor, e)
+}
+
+func executorFromContext(ctx context.Context) executor {
+	e, ok := ctx.Value(contextKeyExecutor).(executor)
+	if !ok {
+		return localExecutor{}
+	}
+	return e
+}
+
+// localExecutor runs the test binaries on this machine.
+type localExecutor struct {
+	cgroups *cgroupLimits // runs every test binary in a cgroup of its own, nil when disabled
+}
+
+func (l localExecutor) run(ctx context.Context, p *Package, cmd *benchCommand) (*execution, error) {
+	scratch, err := newScratchDirs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch directories: %w", err)
+	}
+	name, args, err := sandboxCommand(p.sandbox, p.sandboxSpec(scratch, cmd.outDir), p.testBinary, cmd.args)
+	if err != nil {
+		return nil, err
+	}
+	c := exec.CommandContext(ctx, name, args...)
+	c.Dir = p.workingDir()
+	setProcessGroup(c)
+	var cg *cgroup
+	if l.cgroups != nil {
+		var err error
+		if cg, err = l.cgroups.create(); err != nil {
+			return nil, fmt.Errorf("failed to create cgroup: %w", err)
+		}
+		defer func() {
+			if err := cg.remove(); err != nil {
+				// retry once everything else has exited
+				cleanupFromContext(ctx)(cg.remove)
+			}
+		}()
+		cg.apply(c)
+	}
+	// do not wait forever for orphaned children holding on to stdout/stderr
+	c.WaitDelay = 5 * time.Second
+	c.Stdout = cmd.stdout
+	c.Stderr = cmd.stderr
+	// the environment of the command may still override the temporary directory
+	c.Env = append(append(append(environFromContext(ctx), scratch.env()...), cmd.env...), worktreeEnv+"="+p.workdir)
+
+	e := &execution{started: time.Now()}
+	if err := c.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start benchmark %v: %w", append([]string{p.testBinary}, cmd.args...), err)
+	}
+	// the CPU usage of TestMain's setup and teardown is of no interest
+	sampler := startCPUSampler(c.Process.Pid, cmd.active)
+	err = c.Wait()
+	e.exited = time.Now()
+	e.cpu = sampler.stop()
+	e.state = c.ProcessState
+	leftovers, listErr := scratch.leftovers()
+	if listErr != nil {
+		level.Warn(p.logger).Log("msg", "failed to list the files left in the scratch directories", "err", listErr)
+	}
+	e.leftovers = leftovers
+	if err != nil && cg != nil && cg.oomKilled() {
+		err = fmt.Errorf("%w: killed for exceeding memory.max of its cgroup", err)
+	}
+	return e, err
+}
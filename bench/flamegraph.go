@@ -0,0 +1,87 @@
+package bench
+
+import (
+	"sort"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+const (
+	// flameDepth is the number of levels below the root kept for the
+	// flamegraphs embedded into the HTML report.
+	flameDepth = 24
+	// flameMinShare is the share of the total value in percent, below which
+	// frames are left out to keep the report small.
+	flameMinShare = 1
+)
+
+type flameFrame struct {
+	value    int64
+	children map[string]*flameFrame
+}
+
+func (f *flameFrame) child(name string) *flameFrame {
+	if f.children == nil {
+		f.children = make(map[string]*flameFrame)
+	}
+	c, ok := f.children[name]
+	if !ok {
+		c = &flameFrame{}
+		f.children[name] = c
+	}
+	return c
+}
+
+// node converts the frame and its children, which are at least minValue.
+func (f *flameFrame) node(name string, minValue int64) report.FlameNode {
+	n := report.FlameNode{Name: name, Value: f.value}
+	for childName, c := range f.children {
+		if c.value < minValue || c.value <= 0 {
+			continue
+		}
+		n.Children = append(n.Children, c.node(childName, minValue))
+	}
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	return n
+}
+
+// flamegraphTree aggregates the top levels of the flamegraph of a profile with
+// a single sample type. Inlined functions are frames of their own, like in the
+// flamegraphs of flamegraph.com. It returns nil for an empty profile.
+func flamegraphTree(p *profile.Profile) *report.FlameNode {
+	if p == nil {
+		return nil
+	}
+	root := &flameFrame{}
+	for _, s := range p.Sample {
+		v := s.Value[0]
+		if v <= 0 {
+			continue
+		}
+		root.value += v
+		f, depth := root, 0
+		// locations are ordered from the leaf to the root, as are the lines
+		// of a location with inlined functions
+		for i := len(s.Location) - 1; i >= 0 && depth < flameDepth; i-- {
+			lines := s.Location[i].Line
+			for j := len(lines) - 1; j >= 0 && depth < flameDepth; j-- {
+				name := "unknown"
+				if lines[j].Function != nil {
+					name = lines[j].Function.Name
+				}
+				f = f.child(name)
+				f.value += v
+				depth++
+			}
+		}
+	}
+	if root.value == 0 {
+		return nil
+	}
+	n := root.node("total", root.value*flameMinShare/100)
+	return &n
+}
@@ -0,0 +1,69 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestFlamegraphTree(t *testing.T) {
+	fn := func(id uint64, name string) *profile.Function {
+		return &profile.Function{ID: id, Name: name}
+	}
+	main, run, work, inlined, leaf, rare := fn(1, "main.main"), fn(2, "main.run"), fn(3, "main.work"), fn(4, "main.inlined"), fn(5, "main.leaf"), fn(6, "main.rare")
+	loc := func(id uint64, fns ...*profile.Function) *profile.Location {
+		l := &profile.Location{ID: id}
+		for _, f := range fns {
+			l.Line = append(l.Line, profile.Line{Function: f})
+		}
+		return l
+	}
+	mainLoc, runLoc, workLoc, leafLoc, rareLoc := loc(1, main), loc(2, run), loc(3, inlined, work), loc(4, leaf), loc(5, rare)
+	// the recursion below leaf is deeper than the flamegraph
+	recursion := []*profile.Location{leafLoc, workLoc, runLoc, mainLoc}
+	for i := 0; i < flameDepth; i++ {
+		recursion = append([]*profile.Location{leafLoc}, recursion...)
+	}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Sample: []*profile.Sample{
+			{Location: recursion, Value: []int64{600}},
+			{Location: []*profile.Location{runLoc, mainLoc}, Value: []int64{395}},
+			// less than a percent of the total
+			{Location: []*profile.Location{rareLoc, mainLoc}, Value: []int64{5}},
+		},
+	}
+	// main, run, work and its inlined function take the first four levels
+	leafNode := report.FlameNode{Name: "main.leaf", Value: 600}
+	for i := 5; i < flameDepth; i++ {
+		leafNode = report.FlameNode{Name: "main.leaf", Value: 600, Children: []report.FlameNode{leafNode}}
+	}
+
+	require.Equal(t, &report.FlameNode{
+		Name:  "total",
+		Value: 1000,
+		Children: []report.FlameNode{{
+			Name:  "main.main",
+			Value: 1000,
+			Children: []report.FlameNode{{
+				Name:  "main.run",
+				Value: 995,
+				Children: []report.FlameNode{{
+					Name:  "main.work",
+					Value: 600,
+					Children: []report.FlameNode{{
+						Name:     "main.inlined",
+						Value:    600,
+						Children: []report.FlameNode{leafNode},
+					}},
+				}},
+			}},
+		}},
+	}, flamegraphTree(p))
+
+	require.Nil(t, flamegraphTree(nil))
+	require.Nil(t, flamegraphTree(&profile.Profile{SampleType: p.SampleType}))
+}
@@ -0,0 +1,97 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/gerrit"
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestCompareGerritVote(t *testing.T) {
+	var (
+		mtx    sync.Mutex
+		labels []map[string]int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			Labels map[string]int `json:"labels"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		mtx.Lock()
+		labels = append(labels, in.Labels)
+		mtx.Unlock()
+		_, _ = w.Write([]byte(")]}'\n{}"))
+	}))
+	t.Cleanup(srv.Close)
+
+	repo := t.TempDir()
+	runGit(t, repo, "init", "--initial-branch", "main", ".")
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "go.mod"), []byte("module example.com/m\n\ngo 1.22\n"), 0o644))
+	writeBenchmark := func(extra int) {
+		require.NoError(t, os.WriteFile(filepath.Join(repo, "a_test.go"), []byte(fmt.Sprintf(`package m
+
+import "testing"
+
+var sink []byte
+
+func BenchmarkAlloc(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sink = make([]byte, 1024+%d)
+	}
+}
+`, extra)), 0o644))
+	}
+	writeBenchmark(1)
+	runGit(t, repo, "add", ".")
+	runGit(t, repo, "commit", "-m", "base")
+	// only benchmarks whose binary changed are compared
+	writeBenchmark(2)
+	runGit(t, repo, "commit", "-am", "head")
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(repo))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+
+	b, err := New(log.NewNopLogger())
+	require.NoError(t, err)
+	args := &CompareArgs{
+		BaseRef:      "HEAD~1",
+		BenchTime:    "100ms",
+		BenchCount:   1,
+		BenchTimeout: time.Minute,
+		Offline:      true,
+		ArtifactsDir: t.TempDir(),
+		// the few samples of the run are too noisy to tell regressions
+		Report: &report.Args{PercentageThreshold: math.MaxFloat64},
+		Gerrit: &gerrit.Args{URL: srv.URL, Change: "123", Label: "Verified", VoteOnRegression: -1, VoteOnSuccess: 1},
+	}
+	filter, err := args.filters(nil)
+	require.NoError(t, err)
+	updateCh := make(chan *report.BenchmarkReport)
+	reporter, err := b.newReporter(args, updateCh)
+	require.NoError(t, err)
+	rpt, err := b.compareWithReporter(context.Background(), args, updateCh, filter...)
+	require.NoError(t, reporter.Stop())
+	require.NoError(t, err)
+	require.True(t, rpt.Complete())
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	require.Equal(t, []map[string]int{{"Verified": 1}}, labels)
+}
@@ -1,22 +1,89 @@
 package bench
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/alecthomas/units"
+	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 
+	"github.com/grafana/pyrobench/config"
 	"github.com/grafana/pyrobench/github"
+	"github.com/grafana/pyrobench/history"
 	"github.com/grafana/pyrobench/report"
 )
 
-func AddGitHubCommentHookCommand(app *kingpin.Application) (*kingpin.CmdClause, *github.CommentHookArgs) {
+type GitHubCommentHookArgs struct {
+	*github.CommentHookArgs
+	History      *history.Args
+	Config       *config.Args
+	ProfileDiff  string
+	ArtifactsDir string
+	ArtifactsURL string
+	Preflight    string
+	BaseDir      string // already checked out base, skips fetching the pull request
+	HeadDir      string // already checked out head, skips fetching the pull request
+	BuildTags    string
+	BuildFlags   []string
+
+	MaxProfileSize   units.Base2Bytes
+	TraceRegressions bool
+	PGO              bool
+	GCTrace          bool
+	MaxTotalDuration time.Duration
+	QuickEstimate    string
+
+	Executor    string
+	Kubernetes  *KubernetesArgs
+	Agent       *AgentExecutorArgs
+	Pyroscope   *PyroscopeArgs
+	BinaryCache *BinaryCacheArgs
+	Cgroup      *CgroupArgs
+	ScrubEnv    *ScrubEnvArgs
+	Sandbox     *SandboxArgs
+}
+
+func AddGitHubCommentHookCommand(app *kingpin.Application) (*kingpin.CmdClause, *GitHubCommentHookArgs) {
 	cmd := app.Command("github-comment-hook", "Use this in a Github comment workflow to add benchmarks to your repo.")
-	return cmd, github.AddCommentHookArgs(cmd)
+	return cmd, addGitHubCommentHookArgs(cmd, github.AddCommentHookArgs(cmd))
+}
+
+func addGitHubCommentHookArgs(cmd *kingpin.CmdClause, hookArgs *github.CommentHookArgs) *GitHubCommentHookArgs {
+	args := &GitHubCommentHookArgs{
+		CommentHookArgs: hookArgs,
+		History:         history.AddArgs(cmd),
+		Config:          config.AddArgs(cmd),
+		Kubernetes:      &KubernetesArgs{},
+		Agent:           &AgentExecutorArgs{},
+		Pyroscope:       addPyroscopeArgs(cmd),
+		BinaryCache:     addBinaryCacheArgs(cmd, true), // binaries of pull requests are built from untrusted code
+		Cgroup:          addCgroupArgs(cmd),
+		ScrubEnv:        addScrubEnvArgs(cmd, true), // the head of a pull request is untrusted code
+		Sandbox:         addSandboxArgs(cmd, true),  // the head of a pull request is untrusted code
+	}
+	addProfileDiffArgs(cmd, &args.ProfileDiff, &args.ArtifactsDir)
+	addTraceArgs(cmd, &args.TraceRegressions, &args.ArtifactsURL)
+	addExecutorArgs(cmd, &args.Executor, args.Kubernetes, args.Agent)
+	addMaxProfileSizeArg(cmd, &args.MaxProfileSize)
+	addPreflightArg(cmd, &args.Preflight)
+	addCheckoutDirArgs(cmd, &args.BaseDir, &args.HeadDir)
+	addBuildArgs(cmd, &args.BuildTags, &args.BuildFlags)
+	addPGOArg(cmd, &args.PGO)
+	addGCTraceArg(cmd, &args.GCTrace)
+	addMaxTotalDurationArg(cmd, &args.MaxTotalDuration)
+	addQuickEstimateArg(cmd, &args.QuickEstimate)
+	return args
 }
 
-func (b *Benchmark) GitHubCommentHook(ctx context.Context, args *github.CommentHookArgs) error {
+func (b *Benchmark) GitHubCommentHook(ctx context.Context, args *GitHubCommentHookArgs) error {
 	cleaner := &cleaner{}
 	ctx = addCleanupToContext(ctx, cleaner.add)
 	defer func() {
@@ -26,70 +93,437 @@ func (b *Benchmark) GitHubCommentHook(ctx context.Context, args *github.CommentH
 		}
 	}()
 
-	err := b.prerequisites(ctx)
+	ctx, closeEvents, err := b.openEvents(ctx, args.Reporter)
+	if err != nil {
+		return err
+	}
+	defer closeEvents()
+
+	err = b.prerequisites(ctx)
 	if err != nil {
 		return fmt.Errorf("error checking prerequisites: %w", err)
 	}
+	if (args.BaseDir == "") != (args.HeadDir == "") {
+		return errors.New("--base-dir and --head-dir need to be used together")
+	}
 
-	gch, err := github.NewCommentHook(ctx, b.logger, args)
+	gch, err := github.NewCommentHook(ctx, b.logger, args.CommentHookArgs)
 	if err != nil {
 		return err
 	}
 
+	var constructors []report.NewReporterFunc
+	// the check run can replace the comment
+	if args.Reporter.GitHubCommenter || !args.Reporter.GitHubCheckRun {
+		constructors = append(constructors, gch.Reporter)
+	}
+	if args.Reporter.GitHubCheckRun {
+		constructors = append(constructors, b.checkRunReporter(args.Args, args.Reporter))
+	}
+	constructors = append(constructors, b.fileReporters(args.Reporter)...)
 	updateCh := make(chan *report.BenchmarkReport)
-	reporter, err := gch.Reporter(updateCh)
+	reporter, err := report.NewMulti(updateCh, constructors...)
 	if err != nil {
 		return err
 	}
-	defer reporter.Stop()
+	defer func() {
+		// reruns replace the reporter
+		_ = reporter.Stop()
+	}()
 
 	r, err := gch.ParseBenchmarks(ctx)
 	if err != nil {
 		updateCh <- b.generateReport(nil).WithError(err)
 		return err
 	}
+	switch {
+	case r.Invalid != nil:
+		return b.replyHelp(ctx, args, r, &report.CommandHelp{
+			BotName: gch.BotName(),
+			Command: r.Invalid.Command,
+			Problem: r.Invalid.Error(),
+			Usage:   true,
+		}, true, updateCh)
+	case r.Command == github.CommandHelp:
+		return b.replyHelp(ctx, args, r, &report.CommandHelp{BotName: gch.BotName(), Usage: true}, false, updateCh)
+	case r.Command == github.CommandList:
+		return b.replyHelp(ctx, args, r, &report.CommandHelp{BotName: gch.BotName()}, true, updateCh)
+	case r.Command == github.CommandCancel:
+		// the running benchmarks watch for the command themselves
+		if err := gch.Acknowledge(ctx); err != nil {
+			level.Warn(b.logger).Log("msg", "failed to acknowledge command", "err", err)
+		}
+		return nil
+	}
 	if len(r.Filter) == 0 {
 		// nothing to do, pyrobench has most likely not been mentioned
 		level.Info(b.logger).Log("msg", "no command supplied, nothing to do")
 		return nil
 	}
 
-	// ensure the codebase is checked out
-	if _, err := git("init", "."); err != nil {
-		return fmt.Errorf("error git init: %w", err)
+	for run := 1; ; run++ {
+		runCtx, cancel := gch.WatchCancel(ctx, r.HeadSHA)
+		err = b.comparePullRequest(runCtx, args, gch, r, updateCh)
+		cause := context.Cause(runCtx)
+		cancel()
+
+		var superseded *github.SupersededError
+		if err != nil || !errors.As(cause, &superseded) {
+			return err
+		}
+		if args.BaseDir != "" || run == maxSupersededRuns {
+			// the workflow checked out the outdated head
+			level.Warn(b.logger).Log("msg", "not rerunning superseded benchmarks", "head", superseded.HeadSHA, "runs", run)
+			return nil
+		}
+		r, err = gch.Refresh(ctx, r)
+		if err != nil {
+			return err
+		}
+		level.Info(b.logger).Log("msg", "rerunning superseded benchmarks", "head", r.HeadSHA)
+
+		// the superseded report is kept, the new run reports separately
+		_ = reporter.Stop()
+		b = b.fresh()
+		updateCh = make(chan *report.BenchmarkReport)
+		reporter, err = report.NewMulti(updateCh, constructors...)
+		if err != nil {
+			return err
+		}
 	}
+}
 
-	remote := "origin"
-	if _, err := git("remote", "add", remote, r.GitURL); err != nil {
-		return fmt.Errorf("error git remote add: %w", err)
+// baseConfig returns the configuration limiting the benchmarks requested in
+// the comment. Unless a configuration file has been given explicitly, it is
+// read from base, so a pull request can neither raise its own limits nor
+// relax the policy.
+func (b *Benchmark) baseConfig(args *GitHubCommentHookArgs, gitBase string) (*config.Config, error) {
+	var (
+		cfg *config.Config
+		err error
+	)
+	switch {
+	case args.Config != nil && args.Config.Path != "":
+		cfg, err = config.Load(args.Config)
+	case args.BaseDir != "":
+		path := filepath.Join(args.BaseDir, config.FileName)
+		if _, statErr := os.Stat(path); statErr != nil {
+			return &config.Config{}, nil
+		}
+		cfg, err = config.Load(&config.Args{Path: path})
+	default:
+		data, gitErr := gitIn(b.dir, nil, "show", gitBase+":"+config.FileName)
+		if gitErr != nil {
+			level.Debug(b.logger).Log("msg", "no configuration in base, using the default limits", "err", gitErr)
+			return &config.Config{}, nil
+		}
+		cfg, err = config.Parse(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading the configuration of base: %w", err)
 	}
+	return cfg, nil
+}
 
-	if _, err := git("fetch", "--depth", "1", remote, r.Base); err != nil {
-		return fmt.Errorf("error fetching base: %w", err)
+// checkFilterLimits checks the benchtime and the flags of the filter against
+// the limits of the configuration.
+func checkFilterLimits(limits *config.Limits, f *BenchmarkFilter) error {
+	if f.Time != nil {
+		if err := limits.CheckBenchTime(*f.Time); err != nil {
+			return err
+		}
+	}
+	if f.Count != nil && *f.Count < 1 {
+		return fmt.Errorf("invalid count %d", *f.Count)
+	}
+	for _, flag := range f.Flags {
+		if err := limits.CheckTestFlag(flag); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// maxSupersededRuns limits how often benchmarks are rerun, when new commits
+// are pushed while they are running.
+const maxSupersededRuns = 3
 
-	if _, err := git("fetch", "--depth", "1", remote, r.Head+":head"); err != nil {
-		return fmt.Errorf("error fetching head: %w", err)
+// comparePullRequest checks out the pull request, unless the workflow did
+// already, and compares the benchmarks of the command, once they have been
+// authorized by the policy of base.
+func (b *Benchmark) comparePullRequest(ctx context.Context, args *GitHubCommentHookArgs, gch *github.CommentHook, r *github.CommentHookResult, updateCh chan *report.BenchmarkReport) error {
+	// ensure the codebase is checked out, unless the workflow did already
+	var (
+		gitBase string
+		err     error
+	)
+	if args.BaseDir == "" {
+		gitBase, err = checkoutPullRequest(b.dir, args.Token, r)
+		if err != nil {
+			updateCh <- b.generateReport(nil).WithError(err)
+			return err
+		}
 	}
 
-	if _, err := git("checkout", "head"); err != nil {
-		return fmt.Errorf("error checking out head: %w", err)
+	baseCfg, err := b.baseConfig(args, gitBase)
+	if err != nil {
+		updateCh <- b.generateReport(nil).WithError(err)
+		return err
 	}
+	limits := &baseCfg.Limits
 
-	filters := make([]*BenchmarkFilter, 0, len(r.Filter))
+	var (
+		filters []*BenchmarkFilter
+		changed []string // files of the pull request, listed for the first path
+	)
 	for _, f := range r.Filter {
-		filters = append(filters, &BenchmarkFilter{
-			Filter: f.Regex.Regexp,
-			Time:   f.Time,
-			Count:  f.Count,
-		})
-	}
-
-	return b.compareWithReporter(ctx, &CompareArgs{
-		BenchTime:  "2s",
-		BenchCount: 5,
-		Report:     args.Reporter,
-		GitBase:    remote + "/" + r.Base,
+		var selected []*BenchmarkFilter
+		switch {
+		case f.Suite != nil:
+			// the suites are read from base like the limits
+			s, err := baseCfg.Suite(*f.Suite)
+			if err != nil {
+				updateCh <- b.generateReport(nil).WithError(err)
+				return err
+			}
+			filter, err := suiteFilter(s)
+			if err != nil {
+				updateCh <- b.generateReport(nil).WithError(err)
+				return err
+			}
+			selected = append(selected, filter)
+		case f.Path != nil:
+			if changed == nil {
+				if changed, err = gch.ChangedFiles(ctx); err != nil {
+					updateCh <- b.generateReport(nil).WithError(err)
+					return err
+				}
+			}
+			// the paths are mapped to suites by the configuration of base
+			selected, err = pathFilters(baseCfg, *f.Path, changed)
+			if err != nil {
+				updateCh <- b.generateReport(nil).WithError(err)
+				return err
+			}
+			level.Info(b.logger).Log("msg", "selected benchmarks by the changed files", "path", *f.Path, "filters", len(selected))
+		default:
+			selected = append(selected, &BenchmarkFilter{Filter: f.Regex.Regexp})
+		}
+		for _, filter := range selected {
+			// options of the comment take precedence over the suite's
+			if f.Time != nil {
+				filter.Time = f.Time
+			}
+			if f.Count != nil {
+				filter.Count = f.Count
+			}
+			// the flags of the comment are passed last and override the suite's
+			filter.Flags = append(filter.Flags[:len(filter.Flags):len(filter.Flags)], f.Flags...)
+			if f.Dir != nil {
+				filter.Dir = *f.Dir
+			}
+			// the options of suites are limited like the ones of the comment
+			if err := checkFilterLimits(limits, filter); err != nil {
+				err = fmt.Errorf("benchmark %s: %w", f, err)
+				updateCh <- b.generateReport(nil).WithError(err)
+				return err
+			}
+			filters = append(filters, filter)
+		}
+	}
+	if len(filters) == 0 {
+		msg := "no benchmarks to run, the pull request changes no files below the requested paths"
+		updateCh <- b.generateReport(nil).WithMessage(msg).WithFinished()
+		level.Info(b.logger).Log("msg", msg)
+		return nil
+	}
+
+	_, err = b.compareWithReporter(ctx, &CompareArgs{
+		BenchTime:    github.DefaultBenchTime,
+		BenchCount:   github.DefaultBenchCount,
+		BenchTimeout: 15 * time.Minute,
+		ProfileDiff:  args.ProfileDiff,
+		ArtifactsDir: args.ArtifactsDir,
+		ArtifactsURL: args.ArtifactsURL,
+		Preflight:    args.Preflight,
+		Report:       args.Reporter,
+		History:      args.History,
+		BaseRef:      gitBase,
+		BaseDir:      args.BaseDir,
+		HeadDir:      args.HeadDir,
+		BuildTags:    args.BuildTags,
+		BuildFlags:   args.BuildFlags,
+		Config:       args.Config,
+		// hooks, services and fixtures are run on the runner, so they are
+		// taken from base like the limits and the policy
+		TrustedConfig: baseCfg,
+		// the policy of base is checked, once the benchmarks the request
+		// matches are known
+		Authorize: func(requested time.Duration) (*report.Request, error) {
+			if err := gch.Authorize(ctx, &baseCfg.Policy, r, requested); err != nil {
+				return nil, err
+			}
+			return &report.Request{Author: r.Author, Time: requested}, nil
+		},
+
+		MaxProfileSize:   args.MaxProfileSize,
+		GCTrace:          args.GCTrace,
+		TraceRegressions: args.TraceRegressions,
+		PGO:              args.PGO,
+		MaxTotalDuration: args.MaxTotalDuration,
+		QuickEstimate:    args.QuickEstimate,
+		TopFunctions:     10,
+		Executor:         args.Executor,
+		Kubernetes:       args.Kubernetes,
+		Agent:            args.Agent,
+		Pyroscope:        args.Pyroscope,
+		BinaryCache:      args.BinaryCache,
+		Cgroup:           args.Cgroup,
+		ScrubEnv:         args.ScrubEnv,
+		Sandbox:          args.Sandbox,
 	}, updateCh, filters...)
+	return err
+}
+
+// maxHelpBenchmarks limits the number of benchmarks listed in the usage.
+const maxHelpBenchmarks = 100
+
+// replyHelp replies to a command with the help, optionally listing the
+// benchmarks available in head of the pull request.
+func (b *Benchmark) replyHelp(ctx context.Context, args *GitHubCommentHookArgs, r *github.CommentHookResult, help *report.CommandHelp, withBenchmarks bool, updateCh chan<- *report.BenchmarkReport) error {
+	if !withBenchmarks {
+		updateCh <- b.generateReport(nil).WithHelp(help)
+		return nil
+	}
+
+	headDir := args.HeadDir
+	if headDir == "" {
+		if _, err := checkoutPullRequest(b.dir, args.Token, r); err != nil {
+			updateCh <- b.generateReport(nil).WithError(err)
+			return err
+		}
+		var err error
+		if headDir, err = b.workDir(); err != nil {
+			updateCh <- b.generateReport(nil).WithError(err)
+			return err
+		}
+	}
+	buildArgs := (&CompareArgs{BuildTags: args.BuildTags, BuildFlags: args.BuildFlags}).buildArgs()
+	var err error
+	help.Benchmarks, help.MoreBenchmarks, err = availableBenchmarks(ctx, b.logger, headDir, buildArgs, maxHelpBenchmarks)
+	if err != nil {
+		// the usage is helpful without them
+		level.Warn(b.logger).Log("msg", "failed to list benchmarks", "err", err)
+	}
+
+	updateCh <- b.generateReport(nil).WithHelp(help)
+	return nil
+}
+
+// availableBenchmarks lists up to limit benchmarks found in dir by the
+// directory of their package. It returns the number of benchmarks omitted.
+func availableBenchmarks(ctx context.Context, logger log.Logger, dir string, buildArgs []string, limit int) ([]report.PackageBenchmarks, int, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+	pkgs, err := discoverPackages(ctx, logger, dir, []string{"./..."}, buildArgs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		result []report.PackageBenchmarks
+		listed int
+		more   int
+	)
+	for idx := range pkgs {
+		p := &pkgs[idx]
+		if err := p.listBenchmarksAst(ctx, nil); err != nil {
+			return nil, 0, err
+		}
+		if len(p.benchmarkNames) == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(dir, p.meta.Dir)
+		if err != nil {
+			return nil, 0, err
+		}
+		pb := report.PackageBenchmarks{Dir: filepath.ToSlash(rel)}
+		for _, bm := range p.benchmarkNames {
+			if listed >= limit {
+				more++
+				continue
+			}
+			pb.Names = append(pb.Names, bm.Name)
+			listed++
+		}
+		if len(pb.Names) > 0 {
+			result = append(result, pb)
+		}
+	}
+	return result, more, nil
+}
+
+// gitAuthEnv returns the environment to authenticate git's HTTP requests with
+// the token, without persisting it in the repository's config or exposing it
+// in the process arguments.
+func gitAuthEnv(token string) []string {
+	if token == "" {
+		return nil
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraheader",
+		"GIT_CONFIG_VALUE_0=AUTHORIZATION: basic " + auth,
+	}
+}
+
+// checkoutPullRequest shallow clones base and head of the PR into dir, the
+// working directory when empty, and checks out the head, the exact head commit
+// when known. It returns the revision of the base.
+func checkoutPullRequest(dir, token string, r *github.CommentHookResult) (string, error) {
+	const remote = "origin"
+	env := gitAuthEnv(token)
+
+	if _, err := gitIn(dir, nil, "init", "."); err != nil {
+		return "", fmt.Errorf("error git init: %w", err)
+	}
+
+	// the working directory might be reused
+	if _, err := gitIn(dir, nil, "remote", "get-url", remote); err == nil {
+		if _, err := gitIn(dir, nil, "remote", "set-url", remote, r.GitURL); err != nil {
+			return "", fmt.Errorf("error git remote set-url: %w", err)
+		}
+	} else if _, err := gitIn(dir, nil, "remote", "add", remote, r.GitURL); err != nil {
+		return "", fmt.Errorf("error git remote add: %w", err)
+	}
+
+	// prefer the exact base commit, the base branch might have moved on
+	baseRev := remote + "/" + r.Base
+	baseFetch := r.Base
+	if r.BaseSHA != "" {
+		baseRev = r.BaseSHA
+		baseFetch = r.BaseSHA
+	}
+	if _, err := gitIn(dir, env, "fetch", "--depth", "1", remote, baseFetch); err != nil {
+		return "", fmt.Errorf("error fetching base: %w", err)
+	}
+
+	// pin head to the commit the benchmarks were requested for, so commits
+	// pushed meanwhile neither get benchmarked nor reported on
+	headFetch := r.Head
+	if r.HeadSHA != "" {
+		headFetch = r.HeadSHA
+	}
+	if _, err := gitIn(dir, env, "fetch", "--depth", "1", remote, headFetch); err != nil {
+		return "", fmt.Errorf("error fetching head: %w", err)
+	}
+
+	if _, err := gitIn(dir, nil, "checkout", "--force", "--detach", "FETCH_HEAD"); err != nil {
+		return "", fmt.Errorf("error checking out head: %w", err)
+	}
 
+	return baseRev, nil
 }
@@ -0,0 +1,201 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log/level"
+)
+
+type GoEnvArgs struct {
+	Flags     string // GOFLAGS for listing and compiling, inherited when empty
+	Private   string // GOPRIVATE, inherited when empty
+	ModCache  string // GOMODCACHE shared by the checkouts, inherited when empty
+	Platform  string // GOOS/GOARCH to cross-compile the test binaries for, the host's when empty
+	Toolchain string // base, head or system to build both with, selected per checkout when empty
+}
+
+func addGoEnvArgs(cmd *kingpin.CmdClause) *GoEnvArgs {
+	args := &GoEnvArgs{}
+	cmd.Flag("goflags", "GOFLAGS to list and compile the packages of base and head with, instead of the ones configured in the working directory.").PlaceHolder("FLAGS").StringVar(&args.Flags)
+	cmd.Flag("goprivate", "Glob patterns of private module paths, which are fetched directly and not checked against the checksum database, instead of GOPRIVATE configured in the working directory.").PlaceHolder("PATTERNS").StringVar(&args.Private)
+	cmd.Flag("gomodcache", "Module cache shared by base and head, instead of GOMODCACHE configured in the working directory.").PlaceHolder("DIR").StringVar(&args.ModCache)
+	cmd.Flag("target-platform", "Cross-compile the test binaries for this platform, e.g. linux/arm64, so benchmarks behind build constraints of another platform can run. Requires an executor running them on that platform, like kubernetes.").PlaceHolder("GOOS/GOARCH").StringVar(&args.Platform)
+	cmd.Flag("toolchain", "Build base and head with the same Go toolchain, the one selected by base, by head or the installed one, instead of the one selected by the go and toolchain directives of each.").PlaceHolder("base|head|system").EnumVar(&args.Toolchain, toolchainBase, toolchainHead, toolchainSystem)
+	return args
+}
+
+// goEnvPropagated lists the settings of the go tool, which are resolved in
+// the working directory and passed on explicitly to the go commands run in the
+// worktrees. This way private modules and the module cache are set up alike
+// for base and head, regardless of where the worktrees are created.
+var goEnvPropagated = []string{"GOFLAGS", "GOPRIVATE", "GONOPROXY", "GONOSUMDB", "GOPROXY", "GOSUMDB", "GOINSECURE", "GOMODCACHE"}
+
+// resolveGoEnv returns the settings of goEnvPropagated as environment
+// variables, with the overrides of args applied.
+func resolveGoEnv(ctx context.Context, args *GoEnvArgs) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "go", append([]string{"env", "-json"}, goEnvPropagated...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error reading go env: %w", err)
+	}
+	var env map[string]string
+	if err := json.Unmarshal(out, &env); err != nil {
+		return nil, fmt.Errorf("error reading go env: %w", err)
+	}
+	if err := args.apply(env); err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(goEnvPropagated)+2)
+	for _, key := range goEnvPropagated {
+		if v := env[key]; v != "" {
+			result = append(result, key+"="+v)
+		}
+	}
+	if goos, goarch, ok := args.platform(); ok {
+		result = append(result, "GOOS="+goos, "GOARCH="+goarch)
+	}
+	return result, nil
+}
+
+// apply overrides the settings in env.
+func (args *GoEnvArgs) apply(env map[string]string) error {
+	if args == nil {
+		return nil
+	}
+	if args.Flags != "" {
+		env["GOFLAGS"] = args.Flags
+	}
+	if args.Private != "" {
+		// GONOPROXY and GONOSUMDB default to GOPRIVATE
+		for _, key := range []string{"GONOPROXY", "GONOSUMDB"} {
+			if env[key] == env["GOPRIVATE"] {
+				env[key] = args.Private
+			}
+		}
+		env["GOPRIVATE"] = args.Private
+	}
+	if args.ModCache != "" {
+		// the go tool requires an absolute path
+		dir, err := filepath.Abs(args.ModCache)
+		if err != nil {
+			return fmt.Errorf("invalid --gomodcache: %w", err)
+		}
+		env["GOMODCACHE"] = dir
+	}
+	return nil
+}
+
+// platform returns the target platform of --target-platform, ok is false
+// without one.
+func (args *GoEnvArgs) platform() (goos, goarch string, ok bool) {
+	if args == nil || args.Platform == "" {
+		return "", "", false
+	}
+	return strings.Cut(args.Platform, "/")
+}
+
+// toolchain returns the --toolchain to build base and head with, empty to
+// select it per checkout.
+func (args *GoEnvArgs) toolchain() string {
+	if args == nil {
+		return ""
+	}
+	return args.Toolchain
+}
+
+// validatePlatform checks the target platform can be run by the executor.
+func (args *GoEnvArgs) validatePlatform(executor string) error {
+	goos, goarch, ok := args.platform()
+	if args == nil || args.Platform == "" {
+		return nil
+	}
+	if !ok || goos == "" || goarch == "" || strings.Contains(goarch, "/") {
+		return fmt.Errorf("invalid --target-platform %q, expected GOOS/GOARCH", args.Platform)
+	}
+	if executor == executorLocal && (goos != runtime.GOOS || goarch != runtime.GOARCH) {
+		return fmt.Errorf("--target-platform %s can not be run by the local executor on %s/%s", args.Platform, runtime.GOOS, runtime.GOARCH)
+	}
+	return nil
+}
+
+func addGoEnvToContext(ctx context.Context, env []string) context.Context {
+	return context.WithValue(ctx, contextKeyGoEnv, env)
+}
+
+// goEnvFromContext returns the environment variables of the go commands, nil
+// when they inherit the environment unchanged.
+func goEnvFromContext(ctx context.Context) []string {
+	env, _ := ctx.Value(contextKeyGoEnv).([]string)
+	return env
+}
+
+func (b *Benchmark) goEnvContext(ctx context.Context, args *GoEnvArgs) (context.Context, error) {
+	env, err := resolveGoEnv(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	level.Debug(b.logger).Log("msg", "resolved go environment", "env", strings.Join(env, " "))
+	return addGoEnvToContext(ctx, env), nil
+}
+
+// goCommand returns the go command running in dir with the environment of the
+// context.
+func goCommand(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	c := exec.CommandContext(ctx, "go", args...)
+	c.Dir = dir
+	if env := goEnvFromContext(ctx); len(env) > 0 {
+		c.Env = append(os.Environ(), env...)
+	}
+	return c
+}
+
+// goEnvValue returns the last value of key in env, like it is looked up by
+// exec.Cmd.
+func goEnvValue(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if v, ok := strings.CutPrefix(env[i], key+"="); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// moduleBuildArgs returns the build args for the packages of the module in
+// root. Unless -mod is passed explicitly, modules with a vendor directory in
+// the checkout are built with -mod=vendor. Without a vendor directory GOFLAGS
+// asking for vendored modules are overridden with -mod=readonly, e.g. for a
+// base commit predating the vendor directory.
+func moduleBuildArgs(ctx context.Context, workdir, root string, buildArgs []string) []string {
+	if slices.ContainsFunc(buildArgs, func(arg string) bool {
+		return strings.HasPrefix(arg, "-mod=")
+	}) {
+		return buildArgs
+	}
+
+	// workspaces are vendored next to their go.work file
+	vendorRoot := root
+	if _, err := os.Stat(filepath.Join(workdir, "go.work")); err == nil {
+		vendorRoot = workdir
+	}
+	if _, err := os.Stat(filepath.Join(vendorRoot, "vendor", "modules.txt")); err == nil {
+		return append(slices.Clone(buildArgs), "-mod=vendor")
+	}
+
+	goflags, ok := goEnvValue(goEnvFromContext(ctx), "GOFLAGS")
+	if !ok {
+		goflags = os.Getenv("GOFLAGS")
+	}
+	if slices.Contains(strings.Fields(goflags), "-mod=vendor") {
+		return append(slices.Clone(buildArgs), "-mod=readonly")
+	}
+	return buildArgs
+}
@@ -0,0 +1,92 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveGoEnv(t *testing.T) {
+	t.Setenv("GOFLAGS", "-tags=inherited")
+	t.Setenv("GOPRIVATE", "example.com/private")
+	t.Setenv("GONOSUMDB", "")
+	t.Setenv("GONOPROXY", "example.com/proxied")
+
+	env, err := resolveGoEnv(context.Background(), nil)
+	require.NoError(t, err)
+	require.Contains(t, env, "GOFLAGS=-tags=inherited")
+	require.Contains(t, env, "GOPRIVATE=example.com/private")
+	require.Contains(t, env, "GONOSUMDB=example.com/private")
+	require.Contains(t, env, "GONOPROXY=example.com/proxied")
+	v, ok := goEnvValue(env, "GOMODCACHE")
+	require.True(t, ok)
+	require.True(t, filepath.IsAbs(v))
+
+	modCache := t.TempDir()
+	env, err = resolveGoEnv(context.Background(), &GoEnvArgs{
+		Flags:    "-mod=mod",
+		Private:  "git.corp.example.com",
+		ModCache: modCache,
+	})
+	require.NoError(t, err)
+	require.Contains(t, env, "GOFLAGS=-mod=mod")
+	require.Contains(t, env, "GOPRIVATE=git.corp.example.com")
+	// only the default follows GOPRIVATE
+	require.Contains(t, env, "GONOSUMDB=git.corp.example.com")
+	require.Contains(t, env, "GONOPROXY=example.com/proxied")
+	require.Contains(t, env, "GOMODCACHE="+modCache)
+
+	c := goCommand(addGoEnvToContext(context.Background(), env), modCache, "env", "GOFLAGS", "GOMODCACHE")
+	out, err := c.Output()
+	require.NoError(t, err)
+	require.Equal(t, "-mod=mod\n"+modCache+"\n", string(out))
+}
+
+func TestModuleBuildArgs(t *testing.T) {
+	writeFile := func(t *testing.T, path string) {
+		t.Helper()
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, nil, 0o644))
+	}
+	ctx := context.Background()
+	vendorCtx := addGoEnvToContext(ctx, []string{"GOFLAGS=-trimpath -mod=vendor"})
+
+	t.Run("vendored module", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "vendor", "modules.txt"))
+		require.Equal(t, []string{"-tags=x", "-mod=vendor"}, moduleBuildArgs(ctx, dir, dir, []string{"-tags=x"}))
+	})
+
+	t.Run("explicit -mod", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "vendor", "modules.txt"))
+		require.Equal(t, []string{"-mod=mod"}, moduleBuildArgs(ctx, dir, dir, []string{"-mod=mod"}))
+	})
+
+	t.Run("vendored workspace", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "go.work"))
+		writeFile(t, filepath.Join(dir, "vendor", "modules.txt"))
+		require.Equal(t, []string{"-mod=vendor"}, moduleBuildArgs(ctx, dir, filepath.Join(dir, "sub"), nil))
+	})
+
+	t.Run("not vendored", func(t *testing.T) {
+		dir := t.TempDir()
+		require.Empty(t, moduleBuildArgs(ctx, dir, dir, nil))
+		// a checkout without vendor directory can not use GOFLAGS=-mod=vendor
+		require.Equal(t, []string{"-mod=readonly"}, moduleBuildArgs(vendorCtx, dir, dir, nil))
+	})
+
+	t.Run("build args are not modified", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, "vendor", "modules.txt"))
+		buildArgs := make([]string, 1, 2)
+		buildArgs[0] = "-tags=x"
+		moduleBuildArgs(ctx, dir, dir, buildArgs)
+		require.Equal(t, "-tags=x", strings.Join(buildArgs[:cap(buildArgs)], ""))
+	})
+}
@@ -0,0 +1,265 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/go-kit/log/level"
+	"github.com/google/pprof/profile"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+const (
+	// goroutineDirEnv tells the injected TestMain where to write the
+	// goroutine profiles to.
+	goroutineDirEnv = "PYROBENCH_GOROUTINE_DIR"
+
+	// goroutineTestFile is the name of the test file injected into the
+	// package.
+	goroutineTestFile = "zz_pyrobench_goroutines_test.go"
+
+	// maxLeakedStacks limits the number of stacks listed per benchmark.
+	maxLeakedStacks = 5
+)
+
+// goroutineTestMain records the goroutines before and after the benchmarks.
+// The imports are renamed, so they do not collide with identifiers of the
+// package. Goroutines winding down get a second to exit.
+var goroutineTestMain = template.Must(template.New("goroutines").Parse(`// Code generated by pyrobench. DO NOT EDIT.
+
+package {{.}}
+
+import (
+	pyrobenchos "os"
+	pyrobenchfilepath "path/filepath"
+	pyrobenchruntime "runtime"
+	pyrobenchpprof "runtime/pprof"
+	pyrobenchtesting "testing"
+	pyrobenchtime "time"
+)
+
+func TestMain(m *pyrobenchtesting.M) {
+	dir := pyrobenchos.Getenv("` + goroutineDirEnv + `")
+	if dir == "" {
+		pyrobenchos.Exit(m.Run())
+	}
+
+	before := pyrobenchruntime.NumGoroutine()
+	pyrobenchWriteGoroutines(pyrobenchfilepath.Join(dir, "before.pprof"))
+	code := m.Run()
+
+	deadline := pyrobenchtime.Now().Add(pyrobenchtime.Second)
+	for pyrobenchruntime.NumGoroutine() > before && pyrobenchtime.Now().Before(deadline) {
+		pyrobenchtime.Sleep(10 * pyrobenchtime.Millisecond)
+	}
+	pyrobenchWriteGoroutines(pyrobenchfilepath.Join(dir, "after.pprof"))
+	pyrobenchos.Exit(code)
+}
+
+func pyrobenchWriteGoroutines(path string) {
+	f, err := pyrobenchos.Create(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	_ = pyrobenchpprof.Lookup("goroutine").WriteTo(f, 0)
+}
+`))
+
+// hasTestMain returns true if one of the test files declares a TestMain.
+func (p *Package) hasTestMain() (bool, error) {
+	fset := token.NewFileSet()
+	for _, files := range [][]string{p.meta.TestGoFiles, p.meta.XTestGoFiles} {
+		for _, fileName := range files {
+			file, err := parser.ParseFile(fset, filepath.Join(p.meta.Dir, fileName), nil, parser.SkipObjectResolution)
+			if err != nil {
+				return false, err
+			}
+			for _, decl := range file.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "TestMain" {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// goroutineOverlay writes the TestMain recording the goroutines and returns
+// the path of the overlay adding it to the package. It returns an empty path,
+// when the package has a TestMain of its own.
+func (p *Package) goroutineOverlay(ctx context.Context) (string, error) {
+	exists, err := p.hasTestMain()
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		level.Warn(p.logger).Log("msg", "package has its own TestMain, goroutine leaks are not checked")
+		return "", nil
+	}
+
+	dir, err := os.MkdirTemp("", "pyrotest-goroutines")
+	if err != nil {
+		return "", err
+	}
+	cleanupFromContext(ctx)(func() error {
+		return os.RemoveAll(dir)
+	})
+
+	src := new(bytes.Buffer)
+	if err := goroutineTestMain.Execute(src, p.meta.Name); err != nil {
+		return "", err
+	}
+	srcPath := filepath.Join(dir, goroutineTestFile)
+	if err := os.WriteFile(srcPath, src.Bytes(), 0o644); err != nil {
+		return "", err
+	}
+
+	overlay, err := json.Marshal(struct {
+		Replace map[string]string
+	}{
+		Replace: map[string]string{filepath.Join(p.meta.Dir, goroutineTestFile): srcPath},
+	})
+	if err != nil {
+		return "", err
+	}
+	overlayPath := filepath.Join(dir, "overlay.json")
+	return overlayPath, os.WriteFile(overlayPath, overlay, 0o644)
+}
+
+// goroutineStack are the goroutines sharing a stack.
+type goroutineStack struct {
+	count int
+	stack string // one line per frame, innermost first
+}
+
+// goroutineLeak are the goroutines still running after the benchmarks of a
+// test binary finished, which have not been running before.
+type goroutineLeak struct {
+	// stacks are keyed by their function names only, so they match between
+	// base and head even when line numbers changed.
+	stacks map[string]*goroutineStack
+}
+
+// goroutineStacks groups the goroutines of a goroutine profile by their
+// stack.
+func goroutineStacks(p *profile.Profile) map[string]*goroutineStack {
+	stacks := make(map[string]*goroutineStack)
+	for _, s := range p.Sample {
+		var funcs, frames []string
+		for _, loc := range s.Location {
+			for _, line := range loc.Line {
+				if line.Function == nil {
+					continue
+				}
+				funcs = append(funcs, line.Function.Name)
+				frames = append(frames, fmt.Sprintf("%s\n\t%s:%d", line.Function.Name, line.Function.Filename, line.Line))
+			}
+		}
+		key := strings.Join(funcs, "\n")
+		gs, ok := stacks[key]
+		if !ok {
+			gs = &goroutineStack{stack: strings.Join(frames, "\n")}
+			stacks[key] = gs
+		}
+		if len(s.Value) > 0 {
+			gs.count += int(s.Value[0])
+		}
+	}
+	return stacks
+}
+
+// newGoroutineLeak returns the goroutines of after in excess of before.
+func newGoroutineLeak(before, after *profile.Profile) *goroutineLeak {
+	b := goroutineStacks(before)
+	l := &goroutineLeak{stacks: make(map[string]*goroutineStack)}
+	for key, gs := range goroutineStacks(after) {
+		n := gs.count
+		if prev, ok := b[key]; ok {
+			n -= prev.count
+		}
+		if n > 0 {
+			l.stacks[key] = &goroutineStack{count: n, stack: gs.stack}
+		}
+	}
+	return l
+}
+
+// readGoroutineLeak reads the profiles written by the injected TestMain. It
+// returns nil, when they have not been written.
+func readGoroutineLeak(dir string) (*goroutineLeak, error) {
+	before, err := readProfile(filepath.Join(dir, "before.pprof"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	after, err := readProfile(filepath.Join(dir, "after.pprof"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return newGoroutineLeak(before, after), nil
+}
+
+// count returns the number of leaked goroutines with the stack, or all of
+// them when key is empty.
+func (l *goroutineLeak) count(key string) int {
+	if l == nil {
+		return 0
+	}
+	if key != "" {
+		if gs, ok := l.stacks[key]; ok {
+			return gs.count
+		}
+		return 0
+	}
+	var n int
+	for _, gs := range l.stacks {
+		n += gs.count
+	}
+	return n
+}
+
+// goroutineLeak compares the goroutines leaked by the latest runs of base and
+// head. It returns nil, unless head leaks more than base.
+func (b *bench) goroutineLeak() *report.GoroutineLeak {
+	head, ok := b.goroutines[benchSourceHead]
+	if !ok {
+		return nil
+	}
+	base := b.goroutines[benchSourceBase]
+	l := &report.GoroutineLeak{Base: base.count(""), Head: head.count("")}
+	if l.Head <= l.Base {
+		return nil
+	}
+	for key, gs := range head.stacks {
+		if n := gs.count - base.count(key); n > 0 {
+			l.Stacks = append(l.Stacks, report.LeakedStack{Count: n, Stack: gs.stack})
+		}
+	}
+	sort.Slice(l.Stacks, func(i, j int) bool {
+		if l.Stacks[i].Count != l.Stacks[j].Count {
+			return l.Stacks[i].Count > l.Stacks[j].Count
+		}
+		return l.Stacks[i].Stack < l.Stacks[j].Stack
+	})
+	if len(l.Stacks) > maxLeakedStacks {
+		l.Stacks = l.Stacks[:maxLeakedStacks]
+	}
+	return l
+}
@@ -0,0 +1,134 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGoroutineLeaks(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.22\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "leak"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "leak", "leak.go"), []byte(`package leak
+
+// os collides with the imports of a naive TestMain
+var os = "linux"
+
+func Block(ch chan struct{}) {
+	<-ch
+}
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "leak", "leak_test.go"), []byte(`package leak
+
+import "testing"
+
+func BenchmarkLeak(b *testing.B) {
+	for i := 0; i < 3; i++ {
+		go Block(make(chan struct{}))
+	}
+}
+`), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "main"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main", "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main", "bench_test.go"), []byte(`package main
+
+import "testing"
+
+func BenchmarkNothing(b *testing.B) {}
+`), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main", "main_test.go"), []byte(`package main_test
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+`), 0o644))
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	t.Cleanup(func() {
+		require.NoError(t, cleaner.cleanup())
+	})
+
+	pkgs, err := discoverPackages(ctx, log.NewNopLogger(), dir, []string{"./..."}, nil)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 2)
+	leak, main := &pkgs[0], &pkgs[1]
+	require.Equal(t, "example.com/m/leak", leak.meta.ImportPath)
+	require.Equal(t, []string{"main_test.go"}, main.meta.XTestGoFiles)
+
+	// the package's own TestMain is kept
+	main.goroutineLeaks = true
+	require.NoError(t, main.compileTest(ctx))
+	require.False(t, main.goroutineLeaks)
+
+	leak.goroutineLeaks = true
+	require.NoError(t, leak.compileTest(ctx))
+	require.True(t, leak.goroutineLeaks)
+
+	out := t.TempDir()
+	c := exec.Command(leak.testBinary, "-test.run", "^$", "-test.bench", "BenchmarkLeak", "-test.benchtime", "1x")
+	c.Dir = leak.meta.Dir
+	c.Env = append(os.Environ(), goroutineDirEnv+"="+out)
+	msg, err := c.CombinedOutput()
+	require.NoError(t, err, string(msg))
+
+	l, err := readGoroutineLeak(out)
+	require.NoError(t, err)
+	require.NotNil(t, l)
+	require.Equal(t, 3, l.count(""))
+	require.Len(t, l.stacks, 1)
+	for _, gs := range l.stacks {
+		require.Contains(t, gs.stack, "example.com/m/leak.Block\n\texample.com/m/leak/leak.go:")
+	}
+
+	// without the profiles
+	l, err = readGoroutineLeak(t.TempDir())
+	require.NoError(t, err)
+	require.Nil(t, l)
+}
+
+func TestBenchGoroutineLeak(t *testing.T) {
+	leak := func(counts map[string]int) *goroutineLeak {
+		l := &goroutineLeak{stacks: make(map[string]*goroutineStack)}
+		for key, n := range counts {
+			l.stacks[key] = &goroutineStack{count: n, stack: key + "\n\tfile.go:1"}
+		}
+		return l
+	}
+
+	b := &bench{}
+	require.Nil(t, b.goroutineLeak())
+
+	// only leaks in excess of base are listed
+	b.goroutines = map[benchSource]*goroutineLeak{
+		benchSourceBase: leak(map[string]int{"a": 2}),
+		benchSourceHead: leak(map[string]int{"a": 3, "b": 5}),
+	}
+	l := b.goroutineLeak()
+	require.NotNil(t, l)
+	require.Equal(t, 2, l.Base)
+	require.Equal(t, 8, l.Head)
+	require.Len(t, l.Stacks, 2)
+	require.Equal(t, 5, l.Stacks[0].Count)
+	require.Equal(t, "b\n\tfile.go:1", l.Stacks[0].Stack)
+	require.Equal(t, 1, l.Stacks[1].Count)
+
+	// no leak in excess of base
+	b.goroutines[benchSourceHead] = leak(map[string]int{"b": 2})
+	require.Nil(t, b.goroutineLeak())
+
+	// new benchmarks are compared with no leaks
+	delete(b.goroutines, benchSourceBase)
+	require.Equal(t, 2, b.goroutineLeak().Head)
+}
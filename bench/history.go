@@ -0,0 +1,100 @@
+package bench
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/history"
+	"github.com/grafana/pyrobench/report"
+)
+
+// historyDepth limits how many ancestors of the base commit are considered.
+const historyDepth = 50
+
+func (b *Benchmark) gitAncestors(_ context.Context, rev string, depth int) ([]string, error) {
+	out, err := gitIn(b.dir, nil, "rev-list", "--first-parent", "--max-count", strconv.Itoa(depth), rev)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// applyHistory annotates the report with baseline shifts and the machine
+// drift found in the history and records the results of this run afterwards.
+func (b *Benchmark) applyHistory(ctx context.Context, args *history.Args, threshold float64, rpt *report.BenchmarkReport) {
+	store, err := history.NewStore(args)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "unable to open history store", "err", err)
+		return
+	}
+
+	records, err := store.Load(ctx)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "unable to load history", "err", err)
+	}
+
+	var ancestors []string
+	if rpt.BaseRef != "" {
+		ancestors, err = b.gitAncestors(ctx, rpt.BaseRef, historyDepth)
+		if err != nil {
+			level.Warn(b.logger).Log("msg", "unable to list ancestors of base commit", "err", err)
+		}
+	}
+
+	type series struct{ benchmark, resource string }
+	drifts := make(map[series]float64)
+	for _, d := range history.Drifts(records) {
+		drifts[series{d.Benchmark, d.Resource}] = d.Diff
+	}
+
+	for i := range rpt.Runs {
+		run := &rpt.Runs[i]
+		for j := range run.Results {
+			res := &run.Results[j]
+			if res.BaseValue.FlamegraphKey != "" {
+				res.BaselineShift = history.FindBaselineShift(records, run.Name, res.Resource(), float64(res.BaseValue.ProfileValue), ancestors, threshold)
+			}
+			res.Drift = drifts[series{run.Name, res.Resource()}]
+		}
+	}
+
+	if err := store.Append(ctx, historyRecords(rpt, time.Now(), "")...); err != nil {
+		level.Warn(b.logger).Log("msg", "unable to record results in history", "err", err)
+	}
+}
+
+// historyRecords returns the measurements of base and head of the report.
+func historyRecords(rpt *report.BenchmarkReport, now time.Time, ref string) []history.Record {
+	var records []history.Record
+	for i := range rpt.Runs {
+		run := &rpt.Runs[i]
+		for j := range run.Results {
+			res := &run.Results[j]
+			for _, x := range []struct {
+				commit string
+				value  report.BenchmarkValue
+			}{
+				{rpt.BaseRef, res.BaseValue},
+				{rpt.HeadRef, res.HeadValue},
+			} {
+				if x.value.FlamegraphKey == "" {
+					continue
+				}
+				records = append(records, history.Record{
+					Time:      now,
+					Commit:    x.commit,
+					Ref:       ref,
+					Benchmark: run.Name,
+					Resource:  res.Resource(),
+					Unit:      res.Unit,
+					Value:     float64(x.value.ProfileValue),
+				})
+			}
+		}
+	}
+	return records
+}
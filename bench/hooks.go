@@ -0,0 +1,241 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/config"
+)
+
+// teardownTimeout limits how long a teardown command may run, it is started
+// on cleanup, when the context might already be cancelled.
+const teardownTimeout = 5 * time.Minute
+
+// worktreeEnv points the test binaries and commands run locally to the root
+// of the checkout of either base or head.
+const worktreeEnv = "PYROBENCH_WORKTREE"
+
+// packageHooks are the environment and the setup and teardown commands of the
+// package configurations matching a package.
+type packageHooks struct {
+	env      []string // KEY=value
+	setup    []string
+	teardown []string
+	workDir  string   // relative to the repository root, empty for the package's directory
+	fixtures []string // relative to the repository root
+	services []config.Service
+	flags    []string // passed to the test binary
+
+	// fixturesDir holds the fixtures linked into the checkouts, the
+	// working directory when empty.
+	fixturesDir string
+
+	done bool  // the setup has been run
+	err  error // of the setup
+}
+
+// newPackageHooks merges the configurations matching the import path, in the
+// alphabetical order of their globs. It returns nil, when none matches.
+func newPackageHooks(cfg *config.Config, importPath string) *packageHooks {
+	if cfg == nil {
+		return nil
+	}
+	var h *packageHooks
+	env := make(map[string]string)
+	for _, glob := range cfg.PackageGlobs() {
+		if !matchPackage([]string{glob}, importPath) {
+			continue
+		}
+		if h == nil {
+			h = &packageHooks{}
+		}
+		c := cfg.Packages[glob]
+		for k, v := range c.Env {
+			env[k] = v
+		}
+		if c.Setup != "" {
+			h.setup = append(h.setup, c.Setup)
+		}
+		if c.Teardown != "" {
+			h.teardown = append(h.teardown, c.Teardown)
+		}
+		if c.WorkDir != "" {
+			h.workDir = c.WorkDir
+		}
+		h.fixtures = append(h.fixtures, c.Fixtures...)
+		h.services = append(h.services, c.Services...)
+		h.flags = append(h.flags, c.Flags...)
+	}
+	if h == nil {
+		return nil
+	}
+	for k, v := range env {
+		h.env = append(h.env, k+"="+v)
+	}
+	sort.Strings(h.env)
+	return h
+}
+
+// runSetup starts the services and runs the setup commands of the package
+// once, before its first benchmark. The teardown commands are registered for
+// cleanup beforehand, so they also undo a partial setup. Later calls return
+// the error of the first.
+func (p *Package) runSetup(ctx context.Context) error {
+	h := p.hooks
+	if h == nil {
+		return nil
+	}
+	if h.done {
+		return h.err
+	}
+	h.done = true
+
+	// cleanups run in reverse order, so the teardowns do as well
+	for _, command := range h.teardown {
+		cleanupFromContext(ctx)(func() error {
+			// the teardown runs after cancellation, with the environment of ctx
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), teardownTimeout)
+			defer cancel()
+			if err := p.runHook(ctx, command); err != nil {
+				return fmt.Errorf("teardown of %s failed: %w", p.meta.ImportPath, err)
+			}
+			return nil
+		})
+	}
+	if err := p.startServices(ctx); err != nil {
+		h.err = err
+		return h.err
+	}
+	if err := p.linkFixtures(ctx); err != nil {
+		h.err = fmt.Errorf("linking fixtures of %s failed: %w", p.meta.ImportPath, err)
+		return h.err
+	}
+	for _, command := range h.setup {
+		level.Info(p.logger).Log("msg", "running setup", "package", p.meta.ImportPath, "command", command)
+		if err := p.runHook(ctx, command); err != nil {
+			h.err = fmt.Errorf("setup of %s failed: %w", p.meta.ImportPath, err)
+			return h.err
+		}
+	}
+	return nil
+}
+
+// startServices starts the services of the package and adds their addresses
+// to its environment. The services publish their ports on the local host, so
+// they are only reachable by test binaries run locally.
+func (p *Package) startServices(ctx context.Context) error {
+	h := p.hooks
+	if len(h.services) == 0 {
+		return nil
+	}
+	if _, ok := executorFromContext(ctx).(localExecutor); !ok {
+		return fmt.Errorf("services of %s require the local executor", p.meta.ImportPath)
+	}
+	for _, svc := range h.services {
+		env, err := servicesFromContext(ctx).start(ctx, svc)
+		if err != nil {
+			return fmt.Errorf("service %s of %s failed: %w", svc.EnvName(), p.meta.ImportPath, err)
+		}
+		h.env = append(h.env, env...)
+	}
+	return nil
+}
+
+// runHook runs the command in the package's directory. Like the test binary,
+// it runs in the sandbox, as it might execute the code of the checkout.
+func (p *Package) runHook(ctx context.Context, command string) error {
+	c := shellCommand(ctx, command)
+	env := environFromContext(ctx)
+	if p.sandbox != "" {
+		// teardowns run on cleanup, so the directories are removed right away
+		scratch, err := makeScratchDirs()
+		if err != nil {
+			return fmt.Errorf("failed to create scratch directories: %w", err)
+		}
+		defer scratch.remove()
+		spec := p.sandboxSpec(scratch)
+		spec.dir = p.meta.Dir
+		name, args, err := sandboxCommand(p.sandbox, spec, c.Args[0], c.Args[1:])
+		if err != nil {
+			return err
+		}
+		c = exec.CommandContext(ctx, name, args...)
+		env = append(env, scratch.env()...)
+	}
+	c.Dir = p.meta.Dir
+	c.Env = append(append(env, p.hooks.env...), worktreeEnv+"="+p.workdir)
+	out, err := c.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w\n%s", command, err, out)
+	}
+	return nil
+}
+
+// workingDir returns the directory the test binary runs in, the package's
+// directory unless configured otherwise.
+func (p *Package) workingDir() string {
+	if p.hooks == nil || p.hooks.workDir == "" {
+		return p.meta.Dir
+	}
+	return filepath.Join(p.workdir, filepath.FromSlash(p.hooks.workDir))
+}
+
+// linkFixtures links the fixtures missing in the package's checkout to those
+// of the working directory of the repository. The links and the directories
+// created for them are removed on cleanup, so the worktree can be removed.
+func (p *Package) linkFixtures(ctx context.Context) error {
+	if len(p.hooks.fixtures) == 0 {
+		return nil
+	}
+	wd := p.hooks.fixturesDir
+	if wd == "" {
+		var err error
+		if wd, err = os.Getwd(); err != nil {
+			return err
+		}
+	}
+	for _, f := range p.hooks.fixtures {
+		src := filepath.Join(wd, filepath.FromSlash(f))
+		dst := filepath.Join(p.workdir, filepath.FromSlash(f))
+		if src == dst {
+			continue
+		}
+		if _, err := os.Lstat(dst); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if _, err := os.Stat(src); err != nil {
+			return fmt.Errorf("fixture %s: %w", f, err)
+		}
+
+		// the outermost directory, which does not exist yet
+		created := dst
+		for dir := filepath.Dir(dst); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if _, err := os.Stat(dir); err == nil {
+				break
+			}
+			created = dir
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.Symlink(src, dst); err != nil {
+			return err
+		}
+		level.Debug(p.logger).Log("msg", "linked fixture", "package", p.meta.ImportPath, "fixture", f, "target", src)
+		cleanupFromContext(ctx)(func() error {
+			return os.RemoveAll(created)
+		})
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/config"
+)
+
+func TestPackageHooks(t *testing.T) {
+	cfg := &config.Config{Packages: map[string]*config.Package{
+		"example.com/m/...": {
+			Env:      map[string]string{"TESTDATA_DIR": "testdata", "DB": "all"},
+			Setup:    `echo "setup $DB $TESTDATA_DIR" >> hooks.log`,
+			Teardown: `echo "teardown $DB" >> hooks.log`,
+		},
+		"example.com/m/storage": {
+			Env:   map[string]string{"DB": "storage"},
+			Setup: `echo "setup storage" >> hooks.log`,
+		},
+		"example.com/other": {Setup: "false"},
+	}}
+	require.Nil(t, newPackageHooks(cfg, "example.com/x"))
+	require.Nil(t, newPackageHooks(nil, "example.com/m"))
+
+	h := newPackageHooks(cfg, "example.com/m/storage")
+	require.Equal(t, []string{"DB=storage", "TESTDATA_DIR=testdata"}, h.env)
+
+	dir := t.TempDir()
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	p := &Package{logger: log.NewNopLogger(), meta: &packageMeta{Dir: dir, ImportPath: "example.com/m/storage"}, hooks: h}
+
+	// the setup runs only once
+	require.NoError(t, p.runSetup(ctx))
+	require.NoError(t, p.runSetup(ctx))
+	require.NoError(t, cleaner.cleanup())
+	data, err := os.ReadFile(filepath.Join(dir, "hooks.log"))
+	require.NoError(t, err)
+	require.Equal(t, "setup storage testdata\nsetup storage\nteardown storage\n", string(data))
+
+	// a failed setup fails all runs of the package
+	p = &Package{logger: log.NewNopLogger(), meta: &packageMeta{Dir: dir, ImportPath: "example.com/other"}, hooks: newPackageHooks(cfg, "example.com/other")}
+	require.ErrorContains(t, p.runSetup(ctx), "setup of example.com/other failed: false: exit status 1")
+	require.ErrorContains(t, p.runSetup(ctx), "setup of example.com/other failed")
+}
+
+func TestPackageFixtures(t *testing.T) {
+	cfg := &config.Config{Packages: map[string]*config.Package{
+		"example.com/m/...": {
+			WorkDir:  ".",
+			Fixtures: []string{"testdata/large", "testdata/tracked", "fixtures/db/dump.sql"},
+		},
+	}}
+	h := newPackageHooks(cfg, "example.com/m/storage")
+	require.Equal(t, ".", h.workDir)
+
+	// the working directory holds the fixtures, which are not tracked
+	wd := t.TempDir()
+	for _, f := range []string{"testdata/large/a.bin", "testdata/tracked/b.txt", "fixtures/db/dump.sql"} {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(wd, f)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(wd, f), []byte("wd"), 0o644))
+	}
+	h.fixturesDir = wd
+
+	// the worktree of base only has the tracked ones
+	worktree := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(worktree, "testdata", "tracked"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(worktree, "testdata", "tracked", "b.txt"), []byte("worktree"), 0o644))
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	pkgDir := filepath.Join(worktree, "storage")
+	p := &Package{logger: log.NewNopLogger(), workdir: worktree, meta: &packageMeta{Dir: pkgDir, ImportPath: "example.com/m/storage"}, hooks: h}
+	require.Equal(t, worktree, p.workingDir())
+	require.NoError(t, p.runSetup(ctx))
+
+	read := func(f string) string {
+		data, err := os.ReadFile(filepath.Join(worktree, filepath.FromSlash(f)))
+		require.NoError(t, err)
+		return string(data)
+	}
+	require.Equal(t, "wd", read("testdata/large/a.bin"))
+	require.Equal(t, "worktree", read("testdata/tracked/b.txt"))
+	require.Equal(t, "wd", read("fixtures/db/dump.sql"))
+
+	// nothing is left behind in the worktree, nor removed from the working directory
+	require.NoError(t, cleaner.cleanup())
+	require.NoDirExists(t, filepath.Join(worktree, "fixtures"))
+	require.NoFileExists(t, filepath.Join(worktree, "testdata", "large"))
+	require.FileExists(t, filepath.Join(worktree, "testdata", "tracked", "b.txt"))
+	require.FileExists(t, filepath.Join(wd, "testdata", "large", "a.bin"))
+	require.FileExists(t, filepath.Join(wd, "fixtures", "db", "dump.sql"))
+
+	// without a configured working directory, the package's directory is used
+	p.hooks = &packageHooks{}
+	require.Equal(t, pkgDir, p.workingDir())
+}
+
+func TestPackageTestFlags(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.22\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "m_test.go"), []byte(`package m
+
+import (
+	"flag"
+	"os"
+	"testing"
+)
+
+var fixtures = flag.String("db.fixtures", "", "")
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	os.Exit(m.Run())
+}
+
+func BenchmarkFlags(b *testing.B) {
+	if !testing.Short() || *fixtures != "testdata" {
+		b.Fatalf("short=%v fixtures=%q", testing.Short(), *fixtures)
+	}
+}
+`), 0o644))
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	t.Cleanup(func() {
+		require.NoError(t, cleaner.cleanup())
+	})
+	pkgs, err := discoverPackages(ctx, log.NewNopLogger(), dir, []string{"./..."}, nil)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	p := &pkgs[0]
+	require.NoError(t, p.compileTest(ctx))
+
+	// the package configuration passes the flag registered by TestMain, the
+	// comment or suite -test.short
+	cfg := &config.Config{Packages: map[string]*config.Package{
+		"example.com/m": {Flags: []string{"-db.fixtures=testdata"}},
+	}}
+	p.hooks = newPackageHooks(cfg, "example.com/m")
+	require.Equal(t, []string{"-db.fixtures=testdata"}, p.hooks.flags)
+
+	opts := runOptions{benchTime: "1x", count: 1, timeout: time.Minute, noProfiles: true}
+	_, err = p.runBenchmark(ctx, opts, "BenchmarkFlags")
+	require.Error(t, err)
+
+	opts.flags = []string{"-test.short"}
+	res, err := p.runBenchmark(ctx, opts, "BenchmarkFlags")
+	require.NoError(t, err)
+	require.Len(t, res.RawResult, 1)
+}
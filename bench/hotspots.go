@@ -0,0 +1,222 @@
+package bench
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// flatTotals returns the flat values of all functions in a profile with a
+// single sample type. The flat value is attributed to the innermost function
+// of a sample, which includes functions inlined into another one.
+func flatTotals(p *profile.Profile) map[string]int64 {
+	totals := make(map[string]int64)
+	for _, s := range p.Sample {
+		if len(s.Location) == 0 || len(s.Location[0].Line) == 0 {
+			continue
+		}
+		fn := s.Location[0].Line[0].Function
+		if fn == nil {
+			continue
+		}
+		totals[fn.Name] += s.Value[0]
+	}
+	return totals
+}
+
+// flatChanges returns the top functions, whose flat value changed the most in
+// absolute terms. The base values get multiplied by baseScale first.
+func flatChanges(base, head *profile.Profile, baseScale float64, top int) []report.FunctionDelta {
+	if top <= 0 {
+		return nil
+	}
+	return topChanges(flatTotals(base), flatTotals(head), baseScale, top)
+}
+
+// topChanges returns the top functions, whose total changed the most in
+// absolute terms between base and head. The base totals get multiplied by
+// baseScale first.
+func topChanges(baseTotals, headTotals map[string]int64, baseScale float64, top int) []report.FunctionDelta {
+	names := make(map[string]struct{})
+	for name := range baseTotals {
+		names[name] = struct{}{}
+	}
+	for name := range headTotals {
+		names[name] = struct{}{}
+	}
+
+	var result []report.FunctionDelta
+	for name := range names {
+		d := report.FunctionDelta{
+			Name: name,
+			Base: int64(float64(baseTotals[name]) * baseScale),
+			Head: headTotals[name],
+		}
+		if d.Base == d.Head {
+			continue
+		}
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		di := abs(result[i].Head - result[i].Base)
+		dj := abs(result[j].Head - result[j].Base)
+		if di != dj {
+			return di > dj
+		}
+		return result[i].Name < result[j].Name
+	})
+	if len(result) > top {
+		result = result[:top]
+	}
+	return result
+}
+
+// compareHotspots records the functions, whose flat CPU time changed the most
+// between the latest runs of base and head.
+func (r *bench) compareHotspots(top int) {
+	baseScale, ok := r.baseScale()
+	if !ok || top <= 0 {
+		return
+	}
+	base, head := r.baseResult.CPU.profile, r.headResult.CPU.profile
+	if base == nil || head == nil {
+		return
+	}
+	r.hotspots = flatChanges(base, head, baseScale, top)
+}
+
+// maxHotLines limits the lines recorded per benchmark and profile. Reporters
+// only annotate those lines, which have been added by the change.
+const maxHotLines = 50
+
+// hotLineUnits are the units of the profiles, whose lines are recorded.
+var hotLineUnits = map[string]string{
+	"cpu":         "ns",
+	"alloc_space": "bytes",
+}
+
+// sourceFiles maps the paths of modules to their directories relative to the
+// repository root.
+type sourceFiles map[string]string
+
+// sourceFiles returns the modules of the head packages within the checkout.
+func (b *Benchmark) sourceFiles() sourceFiles {
+	files := make(sourceFiles)
+	for i := range b.headPackages {
+		m := b.headPackages[i].meta.Module
+		if m == nil || m.Dir == "" {
+			continue
+		}
+		if _, ok := files[m.Path]; ok {
+			continue
+		}
+		dir, err := filepath.Rel(b.headDir, m.Dir)
+		if err != nil || dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+			continue
+		}
+		files[m.Path] = filepath.ToSlash(dir)
+	}
+	return files
+}
+
+// resolve returns the path relative to the repository root of a file name
+// in a profile. These are prefixed with the module path, as the test binaries
+// are compiled with -trimpath. It returns false for files of other modules.
+func (s sourceFiles) resolve(file string) (string, bool) {
+	var module string
+	for m := range s {
+		if strings.HasPrefix(file, m+"/") && len(m) > len(module) {
+			module = m
+		}
+	}
+	if module == "" {
+		return "", false
+	}
+	return path.Join(s[module], strings.TrimPrefix(file, module+"/")), true
+}
+
+// hotLines returns the lines of head with the largest flat values, within
+// functions whose flat value increased against base. The base values get
+// multiplied by baseScale first. Lines outside of the repository are skipped.
+func hotLines(base, head *profile.Profile, baseScale float64, files sourceFiles, resource, unit string) []report.HotLine {
+	type lineKey struct {
+		file     string
+		line     int64
+		function string
+	}
+	lines := make(map[lineKey]int64)
+	var total int64
+	for _, s := range head.Sample {
+		total += s.Value[0]
+		if len(s.Location) == 0 || len(s.Location[0].Line) == 0 {
+			continue
+		}
+		l := s.Location[0].Line[0]
+		if l.Function == nil {
+			continue
+		}
+		lines[lineKey{file: l.Function.Filename, line: l.Line, function: l.Function.Name}] += s.Value[0]
+	}
+	if total <= 0 {
+		return nil
+	}
+
+	baseTotals := flatTotals(base)
+	headTotals := flatTotals(head)
+	var result []report.HotLine
+	for k, v := range lines {
+		increase := headTotals[k.function] - int64(float64(baseTotals[k.function])*baseScale)
+		if v <= 0 || increase <= 0 {
+			continue
+		}
+		file, ok := files.resolve(k.file)
+		if !ok {
+			continue
+		}
+		result = append(result, report.HotLine{
+			Resource: resource,
+			Unit:     unit,
+			File:     file,
+			Line:     int(k.line),
+			Function: k.function,
+			Value:    v,
+			Share:    float64(v) / float64(total) * 100,
+			Increase: increase,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Value != result[j].Value {
+			return result[i].Value > result[j].Value
+		}
+		if result[i].File != result[j].File {
+			return result[i].File < result[j].File
+		}
+		return result[i].Line < result[j].Line
+	})
+	if len(result) > maxHotLines {
+		result = result[:maxHotLines]
+	}
+	return result
+}
+
+// compareHotLines records the hot lines of the CPU and allocation profiles of
+// the latest runs of base and head.
+func (r *bench) compareHotLines(files sourceFiles) {
+	baseScale, ok := r.baseScale()
+	if !ok {
+		return
+	}
+	r.hotLines = nil
+	for _, x := range r.profilePairs() {
+		unit, ok := hotLineUnits[x.name]
+		if !ok || x.base.profile == nil || x.head.profile == nil {
+			continue
+		}
+		r.hotLines = append(r.hotLines, hotLines(x.base.profile, x.head.profile, baseScale, files, x.name, unit)...)
+	}
+}
@@ -0,0 +1,140 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestFlatChanges(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		base      []int64
+		head      []int64
+		baseScale float64
+		top       int
+		expected  []report.FunctionDelta
+	}{
+		{
+			name:      "unchanged",
+			base:      []int64{100, 200},
+			head:      []int64{100, 200},
+			baseScale: 1,
+			top:       10,
+		},
+		{
+			name:      "ordered by absolute change",
+			base:      []int64{100, 200, 300},
+			head:      []int64{150, 100, 310},
+			baseScale: 1,
+			top:       10,
+			expected: []report.FunctionDelta{
+				{Name: "main.f1", Base: 200, Head: 100},
+				{Name: "main.f0", Base: 100, Head: 150},
+				{Name: "main.f2", Base: 300, Head: 310},
+			},
+		},
+		{
+			name:      "limited to top",
+			base:      []int64{100, 200, 300},
+			head:      []int64{150, 100, 310},
+			baseScale: 1,
+			top:       1,
+			expected:  []report.FunctionDelta{{Name: "main.f1", Base: 200, Head: 100}},
+		},
+		{
+			name:      "base is scaled",
+			base:      []int64{50, 100},
+			head:      []int64{100, 300},
+			baseScale: 2,
+			top:       10,
+			expected:  []report.FunctionDelta{{Name: "main.f1", Base: 200, Head: 300}},
+		},
+		{
+			name:      "new function",
+			base:      []int64{100},
+			head:      []int64{100, 50},
+			baseScale: 1,
+			top:       10,
+			expected:  []report.FunctionDelta{{Name: "main.f1", Base: 0, Head: 50}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, flatChanges(testCPUProfile(tc.base...), testCPUProfile(tc.head...), tc.baseScale, tc.top))
+		})
+	}
+}
+
+func TestFlatTotals(t *testing.T) {
+	// main.caller calls main.callee, which got main.inlined inlined
+	caller := &profile.Function{ID: 1, Name: "main.caller"}
+	callee := &profile.Function{ID: 2, Name: "main.callee"}
+	inlined := &profile.Function{ID: 3, Name: "main.inlined"}
+	callerLoc := &profile.Location{ID: 1, Line: []profile.Line{{Function: caller}}}
+	calleeLoc := &profile.Location{ID: 2, Line: []profile.Line{{Function: inlined}, {Function: callee}}}
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{calleeLoc, callerLoc}, Value: []int64{30}},
+			{Location: []*profile.Location{callerLoc}, Value: []int64{10}},
+		},
+	}
+	require.Equal(t, map[string]int64{"main.inlined": 30, "main.caller": 10}, flatTotals(p))
+}
+
+func TestSourceFilesResolve(t *testing.T) {
+	files := sourceFiles{
+		"github.com/org/repo":     ".",
+		"github.com/org/repo/sub": "sub",
+	}
+	for _, tc := range []struct {
+		file     string
+		expected string
+		ok       bool
+	}{
+		{file: "github.com/org/repo/pkg/a.go", expected: "pkg/a.go", ok: true},
+		{file: "github.com/org/repo/sub/b.go", expected: "sub/b.go", ok: true},
+		{file: "github.com/other/repo@v1.0.0/c.go"},
+		{file: "runtime/proc.go"},
+	} {
+		file, ok := files.resolve(tc.file)
+		require.Equal(t, tc.ok, ok, tc.file)
+		require.Equal(t, tc.expected, file, tc.file)
+	}
+}
+
+func TestHotLines(t *testing.T) {
+	slower := &profile.Function{ID: 1, Name: "example.com/m.slower", Filename: "example.com/m/a.go"}
+	same := &profile.Function{ID: 2, Name: "example.com/m.same", Filename: "example.com/m/a.go"}
+	dep := &profile.Function{ID: 3, Name: "example.com/dep.f", Filename: "example.com/dep@v1.0.0/dep.go"}
+	loc := func(id uint64, fn *profile.Function, line int64) *profile.Location {
+		return &profile.Location{ID: id, Line: []profile.Line{{Function: fn, Line: line}}}
+	}
+	newProfile := func(samples ...*profile.Sample) *profile.Profile {
+		return &profile.Profile{SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}}, Sample: samples}
+	}
+
+	base := newProfile(
+		&profile.Sample{Location: []*profile.Location{loc(1, slower, 10)}, Value: []int64{10}},
+		&profile.Sample{Location: []*profile.Location{loc(2, same, 20)}, Value: []int64{20}},
+		&profile.Sample{Location: []*profile.Location{loc(3, dep, 5)}, Value: []int64{10}},
+	)
+	head := newProfile(
+		&profile.Sample{Location: []*profile.Location{loc(1, slower, 10)}, Value: []int64{10}},
+		&profile.Sample{Location: []*profile.Location{loc(4, slower, 11)}, Value: []int64{40}},
+		&profile.Sample{Location: []*profile.Location{loc(2, same, 20)}, Value: []int64{20}},
+		&profile.Sample{Location: []*profile.Location{loc(3, dep, 5)}, Value: []int64{30}},
+	)
+
+	files := sourceFiles{"example.com/m": "m"}
+	require.Equal(t, []report.HotLine{
+		{Resource: "cpu", Unit: "ns", File: "m/a.go", Line: 11, Function: "example.com/m.slower", Value: 40, Share: 40, Increase: 40},
+		{Resource: "cpu", Unit: "ns", File: "m/a.go", Line: 10, Function: "example.com/m.slower", Value: 10, Share: 10, Increase: 40},
+	}, hotLines(base, head, 1, files, "cpu", "ns"))
+
+	// scaled to the iterations of head, base is as slow as head
+	require.Empty(t, hotLines(base, head, 5, files, "cpu", "ns"))
+}
@@ -6,9 +6,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
+	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
 )
 
 type profileResponse struct {
@@ -16,12 +20,60 @@ type profileResponse struct {
 	Key         string `json:"key"`
 	SubProfiles []struct {
 		Key  string `json:"key"`
-		Name string `json:"name"`
+		Name string `json:"name"` // sample type shown by the sub-profile
 	} `json:"subProfiles"`
 }
 
+// subProfileKey returns the key of the view of a single sample type of the
+// uploaded profile. Profiles with only one sample type have no sub-profiles,
+// they are linked by their own key. The sub-profiles of a pending upload are
+// resolved with its response, their keys name the sample type.
+func (r *profileResponse) subProfileKey(sampleType string) string {
+	if strings.HasPrefix(r.Key, report.PendingKeyPrefix) {
+		return r.Key + "/" + sampleType
+	}
+	for _, s := range r.SubProfiles {
+		if s.Name == sampleType {
+			return s.Key
+		}
+	}
+	return r.Key
+}
+
+// uploadProfile uploads the profile with the uploader of the context, by
+// default directly to flamegraph.com.
 func uploadProfile(ctx context.Context, logger log.Logger, body io.Reader) (*profileResponse, error) {
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://flamegraph.com", body)
+	res, err := uploaderFromContext(ctx).upload(ctx, logger, body)
+	if err != nil {
+		serviceMetricsFromContext(ctx).uploadFailed()
+	}
+	return res, err
+}
+
+// defaultFlamegraphURL is where the profiles are uploaded to by default.
+const defaultFlamegraphURL = "https://flamegraph.com"
+
+func addFlamegraphURLArg(cmd *kingpin.CmdClause, url *string) {
+	cmd.Flag("flamegraph-url", "URL to upload the profiles to, flamegraph.com or a service with the same API, e.g. a mock in tests. The reports keep linking to flamegraph.com.").Default(defaultFlamegraphURL).Envar("PYROBENCH_FLAMEGRAPH_URL").StringVar(url)
+}
+
+// flamegraphUploader uploads the profiles to flamegraph.com.
+type flamegraphUploader struct {
+	url    string
+	client *http.Client
+}
+
+// newFlamegraphUploader returns the uploader of the URL, an empty URL uploads
+// to flamegraph.com.
+func newFlamegraphUploader(url string) *flamegraphUploader {
+	if url == "" {
+		url = defaultFlamegraphURL
+	}
+	return &flamegraphUploader{url: url, client: http.DefaultClient}
+}
+
+func (u *flamegraphUploader) upload(ctx context.Context, logger log.Logger, body io.Reader) (*profileResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", u.url, body)
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +82,7 @@ func uploadProfile(ctx context.Context, logger log.Logger, body io.Reader) (*pro
 	req.Header.Set("user-agent", "pyrobench")
 	req.Header.Set("content-type", "application/octet-stream")
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := u.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
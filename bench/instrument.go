@@ -0,0 +1,108 @@
+package bench
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serviceMetrics instruments the watch mode, so the service itself can be
+// monitored. A nil serviceMetrics records nothing.
+type serviceMetrics struct {
+	comparisons       *prometheus.CounterVec
+	benchmarksRun     prometheus.Counter
+	compileDuration   *prometheus.HistogramVec
+	benchmarkDuration prometheus.Histogram
+	regressions       prometheus.Counter
+	uploadFailures    prometheus.Counter
+}
+
+func newServiceMetrics(reg prometheus.Registerer) *serviceMetrics {
+	m := &serviceMetrics{
+		comparisons: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "pyrobench",
+			Name:      "comparisons_total",
+			Help:      "Number of commits compared against their parent, by result.",
+		}, []string{"result"}),
+		benchmarksRun: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "pyrobench",
+			Name:      "benchmarks_run_total",
+			Help:      "Number of benchmarks run on base and head.",
+		}),
+		compileDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "pyrobench",
+			Name:      "compile_duration_seconds",
+			Help:      "Time spent compiling the test binary of a package.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+		}, []string{"source"}),
+		benchmarkDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "pyrobench",
+			Name:      "benchmark_duration_seconds",
+			Help:      "Time spent running a benchmark on base and head, including its repetitions.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+		}),
+		regressions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "pyrobench",
+			Name:      "regressions_total",
+			Help:      "Number of regressions beyond the threshold detected in compared commits.",
+		}),
+		uploadFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "pyrobench",
+			Name:      "upload_failures_total",
+			Help:      "Number of profiles, which failed to upload.",
+		}),
+	}
+	reg.MustRegister(m.comparisons, m.benchmarksRun, m.compileDuration, m.benchmarkDuration, m.regressions, m.uploadFailures)
+	return m
+}
+
+func (m *serviceMetrics) observeCompile(src benchSource, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.compileDuration.WithLabelValues(src.String()).Observe(d.Seconds())
+}
+
+func (m *serviceMetrics) observeBenchmark(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.benchmarksRun.Inc()
+	m.benchmarkDuration.Observe(d.Seconds())
+}
+
+func (m *serviceMetrics) observeComparison(err error, regressions int) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.comparisons.WithLabelValues("error").Inc()
+		return
+	}
+	m.comparisons.WithLabelValues("success").Inc()
+	m.regressions.Add(float64(regressions))
+}
+
+func (m *serviceMetrics) uploadFailed() {
+	if m == nil {
+		return
+	}
+	m.uploadFailures.Inc()
+}
+
+// metricsHandler serves the metrics in the Prometheus exposition format.
+func metricsHandler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
+
+func addServiceMetricsToContext(ctx context.Context, m *serviceMetrics) context.Context {
+	return context.WithValue(ctx, contextKeyServiceMetrics, m)
+}
+
+func serviceMetricsFromContext(ctx context.Context) *serviceMetrics {
+	m, _ := ctx.Value(contextKeyServiceMetrics).(*serviceMetrics)
+	return m
+}
@@ -0,0 +1,63 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceMetrics(t *testing.T) {
+	// a nil serviceMetrics records nothing
+	var nop *serviceMetrics
+	nop.observeCompile(benchSourceBase, time.Second)
+	nop.observeBenchmark(time.Second)
+	nop.observeComparison(nil, 1)
+	nop.uploadFailed()
+
+	reg := prometheus.NewRegistry()
+	m := newServiceMetrics(reg)
+	ctx := addServiceMetricsToContext(context.Background(), m)
+
+	m.observeCompile(benchSourceBase, 2*time.Second)
+	m.observeCompile(benchSourceHead, 3*time.Second)
+	m.observeBenchmark(10 * time.Second)
+	m.observeComparison(nil, 2)
+	m.observeComparison(errors.New("broken"), 0)
+
+	ctx = addUploaderToContext(ctx, uploaderFunc(func(context.Context, log.Logger, io.Reader) (*profileResponse, error) {
+		return nil, errors.New("unavailable")
+	}))
+	_, err := uploadProfile(ctx, log.NewNopLogger(), strings.NewReader("profile"))
+	require.Error(t, err)
+
+	require.Equal(t, 1.0, testutil.ToFloat64(m.benchmarksRun))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.regressions))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.uploadFailures))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.comparisons.WithLabelValues("success")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.comparisons.WithLabelValues("error")))
+
+	rec := httptest.NewRecorder()
+	metricsHandler(reg).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	for _, s := range []string{
+		`pyrobench_compile_duration_seconds_count{source="base"} 1`,
+		`pyrobench_compile_duration_seconds_count{source="head"} 1`,
+		`pyrobench_benchmark_duration_seconds_sum 10`,
+		`pyrobench_benchmarks_run_total 1`,
+		`pyrobench_regressions_total 2`,
+		`pyrobench_upload_failures_total 1`,
+	} {
+		require.Contains(t, body, s)
+	}
+}
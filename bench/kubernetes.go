@@ -0,0 +1,605 @@
+package bench
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	executorLocal      = "local"
+	executorKubernetes = "kubernetes"
+
+	// kubernetesOutDir replaces the output directory in the arguments and
+	// the environment of the test binary. It is relative to the package
+	// directory, the working directory of the test binary within the pod or
+	// on the agent.
+	kubernetesOutDir = "../out"
+
+	// kubernetesSetupTime is added to the timeout of the benchmark for the
+	// pod's deadline, as it includes pulling the image and the bundle.
+	kubernetesSetupTime = time.Minute
+)
+
+// kubernetesScript runs within the pod. It fetches the bundle with the
+// package directory and the test binary, runs the test binary with the
+// container's arguments and uploads its output and the files it wrote.
+const kubernetesScript = `set -eu
+W="${PYROBENCH_WORKDIR:-/work}"
+fetch() {
+	if [ -n "${PYROBENCH_STORAGE_TOKEN:-}" ]; then
+		curl -fsS -H "Authorization: Bearer ${PYROBENCH_STORAGE_TOKEN}" "$@"
+	else
+		curl -fsS "$@"
+	fi
+}
+mkdir -p "$W"
+cd "$W"
+fetch -o bundle.tar.gz "$PYROBENCH_BUNDLE_URL"
+tar -xzf bundle.tar.gz
+mkdir -p out
+cd pkg
+code=0
+"$W/pyrobench.test" "$@" >"$W/stdout" 2>"$W/stderr" || code=$?
+echo "$code" >"$W/exitcode"
+cd "$W"
+tar -czf result.tar.gz stdout stderr exitcode out
+fetch -X PUT --upload-file result.tar.gz "$PYROBENCH_RESULT_URL"
+`
+
+type KubernetesArgs struct {
+	Namespace      string
+	Image          string // needs sh, tar and curl
+	ServiceAccount string
+	NodeSelector   map[string]string
+	Tolerations    []string // key[=value]:effect
+	CPU            string   // requested and limited, so pods get the guaranteed QoS class
+	Memory         string
+	StorageURL     string // base URL of the object storage accepting PUT, GET and DELETE
+	StorageToken   string // bearer token for the object storage
+	StorageSecret  string // secret holding the token for the pods under the key "token"
+	Kubectl        string
+}
+
+func addExecutorArgs(cmd *kingpin.CmdClause, executor *string, args *KubernetesArgs, agent *AgentExecutorArgs) {
+	cmd.Flag("executor", "Where to run the test binaries. 'kubernetes' runs every benchmark run as a Kubernetes Job, 'agent' sends them to a 'pyrobench agent' on a dedicated machine.").Default(executorLocal).EnumVar(executor, executorLocal, executorKubernetes, executorAgent)
+	cmd.Flag("kubernetes-namespace", "Namespace to create the benchmark jobs in.").Default("default").StringVar(&args.Namespace)
+	cmd.Flag("kubernetes-image", "Container image to run the test binaries in, it needs sh, tar and curl and to match the platform the test binaries are compiled for.").StringVar(&args.Image)
+	cmd.Flag("kubernetes-service-account", "Service account of the benchmark pods.").StringVar(&args.ServiceAccount)
+	cmd.Flag("kubernetes-node-selector", "Label the nodes of the dedicated node pool need to have. Can be repeated.").PlaceHolder("KEY=VALUE").StringMapVar(&args.NodeSelector)
+	cmd.Flag("kubernetes-toleration", "Taint of the dedicated node pool to tolerate. Can be repeated.").PlaceHolder("KEY[=VALUE]:EFFECT").StringsVar(&args.Tolerations)
+	cmd.Flag("kubernetes-cpu", "CPU requested and limited for the benchmark pods.").Default("2").StringVar(&args.CPU)
+	cmd.Flag("kubernetes-memory", "Memory requested and limited for the benchmark pods.").Default("4Gi").StringVar(&args.Memory)
+	cmd.Flag("kubernetes-storage-url", "Base URL of an object storage accepting PUT, GET and DELETE requests, used to exchange test binaries and results with the pods.").PlaceHolder("URL").StringVar(&args.StorageURL)
+	cmd.Flag("kubernetes-storage-token", "Bearer token to access the object storage with.").Envar("PYROBENCH_STORAGE_TOKEN").StringVar(&args.StorageToken)
+	cmd.Flag("kubernetes-storage-secret", "Secret in the namespace holding the bearer token for the pods under the key 'token'.").StringVar(&args.StorageSecret)
+	cmd.Flag("kubectl", "Path of kubectl.").Default("kubectl").StringVar(&args.Kubectl)
+	addAgentExecutorArgs(cmd, agent)
+}
+
+// kubectlFunc runs kubectl with the arguments and returns its output.
+type kubectlFunc func(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error)
+
+func newKubectl(path string) kubectlFunc {
+	return func(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+		var stdout, stderr bytes.Buffer
+		c := exec.CommandContext(ctx, path, args...)
+		c.Stdin = stdin
+		c.Stdout = &stdout
+		c.Stderr = &stderr
+		if err := c.Run(); err != nil {
+			return nil, fmt.Errorf("kubectl %s: %w: %s", strings.Join(args, " "), err, stderr.String())
+		}
+		return stdout.Bytes(), nil
+	}
+}
+
+// objectStore stores objects by key under a base URL, which is reachable
+// from the pods as well.
+type objectStore struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func (s *objectStore) url(key string) string {
+	return strings.TrimSuffix(s.base, "/") + "/" + key
+}
+
+func (s *objectStore) do(ctx context.Context, method, key string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: unexpected status %s", method, key, resp.Status)
+	}
+	return resp, nil
+}
+
+func (s *objectStore) put(ctx context.Context, key string, body []byte) error {
+	resp, err := s.do(ctx, http.MethodPut, key, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *objectStore) get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *objectStore) delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// kubernetesExecutor runs every test binary invocation as a Kubernetes Job.
+// The test binary and the package directory are handed over to the pod as a
+// bundle in the object storage, the pod uploads its results next to it.
+type kubernetesExecutor struct {
+	logger       log.Logger
+	args         *KubernetesArgs
+	store        *objectStore
+	kubectl      kubectlFunc
+	pollInterval time.Duration
+}
+
+func newKubernetesExecutor(logger log.Logger, args *KubernetesArgs) (*kubernetesExecutor, error) {
+	if args.Image == "" {
+		return nil, errors.New("--executor=kubernetes requires --kubernetes-image")
+	}
+	if args.StorageURL == "" {
+		return nil, errors.New("--executor=kubernetes requires --kubernetes-storage-url")
+	}
+	if err := validStorageURL(args.StorageURL); err != nil {
+		return nil, err
+	}
+	for _, t := range args.Tolerations {
+		if _, err := parseToleration(t); err != nil {
+			return nil, err
+		}
+	}
+	return &kubernetesExecutor{
+		logger:       logger,
+		args:         args,
+		store:        &objectStore{base: args.StorageURL, token: args.StorageToken, client: http.DefaultClient},
+		kubectl:      newKubectl(args.Kubectl),
+		pollInterval: 2 * time.Second,
+	}, nil
+}
+
+// parseToleration parses a toleration given as key[=value]:effect.
+func parseToleration(s string) (map[string]string, error) {
+	kv, effect, ok := strings.Cut(s, ":")
+	if !ok || kv == "" {
+		return nil, fmt.Errorf("invalid toleration %q, expected key[=value]:effect", s)
+	}
+	t := map[string]string{"key": kv, "operator": "Exists"}
+	if k, v, ok := strings.Cut(kv, "="); ok {
+		t = map[string]string{"key": k, "operator": "Equal", "value": v}
+	}
+	if effect != "" {
+		t["effect"] = effect
+	}
+	return t, nil
+}
+
+// relocate replaces the directory dir at the start of a path with to.
+func relocate(p, dir, to string) string {
+	if p == dir {
+		return to
+	}
+	if rest, ok := strings.CutPrefix(p, dir+string(filepath.Separator)); ok {
+		return path.Join(to, filepath.ToSlash(rest))
+	}
+	return p
+}
+
+// relocateCommand returns the arguments and the environment of the command
+// with the output directory replaced by kubernetesOutDir.
+func relocateCommand(cmd *benchCommand) (args, env []string) {
+	args = make([]string, len(cmd.args))
+	for i, a := range cmd.args {
+		args[i] = relocate(a, cmd.outDir, kubernetesOutDir)
+	}
+	env = make([]string, len(cmd.env))
+	for i, e := range cmd.env {
+		n, v, _ := strings.Cut(e, "=")
+		env[i] = n + "=" + relocate(v, cmd.outDir, kubernetesOutDir)
+	}
+	return args, env
+}
+
+// addTarFiles adds the directory tree below dir to the archive with the
+// given prefix.
+func addTarFiles(tw *tar.Writer, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		name := path.Join(prefix, filepath.ToSlash(rel))
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case d.IsDir():
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755, ModTime: info.ModTime()})
+		case info.Mode().IsRegular():
+			return addTarFile(tw, p, name, int64(info.Mode().Perm()))
+		}
+		// symlinks and other special files are skipped
+		return nil
+	})
+}
+
+func addTarFile(tw *tar.Writer, p, name string, mode int64) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: mode, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// bundlePackage archives the package directory, the test binary and the
+// current content of the output directory.
+func bundlePackage(p *Package, outDir string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := writeBundle(buf, p, outDir, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBundle streams the archive of bundlePackage to w. The spec of an agent
+// run is added as spec.json, unless it is nil.
+func writeBundle(w io.Writer, p *Package, outDir string, spec []byte) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	if spec != nil {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "spec.json", Mode: 0o644, Size: int64(len(spec)), ModTime: time.Now()}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(spec); err != nil {
+			return err
+		}
+	}
+	if err := addTarFiles(tw, p.meta.Dir, "pkg"); err != nil {
+		return err
+	}
+	if err := addTarFiles(tw, outDir, "out"); err != nil {
+		return err
+	}
+	if err := addTarFile(tw, p.testBinary, "pyrobench.test", 0o755); err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// jobManifest returns the job running the test binary with the arguments.
+func (k *kubernetesExecutor) jobManifest(name, bundleKey, resultKey string, args, env []string, deadline time.Duration) ([]byte, error) {
+	containerEnv := []map[string]any{
+		{"name": "PYROBENCH_BUNDLE_URL", "value": k.store.url(bundleKey)},
+		{"name": "PYROBENCH_RESULT_URL", "value": k.store.url(resultKey)},
+	}
+	if k.args.StorageSecret != "" {
+		containerEnv = append(containerEnv, map[string]any{
+			"name": "PYROBENCH_STORAGE_TOKEN",
+			"valueFrom": map[string]any{
+				"secretKeyRef": map[string]string{"name": k.args.StorageSecret, "key": "token"},
+			},
+		})
+	}
+	for _, e := range env {
+		n, v, _ := strings.Cut(e, "=")
+		containerEnv = append(containerEnv, map[string]any{"name": n, "value": v})
+	}
+
+	resources := map[string]string{}
+	if k.args.CPU != "" {
+		resources["cpu"] = k.args.CPU
+	}
+	if k.args.Memory != "" {
+		resources["memory"] = k.args.Memory
+	}
+
+	podSpec := map[string]any{
+		"restartPolicy": "Never",
+		"containers": []map[string]any{{
+			"name":      "benchmark",
+			"image":     k.args.Image,
+			"command":   []string{"/bin/sh", "-c", kubernetesScript, "pyrobench"},
+			"args":      args,
+			"env":       containerEnv,
+			"resources": map[string]any{"requests": resources, "limits": resources},
+		}},
+	}
+	if k.args.ServiceAccount != "" {
+		podSpec["serviceAccountName"] = k.args.ServiceAccount
+	}
+	if len(k.args.NodeSelector) > 0 {
+		podSpec["nodeSelector"] = k.args.NodeSelector
+	}
+	var tolerations []map[string]string
+	for _, s := range k.args.Tolerations {
+		t, err := parseToleration(s)
+		if err != nil {
+			return nil, err
+		}
+		tolerations = append(tolerations, t)
+	}
+	if len(tolerations) > 0 {
+		podSpec["tolerations"] = tolerations
+	}
+
+	labels := map[string]string{"app.kubernetes.io/managed-by": "pyrobench"}
+	spec := map[string]any{
+		"backoffLimit":            0,
+		"ttlSecondsAfterFinished": 600,
+		"template": map[string]any{
+			"metadata": map[string]any{"labels": labels},
+			"spec":     podSpec,
+		},
+	}
+	if deadline > 0 {
+		spec["activeDeadlineSeconds"] = int64(deadline.Seconds())
+	}
+	return json.Marshal(map[string]any{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata": map[string]any{
+			"name":      name,
+			"namespace": k.args.Namespace,
+			"labels":    labels,
+		},
+		"spec": spec,
+	})
+}
+
+// waitForJob polls the job's conditions until it completed or failed.
+func (k *kubernetesExecutor) waitForJob(ctx context.Context, name string) (bool, error) {
+	ticker := time.NewTicker(k.pollInterval)
+	defer ticker.Stop()
+	for {
+		out, err := k.kubectl(ctx, nil, "get", "job", name, "--namespace", k.args.Namespace, "-o", `jsonpath={range .status.conditions[*]}{.type}={.status}{"\n"}{end}`)
+		if err != nil {
+			return false, err
+		}
+		for _, line := range strings.Fields(string(out)) {
+			switch line {
+			case "Complete=True":
+				return true, nil
+			case "Failed=True":
+				return false, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// extractResult writes the output of the test binary to the command's
+// writers and the files it wrote to the output directory. It returns the
+// exit code of the test binary.
+func extractResult(r io.Reader, cmd *benchCommand) (int, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	code := -1
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+		name := path.Clean(h.Name)
+		switch {
+		case name == "stdout":
+			_, err = io.Copy(cmd.stdout, tr)
+		case name == "stderr":
+			_, err = io.Copy(cmd.stderr, tr)
+		case name == "exitcode":
+			var b []byte
+			if b, err = io.ReadAll(tr); err == nil {
+				code, err = strconv.Atoi(strings.TrimSpace(string(b)))
+			}
+		case strings.HasPrefix(name, "out/") && h.Typeflag == tar.TypeReg:
+			err = extractFile(tr, filepath.Join(cmd.outDir, filepath.FromSlash(strings.TrimPrefix(name, "out/"))), cmd.outDir)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if code < 0 {
+		return 0, errors.New("result lacks the exit code of the test binary")
+	}
+	return code, nil
+}
+
+func extractFile(r io.Reader, p, dir string) error {
+	if rel, err := filepath.Rel(dir, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("result file %s leaves the output directory", p)
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (k *kubernetesExecutor) run(ctx context.Context, p *Package, cmd *benchCommand) (*execution, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	name := "pyrobench-" + hex.EncodeToString(id)
+	bundleKey, resultKey := name+"/bundle.tar.gz", name+"/result.tar.gz"
+	logger := log.With(k.logger, "job", name, "package", p.meta.ImportPath)
+
+	bundle, err := bundlePackage(p, cmd.outDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bundle test binary: %w", err)
+	}
+	if err := k.store.put(ctx, bundleKey, bundle); err != nil {
+		return nil, fmt.Errorf("failed to upload bundle: %w", err)
+	}
+
+	args, env := relocateCommand(cmd)
+	var deadline time.Duration
+	if timeout := cmd.remoteTimeout(ctx); timeout > 0 {
+		deadline = timeout + kubernetesSetupTime
+	}
+	manifest, err := k.jobManifest(name, bundleKey, resultKey, args, env, deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	// clean up, even when the benchmark has been canceled
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if _, err := k.kubectl(cleanupCtx, nil, "delete", "job", name, "--namespace", k.args.Namespace, "--ignore-not-found", "--cascade=background", "--wait=false"); err != nil {
+			level.Warn(logger).Log("msg", "error deleting job", "err", err)
+		}
+		for _, key := range []string{bundleKey, resultKey} {
+			if err := k.store.delete(cleanupCtx, key); err != nil {
+				level.Debug(logger).Log("msg", "error deleting object", "key", key, "err", err)
+			}
+		}
+	}()
+
+	e := &execution{started: time.Now(), remote: true}
+	if _, err := k.kubectl(ctx, bytes.NewReader(manifest), "apply", "--namespace", k.args.Namespace, "-f", "-"); err != nil {
+		return nil, err
+	}
+	level.Debug(logger).Log("msg", "created benchmark job")
+
+	complete, err := k.waitForJob(ctx, name)
+	e.exited = time.Now()
+	if err != nil {
+		return e, err
+	}
+
+	result, err := k.store.get(ctx, resultKey)
+	if err != nil {
+		if !complete {
+			return e, fmt.Errorf("job %s failed without result: %w", name, err)
+		}
+		return e, fmt.Errorf("failed to download result: %w", err)
+	}
+	code, err := extractResult(bytes.NewReader(result), cmd)
+	if err != nil {
+		return e, fmt.Errorf("failed to extract result: %w", err)
+	}
+	if code != 0 {
+		return e, fmt.Errorf("test binary exited with code %d", code)
+	}
+	return e, nil
+}
+
+// executorContext adds the executor selected by the arguments to the context.
+func (b *Benchmark) executorContext(ctx context.Context, executor string, args *KubernetesArgs, agent *AgentExecutorArgs) (context.Context, error) {
+	switch executor {
+	case executorKubernetes:
+		e, err := newKubernetesExecutor(b.logger, args)
+		if err != nil {
+			return nil, err
+		}
+		level.Info(b.logger).Log("msg", "running benchmarks as Kubernetes jobs", "namespace", args.Namespace, "image", args.Image)
+		return addExecutorToContext(ctx, e), nil
+	case executorAgent:
+		e, err := newAgentExecutor(b.logger, agent)
+		if err != nil {
+			return nil, err
+		}
+		info, err := e.info(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("agent %s is not reachable: %w", agent.URL, err)
+		}
+		level.Info(b.logger).Log("msg", "running benchmarks on agent", "url", agent.URL, "platform", info.GOOS+"/"+info.GOARCH, "cpus", info.CPUs)
+		return addExecutorToContext(ctx, e), nil
+	}
+	return ctx, nil
+}
+
+// validStorageURL checks, that the object storage is addressed by HTTP(S).
+func validStorageURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported object storage URL %q, expected http(s)", s)
+	}
+	return nil
+}
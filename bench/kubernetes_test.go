@@ -0,0 +1,211 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryObjectStore serves objects from memory and requires a bearer token.
+type memoryObjectStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memoryObjectStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		body, _ := io.ReadAll(r.Body)
+		s.objects[r.URL.Path] = body
+	case http.MethodGet:
+		body, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(body)
+	case http.MethodDelete:
+		delete(s.objects, r.URL.Path)
+	}
+}
+
+// fakeKubectl runs the container of applied jobs right away with sh instead
+// of creating them in a cluster.
+type fakeKubectl struct {
+	t       *testing.T
+	token   string
+	applied []map[string]any
+	deleted []string
+}
+
+func (k *fakeKubectl) run(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	switch args[0] {
+	case "apply":
+		var job map[string]any
+		if err := json.NewDecoder(stdin).Decode(&job); err != nil {
+			return nil, err
+		}
+		k.applied = append(k.applied, job)
+
+		container := job["spec"].(map[string]any)["template"].(map[string]any)["spec"].(map[string]any)["containers"].([]any)[0].(map[string]any)
+		var command []string
+		for _, c := range container["command"].([]any) {
+			command = append(command, c.(string))
+		}
+		for _, a := range container["args"].([]any) {
+			command = append(command, a.(string))
+		}
+		env := []string{"PATH=" + os.Getenv("PATH"), "PYROBENCH_WORKDIR=" + k.t.TempDir(), "PYROBENCH_STORAGE_TOKEN=" + k.token}
+		for _, e := range container["env"].([]any) {
+			e := e.(map[string]any)
+			if v, ok := e["value"]; ok {
+				env = append(env, fmt.Sprintf("%s=%s", e["name"], v))
+			}
+		}
+		c := exec.CommandContext(ctx, command[0], command[1:]...)
+		c.Env = env
+		if out, err := c.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, out)
+		}
+		return nil, nil
+	case "get":
+		return []byte("Complete=True\n"), nil
+	case "delete":
+		k.deleted = append(k.deleted, args[2])
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unexpected kubectl command %v", args)
+}
+
+func TestKubernetesExecutor(t *testing.T) {
+	for _, tool := range []string{"sh", "curl", "tar"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is required to run the job script: %v", tool, err)
+		}
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.22\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "remote", "testdata"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "remote", "remote.go"), []byte("package remote\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "remote", "testdata", "input.txt"), []byte("input"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "remote", "remote_test.go"), []byte(`package remote
+
+import (
+	"os"
+	"testing"
+)
+
+func BenchmarkRead(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := os.ReadFile("testdata/input.txt"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+`), 0o644))
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	t.Cleanup(func() {
+		require.NoError(t, cleaner.cleanup())
+	})
+
+	pkgs, err := discoverPackages(ctx, log.NewNopLogger(), dir, []string{"./..."}, nil)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	p := &pkgs[0]
+	require.NoError(t, p.compileTest(ctx))
+
+	store := &memoryObjectStore{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(store)
+	t.Cleanup(srv.Close)
+
+	kubectl := &fakeKubectl{t: t, token: "secret"}
+	e, err := newKubernetesExecutor(log.NewNopLogger(), &KubernetesArgs{
+		Namespace:    "bench",
+		Image:        "alpine",
+		NodeSelector: map[string]string{"pool": "bench"},
+		Tolerations:  []string{"dedicated=bench:NoSchedule"},
+		CPU:          "2",
+		Memory:       "1Gi",
+		StorageURL:   srv.URL + "/prefix",
+		StorageToken: "secret",
+	})
+	require.NoError(t, err)
+	e.kubectl = kubectl.run
+	e.pollInterval = time.Millisecond
+
+	var uploads int
+	ctx = addExecutorToContext(ctx, e)
+	ctx = addUploaderToContext(ctx, uploaderFunc(func(context.Context, log.Logger, io.Reader) (*profileResponse, error) {
+		uploads++
+		return &profileResponse{Key: fmt.Sprintf("key-%d", uploads)}, nil
+	}))
+
+	res, err := p.runBenchmark(ctx, runOptions{benchTime: "10x", count: 2, timeout: time.Minute, gcTrace: true}, "BenchmarkRead")
+	require.NoError(t, err)
+	require.Len(t, res.RawResult, 2)
+	require.NotEmpty(t, res.CPU.Key)
+	require.NotEmpty(t, res.AllocSpace.Key)
+	require.Nil(t, res.TestMain, "scheduling is part of the remote run")
+
+	require.Len(t, kubectl.applied, 1)
+	job := kubectl.applied[0]
+	name := job["metadata"].(map[string]any)["name"].(string)
+	require.True(t, strings.HasPrefix(name, "pyrobench-"), name)
+	require.Equal(t, "bench", job["metadata"].(map[string]any)["namespace"])
+	spec := job["spec"].(map[string]any)
+	require.EqualValues(t, 120, spec["activeDeadlineSeconds"], "the run timeout and the setup time")
+	pod := spec["template"].(map[string]any)["spec"].(map[string]any)
+	require.Equal(t, map[string]any{"pool": "bench"}, pod["nodeSelector"])
+	require.Equal(t, []any{map[string]any{"key": "dedicated", "operator": "Equal", "value": "bench", "effect": "NoSchedule"}}, pod["tolerations"])
+	container := pod["containers"].([]any)[0].(map[string]any)
+	require.Contains(t, container["args"], "../out/cpu.pprof")
+
+	// the job and its objects are cleaned up
+	require.Equal(t, []string{name}, kubectl.deleted)
+	require.Empty(t, store.objects)
+}
+
+func TestKubernetesExecutorArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args        KubernetesArgs
+		expectedErr string
+	}{
+		{KubernetesArgs{StorageURL: "https://storage"}, "--executor=kubernetes requires --kubernetes-image"},
+		{KubernetesArgs{Image: "alpine"}, "--executor=kubernetes requires --kubernetes-storage-url"},
+		{KubernetesArgs{Image: "alpine", StorageURL: "s3://bucket"}, `unsupported object storage URL "s3://bucket", expected http(s)`},
+		{KubernetesArgs{Image: "alpine", StorageURL: "https://storage", Tolerations: []string{"dedicated"}}, `invalid toleration "dedicated", expected key[=value]:effect`},
+	} {
+		_, err := newKubernetesExecutor(log.NewNopLogger(), &tc.args)
+		require.EqualError(t, err, tc.expectedErr)
+	}
+}
+
+func TestRelocate(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "pyrotest-pprof-out")
+	require.Equal(t, "../out", relocate(dir, dir, kubernetesOutDir))
+	require.Equal(t, "../out/goroutines", relocate(filepath.Join(dir, "goroutines"), dir, kubernetesOutDir))
+	require.Equal(t, dir+"2/cpu.pprof", relocate(dir+"2/cpu.pprof", dir, kubernetesOutDir))
+	require.Equal(t, "gctrace=1", relocate("gctrace=1", dir, kubernetesOutDir))
+}
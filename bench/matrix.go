@@ -0,0 +1,150 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+type MatrixArgs struct {
+	Platforms []string // GOOS/GOARCH to compare on, disabled when empty
+	Agents    []string // URLs of the agents running the platforms
+}
+
+func addMatrixArgs(cmd *kingpin.CmdClause) *MatrixArgs {
+	args := &MatrixArgs{}
+	cmd.Flag("matrix-platform", "Compare on this platform as well, e.g. linux/arm64. The test binaries are cross-compiled and run on a --matrix-agent of the platform, the report shows the platforms side by side. Can be repeated.").PlaceHolder("GOOS/GOARCH").StringsVar(&args.Platforms)
+	cmd.Flag("matrix-agent", "URL of a 'pyrobench agent' running the benchmarks of its platform in the matrix, authenticated with --agent-token. Can be repeated.").PlaceHolder("URL").StringsVar(&args.Agents)
+	return args
+}
+
+func (args *MatrixArgs) enabled() bool {
+	return args != nil && len(args.Platforms) > 0
+}
+
+// matrixAgents returns the URL of the agent of every platform, the first
+// agent reporting a platform runs it.
+func matrixAgents(ctx context.Context, logger log.Logger, args *MatrixArgs, token string) (map[string]string, error) {
+	if len(args.Agents) == 0 {
+		return nil, errors.New("--matrix-platform requires --matrix-agent")
+	}
+	byPlatform := make(map[string]string, len(args.Agents))
+	for _, u := range args.Agents {
+		e, err := newAgentExecutor(logger, &AgentExecutorArgs{URL: u, Token: token})
+		if err != nil {
+			return nil, err
+		}
+		info, err := e.info(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("agent %s is not reachable: %w", u, err)
+		}
+		platform := info.GOOS + "/" + info.GOARCH
+		if _, ok := byPlatform[platform]; !ok {
+			byPlatform[platform] = u
+		}
+	}
+
+	agents := make(map[string]string, len(args.Platforms))
+	for _, platform := range args.Platforms {
+		if _, _, ok := strings.Cut(platform, "/"); !ok {
+			return nil, fmt.Errorf("invalid --matrix-platform %q, expected GOOS/GOARCH", platform)
+		}
+		u, ok := byPlatform[platform]
+		if !ok {
+			return nil, fmt.Errorf("no --matrix-agent runs %s", platform)
+		}
+		agents[platform] = u
+	}
+	return agents, nil
+}
+
+// compareMatrix compares base and head once per platform of the matrix, each
+// on the agent of the platform, and sends the combined progress of all
+// platforms to updateCh. Without a matrix it compares on a single platform.
+func (b *Benchmark) compareMatrix(ctx context.Context, args *CompareArgs, updateCh chan *report.BenchmarkReport, filter ...*BenchmarkFilter) (*report.BenchmarkReport, error) {
+	if !args.Matrix.enabled() {
+		return b.compareWithReporter(ctx, args, updateCh, filter...)
+	}
+	var token string
+	if args.Agent != nil {
+		token = args.Agent.Token
+	}
+	agents, err := matrixAgents(ctx, b.logger, args.Matrix, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []*report.BenchmarkReport
+	for i, platform := range args.Matrix.Platforms {
+		last := i == len(args.Matrix.Platforms)-1
+		level.Info(b.logger).Log("msg", "comparing on platform of the matrix", "platform", platform, "agent", agents[platform])
+
+		platformArgs := *args
+		platformArgs.Matrix = nil
+		platformArgs.Executor = executorAgent
+		platformArgs.Agent = &AgentExecutorArgs{URL: agents[platform], Token: token}
+		goEnv := GoEnvArgs{}
+		if args.GoEnv != nil {
+			goEnv = *args.GoEnv
+		}
+		goEnv.Platform = platform
+		platformArgs.GoEnv = &goEnv
+		if args.ArtifactsDir != "" {
+			platformArgs.ArtifactsDir = filepath.Join(args.ArtifactsDir, strings.ReplaceAll(platform, "/", "-"))
+		}
+
+		ch := make(chan *report.BenchmarkReport)
+		forwarded := make(chan *report.BenchmarkReport, 1)
+		go func() {
+			var latest *report.BenchmarkReport
+			for rpt := range ch {
+				latest = withPlatform(rpt, platform)
+				merged, err := report.Merge(append(done[:len(done):len(done)], latest)...)
+				if err != nil {
+					level.Warn(b.logger).Log("msg", "error combining the reports of the platforms", "err", err)
+					continue
+				}
+				// the platforms still to come are missing
+				merged.Finished = merged.Finished && last
+				merged.Completed = merged.Completed && last
+				updateCh <- merged
+			}
+			forwarded <- latest
+		}()
+
+		fb := b.fresh()
+		fb.logger = log.With(b.logger, "platform", platform)
+		_, err := fb.compareWithReporter(ctx, &platformArgs, ch, filter...)
+		close(ch)
+		if latest := <-forwarded; latest != nil {
+			done = append(done, latest)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error comparing on %s: %w", platform, err)
+		}
+	}
+	if len(done) == 0 {
+		return nil, nil
+	}
+	return report.Merge(done...)
+}
+
+// withPlatform returns a copy of the report with its runs marked as run on
+// the platform.
+func withPlatform(rpt *report.BenchmarkReport, platform string) *report.BenchmarkReport {
+	c := *rpt
+	c.Runs = make([]report.BenchmarkRun, len(rpt.Runs))
+	for i, r := range rpt.Runs {
+		r.Platform = platform
+		c.Runs[i] = r
+	}
+	return &c
+}
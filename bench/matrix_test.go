@@ -0,0 +1,54 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func testMatrixAgent(t *testing.T, goos, goarch string) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, agentInfoPath, r.URL.Path)
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		_ = json.NewEncoder(w).Encode(agentInfo{GOOS: goos, GOARCH: goarch, CPUs: 4})
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestMatrixAgents(t *testing.T) {
+	amd64 := testMatrixAgent(t, "linux", "amd64")
+	arm64 := testMatrixAgent(t, "linux", "arm64")
+	otherArm64 := testMatrixAgent(t, "linux", "arm64")
+	ctx := context.Background()
+
+	agents, err := matrixAgents(ctx, log.NewNopLogger(), &MatrixArgs{
+		Platforms: []string{"linux/arm64", "linux/amd64"},
+		Agents:    []string{amd64, arm64, otherArm64},
+	}, "secret")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"linux/amd64": amd64, "linux/arm64": arm64}, agents)
+
+	_, err = matrixAgents(ctx, log.NewNopLogger(), &MatrixArgs{Platforms: []string{"darwin/arm64"}, Agents: []string{amd64}}, "secret")
+	require.EqualError(t, err, "no --matrix-agent runs darwin/arm64")
+
+	_, err = matrixAgents(ctx, log.NewNopLogger(), &MatrixArgs{Platforms: []string{"linux"}, Agents: []string{amd64}}, "secret")
+	require.EqualError(t, err, `invalid --matrix-platform "linux", expected GOOS/GOARCH`)
+
+	_, err = matrixAgents(ctx, log.NewNopLogger(), &MatrixArgs{Platforms: []string{"linux/amd64"}}, "secret")
+	require.EqualError(t, err, "--matrix-platform requires --matrix-agent")
+}
+
+func TestWithPlatform(t *testing.T) {
+	rpt := &report.BenchmarkReport{Runs: []report.BenchmarkRun{{Name: "pkg.BenchmarkA"}}}
+	tagged := withPlatform(rpt, "linux/arm64")
+	require.Equal(t, "linux/arm64", tagged.Runs[0].Platform)
+	require.Empty(t, rpt.Runs[0].Platform, "the original report must not be modified")
+}
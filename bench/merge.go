@@ -0,0 +1,74 @@
+package bench
+
+import (
+	"context"
+	"errors"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/gerrit"
+	"github.com/grafana/pyrobench/github"
+	"github.com/grafana/pyrobench/report"
+)
+
+type MergeReportsArgs struct {
+	Files  []string
+	Report *report.Args
+	GitHub *github.Args
+	Gerrit *gerrit.Args
+}
+
+func AddMergeReportsCommand(app *kingpin.Application) (*kingpin.CmdClause, *MergeReportsArgs) {
+	cmd := app.Command("merge-reports", "Merge the JSON reports of the shards of a comparison, written with --shard-index and --report-json, and report them as one.")
+	args := &MergeReportsArgs{
+		Report: report.AddArgs(cmd),
+		GitHub: github.AddArgs(cmd),
+		Gerrit: gerrit.AddArgs(cmd),
+	}
+	cmd.Arg("files", "JSON reports of the shards.").Required().ExistingFilesVar(&args.Files)
+	return cmd, args
+}
+
+// MergeReports combines the reports of the shards and sends the result to the
+// reporters, as if the comparison had run in a single job.
+func (b *Benchmark) MergeReports(ctx context.Context, args *MergeReportsArgs) error {
+	reports := make([]*report.BenchmarkReport, 0, len(args.Files))
+	for _, f := range args.Files {
+		r, err := report.ReadJSONFile(f)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, r)
+	}
+	rpt, err := report.Merge(reports...)
+	if err != nil {
+		return err
+	}
+
+	ctx, closeEvents, err := b.openEvents(ctx, args.Report)
+	if err != nil {
+		return err
+	}
+	defer closeEvents()
+
+	updateCh := make(chan *report.BenchmarkReport)
+	reporter, err := b.newReporter(&CompareArgs{Report: args.Report, GitHub: args.GitHub, Gerrit: args.Gerrit}, updateCh)
+	if err != nil {
+		return err
+	}
+	select {
+	case updateCh <- rpt:
+	case <-ctx.Done():
+	}
+	close(updateCh)
+	if err := reporter.Stop(); err != nil {
+		level.Warn(b.logger).Log("msg", "error stopping reporter", "err", err)
+	}
+
+	b.printResults(rpt, args.Report.PercentageThreshold)
+	if !rpt.Finished {
+		return errors.New("not all shards have finished")
+	}
+	return rpt.Error
+}
@@ -0,0 +1,122 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// Metric is a custom value derived from a profile of a benchmark run.
+type Metric struct {
+	Name  string // shown as resource in the report, must be unique
+	Unit  string // "ns", "bytes", "" for counts, otherwise the unit of the sample type
+	Value int64
+}
+
+// MetricExtractor derives custom metrics from a profile. It is called with
+// the cpu, alloc_space, alloc_objects, inuse_space and inuse_objects profile
+// and the profiles of custom sample types of every benchmark run, each
+// containing only a single sample type. The
+// metrics are compared between base and head like the built-in resources.
+type MetricExtractor func(prof *profile.Profile) []Metric
+
+// WithMetricExtractor registers an extractor for custom metrics.
+func WithMetricExtractor(f MetricExtractor) Option {
+	return func(b *Benchmark) {
+		b.metricExtractors = append(b.metricExtractors, f)
+	}
+}
+
+// PackageMetric returns an extractor for the resources spent within a
+// package, including the functions called by it.
+func PackageMetric(importPath string) MetricExtractor {
+	symbolPath := escapeImportPath(importPath)
+	return func(prof *profile.Profile) []Metric {
+		if len(prof.SampleType) != 1 {
+			return nil
+		}
+
+		var value int64
+		for _, s := range prof.Sample {
+			if sampleInPackage(s, symbolPath) {
+				value += s.Value[0]
+			}
+		}
+
+		st := prof.SampleType[0]
+		return []Metric{{
+			Name:  fmt.Sprintf("%s in %s", st.Type, importPath),
+			Unit:  sampleUnit(st.Unit),
+			Value: value,
+		}}
+	}
+}
+
+// escapeImportPath escapes the dots in the last element of the import path,
+// like the linker does for symbol names.
+func escapeImportPath(importPath string) string {
+	slash := strings.LastIndexByte(importPath, '/')
+	return importPath[:slash+1] + strings.ReplaceAll(importPath[slash+1:], ".", "%2e")
+}
+
+func sampleInPackage(s *profile.Sample, symbolPath string) bool {
+	for _, loc := range s.Location {
+		for _, line := range loc.Line {
+			if line.Function != nil && functionPackage(line.Function.Name) == symbolPath {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// functionPackage returns the escaped import path of a function's symbol
+// name, e.g. "example.com/pkg" for "example.com/pkg.(*T).Method".
+func functionPackage(name string) string {
+	slash := strings.LastIndexByte(name, '/')
+	dot := strings.IndexByte(name[slash+1:], '.')
+	if dot < 0 {
+		return name
+	}
+	return name[:slash+1+dot]
+}
+
+// metricResult is a custom metric, together with the flamegraph of the
+// profile it has been derived from.
+type metricResult struct {
+	Metric
+	Key   string
+	PerOp bool // reported per iteration, as the profile accumulates over them
+}
+
+// perOpProfile returns whether the values of the profile accumulate over the
+// iterations of the benchmark, unlike the heap retained after them.
+func perOpProfile(name string) bool {
+	return name != "inuse_space" && name != "inuse_objects"
+}
+
+func addMetricExtractorsToContext(ctx context.Context, extractors []MetricExtractor) context.Context {
+	return context.WithValue(ctx, contextKeyMetricExtractors, extractors)
+}
+
+func metricExtractorsFromContext(ctx context.Context) []MetricExtractor {
+	extractors, _ := ctx.Value(contextKeyMetricExtractors).([]MetricExtractor)
+	return extractors
+}
+
+// extractMetrics runs all extractors on the profile.
+func extractMetrics(extractors []MetricExtractor, prof *profile.Profile, key string, perOp bool) []metricResult {
+	var result []metricResult
+	for _, f := range extractors {
+		for _, m := range f(prof) {
+			result = append(result, metricResult{Metric: m, Key: key, PerOp: perOp})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
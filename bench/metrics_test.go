@@ -0,0 +1,105 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/perf/benchfmt"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestFunctionPackage(t *testing.T) {
+	for name, expected := range map[string]string{
+		"main.main":                          "main",
+		"example.com/pkg.Func":               "example.com/pkg",
+		"example.com/pkg.(*T).Method":        "example.com/pkg",
+		"example.com/pkg/sub.T.Method.func1": "example.com/pkg/sub",
+		"gopkg.in/yaml%2ev3.Unmarshal":       "gopkg.in/yaml%2ev3",
+		"runtime.mallocgc":                   "runtime",
+	} {
+		require.Equal(t, expected, functionPackage(name), name)
+	}
+}
+
+func TestPackageMetric(t *testing.T) {
+	fn := func(id uint64, name string) *profile.Location {
+		return &profile.Location{ID: id, Line: []profile.Line{{Function: &profile.Function{ID: id, Name: name}}}}
+	}
+	encode := fn(1, "encoding/json.Marshal")
+	alloc := fn(2, "runtime.mallocgc")
+	handler := fn(3, "example.com/app.handler")
+
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{alloc, encode, handler}, Value: []int64{30}},
+			{Location: []*profile.Location{encode, handler}, Value: []int64{20}},
+			{Location: []*profile.Location{handler}, Value: []int64{50}},
+		},
+	}
+
+	metrics := extractMetrics([]MetricExtractor{
+		PackageMetric("encoding/json"),
+		PackageMetric("runtime"),
+	}, prof, "key", true)
+	require.Equal(t, "gopkg.in/yaml%2ev3", escapeImportPath("gopkg.in/yaml.v3"))
+	require.Equal(t, []metricResult{
+		{Metric: Metric{Name: "cpu in encoding/json", Unit: "ns", Value: 50}, Key: "key", PerOp: true},
+		{Metric: Metric{Name: "cpu in runtime", Unit: "ns", Value: 30}, Key: "key", PerOp: true},
+	}, metrics)
+}
+
+func TestAddResultMetrics(t *testing.T) {
+	var b bench
+	b.addResult(benchSourceBase, &benchmarkResult{
+		CPU:     profileResult{Key: "cpu-base", Total: 100},
+		Metrics: []metricResult{{Metric: Metric{Name: "cpu in runtime", Unit: "ns", Value: 10}, Key: "cpu-base"}},
+	})
+	b.addResult(benchSourceHead, &benchmarkResult{
+		CPU:     profileResult{Key: "cpu-head", Total: 100},
+		Metrics: []metricResult{{Metric: Metric{Name: "cpu in runtime", Unit: "ns", Value: 20}, Key: "cpu-head"}},
+	})
+
+	var custom *report.BenchmarkResult
+	for idx := range b.results {
+		if b.results[idx].Name == "cpu in runtime" {
+			custom = &b.results[idx]
+		}
+	}
+	require.NotNil(t, custom)
+	diff, ok := custom.Diff()
+	require.True(t, ok)
+	require.Equal(t, 100.0, diff)
+}
+
+func TestAddResultMetricsPerOp(t *testing.T) {
+	// head ran twice the iterations of base with a time based -benchtime
+	var b bench
+	b.addResult(benchSourceBase, &benchmarkResult{
+		RawResult: []*benchfmt.Result{{Iters: 10}},
+		CPU:       profileResult{Key: "cpu-base", Total: 100},
+		Metrics: []metricResult{
+			{Metric: Metric{Name: "cpu in runtime", Unit: "ns", Value: 100}, Key: "cpu-base", PerOp: true},
+			{Metric: Metric{Name: "inuse_space in runtime", Unit: "bytes", Value: 1000}, Key: "inuse-base"},
+		},
+	})
+	b.addResult(benchSourceHead, &benchmarkResult{
+		RawResult: []*benchfmt.Result{{Iters: 20}},
+		CPU:       profileResult{Key: "cpu-head", Total: 200},
+		Metrics: []metricResult{
+			{Metric: Metric{Name: "cpu in runtime", Unit: "ns", Value: 200}, Key: "cpu-head", PerOp: true},
+			{Metric: Metric{Name: "inuse_space in runtime", Unit: "bytes", Value: 1000}, Key: "inuse-head"},
+		},
+	})
+
+	values := make(map[string][2]int64)
+	for _, r := range b.results {
+		values[r.Name] = [2]int64{r.BaseValue.ProfileValue, r.HeadValue.ProfileValue}
+	}
+	require.Equal(t, [2]int64{10, 10}, values["cpu in runtime"])
+	require.Equal(t, [2]int64{1000, 1000}, values["inuse_space in runtime"], "the retained heap is not accumulated")
+	require.True(t, perOpProfile("alloc_space"))
+	require.False(t, perOpProfile("inuse_objects"))
+}
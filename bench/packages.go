@@ -12,26 +12,46 @@ import (
 	"go/parser"
 	"go/token"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/google/pprof/profile"
 	"golang.org/x/perf/benchfmt"
+
+	"github.com/grafana/pyrobench/report"
+	"github.com/grafana/pyrobench/report/events"
 )
 
 type Package struct {
 	logger log.Logger
 
-	meta *packageMeta
+	meta      *packageMeta
+	workdir   string   // root of the checkout the package has been discovered in
+	buildArgs []string // passed to the go tool when listing and compiling
 
 	testBinary     string
 	testBinaryHash []byte
+	build          *report.Build // how the test binary has been compiled, nil before
 	benchmarkNames []benchmarkMeta
+	constrained    []constrainedBenchmark // excluded by build constraints, never run
+
+	criticalFunctions []string // symbol names of functions marked as critical
+
+	goroutineLeaks bool     // record the goroutines before and after the benchmarks
+	sandbox        string   // runs the test binary and the hooks in this sandbox, directly when empty
+	sandboxHidden  []string // directories hidden from the sandbox
+
+	hooks *packageHooks // from the configuration file, nil without any
 }
 
 type benchmarkMeta struct {
@@ -43,9 +63,56 @@ type packageMeta struct {
 	Dir        string
 	Root       string
 	ImportPath string
+	Name       string
+
+	// Module is the module containing the package.
+	Module *struct {
+		Path string
+		Dir  string
+	} `json:",omitempty"`
+
+	// GoFiles is the list of non-test source files of the package.
+	GoFiles []string `json:",omitempty"`
 
 	// TestGoFiles is the list of package test source files.
 	TestGoFiles []string `json:",omitempty"`
+
+	// XTestGoFiles is the list of test source files of the external test
+	// package.
+	XTestGoFiles []string `json:",omitempty"`
+
+	// IgnoredGoFiles is the list of source files ignored due to build
+	// constraints.
+	IgnoredGoFiles []string `json:",omitempty"`
+}
+
+// benchmarkPosition returns the source position of the benchmark function, if
+// it is known.
+func (p *Package) benchmarkPosition(name string) *token.Position {
+	for _, b := range p.benchmarkNames {
+		if b.Name == name {
+			return b.position
+		}
+	}
+	return nil
+}
+
+// inDir returns true if the package is located within the directory relative
+// to the checkout root.
+func (p *Package) inDir(dir string) bool {
+	rel, err := filepath.Rel(p.workdir, p.meta.Dir)
+	if err != nil {
+		return false
+	}
+	dir = filepath.Clean(dir)
+	return dir == "." || rel == dir || strings.HasPrefix(rel, dir+string(filepath.Separator))
+}
+
+// isDir returns true if the package is in the repository relative directory
+// itself.
+func (p *Package) isDir(dir string) bool {
+	rel, err := filepath.Rel(p.workdir, p.meta.Dir)
+	return err == nil && rel == filepath.Clean(filepath.FromSlash(dir))
 }
 
 func (p *Package) hasNoTests() bool {
@@ -131,7 +198,7 @@ func (p *Package) listBenchmarksAst(_ context.Context, filters []*BenchmarkFilte
 				if len(filters) > 0 {
 					keep = false
 					for _, filter := range filters {
-						if filter.Filter.MatchString(m.Name.Name) {
+						if filter.matches(p, m.Name.Name) {
 							keep = true
 							break
 						}
@@ -157,18 +224,96 @@ type profileResult struct {
 	Key              string
 	Total            int64
 	FlameGraphComURL string
+	ExploreURL       string // of the profile pushed to Pyroscope, empty when not pushed
+
+	// DownsampleFactor is the ratio of the original to the uploaded number
+	// of samples, 1 when the profile was small enough.
+	DownsampleFactor float64
+
+	profile *profile.Profile // kept to compute the diff between base and head
 }
 
 type benchmarkResult struct {
 	ImportPath string
 	Name       string
+	GOMAXPROCS int // the test binary ran with, 0 for the default
 
 	AllocObjects profileResult
 	AllocSpace   profileResult
+	InuseObjects profileResult // retained after the benchmark, unlike the allocations
+	InuseSpace   profileResult
 	CPU          profileResult
+	Custom       []*customProfile // other sample types, ordered by their name
 
 	RawResult []*benchfmt.Result
 	Units     benchfmt.UnitMetadataMap
+	Finished  time.Time // when the test binary exited, zero when unknown
+
+	CPUUsage    *cpuUsage       `json:"-"` // nil when not observed
+	TestMain    *testMainTiming `json:"-"` // nil when no benchmark output was observed
+	CPUSampling *cpuSampling    `json:"-"` // nil when the CPU profile has no sample counts
+	Resources   *resourceUsage  `json:"-"` // nil when neither memory nor GC have been observed
+	Goroutines  *goroutineLeak  `json:"-"` // nil when goroutine leaks are not checked
+	Leftovers   []string        `json:"-"` // files left in the scratch directories of the run
+	Metrics     []metricResult  // custom metrics derived from the profiles
+
+	cpuProfile []byte       // as written by the test binary, the input of PGO builds
+	uploads    *uploadBatch // pending uploads of the profiles, nil once awaited
+}
+
+// iterations returns the number of iterations the benchmark was run for in
+// total.
+func (r *benchmarkResult) iterations() int {
+	var n int
+	for _, res := range r.RawResult {
+		n += res.Iters
+	}
+	return n
+}
+
+// mergeRounds returns a result with the iterations and profiles of both
+// results, prev being the one of an earlier round at the same GOMAXPROCS. Only
+// the fields comparing the profiles of base and head rely on are kept, the
+// other results are recorded per run. Results without profiles, like resumed
+// ones, are left out, as the profiles are scaled by the iterations.
+func mergeRounds(prev, res *benchmarkResult) (*benchmarkResult, error) {
+	if prev == nil || !prev.hasProfiles() {
+		return res, nil
+	}
+	if !res.hasProfiles() {
+		return prev, nil
+	}
+	merged := &benchmarkResult{
+		ImportPath: res.ImportPath,
+		Name:       res.Name,
+		GOMAXPROCS: res.GOMAXPROCS,
+		RawResult:  append(slices.Clone(prev.RawResult), res.RawResult...),
+		Units:      res.Units,
+		Finished:   res.Finished,
+	}
+	for _, x := range []struct {
+		merged, prev, res *profileResult
+	}{
+		{&merged.CPU, &prev.CPU, &res.CPU},
+		{&merged.AllocSpace, &prev.AllocSpace, &res.AllocSpace},
+		{&merged.AllocObjects, &prev.AllocObjects, &res.AllocObjects},
+	} {
+		p, err := mergeProfiles(x.prev.profile, x.res.profile)
+		if err != nil {
+			return nil, err
+		}
+		x.merged.profile = p
+	}
+	var err error
+	merged.cpuProfile, err = mergeProfileData(prev.cpuProfile, res.cpuProfile)
+	if err != nil {
+		return nil, err
+	}
+	return merged, nil
+}
+
+func (r *benchmarkResult) hasProfiles() bool {
+	return r.CPU.profile != nil || r.AllocSpace.profile != nil || r.AllocObjects.profile != nil
 }
 
 func sumProfiles(p *profile.Profile, typeIdx int) int64 {
@@ -179,7 +324,61 @@ func sumProfiles(p *profile.Profile, typeIdx int) int64 {
 	return sum
 }
 
-func (p *Package) runBenchmark(ctx context.Context, benchTime string, benchCount uint16, benchName string) (*benchmarkResult, error) {
+// errBenchmarkTimeout is returned by runBenchmark, when the benchmark did not
+// finish within its timeout. The returned result might still contain partial
+// results.
+var errBenchmarkTimeout = errors.New("benchmark timed out")
+
+// runOptions configure a single run of a benchmark.
+type runOptions struct {
+	benchTime      string
+	count          uint16
+	timeout        time.Duration // 0 disables the timeout
+	maxProfileSize int64         // encoded size from which on profiles get downsampled, 0 disables
+	gcTrace        bool          // trace the garbage collector to sum up its pauses
+	cpus           []int         // GOMAXPROCS values to run the test binaries with, the default when empty
+	cpu            int           // GOMAXPROCS of a single run, 0 for the default
+	env            []string      // normalized environment, nil to inherit it
+	flags          []string      // passed to the test binary after those of the package configuration
+
+	labels    map[string]string // of the profiles pushed to Pyroscope
+	artifacts string            // directory to keep the raw output and profiles in, empty disables
+
+	noProfiles bool // only collect the benchmark results, e.g. of a quick estimate
+}
+
+// newBenchCommand returns the command running the benchmark count times with
+// the flags and environment of opts and the hooks of the package. The
+// outputArgs precede the flags of the hooks and opts, so those can override
+// them.
+func (p *Package) newBenchCommand(opts runOptions, benchName string, count uint16, outputArgs ...string) *benchCommand {
+	cmd := &benchCommand{
+		args: []string{
+			"-test.run", "^$",
+			"-test.count", strconv.FormatUint(uint64(count), 10),
+			"-test.benchtime", opts.benchTime,
+			"-test.bench", regexp.QuoteMeta(benchName),
+		},
+		timeout: opts.timeout,
+	}
+	cmd.args = append(cmd.args, outputArgs...)
+	if opts.cpu > 0 {
+		cmd.args = append(cmd.args, "-test.cpu", strconv.Itoa(opts.cpu))
+	}
+	cmd.env = append(cmd.env, opts.env...)
+	if p.hooks != nil {
+		cmd.env = append(cmd.env, p.hooks.env...)
+		cmd.args = append(cmd.args, p.hooks.flags...)
+	}
+	cmd.args = append(cmd.args, opts.flags...)
+	return cmd
+}
+
+func (p *Package) runBenchmark(ctx context.Context, opts runOptions, benchName string) (*benchmarkResult, error) {
+	if err := p.runSetup(ctx); err != nil {
+		return nil, err
+	}
+
 	pprofPath, err := os.MkdirTemp("", "pyrotest-pprof-out")
 	if err != nil {
 		return nil, err
@@ -189,27 +388,60 @@ func (p *Package) runBenchmark(ctx context.Context, benchTime string, benchCount
 	cpuProfile := filepath.Join(pprofPath, "cpu.pprof")
 	memProfile := filepath.Join(pprofPath, "mem.pprof")
 
-	cmd := []string{
-		p.testBinary,
-		"-test.run", "^$",
-		"-test.count", strconv.FormatUint(uint64(benchCount), 10),
-		"-test.benchtime", benchTime,
-		"-test.bench", regexp.QuoteMeta(benchName),
-		"-test.cpuprofile", cpuProfile,
-		"-test.memprofile", memProfile,
-		"-test.benchmem",
-	}
-	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
-	c.Dir = p.meta.Dir
-
 	bufOut := new(bytes.Buffer)
 	bufErr := new(bytes.Buffer)
-	c.Stdout = bufOut
-	c.Stderr = bufErr
+	window := newBenchmarkWindow(bufOut)
+	var outputArgs []string
+	if !opts.noProfiles {
+		outputArgs = []string{"-test.cpuprofile", cpuProfile, "-test.memprofile", memProfile}
+	}
+	cmd := p.newBenchCommand(opts, benchName, opts.count, append([]string{"-test.benchmem"}, outputArgs...)...)
+	cmd.outDir = pprofPath
+	cmd.stdout = window
+	cmd.stderr = bufErr
+	cmd.active = window.active
+	if opts.gcTrace {
+		// the GODEBUG entry is last and overrides the inherited or normalized one
+		environ := opts.env
+		if environ == nil {
+			environ = environFromContext(ctx)
+		}
+		env := gcTraceEnv(environ)
+		cmd.env = append(cmd.env, env[len(env)-1])
+	}
+	profileDir, err := createProfileDir(pprofPath)
+	if err != nil {
+		return nil, err
+	}
+	cmd.env = append(cmd.env, profileDirEnv+"="+profileDir)
+	goroutineDir := filepath.Join(pprofPath, "goroutines")
+	if p.goroutineLeaks {
+		if err := os.Mkdir(goroutineDir, 0o755); err != nil {
+			return nil, err
+		}
+		cmd.env = append(cmd.env, goroutineDirEnv+"="+goroutineDir)
+	}
+
+	runCtx := ctx
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
 
-	err = c.Run()
+	var timedOut bool
+	e, err := executorFromContext(ctx).run(runCtx, p, cmd)
+	if e == nil {
+		return nil, err
+	}
+	gcCycles, gcPause, stderr := parseGCTrace(bufErr.Bytes())
 	if err != nil {
-		return nil, fmt.Errorf("failed to run benchmark %v stdErr=%s : %w", cmd, bufErr.String(), err)
+		// remote executors may enforce the timeout themselves
+		if (runCtx.Err() == nil && !errors.Is(err, context.DeadlineExceeded)) || ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to run benchmark %v stdErr=%s : %w", cmd.args, string(stderr), err)
+		}
+		// the benchmark exceeded its timeout, continue with the output collected so far
+		timedOut = true
 	}
 
 	results := []*benchfmt.Result{}
@@ -223,7 +455,7 @@ func (p *Package) runBenchmark(ctx context.Context, benchTime string, benchCount
 		}
 
 		result2 := result.Clone()
-		result2.SetConfig("name", benchName)
+		result2.SetConfig("name", benchmarkRowName(benchName, opts.cpu))
 		results = append(results, result2)
 	}
 	if err := benchReader.Err(); err != nil {
@@ -233,68 +465,152 @@ func (p *Package) runBenchmark(ctx context.Context, benchTime string, benchCount
 	result := benchmarkResult{
 		ImportPath: p.meta.ImportPath,
 		Name:       benchName,
+		GOMAXPROCS: opts.cpu,
 		RawResult:  results,
 		Units:      benchReader.Units(),
+		Finished:   e.exited,
+		Leftovers:  e.leftovers,
+		CPUUsage:   e.cpu,
+		Resources:  newResourceUsage(e.state, gcCycles, gcPause),
+	}
+	if t, ok := window.timing(e.started, e.exited); ok && !e.remote {
+		result.TestMain = &t
+	}
+	if opts.artifacts != "" {
+		if err := writeRunArtifacts(opts.artifacts, bufOut.Bytes(), bufErr.Bytes(), results); err != nil {
+			level.Warn(p.logger).Log("msg", "failed to write artifacts", "benchmark", benchName, "err", err)
+		}
 	}
 
-	for _, profPath := range []string{cpuProfile, memProfile} {
-		profF, err := os.Open(profPath)
+	// profiles are only written when the test binary exits cleanly
+	if timedOut {
+		return &result, fmt.Errorf("%w after %s", errBenchmarkTimeout, opts.timeout)
+	}
+	if opts.noProfiles {
+		return &result, nil
+	}
+
+	if p.goroutineLeaks {
+		result.Goroutines, err = readGoroutineLeak(goroutineDir)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to read goroutine profiles: %w", err)
 		}
-		defer profF.Close()
+	}
 
-		// TODO: Parse profile values
-		prof, err := profile.Parse(profF)
+	// the sample types with a field of their own, others are kept as custom
+	// profiles
+	profileResults := map[string]*profileResult{
+		"alloc_objects": &result.AllocObjects,
+		"alloc_space":   &result.AllocSpace,
+		"inuse_objects": &result.InuseObjects,
+		"inuse_space":   &result.InuseSpace,
+		"cpu":           &result.CPU,
+	}
+
+	pusher := pyroscopeFromContext(ctx)
+	pool := uploadPoolFromContext(ctx)
+	result.uploads = &uploadBatch{}
+	customPaths, err := customProfilePaths(profileDir)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, profPath := range append([]string{cpuProfile, memProfile}, customPaths...) {
+		data, prof, err := readProfileData(profPath, maxProfileDataSize)
+		if errors.Is(err, errProfileTooLarge) {
+			level.Warn(p.logger).Log("msg", "skipping profile", "benchmark", benchName, "err", err)
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
 
-		// find the sub-profiles in the types
-		for idx, t := range prof.SampleType {
-			switch t.Type {
-			case "alloc_objects":
-				result.AllocObjects.Total = sumProfiles(prof, idx)
-			case "alloc_space":
-				result.AllocSpace.Total = sumProfiles(prof, idx)
-			case "cpu":
-				result.CPU.Total = sumProfiles(prof, idx)
+		if opts.artifacts != "" {
+			if err := os.WriteFile(filepath.Join(opts.artifacts, filepath.Base(profPath)), data, 0o644); err != nil {
+				level.Warn(p.logger).Log("msg", "failed to write profile artifact", "benchmark", benchName, "err", err)
 			}
 		}
 
-		// rewind file for upload
-		_, err = profF.Seek(0, 0)
-		if err != nil {
-			return nil, err
+		if pusher != nil {
+			// the report does not depend on Pyroscope
+			if err := pusher.push(ctx, p.logger, data, opts.labels, e.started, e.exited); err != nil {
+				level.Warn(p.logger).Log("msg", "failed to push profile to Pyroscope", "benchmark", benchName, "err", err)
+			}
+		}
+		if profPath == cpuProfile {
+			result.CPUSampling = newCPUSampling(prof, opts)
+			result.cpuProfile = data
 		}
 
-		res, err := uploadProfile(ctx, p.logger, profF)
+		// the profile is uploaded once with all its sample types,
+		// flamegraph.com links to each of them as a sub-profile
+		keep := func(name string) bool {
+			if ignoredSampleTypes[name] {
+				return false
+			}
+			if seen[name] {
+				level.Warn(p.logger).Log("msg", "sample type found in more than one profile, keeping the first one", "benchmark", benchName, "sample_type", name, "profile", filepath.Base(profPath))
+				return false
+			}
+			seen[name] = true
+			return true
+		}
+		sel := selectSampleTypes(prof, keep)
+		if sel == nil {
+			continue
+		}
+		_, data, factor, err := downsampleProfile(sel, opts.maxProfileSize)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to write %s profile: %w", filepath.Base(profPath), err)
+		}
+		if factor > 1 {
+			level.Warn(p.logger).Log("msg", "downsampled large profile", "benchmark", benchName, "profile", filepath.Base(profPath), "factor", factor)
 		}
 
-		for _, sub := range res.SubProfiles {
-			switch sub.Name {
-			case "alloc_objects":
-				result.AllocObjects.Key = sub.Key
-
-				// TODO(bryan) Link to specific sub-profile. For some reason
-				// flamegraph.com does not like building links directly to
-				// sub-profiles.
-				result.AllocObjects.FlameGraphComURL = res.URL
-			case "alloc_space":
-				result.AllocSpace.Key = sub.Key
-				result.AllocSpace.FlameGraphComURL = res.URL
-			case "cpu":
-				result.CPU.Key = sub.Key
-				result.CPU.FlameGraphComURL = res.URL
+		// only the upload is downsampled, the analyses use the complete
+		// profile
+		subs := splitProfile(sel)
+		prs := make(map[string]*profileResult, len(subs))
+		for name, sub := range subs {
+			pr, ok := profileResults[name]
+			if !ok {
+				pr = result.addCustomProfile(name, sub.SampleType[0].Unit)
 			}
+			pr.Total = sumProfiles(sub, 0)
+			pr.profile = sub
+			pr.DownsampleFactor = factor
+			prs[name] = pr
 		}
+
+		progressFromContext(ctx).Add("upload", 1)
+		result.uploads.add(ctx, pool, p.logger, data, func(res *profileResponse, err error) {
+			progressFromContext(ctx).Done("upload")
+			if err != nil {
+				return
+			}
+			for name := range prs {
+				url := (&report.BenchmarkValue{FlamegraphKey: res.subProfileKey(name)}).FlamegraphURL()
+				events.FromContext(ctx).Emit(events.Event{Type: events.UploadDone, Package: p.meta.ImportPath, Benchmark: benchName, Profile: name, URL: url})
+			}
+		}, func(res *profileResponse) {
+			for name, pr := range prs {
+				pr.Key = res.subProfileKey(name)
+				pr.FlameGraphComURL = (&report.BenchmarkValue{FlamegraphKey: pr.Key}).FlamegraphURL()
+				pr.ExploreURL = pusher.exploreURL(name, opts.labels, e.started, e.exited)
+
+				// metrics are derived from the complete profile
+				result.Metrics = append(result.Metrics, extractMetrics(metricExtractorsFromContext(ctx), subs[name], pr.Key, perOpProfile(name))...)
+			}
+		})
 	}
 
+	if pool == nil {
+		// without a pool the profiles have been uploaded already
+		if err := result.awaitUploads(); err != nil {
+			return nil, err
+		}
+	}
 	return &result, nil
-
-	// TODO: Upload pprof files, before parsing
 }
 
 func (p *Package) compileTest(ctx context.Context) error {
@@ -304,7 +620,7 @@ func (p *Package) compileTest(ctx context.Context) error {
 		return nil
 	}
 
-	tmpFile, err := os.CreateTemp("", "pyrotest-test-bin")
+	tmpFile, err := os.CreateTemp("", "pyrotest-test-bin*"+exeSuffix)
 	if err != nil {
 		return err
 	}
@@ -322,7 +638,7 @@ func (p *Package) compileTest(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	relativePath = "./" + relativePath
+	relativePath = "./" + filepath.ToSlash(relativePath)
 
 	cmd := []string{
 		"go",
@@ -330,13 +646,55 @@ func (p *Package) compileTest(ctx context.Context) error {
 		"-trimpath", // needed for reproducible builds
 		"-c",        // do not run tests
 		"-o", p.testBinary,
-		relativePath,
 	}
-	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
-	c.Dir = p.meta.Root
-	msg, err := c.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to compile test %v error=%s: %w", cmd, string(msg), err)
+	cmd = append(cmd, p.buildArgs...)
+	if p.goroutineLeaks {
+		overlay, err := p.goroutineOverlay(ctx)
+		if err != nil {
+			return err
+		}
+		if overlay == "" {
+			p.goroutineLeaks = false
+		} else {
+			cmd = append(cmd, "-overlay", overlay)
+		}
+	}
+	cmd = append(cmd, relativePath)
+
+	// binaries with the injected goroutine recorder are not shared
+	var cacheKey string
+	cache := binaryCacheFromContext(ctx)
+	if cache != nil && !p.goroutineLeaks {
+		cacheKey, err = p.binaryCacheKey(ctx)
+		if err != nil {
+			level.Warn(p.logger).Log("msg", "not using the binary cache", "err", err)
+			cache = nil
+		}
+	}
+	started := time.Now()
+	var cached bool
+	if cacheKey != "" {
+		cached, err = fetchCachedBinary(ctx, cache, cacheKey, p.testBinary)
+		if err != nil {
+			level.Warn(p.logger).Log("msg", "error fetching test binary from the binary cache", "key", cacheKey, "err", err)
+		} else if cached {
+			level.Debug(p.logger).Log("msg", "using test binary from the binary cache", "key", cacheKey)
+		}
+	}
+
+	if !cached {
+		c := goCommand(ctx, p.meta.Root, cmd[1:]...)
+		msg, err := c.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to compile test %v error=%s: %w", cmd, string(msg), err)
+		}
+		if cacheKey != "" {
+			if data, err := os.ReadFile(p.testBinary); err != nil {
+				level.Warn(p.logger).Log("msg", "error reading test binary", "err", err)
+			} else if err := cache.put(ctx, cacheKey, data); err != nil {
+				level.Warn(p.logger).Log("msg", "error pushing test binary to the binary cache", "key", cacheKey, "err", err)
+			}
+		}
 	}
 
 	f, err := os.Open(p.testBinary)
@@ -352,8 +710,18 @@ func (p *Package) compileTest(ctx context.Context) error {
 	if stat.Size() == 0 {
 		return fmt.Errorf("test binary is empty: %s", p.testBinary)
 	}
+	p.build = &report.Build{
+		BinarySize:  stat.Size(),
+		CompileTime: time.Since(started),
+		Cached:      cached,
+	}
 
+	// the same binary built with different flags is not interchangeable,
+	// e.g. for flags affecting the runtime like -race
 	hasher := sha256.New()
+	for _, arg := range p.buildArgs {
+		fmt.Fprintf(hasher, "%s\x00", arg)
+	}
 	_, err = io.Copy(hasher, f)
 	if err != nil {
 		return err
@@ -364,10 +732,239 @@ func (p *Package) compileTest(ctx context.Context) error {
 	return nil
 }
 
-func discoverPackages(ctx context.Context, logger log.Logger, workdir string) ([]Package, error) {
-	cmd := []string{"go", "list", "-json", "./..."}
-	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
-	c.Dir = workdir
+// modulePath returns the path of the module containing the package, empty
+// outside of modules.
+func (p *Package) modulePath() string {
+	if p.meta.Module == nil {
+		return ""
+	}
+	return p.meta.Module.Path
+}
+
+// moduleRoots returns the directories of the modules within the checkout. With
+// a go.work file these are the modules used by the workspace, otherwise all
+// directories below workdir containing a go.mod file. Directories ignored by
+// the go tool are skipped.
+func moduleRoots(ctx context.Context, workdir string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(workdir, "go.work")); err == nil {
+		c := goCommand(ctx, workdir, "work", "edit", "-json")
+		out, err := c.Output()
+		if err != nil {
+			return nil, fmt.Errorf("error reading go.work: %w", err)
+		}
+		var work struct {
+			Use []struct {
+				DiskPath string
+			}
+		}
+		if err := json.Unmarshal(out, &work); err != nil {
+			return nil, fmt.Errorf("error reading go.work: %w", err)
+		}
+		var roots []string
+		for _, u := range work.Use {
+			dir := u.DiskPath
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(workdir, dir)
+			}
+			roots = append(roots, filepath.Clean(dir))
+		}
+		return roots, nil
+	}
+
+	var roots []string
+	err := filepath.WalkDir(workdir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != workdir {
+			name := d.Name()
+			if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor" {
+				return filepath.SkipDir
+			}
+		}
+		if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+			roots = append(roots, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(roots) == 0 {
+		// leave the error to the go tool, e.g. GOPATH mode
+		return []string{workdir}, nil
+	}
+	return roots, nil
+}
+
+// goModule is a module within the checkout.
+type goModule struct {
+	root string // directory of its go.mod file
+	path string // module path, empty without a go.mod file, e.g. in GOPATH mode
+}
+
+// readModulePath returns the module path declared by the go.mod file in dir,
+// empty without one.
+func readModulePath(ctx context.Context, dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+		return "", nil
+	}
+	out, err := goCommand(ctx, dir, "mod", "edit", "-json", "go.mod").Output()
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", filepath.Join(dir, "go.mod"), err)
+	}
+	var mod struct {
+		Module struct {
+			Path string
+		}
+	}
+	if err := json.Unmarshal(out, &mod); err != nil {
+		return "", fmt.Errorf("error reading %s: %w", filepath.Join(dir, "go.mod"), err)
+	}
+	return mod.Module.Path, nil
+}
+
+// owningModule returns the module containing a package, which is the one
+// with the longest prefix. contains returns the prefix of a module and whether
+// it contains the package.
+func owningModule(modules []goModule, contains func(m goModule) (string, bool)) (goModule, bool) {
+	var (
+		result  goModule
+		longest = -1
+	)
+	for _, m := range modules {
+		prefix, ok := contains(m)
+		if ok && len(prefix) > longest {
+			result, longest = m, len(prefix)
+		}
+	}
+	return result, longest >= 0
+}
+
+// modulePatterns translates the patterns relative to workdir into patterns
+// relative to the root of module m. Every pattern is resolved against the
+// modules it covers: directories and import paths of single packages only
+// against the module owning them, those ending in /... against all modules
+// within. Patterns not covering m are dropped.
+func modulePatterns(workdir string, m goModule, modules []goModule, patterns []string) []string {
+	relRoot := func(m goModule) string {
+		rel, err := filepath.Rel(workdir, m.root)
+		if err != nil {
+			return ""
+		}
+		return filepath.ToSlash(rel)
+	}
+	rel := relRoot(m)
+	if rel == "" {
+		return nil
+	}
+
+	var result []string
+	for _, pattern := range patterns {
+		dir, wildcard := strings.CutSuffix(pattern, "/...")
+		var translated string
+		switch {
+		case strings.HasPrefix(pattern, ".") && wildcard:
+			dir = path.Clean(dir)
+			switch {
+			case rel == ".":
+				translated = pattern
+			case dir == "." || dir == rel || strings.HasPrefix(rel, dir+"/"):
+				// the module is below the pattern's directory
+				translated = "./..."
+			case strings.HasPrefix(dir, rel+"/"):
+				translated = "./" + strings.TrimPrefix(dir, rel+"/") + "/..."
+			default:
+				continue
+			}
+		case strings.HasPrefix(pattern, "."):
+			dir = path.Clean(pattern)
+			o, ok := owningModule(modules, func(o goModule) (string, bool) {
+				r := relRoot(o)
+				return r, r == "." || dir == r || strings.HasPrefix(dir, r+"/")
+			})
+			if !ok || o.root != m.root {
+				continue
+			}
+			switch {
+			case rel == ".":
+				translated = pattern
+			case dir == rel:
+				translated = "."
+			default:
+				translated = "./" + strings.TrimPrefix(dir, rel+"/")
+			}
+		case m.path == "":
+			// resolved by the go tool, e.g. in GOPATH mode
+			translated = pattern
+		case wildcard && (dir == m.path || strings.HasPrefix(m.path, dir+"/")):
+			// the module is below the pattern's import path
+			translated = pattern
+		default:
+			o, ok := owningModule(modules, func(o goModule) (string, bool) {
+				return o.path, o.path != "" && (dir == o.path || strings.HasPrefix(dir, o.path+"/"))
+			})
+			if !ok || o.root != m.root {
+				continue
+			}
+			translated = pattern
+		}
+		if !slices.Contains(result, translated) {
+			result = append(result, translated)
+		}
+	}
+	return result
+}
+
+// discoverPackages lists the packages matching the patterns in all modules
+// within workdir, so nested modules and workspaces are covered.
+func discoverPackages(ctx context.Context, logger log.Logger, workdir string, patterns, buildArgs []string) ([]Package, error) {
+	roots, err := moduleRoots(ctx, workdir)
+	if err != nil {
+		return nil, err
+	}
+	modules := make([]goModule, 0, len(roots))
+	for _, root := range roots {
+		modPath, err := readModulePath(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		modules = append(modules, goModule{root: root, path: modPath})
+	}
+
+	covered := make(map[string]bool, len(patterns))
+	var packages []Package
+	for _, m := range modules {
+		rootPatterns := modulePatterns(workdir, m, modules, patterns)
+		for _, pattern := range patterns {
+			covered[pattern] = covered[pattern] || len(modulePatterns(workdir, m, modules, []string{pattern})) > 0
+		}
+		if len(rootPatterns) == 0 {
+			continue
+		}
+		pkgs, err := listPackages(ctx, logger, workdir, m.root, rootPatterns, buildArgs)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, pkgs...)
+	}
+	for _, pattern := range patterns {
+		if !covered[pattern] {
+			level.Warn(logger).Log("msg", "package pattern matches no module within the checkout, ignoring it", "pattern", pattern)
+		}
+	}
+	return packages, nil
+}
+
+// listPackages runs go list within the module root.
+func listPackages(ctx context.Context, logger log.Logger, workdir, root string, patterns, buildArgs []string) ([]Package, error) {
+	buildArgs = moduleBuildArgs(ctx, workdir, root, buildArgs)
+	cmd := append([]string{"go", "list", "-json"}, buildArgs...)
+	cmd = append(cmd, patterns...)
+	c := goCommand(ctx, root, cmd[1:]...)
 	out, err := c.StdoutPipe()
 	if err != nil {
 		return nil, err
@@ -390,14 +987,16 @@ func discoverPackages(ctx context.Context, logger log.Logger, workdir string) ([
 			break
 		}
 		packages = append(packages, Package{
-			logger: log.With(logger, "package", m.ImportPath),
-			meta:   &m,
+			logger:    log.With(logger, "package", m.ImportPath),
+			meta:      &m,
+			workdir:   workdir,
+			buildArgs: buildArgs,
 		})
 	}
 
 	err = c.Wait()
 	if err != nil {
-		return nil, fmt.Errorf("error running %v: %w", cmd, err)
+		return nil, fmt.Errorf("error running %v in %s: %w", cmd, root, err)
 	}
 
 	return packages, nil
@@ -0,0 +1,159 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildTags(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.22\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "m.go"), []byte("package m\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "m_test.go"), []byte(`//go:build integration
+
+package m
+
+import "testing"
+
+func BenchmarkIntegration(b *testing.B) {}
+`), 0o644))
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	t.Cleanup(func() {
+		require.NoError(t, cleaner.cleanup())
+	})
+
+	pkgs, err := discoverPackages(ctx, log.NewNopLogger(), dir, []string{"./..."}, nil)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	require.True(t, pkgs[0].hasNoTests())
+
+	args := &CompareArgs{BuildTags: "integration", BuildFlags: []string{"-gcflags=-N -l"}}
+	require.Equal(t, []string{"-tags=integration", "-gcflags=-N -l"}, args.buildArgs())
+
+	pkgs, err = discoverPackages(ctx, log.NewNopLogger(), dir, []string{"./..."}, args.buildArgs())
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	require.Equal(t, []string{"m_test.go"}, pkgs[0].meta.TestGoFiles)
+
+	require.NoError(t, pkgs[0].compileTest(ctx))
+	require.NotEmpty(t, pkgs[0].testBinaryHash)
+	require.NotNil(t, pkgs[0].build)
+	require.Positive(t, pkgs[0].build.BinarySize)
+	require.Positive(t, pkgs[0].build.CompileTime)
+	require.False(t, pkgs[0].build.Cached)
+}
+
+func TestDiscoverPackagesModules(t *testing.T) {
+	writeFile := func(dir, name, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	dir := t.TempDir()
+	writeFile(dir, "a/go.mod", "module example.com/a\n\ngo 1.22\n")
+	writeFile(dir, "a/a.go", "package a\n")
+	writeFile(dir, "a/a_test.go", "package a\n\nimport \"testing\"\n\nfunc BenchmarkA(b *testing.B) {}\n")
+	writeFile(dir, "b/go.mod", "module example.com/b\n\ngo 1.22\n")
+	writeFile(dir, "b/c/c.go", "package c\n")
+	writeFile(dir, "b/c/c_test.go", "package c\n\nimport \"testing\"\n\nfunc BenchmarkC(b *testing.B) {}\n")
+	writeFile(dir, "b/testdata/go.mod", "module example.com/ignored\n\ngo 1.22\n")
+	writeFile(dir, "b/testdata/x.go", "package x\n")
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	t.Cleanup(func() {
+		require.NoError(t, cleaner.cleanup())
+	})
+
+	discover := func(patterns ...string) map[string]string {
+		pkgs, err := discoverPackages(ctx, log.NewNopLogger(), dir, patterns, nil)
+		require.NoError(t, err)
+		modules := make(map[string]string)
+		for _, p := range pkgs {
+			modules[p.meta.ImportPath] = p.modulePath()
+		}
+		return modules
+	}
+
+	// nested modules without a workspace
+	require.Equal(t, map[string]string{"example.com/a": "example.com/a", "example.com/b/c": "example.com/b"}, discover("./..."))
+	require.Equal(t, map[string]string{"example.com/b/c": "example.com/b"}, discover("./b/c/..."))
+	// patterns are only resolved in the module owning them
+	require.Equal(t, map[string]string{"example.com/b/c": "example.com/b"}, discover("example.com/b/c"))
+	require.Equal(t, map[string]string{"example.com/a": "example.com/a", "example.com/b/c": "example.com/b"}, discover("./a", "example.com/b/..."))
+
+	// the workspace only covers the modules in use
+	t.Setenv("GOFLAGS", "")
+	writeFile(dir, "go.work", "go 1.22\n\nuse ./b\n")
+	require.Equal(t, map[string]string{"example.com/b/c": "example.com/b"}, discover("./..."))
+
+	// the test binary is compiled within its module
+	pkgs, err := discoverPackages(ctx, log.NewNopLogger(), dir, []string{"./..."}, nil)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	require.NoError(t, pkgs[0].compileTest(ctx))
+	require.NotEmpty(t, pkgs[0].testBinaryHash)
+}
+
+func TestModulePatterns(t *testing.T) {
+	workdir := filepath.Join(os.TempDir(), "repo")
+	modules := []goModule{
+		{root: workdir, path: "example.com/repo"},
+		{root: filepath.Join(workdir, "tools"), path: "example.com/repo/tools"},
+		{root: filepath.Join(workdir, "tools", "lint"), path: "example.com/lint"},
+	}
+	for _, tc := range []struct {
+		root     string
+		patterns []string
+		expected []string
+	}{
+		{".", []string{"./..."}, []string{"./..."}},
+		{".", []string{"./pkg/..."}, []string{"./pkg/..."}},
+		{"tools", []string{"./..."}, []string{"./..."}},
+		{"tools", []string{"./tools/..."}, []string{"./..."}},
+		{"tools", []string{"./tools/lint/..."}, []string{"./lint/..."}},
+		{"tools/lint", []string{"./tools/..."}, []string{"./..."}},
+		{"tools", []string{"./pkg/...", "./toolshed/..."}, nil},
+		{"tools", []string{"./...", "./tools/..."}, []string{"./..."}},
+
+		// directories of single packages belong to a single module
+		{".", []string{"./pkg"}, []string{"./pkg"}},
+		{"tools", []string{"./pkg"}, nil},
+		{".", []string{"./tools/gen"}, nil},
+		{"tools", []string{"./tools/gen", "./tools"}, []string{"./gen", "."}},
+		{"tools/lint", []string{"./tools/gen"}, nil},
+
+		// so do import paths
+		{".", []string{"example.com/repo/pkg"}, []string{"example.com/repo/pkg"}},
+		{"tools", []string{"example.com/repo/pkg"}, nil},
+		{".", []string{"example.com/repo/tools/gen"}, nil},
+		{"tools", []string{"example.com/repo/tools/gen"}, []string{"example.com/repo/tools/gen"}},
+		{"tools/lint", []string{"example.com/lint/rules"}, []string{"example.com/lint/rules"}},
+		{"tools", []string{"example.com/other"}, nil},
+
+		// unless they cover the modules within
+		{".", []string{"example.com/repo/..."}, []string{"example.com/repo/..."}},
+		{"tools", []string{"example.com/repo/..."}, []string{"example.com/repo/..."}},
+		{"tools/lint", []string{"example.com/repo/..."}, nil},
+		{"tools", []string{"example.com/repo/tools/gen/..."}, []string{"example.com/repo/tools/gen/..."}},
+		{".", []string{"example.com/repo/tools/gen/..."}, nil},
+	} {
+		var m goModule
+		for _, x := range modules {
+			if x.root == filepath.Join(workdir, tc.root) {
+				m = x
+			}
+		}
+		require.Equal(t, tc.expected, modulePatterns(workdir, m, modules, tc.patterns), "%s %v", tc.root, tc.patterns)
+	}
+
+	// without a module, import paths are left to the go tool
+	gopath := goModule{root: workdir}
+	require.Equal(t, []string{"example.com/repo/pkg"}, modulePatterns(workdir, gopath, []goModule{gopath}, []string{"example.com/repo/pkg"}))
+}
@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+func addCPUArg(cmd *kingpin.CmdClause, cpus *[]int) {
+	cmd.Flag("cpu", "Comma separated list of GOMAXPROCS values to run the benchmarks with, like -test.cpu, e.g. 1,2,4 for benchmarks using b.RunParallel. The test binaries run once per value, the results are reported per value and only compared at equal GOMAXPROCS. By default the benchmarks run once with the GOMAXPROCS of the machine.").PlaceHolder("LIST").SetValue((*cpuList)(cpus))
+}
+
+// cpuList is a list of GOMAXPROCS values, which can be given comma separated
+// or by repeating the flag.
+type cpuList []int
+
+func (l *cpuList) Set(value string) error {
+	for _, s := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid GOMAXPROCS value %q, expected a positive integer", s)
+		}
+		if !slices.Contains(*l, n) {
+			*l = append(*l, n)
+		}
+	}
+	return nil
+}
+
+func (l *cpuList) String() string {
+	parts := make([]string, 0, len(*l))
+	for _, n := range *l {
+		parts = append(parts, strconv.Itoa(n))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l *cpuList) IsCumulative() bool {
+	return true
+}
+
+// gomaxprocs returns the GOMAXPROCS values to run the test binaries with, a
+// single 0 for the default of the machine.
+func (o runOptions) gomaxprocs() []int {
+	if len(o.cpus) == 0 {
+		return []int{0}
+	}
+	return o.cpus
+}
+
+// benchmarkRowName returns the name of the benchmark's results run with
+// GOMAXPROCS, which is suffixed like by go test, so the benchstat tables have
+// a row per value.
+func benchmarkRowName(name string, gomaxprocs int) string {
+	if gomaxprocs == 0 {
+		return name
+	}
+	return fmt.Sprintf("%s-%d", name, gomaxprocs)
+}
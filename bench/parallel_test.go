@@ -0,0 +1,68 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestCPUArg(t *testing.T) {
+	parse := func(args ...string) ([]int, error) {
+		var cpus []int
+		app := kingpin.New("test", "")
+		addCPUArg(app.Command("compare", ""), &cpus)
+		_, err := app.Parse(append([]string{"compare"}, args...))
+		return cpus, err
+	}
+
+	cpus, err := parse()
+	require.NoError(t, err)
+	require.Empty(t, cpus)
+	require.Equal(t, []int{0}, runOptions{cpus: cpus}.gomaxprocs())
+
+	cpus, err = parse("--cpu", "1,2, 4", "--cpu", "8,2")
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 4, 8}, cpus)
+	require.Equal(t, cpus, runOptions{cpus: cpus}.gomaxprocs())
+
+	_, err = parse("--cpu", "1,0")
+	require.ErrorContains(t, err, `invalid GOMAXPROCS value "0"`)
+	_, err = parse("--cpu", "two")
+	require.ErrorContains(t, err, `invalid GOMAXPROCS value "two"`)
+}
+
+func TestAddResultGOMAXPROCS(t *testing.T) {
+	var b bench
+	for _, cpu := range []int{1, 4} {
+		b.addResult(benchSourceBase, &benchmarkResult{
+			GOMAXPROCS: cpu,
+			CPU:        profileResult{Key: "cpu-base", Total: 1000},
+		})
+		b.addResult(benchSourceHead, &benchmarkResult{
+			GOMAXPROCS: cpu,
+			CPU:        profileResult{Key: "cpu-head", Total: int64(1000 + 100*cpu)},
+		})
+	}
+
+	results := make(map[string]report.BenchmarkResult)
+	for _, r := range b.results {
+		results[r.Resource()] = r
+	}
+
+	// base and head are only compared at equal GOMAXPROCS
+	cpu1, cpu4 := results["cpu-1"], results["cpu-4"]
+	diff, ok := cpu1.Diff()
+	require.True(t, ok)
+	require.Equal(t, 10.0, diff)
+	diff, ok = cpu4.Diff()
+	require.True(t, ok)
+	require.Equal(t, 40.0, diff)
+	require.Equal(t, 1, b.results[0].GOMAXPROCS)
+	require.Equal(t, 4, b.results[len(b.results)-1].GOMAXPROCS)
+
+	require.Equal(t, "BenchmarkFoo", benchmarkRowName("BenchmarkFoo", 0))
+	require.Equal(t, "BenchmarkFoo-4", benchmarkRowName("BenchmarkFoo", 4))
+}
@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/grafana/pyrobench/config"
+)
+
+// pathFilters returns the filters selecting the benchmarks relevant to the
+// files changed below the path: the suites the configuration maps the changed
+// files to and all benchmarks of the packages in the directories of the
+// remaining files. A path ending in "/..." includes all directories below.
+func pathFilters(cfg *config.Config, p string, changed []string) ([]*BenchmarkFilter, error) {
+	glob := p
+	if !strings.HasSuffix(glob, "/...") {
+		glob = path.Join(glob, "*")
+	}
+	var files []string
+	for _, f := range changed {
+		if config.MatchPath(glob, f) {
+			files = append(files, f)
+		}
+	}
+
+	suites, unmatched := cfg.PathSuites(files)
+	filters := make([]*BenchmarkFilter, 0, len(suites)+1)
+	for _, name := range suites {
+		f, err := suiteFilter(cfg.Suites[name])
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	if dirs := changedPackageDirs(unmatched); len(dirs) > 0 {
+		f := &BenchmarkFilter{PackageDirs: dirs}
+		// limits the discovery of the packages
+		if root := strings.TrimSuffix(p, "/..."); root != "." {
+			f.Dir = root
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+// changedPackageDirs returns the distinct directories of the changed files.
+// Files of testdata directories belong to the package above them.
+func changedPackageDirs(files []string) []string {
+	var dirs []string
+	for _, f := range files {
+		dir := path.Dir(f)
+		if before, _, ok := strings.Cut("/"+dir+"/", "/testdata/"); ok {
+			dir = path.Clean(strings.TrimPrefix(before, "/"))
+		}
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	slices.Sort(dirs)
+	return dirs
+}
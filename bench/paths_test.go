@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/config"
+)
+
+func TestPathFilters(t *testing.T) {
+	cfg, err := config.Parse(strings.NewReader(`
+suites:
+  index:
+    packages: [example.com/repo/pkg/storage/index/...]
+    bench: BenchmarkPostings
+paths:
+  pkg/storage/index/...: [index]
+`))
+	require.NoError(t, err)
+
+	changed := []string{
+		"pkg/storage/block.go",
+		"pkg/storage/testdata/block.json",
+		"pkg/storage/tsdb/head.go",
+		"pkg/storage/index/postings.go",
+		"pkg/query/engine.go",
+		"README.md",
+	}
+
+	filters, err := pathFilters(cfg, "pkg/storage/...", changed)
+	require.NoError(t, err)
+	require.Len(t, filters, 2)
+	require.Equal(t, "BenchmarkPostings", filters[0].Filter.String())
+	require.Equal(t, &BenchmarkFilter{Dir: "pkg/storage", PackageDirs: []string{"pkg/storage", "pkg/storage/tsdb"}}, filters[1])
+
+	workdir := t.TempDir()
+	pkg := func(dir string) *Package {
+		return &Package{workdir: workdir, meta: &packageMeta{Dir: filepath.Join(workdir, dir)}}
+	}
+	require.True(t, filters[1].matches(pkg("pkg/storage"), "BenchmarkWrite"))
+	require.True(t, filters[1].matches(pkg("pkg/storage/tsdb"), "BenchmarkAppend"))
+	require.False(t, filters[1].matches(pkg("pkg/storage/index"), "BenchmarkPostings"))
+	require.False(t, filters[1].matches(pkg("pkg/query"), "BenchmarkQuery"))
+
+	// without "/..." only the files of the directory itself count
+	filters, err = pathFilters(cfg, "pkg/storage", changed)
+	require.NoError(t, err)
+	require.Equal(t, []*BenchmarkFilter{{Dir: "pkg/storage", PackageDirs: []string{"pkg/storage"}}}, filters)
+
+	filters, err = pathFilters(cfg, "./...", changed)
+	require.NoError(t, err)
+	require.Len(t, filters, 2)
+	require.Equal(t, []string{".", "pkg/query", "pkg/storage", "pkg/storage/tsdb"}, filters[1].PackageDirs)
+	require.Empty(t, filters[1].Dir)
+
+	filters, err = pathFilters(cfg, "pkg/ingester/...", changed)
+	require.NoError(t, err)
+	require.Empty(t, filters)
+}
@@ -0,0 +1,117 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/perf/benchproc"
+
+	"github.com/grafana/pyrobench/benchtab"
+)
+
+func addPGOArg(cmd *kingpin.CmdClause, pgo *bool) {
+	cmd.Flag("pgo", "Run head once more, compiled with -pgo using the CPU profile of base, and report its change against base next to the one of head.").Default("false").BoolVar(pgo)
+}
+
+// runPGO compiles head with the CPU profile of the latest base run as
+// profile-guided optimization input and runs the benchmark with it. The
+// results are added as a third column to the benchstat tables.
+func (b *Benchmark) runPGO(ctx context.Context, r *benchWithKey, opts runOptions, artifactsDir string) {
+	if r.base == nil || r.head == nil || r.baseResult == nil || len(r.baseResult.cpuProfile) == 0 {
+		return
+	}
+	logger := log.With(b.logger, "package", r.key.packagePath, "benchmark", r.key.benchmark, "source", benchSourcePGO)
+
+	p, err := r.head.compilePGO(ctx, r.key.benchmark, r.baseResult.cpuProfile)
+	if err != nil {
+		level.Error(logger).Log("msg", "error compiling head with PGO", "err", err)
+		r.warnings = append(r.warnings, fmt.Sprintf("Compiling head with PGO failed: %s", err))
+		return
+	}
+
+	// at the GOMAXPROCS of the profile, so the results share its row
+	opts.cpu = r.baseResult.GOMAXPROCS
+	opts.labels = b.pushLabels(r.key, benchSourcePGO)
+	opts.artifacts = b.runArtifacts(artifactsDir, r, benchSourcePGO, 1, opts.cpu)
+	b.progress.Add("run", 1)
+	defer b.progress.Done("run")
+	res, err := p.runBenchmark(ctx, opts, r.key.benchmark)
+	if err != nil {
+		level.Error(logger).Log("msg", "error running benchmark", "err", err)
+	}
+	if res == nil {
+		return
+	}
+	// only the samples are compared, the profiles are uploaded nonetheless
+	if err := res.awaitUploads(); err != nil {
+		level.Warn(logger).Log("msg", "error uploading profiles", "err", err)
+	}
+	b.addBenchStatResults(res, benchSourcePGO)
+	r.addSamples(benchSourcePGO, res)
+}
+
+// compilePGO returns a copy of the package, whose test binary is compiled
+// with the CPU profile as -pgo input.
+func (p *Package) compilePGO(ctx context.Context, benchName string, cpuProfile []byte) (*Package, error) {
+	dir, err := os.MkdirTemp("", "pyrotest-pgo")
+	if err != nil {
+		return nil, err
+	}
+	cleanupFromContext(ctx)(func() error {
+		return os.RemoveAll(dir)
+	})
+	path := filepath.Join(dir, "default.pgo")
+	if err := os.WriteFile(path, cpuProfile, 0o644); err != nil {
+		return nil, err
+	}
+
+	pgo := *p
+	// the go tool uses the last -pgo flag
+	pgo.buildArgs = append(slices.Clone(p.buildArgs), "-pgo="+path)
+	pgo.testBinary, pgo.testBinaryHash = "", nil
+	level.Debug(p.logger).Log("msg", "compiling test binary with PGO", "package", p.meta.ImportPath, "benchmark", benchName)
+	// the profile is not part of the binary cache key
+	if err := pgo.compileTest(addBinaryCacheToContext(ctx, nil)); err != nil {
+		return nil, err
+	}
+	return &pgo, nil
+}
+
+// pgoDelta returns the change of sec/op of the PGO build against base, empty
+// when PGO has not been run.
+func pgoDelta(tables *benchtab.Tables) string {
+	if tables == nil {
+		return ""
+	}
+	for _, t := range tables.Tables {
+		if t.Unit != "sec/op" {
+			continue
+		}
+		var baseCol, pgoCol benchproc.Key
+		for _, col := range t.Cols {
+			switch col.String() {
+			case "source:" + benchSourceBase.String():
+				baseCol = col
+			case "source:" + benchSourcePGO.String():
+				pgoCol = col
+			}
+		}
+		if baseCol.IsZero() || pgoCol.IsZero() {
+			continue
+		}
+		for _, row := range t.Rows {
+			base := t.Cells[benchtab.TableKey{Row: row, Col: baseCol}]
+			pgo := t.Cells[benchtab.TableKey{Row: row, Col: pgoCol}]
+			if base != nil && pgo != nil && pgo.Baseline == base {
+				return pgo.Comparison.FormatDelta(base.Summary.Center, pgo.Summary.Center)
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package bench
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompilePGO(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.22\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a", "a_test.go"), []byte(`package a
+
+import "testing"
+
+func fib(n int) int {
+	if n < 2 {
+		return n
+	}
+	return fib(n-1) + fib(n-2)
+}
+
+func BenchmarkFib(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		fib(20)
+	}
+}
+`), 0o644))
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	t.Cleanup(func() {
+		require.NoError(t, cleaner.cleanup())
+	})
+	ctx = addUploaderToContext(ctx, uploaderFunc(func(context.Context, log.Logger, io.Reader) (*profileResponse, error) {
+		return &profileResponse{Key: "key"}, nil
+	}))
+
+	pkgs, err := discoverPackages(ctx, log.NewNopLogger(), dir, []string{"./..."}, nil)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	p := &pkgs[0]
+	require.NoError(t, p.compileTest(ctx))
+
+	opts := runOptions{benchTime: "100x", count: 1, timeout: time.Minute}
+	res, err := p.runBenchmark(ctx, opts, "BenchmarkFib")
+	require.NoError(t, err)
+	require.NotEmpty(t, res.cpuProfile)
+
+	pgo, err := p.compilePGO(ctx, "BenchmarkFib", res.cpuProfile)
+	require.NoError(t, err)
+	require.NotEqual(t, p.testBinary, pgo.testBinary)
+	require.Empty(t, p.buildArgs)
+	require.Len(t, pgo.buildArgs, 1)
+	require.Regexp(t, `^-pgo=.*default\.pgo$`, pgo.buildArgs[0])
+
+	res, err = pgo.runBenchmark(ctx, opts, "BenchmarkFib")
+	require.NoError(t, err)
+	require.Len(t, res.RawResult, 1)
+}
+
+func TestPGODelta(t *testing.T) {
+	b, err := New(nil)
+	require.NoError(t, err)
+
+	output := func(ns string) string {
+		out := "goos: linux\n"
+		for i := 0; i < 6; i++ {
+			out += "BenchmarkA-8   	 100	     " + ns + " ns/op\n"
+		}
+		return out
+	}
+	b.addBenchStatResults(parseBenchmarkResult(t, "BenchmarkA", output("1000")), benchSourceBase)
+	b.addBenchStatResults(parseBenchmarkResult(t, "BenchmarkA", output("1100")), benchSourceHead)
+	require.Empty(t, pgoDelta(b.statBuilders["BenchmarkA"].ToTables()))
+	require.Empty(t, pgoDelta(nil))
+
+	b.addBenchStatResults(parseBenchmarkResult(t, "BenchmarkA", output("900")), benchSourcePGO)
+	tables := b.statBuilders["BenchmarkA"].ToTables()
+	require.Equal(t, "-10.00%", pgoDelta(tables))
+
+	// the change of head is still compared against base
+	metrics := benchmarkMetrics(tables)
+	require.Len(t, metrics, 1)
+	require.Equal(t, "+10.00%", metrics[0].Delta)
+}
@@ -0,0 +1,54 @@
+package bench
+
+import (
+	"fmt"
+	"text/tabwriter"
+)
+
+// printPlan writes which of the discovered benchmarks would be run and why,
+// without running any of them.
+func (b *Benchmark) printPlan(args *CompareArgs, filter []*BenchmarkFilter) error {
+	b.progress.Stop()
+
+	if len(filter) == 0 {
+		filter = []*BenchmarkFilter{{}}
+	}
+
+	w := tabwriter.NewWriter(b.output, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tPACKAGE\tBENCHMARK\tREASON\tBENCHTIME\tCOUNT")
+	var run, skipped int
+	for _, x := range b.plannedBenchmarks() {
+		p := x.head
+		if p == nil {
+			p = x.base
+		}
+		// like when running, a benchmark matching multiple filters runs
+		// once per filter
+		for _, f := range filter {
+			if !f.matches(p, x.key.benchmark) {
+				continue
+			}
+			action := "run"
+			if x.reason == reasonUnchanged {
+				action = "skip"
+				skipped++
+			} else {
+				run++
+			}
+			opts := args.runOptions(f)
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\n", action, x.key.packagePath, x.key.benchmark, x.reason, opts.benchTime, opts.count)
+		}
+	}
+	for idx := range b.headPackages {
+		p := &b.headPackages[idx]
+		for _, c := range p.constrained {
+			skipped++
+			fmt.Fprintf(w, "skip\t%s\t%s\t%s: %s\t\t\n", p.meta.ImportPath, c.Name, reasonConstrained, c.Constraint)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(b.output, "%d benchmarks would run, %d skipped\n", run, skipped)
+	return err
+}
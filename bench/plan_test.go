@@ -0,0 +1,54 @@
+package bench
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintPlan(t *testing.T) {
+	pkg := func(importPath, hash string, names ...string) Package {
+		p := Package{meta: &packageMeta{ImportPath: importPath}, testBinaryHash: []byte(hash)}
+		for _, n := range names {
+			p.benchmarkNames = append(p.benchmarkNames, benchmarkMeta{Name: n})
+		}
+		return p
+	}
+
+	out := new(bytes.Buffer)
+	b, err := New(nil, WithOutput(out, false))
+	require.NoError(t, err)
+	b.basePackages = []Package{
+		pkg("example.com/m/a", "a1", "BenchmarkA", "BenchmarkRemoved"),
+		pkg("example.com/m/b", "b1", "BenchmarkB"),
+	}
+	b.headPackages = []Package{
+		pkg("example.com/m/a", "a2", "BenchmarkA", "BenchmarkNew"),
+		pkg("example.com/m/b", "b1", "BenchmarkB"),
+	}
+
+	count := 3
+	args := &CompareArgs{BenchTime: "2s", BenchCount: 10, DryRun: true}
+	require.NoError(t, b.printPlan(args, nil))
+	require.Equal(t, strings.Join([]string{
+		"ACTION  PACKAGE          BENCHMARK         REASON                            BENCHTIME  COUNT",
+		"run     example.com/m/a  BenchmarkA        code changed                      2s         10",
+		"run     example.com/m/a  BenchmarkNew      benchmark does not exist in base  2s         10",
+		"skip    example.com/m/b  BenchmarkB        unchanged test binary             2s         10",
+		"run     example.com/m/a  BenchmarkRemoved  benchmark does not exist in head  2s         10",
+		"3 benchmarks would run, 1 skipped",
+		"",
+	}, "\n"), out.String())
+
+	out.Reset()
+	require.NoError(t, b.printPlan(args, []*BenchmarkFilter{{Filter: regexp.MustCompile("^BenchmarkA$"), Count: &count}}))
+	require.Equal(t, strings.Join([]string{
+		"ACTION  PACKAGE          BENCHMARK   REASON        BENCHTIME  COUNT",
+		"run     example.com/m/a  BenchmarkA  code changed  2s         3",
+		"1 benchmarks would run, 0 skipped",
+		"",
+	}, "\n"), out.String())
+}
@@ -0,0 +1,53 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// exeSuffix is the suffix executables need on this platform. Windows does not
+// start test binaries without it.
+var exeSuffix = func() string {
+	if runtime.GOOS == "windows" {
+		return ".exe"
+	}
+	return ""
+}()
+
+// tempDir creates a new temporary directory and returns its canonical path.
+// The temporary directory of macOS is behind a symlink and Windows might
+// return a short 8.3 name, while the go tool and git report resolved paths.
+func tempDir(prefix string) (string, error) {
+	dir, err := os.MkdirTemp("", prefix)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return dir, nil
+	}
+	return resolved, nil
+}
+
+// platformEnvironment describes the platform the benchmarks run on, without
+// inspecting the machine any further.
+func platformEnvironment() *report.Environment {
+	return &report.Environment{
+		OS:     runtime.GOOS,
+		Arch:   runtime.GOARCH,
+		NumCPU: runtime.NumCPU(),
+	}
+}
+
+// shellCommand runs the command line by the platform's shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
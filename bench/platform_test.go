@@ -0,0 +1,34 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTempDir(t *testing.T) {
+	dir, err := tempDir("pyrotest-platform")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	resolved, err := filepath.EvalSymlinks(dir)
+	require.NoError(t, err)
+	require.Equal(t, resolved, dir)
+	require.True(t, strings.HasPrefix(filepath.Base(dir), "pyrotest-platform"))
+}
+
+func TestPlatformEnvironment(t *testing.T) {
+	env := platformEnvironment()
+	require.Equal(t, runtime.GOOS, env.OS)
+	require.Equal(t, runtime.GOARCH, env.Arch)
+	require.NotZero(t, env.NumCPU)
+	if runtime.GOOS == "windows" {
+		require.Equal(t, ".exe", exeSuffix)
+	} else {
+		require.Empty(t, exeSuffix)
+	}
+}
@@ -0,0 +1,93 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+const (
+	preflightOff  = "off"
+	preflightWarn = "warn"
+	preflightFail = "fail"
+)
+
+// preflightMaxLoad is the 1 minute load average per CPU, above which the
+// machine is considered too busy for reliable results.
+const preflightMaxLoad = 0.25
+
+func addPreflightArg(cmd *kingpin.CmdClause, preflight *string) {
+	cmd.Flag("preflight", "Check the machine for sources of noise, like frequency scaling or other load, before benchmarking. 'warn' only reports them, 'fail' refuses to run.").Default(preflightWarn).EnumVar(preflight, preflightOff, preflightWarn, preflightFail)
+}
+
+// preflight records the environment the benchmarks are going to run in and
+// checks it for sources of noise. In fail mode an error is returned, when
+// issues are found.
+func (b *Benchmark) preflight(ctx context.Context, mode string) (*report.Environment, error) {
+	if mode == "" || mode == preflightOff {
+		return nil, nil
+	}
+
+	env := machineEnvironment(ctx)
+	env.Issues = environmentIssues(env)
+	b.throttleCount = thermalThrottleCount()
+
+	for _, issue := range env.Issues {
+		level.Warn(b.logger).Log("msg", "preflight check", "issue", issue)
+	}
+	if mode == preflightFail && len(env.Issues) > 0 {
+		return env, fmt.Errorf("machine is too noisy for benchmarking: %s", strings.Join(env.Issues, "; "))
+	}
+	return env, nil
+}
+
+// machineEnvironment inspects the toolchain and the machine the benchmarks
+// are going to run on.
+func machineEnvironment(ctx context.Context) *report.Environment {
+	env := platformEnvironment()
+	// the toolchain compiling the benchmarks might differ from our own
+	if out, err := exec.CommandContext(ctx, "go", "env", "GOVERSION", "GOOS", "GOARCH").Output(); err == nil {
+		if lines := strings.Split(strings.TrimSpace(string(out)), "\n"); len(lines) == 3 {
+			env.GoVersion, env.OS, env.Arch = lines[0], lines[1], lines[2]
+		}
+	}
+	inspectMachine(env)
+	return env
+}
+
+// environmentIssues returns the conditions of the environment, which are
+// known to make benchmark results noisy.
+func environmentIssues(env *report.Environment) []string {
+	var issues []string
+	if env.Governor != "" && env.Governor != "performance" {
+		issues = append(issues, fmt.Sprintf("CPU frequency governor is %s instead of performance", env.Governor))
+	}
+	if env.Turbo == "on" {
+		issues = append(issues, "turbo boost is enabled")
+	}
+	if env.NumCPU > 0 && env.LoadAverage/float64(env.NumCPU) > preflightMaxLoad {
+		issues = append(issues, fmt.Sprintf("load average of %.2f on %d CPUs", env.LoadAverage, env.NumCPU))
+	}
+	return issues
+}
+
+// checkThermalThrottling records an issue, when the CPUs have been
+// throttled since the preflight checks.
+func (b *Benchmark) checkThermalThrottling() {
+	if b.environment == nil {
+		return
+	}
+	count := thermalThrottleCount()
+	if count <= b.throttleCount {
+		return
+	}
+	issue := fmt.Sprintf("CPUs have been thermally throttled %d times while benchmarking", count-b.throttleCount)
+	level.Warn(b.logger).Log("msg", "preflight check", "issue", issue)
+	b.environment.Issues = append(b.environment.Issues, issue)
+}
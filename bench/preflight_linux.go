@@ -0,0 +1,96 @@
+//go:build linux
+
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func readSysFile(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// inspectMachine fills in the details of the environment found in /proc and
+// /sys.
+func inspectMachine(env *report.Environment) {
+	env.Kernel = readSysFile("/proc/sys/kernel/osrelease")
+	env.CPU = cpuModel()
+
+	// cores might use different governors
+	var governors []string
+	paths, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_governor")
+	for _, p := range paths {
+		if g := readSysFile(p); g != "" && !slices.Contains(governors, g) {
+			governors = append(governors, g)
+		}
+	}
+	slices.Sort(governors)
+	env.Governor = strings.Join(governors, "/")
+
+	switch {
+	case readSysFile("/sys/devices/system/cpu/intel_pstate/no_turbo") == "1":
+		env.Turbo = "off"
+	case readSysFile("/sys/devices/system/cpu/intel_pstate/no_turbo") == "0":
+		env.Turbo = "on"
+	case readSysFile("/sys/devices/system/cpu/cpufreq/boost") == "1":
+		env.Turbo = "on"
+	case readSysFile("/sys/devices/system/cpu/cpufreq/boost") == "0":
+		env.Turbo = "off"
+	}
+
+	env.LoadAverage = parseLoadAverage(readSysFile("/proc/loadavg"))
+}
+
+func cpuModel() string {
+	data, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.TrimSpace(k) == "model name" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// parseLoadAverage returns the 1 minute load average of /proc/loadavg.
+func parseLoadAverage(s string) float64 {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return 0
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return load
+}
+
+// thermalThrottleCount returns how often the CPUs have been throttled since
+// boot, 0 if unknown.
+func thermalThrottleCount() uint64 {
+	var sum uint64
+	paths, _ := filepath.Glob("/sys/devices/system/cpu/cpu[0-9]*/thermal_throttle/core_throttle_count")
+	for _, p := range paths {
+		v, err := strconv.ParseUint(readSysFile(p), 10, 64)
+		if err == nil {
+			sum += v
+		}
+	}
+	return sum
+}
@@ -0,0 +1,15 @@
+//go:build linux
+
+package bench
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLoadAverage(t *testing.T) {
+	require.Equal(t, 0.52, parseLoadAverage("0.52 0.58 0.59 1/467 12345"))
+	require.Equal(t, 0.0, parseLoadAverage(""))
+	require.Equal(t, 0.0, parseLoadAverage("garbage"))
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package bench
+
+import "github.com/grafana/pyrobench/report"
+
+// inspectMachine is not implemented on this platform.
+func inspectMachine(_ *report.Environment) {}
+
+func thermalThrottleCount() uint64 { return 0 }
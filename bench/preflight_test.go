@@ -0,0 +1,48 @@
+package bench
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestEnvironmentIssues(t *testing.T) {
+	require.Empty(t, environmentIssues(&report.Environment{
+		NumCPU:      8,
+		Governor:    "performance",
+		Turbo:       "off",
+		LoadAverage: 1,
+	}))
+
+	require.Equal(t, []string{
+		"CPU frequency governor is performance/powersave instead of performance",
+		"turbo boost is enabled",
+		"load average of 4.00 on 8 CPUs",
+	}, environmentIssues(&report.Environment{
+		NumCPU:      8,
+		Governor:    "performance/powersave",
+		Turbo:       "on",
+		LoadAverage: 4,
+	}))
+
+	// unknown values are not reported
+	require.Empty(t, environmentIssues(&report.Environment{}))
+}
+
+func TestPreflightOff(t *testing.T) {
+	b, err := New(log.NewNopLogger())
+	require.NoError(t, err)
+
+	env, err := b.preflight(context.Background(), preflightOff)
+	require.NoError(t, err)
+	require.Nil(t, env)
+
+	env, err = b.preflight(context.Background(), preflightWarn)
+	require.NoError(t, err)
+	require.NotNil(t, env)
+	require.NotZero(t, env.NumCPU)
+}
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package bench
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on platforms without process groups, the
+// default cancel behaviour of exec.Cmd kills the process itself.
+func setProcessGroup(_ *exec.Cmd) {}
+
+// maxRSS is not implemented on this platform.
+func maxRSS(_ *os.ProcessState) int64 { return 0 }
@@ -0,0 +1,26 @@
+//go:build windows
+
+package bench
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group. Windows has no
+// signal for a whole group, so on cancellation taskkill terminates the process
+// tree (including children spawned by the test binary).
+func setProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+	c.Cancel = func() error {
+		if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(c.Process.Pid)).Run(); err != nil {
+			return c.Process.Kill()
+		}
+		return nil
+	}
+}
+
+// maxRSS is not implemented on this platform.
+func maxRSS(_ *os.ProcessState) int64 { return 0 }
@@ -0,0 +1,312 @@
+package bench
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/alecthomas/units"
+	"github.com/google/pprof/profile"
+)
+
+// maxProfileDataSize limits the uncompressed size of a profile read from a
+// benchmark run. The profile is kept in memory while parsing, so a benchmark
+// writing a huge profile would otherwise exhaust the memory of the runner.
+const maxProfileDataSize = 1 << 30
+
+var errProfileTooLarge = errors.New("profile too large")
+
+// readProfileData reads and parses the profile at path. It returns the data as
+// written by the benchmark and fails with errProfileTooLarge when its
+// uncompressed size exceeds limit bytes.
+func readProfileData(path string, limit int64) ([]byte, *profile.Profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, limit+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, nil, fmt.Errorf("%w: %s exceeds %d bytes", errProfileTooLarge, filepath.Base(path), limit)
+	}
+
+	raw := data
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, nil, err
+		}
+		raw, err = io.ReadAll(io.LimitReader(zr, limit+1))
+		if err != nil {
+			return nil, nil, err
+		}
+		if int64(len(raw)) > limit {
+			return nil, nil, fmt.Errorf("%w: %s exceeds %d bytes uncompressed", errProfileTooLarge, filepath.Base(path), limit)
+		}
+	}
+
+	prof, err := profile.ParseUncompressed(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, prof, nil
+}
+
+// splitProfile returns a copy of the profile for every of its sample types,
+// each only containing the values of that single sample type. Samples without
+// a value for the particular sample type are dropped.
+func splitProfile(p *profile.Profile) map[string]*profile.Profile {
+	result := make(map[string]*profile.Profile, len(p.SampleType))
+	for idx, st := range p.SampleType {
+		sub := p.Copy()
+		sub.SampleType = []*profile.ValueType{sub.SampleType[idx]}
+		sub.DefaultSampleType = st.Type
+
+		samples := sub.Sample[:0]
+		for _, s := range sub.Sample {
+			if s.Value[idx] == 0 {
+				continue
+			}
+			s.Value = []int64{s.Value[idx]}
+			samples = append(samples, s)
+		}
+		sub.Sample = samples
+
+		result[st.Type] = sub.Compact()
+	}
+	return result
+}
+
+// selectSampleTypes returns a copy of the profile with only the sample types
+// kept, it is nil when none is kept. keep is called once per sample type.
+// Samples without a value for any of them are dropped.
+func selectSampleTypes(p *profile.Profile, keep func(string) bool) *profile.Profile {
+	var idxs []int
+	for idx, st := range p.SampleType {
+		if keep(st.Type) {
+			idxs = append(idxs, idx)
+		}
+	}
+	if len(idxs) == 0 {
+		return nil
+	}
+	if len(idxs) == len(p.SampleType) {
+		return p
+	}
+
+	sel := p.Copy()
+	types := make([]*profile.ValueType, 0, len(idxs))
+	defaultKept := false
+	for _, idx := range idxs {
+		types = append(types, sel.SampleType[idx])
+		defaultKept = defaultKept || sel.SampleType[idx].Type == sel.DefaultSampleType
+	}
+	sel.SampleType = types
+	if !defaultKept {
+		sel.DefaultSampleType = types[0].Type
+	}
+
+	samples := sel.Sample[:0]
+	for _, s := range sel.Sample {
+		values := make([]int64, 0, len(idxs))
+		for _, idx := range idxs {
+			values = append(values, s.Value[idx])
+		}
+		if isZero(values) {
+			continue
+		}
+		s.Value = values
+		samples = append(samples, s)
+	}
+	sel.Sample = samples
+	return sel.Compact()
+}
+
+// diffProfile returns head minus base, similar to pprof's -diff_base. The base
+// values get multiplied by baseScale first, so that runs with a different
+// number of iterations can be compared. Samples with the same stack are
+// merged into a single sample holding the net change.
+func diffProfile(base, head *profile.Profile, baseScale float64) (*profile.Profile, error) {
+	negBase := base.Copy()
+	negBase.Scale(-baseScale)
+
+	diff, err := profile.Merge([]*profile.Profile{head.Copy(), negBase})
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge profiles: %w", err)
+	}
+
+	samples := diff.Sample[:0]
+	for _, s := range diff.Sample {
+		if isZero(s.Value) {
+			continue
+		}
+		samples = append(samples, s)
+	}
+	diff.Sample = samples
+
+	return diff.Compact(), nil
+}
+
+// mergeProfiles returns the sum of both profiles, either of them may be nil.
+func mergeProfiles(a, b *profile.Profile) (*profile.Profile, error) {
+	if a == nil {
+		return b, nil
+	}
+	if b == nil {
+		return a, nil
+	}
+	merged, err := profile.Merge([]*profile.Profile{a, b})
+	if err != nil {
+		return nil, fmt.Errorf("failed to merge profiles: %w", err)
+	}
+	return merged, nil
+}
+
+// mergeProfileData is mergeProfiles for encoded profiles.
+func mergeProfileData(a, b []byte) ([]byte, error) {
+	if len(a) == 0 {
+		return b, nil
+	}
+	if len(b) == 0 {
+		return a, nil
+	}
+	var profiles []*profile.Profile
+	for _, data := range [][]byte{a, b} {
+		p, err := profile.ParseData(data)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	merged, err := mergeProfiles(profiles[0], profiles[1])
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := merged.Write(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func isZero(values []int64) bool {
+	for _, v := range values {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func addMaxProfileSizeArg(cmd *kingpin.CmdClause, maxSize *units.Base2Bytes) {
+	cmd.Flag("max-profile-size", "Downsample uploaded profiles, whose encoded size exceeds this limit, by dropping their smallest samples. Hotspots and diffs are computed from the complete profiles. 0 disables downsampling.").Default("32MiB").BytesVar(maxSize)
+}
+
+// downsampledFunction is the name of the frame holding the values of the
+// samples dropped by downsampleProfile.
+const downsampledFunction = "[pyrobench: downsampled]"
+
+// downsampleProfile makes sure the encoded profile fits into maxSize bytes.
+// Larger profiles only keep the samples with the largest values, the values
+// of all other samples get merged into a single sample, so the total of the
+// profile stays the same. It returns the profile, its encoding and the
+// downsampling factor, which is the ratio of the original to the retained
+// number of samples. A maxSize of 0 disables downsampling.
+func downsampleProfile(p *profile.Profile, maxSize int64) (*profile.Profile, []byte, float64, error) {
+	buf := new(bytes.Buffer)
+	if err := p.Write(buf); err != nil {
+		return nil, nil, 0, err
+	}
+	if maxSize <= 0 || int64(buf.Len()) <= maxSize || len(p.Sample) == 0 {
+		return p, buf.Bytes(), 1, nil
+	}
+
+	samples := make([]*profile.Sample, len(p.Sample))
+	copy(samples, p.Sample)
+	sort.SliceStable(samples, func(i, j int) bool {
+		return abs(samples[i].Value[0]) > abs(samples[j].Value[0])
+	})
+
+	keep := len(samples)
+	for {
+		// estimate how many samples fit, but make progress in any case
+		next := int(float64(keep) * float64(maxSize) / float64(buf.Len()))
+		keep = max(0, min(next, keep-1))
+
+		down := truncateSamples(p, samples, keep)
+		buf.Reset()
+		if err := down.Write(buf); err != nil {
+			return nil, nil, 0, err
+		}
+		if int64(buf.Len()) <= maxSize || keep == 0 {
+			return down, buf.Bytes(), float64(len(samples)) / float64(max(keep, 1)), nil
+		}
+	}
+}
+
+// truncateSamples returns a copy of the profile with the first keep of the
+// sorted samples, all other samples are merged into a single sample.
+func truncateSamples(p *profile.Profile, sorted []*profile.Sample, keep int) *profile.Profile {
+	down := p.Copy()
+	down.Sample = nil
+
+	var maxFunctionID, maxLocationID uint64
+	for _, f := range p.Function {
+		maxFunctionID = max(maxFunctionID, f.ID)
+	}
+	for _, l := range p.Location {
+		maxLocationID = max(maxLocationID, l.ID)
+	}
+	fn := &profile.Function{ID: maxFunctionID + 1, Name: downsampledFunction, SystemName: downsampledFunction}
+	loc := &profile.Location{ID: maxLocationID + 1, Line: []profile.Line{{Function: fn}}}
+	rest := &profile.Sample{Location: []*profile.Location{loc}, Value: make([]int64, len(p.SampleType))}
+
+	// the copy of the profile has its own locations, so the retained
+	// samples need to be copied from it
+	locations := make(map[uint64]*profile.Location, len(down.Location))
+	for _, l := range down.Location {
+		locations[l.ID] = l
+	}
+	for idx, s := range sorted {
+		if idx >= keep {
+			for i, v := range s.Value {
+				rest.Value[i] += v
+			}
+			continue
+		}
+		c := &profile.Sample{
+			Value:    append([]int64(nil), s.Value...),
+			Label:    s.Label,
+			NumLabel: s.NumLabel,
+			NumUnit:  s.NumUnit,
+		}
+		for _, l := range s.Location {
+			c.Location = append(c.Location, locations[l.ID])
+		}
+		down.Sample = append(down.Sample, c)
+	}
+	if !isZero(rest.Value) {
+		down.Function = append(down.Function, fn)
+		down.Location = append(down.Location, loc)
+		down.Sample = append(down.Sample, rest)
+	}
+
+	return down.Compact()
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
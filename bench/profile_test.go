@@ -0,0 +1,215 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func testMemProfile() *profile.Profile {
+	fn := &profile.Function{ID: 1, Name: "main.alloc"}
+	loc := &profile.Location{ID: 1, Line: []profile.Line{{Function: fn, Line: 10}}}
+	return &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "alloc_objects", Unit: "count"},
+			{Type: "alloc_space", Unit: "bytes"},
+		},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{loc}, Value: []int64{2, 1024}},
+			{Location: []*profile.Location{loc}, Value: []int64{0, 512}},
+		},
+		Location: []*profile.Location{loc},
+		Function: []*profile.Function{fn},
+	}
+}
+
+func TestSplitProfile(t *testing.T) {
+	subs := splitProfile(testMemProfile())
+	require.Len(t, subs, 2)
+
+	objects := subs["alloc_objects"]
+	require.Len(t, objects.SampleType, 1)
+	require.Equal(t, "alloc_objects", objects.DefaultSampleType)
+	require.Len(t, objects.Sample, 1)
+	require.Equal(t, int64(2), sumProfiles(objects, 0))
+
+	space := subs["alloc_space"]
+	require.Len(t, space.Sample, 1) // identical stacks get merged
+	require.Equal(t, int64(1536), sumProfiles(space, 0))
+
+	// ensure the resulting profiles can be serialized
+	for name, sub := range subs {
+		require.NoError(t, sub.CheckValid(), name)
+		require.NoError(t, sub.Write(new(bytes.Buffer)), name)
+	}
+}
+
+func TestSelectSampleTypes(t *testing.T) {
+	p := testMemProfile()
+	require.Same(t, p, selectSampleTypes(p, func(string) bool { return true }))
+	require.Nil(t, selectSampleTypes(p, func(string) bool { return false }))
+
+	var called []string
+	sel := selectSampleTypes(p, func(name string) bool {
+		called = append(called, name)
+		return name == "alloc_space"
+	})
+	require.Equal(t, []string{"alloc_objects", "alloc_space"}, called)
+	require.NoError(t, sel.CheckValid())
+	require.Equal(t, []*profile.ValueType{{Type: "alloc_space", Unit: "bytes"}}, sel.SampleType)
+	require.Equal(t, "alloc_space", sel.DefaultSampleType)
+	require.Equal(t, int64(1536), sumProfiles(sel, 0))
+	require.Len(t, p.SampleType, 2, "original profile must not be modified")
+}
+
+func TestProfileResponseSubProfileKey(t *testing.T) {
+	var res profileResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"key":"all","subProfiles":[{"key":"objects","name":"alloc_objects"},{"key":"space","name":"alloc_space"}]}`), &res))
+	require.Equal(t, "objects", res.subProfileKey("alloc_objects"))
+	require.Equal(t, "space", res.subProfileKey("alloc_space"))
+	// profiles of a single sample type have no sub-profiles
+	require.Equal(t, "cpu", (&profileResponse{Key: "cpu"}).subProfileKey("cpu"))
+}
+
+func testCPUProfile(values ...int64) *profile.Profile {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		PeriodType: &profile.ValueType{Type: "cpu", Unit: "nanoseconds"},
+		Period:     10000000,
+	}
+	for idx, v := range values {
+		fn := &profile.Function{ID: uint64(idx + 1), Name: fmt.Sprintf("main.f%d", idx)}
+		loc := &profile.Location{ID: uint64(idx + 1), Line: []profile.Line{{Function: fn, Line: 10}}}
+		p.Function = append(p.Function, fn)
+		p.Location = append(p.Location, loc)
+		p.Sample = append(p.Sample, &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{v}})
+	}
+	return p
+}
+
+func TestDiffProfile(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		base      []int64
+		head      []int64
+		baseScale float64
+		expected  map[string]int64
+	}{
+		{
+			name:      "unchanged",
+			base:      []int64{100, 200},
+			head:      []int64{100, 200},
+			baseScale: 1,
+			expected:  map[string]int64{},
+		},
+		{
+			name:      "regression in one function",
+			base:      []int64{100, 200},
+			head:      []int64{100, 300},
+			baseScale: 1,
+			expected:  map[string]int64{"main.f1": 100},
+		},
+		{
+			name:      "base ran half the iterations",
+			base:      []int64{50, 100},
+			head:      []int64{100, 150},
+			baseScale: 2,
+			expected:  map[string]int64{"main.f1": -50},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			diff, err := diffProfile(testCPUProfile(tc.base...), testCPUProfile(tc.head...), tc.baseScale)
+			require.NoError(t, err)
+			require.NoError(t, diff.CheckValid())
+
+			actual := map[string]int64{}
+			for _, s := range diff.Sample {
+				actual[s.Location[0].Line[0].Function.Name] += s.Value[0]
+			}
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+}
+
+func TestDownsampleProfile(t *testing.T) {
+	values := make([]int64, 1000)
+	var total int64
+	for idx := range values {
+		values[idx] = int64(idx + 1)
+		total += values[idx]
+	}
+
+	t.Run("small enough", func(t *testing.T) {
+		p := testCPUProfile(values...)
+		down, data, factor, err := downsampleProfile(p, 1<<20)
+		require.NoError(t, err)
+		require.Equal(t, 1.0, factor)
+		require.Same(t, p, down)
+		require.NotEmpty(t, data)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		p := testCPUProfile(values...)
+		_, _, factor, err := downsampleProfile(p, 0)
+		require.NoError(t, err)
+		require.Equal(t, 1.0, factor)
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		p := testCPUProfile(values...)
+		_, full, _, err := downsampleProfile(p, 0)
+		require.NoError(t, err)
+
+		maxSize := int64(len(full) / 4)
+		down, data, factor, err := downsampleProfile(p, maxSize)
+		require.NoError(t, err)
+		require.NoError(t, down.CheckValid())
+		require.LessOrEqual(t, int64(len(data)), maxSize)
+		require.Greater(t, factor, 1.0)
+		require.Len(t, p.Sample, 1000, "original profile must not be modified")
+
+		parsed, err := profile.ParseData(data)
+		require.NoError(t, err)
+		require.Equal(t, total, sumProfiles(parsed, 0))
+
+		byFunction := map[string]int64{}
+		for _, s := range parsed.Sample {
+			byFunction[s.Location[0].Line[0].Function.Name] += s.Value[0]
+		}
+		require.Equal(t, int64(1000), byFunction["main.f999"], "largest samples are kept")
+		require.NotContains(t, byFunction, "main.f0")
+		require.Greater(t, byFunction[downsampledFunction], int64(0))
+		require.InDelta(t, 1000/float64(len(byFunction)-1), factor, 0.01)
+	})
+}
+
+func TestReadProfileData(t *testing.T) {
+	buf := new(bytes.Buffer)
+	require.NoError(t, testCPUProfile(100, 200).Write(buf))
+	path := filepath.Join(t.TempDir(), "cpu.pprof")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0o644))
+
+	data, p, err := readProfileData(path, 1<<20)
+	require.NoError(t, err)
+	require.Equal(t, buf.Bytes(), data)
+	require.Equal(t, int64(300), sumProfiles(p, 0))
+
+	// the limit applies to the uncompressed profile
+	uncompressed := new(bytes.Buffer)
+	require.NoError(t, testCPUProfile(100, 200).WriteUncompressed(uncompressed))
+	_, _, err = readProfileData(path, int64(uncompressed.Len()-1))
+	require.ErrorIs(t, err, errProfileTooLarge)
+
+	require.NoError(t, os.WriteFile(path, uncompressed.Bytes(), 0o644))
+	_, p, err = readProfileData(path, int64(uncompressed.Len()))
+	require.NoError(t, err)
+	require.Equal(t, int64(300), sumProfiles(p, 0))
+	_, _, err = readProfileData(path, int64(uncompressed.Len()-1))
+	require.ErrorIs(t, err, errProfileTooLarge)
+}
@@ -0,0 +1,88 @@
+package bench
+
+import (
+	"time"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// estimateRunDuration returns how long running the benchmark on both sides
+// is expected to take, 0 if it cannot be known upfront, e.g. for iteration
+// based bench times.
+func estimateRunDuration(opts runOptions, b *bench) time.Duration {
+	d, err := time.ParseDuration(opts.benchTime)
+	if err != nil {
+		return 0
+	}
+	var sides time.Duration
+	if b.base != nil {
+		sides++
+	}
+	if b.head != nil {
+		sides++
+	}
+	return d * time.Duration(opts.count) * sides * time.Duration(len(opts.gomaxprocs()))
+}
+
+func (b *bench) startRun() {
+	b.running = true
+	b.started = time.Now()
+}
+
+func (b *bench) finishRun() {
+	b.running = false
+	b.finished = true
+	b.elapsed = time.Since(b.started)
+}
+
+// runProgress counts the finished benchmarks and estimates how long the
+// remaining ones take. The estimates are corrected by how long the finished
+// benchmarks actually took compared to their estimate, which accounts for
+// the overhead of running the test binaries. Benchmarks without an estimate
+// are expected to take as long as the finished ones on average.
+func runProgress(benchmarkGroups [][]*benchWithKey, now time.Time) *report.RunProgress {
+	p := &report.RunProgress{}
+	var estimated, actual, elapsed time.Duration
+	for _, benchmarks := range benchmarkGroups {
+		for _, r := range benchmarks {
+			if r.skipped {
+				continue
+			}
+			p.Total++
+			if r.finished {
+				p.Done++
+				elapsed += r.elapsed
+				if r.estimate > 0 {
+					estimated += r.estimate
+					actual += r.elapsed
+				}
+			}
+		}
+	}
+
+	scale := 1.0
+	if estimated > 0 && actual > 0 {
+		scale = float64(actual) / float64(estimated)
+	}
+	var average time.Duration
+	if p.Done > 0 {
+		average = elapsed / time.Duration(p.Done)
+	}
+
+	for _, benchmarks := range benchmarkGroups {
+		for _, r := range benchmarks {
+			if r.finished || r.skipped {
+				continue
+			}
+			expected := time.Duration(float64(r.estimate) * scale)
+			if r.estimate == 0 {
+				expected = average
+			}
+			if r.running {
+				expected -= now.Sub(r.started)
+			}
+			p.Remaining += max(expected, 0)
+		}
+	}
+	return p
+}
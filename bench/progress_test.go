@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestRunProgress(t *testing.T) {
+	now := time.Now()
+	opts := runOptions{benchTime: "2s", count: 6}
+	both := &bench{base: &Package{}, head: &Package{}}
+	require.Equal(t, 24*time.Second, estimateRunDuration(opts, both))
+	require.Equal(t, 12*time.Second, estimateRunDuration(opts, &bench{head: &Package{}}))
+	require.Zero(t, estimateRunDuration(runOptions{benchTime: "100x", count: 6}, both))
+
+	groups := [][]*benchWithKey{{
+		// took twice as long as estimated
+		{bench: &bench{finished: true, estimate: 24 * time.Second, elapsed: 48 * time.Second}},
+		{bench: &bench{running: true, started: now.Add(-8 * time.Second), estimate: 24 * time.Second}},
+		{bench: &bench{estimate: 12 * time.Second}},
+		// iteration based, expected to take the average
+		{bench: &bench{}},
+		// skipped ones are not expected to run at all
+		{bench: &bench{skipped: true, estimate: time.Hour}},
+	}}
+	p := runProgress(groups, now)
+	require.Equal(t, 1, p.Done)
+	require.Equal(t, 4, p.Total)
+	require.Equal(t, (48-8+24+48)*time.Second, p.Remaining)
+
+	// without finished benchmarks the estimates are used as they are
+	groups[0][0].finished = false
+	p = runProgress(groups, now)
+	require.Zero(t, p.Done)
+	require.Equal(t, (24-8+24+12)*time.Second, p.Remaining)
+}
+
+// countingProgress counts the steps of the run phase.
+type countingProgress struct {
+	report.Progress
+	added, done int
+}
+
+func (p *countingProgress) Add(_ string, n int) { p.added += n }
+func (p *countingProgress) Done(string)         { p.done++ }
+
+func TestRunStepsFinish(t *testing.T) {
+	p := &countingProgress{}
+	runs := &runSteps{progress: p}
+	runs.add(4)
+	runs.done()
+
+	// cancelled after the first run, the remaining ones are completed
+	runs.finish()
+	runs.finish()
+	require.Equal(t, 4, p.added)
+	require.Equal(t, 4, p.done)
+}
@@ -0,0 +1,238 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// pyroscopeProfileTypes maps the sample types of the report to the profile
+// types they are stored as in Pyroscope.
+var pyroscopeProfileTypes = map[string]string{
+	"cpu":           "process_cpu:cpu:nanoseconds:cpu:nanoseconds",
+	"alloc_space":   "memory:alloc_space:bytes:space:bytes",
+	"alloc_objects": "memory:alloc_objects:count:space:bytes",
+	"inuse_space":   "memory:inuse_space:bytes:space:bytes",
+	"inuse_objects": "memory:inuse_objects:count:space:bytes",
+}
+
+// pyroscopeSampleTypeConfig tells Pyroscope to store the allocations of the
+// memory profile as they are. Without a previous profile they can not be
+// turned into deltas anyhow.
+const pyroscopeSampleTypeConfig = `{"alloc_objects":{"units":"objects"},"alloc_space":{"units":"bytes"}}`
+
+type PyroscopeArgs struct {
+	URL        string // of the Pyroscope server, disabled when empty
+	Auth       string // user:password for basic auth, otherwise a bearer token
+	AppName    string // service name of the pushed profiles
+	GrafanaURL string // to link to Explore, no links when empty
+	Datasource string // uid of the Pyroscope datasource in Grafana
+}
+
+func addPyroscopeArgs(cmd *kingpin.CmdClause) *PyroscopeArgs {
+	args := &PyroscopeArgs{}
+	cmd.Flag("pyroscope-url", "Push the profiles of every benchmark run to this Pyroscope server, e.g. Grafana Cloud Profiles, labeled with benchmark, package, ref and commit.").PlaceHolder("URL").StringVar(&args.URL)
+	cmd.Flag("pyroscope-auth", "Credentials for the Pyroscope server, either user:password for basic auth or a bearer token.").Envar("PYROBENCH_PYROSCOPE_AUTH").StringVar(&args.Auth)
+	cmd.Flag("pyroscope-app-name", "Service name of the pushed profiles.").Default("pyrobench").StringVar(&args.AppName)
+	cmd.Flag("pyroscope-grafana-url", "Grafana to link to the pushed profiles in Explore, next to the flamegraph.com links.").PlaceHolder("URL").StringVar(&args.GrafanaURL)
+	cmd.Flag("pyroscope-datasource", "UID of the Pyroscope datasource in Grafana.").StringVar(&args.Datasource)
+	return args
+}
+
+// pyroscopePusher pushes profiles to a Pyroscope server.
+type pyroscopePusher struct {
+	url        *url.URL
+	auth       string
+	appName    string
+	grafanaURL string
+	datasource string
+	client     *http.Client
+}
+
+func newPyroscopePusher(args *PyroscopeArgs) (*pyroscopePusher, error) {
+	u, err := url.Parse(args.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Pyroscope URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported Pyroscope URL %q, expected http(s)", args.URL)
+	}
+	if args.GrafanaURL != "" && args.Datasource == "" {
+		return nil, errors.New("--pyroscope-grafana-url requires --pyroscope-datasource")
+	}
+	return &pyroscopePusher{
+		url:        u,
+		auth:       args.Auth,
+		appName:    args.AppName,
+		grafanaURL: strings.TrimSuffix(args.GrafanaURL, "/"),
+		datasource: args.Datasource,
+		client:     &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+func addPyroscopeToContext(ctx context.Context, p *pyroscopePusher) context.Context {
+	return context.WithValue(ctx, contextKeyPyroscope, p)
+}
+
+// pyroscopeFromContext returns the pusher, nil when pushing is disabled.
+func pyroscopeFromContext(ctx context.Context) *pyroscopePusher {
+	p, _ := ctx.Value(contextKeyPyroscope).(*pyroscopePusher)
+	return p
+}
+
+func (b *Benchmark) pyroscopeContext(ctx context.Context, args *PyroscopeArgs) (context.Context, error) {
+	if args == nil || args.URL == "" {
+		return ctx, nil
+	}
+	p, err := newPyroscopePusher(args)
+	if err != nil {
+		return nil, err
+	}
+	level.Info(b.logger).Log("msg", "pushing profiles to Pyroscope", "url", args.URL)
+	return addPyroscopeToContext(ctx, p), nil
+}
+
+// pushLabels returns the labels of the profiles pushed for the runs of the
+// benchmark in base or head.
+func (b *Benchmark) pushLabels(key benchKey, source benchSource) map[string]string {
+	commit := b.headCommit
+	if source == benchSourceBase {
+		commit = b.baseCommit
+	}
+	return map[string]string{
+		"benchmark": key.benchmark,
+		"package":   key.packagePath,
+		"ref":       source.String(),
+		"commit":    commit,
+	}
+}
+
+// pyroscopeLabelValue replaces the characters, which separate the labels of
+// the application name.
+var pyroscopeLabelValue = strings.NewReplacer(",", "_", "{", "_", "}", "_", "=", "_")
+
+// name returns the application name with the labels as expected by the
+// ingest API, e.g. pyrobench{benchmark=BenchmarkA,ref=head}.
+func (p *pyroscopePusher) name(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+pyroscopeLabelValue.Replace(labels[k]))
+	}
+	return p.appName + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// push uploads the pprof encoded profile recorded between from and until.
+func (p *pyroscopePusher) push(ctx context.Context, logger log.Logger, data []byte, labels map[string]string, from, until time.Time) error {
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("profile", "profile.pprof")
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(data); err != nil {
+		return err
+	}
+	part, err = w.CreateFormFile("sample_type_config", "sample_type_config.json")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(part, pyroscopeSampleTypeConfig); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	u := p.url.JoinPath("ingest")
+	q := u.Query()
+	q.Set("name", p.name(labels))
+	q.Set("from", strconv.FormatInt(from.Unix(), 10))
+	q.Set("until", strconv.FormatInt(until.Unix(), 10))
+	q.Set("spyName", "gospy")
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("user-agent", "pyrobench")
+	req.Header.Set("content-type", w.FormDataContentType())
+	if user, password, ok := strings.Cut(p.auth, ":"); ok {
+		req.SetBasicAuth(user, password)
+	} else if p.auth != "" {
+		req.Header.Set("authorization", "Bearer "+p.auth)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to push profile: [%d] msg=%s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	level.Debug(logger).Log("msg", "pushed profile to Pyroscope", "name", p.name(labels))
+	return nil
+}
+
+// exploreURL links to the profile in Grafana Explore. It is empty when not
+// pushing, without a Grafana URL or for unknown sample types.
+func (p *pyroscopePusher) exploreURL(sampleType string, labels map[string]string, from, until time.Time) string {
+	if p == nil || p.grafanaURL == "" {
+		return ""
+	}
+	profileType, ok := pyroscopeProfileTypes[sampleType]
+	if !ok {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	matchers := []string{fmt.Sprintf("service_name=%q", p.appName)}
+	for _, k := range keys {
+		matchers = append(matchers, fmt.Sprintf("%s=%q", k, pyroscopeLabelValue.Replace(labels[k])))
+	}
+
+	datasource := map[string]string{"type": "grafana-pyroscope-datasource", "uid": p.datasource}
+	left, err := json.Marshal(map[string]any{
+		"datasource": p.datasource,
+		"queries": []map[string]any{{
+			"refId":         "A",
+			"datasource":    datasource,
+			"queryType":     "profile",
+			"profileTypeId": profileType,
+			"labelSelector": "{" + strings.Join(matchers, ",") + "}",
+		}},
+		// the profile is stored at the start of the run
+		"range": map[string]string{
+			"from": strconv.FormatInt(from.Add(-time.Minute).UnixMilli(), 10),
+			"to":   strconv.FormatInt(until.Add(time.Minute).UnixMilli(), 10),
+		},
+	})
+	if err != nil {
+		return ""
+	}
+	return p.grafanaURL + "/explore?left=" + url.QueryEscape(string(left))
+}
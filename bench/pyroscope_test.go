@@ -0,0 +1,90 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPyroscopePush(t *testing.T) {
+	var (
+		query   url.Values
+		user    string
+		profile []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/prefix/ingest", r.URL.Path)
+		query = r.URL.Query()
+		var ok bool
+		user, _, ok = r.BasicAuth()
+		require.True(t, ok)
+		f, _, err := r.FormFile("profile")
+		require.NoError(t, err)
+		profile, err = io.ReadAll(f)
+		require.NoError(t, err)
+	}))
+	t.Cleanup(srv.Close)
+
+	p, err := newPyroscopePusher(&PyroscopeArgs{URL: srv.URL + "/prefix", Auth: "1234:token", AppName: "pyrobench"})
+	require.NoError(t, err)
+
+	from := time.Unix(1724000000, 0)
+	labels := map[string]string{"benchmark": "BenchmarkA/size=1,000", "ref": "head"}
+	require.NoError(t, p.push(context.Background(), log.NewNopLogger(), []byte("pprof"), labels, from, from.Add(time.Minute)))
+	require.Equal(t, "pyrobench{benchmark=BenchmarkA/size_1_000,ref=head}", query.Get("name"))
+	require.Equal(t, "1724000000", query.Get("from"))
+	require.Equal(t, "1724000060", query.Get("until"))
+	require.Equal(t, "1234", user)
+	require.Equal(t, "pprof", string(profile))
+
+	// errors of the server are returned
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+	})
+	err = p.push(context.Background(), log.NewNopLogger(), []byte("pprof"), labels, from, from)
+	require.EqualError(t, err, "failed to push profile: [401] msg=invalid token")
+}
+
+func TestPyroscopeExploreURL(t *testing.T) {
+	var p *pyroscopePusher
+	require.Empty(t, p.exploreURL("cpu", nil, time.Time{}, time.Time{}), "not pushing")
+
+	_, err := newPyroscopePusher(&PyroscopeArgs{URL: "https://pyroscope", GrafanaURL: "https://grafana"})
+	require.EqualError(t, err, "--pyroscope-grafana-url requires --pyroscope-datasource")
+
+	p, err = newPyroscopePusher(&PyroscopeArgs{URL: "https://pyroscope", AppName: "pyrobench", GrafanaURL: "https://grafana/", Datasource: "ds"})
+	require.NoError(t, err)
+	require.Empty(t, p.exploreURL("goroutine", nil, time.Time{}, time.Time{}))
+
+	from := time.UnixMilli(1724000000000)
+	u := p.exploreURL("alloc_space", map[string]string{"ref": "base", "commit": "abcd"}, from, from.Add(time.Minute))
+	left, ok := strings.CutPrefix(u, "https://grafana/explore?left=")
+	require.True(t, ok, u)
+	left, err = url.QueryUnescape(left)
+	require.NoError(t, err)
+
+	var state struct {
+		Queries []struct {
+			ProfileTypeID string `json:"profileTypeId"`
+			LabelSelector string `json:"labelSelector"`
+		} `json:"queries"`
+		Range struct {
+			From, To string
+		} `json:"range"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(left), &state))
+	require.Len(t, state.Queries, 1)
+	require.Equal(t, "memory:alloc_space:bytes:space:bytes", state.Queries[0].ProfileTypeID)
+	require.Equal(t, `{service_name="pyrobench",commit="abcd",ref="base"}`, state.Queries[0].LabelSelector)
+	require.Equal(t, "1723999940000", state.Range.From)
+	require.Equal(t, "1724000120000", state.Range.To)
+}
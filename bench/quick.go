@@ -0,0 +1,96 @@
+package bench
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/benchtab"
+	"github.com/grafana/pyrobench/config"
+	"github.com/grafana/pyrobench/report"
+)
+
+func addQuickEstimateArg(cmd *kingpin.CmdClause, benchTime *string) {
+	cmd.Flag("quick-estimate", "Run every benchmark once with this bench time first, e.g. 100ms, and report the preliminary numbers, before running them with --bench-time. The final numbers replace the preliminary ones. Empty disables the quick estimate.").PlaceHolder("BENCHTIME").StringVar(benchTime)
+}
+
+func validateQuickEstimate(benchTime string) error {
+	if benchTime == "" {
+		return nil
+	}
+	if err := config.ValidateBenchTime(benchTime); err != nil {
+		return fmt.Errorf("invalid --quick-estimate: %w", err)
+	}
+	return nil
+}
+
+// quickEstimate runs every benchmark once on both sides with a short bench
+// time and reports the benchstat tables of these runs as preliminary numbers.
+// The profiles are not collected, the runs are only meant to give a first
+// impression until the benchmarks ran with their full budget.
+func (b *Benchmark) quickEstimate(ctx context.Context, args *CompareArgs, benchmarkGroups [][]*benchWithKey, filter []*BenchmarkFilter, updateCh chan<- *report.BenchmarkReport) {
+	for idx, benchmarks := range benchmarkGroups {
+		for _, r := range benchmarks {
+			if ctx.Err() != nil {
+				return
+			}
+			opts := args.runOptions(filter[idx])
+			opts.benchTime = args.QuickEstimate
+			opts.count = 1
+			opts.noProfiles = true
+			sb, err := NewStatBuilder()
+			if err != nil {
+				level.Error(b.logger).Log("msg", "error creating stat builder", "err", err)
+				return
+			}
+			logger := log.With(b.logger, "package", r.key.packagePath, "benchmark", r.key.benchmark)
+			var ran bool
+			for _, cpu := range opts.gomaxprocs() {
+				opts.cpu = cpu
+				for _, src := range []benchSource{benchSourceBase, benchSourceHead} {
+					p := r.base
+					if src == benchSourceHead {
+						p = r.head
+					}
+					if p == nil {
+						continue
+					}
+					res, err := p.runBenchmark(ctx, opts, r.key.benchmark)
+					if err != nil {
+						level.Warn(logger).Log("msg", "error running quick estimate", "source", src, "err", err)
+					}
+					if res == nil {
+						continue
+					}
+					sb.add(logger, res, src)
+					ran = true
+				}
+			}
+			if !ran {
+				continue
+			}
+			r.setPreliminary(sb.ToTables())
+			updateCh <- b.generateReport(benchmarkGroups)
+		}
+	}
+}
+
+// setPreliminary reports the tables of the quick estimate until the final
+// results are known.
+func (b *bench) setPreliminary(tables *benchtab.Tables) {
+	b.tables = tables
+	b.preliminary = true
+}
+
+// finishPreliminary drops the tables of the quick estimate, which are replaced
+// by the ones of the full runs.
+func (b *bench) finishPreliminary() {
+	if !b.preliminary {
+		return
+	}
+	b.preliminary = false
+	b.tables = nil
+}
@@ -0,0 +1,40 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreliminary(t *testing.T) {
+	sb, err := NewStatBuilder()
+	require.NoError(t, err)
+	sb.add(log.NewNopLogger(), parseBenchmarkResult(t, "BenchmarkA", "BenchmarkA-8   	 100	     1000 ns/op\n"), benchSourceBase)
+	sb.add(log.NewNopLogger(), parseBenchmarkResult(t, "BenchmarkA", "BenchmarkA-8   	 100	     1500 ns/op\n"), benchSourceHead)
+
+	p := &Package{meta: &packageMeta{ImportPath: "example.com/repo/pkg"}}
+	r := &benchWithKey{key: benchKey{"example.com/repo/pkg", "BenchmarkA"}, bench: &bench{base: p, head: p, reason: reasonChanged}}
+	r.setPreliminary(sb.ToTables())
+
+	b := &Benchmark{}
+	run := b.generateReport([][]*benchWithKey{{r}}).Runs[0]
+	require.True(t, run.Preliminary)
+	require.Equal(t, "(preliminary, sec/op=50 %)", run.Status())
+
+	// the final results replace the quick estimate
+	r.startRun()
+	require.Equal(t, "(preliminary, sec/op=50 %, running)", b.generateReport([][]*benchWithKey{{r}}).Runs[0].Status())
+	r.finishRun()
+	r.finishPreliminary()
+	run = b.generateReport([][]*benchWithKey{{r}}).Runs[0]
+	require.False(t, run.Preliminary)
+	require.Empty(t, run.Metrics)
+}
+
+func TestValidateQuickEstimate(t *testing.T) {
+	require.NoError(t, validateQuickEstimate(""))
+	require.NoError(t, validateQuickEstimate("100ms"))
+	require.NoError(t, validateQuickEstimate("10x"))
+	require.Error(t, validateQuickEstimate("fast"))
+}
@@ -0,0 +1,109 @@
+package bench
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// resourceUsage is the peak memory and the garbage collection pressure of a
+// test binary.
+type resourceUsage struct {
+	maxRSS       int64 // bytes
+	gcCycles     int
+	gcPauseTotal time.Duration
+}
+
+// gcTraceEnv returns the environment enabling the GC trace, keeping other
+// GODEBUG settings.
+func gcTraceEnv(environ []string) []string {
+	env := make([]string, 0, len(environ)+1)
+	godebug := "gctrace=1"
+	for _, e := range environ {
+		if v, ok := strings.CutPrefix(e, "GODEBUG="); ok {
+			if v != "" {
+				godebug = v + "," + godebug
+			}
+			continue
+		}
+		env = append(env, e)
+	}
+	return append(env, "GODEBUG="+godebug)
+}
+
+func addGCTraceArg(cmd *kingpin.CmdClause, gcTrace *bool) {
+	cmd.Flag("gc-trace", "Run the benchmarks with GODEBUG=gctrace=1 to report the garbage collector's pauses. The trace is written while the benchmark is timed, so it adds to the measured time.").Default("false").BoolVar(gcTrace)
+}
+
+// gcTraceLine matches the summary the runtime prints for every GC cycle with
+// GODEBUG=gctrace=1. The first and third clock times are the stop the world
+// phases.
+var gcTraceLine = regexp.MustCompile(`^gc \d+ @[\d.]+s \d+%: ([\d.]+)\+[\d.]+\+([\d.]+) ms clock`)
+
+// parseGCTrace sums up the GC cycles found in the stderr output of a test
+// binary. It returns the output without the trace.
+func parseGCTrace(stderr []byte) (cycles int, pause time.Duration, rest []byte) {
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(stderr, []byte("\n")) {
+		m := gcTraceLine.FindSubmatch(line)
+		if m == nil {
+			out.Write(line)
+			continue
+		}
+		cycles++
+		for _, ms := range m[1:] {
+			v, err := strconv.ParseFloat(string(ms), 64)
+			if err == nil {
+				pause += time.Duration(v * float64(time.Millisecond))
+			}
+		}
+	}
+	return cycles, pause, out.Bytes()
+}
+
+// newResourceUsage combines the GC trace with the peak memory of the exited
+// process.
+func newResourceUsage(state *os.ProcessState, gcCycles int, gcPause time.Duration) *resourceUsage {
+	u := &resourceUsage{
+		maxRSS:       maxRSS(state),
+		gcCycles:     gcCycles,
+		gcPauseTotal: gcPause,
+	}
+	if u.maxRSS == 0 && u.gcCycles == 0 {
+		return nil
+	}
+	return u
+}
+
+func (u *resourceUsage) report(source benchSource) report.ResourceUsage {
+	return report.ResourceUsage{
+		Source:       source.String(),
+		MaxRSS:       u.maxRSS,
+		GCCycles:     u.gcCycles,
+		GCPauseTotal: u.gcPauseTotal,
+	}
+}
+
+// setResourceUsage records the resource usage of the latest run of the
+// source.
+func (b *bench) setResourceUsage(source benchSource, u *resourceUsage) {
+	r := u.report(source)
+	for idx := range b.resources {
+		if b.resources[idx].Source == r.Source {
+			b.resources[idx] = r
+			return
+		}
+	}
+	b.resources = append(b.resources, r)
+	sort.Slice(b.resources, func(i, j int) bool {
+		return b.resources[i].Source < b.resources[j].Source
+	})
+}
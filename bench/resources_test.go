@@ -0,0 +1,57 @@
+package bench
+
+import (
+	"os/exec"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGCTrace(t *testing.T) {
+	stderr := []byte(`gc 1 @0.012s 2%: 0.011+0.53+0.003 ms clock, 0.089+0.20/0.41/0.17+0.026 ms cpu, 4->4->0 MB, 4 MB goal, 0 MB stacks, 0 MB globals, 8 P
+panic: something went wrong
+gc 2 @0.020s 3%: 0.5+1.2+0.25 ms clock, 4+0.30/0.50/0.20+2 ms cpu, 4->5->1 MB, 5 MB goal, 0 MB stacks, 0 MB globals, 8 P
+gc 3 @0.030s 3%: 0.010+0.12+0.004 ms clock, 0.08+0.1/0.2/0.1+0.03 ms cpu, 5->5->1 MB, 5 MB goal, 0 MB stacks, 0 MB globals, 8 P (forced)
+`)
+	cycles, pause, rest := parseGCTrace(stderr)
+	require.Equal(t, 3, cycles)
+	require.Equal(t, 778*time.Microsecond, pause.Round(time.Microsecond))
+	require.Equal(t, "panic: something went wrong\n", string(rest))
+
+	cycles, pause, rest = parseGCTrace(nil)
+	require.Zero(t, cycles)
+	require.Zero(t, pause)
+	require.Empty(t, rest)
+}
+
+func TestGCTraceEnv(t *testing.T) {
+	require.Equal(t, []string{"HOME=/root", "GODEBUG=gctrace=1"}, gcTraceEnv([]string{"HOME=/root"}))
+	require.Equal(t, []string{"HOME=/root", "GODEBUG=madvdontneed=1,gctrace=1"}, gcTraceEnv([]string{"GODEBUG=madvdontneed=1", "HOME=/root"}))
+}
+
+func TestMaxRSS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("not implemented on windows")
+	}
+	c := exec.Command("go", "version")
+	require.NoError(t, c.Run())
+	require.Greater(t, maxRSS(c.ProcessState), int64(1024*1024))
+}
+
+func TestGCTraceArg(t *testing.T) {
+	for _, cmd := range []func(*kingpin.Application){
+		func(app *kingpin.Application) { AddCompareCommand(app) },
+		func(app *kingpin.Application) { AddGitHubCommentHookCommand(app) },
+	} {
+		app := kingpin.New("test", "")
+		cmd(app)
+		flag := app.Model().Commands[0].Flags
+		idx := slices.IndexFunc(flag, func(f *kingpin.FlagModel) bool { return f.Name == "gc-trace" })
+		require.GreaterOrEqual(t, idx, 0)
+		require.Equal(t, []string{"false"}, flag[idx].Default, "the trace adds to the measured time")
+	}
+}
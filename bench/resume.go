@@ -0,0 +1,302 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/perf/benchfmt"
+)
+
+// resumeStateFile is the file in the artifacts directory the results of
+// completed runs are persisted to, so an interrupted comparison of the same
+// commits can be resumed.
+const resumeStateFile = "state.json"
+
+func addResumeArg(cmd *kingpin.CmdClause, resume *bool) {
+	cmd.Flag("resume", "Skip the runs an interrupted comparison of the same base and head commits, bench time, count and filters has recorded in --artifacts-dir and continue with the remaining ones.").Default("false").BoolVar(resume)
+}
+
+// resumeSettings are the arguments the recorded runs depend on besides the
+// commits. Runs recorded with other settings are not resumed.
+type resumeSettings struct {
+	BenchTime  string   `json:"bench_time"`
+	BenchCount uint16   `json:"bench_count"`
+	Filters    []string `json:"filters,omitempty"` // selecting the benchmarks, with their run options
+}
+
+func (s resumeSettings) equal(o resumeSettings) bool {
+	return s.BenchTime == o.BenchTime && s.BenchCount == o.BenchCount && slices.Equal(s.Filters, o.Filters)
+}
+
+// resumeSettings returns the settings of the comparison with the filters the
+// benchmarks are grouped by.
+func (args *CompareArgs) resumeSettings(filter []*BenchmarkFilter) resumeSettings {
+	s := resumeSettings{BenchTime: args.BenchTime, BenchCount: args.BenchCount}
+	for _, f := range filter {
+		var re, benchTime, count string
+		if f.Filter != nil {
+			re = f.Filter.String()
+		}
+		if f.Time != nil {
+			benchTime = *f.Time
+		}
+		if f.Count != nil {
+			count = strconv.Itoa(*f.Count)
+		}
+		s.Filters = append(s.Filters, fmt.Sprintf(
+			"bench=%q dir=%q time=%q count=%q flags=%q packages=%q exclude=%q package_dirs=%q",
+			re, f.Dir, benchTime, count, f.Flags, f.Packages, f.ExcludePackages, f.PackageDirs,
+		))
+	}
+	return s
+}
+
+// resumeState holds the results of the runs completed so far. A nil state
+// records nothing.
+type resumeState struct {
+	Base       string         `json:"base"` // commit
+	Head       string         `json:"head"` // commit
+	Settings   resumeSettings `json:"settings"`
+	Benchmarks []*resumeBench `json:"benchmarks"`
+
+	path string
+}
+
+type resumeBench struct {
+	Package  string       `json:"package"`
+	Name     string       `json:"name"`
+	Finished bool         `json:"finished"` // no further runs are needed
+	Runs     []*resumeRun `json:"runs"`
+}
+
+type resumeRun struct {
+	Source     string    `json:"source"`
+	Run        int       `json:"run"`
+	GOMAXPROCS int       `json:"gomaxprocs,omitempty"`
+	Results    string    `json:"results"` // benchfmt records, including the unit metadata
+	Finished   time.Time `json:"finished"`
+
+	CPU          profileResult    `json:"cpu"`
+	AllocSpace   profileResult    `json:"alloc_space"`
+	AllocObjects profileResult    `json:"alloc_objects"`
+	InuseSpace   profileResult    `json:"inuse_space"`
+	InuseObjects profileResult    `json:"inuse_objects"`
+	Custom       []*customProfile `json:"custom,omitempty"`
+	Metrics      []metricResult   `json:"metrics,omitempty"`
+}
+
+// openResumeState returns the state persisted in dir. With resume, the runs
+// recorded by an earlier comparison of the same base and head commits with
+// the same settings are kept, otherwise the state starts empty. It returns
+// nil, when dir is empty.
+func openResumeState(logger log.Logger, dir, base, head string, settings resumeSettings, resume bool) (*resumeState, error) {
+	if dir == "" {
+		if resume {
+			return nil, errors.New("--resume requires --artifacts-dir to find the state of the interrupted run in")
+		}
+		return nil, nil
+	}
+	s := &resumeState{Base: base, Head: head, Settings: settings, path: filepath.Join(dir, resumeStateFile)}
+	if resume {
+		data, err := os.ReadFile(s.path)
+		switch {
+		case os.IsNotExist(err):
+			level.Info(logger).Log("msg", "no state to resume from, running all benchmarks", "path", s.path)
+		case err != nil:
+			return nil, fmt.Errorf("error reading state to resume from: %w", err)
+		default:
+			var prev resumeState
+			if err := json.Unmarshal(data, &prev); err != nil {
+				return nil, fmt.Errorf("error parsing state to resume from %s: %w", s.path, err)
+			}
+			if prev.Base != base || prev.Head != head {
+				level.Warn(logger).Log("msg", "state has been recorded for different commits, running all benchmarks", "path", s.path, "base", prev.Base, "head", prev.Head)
+			} else if !prev.Settings.equal(settings) {
+				level.Warn(logger).Log("msg", "state has been recorded with a different bench time, count or filter, running all benchmarks", "path", s.path, "bench_time", prev.Settings.BenchTime, "bench_count", prev.Settings.BenchCount)
+			} else {
+				s.Benchmarks = prev.Benchmarks
+				level.Info(logger).Log("msg", "resuming interrupted comparison", "path", s.path, "benchmarks", len(s.Benchmarks))
+			}
+		}
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return s, s.save()
+}
+
+func (s *resumeState) bench(key benchKey, create bool) *resumeBench {
+	for _, rb := range s.Benchmarks {
+		if rb.Package == key.packagePath && rb.Name == key.benchmark {
+			return rb
+		}
+	}
+	if !create {
+		return nil
+	}
+	rb := &resumeBench{Package: key.packagePath, Name: key.benchmark}
+	s.Benchmarks = append(s.Benchmarks, rb)
+	return rb
+}
+
+// result returns the recorded result of the run of src at GOMAXPROCS, nil
+// when the run has not been completed.
+func (s *resumeState) result(key benchKey, src benchSource, run, gomaxprocs int) (*benchmarkResult, error) {
+	if s == nil {
+		return nil, nil
+	}
+	rb := s.bench(key, false)
+	if rb == nil {
+		return nil, nil
+	}
+	for _, rr := range rb.Runs {
+		if rr.Source != src.String() || rr.Run != run || rr.GOMAXPROCS != gomaxprocs {
+			continue
+		}
+		res := &benchmarkResult{
+			ImportPath:   key.packagePath,
+			Name:         key.benchmark,
+			GOMAXPROCS:   gomaxprocs,
+			CPU:          rr.CPU,
+			AllocSpace:   rr.AllocSpace,
+			AllocObjects: rr.AllocObjects,
+			InuseSpace:   rr.InuseSpace,
+			InuseObjects: rr.InuseObjects,
+			Custom:       rr.Custom,
+			Metrics:      rr.Metrics,
+			Units:        make(benchfmt.UnitMetadataMap),
+			Finished:     rr.Finished,
+		}
+		reader := benchfmt.NewReader(strings.NewReader(rr.Results), resumeStateFile)
+		for reader.Scan() {
+			switch rec := reader.Result().(type) {
+			case *benchfmt.Result:
+				// like runBenchmark, the name is not part of the records
+				r := rec.Clone()
+				r.SetConfig("name", benchmarkRowName(key.benchmark, gomaxprocs))
+				res.RawResult = append(res.RawResult, r)
+			case *benchfmt.UnitMetadata:
+				res.Units[rec.UnitMetadataKey] = rec
+			case *benchfmt.SyntaxError:
+				return nil, rec
+			}
+		}
+		return res, reader.Err()
+	}
+	return nil, nil
+}
+
+// finished returns whether the benchmark needs no more runs than the ones
+// recorded before run.
+func (s *resumeState) finished(key benchKey, run int) bool {
+	if s == nil {
+		return false
+	}
+	rb := s.bench(key, false)
+	if rb == nil || !rb.Finished {
+		return false
+	}
+	for _, rr := range rb.Runs {
+		if rr.Run >= run {
+			return false
+		}
+	}
+	return true
+}
+
+// record persists the result of a completed run.
+func (s *resumeState) record(key benchKey, src benchSource, run int, res *benchmarkResult) error {
+	if s == nil {
+		return nil
+	}
+	buf := new(bytes.Buffer)
+	w := benchfmt.NewWriter(buf)
+	for _, m := range res.Units {
+		if err := w.Write(m); err != nil {
+			return err
+		}
+	}
+	for _, r := range res.RawResult {
+		if err := w.Write(r); err != nil {
+			return err
+		}
+	}
+	rb := s.bench(key, true)
+	rb.Runs = append(rb.Runs, &resumeRun{
+		Source:       src.String(),
+		Run:          run,
+		GOMAXPROCS:   res.GOMAXPROCS,
+		Results:      buf.String(),
+		Finished:     res.Finished,
+		CPU:          res.CPU,
+		AllocSpace:   res.AllocSpace,
+		AllocObjects: res.AllocObjects,
+		InuseSpace:   res.InuseSpace,
+		InuseObjects: res.InuseObjects,
+		Custom:       res.Custom,
+		Metrics:      res.Metrics,
+	})
+	return s.save()
+}
+
+// finish marks the benchmark as not needing any further runs.
+func (s *resumeState) finish(key benchKey) error {
+	if s == nil {
+		return nil
+	}
+	s.bench(key, true).Finished = true
+	return s.save()
+}
+
+// save replaces the state file, so it is never left half written.
+func (s *resumeState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// resumeOrRun returns the result of the run of src recorded by an interrupted
+// comparison or runs the benchmark and records its result.
+func (b *Benchmark) resumeOrRun(ctx context.Context, state *resumeState, r *benchWithKey, src benchSource, run int, opts runOptions) (*benchmarkResult, error) {
+	logger := log.With(b.logger, "package", r.key.packagePath, "benchmark", r.key.benchmark, "source", src, "run", run)
+	res, err := state.result(r.key, src, run, opts.cpu)
+	if err != nil {
+		level.Warn(logger).Log("msg", "error reading recorded result, running benchmark again", "err", err)
+	} else if res != nil {
+		level.Debug(logger).Log("msg", "resumed recorded result")
+		return res, nil
+	}
+
+	p := r.base
+	if src == benchSourceHead {
+		p = r.head
+	}
+	res, err = p.runBenchmark(ctx, opts, r.key.benchmark)
+	if err == nil && res != nil {
+		// the recorded result includes the flamegraph keys
+		res.afterUploads(func() {
+			if err := state.record(r.key, src, run, res); err != nil {
+				level.Warn(logger).Log("msg", "error recording result for resuming", "err", err)
+			}
+		})
+	}
+	return res, err
+}
@@ -0,0 +1,104 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumeState(t *testing.T) {
+	dir := t.TempDir()
+	key := benchKey{"example.com/m/a", "BenchmarkA"}
+	res := parseBenchmarkResult(t, "BenchmarkA", `goos: linux
+Unit ns/op assume=exact
+BenchmarkA-8   	 100	     1000 ns/op	     64 B/op	       2 allocs/op
+BenchmarkA-8   	 100	     1010 ns/op	     64 B/op	       2 allocs/op
+`)
+	res.CPU = profileResult{Key: "cpu-key", Total: 1234}
+	res.InuseSpace = profileResult{Key: "inuse-key", Total: 512}
+	res.Metrics = []metricResult{{Metric: Metric{Name: "gc", Unit: "ns", Value: 42}, Key: "cpu-key"}}
+
+	settings := (&CompareArgs{BenchTime: "1s", BenchCount: 6}).resumeSettings([]*BenchmarkFilter{{Filter: regexp.MustCompile("A")}})
+	s, err := openResumeState(log.NewNopLogger(), dir, "base-sha", "head-sha", settings, false)
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(dir, resumeStateFile))
+	require.NoError(t, s.record(key, benchSourceBase, 1, res))
+	require.False(t, s.finished(key, 2))
+
+	// without --resume, the recorded runs are discarded
+	s, err = openResumeState(log.NewNopLogger(), dir, "base-sha", "head-sha", settings, false)
+	require.NoError(t, err)
+	got, err := s.result(key, benchSourceBase, 1, 0)
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	require.NoError(t, s.record(key, benchSourceBase, 1, res))
+	require.NoError(t, s.record(key, benchSourceHead, 1, res))
+	require.NoError(t, s.finish(key))
+
+	s, err = openResumeState(log.NewNopLogger(), dir, "base-sha", "head-sha", settings, true)
+	require.NoError(t, err)
+	require.True(t, s.finished(key, 2))
+	require.False(t, s.finished(key, 1))
+	got, err = s.result(key, benchSourceHead, 1, 0)
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	require.Equal(t, "BenchmarkA", got.Name)
+	require.Equal(t, res.CPU, got.CPU)
+	require.Equal(t, res.InuseSpace, got.InuseSpace)
+	require.Equal(t, res.Metrics, got.Metrics)
+	require.Len(t, got.RawResult, 2)
+	require.Equal(t, 2*100, got.iterations())
+	require.Equal(t, "BenchmarkA", got.RawResult[0].GetConfig("name"))
+	require.Equal(t, "linux", got.RawResult[0].GetConfig("goos"))
+	v, ok := got.RawResult[1].Value("sec/op")
+	require.True(t, ok)
+	require.InDelta(t, 1010e-9, v, 1e-12)
+	require.Len(t, got.Units, len(res.Units))
+	got, err = s.result(key, benchSourceHead, 2, 0)
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	// runs at another GOMAXPROCS are resumed separately
+	got, err = s.result(key, benchSourceHead, 1, 4)
+	require.NoError(t, err)
+	require.Nil(t, got)
+	res.GOMAXPROCS = 4
+	require.NoError(t, s.record(key, benchSourceHead, 1, res))
+	got, err = s.result(key, benchSourceHead, 1, 4)
+	require.NoError(t, err)
+	require.Equal(t, 4, got.GOMAXPROCS)
+	require.Equal(t, "BenchmarkA-4", got.RawResult[0].GetConfig("name"))
+	res.GOMAXPROCS = 0
+
+	// nor the results recorded with other settings
+	other := (&CompareArgs{BenchTime: "1s", BenchCount: 6}).resumeSettings([]*BenchmarkFilter{{Filter: regexp.MustCompile("B")}})
+	require.False(t, settings.equal(other))
+	s, err = openResumeState(log.NewNopLogger(), dir, "base-sha", "head-sha", other, true)
+	require.NoError(t, err)
+	got, err = s.result(key, benchSourceHead, 1, 0)
+	require.NoError(t, err)
+	require.Nil(t, got)
+
+	// the results of other commits are not resumed
+	s, err = openResumeState(log.NewNopLogger(), dir, "base-sha", "other-sha", settings, true)
+	require.NoError(t, err)
+	got, err = s.result(key, benchSourceBase, 1, 0)
+	require.NoError(t, err)
+	require.Nil(t, got)
+	require.False(t, s.finished(key, 2))
+
+	_, err = openResumeState(log.NewNopLogger(), "", "base-sha", "head-sha", settings, true)
+	require.Error(t, err)
+	s, err = openResumeState(log.NewNopLogger(), "", "base-sha", "head-sha", settings, false)
+	require.NoError(t, err)
+	require.Nil(t, s)
+	require.NoError(t, s.record(key, benchSourceBase, 1, res))
+
+	_, err = os.Stat(filepath.Join(dir, resumeStateFile+".tmp"))
+	require.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,61 @@
+package bench
+
+import (
+	"fmt"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// AddRunCommand adds the run command, which runs the benchmarks of a single
+// commit without a base to compare against.
+func AddRunCommand(app *kingpin.Application) (*kingpin.CmdClause, *CompareArgs) {
+	cmd := app.Command("run", "Run the benchmarks of a single commit without comparing it, upload their profiles and report their absolute values, e.g. to seed the history or for ad-hoc profiling.")
+	args := addCompareArgs(cmd)
+	args.HeadOnly = true
+	cmd.Flag("ref", "Git commit, branch or tag to run, it gets checked out into a separate worktree. By default the working directory is used.").StringVar(&args.HeadRef)
+	cmd.Flag("dir", "Directory with the commit already checked out. No git operations are run, --ref is ignored.").PlaceHolder("DIR").ExistingDirVar(&args.HeadDir)
+	cmd.Flag("dry-run", "Only discover and compile the benchmarks and print which of them would run.").Default("false").BoolVar(&args.DryRun)
+	return cmd, args
+}
+
+// printRunResults writes the benchstat tables and the values of every
+// benchmark with the link to its flamegraph to the output. In quiet mode only
+// the summary is printed.
+func (b *Benchmark) printRunResults(rpt *report.BenchmarkReport) {
+	b.printTables(rpt)
+
+	var run, skipped, constrained int
+	for i := range rpt.Runs {
+		r := &rpt.Runs[i]
+		if r.Skipped {
+			skipped++
+			continue
+		}
+		if r.Constraint != "" {
+			constrained++
+			continue
+		}
+		run++
+		if b.quiet || len(r.Results) == 0 {
+			continue
+		}
+		fmt.Fprintln(b.output, r.Name)
+		for j := range r.Results {
+			res := &r.Results[j]
+			v := res.HeadValue.Format(res.Unit)
+			if res.HeadValue.FlamegraphKey == "" || v == "" {
+				continue
+			}
+			fmt.Fprintf(b.output, "  %s %s %s\n", res.Resource(), v, res.HeadValue.FlamegraphURL())
+		}
+	}
+	fmt.Fprintf(b.output, "%d benchmarks run\n", run)
+	if skipped > 0 {
+		fmt.Fprintf(b.output, "%d benchmarks skipped, the total time budget has been exhausted\n", skipped)
+	}
+	if constrained > 0 {
+		fmt.Fprintf(b.output, "%d benchmarks skipped, build constraints exclude them\n", constrained)
+	}
+}
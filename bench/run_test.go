@@ -0,0 +1,41 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestPrintRunResults(t *testing.T) {
+	var out bytes.Buffer
+	b := &Benchmark{logger: log.NewNopLogger(), output: &out}
+
+	// no base is checked out
+	require.NoError(t, b.checkoutBase(context.Background(), &CompareArgs{HeadOnly: true, BaseRef: "does-not-exist"}))
+	require.Empty(t, b.baseDir)
+
+	b.printRunResults(&report.BenchmarkReport{
+		Runs: []report.BenchmarkRun{
+			{
+				Name: "pkg.BenchmarkA",
+				Results: []report.BenchmarkResult{
+					{Name: "cpu", Unit: "ns", HeadValue: report.BenchmarkValue{ProfileValue: 2e9, FlamegraphKey: "a"}},
+					{Name: "alloc_space", Unit: "bytes", HeadValue: report.BenchmarkValue{ProfileValue: 2048, FlamegraphKey: "b"}},
+					{Name: "alloc_objects", Unit: "", HeadValue: report.BenchmarkValue{}},
+				},
+			},
+			{Name: "pkg.BenchmarkB", Skipped: true},
+		},
+	})
+	require.Equal(t, `pkg.BenchmarkA
+  cpu 2 s https://flamegraph.com/share/a
+  alloc_space 2.0 KiB https://flamegraph.com/share/b
+1 benchmarks run
+1 benchmarks skipped, the total time budget has been exhausted
+`, out.String())
+}
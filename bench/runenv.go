@@ -0,0 +1,77 @@
+package bench
+
+import (
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+type RunEnvArgs struct {
+	Normalize  bool   // run the test binaries with a controlled environment
+	GOGC       string // of the normalized environment
+	GOMAXPROCS int    // of the normalized environment, the CPUs of the machine when 0
+	GODEBUG    string // the only GODEBUG settings of the normalized environment
+}
+
+func addRunEnvArgs(cmd *kingpin.CmdClause) *RunEnvArgs {
+	args := &RunEnvArgs{}
+	cmd.Flag("normalize-env", "Run the test binaries of base and head with a controlled environment: fixed GOGC and GOMAXPROCS, TZ=UTC, the C locale, no proxy settings and only the GODEBUG settings of --normalize-godebug. The environment is recorded in the report.").Default("false").BoolVar(&args.Normalize)
+	cmd.Flag("normalize-gogc", "GOGC of the normalized environment.").Default("100").StringVar(&args.GOGC)
+	cmd.Flag("normalize-gomaxprocs", "GOMAXPROCS of the normalized environment, by default the number of CPUs of the machine. --cpu takes precedence.").PlaceHolder("N").IntVar(&args.GOMAXPROCS)
+	cmd.Flag("normalize-godebug", "GODEBUG settings of the normalized environment, e.g. 'randautoseed=0' for a deterministic seed of math/rand.").PlaceHolder("SETTINGS").StringVar(&args.GODEBUG)
+	return args
+}
+
+// runEnvCleared are the variables emptied in the normalized environment, so
+// they do not leak in from the machine running the benchmarks.
+var runEnvCleared = []string{
+	"GOMEMLIMIT",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "ALL_PROXY", "FTP_PROXY",
+	"http_proxy", "https_proxy", "no_proxy", "all_proxy", "ftp_proxy",
+}
+
+// environ returns the normalized environment sorted by name, nil when the
+// test binaries inherit the environment. It is added to the inherited
+// environment, so cleared variables are set to an empty value.
+func (args *RunEnvArgs) environ() []string {
+	if args == nil || !args.Normalize {
+		return nil
+	}
+	gomaxprocs := args.GOMAXPROCS
+	if gomaxprocs <= 0 {
+		gomaxprocs = runtime.NumCPU()
+	}
+	env := []string{
+		"GODEBUG=" + args.GODEBUG,
+		"GOGC=" + args.GOGC,
+		"GOMAXPROCS=" + strconv.Itoa(gomaxprocs),
+		"LANG=C",
+		"LC_ALL=C",
+		"TZ=UTC",
+	}
+	for _, name := range runEnvCleared {
+		env = append(env, name+"=")
+	}
+	sort.Strings(env)
+	return env
+}
+
+// reportEnvironment returns env with the normalized environment of the test
+// binaries and the toolchains of base and head, without modifying env.
+func (b *Benchmark) reportEnvironment(env *report.Environment) *report.Environment {
+	if env == nil || (len(b.runEnv) == 0 && b.toolchains.head == "") {
+		return env
+	}
+	e := *env
+	e.Variables = b.runEnv
+	e.BaseGoVersion, e.HeadGoVersion, e.Toolchain = b.toolchains.base, b.toolchains.head, b.toolchains.forced
+	if w := b.toolchains.warning(); w != "" {
+		e.Issues = append(slices.Clip(e.Issues), w)
+	}
+	return &e
+}
@@ -0,0 +1,42 @@
+package bench
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunEnv(t *testing.T) {
+	require.Nil(t, (*RunEnvArgs)(nil).environ())
+	require.Nil(t, (&RunEnvArgs{GOGC: "100"}).environ())
+
+	env := (&RunEnvArgs{Normalize: true, GOGC: "100", GODEBUG: "randautoseed=0"}).environ()
+	require.IsIncreasing(t, env)
+	for _, e := range []string{
+		"GODEBUG=randautoseed=0",
+		"GOGC=100",
+		"GOMAXPROCS=" + strconv.Itoa(runtime.NumCPU()),
+		"LANG=C",
+		"LC_ALL=C",
+		"TZ=UTC",
+		"HTTPS_PROXY=",
+		"no_proxy=",
+	} {
+		require.Contains(t, env, e)
+	}
+
+	env = (&RunEnvArgs{Normalize: true, GOGC: "off", GOMAXPROCS: 2}).environ()
+	require.Contains(t, env, "GOGC=off")
+	require.Contains(t, env, "GOMAXPROCS=2")
+	require.Contains(t, env, "GODEBUG=")
+
+	// the GC trace replaces the GODEBUG settings of the machine
+	t.Setenv("GODEBUG", "madvdontneed=1")
+	traced := gcTraceEnv(env)
+	require.Equal(t, "GODEBUG=gctrace=1", traced[len(traced)-1])
+	traced = gcTraceEnv(os.Environ())
+	require.Equal(t, "GODEBUG=madvdontneed=1,gctrace=1", traced[len(traced)-1])
+}
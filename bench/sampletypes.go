@@ -0,0 +1,89 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// profileDirEnv tells the test binary where it may write additional profiles
+// to, e.g. of profiles registered with runtime/pprof.NewProfile. Every
+// *.pprof file found there after the benchmark is read like the CPU and
+// memory profiles.
+const profileDirEnv = "PYROBENCH_PROFILE_DIR"
+
+// ignoredSampleTypes are sample types, which duplicate another one.
+var ignoredSampleTypes = map[string]bool{
+	"samples": true, // of the CPU profile, counting the samples of cpu
+}
+
+// customProfile is the result of a sample type without a field of its own
+// in benchmarkResult.
+type customProfile struct {
+	Type string `json:"type"`
+	Unit string `json:"unit"` // of the sample type, e.g. count or nanoseconds
+	profileResult
+}
+
+// sampleUnit returns the unit of the report for the unit of a sample type.
+// Units unknown to the report are kept as they are.
+func sampleUnit(unit string) string {
+	switch unit {
+	case "nanoseconds":
+		return "ns"
+	case "bytes":
+		return "bytes"
+	case "count", "":
+		return ""
+	}
+	return unit
+}
+
+// customProfilePaths returns the profiles written to dir in order of their
+// names.
+func customProfilePaths(dir string) ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pprof"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// addCustomProfile returns the result of a sample type, which is not one of
+// the built-in ones.
+func (r *benchmarkResult) addCustomProfile(sampleType, unit string) *profileResult {
+	c := &customProfile{Type: sampleType, Unit: unit}
+	r.Custom = append(r.Custom, c)
+	sort.Slice(r.Custom, func(i, j int) bool {
+		return r.Custom[i].Type < r.Custom[j].Type
+	})
+	return &c.profileResult
+}
+
+// reportedResults returns the results of the run to report. Results of sample
+// types are left out, unless the configuration lists them or lists no sample
+// types at all. Custom metrics are always reported.
+func (b *Benchmark) reportedResults(r *bench) []report.BenchmarkResult {
+	if len(b.sampleTypes) == 0 {
+		return r.results
+	}
+	results := make([]report.BenchmarkResult, 0, len(r.results))
+	for _, res := range r.results {
+		if _, ok := r.sampleTypes[res.Name]; ok && !slices.Contains(b.sampleTypes, res.Name) {
+			continue
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// createProfileDir creates the directory the test binary may write
+// additional profiles to.
+func createProfileDir(pprofPath string) (string, error) {
+	dir := filepath.Join(pprofPath, "profiles")
+	return dir, os.Mkdir(dir, 0o755)
+}
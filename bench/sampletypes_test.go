@@ -0,0 +1,64 @@
+package bench
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestAddResultCustomProfiles(t *testing.T) {
+	newResult := func(source string, cpu, widgets, lockWait int64) *benchmarkResult {
+		res := &benchmarkResult{CPU: profileResult{Key: "cpu-" + source, Total: cpu}}
+		*res.addCustomProfile("widgets", "count") = profileResult{Key: "widgets-" + source, Total: widgets}
+		*res.addCustomProfile("lock_wait", "microseconds") = profileResult{Key: "lock-" + source, Total: lockWait}
+		return res
+	}
+	var b bench
+	b.addResult(benchSourceBase, newResult("base", 100, 10, 2000))
+	b.addResult(benchSourceHead, newResult("head", 100, 15, 1000))
+	b.addResult(benchSourceBase, &benchmarkResult{
+		CPU:     profileResult{Key: "cpu-base", Total: 100},
+		Metrics: []metricResult{{Metric: Metric{Name: "cpu in runtime", Unit: "ns", Value: 10}, Key: "cpu-base"}},
+	})
+
+	results := make(map[string]report.BenchmarkResult)
+	for _, r := range b.results {
+		results[r.Name] = r
+	}
+	widgets := results["widgets"]
+	require.Equal(t, "", widgets.Unit)
+	diff, ok := widgets.Diff()
+	require.True(t, ok)
+	require.Equal(t, 50.0, diff)
+	lockWait := results["lock_wait"]
+	require.Equal(t, "microseconds", lockWait.Unit)
+	require.Equal(t, "2 k microseconds", lockWait.BaseValue.Format(lockWait.Unit))
+
+	// only the configured sample types are reported, custom metrics always
+	bm := &Benchmark{}
+	require.Equal(t, b.results, bm.reportedResults(&b))
+	bm.sampleTypes = []string{"cpu", "widgets"}
+	names := make(map[string]bool)
+	for _, r := range bm.reportedResults(&b) {
+		names[r.Name] = true
+	}
+	require.Equal(t, map[string]bool{"cpu": true, "widgets": true, "cpu in runtime": true}, names)
+}
+
+func TestCustomProfilePaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"widgets.pprof", "connections.pprof", "notes.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+	paths, err := customProfilePaths(dir)
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "connections.pprof"), filepath.Join(dir, "widgets.pprof")}, paths)
+
+	require.Equal(t, "ns", sampleUnit("nanoseconds"))
+	require.Equal(t, "", sampleUnit("count"))
+	require.Equal(t, "microseconds", sampleUnit("microseconds"))
+}
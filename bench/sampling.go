@@ -0,0 +1,89 @@
+package bench
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/pprof/profile"
+)
+
+// minCPUSamples is the number of samples a CPU profile needs, so the
+// sampling error of its total stays around 3 %.
+const minCPUSamples = 1000
+
+// cpuSampling describes how many samples the CPU profile of a benchmark run
+// collected.
+type cpuSampling struct {
+	samples   int64
+	duration  time.Duration // covered by the profile
+	benchTime string
+	count     uint16
+}
+
+// newCPUSampling counts the samples of a CPU profile.
+func newCPUSampling(p *profile.Profile, opts runOptions) *cpuSampling {
+	s := &cpuSampling{
+		duration:  time.Duration(p.DurationNanos),
+		benchTime: opts.benchTime,
+		count:     opts.count,
+	}
+	idx := -1
+	for i, st := range p.SampleType {
+		if st.Type == "samples" {
+			idx = i
+		}
+	}
+	if idx < 0 {
+		return nil
+	}
+	for _, sample := range p.Sample {
+		s.samples += sample.Value[idx]
+	}
+	return s
+}
+
+// noise returns the expected relative sampling error in percent.
+func (s *cpuSampling) noise() float64 {
+	return 100 / math.Sqrt(float64(s.samples))
+}
+
+// recommendedBenchTime returns the bench time, which would collect enough
+// samples, 0 if it cannot be known.
+func (s *cpuSampling) recommendedBenchTime() time.Duration {
+	if s.samples == 0 {
+		return 0
+	}
+	factor := float64(minCPUSamples) / float64(s.samples)
+	if d, err := time.ParseDuration(s.benchTime); err == nil {
+		return roundUpSecond(time.Duration(float64(d) * factor))
+	}
+	// iteration based bench times, derive it from the profile's duration
+	if s.duration == 0 || s.count == 0 {
+		return 0
+	}
+	return roundUpSecond(time.Duration(float64(s.duration) * factor / float64(s.count)))
+}
+
+func roundUpSecond(d time.Duration) time.Duration {
+	return max(time.Second, (d+time.Second-1)/time.Second*time.Second)
+}
+
+// cpuSamplingWarning returns a warning, when the CPU profile with the fewest
+// samples has too few for reliable results.
+func cpuSamplingWarning(source benchSource, s *cpuSampling) string {
+	if s == nil || s.samples >= minCPUSamples {
+		return ""
+	}
+	if s.samples == 0 {
+		return fmt.Sprintf("The CPU profile of %s has no samples, the bench time %s is too short to profile the benchmark.", source, s.benchTime)
+	}
+	w := fmt.Sprintf(
+		"The CPU profile of %s has only %d samples, differences below ±%.1f %% are likely sampling noise.",
+		source, s.samples, s.noise(),
+	)
+	if d := s.recommendedBenchTime(); d > 0 {
+		w += fmt.Sprintf(" Use a bench time of at least %s.", d)
+	}
+	return w
+}
@@ -0,0 +1,54 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/pprof/profile"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPUSampling(t *testing.T) {
+	p := &profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: "samples", Unit: "count"},
+			{Type: "cpu", Unit: "nanoseconds"},
+		},
+		DurationNanos: int64(12 * time.Second),
+		Sample: []*profile.Sample{
+			{Value: []int64{150, 1500000000}},
+			{Value: []int64{100, 1000000000}},
+		},
+	}
+	s := newCPUSampling(p, runOptions{benchTime: "2s", count: 6})
+	require.Equal(t, int64(250), s.samples)
+	require.InDelta(t, 6.32, s.noise(), 0.01)
+	require.Equal(t, 8*time.Second, s.recommendedBenchTime())
+	require.Equal(t,
+		"The CPU profile of head has only 250 samples, differences below ±6.3 % are likely sampling noise. Use a bench time of at least 8s.",
+		cpuSamplingWarning(benchSourceHead, s),
+	)
+
+	// iteration based bench times are derived from the profile's duration
+	s = newCPUSampling(p, runOptions{benchTime: "100x", count: 6})
+	require.Equal(t, 8*time.Second, s.recommendedBenchTime())
+
+	s.samples = 0
+	require.Equal(t, "The CPU profile of base has no samples, the bench time 100x is too short to profile the benchmark.", cpuSamplingWarning(benchSourceBase, s))
+
+	s.samples = minCPUSamples
+	require.Empty(t, cpuSamplingWarning(benchSourceBase, s))
+
+	// without sample counts nothing can be said
+	require.Nil(t, newCPUSampling(testCPUProfile(100), runOptions{benchTime: "2s", count: 6}))
+}
+
+func TestCPUSamplingWarningOfSparserSide(t *testing.T) {
+	b := &bench{cpuSampling: map[benchSource]*cpuSampling{
+		benchSourceBase: {samples: 5000, benchTime: "2s", count: 6},
+		benchSourceHead: {samples: 500, benchTime: "2s", count: 6},
+	}}
+	require.Equal(t, []string{
+		"The CPU profile of head has only 500 samples, differences below ±4.5 % are likely sampling noise. Use a bench time of at least 4s.",
+	}, b.runWarnings())
+}
@@ -0,0 +1,210 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+// The sandboxes the test binaries can be run in.
+const (
+	sandboxNone    = "none"
+	sandboxUnshare = "unshare" // user and network namespace of util-linux' unshare
+	sandboxNsjail  = "nsjail"
+	sandboxRunsc   = "runsc" // gVisor
+)
+
+type SandboxArgs struct {
+	Mode   string   // sandbox of the head test binaries, none when empty
+	Base   bool     // run the base test binaries in the same sandbox
+	Hidden []string // files and directories hidden from the sandbox in addition to the git directories
+}
+
+func addSandboxArgs(cmd *kingpin.CmdClause, untrusted bool) *SandboxArgs {
+	args := &SandboxArgs{}
+	def := sandboxNone
+	if untrusted {
+		def = sandboxUnshare
+	}
+	cmd.Flag("sandbox", "Run the test binaries and the setup and teardown hooks of head in a sandbox without network access and with a read-only file system apart from the package's directory and a temporary directory, as they execute the code of the pull request. 'unshare' uses user, mount, PID and network namespaces, 'nsjail' and 'runsc' (gVisor) need the tool in the PATH, 'none' disables it. Profiles are uploaded by pyrobench outside of the sandbox. Linux only, other executors than the local one only run the hooks in it.").Default(def).EnumVar(&args.Mode, sandboxNone, sandboxUnshare, sandboxNsjail, sandboxRunsc)
+	cmd.Flag("sandbox-base", "Run the test binaries of base in the same sandbox as head, so the overhead of the sandbox does not show up as a change.").Default("true").BoolVar(&args.Base)
+	return args
+}
+
+// enabled returns true, when the test binaries of head run in a sandbox.
+func (args *SandboxArgs) enabled() bool {
+	return args != nil && args.Mode != "" && args.Mode != sandboxNone
+}
+
+// validate checks the sandbox is available. Other executors than the local
+// one isolate the test binaries themselves, e.g. in pods, the sandbox then
+// only applies to the setup and teardown hooks run locally.
+func (args *SandboxArgs) validate() error {
+	if !args.enabled() {
+		return nil
+	}
+	if _, err := exec.LookPath(args.Mode); err != nil {
+		return fmt.Errorf("--sandbox %s: %w", args.Mode, err)
+	}
+	return nil
+}
+
+// mode returns the sandbox of the test binaries of the source, empty when they
+// run directly.
+func (args *SandboxArgs) mode(src benchSource) string {
+	if !args.enabled() || (src == benchSourceBase && !args.Base) {
+		return ""
+	}
+	return args.Mode
+}
+
+// errSandboxUnknown is returned for sandboxes sandboxCommand does not know.
+var errSandboxUnknown = errors.New("unknown sandbox")
+
+// sandboxSpec describes the file system a sandboxed command sees. The root is
+// read-only, so the command can neither modify the checkouts, the build cache
+// nor the test binaries of later runs.
+type sandboxSpec struct {
+	dir      string   // working directory
+	writable []string // directories kept writable, e.g. the package's directory
+	hidden   []string // files and directories replaced by empty ones, e.g. the git directory holding credentials
+	empty    string   // empty directory mounted over the hidden ones, where the sandbox needs one
+}
+
+// sandboxUnshareScript sets up the mount namespace created by unshare: the
+// writable directories are bind mounted onto themselves, before every other
+// mount is made read-only. The remount keeps the options of the mount, as the
+// user namespace may not drop locked ones like nosuid, and a mount which cannot
+// be made read-only fails the command. The writable and hidden directories are
+// passed in the environment, separated by newlines.
+const sandboxUnshareScript = `ip link set lo up 2>/dev/null
+IFS='
+'
+for dir in $PYROBENCH_SANDBOX_RW; do mount --bind "$dir" "$dir" || exit 125; done
+for line in $(awk '{opts[$5] = $6} END {for (m in opts) print m " " opts[m]}' /proc/self/mountinfo | sort -r); do
+  m=${line% *} opts=${line##* }
+  case "
+$PYROBENCH_SANDBOX_RW
+" in *"
+$m
+"*) continue;; esac
+  case "$opts" in ro|ro,*) continue;; esac
+  mount -o "remount,bind,ro${opts#rw}" "$m" || exit 125
+done
+for path in $PYROBENCH_SANDBOX_HIDDEN; do
+  if [ -d "$path" ]; then mount -t tmpfs -o ro,size=4k tmpfs "$path" || exit 125
+  elif [ -e "$path" ]; then mount --bind /dev/null "$path" || exit 125; fi
+done
+unset PYROBENCH_SANDBOX_RW PYROBENCH_SANDBOX_HIDDEN
+exec "$@"`
+
+// sandboxCommand returns the command running name in the sandbox. None of the
+// sandboxes has network access apart from loopback, nor sees the processes
+// outside of it. Only the writable directories of spec can be written to.
+func sandboxCommand(sandbox string, spec sandboxSpec, name string, args []string) (string, []string, error) {
+	var prefix []string
+	switch sandbox {
+	case "":
+		return name, args, nil
+	case sandboxUnshare:
+		// the loopback interface of the new network namespace is down, /proc
+		// is mounted for the new PID namespace
+		prefix = []string{
+			"env",
+			"PYROBENCH_SANDBOX_RW=" + strings.Join(spec.writable, "\n"),
+			"PYROBENCH_SANDBOX_HIDDEN=" + strings.Join(spec.hidden, "\n"),
+			"unshare", "--user", "--map-root-user", "--net", "--mount", "--pid", "--fork", "--mount-proc", "--",
+			"sh", "-c", sandboxUnshareScript, "sh",
+		}
+	case sandboxNsjail:
+		// the chroot is mounted read-only without --rw
+		prefix = []string{"nsjail", "--mode", "o", "--quiet", "--chroot", "/", "--keep_env", "--disable_rlimits", "--time_limit", "0"}
+		for _, dir := range spec.writable {
+			prefix = append(prefix, "--bindmount", dir)
+		}
+		for _, path := range spec.hidden {
+			switch dir, ok := statHidden(path); {
+			case !ok:
+			case dir:
+				prefix = append(prefix, "--tmpfsmount", path)
+			default:
+				prefix = append(prefix, "--bindmount_ro", os.DevNull+":"+path)
+			}
+		}
+		prefix = append(prefix, "--cwd", spec.dir, "--")
+	case sandboxRunsc:
+		// files written outside of the volumes go to the overlay of the root
+		// and are discarded on exit
+		prefix = []string{"runsc", "--rootless", "--network=none", "do", "-cwd", spec.dir}
+		for _, dir := range spec.writable {
+			prefix = append(prefix, "-volume", dir+":"+dir)
+		}
+		for _, path := range spec.hidden {
+			dir, ok := statHidden(path)
+			if !ok {
+				continue
+			}
+			if !dir {
+				prefix = append(prefix, "-volume", os.DevNull+":"+path)
+				continue
+			}
+			if spec.empty == "" {
+				return "", nil, errors.New("the runsc sandbox needs an empty directory to hide directories")
+			}
+			prefix = append(prefix, "-volume", spec.empty+":"+path)
+		}
+		prefix = append(prefix, "--")
+	default:
+		return "", nil, fmt.Errorf("%w %q", errSandboxUnknown, sandbox)
+	}
+	return prefix[0], append(append(prefix[1:], name), args...), nil
+}
+
+// sandboxHidden returns the directories hidden from the sandboxed commands of
+// the checkout in dir: the git directory, which might hold the credentials of
+// the runner, e.g. persisted by actions/checkout.
+func sandboxHidden(ctx context.Context, dir string) ([]string, error) {
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "--path-format=absolute", "--git-common-dir").Output()
+	if err != nil {
+		return nil, fmt.Errorf("error finding the git directory of %s: %w", dir, err)
+	}
+	return []string{strings.TrimSpace(string(out))}, nil
+}
+
+// sandboxSpec returns the file system of the package's sandboxed commands:
+// they may write to the package's directory, the working directory of the
+// test binary, the scratch directories of the run and the additional
+// directories, e.g. where the test binary writes its profiles to.
+func (p *Package) sandboxSpec(scratch *scratchDirs, writable ...string) sandboxSpec {
+	spec := sandboxSpec{
+		dir:      p.workingDir(),
+		writable: []string{p.meta.Dir},
+		hidden:   p.sandboxHidden,
+		empty:    scratch.empty(),
+	}
+	if dir := p.workingDir(); dir != p.meta.Dir {
+		spec.writable = append(spec.writable, dir)
+	}
+	spec.writable = append(spec.writable, scratch.tmp(), scratch.scratch())
+	for _, dir := range writable {
+		if dir != "" {
+			spec.writable = append(spec.writable, dir)
+		}
+	}
+	return spec
+}
+
+// statHidden returns whether the hidden path is a directory, ok is false
+// when it does not exist and there is nothing to hide.
+func statHidden(path string) (dir, ok bool) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false, false
+	}
+	return fi.IsDir(), true
+}
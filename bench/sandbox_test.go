@@ -0,0 +1,99 @@
+package bench
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandboxCommand(t *testing.T) {
+	gitDir := t.TempDir()
+	key := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(key, []byte("secret"), 0o600))
+	spec := sandboxSpec{
+		dir:      "/src/pkg",
+		writable: []string{"/src/pkg", "/tmp/out"},
+		hidden:   []string{gitDir, key, "/missing"},
+		empty:    "/tmp/run/empty",
+	}
+	name, args, err := sandboxCommand("", spec, "/tmp/pkg.test", []string{"-test.run", "^$"})
+	require.NoError(t, err)
+	require.Equal(t, "/tmp/pkg.test", name)
+	require.Equal(t, []string{"-test.run", "^$"}, args)
+
+	name, args, err = sandboxCommand(sandboxUnshare, spec, "/tmp/pkg.test", []string{"-test.run", "^$"})
+	require.NoError(t, err)
+	require.Equal(t, "env", name)
+	require.Contains(t, args, "PYROBENCH_SANDBOX_RW=/src/pkg\n/tmp/out")
+	require.Contains(t, args, "PYROBENCH_SANDBOX_HIDDEN="+gitDir+"\n"+key+"\n/missing")
+	require.Contains(t, args, "--net")
+	require.Contains(t, args, "--mount")
+	require.Contains(t, args, "--pid")
+	require.Equal(t, []string{"sh", "/tmp/pkg.test", "-test.run", "^$"}, args[len(args)-4:])
+
+	name, args, err = sandboxCommand(sandboxNsjail, spec, "/tmp/pkg.test", nil)
+	require.NoError(t, err)
+	require.Equal(t, "nsjail", name)
+	require.NotContains(t, args, "--rw")
+	require.Equal(t, []string{"--bindmount", "/src/pkg", "--bindmount", "/tmp/out", "--tmpfsmount", gitDir, "--bindmount_ro", "/dev/null:" + key, "--cwd", "/src/pkg", "--", "/tmp/pkg.test"}, args[len(args)-12:])
+
+	name, args, err = sandboxCommand(sandboxRunsc, spec, "/tmp/pkg.test", nil)
+	require.NoError(t, err)
+	require.Equal(t, "runsc", name)
+	require.Contains(t, args, "--network=none")
+	require.NotContains(t, args, "--force-overlay=false")
+	require.Equal(t, []string{"-volume", "/src/pkg:/src/pkg", "-volume", "/tmp/out:/tmp/out", "-volume", "/tmp/run/empty:" + gitDir, "-volume", "/dev/null:" + key, "--", "/tmp/pkg.test"}, args[len(args)-10:])
+
+	_, _, err = sandboxCommand("docker", spec, "/tmp/pkg.test", nil)
+	require.ErrorIs(t, err, errSandboxUnknown)
+}
+
+func TestSandboxUnshare(t *testing.T) {
+	if err := exec.Command("unshare", "--user", "--map-root-user", "--mount", "--", "true").Run(); err != nil {
+		t.Skipf("unshare is not available: %v", err)
+	}
+	dir := t.TempDir()
+	for _, d := range []string{"pkg", "other", "git"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, d), 0o755))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "git", "config"), []byte("token"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "key.pem"), []byte("secret"), 0o600))
+	spec := sandboxSpec{
+		dir:      filepath.Join(dir, "pkg"),
+		writable: []string{filepath.Join(dir, "pkg")},
+		hidden:   []string{filepath.Join(dir, "git"), filepath.Join(dir, "key.pem")},
+	}
+	name, args, err := sandboxCommand(sandboxUnshare, spec, "sh", []string{"-c", `
+touch pkg/written || exit 1
+touch other/written 2>/dev/null && exit 2
+test -e git/config && exit 3
+test -s key.pem && exit 5
+test -r /proc/$PPID/environ && exit 4
+awk '$5 == "/" && $6 !~ /^ro/ {exit 1}' /proc/self/mountinfo || exit 6
+exit 0`})
+	require.NoError(t, err)
+	c := exec.Command(name, args...)
+	c.Dir = dir
+	out, err := c.CombinedOutput()
+	require.NoError(t, err, string(out))
+	require.FileExists(t, filepath.Join(dir, "pkg", "written"))
+	require.NoFileExists(t, filepath.Join(dir, "other", "written"))
+	require.FileExists(t, filepath.Join(dir, "git", "config"))
+}
+
+func TestSandboxArgs(t *testing.T) {
+	var disabled *SandboxArgs
+	require.Equal(t, "", disabled.mode(benchSourceHead))
+	require.NoError(t, disabled.validate())
+	require.Equal(t, "", (&SandboxArgs{Mode: sandboxNone}).mode(benchSourceHead))
+
+	args := &SandboxArgs{Mode: sandboxRunsc}
+	require.Equal(t, sandboxRunsc, args.mode(benchSourceHead))
+	require.Equal(t, "", args.mode(benchSourceBase))
+	args.Base = true
+	require.Equal(t, sandboxRunsc, args.mode(benchSourceBase))
+	require.EqualError(t, (&SandboxArgs{Mode: "missing-sandbox"}).validate(), `--sandbox missing-sandbox: exec: "missing-sandbox": executable file not found in $PATH`)
+}
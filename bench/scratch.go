@@ -0,0 +1,126 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// scratchEnv points the test binaries run locally to a directory of their
+// own, which they may write to without interfering with other runs.
+const scratchEnv = "PYROBENCH_SCRATCH"
+
+// maxLeftovers limits the files named in the warning about leftovers.
+const maxLeftovers = 3
+
+// scratchDirs are the temporary and the scratch directory of a single run of
+// a test binary, so runs of base and head do not see each other's files. They
+// are removed on cleanup.
+type scratchDirs struct {
+	root string
+}
+
+func newScratchDirs(ctx context.Context) (*scratchDirs, error) {
+	s, err := makeScratchDirs()
+	if err != nil {
+		return nil, err
+	}
+	cleanupFromContext(ctx)(s.remove)
+	return s, nil
+}
+
+// makeScratchDirs creates the scratch directories, which the caller needs to
+// remove.
+func makeScratchDirs() (*scratchDirs, error) {
+	root, err := os.MkdirTemp("", "pyrobench-run-")
+	if err != nil {
+		return nil, err
+	}
+	s := &scratchDirs{root: root}
+	for _, dir := range []string{s.tmp(), s.scratch(), s.empty()} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			return nil, errors.Join(err, s.remove())
+		}
+	}
+	return s, nil
+}
+
+func (s *scratchDirs) remove() error {
+	return os.RemoveAll(s.root)
+}
+
+func (s *scratchDirs) tmp() string {
+	return filepath.Join(s.root, "tmp")
+}
+
+func (s *scratchDirs) scratch() string {
+	return filepath.Join(s.root, "scratch")
+}
+
+// empty is mounted over the directories hidden from a sandboxed run.
+func (s *scratchDirs) empty() string {
+	return filepath.Join(s.root, "empty")
+}
+
+// env points the temporary directory of the test binary, e.g. os.TempDir, to
+// the one of the run.
+func (s *scratchDirs) env() []string {
+	return []string{
+		"TMPDIR=" + s.tmp(),
+		"TMP=" + s.tmp(),
+		"TEMP=" + s.tmp(),
+		scratchEnv + "=" + s.scratch(),
+	}
+}
+
+// leftovers returns the files and directories left behind by the test binary,
+// relative to the root and sorted. The contents of directories left behind
+// are not listed.
+func (s *scratchDirs) leftovers() ([]string, error) {
+	var paths []string
+	for _, dir := range []string{s.tmp(), s.scratch()} {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path == dir {
+				return nil
+			}
+			rel, err := filepath.Rel(s.root, path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, filepath.ToSlash(rel))
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// leftoversWarning returns a warning about the files a run of the source left
+// in its temporary or scratch directory, empty when there are none.
+func leftoversWarning(source benchSource, leftovers []string) string {
+	if len(leftovers) == 0 {
+		return ""
+	}
+	names := leftovers
+	if len(names) > maxLeftovers {
+		names = append(names[:maxLeftovers:maxLeftovers], fmt.Sprintf("%d more", len(leftovers)-maxLeftovers))
+	}
+	return fmt.Sprintf(
+		"A run of %s left files behind in its temporary directory (%s), the benchmark or its TestMain does not clean up after itself, which might affect later runs.",
+		source, strings.Join(names, ", "),
+	)
+}
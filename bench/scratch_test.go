@@ -0,0 +1,79 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScratchDirs(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.22\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "m_test.go"), []byte(`package m
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func BenchmarkClean(b *testing.B) {
+	dir := b.TempDir()
+	for i := 0; i < b.N; i++ {
+		_ = os.WriteFile(filepath.Join(dir, "data"), nil, 0o644)
+	}
+}
+
+func BenchmarkLeaky(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		f, _ := os.CreateTemp("", "leaky-")
+		f.Close()
+	}
+	_ = os.MkdirAll(filepath.Join(os.Getenv("PYROBENCH_SCRATCH"), "db", "wal"), 0o755)
+}
+`), 0o644))
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	pkgs, err := discoverPackages(ctx, log.NewNopLogger(), dir, []string{"./..."}, nil)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	p := &pkgs[0]
+	require.NoError(t, p.compileTest(ctx))
+
+	opts := runOptions{benchTime: "4x", count: 1, timeout: time.Minute, noProfiles: true}
+	res, err := p.runBenchmark(ctx, opts, "BenchmarkClean")
+	require.NoError(t, err)
+	require.Empty(t, res.Leftovers)
+
+	res, err = p.runBenchmark(ctx, opts, "BenchmarkLeaky")
+	require.NoError(t, err)
+	// the benchmark runs once before the 4 iterations
+	require.Len(t, res.Leftovers, 6)
+	require.Equal(t, "scratch/db", res.Leftovers[0])
+	for _, l := range res.Leftovers[1:] {
+		require.True(t, strings.HasPrefix(l, "tmp/leaky-"), l)
+	}
+	require.Equal(t,
+		"A run of head left files behind in its temporary directory ("+strings.Join(res.Leftovers[:3], ", ")+", 3 more), the benchmark or its TestMain does not clean up after itself, which might affect later runs.",
+		leftoversWarning(benchSourceHead, res.Leftovers),
+	)
+	require.Empty(t, leftoversWarning(benchSourceBase, nil))
+
+	// the scratch directories are removed on cleanup
+	matches, err := filepath.Glob(filepath.Join(tmp, "pyrobench-run-*"))
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	require.NoError(t, cleaner.cleanup())
+	for _, m := range matches {
+		require.NoDirExists(t, m)
+	}
+}
@@ -0,0 +1,94 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log/level"
+)
+
+type ScrubEnvArgs struct {
+	Enabled bool     // only pass the allowed variables on to the test binaries and hooks
+	Allow   []string // globs of variable names kept in addition to the defaults
+}
+
+func addScrubEnvArgs(cmd *kingpin.CmdClause, enabled bool) *ScrubEnvArgs {
+	args := &ScrubEnvArgs{}
+	def := "false"
+	if enabled {
+		def = "true"
+	}
+	cmd.Flag("scrub-env", "Only pass the variables of an allowlist on to the test binaries and the setup and teardown hooks, so the benchmarked code can not read secrets like GITHUB_TOKEN from the environment. Compiling keeps the full environment. On Linux pyrobench also hides its own environment in /proc from them, elsewhere the scrubbing is best-effort, as processes of the same user can read the environment of pyrobench.").Default(def).BoolVar(&args.Enabled)
+	cmd.Flag("scrub-env-allow", "Glob of environment variable names to keep in addition to the defaults when scrubbing, e.g. 'DATABASE_*'. Can be repeated.").PlaceHolder("GLOB").StringsVar(&args.Allow)
+	return args
+}
+
+// scrubEnvAllowed are the globs of the variables kept by default: those the
+// Go toolchain, the runtime, the shell and the C toolchain rely on.
+var scrubEnvAllowed = []string{
+	"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "HOSTNAME",
+	"TMPDIR", "TMP", "TEMP",
+	"LANG", "LANGUAGE", "LC_*", "TZ",
+	// not GO*, which would keep e.g. GOOGLE_APPLICATION_CREDENTIALS
+	"GOROOT", "GOPATH", "GOBIN", "GOCACHE", "GOMODCACHE", "GOTMPDIR", "GOENV", "GOFLAGS", "GOWORK", "GO111MODULE",
+	"GOOS", "GOARCH", "GOAMD64", "GOARM", "GOARM64", "GO386", "GOEXPERIMENT", "GOTOOLCHAIN",
+	"GOPROXY", "GOPRIVATE", "GONOPROXY", "GONOSUMDB", "GOSUMDB", "GOINSECURE",
+	"GOGC", "GOMEMLIMIT", "GOMAXPROCS", "GODEBUG", "GOTRACEBACK",
+	"CGO_*", "CC", "CXX", "AR", "PKG_CONFIG_PATH", "LD_LIBRARY_PATH",
+	"SYSTEMROOT", "COMSPEC", "PATHEXT", "WINDIR", "USERPROFILE", "APPDATA", "LOCALAPPDATA",
+}
+
+// scrub returns the variables of environ allowed by default or by args.
+func (args *ScrubEnvArgs) scrub(environ []string) []string {
+	env := make([]string, 0, len(environ))
+	for _, e := range environ {
+		name, _, _ := strings.Cut(e, "=")
+		if args.allowed(name) {
+			env = append(env, e)
+		}
+	}
+	return env
+}
+
+func (args *ScrubEnvArgs) allowed(name string) bool {
+	for _, globs := range [][]string{scrubEnvAllowed, args.Allow} {
+		for _, glob := range globs {
+			if ok, _ := path.Match(glob, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func addEnvironToContext(ctx context.Context, environ []string) context.Context {
+	return context.WithValue(ctx, contextKeyEnviron, environ)
+}
+
+// environFromContext returns the environment the test binaries and hooks
+// inherit, the one of pyrobench unless it gets scrubbed. It is shared by
+// concurrent runs, so appending to it copies it.
+func environFromContext(ctx context.Context) []string {
+	env, ok := ctx.Value(contextKeyEnviron).([]string)
+	if !ok {
+		return os.Environ()
+	}
+	return slices.Clip(env)
+}
+
+func (b *Benchmark) scrubEnvContext(ctx context.Context, args *ScrubEnvArgs) context.Context {
+	if args == nil || !args.Enabled {
+		return ctx
+	}
+	if err := hideEnviron(); err != nil {
+		level.Warn(b.logger).Log("msg", "the test binaries may read the environment of pyrobench from /proc", "err", err)
+	}
+	environ := os.Environ()
+	env := args.scrub(environ)
+	level.Info(b.logger).Log("msg", "scrubbing the environment of the test binaries", "kept", len(env), "removed", len(environ)-len(env))
+	return addEnvironToContext(ctx, env)
+}
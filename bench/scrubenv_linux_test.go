@@ -0,0 +1,17 @@
+//go:build linux
+
+package bench
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestHideEnviron(t *testing.T) {
+	require.NoError(t, hideEnviron())
+	dumpable, err := unix.PrctlRetInt(unix.PR_GET_DUMPABLE, 0, 0, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, 0, dumpable)
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package bench
+
+// hideEnviron is not implemented on this platform, processes of the same user
+// may still read the environment of pyrobench.
+func hideEnviron() error { return nil }
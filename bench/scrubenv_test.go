@@ -0,0 +1,37 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrubEnv(t *testing.T) {
+	args := &ScrubEnvArgs{Enabled: true, Allow: []string{"DATABASE_*"}}
+	require.Equal(t, []string{
+		"PATH=/usr/bin",
+		"GOFLAGS=-mod=mod",
+		"LC_ALL=C",
+		"DATABASE_URL=postgres://localhost",
+	}, args.scrub([]string{
+		"PATH=/usr/bin",
+		"GITHUB_TOKEN=ghp_secret",
+		"GOFLAGS=-mod=mod",
+		"GOOGLE_APPLICATION_CREDENTIALS=/etc/key.json",
+		"LC_ALL=C",
+		"AWS_SECRET_ACCESS_KEY=secret",
+		"DATABASE_URL=postgres://localhost",
+	}))
+
+	// the environment is only scrubbed when enabled
+	t.Setenv("GITHUB_TOKEN", "ghp_secret")
+	b := &Benchmark{logger: log.NewNopLogger()}
+	ctx := b.scrubEnvContext(context.Background(), &ScrubEnvArgs{})
+	require.Equal(t, os.Environ(), environFromContext(ctx))
+	ctx = b.scrubEnvContext(context.Background(), args)
+	require.NotContains(t, environFromContext(ctx), "GITHUB_TOKEN=ghp_secret")
+	require.Contains(t, environFromContext(ctx), "PATH="+os.Getenv("PATH"))
+}
@@ -0,0 +1,223 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/github"
+)
+
+type ServerArgs struct {
+	*GitHubCommentHookArgs
+	App *github.AppArgs
+
+	Listen        string
+	WebhookSecret string
+	Repositories  []string // globs of owner/repo allowed to run benchmarks
+	WorkDir       string   // the repositories are checked out below it
+	QueueSize     int      // events waiting for their benchmarks at most
+}
+
+func AddServerCommand(app *kingpin.Application) (*kingpin.CmdClause, *ServerArgs) {
+	cmd := app.Command("server", "Run as GitHub App, which receives the comments of all repositories it is installed in, instead of a workflow per repository.")
+	args := &ServerArgs{
+		GitHubCommentHookArgs: addGitHubCommentHookArgs(cmd, github.AddAppCommentHookArgs(cmd)),
+		App:                   github.AddAppArgs(cmd),
+	}
+	cmd.Flag("listen", "Address to receive the webhooks of the GitHub App on.").Default(":8080").StringVar(&args.Listen)
+	cmd.Flag("webhook-secret", "Webhook secret of the GitHub App to verify the X-Hub-Signature-256 header with.").Envar("PYROBENCH_WEBHOOK_SECRET").Required().StringVar(&args.WebhookSecret)
+	cmd.Flag("repository", "Glob of the repositories allowed to run benchmarks, e.g. 'my-org/*'. Can be repeated.").PlaceHolder("OWNER/REPO").Required().StringsVar(&args.Repositories)
+	cmd.Flag("work-dir", "Directory to check out the repositories in.").Required().ExistingDirVar(&args.WorkDir)
+	cmd.Flag("queue-size", "How many requested benchmarks wait at most, while others are running.").Default("20").IntVar(&args.QueueSize)
+	return cmd, args
+}
+
+// validateIsolation checks the code of the pull requests is isolated from
+// the server, which holds the private key of the GitHub App and the webhook
+// secret. Remote executors run the test binaries away from the server.
+func (args *ServerArgs) validateIsolation() error {
+	if !args.Sandbox.enabled() && args.Executor == executorLocal {
+		return errors.New("the server requires a --sandbox, as it runs the code of pull requests next to the private key of the GitHub App")
+	}
+	if args.ScrubEnv == nil || !args.ScrubEnv.Enabled {
+		return errors.New("the server requires --scrub-env, as it runs the code of pull requests next to the private key of the GitHub App")
+	}
+	return nil
+}
+
+// serverJob is a comment requesting benchmarks, queued until the ones
+// requested before ran.
+type serverJob struct {
+	delivery string // ID of the webhook delivery
+	event    *github.WebhookEvent
+}
+
+// Server receives the webhooks of the GitHub App and runs the benchmarks
+// requested by comments one after another, until the context is canceled.
+func (b *Benchmark) Server(ctx context.Context, args *ServerArgs) error {
+	if args.QueueSize < 1 {
+		return fmt.Errorf("invalid queue size %d", args.QueueSize)
+	}
+	for _, glob := range args.Repositories {
+		if _, err := path.Match(glob, ""); err != nil {
+			return fmt.Errorf("invalid --repository %q: %w", glob, err)
+		}
+	}
+	if err := args.validateIsolation(); err != nil {
+		return err
+	}
+	app, err := github.NewApp(args.App)
+	if err != nil {
+		return err
+	}
+	// the key stays readable by the user running the server
+	key, err := filepath.Abs(args.App.PrivateKeyPath)
+	if err != nil {
+		return err
+	}
+	args.Sandbox.Hidden = append(args.Sandbox.Hidden, key)
+
+	queue := make(chan serverJob, args.QueueSize)
+	srv := &http.Server{
+		Addr:              args.Listen,
+		Handler:           appWebhookHandler(b.logger, args, queue),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.ListenAndServe()
+	}()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	level.Info(b.logger).Log("msg", "waiting for webhooks of the GitHub App", "listen", args.Listen, "repositories", strings.Join(args.Repositories, ","))
+
+	for {
+		select {
+		case err := <-errCh:
+			return err
+		case <-ctx.Done():
+			return nil
+		case job := <-queue:
+			if err := b.serveJob(ctx, args, app, job); err != nil {
+				level.Error(b.logger).Log("msg", "error running requested benchmarks", "repository", job.event.Repository, "delivery", job.delivery, "err", err)
+			}
+		}
+	}
+}
+
+// serveJob runs the comment hook for the event in the checkout of its
+// repository, authenticated as the installation of the app with a token
+// scoped to the repository. Jobs run one at a time, so they do not disturb
+// each other's benchmarks.
+func (b *Benchmark) serveJob(ctx context.Context, args *ServerArgs, app *github.App, job serverJob) error {
+	logger := log.With(b.logger, "repository", job.event.Repository, "delivery", job.delivery)
+	token, err := app.InstallationToken(ctx, job.event.Installation, job.event.Repository)
+	if err != nil {
+		return err
+	}
+
+	dir, err := filepath.Abs(filepath.Join(args.WorkDir, filepath.FromSlash(job.event.Repository)))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	ghArgs := *args.CommentHookArgs.Args
+	ghArgs.Token = token
+	ghArgs.Context = job.event.Context
+	hookArgs := *args.CommentHookArgs
+	hookArgs.Args = &ghArgs
+	jobArgs := *args.GitHubCommentHookArgs
+	jobArgs.CommentHookArgs = &hookArgs
+
+	level.Info(logger).Log("msg", "running requested benchmarks")
+	fb := b.fresh()
+	fb.logger = logger
+	fb.dir = dir
+	return fb.GitHubCommentHook(ctx, &jobArgs)
+}
+
+// appWebhookHandler queues the comments mentioning the bot in pull requests
+// of the allowed repositories.
+func appWebhookHandler(logger log.Logger, args *ServerArgs, queue chan<- serverJob) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 25<<20))
+		if err != nil {
+			http.Error(w, "error reading body", http.StatusBadRequest)
+			return
+		}
+		if !validSignature(args.WebhookSecret, body, r.Header.Get("X-Hub-Signature-256")) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		name := r.Header.Get("X-GitHub-Event")
+		if name != "issue_comment" {
+			// e.g. ping or installation events
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		event, err := github.ParseWebhookEvent(name, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !matchesRepository(args.Repositories, event.Repository) {
+			level.Warn(logger).Log("msg", "ignoring comment of repository not allowed", "repository", event.Repository)
+			http.Error(w, "repository not allowed", http.StatusForbidden)
+			return
+		}
+		if event.Action != "created" || !event.PullRequest || !strings.Contains(event.Comment, args.BotName) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if event.Installation == 0 {
+			http.Error(w, "event lacks the installation", http.StatusBadRequest)
+			return
+		}
+		job := serverJob{delivery: r.Header.Get("X-GitHub-Delivery"), event: event}
+		select {
+		case queue <- job:
+		default:
+			level.Warn(logger).Log("msg", "queue is full, dropping requested benchmarks", "repository", event.Repository, "delivery", job.delivery)
+			http.Error(w, "queue is full", http.StatusServiceUnavailable)
+			return
+		}
+		level.Info(logger).Log("msg", "queued requested benchmarks", "repository", event.Repository, "delivery", job.delivery)
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// matchesRepository returns true, when one of the globs matches the
+// repository.
+func matchesRepository(globs []string, repository string) bool {
+	if repository == "" {
+		return false
+	}
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, repository); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,96 @@
+package bench
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/github"
+)
+
+func TestAppWebhookHandler(t *testing.T) {
+	queue := make(chan serverJob, 1)
+	h := appWebhookHandler(log.NewNopLogger(), &ServerArgs{
+		GitHubCommentHookArgs: &GitHubCommentHookArgs{
+			CommentHookArgs: &github.CommentHookArgs{BotName: "@pyrobench"},
+		},
+		WebhookSecret: "secret",
+		Repositories:  []string{"my-org/*"},
+	}, queue)
+
+	post := func(event, body string, signed bool) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-GitHub-Delivery", "delivery-1")
+		if signed {
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(body))
+			req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	comment := func(repo, body string) string {
+		return fmt.Sprintf(`{
+			"action": "created",
+			"repository": {"full_name": %q},
+			"installation": {"id": 7},
+			"issue": {"number": 1, "pull_request": {"url": "https://api.github.com/repos/%s/pulls/1"}},
+			"comment": {"id": 2, "body": %q}
+		}`, repo, repo, body)
+	}
+
+	require.Equal(t, http.StatusUnauthorized, post("issue_comment", comment("my-org/repo", "@pyrobench BenchmarkA"), false))
+	require.Equal(t, http.StatusNoContent, post("ping", `{"zen": "Keep it logically awesome."}`, true))
+	require.Equal(t, http.StatusForbidden, post("issue_comment", comment("other-org/repo", "@pyrobench BenchmarkA"), true))
+	require.Equal(t, http.StatusNoContent, post("issue_comment", comment("my-org/repo", "looks good"), true))
+	require.Len(t, queue, 0)
+
+	require.Equal(t, http.StatusAccepted, post("issue_comment", comment("my-org/repo", "@pyrobench BenchmarkA"), true))
+	require.Len(t, queue, 1)
+	job := <-queue
+	require.Equal(t, "delivery-1", job.delivery)
+	require.Equal(t, "my-org/repo", job.event.Repository)
+	require.Equal(t, int64(7), job.event.Installation)
+
+	// requests are not queued without bound
+	require.Equal(t, http.StatusAccepted, post("issue_comment", comment("my-org/repo", "@pyrobench BenchmarkA"), true))
+	require.Equal(t, http.StatusServiceUnavailable, post("issue_comment", comment("my-org/repo", "@pyrobench BenchmarkB"), true))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestMatchesRepository(t *testing.T) {
+	globs := []string{"my-org/*", "other-org/benchmarks"}
+	require.True(t, matchesRepository(globs, "my-org/repo"))
+	require.True(t, matchesRepository(globs, "other-org/benchmarks"))
+	require.False(t, matchesRepository(globs, "other-org/repo"))
+	require.False(t, matchesRepository(globs, ""))
+	require.False(t, matchesRepository(nil, "my-org/repo"))
+}
+
+func TestServerIsolation(t *testing.T) {
+	args := &ServerArgs{GitHubCommentHookArgs: &GitHubCommentHookArgs{
+		Executor: executorLocal,
+		Sandbox:  &SandboxArgs{Mode: sandboxNone},
+		ScrubEnv: &ScrubEnvArgs{Enabled: true},
+	}}
+	require.ErrorContains(t, args.validateIsolation(), "the server requires a --sandbox")
+	args.Executor = executorAgent
+	require.NoError(t, args.validateIsolation())
+	args.Executor, args.Sandbox.Mode = executorLocal, sandboxUnshare
+	require.NoError(t, args.validateIsolation())
+	args.ScrubEnv.Enabled = false
+	require.ErrorContains(t, args.validateIsolation(), "the server requires --scrub-env")
+}
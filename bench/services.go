@@ -0,0 +1,255 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/config"
+)
+
+const (
+	// serviceStartTimeout limits how long a service may take to accept
+	// connections.
+	serviceStartTimeout = 2 * time.Minute
+	// servicePollInterval is how often a service is checked for accepting
+	// connections.
+	servicePollInterval = 200 * time.Millisecond
+	// serviceHost is the address the ports of the services are published on.
+	serviceHost = "127.0.0.1"
+)
+
+func addServicesToContext(ctx context.Context, s *services) context.Context {
+	return context.WithValue(ctx, contextKeyServices, s)
+}
+
+func servicesFromContext(ctx context.Context) *services {
+	s, _ := ctx.Value(contextKeyServices).(*services)
+	return s
+}
+
+// dockerFunc runs docker with the arguments and returns its stdout.
+type dockerFunc func(ctx context.Context, args ...string) ([]byte, error)
+
+func runDocker(ctx context.Context, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	c := exec.CommandContext(ctx, "docker", args...)
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return nil, fmt.Errorf("docker %s: %w\n%s", args[0], err, stderr.Bytes())
+	}
+	return out, nil
+}
+
+// runningService is a started container, shared by all packages depending on
+// a service of the same configuration.
+type runningService struct {
+	env []string // KEY=value
+	err error
+}
+
+// services starts the containers of the services the packages depend on. Each
+// service is started once per comparison, so base and head run against the
+// very same one. The containers are removed on cleanup.
+type services struct {
+	logger  log.Logger
+	docker  dockerFunc
+	dial    func(ctx context.Context, addr string) error
+	timeout time.Duration
+
+	mtx     sync.Mutex
+	running map[string]*runningService // keyed by the configuration of the service
+}
+
+func newServices(logger log.Logger, docker dockerFunc) *services {
+	return &services{
+		logger: logger,
+		docker: docker,
+		dial: func(ctx context.Context, addr string) error {
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		},
+		timeout: serviceStartTimeout,
+		running: make(map[string]*runningService),
+	}
+}
+
+// start returns the environment holding the address of the service, it is
+// started when it is not running yet.
+func (s *services) start(ctx context.Context, svc config.Service) ([]string, error) {
+	if s == nil {
+		return nil, errors.New("services are not available")
+	}
+	key, err := json.Marshal(svc)
+	if err != nil {
+		return nil, err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if r, ok := s.running[string(key)]; ok {
+		return r.env, r.err
+	}
+	r := &runningService{}
+	r.env, r.err = s.run(ctx, svc)
+	s.running[string(key)] = r
+	return r.env, r.err
+}
+
+func (s *services) run(ctx context.Context, svc config.Service) ([]string, error) {
+	ports, err := s.exposedPorts(ctx, svc.Image)
+	if err != nil {
+		return nil, err
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("image %s exposes no ports", svc.Image)
+	}
+
+	args := []string{"run", "--detach", "--rm", "--label", "pyrobench.service=" + svc.EnvName()}
+	for _, p := range ports {
+		args = append(args, "--publish", serviceHost+"::"+p)
+	}
+	env := make([]string, 0, len(svc.Env))
+	for k, v := range svc.Env {
+		env = append(env, k+"="+v)
+	}
+	sort.Strings(env)
+	for _, e := range env {
+		args = append(args, "--env", e)
+	}
+	args = append(args, svc.Image)
+
+	level.Info(s.logger).Log("msg", "starting service", "image", svc.Image)
+	out, err := s.docker(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	id := strings.TrimSpace(string(out))
+	cleanupFromContext(ctx)(func() error {
+		// the container is removed after cancellation as well
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), teardownTimeout)
+		defer cancel()
+		if _, err := s.docker(ctx, "rm", "--force", id); err != nil {
+			return fmt.Errorf("removing service %s failed: %w", svc.Image, err)
+		}
+		return nil
+	})
+
+	published, err := s.publishedPorts(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	name := svc.EnvName()
+	result := []string{name + "_HOST=" + serviceHost}
+	var primary string
+	for _, p := range ports {
+		hostPort, ok := published[p]
+		if !ok {
+			return nil, fmt.Errorf("port %s of service %s has not been published", p, svc.Image)
+		}
+		if primary == "" {
+			primary = hostPort
+			result = append(result, name+"_PORT="+hostPort)
+		}
+		number, _, _ := strings.Cut(p, "/")
+		result = append(result, name+"_PORT_"+number+"="+hostPort)
+	}
+
+	addr := net.JoinHostPort(serviceHost, primary)
+	if err := s.waitReady(ctx, addr); err != nil {
+		return nil, fmt.Errorf("service %s does not accept connections on %s: %w", svc.Image, addr, err)
+	}
+	level.Info(s.logger).Log("msg", "service is ready", "image", svc.Image, "addr", addr)
+	return result, nil
+}
+
+// exposedPorts returns the TCP ports exposed by the image, sorted by their
+// number. The image is pulled, when it is missing.
+func (s *services) exposedPorts(ctx context.Context, image string) ([]string, error) {
+	inspect := func() ([]byte, error) {
+		return s.docker(ctx, "image", "inspect", "--format", "{{json .Config.ExposedPorts}}", image)
+	}
+	out, err := inspect()
+	if err != nil {
+		if _, pullErr := s.docker(ctx, "pull", image); pullErr != nil {
+			return nil, pullErr
+		}
+		if out, err = inspect(); err != nil {
+			return nil, err
+		}
+	}
+	var exposed map[string]struct{}
+	if err := json.Unmarshal(bytes.TrimSpace(out), &exposed); err != nil {
+		return nil, fmt.Errorf("invalid exposed ports of image %s: %w", image, err)
+	}
+	var ports []string
+	for p := range exposed {
+		if strings.HasSuffix(p, "/tcp") {
+			ports = append(ports, p)
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		a, _ := strconv.Atoi(strings.TrimSuffix(ports[i], "/tcp"))
+		b, _ := strconv.Atoi(strings.TrimSuffix(ports[j], "/tcp"))
+		return a < b
+	})
+	return ports, nil
+}
+
+// publishedPorts returns the host port of every published port of the
+// container.
+func (s *services) publishedPorts(ctx context.Context, id string) (map[string]string, error) {
+	out, err := s.docker(ctx, "container", "inspect", "--format", "{{json .NetworkSettings.Ports}}", id)
+	if err != nil {
+		return nil, err
+	}
+	var bindings map[string][]struct {
+		HostIP   string `json:"HostIp"`
+		HostPort string `json:"HostPort"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(out), &bindings); err != nil {
+		return nil, fmt.Errorf("invalid ports of container %s: %w", id, err)
+	}
+	result := make(map[string]string, len(bindings))
+	for p, b := range bindings {
+		if len(b) > 0 {
+			result[p] = b[0].HostPort
+		}
+	}
+	return result, nil
+}
+
+// waitReady waits until the address accepts connections.
+func (s *services) waitReady(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	ticker := time.NewTicker(servicePollInterval)
+	defer ticker.Stop()
+	for {
+		err := s.dial(ctx, addr)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/config"
+)
+
+// fakeDocker pretends to run docker, the redis image is only available after
+// it has been pulled.
+type fakeDocker struct {
+	calls  []string
+	pulled bool
+}
+
+func (d *fakeDocker) run(_ context.Context, args ...string) ([]byte, error) {
+	d.calls = append(d.calls, strings.Join(args, " "))
+	switch args[0] {
+	case "pull":
+		d.pulled = true
+		return nil, nil
+	case "image":
+		if !d.pulled {
+			return nil, errors.New("no such image")
+		}
+		return []byte(`{"6379/tcp":{},"16379/tcp":{},"53/udp":{}}` + "\n"), nil
+	case "run":
+		return []byte("c0ffee\n"), nil
+	case "container":
+		return []byte(`{"6379/tcp":[{"HostIp":"127.0.0.1","HostPort":"32768"}],"16379/tcp":[{"HostIp":"127.0.0.1","HostPort":"32769"}]}`), nil
+	case "rm":
+		return nil, nil
+	}
+	return nil, errors.New("unexpected command")
+}
+
+func TestPackageServices(t *testing.T) {
+	cfg := &config.Config{Packages: map[string]*config.Package{
+		"example.com/m/...": {
+			Env:      map[string]string{"DB": "all"},
+			Services: []config.Service{{Image: "redis:7", Name: "CACHE", Env: map[string]string{"B": "2", "A": "1"}}},
+		},
+	}}
+	docker := &fakeDocker{}
+	s := newServices(log.NewNopLogger(), docker.run)
+	var dialed []string
+	s.dial = func(_ context.Context, addr string) error {
+		dialed = append(dialed, addr)
+		if len(dialed) < 2 {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	cleaner := &cleaner{}
+	ctx := addServicesToContext(addCleanupToContext(context.Background(), cleaner.add), s)
+
+	// base and head share the container
+	for _, dir := range []string{t.TempDir(), t.TempDir()} {
+		p := &Package{logger: log.NewNopLogger(), meta: &packageMeta{Dir: dir, ImportPath: "example.com/m/storage"}, hooks: newPackageHooks(cfg, "example.com/m/storage")}
+		require.NoError(t, p.runSetup(ctx))
+		require.Equal(t, []string{
+			"DB=all",
+			"CACHE_HOST=127.0.0.1",
+			"CACHE_PORT=32768",
+			"CACHE_PORT_6379=32768",
+			"CACHE_PORT_16379=32769",
+		}, p.hooks.env)
+	}
+	require.Equal(t, []string{"127.0.0.1:32768", "127.0.0.1:32768"}, dialed)
+
+	require.NoError(t, cleaner.cleanup())
+	require.Equal(t, []string{
+		"image inspect --format {{json .Config.ExposedPorts}} redis:7",
+		"pull redis:7",
+		"image inspect --format {{json .Config.ExposedPorts}} redis:7",
+		"run --detach --rm --label pyrobench.service=CACHE --publish 127.0.0.1::6379/tcp --publish 127.0.0.1::16379/tcp --env A=1 --env B=2 redis:7",
+		"container inspect --format {{json .NetworkSettings.Ports}} c0ffee",
+		"rm --force c0ffee",
+	}, docker.calls)
+
+	// the ports are not reachable by remote executors
+	p := &Package{logger: log.NewNopLogger(), meta: &packageMeta{Dir: t.TempDir(), ImportPath: "example.com/m/storage"}, hooks: newPackageHooks(cfg, "example.com/m/storage")}
+	ctx = addExecutorToContext(ctx, &agentExecutor{})
+	require.ErrorContains(t, p.runSetup(ctx), "services of example.com/m/storage require the local executor")
+}
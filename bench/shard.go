@@ -0,0 +1,68 @@
+package bench
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+func addShardArgs(cmd *kingpin.CmdClause, index, count *int) {
+	cmd.Flag("shard-index", "Run only the benchmarks of this shard, counting from 0.").Default("0").IntVar(index)
+	cmd.Flag("shard-count", "Split the benchmarks deterministically into this many shards, e.g. one per job of a GitHub Actions matrix, and run only those of --shard-index. The JSON reports of all shards can be combined with merge-reports.").Default("1").IntVar(count)
+}
+
+func validateShard(index, count int) error {
+	if count <= 1 && index == 0 {
+		return nil
+	}
+	if count < 1 {
+		return fmt.Errorf("--shard-count must be at least 1, got %d", count)
+	}
+	if index < 0 || index >= count {
+		return fmt.Errorf("--shard-index must be between 0 and %d, got %d", count-1, index)
+	}
+	return nil
+}
+
+// shardBenchmarks drops the benchmarks of the other shards from the packages
+// of base and head, so their packages are not compiled either. The benchmarks
+// of both sides are sorted by package and name and dealt out to the shards in
+// turn, which is the same in every job comparing the same commits. It returns
+// the number of benchmarks kept.
+func (b *Benchmark) shardBenchmarks(index, count int) int {
+	keys := make(map[benchKey]struct{})
+	for _, pkgs := range [][]Package{b.basePackages, b.headPackages} {
+		resultFromPackages(func(k benchKey, _ *Package) {
+			keys[k] = struct{}{}
+		}, pkgs)
+	}
+	sorted := make([]benchKey, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].packagePath != sorted[j].packagePath {
+			return sorted[i].packagePath < sorted[j].packagePath
+		}
+		return sorted[i].benchmark < sorted[j].benchmark
+	})
+	keep := make(map[benchKey]bool)
+	for i := index; i < len(sorted); i += count {
+		keep[sorted[i]] = true
+	}
+
+	for _, pkgs := range [][]Package{b.basePackages, b.headPackages} {
+		for idx := range pkgs {
+			p := &pkgs[idx]
+			names := p.benchmarkNames[:0]
+			for _, bm := range p.benchmarkNames {
+				if keep[benchKey{p.meta.ImportPath, bm.Name}] {
+					names = append(names, bm)
+				}
+			}
+			p.benchmarkNames = names
+		}
+	}
+	return len(keep)
+}
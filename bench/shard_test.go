@@ -0,0 +1,53 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardBenchmarks(t *testing.T) {
+	pkg := func(importPath string, names ...string) Package {
+		p := Package{meta: &packageMeta{ImportPath: importPath}}
+		for _, n := range names {
+			p.benchmarkNames = append(p.benchmarkNames, benchmarkMeta{Name: n})
+		}
+		return p
+	}
+	shards := make([][]string, 2)
+	for index := range shards {
+		b, err := New(nil)
+		require.NoError(t, err)
+		b.basePackages = []Package{
+			pkg("example.com/m/a", "BenchmarkA", "BenchmarkRemoved"),
+			pkg("example.com/m/b", "BenchmarkB"),
+		}
+		b.headPackages = []Package{
+			pkg("example.com/m/b", "BenchmarkB"),
+			pkg("example.com/m/a", "BenchmarkNew", "BenchmarkA"),
+		}
+		require.Equal(t, 2, b.shardBenchmarks(index, len(shards)))
+		for _, x := range b.plannedBenchmarks() {
+			shards[index] = append(shards[index], x.key.packagePath+"."+x.key.benchmark)
+		}
+	}
+
+	// dealt out sorted by package and name, both sides of a benchmark stay together
+	require.Equal(t, []string{
+		"example.com/m/a.BenchmarkA",
+		"example.com/m/a.BenchmarkRemoved",
+	}, shards[0])
+	require.Equal(t, []string{
+		"example.com/m/b.BenchmarkB",
+		"example.com/m/a.BenchmarkNew",
+	}, shards[1])
+}
+
+func TestValidateShard(t *testing.T) {
+	require.NoError(t, validateShard(0, 1))
+	require.NoError(t, validateShard(0, 0))
+	require.NoError(t, validateShard(2, 3))
+	require.EqualError(t, validateShard(3, 3), "--shard-index must be between 0 and 2, got 3")
+	require.EqualError(t, validateShard(-1, 3), "--shard-index must be between 0 and 2, got -1")
+	require.EqualError(t, validateShard(1, 0), "--shard-count must be at least 1, got 0")
+}
@@ -0,0 +1,113 @@
+package bench
+
+import (
+	"math"
+	"slices"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// stabilityUnits maps the results to the unit of the samples measured by the
+// testing package alongside them.
+var stabilityUnits = map[string]string{
+	"cpu":           "sec/op",
+	"alloc_space":   "B/op",
+	"alloc_objects": "allocs/op",
+}
+
+// minStabilitySamples is the number of samples needed to judge their spread.
+const minStabilitySamples = 3
+
+// checkStability marks the results, whose samples of base or head have a
+// coefficient of variation above threshold percent or are bimodal, as
+// unstable.
+func (b *benchWithKey) checkStability(threshold float64) {
+	if threshold <= 0 {
+		return
+	}
+	for idx := range b.results {
+		res := &b.results[idx]
+		res.Instability = nil
+		unit, ok := stabilityUnits[res.Name]
+		if !ok {
+			continue
+		}
+		name := benchmarkRowName(b.key.benchmark, res.GOMAXPROCS)
+		for _, src := range []benchSource{benchSourceBase, benchSourceHead} {
+			var values []float64
+			for _, s := range b.samples {
+				if s.Source == src.String() && s.Unit == unit && s.Config["name"] == name {
+					values = append(values, s.Value)
+				}
+			}
+			if i := sampleInstability(values, threshold); i != nil {
+				i.Source = src.String()
+				i.Unit = unit
+				res.Instability = append(res.Instability, *i)
+			}
+		}
+	}
+}
+
+// sampleInstability returns the spread of the values, when their coefficient
+// of variation exceeds threshold percent or they are bimodal. It returns nil
+// for stable values.
+func sampleInstability(values []float64, threshold float64) *report.Instability {
+	n := len(values)
+	if n < minStabilitySamples {
+		return nil
+	}
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	var mean float64
+	for _, v := range sorted {
+		mean += v
+	}
+	mean /= float64(n)
+	if mean == 0 {
+		return nil
+	}
+	var variance float64
+	for _, v := range sorted {
+		variance += (v - mean) * (v - mean)
+	}
+	cv := math.Sqrt(variance/float64(n-1)) / math.Abs(mean) * 100
+
+	median := sorted[n/2]
+	if n%2 == 0 {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	bimodal := isBimodal(sorted, median, threshold)
+	if cv <= threshold && !bimodal {
+		return nil
+	}
+	return &report.Instability{
+		Samples: n,
+		CV:      cv,
+		Bimodal: bimodal,
+		Min:     sorted[0],
+		Median:  median,
+		Max:     sorted[n-1],
+	}
+}
+
+// isBimodal returns true, when the largest gap between the sorted values
+// splits them into two clusters of at least two values each. The gap needs to
+// be wider than twice the range of either cluster and wider than threshold
+// percent of the median, so noise does not count as second mode.
+func isBimodal(sorted []float64, median, threshold float64) bool {
+	n := len(sorted)
+	var gap float64
+	split := -1
+	for i := 2; i <= n-2; i++ {
+		if g := sorted[i] - sorted[i-1]; g > gap {
+			gap, split = g, i
+		}
+	}
+	if split < 0 {
+		return false
+	}
+	spread := math.Max(sorted[split-1]-sorted[0], sorted[n-1]-sorted[split])
+	return gap > 2*spread && gap > threshold/100*math.Abs(median)
+}
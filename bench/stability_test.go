@@ -0,0 +1,69 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestSampleInstability(t *testing.T) {
+	// stable
+	require.Nil(t, sampleInstability([]float64{100, 101, 99, 100, 102, 100}, 10))
+	// too few samples
+	require.Nil(t, sampleInstability([]float64{100, 200}, 10))
+
+	// noisy
+	i := sampleInstability([]float64{100, 140, 70, 120, 90, 60}, 10)
+	require.NotNil(t, i)
+	require.False(t, i.Bimodal)
+	require.Equal(t, 6, i.Samples)
+	require.InDelta(t, 31.1, i.CV, 0.1)
+	require.Equal(t, 60.0, i.Min)
+	require.Equal(t, 95.0, i.Median)
+	require.Equal(t, 140.0, i.Max)
+
+	// two modes with a small coefficient of variation
+	i = sampleInstability([]float64{100, 100.5, 101, 112, 112.5, 113}, 10)
+	require.NotNil(t, i)
+	require.True(t, i.Bimodal)
+	require.Less(t, i.CV, 10.0)
+
+	// a gap within the threshold is noise
+	require.Nil(t, sampleInstability([]float64{100, 100.1, 101, 101.1}, 10))
+}
+
+func TestCheckStability(t *testing.T) {
+	sample := func(src benchSource, name string, v float64) report.Sample {
+		return report.Sample{Source: src.String(), Unit: "sec/op", Value: v, Config: map[string]string{"name": name}}
+	}
+	r := &benchWithKey{key: benchKey{"example.com/m/a", "BenchmarkA"}, bench: &bench{}}
+	r.results = []report.BenchmarkResult{
+		{Name: "cpu"},
+		{Name: "cpu", GOMAXPROCS: 4},
+		{Name: "inuse_space"},
+	}
+	for _, v := range []float64{1, 1.01, 0.99} {
+		r.samples = append(r.samples,
+			sample(benchSourceBase, "BenchmarkA", v),
+			sample(benchSourceHead, "BenchmarkA", v),
+			sample(benchSourceBase, "BenchmarkA-4", v),
+			sample(benchSourceHead, "BenchmarkA-4", v*v*v),
+		)
+	}
+
+	r.checkStability(0.5)
+	require.Len(t, r.results[0].Instability, 2)
+	require.Len(t, r.results[1].Instability, 2)
+	require.Empty(t, r.results[2].Instability)
+
+	r.checkStability(2)
+	require.False(t, r.results[0].Unstable())
+	require.Len(t, r.results[1].Instability, 1)
+	require.Equal(t, "head", r.results[1].Instability[0].Source)
+	require.Equal(t, "sec/op", r.results[1].Instability[0].Unit)
+
+	r.checkStability(0)
+	require.True(t, r.results[1].Unstable(), "disabled analysis keeps the results")
+}
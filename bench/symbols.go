@@ -0,0 +1,157 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// parseNM sums up the functions of a package from the output of
+// 'go tool nm -size'. Only text symbols with the package's prefix are
+// counted, so generic functions instantiated from other packages are
+// attributed to the package declaring them.
+func parseNM(r io.Reader, prefix string) (*report.CodeSize, error) {
+	prefix += "."
+	var c report.CodeSize
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		// address size type name, undefined symbols lack the address
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || (fields[2] != "T" && fields[2] != "t") {
+			continue
+		}
+		name, ok := strings.CutPrefix(fields[3], prefix)
+		if !ok {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size of symbol %s: %w", fields[3], err)
+		}
+		c.TextSize += size
+		c.Functions++
+		if isExportedSymbol(name) {
+			c.Exported++
+		}
+	}
+	return &c, scanner.Err()
+}
+
+// isExportedSymbol returns true, if the symbol name without the package
+// prefix refers to an exported function or a method of an exported type.
+// Closures like F.func1 are not exported.
+func isExportedSymbol(name string) bool {
+	// drop type arguments, they might contain dots
+	var b strings.Builder
+	var depth int
+	for _, r := range name {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']':
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+
+	for _, segment := range strings.Split(b.String(), ".") {
+		segment = strings.TrimLeft(segment, "(*")
+		segment = strings.TrimRight(segment, ")")
+		r := []rune(segment)
+		if len(r) == 0 || !unicode.IsUpper(r[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// codeSize returns the functions the package contributes to its test binary.
+func (p *Package) codeSize(ctx context.Context) (*report.CodeSize, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "go", "tool", "nm", "-size", p.testBinary)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error running go tool nm: %w: %s", err, stderr.String())
+	}
+	return parseNM(&stdout, p.symbolPrefix())
+}
+
+// compareCodeSize compares the code size of the packages compiled on both
+// sides. Packages with identical test binaries are skipped, the remaining are
+// sorted by the change of their text size, largest first.
+func (b *Benchmark) compareCodeSize(ctx context.Context) ([]report.PackageCodeSize, error) {
+	sizes := make(map[string]*report.PackageCodeSize)
+	for _, x := range []struct {
+		pkgs []Package
+		head bool
+	}{
+		{b.basePackages, false},
+		{b.headPackages, true},
+	} {
+		for idx := range x.pkgs {
+			p := &x.pkgs[idx]
+			if p.testBinary == "" {
+				continue
+			}
+			c, err := p.codeSize(ctx)
+			if err != nil {
+				return nil, err
+			}
+			s, ok := sizes[p.meta.ImportPath]
+			if !ok {
+				s = &report.PackageCodeSize{ImportPath: p.meta.ImportPath}
+				sizes[p.meta.ImportPath] = s
+			}
+			if x.head {
+				s.Head = c
+			} else {
+				s.Base = c
+			}
+		}
+	}
+
+	var result []report.PackageCodeSize
+	for _, s := range sizes {
+		if s.Base != nil && s.Head != nil && *s.Base == *s.Head {
+			continue
+		}
+		result = append(result, *s)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		di, dj := textSizeDiff(&result[i]), textSizeDiff(&result[j])
+		if di != dj {
+			return di > dj
+		}
+		return result[i].ImportPath < result[j].ImportPath
+	})
+	level.Debug(b.logger).Log("msg", "compared code size", "changed_packages", len(result))
+	return result, nil
+}
+
+// textSizeDiff returns the absolute change of the text size in bytes.
+func textSizeDiff(s *report.PackageCodeSize) int64 {
+	var d int64
+	if s.Base != nil {
+		d -= s.Base.TextSize
+	}
+	if s.Head != nil {
+		d += s.Head.TextSize
+	}
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,50 @@
+package bench
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestParseNM(t *testing.T) {
+	out := `  74df80       1625 T example.com/foo.Plain
+  74e5e0       5733 T example.com/foo.(*T).Method
+  74fc60        197 T example.com/foo.median
+  752360         70 t example.com/foo.Plain.func1
+  752420        120 T example.com/foo.Map[go.shape.int,go.shape.string]
+  752480        244 T example.com/foo.(*List[go.shape.int]).Push
+  7524f0         80 T example.com/foo.(*list).Len
+  752580        148 T example.com/foobar.Other
+  8a0000         32 D example.com/foo.Global
+  8a0020         16 R example.com/foo..stmp_0
+                    U example.com/foo.Undefined
+`
+	c, err := parseNM(strings.NewReader(out), "example.com/foo")
+	require.NoError(t, err)
+	require.Equal(t, &report.CodeSize{
+		TextSize:  1625 + 5733 + 197 + 70 + 120 + 244 + 80,
+		Functions: 7,
+		Exported:  4,
+	}, c)
+}
+
+func TestIsExportedSymbol(t *testing.T) {
+	for name, exported := range map[string]bool{
+		"Plain":                             true,
+		"plain":                             false,
+		"Plain.func1":                       false,
+		"T.Method":                          true,
+		"(*T).Method":                       true,
+		"(*T).method":                       false,
+		"(*t).Method":                       false,
+		"Map[go.shape.int,go.shape.string]": true,
+		"(*List[go.shape.int]).Push":        true,
+		"init":                              false,
+		"init.0":                            false,
+	} {
+		require.Equal(t, exported, isExportedSymbol(name), name)
+	}
+}
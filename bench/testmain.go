@@ -0,0 +1,123 @@
+package bench
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// benchmarkWindow observes the output of a test binary to find out when its
+// benchmarks started and finished. Everything before is spent in the setup
+// of TestMain, everything after in its teardown.
+type benchmarkWindow struct {
+	w   io.Writer
+	now func() time.Time
+
+	mu         sync.Mutex
+	line       []byte // incomplete line of the last write
+	processed  time.Time
+	start, end time.Time
+}
+
+func newBenchmarkWindow(w io.Writer) *benchmarkWindow {
+	return &benchmarkWindow{w: w, now: time.Now}
+}
+
+// benchmarkHeaders are printed by the testing package right before the first
+// benchmark runs.
+var benchmarkHeaders = [][]byte{
+	[]byte("goos:"),
+	[]byte("goarch:"),
+	[]byte("pkg:"),
+	[]byte("cpu:"),
+	[]byte("Benchmark"),
+}
+
+func (bw *benchmarkWindow) Write(p []byte) (int, error) {
+	bw.mu.Lock()
+	now := bw.now()
+	bw.line = append(bw.line, p...)
+	for {
+		idx := bytes.IndexByte(bw.line, '\n')
+		if idx < 0 {
+			break
+		}
+		bw.observe(bytes.TrimSpace(bw.line[:idx]), now)
+		bw.line = bw.line[idx+1:]
+	}
+	bw.mu.Unlock()
+	return bw.w.Write(p)
+}
+
+func (bw *benchmarkWindow) observe(line []byte, now time.Time) {
+	if bw.start.IsZero() {
+		for _, h := range benchmarkHeaders {
+			if bytes.HasPrefix(line, h) {
+				bw.start = now
+				return
+			}
+		}
+		return
+	}
+	// m.Run prints the verdict after the last benchmark finished
+	if bytes.Equal(line, []byte("PASS")) || bytes.Equal(line, []byte("FAIL")) {
+		bw.end = now
+	}
+}
+
+// active returns true while the benchmarks are running.
+func (bw *benchmarkWindow) active() bool {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	return !bw.start.IsZero() && bw.end.IsZero()
+}
+
+// testMainTiming is the time a test binary spent outside of its benchmarks.
+type testMainTiming struct {
+	Setup    time.Duration // from process start until the first benchmark
+	Teardown time.Duration // from the last benchmark until the process exit, zero if unknown
+}
+
+// timing returns how long the setup and teardown took for a process which
+// started and exited at the given times. It returns false, when no
+// benchmark output has been observed.
+func (bw *benchmarkWindow) timing(started, exited time.Time) (testMainTiming, bool) {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+	if bw.start.IsZero() {
+		return testMainTiming{}, false
+	}
+	t := testMainTiming{Setup: bw.start.Sub(started)}
+	if !bw.end.IsZero() {
+		t.Teardown = exited.Sub(bw.end)
+	}
+	return t, true
+}
+
+func (t *testMainTiming) report(source benchSource) report.TestMainTiming {
+	return report.TestMainTiming{
+		Source:   source.String(),
+		Setup:    t.Setup,
+		Teardown: t.Teardown,
+	}
+}
+
+// setTestMainTiming records the TestMain timing of the latest run of the
+// source.
+func (b *bench) setTestMainTiming(source benchSource, t *testMainTiming) {
+	r := t.report(source)
+	for idx := range b.testMain {
+		if b.testMain[idx].Source == r.Source {
+			b.testMain[idx] = r
+			return
+		}
+	}
+	b.testMain = append(b.testMain, r)
+	sort.Slice(b.testMain, func(i, j int) bool {
+		return b.testMain[i].Source < b.testMain[j].Source
+	})
+}
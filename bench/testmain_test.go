@@ -0,0 +1,49 @@
+package bench
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBenchmarkWindow(t *testing.T) {
+	started := time.Unix(1000, 0)
+	now := started
+	buf := new(bytes.Buffer)
+	bw := newBenchmarkWindow(buf)
+	bw.now = func() time.Time { return now }
+
+	write := func(after time.Duration, s string) {
+		now = now.Add(after)
+		_, err := bw.Write([]byte(s))
+		require.NoError(t, err)
+	}
+
+	write(time.Second, "starting test server\n")
+	require.False(t, bw.active())
+	write(2*time.Second, "goos: linux\ngoarch: amd64\n")
+	require.True(t, bw.active())
+	write(5*time.Second, "BenchmarkA-8   \t 100\t 1000 ns/op\n")
+	// lines might be split across writes
+	write(time.Second, "PA")
+	require.True(t, bw.active())
+	write(0, "SS\n")
+	require.False(t, bw.active())
+
+	timing, ok := bw.timing(started, now.Add(500*time.Millisecond))
+	require.True(t, ok)
+	require.Equal(t, testMainTiming{Setup: 3 * time.Second, Teardown: 500 * time.Millisecond}, timing)
+	require.Equal(t, "starting test server\ngoos: linux\ngoarch: amd64\nBenchmarkA-8   \t 100\t 1000 ns/op\nPASS\n", buf.String())
+}
+
+func TestBenchmarkWindowNoBenchmarks(t *testing.T) {
+	bw := newBenchmarkWindow(new(bytes.Buffer))
+	_, err := bw.Write([]byte("PASS\n"))
+	require.NoError(t, err)
+	require.False(t, bw.active())
+
+	_, ok := bw.timing(time.Now(), time.Now())
+	require.False(t, ok)
+}
@@ -0,0 +1,129 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/go-kit/log/level"
+)
+
+const (
+	toolchainBase   = "base"
+	toolchainHead   = "head"
+	toolchainSystem = "system"
+)
+
+// toolchains are the Go toolchains base and head are built with. Depending on
+// GOTOOLCHAIN the go and toolchain directives of a checkout select a different
+// toolchain than the one installed.
+type toolchains struct {
+	base   string // version used for base, empty without base
+	head   string // version used for head
+	forced string // --toolchain both are built with, empty when selected per checkout
+}
+
+// goVersion returns the version of the toolchain the go command selects in
+// dir.
+func goVersion(ctx context.Context, dir string) (string, error) {
+	out, err := goCommand(ctx, dir, "env", "GOVERSION").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// detectToolchains returns the toolchain versions of the checkouts.
+func (b *Benchmark) detectToolchains(ctx context.Context) (toolchains, error) {
+	var (
+		t   toolchains
+		err error
+	)
+	t.head, err = goVersion(ctx, b.headDir)
+	if err != nil {
+		return t, fmt.Errorf("error detecting the go toolchain of head: %w", err)
+	}
+	if b.baseDir != "" {
+		t.base, err = goVersion(ctx, b.baseDir)
+		if err != nil {
+			return t, fmt.Errorf("error detecting the go toolchain of base: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// gotoolchain returns the GOTOOLCHAIN building both checkouts with the
+// toolchain of mode, system being the installed one.
+func (t toolchains) gotoolchain(mode, system string) (string, error) {
+	var version string
+	switch mode {
+	case toolchainSystem:
+		return "local", nil
+	case toolchainBase:
+		if t.base == "" {
+			return "", errors.New("--toolchain=base requires a base to compare with")
+		}
+		version = t.base
+	case toolchainHead:
+		version = t.head
+	default:
+		return "", fmt.Errorf("invalid --toolchain %q, expected base, head or system", mode)
+	}
+	if version == system {
+		// avoids looking up the installed toolchain again
+		return "local", nil
+	}
+	return version, nil
+}
+
+// toolchainContext detects the toolchains of base and head and, with
+// --toolchain, pins both to the same one by adding GOTOOLCHAIN to the go
+// environment of the context.
+func (b *Benchmark) toolchainContext(ctx context.Context, mode string) (context.Context, error) {
+	t, err := b.detectToolchains(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if mode != "" {
+		system, err := goVersion(addGoEnvToContext(ctx, append(goEnvFromContext(ctx), "GOTOOLCHAIN=local")), "")
+		if err != nil {
+			return nil, fmt.Errorf("error detecting the installed go toolchain: %w", err)
+		}
+		gotoolchain, err := t.gotoolchain(mode, system)
+		if err != nil {
+			return nil, err
+		}
+		ctx = addGoEnvToContext(ctx, append(goEnvFromContext(ctx), "GOTOOLCHAIN="+gotoolchain))
+		// fails when a checkout requires a newer toolchain than the pinned one
+		t, err = b.detectToolchains(ctx)
+		if err != nil {
+			return nil, err
+		}
+		t.forced = mode
+	}
+	b.toolchains = t
+
+	level.Info(b.logger).Log("msg", "detected go toolchains", "base", t.base, "head", t.head, "forced", t.forced)
+	if w := t.warning(); w != "" {
+		level.Warn(b.logger).Log("msg", w)
+	}
+	return ctx, nil
+}
+
+// warning returns a warning when base and head are built with different
+// toolchains, empty otherwise.
+func (t toolchains) warning() string {
+	if t.base == "" || t.base == t.head {
+		return ""
+	}
+	return fmt.Sprintf(
+		"Base is built with %s and head with %s, the results include the changes of the compiler and the runtime. Use --toolchain to build both with the same toolchain.",
+		t.base, t.head,
+	)
+}
@@ -0,0 +1,74 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestToolchains(t *testing.T) {
+	t.Setenv("GOTOOLCHAIN", "auto")
+	ctx := context.Background()
+	system, err := goVersion(ctx, "")
+	require.NoError(t, err)
+
+	b := &Benchmark{logger: log.NewNopLogger(), baseDir: t.TempDir(), headDir: t.TempDir()}
+	for _, dir := range []string{b.baseDir, b.headDir} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.21\n"), 0o644))
+	}
+
+	_, err = b.toolchainContext(ctx, "")
+	require.NoError(t, err)
+	require.Equal(t, toolchains{base: system, head: system}, b.toolchains)
+
+	ctx, err = b.toolchainContext(ctx, toolchainSystem)
+	require.NoError(t, err)
+	require.Equal(t, toolchains{base: system, head: system, forced: toolchainSystem}, b.toolchains)
+	gotoolchain, ok := goEnvValue(goEnvFromContext(ctx), "GOTOOLCHAIN")
+	require.True(t, ok)
+	require.Equal(t, "local", gotoolchain)
+
+	// a checkout requiring a newer toolchain than the pinned one fails
+	require.NoError(t, os.WriteFile(filepath.Join(b.headDir, "go.mod"), []byte("module example.com/m\n\ngo 1.999\n"), 0o644))
+	_, err = b.toolchainContext(context.Background(), toolchainSystem)
+	require.ErrorContains(t, err, "error detecting the go toolchain of head")
+
+	tc := toolchains{base: "go1.21.13", head: "go1.22.5"}
+	for _, tt := range []struct {
+		mode     string
+		expected string
+		err      string
+	}{
+		{mode: toolchainBase, expected: "go1.21.13"},
+		{mode: toolchainHead, expected: "local"},
+		{mode: toolchainSystem, expected: "local"},
+		{mode: "newest", err: `invalid --toolchain "newest", expected base, head or system`},
+	} {
+		gotoolchain, err := tc.gotoolchain(tt.mode, "go1.22.5")
+		if tt.err != "" {
+			require.EqualError(t, err, tt.err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tt.expected, gotoolchain, tt.mode)
+	}
+	_, err = toolchains{head: "go1.22.5"}.gotoolchain(toolchainBase, "go1.22.5")
+	require.EqualError(t, err, "--toolchain=base requires a base to compare with")
+
+	require.Empty(t, toolchains{head: "go1.22.5"}.warning())
+	require.Empty(t, toolchains{base: "go1.22.5", head: "go1.22.5"}.warning())
+	b.toolchains = tc
+	env := b.reportEnvironment(&report.Environment{OS: "linux"})
+	require.Equal(t, "base go1.21.13, head go1.22.5", env.ToolchainsString())
+	require.Equal(t, []string{tc.warning()}, env.Issues)
+
+	env = (&Benchmark{toolchains: toolchains{base: "go1.22.5", head: "go1.22.5", forced: toolchainBase}}).reportEnvironment(&report.Environment{})
+	require.Equal(t, "go1.22.5 (--toolchain=base)", env.ToolchainsString())
+	require.Empty(t, env.Issues)
+}
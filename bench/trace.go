@@ -0,0 +1,125 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func addTraceArgs(cmd *kingpin.CmdClause, traceRegressions *bool, artifactsURL *string) {
+	cmd.Flag("trace-regressions", "Re-run benchmarks regressing beyond the threshold once more per side with -test.trace and store the execution traces in --artifacts-dir.").Default("false").BoolVar(traceRegressions)
+	cmd.Flag("artifacts-url", "URL the artifacts directory is published at, used to link the artifacts from the report.").PlaceHolder("URL").StringVar(artifactsURL)
+}
+
+// traceBenchmark runs the benchmark a single time, writing its execution
+// trace to path. It runs with the same options and package setup as the
+// measured runs, but no profiles are recorded, as they would distort the
+// trace.
+func (p *Package) traceBenchmark(ctx context.Context, opts runOptions, benchName, path string) error {
+	if err := p.runSetup(ctx); err != nil {
+		return err
+	}
+
+	outDir, err := os.MkdirTemp("", "pyrotest-trace")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+
+	stderr := new(bytes.Buffer)
+	trace := filepath.Join(outDir, "trace.out")
+	cmd := p.newBenchCommand(opts, benchName, 1, "-test.trace", trace)
+	cmd.outDir = outDir
+	cmd.stdout = io.Discard
+	cmd.stderr = stderr
+	cmd.active = func() bool { return false }
+
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	if _, err := executorFromContext(ctx).run(ctx, p, cmd); err != nil {
+		return fmt.Errorf("failed to trace benchmark %v stdErr=%s : %w", cmd.args, stderr.String(), err)
+	}
+
+	// the output directory might be on another file system
+	src, err := os.Open(trace)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// artifactURL returns the URL of an artifact, when the artifacts directory is
+// published.
+func artifactURL(artifactsURL, artifactsDir, path string) (string, error) {
+	if artifactsURL == "" {
+		return "", nil
+	}
+	rel, err := filepath.Rel(artifactsDir, path)
+	if err != nil {
+		return "", err
+	}
+	return url.JoinPath(artifactsURL, strings.Split(filepath.ToSlash(rel), "/")...)
+}
+
+// traceRegression captures execution traces of base and head, when the
+// benchmark regressed beyond the threshold. Errors are only logged, the
+// traces are merely an aid to investigate the regression.
+func (b *Benchmark) traceRegression(ctx context.Context, r *benchWithKey, opts runOptions, threshold float64, artifactsDir, artifactsURL string) {
+	run := report.BenchmarkRun{Results: r.results}
+	if len(run.Regressions(threshold)) == 0 {
+		return
+	}
+
+	logger := log.With(b.logger, "package", r.key.packagePath, "benchmark", r.key.benchmark)
+	level.Info(logger).Log("msg", "benchmark regressed, capturing execution traces")
+	for _, x := range []struct {
+		src benchSource
+		p   *Package
+	}{
+		{benchSourceBase, r.base},
+		{benchSourceHead, r.head},
+	} {
+		if x.p == nil {
+			continue
+		}
+		path, err := artifactPath(artifactsDir, r.key, "trace-"+x.src.String()+".out")
+		if err != nil {
+			level.Warn(logger).Log("msg", "error creating artifact directory", "err", err)
+			return
+		}
+		if err := x.p.traceBenchmark(ctx, opts, r.key.benchmark, path); err != nil {
+			level.Warn(logger).Log("msg", "error capturing execution trace", "source", x.src, "err", err)
+			continue
+		}
+		u, err := artifactURL(artifactsURL, artifactsDir, path)
+		if err != nil {
+			level.Warn(logger).Log("msg", "error building artifact URL", "err", err)
+		}
+		level.Debug(logger).Log("msg", "wrote execution trace", "source", x.src, "path", path)
+		r.traces = append(r.traces, report.Trace{Source: x.src.String(), Path: path, URL: u})
+	}
+}
@@ -0,0 +1,82 @@
+package bench
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestTraceRegression(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/m\n\ngo 1.22\n"), 0o644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "slow"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "slow", "slow.go"), []byte("package slow\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "slow", "slow_test.go"), []byte(`package slow
+
+import (
+	"os"
+	"testing"
+)
+
+func BenchmarkSlow(b *testing.B) {
+	if os.Getenv("SLOW_SETUP") != "1" {
+		b.Fatal("missing environment of the package hooks")
+	}
+	for i := 0; i < b.N; i++ {
+		_ = make([]byte, 1024)
+	}
+}
+`), 0o644))
+
+	cleaner := &cleaner{}
+	ctx := addCleanupToContext(context.Background(), cleaner.add)
+	t.Cleanup(func() {
+		require.NoError(t, cleaner.cleanup())
+	})
+
+	pkgs, err := discoverPackages(ctx, log.NewNopLogger(), dir, []string{"./..."}, nil)
+	require.NoError(t, err)
+	require.Len(t, pkgs, 1)
+	p := &pkgs[0]
+	require.NoError(t, p.compileTest(ctx))
+	p.hooks = &packageHooks{env: []string{"SLOW_SETUP=1"}}
+
+	b := &Benchmark{logger: log.NewNopLogger()}
+	r := &benchWithKey{
+		key: benchKey{packagePath: "example.com/m/slow", benchmark: "BenchmarkSlow"},
+		bench: &bench{
+			base: p,
+			head: p,
+			results: []report.BenchmarkResult{{
+				Name:      "cpu",
+				BaseValue: report.BenchmarkValue{ProfileValue: 100, FlamegraphKey: "base"},
+				HeadValue: report.BenchmarkValue{ProfileValue: 104, FlamegraphKey: "head"},
+			}},
+		},
+	}
+	opts := runOptions{benchTime: "10x"}
+	artifacts := t.TempDir()
+
+	// below the threshold
+	b.traceRegression(ctx, r, opts, 5, artifacts, "")
+	require.Empty(t, r.traces)
+
+	b.traceRegression(ctx, r, opts, 2, artifacts, "https://example.com/run 1/")
+	require.Len(t, r.traces, 2)
+	require.True(t, p.hooks.done, "setup must run before tracing")
+	for idx, src := range []string{"base", "head"} {
+		tr := r.traces[idx]
+		require.Equal(t, src, tr.Source)
+		require.Equal(t, filepath.Join(artifacts, "example.com", "m", "slow", "BenchmarkSlow", "trace-"+src+".out"), tr.Path)
+		require.Equal(t, "https://example.com/run%201/example.com/m/slow/BenchmarkSlow/trace-"+src+".out", tr.URL)
+		stat, err := os.Stat(tr.Path)
+		require.NoError(t, err)
+		require.NotZero(t, stat.Size())
+	}
+}
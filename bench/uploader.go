@@ -0,0 +1,334 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// profileUploader stores a profile and returns where it can be found.
+type profileUploader interface {
+	upload(ctx context.Context, logger log.Logger, body io.Reader) (*profileResponse, error)
+}
+
+type uploaderFunc func(ctx context.Context, logger log.Logger, body io.Reader) (*profileResponse, error)
+
+func (f uploaderFunc) upload(ctx context.Context, logger log.Logger, body io.Reader) (*profileResponse, error) {
+	return f(ctx, logger, body)
+}
+
+func addUploaderToContext(ctx context.Context, u profileUploader) context.Context {
+	return context.WithValue(ctx, contextKeyUploader, u)
+}
+
+func uploaderFromContext(ctx context.Context) profileUploader {
+	u, ok := ctx.Value(contextKeyUploader).(profileUploader)
+	if !ok {
+		return newFlamegraphUploader("")
+	}
+	return u
+}
+
+// offlineUploader keeps the profiles in a directory instead of uploading
+// them, e.g. without network access. The files are named by the key of the
+// profile, which is derived from its content.
+type offlineUploader struct {
+	dir string
+}
+
+func newOfflineUploader(dir string) (*offlineUploader, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &offlineUploader{dir: dir}, nil
+}
+
+func (u *offlineUploader) upload(ctx context.Context, logger log.Logger, body io.Reader) (*profileResponse, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	key := report.OfflineKeyPrefix + hex.EncodeToString(sum[:8])
+	path := filepath.Join(u.dir, key+spoolRequestExt)
+	if err := writeFileAtomic(path, data); err != nil {
+		return nil, fmt.Errorf("failed to keep profile: %w", err)
+	}
+	level.Debug(logger).Log("msg", "kept profile offline", "path", path)
+	return &profileResponse{Key: key, URL: path}, nil
+}
+
+// The spool directory holds a request file per profile, which the uploader
+// process claims by renaming it, uploads and answers with a response file.
+// Files are written to a hidden temporary name first, so they are never read
+// partially.
+const (
+	spoolRequestExt  = ".pprof"
+	spoolResponseExt = ".json"
+	spoolClaimedExt  = ".uploading"
+
+	// spoolPollInterval is how often the response is checked for.
+	spoolPollInterval = 100 * time.Millisecond
+	// spoolTimeout is how long to wait for the uploader process to respond to
+	// the profiles of a report.
+	spoolTimeout = 5 * time.Minute
+)
+
+// spoolResponse is the content of a response file.
+type spoolResponse struct {
+	profileResponse
+	Error string `json:"error,omitempty"`
+}
+
+// writeFileAtomic writes the file under a hidden temporary name and renames
+// it.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// spoolUploader hands the profiles to a separate uploader process, so the
+// benchmarks never wait on the network themselves. Profiles are only queued
+// and get a pending key, which is resolved into the key of the upload once
+// the report is finalized.
+type spoolUploader struct {
+	dir string
+
+	mtx       sync.Mutex
+	responses map[string]*spoolResponse // by request, once read
+}
+
+func (u *spoolUploader) upload(ctx context.Context, logger log.Logger, body io.Reader) (*profileResponse, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	name := hex.EncodeToString(id)
+	if err := writeFileAtomic(filepath.Join(u.dir, name+spoolRequestExt), data); err != nil {
+		return nil, fmt.Errorf("failed to queue profile: %w", err)
+	}
+	level.Debug(logger).Log("msg", "queued profile", "request", name)
+	return &profileResponse{Key: report.PendingKeyPrefix + name}, nil
+}
+
+// resolve returns the key of the upload of a pending key, which might name
+// the sample type of a sub-profile. Other keys are returned as they are.
+func (u *spoolUploader) resolve(ctx context.Context, key string) (string, error) {
+	pending, ok := strings.CutPrefix(key, report.PendingKeyPrefix)
+	if !ok {
+		return key, nil
+	}
+	name, sampleType, sub := strings.Cut(pending, "/")
+	resp, err := u.response(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if !sub {
+		return resp.Key, nil
+	}
+	return resp.subProfileKey(sampleType), nil
+}
+
+// response waits for the response of the uploader process to the request
+// until ctx is done, a done ctx only takes an existing response.
+func (u *spoolUploader) response(ctx context.Context, name string) (*profileResponse, error) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	resp, ok := u.responses[name]
+	if !ok {
+		var err error
+		resp, err = u.awaitResponse(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if u.responses == nil {
+			u.responses = make(map[string]*spoolResponse)
+		}
+		u.responses[name] = resp
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("uploader failed: %s", resp.Error)
+	}
+	return &resp.profileResponse, nil
+}
+
+func (u *spoolUploader) awaitResponse(ctx context.Context, name string) (*spoolResponse, error) {
+	responsePath := filepath.Join(u.dir, name+spoolResponseExt)
+	ticker := time.NewTicker(spoolPollInterval)
+	defer ticker.Stop()
+	for {
+		data, err := os.ReadFile(responsePath)
+		if err == nil {
+			_ = os.Remove(responsePath)
+			var resp spoolResponse
+			if err := json.Unmarshal(data, &resp); err != nil {
+				return nil, fmt.Errorf("invalid response of uploader: %w", err)
+			}
+			return &resp, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			// an unclaimed request is of no use anymore
+			_ = os.Remove(filepath.Join(u.dir, name+spoolRequestExt))
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, fmt.Errorf("no response of an uploader within %s, is `pyrobench uploader --queue-dir %s` running?", spoolTimeout, u.dir)
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// resolvePendingKeys replaces the pending keys of the report by the keys of
+// the uploads of the separate uploader process. Keys without a response stay
+// pending and are not linked. It returns whether any key has been replaced.
+func (b *Benchmark) resolvePendingKeys(ctx context.Context, rpt *report.BenchmarkReport) bool {
+	u, ok := uploaderFromContext(ctx).(*spoolUploader)
+	if !ok {
+		return false
+	}
+	resolveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), spoolTimeout)
+	defer cancel()
+	if ctx.Err() != nil {
+		// interrupted, only take the responses already there
+		cancel()
+	}
+
+	var (
+		resolved bool
+		failed   error
+	)
+	rpt.MapFlamegraphKeys(func(key string) string {
+		k, err := u.resolve(resolveCtx, key)
+		if err != nil {
+			failed = err
+			return key
+		}
+		resolved = resolved || k != key
+		return k
+	})
+	if failed != nil {
+		level.Warn(b.logger).Log("msg", "not all queued profiles have been uploaded, they are not linked", "err", failed)
+	}
+	return resolved
+}
+
+type UploaderArgs struct {
+	QueueDir      string
+	Interval      time.Duration
+	FlamegraphURL string
+}
+
+func AddUploaderCommand(app *kingpin.Application) (*kingpin.CmdClause, *UploaderArgs) {
+	cmd := app.Command("uploader", "Upload the profiles queued by benchmarks run with --upload-queue-dir, e.g. in a sidecar allowed to reach the network.")
+	args := &UploaderArgs{}
+	cmd.Flag("queue-dir", "Spool directory shared with the benchmarking process.").Required().ExistingDirVar(&args.QueueDir)
+	cmd.Flag("interval", "How often to look for new profiles.").Default("200ms").DurationVar(&args.Interval)
+	addFlamegraphURLArg(cmd, &args.FlamegraphURL)
+	return cmd, args
+}
+
+// Uploader uploads queued profiles until the context is canceled.
+func (b *Benchmark) Uploader(ctx context.Context, args *UploaderArgs) error {
+	if args.Interval <= 0 {
+		return fmt.Errorf("invalid poll interval %s", args.Interval)
+	}
+	level.Info(b.logger).Log("msg", "waiting for profiles to upload", "queue-dir", args.QueueDir)
+	u := newFlamegraphUploader(args.FlamegraphURL)
+	ticker := time.NewTicker(args.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := processSpool(ctx, b.logger, args.QueueDir, u); err != nil {
+			level.Error(b.logger).Log("msg", "error processing queue", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// processSpool uploads all queued profiles and answers them. It returns the
+// number of processed requests.
+func processSpool(ctx context.Context, logger log.Logger, dir string, u profileUploader) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasSuffix(name, spoolRequestExt) && !strings.HasPrefix(name, ".") {
+			names = append(names, strings.TrimSuffix(name, spoolRequestExt))
+		}
+	}
+	sort.Strings(names)
+
+	var processed int
+	for _, name := range names {
+		if ctx.Err() != nil {
+			break
+		}
+		// claiming fails, when another uploader was faster or the request
+		// has been withdrawn
+		claimed := filepath.Join(dir, "."+name+spoolClaimedExt)
+		if err := os.Rename(filepath.Join(dir, name+spoolRequestExt), claimed); err != nil {
+			continue
+		}
+		processed++
+
+		data, err := os.ReadFile(claimed)
+		var resp spoolResponse
+		if err == nil {
+			var res *profileResponse
+			res, err = u.upload(ctx, logger, bytes.NewReader(data))
+			if res != nil {
+				resp.profileResponse = *res
+			}
+		}
+		if err != nil {
+			level.Warn(logger).Log("msg", "failed to upload profile", "request", name, "err", err)
+			resp.Error = err.Error()
+		}
+		out, err := json.Marshal(resp)
+		if err != nil {
+			return processed, err
+		}
+		if err := writeFileAtomic(filepath.Join(dir, name+spoolResponseExt), out); err != nil {
+			return processed, err
+		}
+		_ = os.Remove(claimed)
+	}
+	return processed, nil
+}
@@ -0,0 +1,185 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestSpoolUploader(t *testing.T) {
+	dir := t.TempDir()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var uploaded [][]byte
+	fake := uploaderFunc(func(_ context.Context, _ log.Logger, body io.Reader) (*profileResponse, error) {
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		if string(data) == "broken" {
+			return nil, errors.New("status 500")
+		}
+		uploaded = append(uploaded, data)
+		return &profileResponse{Key: "key-" + string(data), URL: "https://flamegraph.com/share/key-" + string(data)}, nil
+	})
+
+	// the uploader process
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			if _, err := processSpool(ctx, log.NewNopLogger(), dir, fake); err != nil {
+				t.Error(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	// queuing does not wait for the uploader
+	u := &spoolUploader{dir: dir}
+	res, err := u.upload(ctx, log.NewNopLogger(), bytes.NewReader([]byte("a")))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(res.Key, report.PendingKeyPrefix), res.Key)
+	require.Equal(t, res.Key+"/cpu", res.subProfileKey("cpu"))
+	broken, err := u.upload(ctx, log.NewNopLogger(), bytes.NewReader([]byte("broken")))
+	require.NoError(t, err)
+
+	key, err := u.resolve(ctx, res.Key)
+	require.NoError(t, err)
+	require.Equal(t, "key-a", key)
+	key, err = u.resolve(ctx, res.subProfileKey("cpu"))
+	require.NoError(t, err)
+	require.Equal(t, "key-a", key, "without sub-profiles the profile is linked by its own key")
+	_, err = u.resolve(ctx, broken.Key)
+	require.EqualError(t, err, "uploader failed: status 500")
+	key, err = u.resolve(ctx, "key-b")
+	require.NoError(t, err)
+	require.Equal(t, "key-b", key)
+
+	cancel()
+	<-done
+	require.Equal(t, [][]byte{[]byte("a")}, uploaded)
+
+	// nothing is left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestResolvePendingKeys(t *testing.T) {
+	dir := t.TempDir()
+	u := &spoolUploader{dir: dir}
+	ctx := addUploaderToContext(context.Background(), u)
+	queued, err := u.upload(ctx, log.NewNopLogger(), bytes.NewReader([]byte("a")))
+	require.NoError(t, err)
+	unanswered, err := u.upload(ctx, log.NewNopLogger(), bytes.NewReader([]byte("b")))
+	require.NoError(t, err)
+
+	// the uploader answered the first request only
+	n, err := processSpool(ctx, log.NewNopLogger(), dir, uploaderFunc(func(_ context.Context, _ log.Logger, body io.Reader) (*profileResponse, error) {
+		data, _ := io.ReadAll(body)
+		if string(data) == "b" {
+			return nil, errors.New("unreachable")
+		}
+		return &profileResponse{Key: "key-a", SubProfiles: []struct {
+			Key  string `json:"key"`
+			Name string `json:"name"`
+		}{{Key: "key-a-cpu", Name: "cpu"}}}, nil
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	sent := &report.BenchmarkReport{Runs: []report.BenchmarkRun{{Results: []report.BenchmarkResult{{
+		BaseValue: report.BenchmarkValue{FlamegraphKey: queued.subProfileKey("cpu")},
+		HeadValue: report.BenchmarkValue{FlamegraphKey: unanswered.subProfileKey("cpu")},
+	}}}}}
+	rpt := sent.Copy()
+	b := &Benchmark{logger: log.NewNopLogger()}
+	require.True(t, b.resolvePendingKeys(ctx, rpt))
+	res := rpt.Runs[0].Results[0]
+	require.Equal(t, "key-a-cpu", res.BaseValue.FlamegraphKey)
+	require.Equal(t, unanswered.subProfileKey("cpu"), res.HeadValue.FlamegraphKey, "failed uploads stay pending")
+	require.Empty(t, res.DiffFlamegraphURL())
+	require.Equal(t, queued.subProfileKey("cpu"), sent.Runs[0].Results[0].BaseValue.FlamegraphKey, "reports sent before are left alone")
+
+	require.False(t, (&Benchmark{logger: log.NewNopLogger()}).resolvePendingKeys(context.Background(), rpt), "only the spool has pending keys")
+}
+
+func TestProcessSpoolIgnoresPartialFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".abc.pprof.tmp"), []byte("partial"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "abc.json"), []byte("{}"), 0o600))
+
+	n, err := processSpool(context.Background(), log.NewNopLogger(), dir, uploaderFunc(func(context.Context, log.Logger, io.Reader) (*profileResponse, error) {
+		t.Fatal("unexpected upload")
+		return nil, nil
+	}))
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+}
+
+func TestUploaderFromContext(t *testing.T) {
+	ctx := context.Background()
+	require.NotNil(t, uploaderFromContext(ctx))
+
+	u := &spoolUploader{dir: t.TempDir()}
+	require.Same(t, u, uploaderFromContext(addUploaderToContext(ctx, u)))
+}
+
+func TestFlamegraphUploader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "application/octet-stream", r.Header.Get("content-type"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		if string(body) == "invalid" {
+			http.Error(w, "invalid profile", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"key":"key","url":"https://flamegraph.com/share/key"}`))
+	}))
+	defer srv.Close()
+
+	u := newFlamegraphUploader(srv.URL)
+	res, err := u.upload(context.Background(), log.NewNopLogger(), bytes.NewReader([]byte("profile")))
+	require.NoError(t, err)
+	require.Equal(t, "key", res.Key)
+
+	_, err = u.upload(context.Background(), log.NewNopLogger(), bytes.NewReader([]byte("invalid")))
+	require.ErrorContains(t, err, "[400]")
+
+	require.Equal(t, defaultFlamegraphURL, newFlamegraphUploader("").url)
+}
+
+func TestOfflineUploader(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "profiles")
+	u, err := newOfflineUploader(dir)
+	require.NoError(t, err)
+
+	res, err := u.upload(context.Background(), log.NewNopLogger(), bytes.NewReader([]byte("profile")))
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(res.Key, report.OfflineKeyPrefix), res.Key)
+	data, err := os.ReadFile(res.URL)
+	require.NoError(t, err)
+	require.Equal(t, "profile", string(data))
+	require.Equal(t, filepath.Join(dir, res.Key+".pprof"), res.URL)
+
+	// the key is derived from the content
+	again, err := u.upload(context.Background(), log.NewNopLogger(), bytes.NewReader([]byte("profile")))
+	require.NoError(t, err)
+	require.Equal(t, res.Key, again.Key)
+}
@@ -0,0 +1,202 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+const (
+	// uploadAttempts is how often an upload is tried before the run fails.
+	uploadAttempts = 3
+	// uploadBackoff is the wait before the first retry, it doubles with every
+	// further attempt.
+	uploadBackoff = time.Second
+)
+
+func addUploadConcurrencyArg(cmd *kingpin.CmdClause, concurrency *int) {
+	cmd.Flag("upload-concurrency", "Number of profiles uploaded in parallel, while the next benchmarks already run. Failed uploads are retried. 0 uploads the profiles one by one before running the next benchmark.").Default("4").IntVar(concurrency)
+}
+
+// uploadPool uploads profiles in the background with a bounded number of
+// workers, so the next run does not wait for the uploads of the previous one.
+type uploadPool struct {
+	sem     chan struct{}
+	wg      sync.WaitGroup
+	backoff time.Duration
+}
+
+func newUploadPool(concurrency int) *uploadPool {
+	return &uploadPool{
+		sem:     make(chan struct{}, concurrency),
+		backoff: uploadBackoff,
+	}
+}
+
+func addUploadPoolToContext(ctx context.Context, p *uploadPool) context.Context {
+	return context.WithValue(ctx, contextKeyUploadPool, p)
+}
+
+// uploadPoolFromContext returns the pool, nil when profiles are uploaded
+// synchronously.
+func uploadPoolFromContext(ctx context.Context) *uploadPool {
+	p, _ := ctx.Value(contextKeyUploadPool).(*uploadPool)
+	return p
+}
+
+// wait blocks until all uploads have finished.
+func (p *uploadPool) wait() {
+	if p == nil {
+		return
+	}
+	p.wg.Wait()
+}
+
+// upload uploads the profile with retries. With a nil pool it uploads
+// synchronously, otherwise on a worker of the pool. done is called with the
+// outcome by the worker.
+func (p *uploadPool) upload(ctx context.Context, logger log.Logger, data []byte, done func(*profileResponse, error)) {
+	if p == nil {
+		done(uploadWithRetries(ctx, logger, data, uploadBackoff))
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Done():
+			done(nil, ctx.Err())
+			return
+		}
+		defer func() { <-p.sem }()
+		done(uploadWithRetries(ctx, logger, data, p.backoff))
+	}()
+}
+
+// uploadWithRetries uploads the profile, retrying failed attempts with an
+// exponential backoff.
+func uploadWithRetries(ctx context.Context, logger log.Logger, data []byte, backoff time.Duration) (*profileResponse, error) {
+	var err error
+	for attempt := 1; ; attempt++ {
+		var res *profileResponse
+		res, err = uploadProfile(ctx, logger, bytes.NewReader(data))
+		if err == nil {
+			return res, nil
+		}
+		if attempt == uploadAttempts {
+			break
+		}
+		level.Debug(logger).Log("msg", "retrying failed upload", "attempt", attempt, "err", err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, err
+		}
+		backoff *= 2
+	}
+	return nil, fmt.Errorf("upload failed after %d attempts: %w", uploadAttempts, err)
+}
+
+// uploadBatch collects the uploads of the profiles of a single run. The
+// outcomes are applied to the result by wait, in the goroutine waiting for
+// them, so the result is never modified concurrently.
+type uploadBatch struct {
+	wg    sync.WaitGroup
+	mtx   sync.Mutex
+	err   error
+	apply []func() // by upload, in the order they were started
+
+	after []func() // called once the outcomes have been applied
+}
+
+// add uploads the profile on the pool. uploaded is called with the outcome by
+// the worker, apply is stored to be called by wait with the response of a
+// successful upload.
+func (b *uploadBatch) add(ctx context.Context, pool *uploadPool, logger log.Logger, data []byte, uploaded func(*profileResponse, error), apply func(*profileResponse)) {
+	b.mtx.Lock()
+	idx := len(b.apply)
+	b.apply = append(b.apply, nil)
+	b.mtx.Unlock()
+
+	b.wg.Add(1)
+	pool.upload(ctx, logger, data, func(res *profileResponse, err error) {
+		defer b.wg.Done()
+		uploaded(res, err)
+		b.mtx.Lock()
+		defer b.mtx.Unlock()
+		if err != nil {
+			if b.err == nil {
+				b.err = err
+			}
+			return
+		}
+		b.apply[idx] = func() { apply(res) }
+	})
+}
+
+// wait blocks until all uploads have finished, applies their responses and
+// returns the first error.
+func (b *uploadBatch) wait() error {
+	b.wg.Wait()
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	for _, f := range b.apply {
+		f()
+	}
+	for _, f := range b.after {
+		f()
+	}
+	return nil
+}
+
+// awaitUploads waits for the profiles of the run to be uploaded. Afterwards
+// the flamegraph keys and the metrics of the result are complete.
+func (r *benchmarkResult) awaitUploads() error {
+	if r.uploads == nil {
+		return nil
+	}
+	err := r.uploads.wait()
+	r.uploads = nil
+	return err
+}
+
+// afterUploads calls f once the profiles of the run have been uploaded, right
+// away when there are no pending uploads. f is not called, when an upload
+// failed.
+func (r *benchmarkResult) afterUploads(f func()) {
+	if r.uploads == nil {
+		f()
+		return
+	}
+	r.uploads.after = append(r.uploads.after, f)
+}
+
+// awaitUploads waits for the pending uploads of the runs of the benchmark and
+// adds the profile results of the runs, whose uploads succeeded.
+func (b *Benchmark) awaitUploads(r *benchWithKey) {
+	for _, p := range r.pendingUploads {
+		if err := p.res.awaitUploads(); err != nil {
+			level.Error(b.logger).Log("msg", "error uploading profiles", "package", r.key.packagePath, "benchmark", r.key.benchmark, "source", p.src, "err", err)
+			continue
+		}
+		r.addResult(p.src, p.res)
+	}
+	r.pendingUploads = nil
+}
+
+// pendingUpload is a run, whose profile results are added to the benchmark
+// once they have been uploaded.
+type pendingUpload struct {
+	src benchSource
+	res *benchmarkResult
+}
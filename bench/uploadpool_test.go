@@ -0,0 +1,112 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadPool(t *testing.T) {
+	var (
+		active, maxActive atomic.Int32
+		mtx               sync.Mutex
+		attempts          = map[string]int{}
+	)
+	ctx := addUploaderToContext(context.Background(), uploaderFunc(func(_ context.Context, _ log.Logger, body io.Reader) (*profileResponse, error) {
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			m := maxActive.Load()
+			if n <= m || maxActive.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		attempts[string(data)]++
+		switch {
+		case string(data) == "broken":
+			return nil, errors.New("unavailable")
+		case string(data) == "flaky" && attempts["flaky"] == 1:
+			return nil, errors.New("try again")
+		}
+		return &profileResponse{Key: "key-" + string(data)}, nil
+	}))
+
+	pool := newUploadPool(2)
+	pool.backoff = time.Millisecond
+
+	var keys []string
+	batch := &uploadBatch{}
+	for i := 0; i < 5; i++ {
+		batch.add(ctx, pool, log.NewNopLogger(), []byte(fmt.Sprint(i)), func(*profileResponse, error) {}, func(res *profileResponse) {
+			keys = append(keys, res.Key)
+		})
+	}
+	batch.add(ctx, pool, log.NewNopLogger(), []byte("flaky"), func(*profileResponse, error) {}, func(res *profileResponse) {
+		keys = append(keys, res.Key)
+	})
+	var after bool
+	res := &benchmarkResult{uploads: batch}
+	res.afterUploads(func() { after = true })
+	require.False(t, after)
+
+	require.NoError(t, res.awaitUploads())
+	require.True(t, after)
+	require.Nil(t, res.uploads)
+	// applied in the order the uploads were started
+	require.Equal(t, []string{"key-0", "key-1", "key-2", "key-3", "key-4", "key-flaky"}, keys)
+	require.LessOrEqual(t, maxActive.Load(), int32(2))
+	require.Equal(t, 2, attempts["flaky"])
+
+	// failing uploads are retried, before the batch fails
+	var uploaded []error
+	batch = &uploadBatch{}
+	batch.add(ctx, pool, log.NewNopLogger(), []byte("broken"), func(_ *profileResponse, err error) {
+		uploaded = append(uploaded, err)
+	}, func(*profileResponse) {
+		t.Fatal("failed upload applied")
+	})
+	res = &benchmarkResult{uploads: batch}
+	res.afterUploads(func() { t.Fatal("called after failed upload") })
+	err := res.awaitUploads()
+	require.ErrorContains(t, err, "upload failed after 3 attempts: unavailable")
+	require.Len(t, uploaded, 1)
+	require.Equal(t, uploadAttempts, attempts["broken"])
+	pool.wait()
+
+	// without pending uploads, f is called right away
+	after = false
+	(&benchmarkResult{}).afterUploads(func() { after = true })
+	require.True(t, after)
+}
+
+func TestUploadPoolCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = addUploaderToContext(ctx, uploaderFunc(func(context.Context, log.Logger, io.Reader) (*profileResponse, error) {
+		return nil, errors.New("unavailable")
+	}))
+	pool := newUploadPool(1)
+	pool.backoff = time.Hour
+
+	batch := &uploadBatch{}
+	batch.add(ctx, pool, log.NewNopLogger(), []byte("profile"), func(*profileResponse, error) {}, func(*profileResponse) {})
+	cancel()
+	// the backoff is not waited for
+	require.Error(t, batch.wait())
+	pool.wait()
+}
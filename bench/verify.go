@@ -0,0 +1,65 @@
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alecthomas/kingpin/v2"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+type VerifyArgs struct {
+	File       string
+	SigningKey string
+	Repository string
+	Head       string
+}
+
+func AddVerifyCommand(app *kingpin.Application) (*kingpin.CmdClause, *VerifyArgs) {
+	cmd := app.Command("verify", "Verify the signature of a report posted with --signing-key.")
+	args := &VerifyArgs{}
+	cmd.Arg("file", "File holding the report body, '-' reads from stdin.").Default("-").StringVar(&args.File)
+	cmd.Flag("signing-key", "Key the report has been signed with.").Envar("PYROBENCH_SIGNING_KEY").Required().StringVar(&args.SigningKey)
+	cmd.Flag("repository", "Require the report to be signed for this repository (owner/repo).").StringVar(&args.Repository)
+	cmd.Flag("head", "Require the report to be signed for this head commit.").StringVar(&args.Head)
+	return cmd, args
+}
+
+// Verify checks the signature of a report and that it has been signed for the
+// expected repository and commit.
+func (b *Benchmark) Verify(_ context.Context, args *VerifyArgs) error {
+	var r io.Reader = os.Stdin
+	if args.File != "-" {
+		f, err := os.Open(args.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	p, err := report.Verify([]byte(args.SigningKey), string(body))
+	if err != nil {
+		return err
+	}
+	if args.Repository != "" && p.Repository != args.Repository {
+		return fmt.Errorf("report has been signed for repository %s, expected %s", p.Repository, args.Repository)
+	}
+	if args.Head != "" && p.Head != args.Head {
+		return fmt.Errorf("report has been signed for head %s, expected %s", p.Head, args.Head)
+	}
+	if p.Head == "" {
+		return errors.New("report has been signed without a head commit")
+	}
+
+	fmt.Fprintf(b.output, "valid signature for %s at %s\n", p.Repository, p.Head)
+	return nil
+}
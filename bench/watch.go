@@ -0,0 +1,315 @@
+package bench
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/dustin/go-humanize"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+
+	"github.com/grafana/pyrobench/history"
+	"github.com/grafana/pyrobench/report"
+)
+
+type WatchArgs struct {
+	*CompareArgs
+
+	Remote        string
+	Branch        string
+	Interval      time.Duration
+	Listen        string // address to receive push webhooks and serve metrics on, disabled when empty
+	WebhookSecret string // secret to verify the signature of webhooks with
+	AlertWebhook  string // URL to post regression alerts to
+}
+
+func AddWatchCommand(app *kingpin.Application) (*kingpin.CmdClause, *WatchArgs) {
+	cmd := app.Command("watch", "Continuously benchmark every push to a branch against its parent commit.")
+	args := &WatchArgs{
+		CompareArgs: addCompareArgs(cmd),
+	}
+	cmd.Flag("remote", "Git remote to fetch the branch from.").Default("origin").StringVar(&args.Remote)
+	cmd.Flag("branch", "Branch to watch.").Default("main").StringVar(&args.Branch)
+	cmd.Flag("interval", "How often to poll the remote for new commits.").Default("5m").DurationVar(&args.Interval)
+	cmd.Flag("listen", "Address to receive push webhooks on, which trigger an immediate poll, and to serve Prometheus metrics of the service at /metrics.").PlaceHolder("ADDR").StringVar(&args.Listen)
+	cmd.Flag("webhook-secret", "Secret to verify the X-Hub-Signature-256 header of received webhooks with.").Envar("PYROBENCH_WEBHOOK_SECRET").StringVar(&args.WebhookSecret)
+	cmd.Flag("alert-webhook", "URL to post a JSON message with a 'text' field to, when a push regresses.").PlaceHolder("URL").StringVar(&args.AlertWebhook)
+	return cmd, args
+}
+
+// Watch polls the branch for new commits and compares every new tip against
+// its parent, until the context is canceled. The results are recorded in the
+// history and regressions are alerted.
+func (b *Benchmark) Watch(ctx context.Context, args *WatchArgs) error {
+	if !args.History.Enabled() {
+		return errors.New("watch requires a history file to record the results in")
+	}
+	if args.Interval <= 0 {
+		return fmt.Errorf("invalid poll interval %s", args.Interval)
+	}
+	store, err := history.NewStore(args.History)
+	if err != nil {
+		return err
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	ctx = addServiceMetricsToContext(ctx, newServiceMetrics(reg))
+
+	triggerCh := make(chan struct{}, 1)
+	if args.Listen != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler(reg))
+		mux.Handle("/", webhookHandler(args.WebhookSecret, triggerCh))
+		srv := &http.Server{
+			Addr:              args.Listen,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				level.Error(b.logger).Log("msg", "error serving webhooks", "err", err)
+			}
+		}()
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = srv.Shutdown(shutdownCtx)
+		}()
+	}
+
+	ticker := time.NewTicker(args.Interval)
+	defer ticker.Stop()
+
+	var last string
+	for {
+		tip, err := b.watchOnce(ctx, args, store, last)
+		if err != nil {
+			level.Error(b.logger).Log("msg", "error watching branch", "branch", args.Branch, "err", err)
+		}
+		if tip != "" {
+			last = tip
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-triggerCh:
+		}
+	}
+}
+
+// maxWatchQueue limits the number of commits compared per poll, older
+// commits pushed at once are skipped.
+const maxWatchQueue = 10
+
+// watchOnce fetches the branch and compares every commit pushed since last
+// with its parent, unless it has been benchmarked before. It returns the tip.
+func (b *Benchmark) watchOnce(ctx context.Context, args *WatchArgs, store history.Store, last string) (string, error) {
+	if _, err := git("fetch", "--quiet", args.Remote, args.Branch); err != nil {
+		return "", err
+	}
+	tip, err := b.gitRevParse(ctx, "FETCH_HEAD")
+	if err != nil {
+		return "", err
+	}
+	if tip == last {
+		return tip, nil
+	}
+
+	// after a restart the commits might have been benchmarked already
+	records, err := store.Load(ctx)
+	if err != nil {
+		return "", err
+	}
+	benchmarked := make(map[string]bool, len(records))
+	for _, r := range records {
+		benchmarked[r.Commit] = true
+	}
+
+	var errs []error
+	for _, commit := range b.pushedCommits(args.Branch, last, tip) {
+		if benchmarked[commit] {
+			level.Debug(b.logger).Log("msg", "commit already benchmarked", "commit", commit)
+			continue
+		}
+		// do not retry a broken commit on every poll
+		if err := b.watchCommit(ctx, args, commit); err != nil {
+			errs = append(errs, fmt.Errorf("commit %s: %w", commit, err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return tip, errors.Join(errs...)
+}
+
+// pushedCommits returns the commits of the branch after last up to tip, oldest
+// first. Only tip is returned on the first poll or when last is unknown, e.g.
+// after a force push. Of more than maxWatchQueue commits the oldest are
+// skipped.
+func (b *Benchmark) pushedCommits(branch, last, tip string) []string {
+	if last == "" {
+		return []string{tip}
+	}
+	out, err := git("rev-list", "--first-parent", "--reverse", last+".."+tip)
+	if err != nil {
+		level.Warn(b.logger).Log("msg", "error listing pushed commits, only benchmarking the tip", "branch", branch, "err", err)
+		return []string{tip}
+	}
+	commits := strings.Fields(string(out))
+	if len(commits) == 0 {
+		// the branch has been reset to an older commit
+		return []string{tip}
+	}
+	if skipped := len(commits) - maxWatchQueue; skipped > 0 {
+		level.Warn(b.logger).Log("msg", "too many commits pushed, skipping the oldest", "branch", branch, "skipped", skipped, "commits", strings.Join(commits[:skipped], ","))
+		commits = commits[skipped:]
+	}
+	return commits
+}
+
+// watchCommit compares the commit with its parent, records the results in
+// the history and alerts regressions.
+func (b *Benchmark) watchCommit(ctx context.Context, args *WatchArgs, commit string) error {
+	level.Info(b.logger).Log("msg", "benchmarking new commit", "branch", args.Branch, "commit", commit)
+	compareArgs := *args.CompareArgs
+	compareArgs.BaseRef = commit + "~1"
+	compareArgs.HeadRef = commit
+	filter, err := compareArgs.filters(nil)
+	if err != nil {
+		return err
+	}
+
+	fb := b.fresh()
+	updateCh := make(chan *report.BenchmarkReport)
+	reporter, err := fb.newReporter(&compareArgs, updateCh)
+	if err != nil {
+		return err
+	}
+	rpt, err := fb.compareWithReporter(ctx, &compareArgs, updateCh, filter...)
+	if stopErr := reporter.Stop(); stopErr != nil {
+		level.Warn(b.logger).Log("msg", "error stopping reporter", "err", stopErr)
+	}
+	if err != nil || rpt == nil {
+		serviceMetricsFromContext(ctx).observeComparison(err, 0)
+		return err
+	}
+
+	var threshold float64
+	if compareArgs.Report != nil {
+		threshold = compareArgs.Report.PercentageThreshold
+	}
+	regressions := rpt.Regressions(threshold)
+	serviceMetricsFromContext(ctx).observeComparison(nil, len(regressions))
+	if len(regressions) == 0 {
+		return nil
+	}
+
+	text := regressionAlert(args.Branch, commit, regressions)
+	level.Warn(b.logger).Log("msg", "push regressed", "branch", args.Branch, "commit", commit, "regressions", len(regressions))
+	if args.AlertWebhook != "" {
+		if err := postAlert(ctx, args.AlertWebhook, text); err != nil {
+			return fmt.Errorf("error posting alert: %w", err)
+		}
+	}
+	return nil
+}
+
+// regressionAlert summarizes the regressions of a commit.
+func regressionAlert(branch, commit string, regressions []report.Regression) string {
+	var sb strings.Builder
+	short := commit
+	if len(short) > 12 {
+		short = short[:12]
+	}
+	fmt.Fprintf(&sb, "pyrobench: %s at %s regressed compared to its parent:", branch, short)
+	for _, r := range regressions {
+		fmt.Fprintf(&sb, "\n- %s %s %s %%", r.Run.Name, r.Result.Name, humanize.CommafWithDigits(r.Diff, 2))
+		for _, c := range r.Critical {
+			fmt.Fprintf(&sb, "\n  - %s", c.Markdown(r.Result.Name))
+		}
+	}
+	return sb.String()
+}
+
+// postAlert posts the text in the format understood by Slack compatible
+// incoming webhooks.
+func postAlert(ctx context.Context, url, text string) error {
+	body, err := json.Marshal(struct {
+		Text string `json:"text"`
+	}{text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("user-agent", "pyrobench")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookHandler triggers a poll for every valid webhook received. The payload
+// itself is not trusted, the branch is always fetched from the remote.
+func webhookHandler(secret string, triggerCh chan<- struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 25<<20))
+		if err != nil {
+			http.Error(w, "error reading body", http.StatusBadRequest)
+			return
+		}
+		if secret != "" && !validSignature(secret, body, r.Header.Get("X-Hub-Signature-256")) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		select {
+		case triggerCh <- struct{}{}:
+		default:
+			// a poll is already pending
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// validSignature checks the HMAC-SHA256 signature of a webhook, as sent by
+// GitHub.
+func validSignature(secret string, body []byte, signature string) bool {
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	actual, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), actual)
+}
@@ -0,0 +1,132 @@
+package bench
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/history"
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestWebhookHandler(t *testing.T) {
+	triggerCh := make(chan struct{}, 1)
+	h := webhookHandler("secret", triggerCh)
+
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	post := func(body, signature string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if signature != "" {
+			req.Header.Set("X-Hub-Signature-256", signature)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(t, http.StatusUnauthorized, post(`{"ref":"refs/heads/main"}`, ""))
+	require.Equal(t, http.StatusUnauthorized, post(`{"ref":"refs/heads/main"}`, sign(`{"ref":"refs/heads/other"}`)))
+	require.Len(t, triggerCh, 0)
+
+	require.Equal(t, http.StatusAccepted, post(`{"ref":"refs/heads/main"}`, sign(`{"ref":"refs/heads/main"}`)))
+	// a pending trigger must not block
+	require.Equal(t, http.StatusAccepted, post(`{}`, sign(`{}`)))
+	require.Len(t, triggerCh, 1)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestRegressionAlert(t *testing.T) {
+	run := &report.BenchmarkRun{Name: "pkg.BenchmarkA"}
+	res := &report.BenchmarkResult{Name: "cpu"}
+	text := regressionAlert("main", "0123456789abcdef", []report.Regression{
+		{Run: run, Result: res, Diff: 12.5, Critical: []report.CriticalFunction{{Name: "pkg.Decode", Diff: 20}}},
+	})
+	require.Equal(t, "pyrobench: main at 0123456789ab regressed compared to its parent:\n"+
+		"- pkg.BenchmarkA cpu 12.5 %\n"+
+		"  - Critical function `pkg.Decode` regressed `cpu` by 20 %.", text)
+
+	var received string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct {
+			Text string `json:"text"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		received = msg.Text
+	}))
+	defer srv.Close()
+	require.NoError(t, postAlert(context.Background(), srv.URL, text))
+	require.Equal(t, text, received)
+}
+
+func TestWatchOnceSkipsBenchmarkedCommits(t *testing.T) {
+	upstream := t.TempDir()
+	runGit(t, upstream, "init", "--initial-branch", "main", ".")
+	require.NoError(t, os.WriteFile(filepath.Join(upstream, "file"), []byte("a"), 0o644))
+	runGit(t, upstream, "add", "file")
+	runGit(t, upstream, "commit", "-m", "first")
+	tip := runGit(t, upstream, "rev-parse", "HEAD")
+
+	work := t.TempDir()
+	runGit(t, work, "clone", "--quiet", "file://"+upstream, ".")
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(work))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+
+	store, err := history.NewStore(&history.Args{Path: filepath.Join(t.TempDir(), "history.jsonl")})
+	require.NoError(t, err)
+	require.NoError(t, store.Append(context.Background(), history.Record{Commit: tip, Benchmark: "pkg.BenchmarkA", Resource: "cpu"}))
+
+	b, err := New(log.NewNopLogger())
+	require.NoError(t, err)
+	args := &WatchArgs{CompareArgs: &CompareArgs{}, Remote: "origin", Branch: "main"}
+
+	seen, err := b.watchOnce(context.Background(), args, store, "")
+	require.NoError(t, err)
+	require.Equal(t, tip, seen)
+
+	seen, err = b.watchOnce(context.Background(), args, store, tip)
+	require.NoError(t, err)
+	require.Equal(t, tip, seen)
+
+	// every commit of a push is queued, not just the new tip
+	var pushed []string
+	for i := 0; i < maxWatchQueue+2; i++ {
+		runGit(t, upstream, "commit", "--allow-empty", "-m", fmt.Sprintf("commit %d", i))
+		commit := runGit(t, upstream, "rev-parse", "HEAD")
+		pushed = append(pushed, commit)
+		require.NoError(t, store.Append(context.Background(), history.Record{Commit: commit, Benchmark: "pkg.BenchmarkA", Resource: "cpu"}))
+	}
+	newTip := pushed[len(pushed)-1]
+	seen, err = b.watchOnce(context.Background(), args, store, tip)
+	require.NoError(t, err)
+	require.Equal(t, newTip, seen)
+	require.Equal(t, pushed[len(pushed)-2:], b.pushedCommits(args.Branch, pushed[len(pushed)-3], newTip))
+	// the oldest commits beyond the queue are skipped
+	require.Equal(t, pushed[2:], b.pushedCommits(args.Branch, tip, newTip))
+
+	// after a force push the previous tip might be unknown
+	require.Equal(t, []string{newTip}, b.pushedCommits(args.Branch, "0123456789abcdef0123456789abcdef01234567", newTip))
+	require.Equal(t, []string{tip}, b.pushedCommits(args.Branch, newTip, tip))
+}
@@ -0,0 +1,208 @@
+package benchtab
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/perf/benchunit"
+)
+
+// RenderText writes the tables aligned for a fixed-width font, the way
+// benchstat prints them. Nothing is written for nil tables.
+func RenderText(w io.Writer, t *Tables) error {
+	if t == nil {
+		return nil
+	}
+	return t.ToText(w, false)
+}
+
+// RenderMarkdown writes the tables as GitHub flavored Markdown. Nothing is
+// written for nil tables.
+func RenderMarkdown(w io.Writer, t *Tables) error {
+	if t == nil {
+		return nil
+	}
+	return t.ToMarkdown(w)
+}
+
+// ToMarkdown renders t to GitHub flavored Markdown. The table keys, which
+// change between tables, precede each table as code spans.
+func (t *Tables) ToMarkdown(w io.Writer) error {
+	var hdrs []string
+	return t.printTables(func(hdr string) error {
+		if hdr == "" {
+			// Blank line between tables.
+			_, err := fmt.Fprintln(w)
+			return err
+		}
+		hdrs = append(hdrs, "`"+hdr+"`")
+		return nil
+	}, func(table *Table) error {
+		if len(hdrs) > 0 {
+			if _, err := fmt.Fprintf(w, "%s\n\n", strings.Join(hdrs, " ")); err != nil {
+				return err
+			}
+			hdrs = hdrs[:0]
+		}
+		return table.ToMarkdown(w)
+	})
+}
+
+// ToMarkdown renders t to a GitHub flavored Markdown table. The cells are
+// padded, so the columns line up in the source as well. Like ToText, each
+// column after the first is compared to the first one and the summary row is
+// only shown for more than one row.
+func (t *Table) ToMarkdown(w io.Writer) error {
+	var warningList []string
+	warningSet := make(map[string]int)
+	warn := func(s string, msgs ...[]error) string {
+		for _, msgs1 := range msgs {
+			for _, msg := range msgs1 {
+				i, ok := warningSet[msg.Error()]
+				if !ok {
+					i = len(warningList)
+					warningSet[msg.Error()] = i
+					warningList = append(warningList, msg.Error())
+				}
+				s += " " + superscript(i+1)
+			}
+		}
+		return s
+	}
+
+	// The header, one column per sample and one delta column for every
+	// sample compared to the first one.
+	header := []string{""}
+	right := []bool{false}
+	for i, col := range t.Cols {
+		header = append(header, strings.TrimSpace(col.StringValues()+" "+t.Unit))
+		right = append(right, true)
+		if i > 0 {
+			header = append(header, "vs base")
+			right = append(right, true)
+		}
+	}
+
+	rows := [][]string{header}
+	unitClass := benchunit.ClassOf(t.Unit)
+	for _, row := range t.Rows {
+		cells := make([]string, len(header))
+		cells[0] = row.StringValues()
+		scaler := t.RowScaler(row, unitClass)
+		for exp, col := range t.Cols {
+			cell, ok := t.Cells[TableKey{row, col}]
+			if !ok {
+				continue
+			}
+			i := markdownCol(exp)
+			cells[i] = warn(scaler.Format(cell.Summary.Center)+" ± "+cell.Summary.PctRangeString(), cell.Sample.Warnings, cell.Summary.Warnings)
+			if exp > 0 && cell.Baseline != nil {
+				d := cell.Comparison.FormatDelta(cell.Baseline.Summary.Center, cell.Summary.Center)
+				cells[i+1] = warn(d+" ("+cell.Comparison.String()+")", cell.Comparison.Warnings)
+			}
+		}
+		rows = append(rows, cells)
+	}
+
+	if len(t.Rows) > 1 {
+		cells := make([]string, len(header))
+		cells[0] = t.SummaryLabel
+		for exp, col := range t.Cols {
+			tsum, ok := t.Summary[col]
+			if !ok {
+				continue
+			}
+			i := markdownCol(exp)
+			if tsum.HasSummary {
+				cells[i] = benchunit.Scale(tsum.Summary, unitClass)
+			}
+			if exp > 0 {
+				if tsum.HasRatio {
+					cells[i+1] = fmt.Sprintf("%+.2f%%", (tsum.Ratio-1)*100)
+				} else {
+					cells[i+1] = "?"
+				}
+			}
+			cells[i] = strings.TrimSpace(warn(cells[i], tsum.Warnings))
+		}
+		rows = append(rows, cells)
+	}
+
+	widths := make([]int, len(header))
+	for _, cells := range rows {
+		for i, c := range cells {
+			c = markdownEscape(c)
+			cells[i] = c
+			// the delimiter row needs at least three characters
+			widths[i] = max(widths[i], utf8.RuneCountInString(c), 3)
+		}
+	}
+
+	writeRow := func(cells []string) error {
+		var sb strings.Builder
+		sb.WriteString("|")
+		for i, c := range cells {
+			pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c))
+			if right[i] {
+				c = pad + c
+			} else {
+				c += pad
+			}
+			sb.WriteString(" " + c + " |")
+		}
+		sb.WriteString("\n")
+		_, err := io.WriteString(w, sb.String())
+		return err
+	}
+
+	if err := writeRow(rows[0]); err != nil {
+		return err
+	}
+	delim := make([]string, len(header))
+	for i := range delim {
+		if right[i] {
+			delim[i] = strings.Repeat("-", widths[i]-1) + ":"
+		} else {
+			delim[i] = strings.Repeat("-", widths[i])
+		}
+	}
+	if err := writeRow(delim); err != nil {
+		return err
+	}
+	for _, cells := range rows[1:] {
+		if err := writeRow(cells); err != nil {
+			return err
+		}
+	}
+
+	if len(warningList) > 0 {
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+		for i, msg := range warningList {
+			// a hard line break between the footnotes
+			if _, err := fmt.Fprintf(w, "%s %s<br>\n", superscript(i+1), markdownEscape(msg)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// markdownCol returns the index of the Markdown column of sample exp. All but
+// the first sample are followed by their delta column.
+func markdownCol(exp int) int {
+	if exp == 0 {
+		return 1
+	}
+	return 2 * exp
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "<", "&lt;", ">", "&gt;")
+
+func markdownEscape(s string) string {
+	return markdownEscaper.Replace(s)
+}
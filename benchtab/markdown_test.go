@@ -0,0 +1,78 @@
+package benchtab
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/perf/benchfmt"
+	"golang.org/x/perf/benchmath"
+	"golang.org/x/perf/benchproc"
+)
+
+func testTables(t *testing.T) *Tables {
+	t.Helper()
+	filter, err := benchproc.NewFilter("*")
+	require.NoError(t, err)
+	// rows by .fullname like benchstat, to get a summary row
+	var parser benchproc.ProjectionParser
+	tableBy, _, err := parser.ParseWithUnit(".config", filter)
+	require.NoError(t, err)
+	rowBy, err := parser.Parse(".fullname", filter)
+	require.NoError(t, err)
+	colBy, err := parser.Parse("source", filter)
+	require.NoError(t, err)
+	builder := NewBuilder(tableBy, rowBy, colBy, parser.Residue())
+
+	add := func(source string, ns ...string) {
+		var lines []string
+		for _, n := range ns {
+			lines = append(lines, "BenchmarkA-8 100 "+n+" ns/op", "BenchmarkB-8 100 "+n+"0 ns/op")
+		}
+		r := benchfmt.NewReader(strings.NewReader("goos: linux\n"+strings.Join(lines, "\n")+"\n"), source)
+		for r.Scan() {
+			res, ok := r.Result().(*benchfmt.Result)
+			if !ok {
+				continue
+			}
+			ok, err := filter.Apply(res)
+			require.True(t, ok)
+			require.NoError(t, err)
+			res.SetConfig("source", source)
+			builder.Add(res)
+		}
+		require.NoError(t, r.Err())
+	}
+	add("base", "1000", "1010", "990", "1005", "995", "1000")
+	add("head", "2000", "2020", "1980", "2010", "1990", "2000")
+
+	return builder.ToTables(TableOpts{
+		Confidence: 0.95,
+		Thresholds: &benchmath.DefaultThresholds,
+	})
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	buf := new(bytes.Buffer)
+	require.NoError(t, RenderMarkdown(buf, testTables(t)))
+	require.Equal(t, strings.Join([]string{
+		"`goos: linux`",
+		"",
+		"|         | base sec/op | head sec/op |                vs base |",
+		"| ------- | ----------: | ----------: | ---------------------: |",
+		"| A-8     | 1.000µ ± 1% | 2.000µ ± 1% | +100.00% (p=0.002 n=6) |",
+		"| B-8     | 10.00µ ± 1% | 20.00µ ± 1% | +100.00% (p=0.002 n=6) |",
+		"| geomean |      3.162µ |      6.325µ |               +100.00% |",
+		"",
+	}, "\n"), buf.String())
+
+	require.NoError(t, RenderMarkdown(buf, nil))
+}
+
+func TestRenderText(t *testing.T) {
+	buf := new(bytes.Buffer)
+	require.NoError(t, RenderText(buf, testTables(t)))
+	require.Contains(t, buf.String(), "geomean")
+	require.Contains(t, buf.String(), "+100.00% (p=0.002 n=6)")
+}
@@ -0,0 +1,576 @@
+// Package config reads the pyrobench configuration kept in the benchmarked
+// repository.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the configuration file in the repository root.
+const FileName = ".pyrobench.yaml"
+
+type Args struct {
+	Path string
+}
+
+func AddArgs(cmd *kingpin.CmdClause) *Args {
+	args := &Args{}
+	cmd.Flag("config", "Path of the configuration file, "+FileName+" in the working directory when not set.").PlaceHolder("PATH").StringVar(&args.Path)
+	return args
+}
+
+type Config struct {
+	Suites   map[string]*Suite   `yaml:"suites"`
+	Packages map[string]*Package `yaml:"packages"` // keyed by a glob of import paths
+	Paths    map[string][]string `yaml:"paths"`    // suites to run for changed files, keyed by a glob of repository relative paths
+	Limits   Limits              `yaml:"limits"`
+	Policy   Policy              `yaml:"policy"`
+
+	// SampleTypes are the sample types of the profiles shown in the
+	// reports, e.g. cpu or of custom profiles. All are shown when empty.
+	SampleTypes []string `yaml:"sample_types"`
+}
+
+// The default limits of benchmarks requested in pull request comments.
+const (
+	DefaultMaxTime       = time.Minute
+	DefaultMaxIterations = 1000000
+)
+
+// Limits bound the options of benchmarks requested in pull request comments,
+// so they can not be abused to occupy the runners.
+type Limits struct {
+	MaxTime       string `yaml:"max_time"`       // longest benchtime duration, DefaultMaxTime when empty
+	MaxIterations int    `yaml:"max_iterations"` // largest benchtime iteration count, DefaultMaxIterations when 0
+
+	// TestFlags are the names of flags, which may be passed to the test
+	// binaries in addition to AllowedTestFlags, e.g. -db.fixtures
+	// registered by a TestMain.
+	TestFlags []string `yaml:"test_flags"`
+}
+
+// DefaultApprovers are the author associations, which may approve benchmarks
+// requested in pull request comments, unless configured otherwise.
+var DefaultApprovers = []string{"member", "owner"}
+
+// Policy restricts who may request which benchmarks in pull request comments,
+// in addition to the author associations allowed by the comment hook.
+type Policy struct {
+	OptionAssociations []string `yaml:"option_associations"` // may set count, time and flags, everybody when empty
+	DailyBudget        string   `yaml:"daily_budget"`        // benchmark time a user may request per UTC day, unlimited when empty
+	ApprovalAbove      string   `yaml:"approval_above"`      // requests of more benchmark time need approval, none do when empty
+	Approvers          []string `yaml:"approvers"`           // may approve requests and are exempt from budget and approval, DefaultApprovers when empty
+}
+
+// Package prepares the environment the benchmarks of the matching packages
+// run in. The commands are run by the shell in the package's directory.
+type Package struct {
+	Env      map[string]string `yaml:"env"`      // added to the environment of the test binary and the commands
+	Setup    string            `yaml:"setup"`    // run before the first benchmark of the package
+	Teardown string            `yaml:"teardown"` // run on cleanup, even when the setup failed
+
+	// WorkDir is the working directory of the test binary relative to the
+	// repository root, the package's directory when empty.
+	WorkDir string `yaml:"workdir"`
+	// Fixtures are paths relative to the repository root, which are linked
+	// into the checkouts of base and head from the working directory, when
+	// they are missing there, e.g. test data not tracked by git.
+	Fixtures []string `yaml:"fixtures"`
+	// Services are containers started before the first benchmark of the
+	// package, e.g. databases. Their addresses are added to the environment.
+	Services []Service `yaml:"services"`
+	// Flags are passed to the test binary, like -test.short or the flags
+	// registered by a TestMain.
+	Flags []string `yaml:"flags"`
+}
+
+// Service is a container the benchmarks depend on. It is configured either
+// by its image alone, like postgres:16, or as mapping.
+type Service struct {
+	Image string            `yaml:"image"`
+	Name  string            `yaml:"name"` // prefix of its environment variables, derived from the image when empty
+	Env   map[string]string `yaml:"env"`  // of the container
+}
+
+func (s *Service) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = Service{}
+		return value.Decode(&s.Image)
+	}
+	type plain Service
+	return value.Decode((*plain)(s))
+}
+
+// EnvName returns the prefix of the environment variables holding the address
+// of the service, e.g. POSTGRES for the image postgres:16.
+func (s *Service) EnvName() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	name := s.Image
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.IndexAny(name, ":@"); i >= 0 {
+		name = name[:i]
+	}
+	return strings.ToUpper(nonEnvChars.ReplaceAllString(name, "_"))
+}
+
+var (
+	envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	nonEnvChars    = regexp.MustCompile(`[^A-Za-z0-9_]`)
+)
+
+func (s *Service) validate() error {
+	if s.Image == "" || strings.ContainsAny(s.Image, " \t\n") || strings.HasPrefix(s.Image, "-") {
+		return fmt.Errorf("invalid service image %q", s.Image)
+	}
+	if !envNamePattern.MatchString(s.EnvName()) {
+		return fmt.Errorf("invalid name %q of service %s, set a name usable in environment variables", s.EnvName(), s.Image)
+	}
+	for k := range s.Env {
+		if k == "" || strings.Contains(k, "=") {
+			return fmt.Errorf("invalid environment variable %q of service %s", k, s.Image)
+		}
+	}
+	return nil
+}
+
+// Suite is a named selection of benchmarks together with how to run them.
+type Suite struct {
+	Packages        []string `yaml:"packages"`         // globs of import paths to include, all when empty
+	ExcludePackages []string `yaml:"exclude_packages"` // globs of import paths to exclude
+	Bench           string   `yaml:"bench"`            // regular expression of benchmark names, all when empty
+	Count           int      `yaml:"count"`            // 0 keeps the default count
+	Time            string   `yaml:"time"`             // empty keeps the default benchtime
+	Flags           []string `yaml:"flags"`            // passed to the test binaries
+}
+
+// Load reads the configuration file. A missing file results in an empty
+// configuration, unless its path has been given explicitly.
+func Load(args *Args) (*Config, error) {
+	p := FileName
+	if args != nil && args.Path != "" {
+		p = args.Path
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) && (args == nil || args.Path == "") {
+		return &Config{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", p, err)
+	}
+	return c, nil
+}
+
+// Parse reads and validates a configuration. Unknown fields are rejected, so
+// typos do not go unnoticed.
+func Parse(r io.Reader) (*Config, error) {
+	var c Config
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	for name, s := range c.Suites {
+		if s == nil {
+			c.Suites[name] = &Suite{}
+			continue
+		}
+		if err := s.validate(); err != nil {
+			return nil, fmt.Errorf("suite %s: %w", name, err)
+		}
+	}
+	if err := c.Limits.validate(); err != nil {
+		return nil, fmt.Errorf("limits: %w", err)
+	}
+	if err := c.Policy.validate(); err != nil {
+		return nil, fmt.Errorf("policy: %w", err)
+	}
+	for glob, p := range c.Packages {
+		if p == nil {
+			c.Packages[glob] = &Package{}
+			continue
+		}
+		if err := p.validate(glob); err != nil {
+			return nil, fmt.Errorf("package %s: %w", glob, err)
+		}
+	}
+	for glob, suites := range c.Paths {
+		if err := c.validatePath(glob, suites); err != nil {
+			return nil, fmt.Errorf("path %s: %w", glob, err)
+		}
+	}
+	for _, st := range c.SampleTypes {
+		if strings.TrimSpace(st) == "" {
+			return nil, errors.New("sample_types: empty sample type")
+		}
+	}
+	return &c, nil
+}
+
+func (c *Config) validatePath(glob string, suites []string) error {
+	if !isRepositoryPath(glob) {
+		return errors.New("invalid path glob, expected a path relative to the repository root")
+	}
+	if _, err := path.Match(strings.TrimSuffix(glob, "/..."), ""); err != nil {
+		return fmt.Errorf("invalid path glob: %w", err)
+	}
+	if len(suites) == 0 {
+		return errors.New("no suites given")
+	}
+	for _, name := range suites {
+		if _, err := c.Suite(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchPath returns true if the repository relative path of a file matches
+// the glob. A glob ending in "/..." matches all files below the directories
+// matching the rest of it.
+func MatchPath(glob, file string) bool {
+	prefix, ok := strings.CutSuffix(glob, "/...")
+	if !ok {
+		ok, _ := path.Match(glob, file)
+		return ok
+	}
+	if prefix == "." {
+		return true
+	}
+	for dir := path.Dir(file); dir != "."; dir = path.Dir(dir) {
+		if ok, _ := path.Match(prefix, dir); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// PathSuites returns the names of the suites configured for the paths
+// matching any of the files in alphabetical order, along with the files no
+// path matches.
+func (c *Config) PathSuites(files []string) ([]string, []string) {
+	var (
+		suites    []string
+		unmatched []string
+	)
+	for _, f := range files {
+		var matched bool
+		for glob, names := range c.Paths {
+			if !MatchPath(glob, f) {
+				continue
+			}
+			matched = true
+			for _, name := range names {
+				if !slices.Contains(suites, name) {
+					suites = append(suites, name)
+				}
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, f)
+		}
+	}
+	sort.Strings(suites)
+	return suites, unmatched
+}
+
+func (p *Package) validate(glob string) error {
+	if _, err := path.Match(strings.TrimSuffix(glob, "/..."), ""); err != nil {
+		return fmt.Errorf("invalid package glob: %w", err)
+	}
+	for k := range p.Env {
+		if k == "" || strings.Contains(k, "=") {
+			return fmt.Errorf("invalid environment variable %q", k)
+		}
+	}
+	if p.WorkDir != "" && !isRepositoryPath(p.WorkDir) {
+		return fmt.Errorf("invalid workdir %q, expected a path relative to the repository root", p.WorkDir)
+	}
+	for _, f := range p.Fixtures {
+		if !isRepositoryPath(f) || path.Clean(f) == "." {
+			return fmt.Errorf("invalid fixture %q, expected a path relative to the repository root", f)
+		}
+	}
+	names := make(map[string]bool, len(p.Services))
+	for i := range p.Services {
+		s := &p.Services[i]
+		if err := s.validate(); err != nil {
+			return err
+		}
+		if names[s.EnvName()] {
+			return fmt.Errorf("duplicate service name %s", s.EnvName())
+		}
+		names[s.EnvName()] = true
+	}
+	for _, f := range p.Flags {
+		if err := ValidateTestFlag(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRepositoryPath returns true for slash separated paths, which stay within
+// the repository root.
+func isRepositoryPath(p string) bool {
+	c := path.Clean(p)
+	return !path.IsAbs(c) && !strings.Contains(p, `\`) && c != ".." && !strings.HasPrefix(c, "../")
+}
+
+func (s *Suite) validate() error {
+	for _, g := range append(append([]string{}, s.Packages...), s.ExcludePackages...) {
+		if _, err := path.Match(strings.TrimSuffix(g, "/..."), ""); err != nil {
+			return fmt.Errorf("invalid package glob %q: %w", g, err)
+		}
+	}
+	if _, err := regexp.Compile(s.Bench); err != nil {
+		return fmt.Errorf("invalid bench regex: %w", err)
+	}
+	if s.Count < 0 {
+		return fmt.Errorf("invalid count %d", s.Count)
+	}
+	if s.Time != "" {
+		if err := ValidateBenchTime(s.Time); err != nil {
+			return err
+		}
+	}
+	for _, f := range s.Flags {
+		if err := ValidateTestFlag(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllowedTestFlags are the flags of the testing package, which may be passed
+// to the test binaries. All others are set by pyrobench or change what is
+// measured.
+var AllowedTestFlags = []string{"-test.short", "-test.timeout"}
+
+var testFlagPattern = regexp.MustCompile(`^--?[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// TestFlagName returns the name of a flag like -test.timeout=5m, with a single
+// leading dash.
+func TestFlagName(f string) (string, error) {
+	name, _, _ := strings.Cut(f, "=")
+	if !testFlagPattern.MatchString(name) || strings.ContainsAny(f, "\n\r") {
+		return "", fmt.Errorf("invalid flag %q, expected -name or -name=value", f)
+	}
+	return "-" + strings.TrimLeft(name, "-"), nil
+}
+
+// ValidateTestFlag checks the syntax of a flag of the test binary and rejects
+// the flags of the testing package, which are not allowed.
+func ValidateTestFlag(f string) error {
+	name, err := TestFlagName(f)
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(name, "-test.") && !slices.Contains(AllowedTestFlags, name) {
+		return fmt.Errorf("flag %s is not allowed, only %s of the testing package are", name, strings.Join(AllowedTestFlags, " and "))
+	}
+	return nil
+}
+
+// ValidateBenchTime checks the syntax of a -test.benchtime value, either a
+// duration like 2s or a number of iterations like 100x.
+func ValidateBenchTime(s string) error {
+	_, _, err := parseBenchTime(s)
+	return err
+}
+
+// parseBenchTime returns either the duration or the number of iterations of
+// a -test.benchtime value.
+func parseBenchTime(s string) (time.Duration, int, error) {
+	invalid := fmt.Errorf("invalid time %q, expected a duration like 2s or a number of iterations like 100x", s)
+	if n, ok := strings.CutSuffix(s, "x"); ok {
+		i, err := strconv.Atoi(n)
+		if err != nil || i <= 0 {
+			return 0, 0, invalid
+		}
+		return 0, i, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, 0, invalid
+	}
+	return d, 0, nil
+}
+
+func (l *Limits) validate() error {
+	if l.MaxTime != "" {
+		if d, err := time.ParseDuration(l.MaxTime); err != nil || d <= 0 {
+			return fmt.Errorf("invalid max_time %q", l.MaxTime)
+		}
+	}
+	if l.MaxIterations < 0 {
+		return fmt.Errorf("invalid max_iterations %d", l.MaxIterations)
+	}
+	for _, f := range l.TestFlags {
+		if strings.Contains(f, "=") {
+			return fmt.Errorf("invalid test flag %q, expected the name without a value", f)
+		}
+		if err := ValidateTestFlag(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckTestFlag validates a flag of the test binary requested in a pull
+// request comment, which needs to be one of AllowedTestFlags or TestFlags.
+func (l *Limits) CheckTestFlag(f string) error {
+	if err := ValidateTestFlag(f); err != nil {
+		return err
+	}
+	name, _ := TestFlagName(f)
+	for _, allowed := range l.TestFlags {
+		if n, _ := TestFlagName(allowed); n == name {
+			return nil
+		}
+	}
+	if slices.Contains(AllowedTestFlags, name) {
+		return nil
+	}
+	return fmt.Errorf("flag %s is not allowed, add it to the test_flags of the limits", name)
+}
+
+// CheckBenchTime validates a benchtime requested in a pull request comment
+// and rejects values beyond the limits.
+func (l *Limits) CheckBenchTime(s string) error {
+	d, n, err := parseBenchTime(s)
+	if err != nil {
+		return err
+	}
+	maxTime := DefaultMaxTime
+	if l.MaxTime != "" {
+		// validated by Parse
+		maxTime, _ = time.ParseDuration(l.MaxTime)
+	}
+	maxIterations := DefaultMaxIterations
+	if l.MaxIterations > 0 {
+		maxIterations = l.MaxIterations
+	}
+	if d > maxTime {
+		return fmt.Errorf("time %s exceeds the maximum of %s", s, maxTime)
+	}
+	if n > maxIterations {
+		return fmt.Errorf("time %s exceeds the maximum of %dx", s, maxIterations)
+	}
+	return nil
+}
+
+func (p *Policy) validate() error {
+	for name, d := range map[string]string{"daily_budget": p.DailyBudget, "approval_above": p.ApprovalAbove} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return fmt.Errorf("invalid %s %q", name, d)
+		}
+	}
+	return nil
+}
+
+// Budget returns the benchmark time a user may request per day, 0 when it is
+// unlimited.
+func (p *Policy) Budget() time.Duration {
+	// validated by Parse
+	d, _ := time.ParseDuration(p.DailyBudget)
+	return d
+}
+
+// ApprovalThreshold returns the benchmark time, beyond which requests need
+// approval, 0 when none do.
+func (p *Policy) ApprovalThreshold() time.Duration {
+	// validated by Parse
+	d, _ := time.ParseDuration(p.ApprovalAbove)
+	return d
+}
+
+// MaySetOptions returns true, when authors of the association may set the
+// count, time and flags of benchmarks.
+func (p *Policy) MaySetOptions(association string) bool {
+	return len(p.OptionAssociations) == 0 || containsFold(p.OptionAssociations, association)
+}
+
+// MayApprove returns true, when authors of the association may approve
+// requests.
+func (p *Policy) MayApprove(association string) bool {
+	if len(p.Approvers) == 0 {
+		return containsFold(DefaultApprovers, association)
+	}
+	return containsFold(p.Approvers, association)
+}
+
+// ApproverAssociations returns the author associations, which may approve
+// requests.
+func (p *Policy) ApproverAssociations() []string {
+	if len(p.Approvers) == 0 {
+		return DefaultApprovers
+	}
+	return p.Approvers
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Suite returns the suite with the given name.
+func (c *Config) Suite(name string) (*Suite, error) {
+	if s, ok := c.Suites[name]; ok {
+		return s, nil
+	}
+	if len(c.Suites) == 0 {
+		return nil, fmt.Errorf("unknown suite %q, no suites are defined in %s", name, FileName)
+	}
+	return nil, fmt.Errorf("unknown suite %q, defined are %s", name, strings.Join(c.SuiteNames(), ", "))
+}
+
+// PackageGlobs returns the globs of the package configurations in
+// alphabetical order.
+func (c *Config) PackageGlobs() []string {
+	globs := make([]string, 0, len(c.Packages))
+	for glob := range c.Packages {
+		globs = append(globs, glob)
+	}
+	sort.Strings(globs)
+	return globs
+}
+
+// SuiteNames returns the names of all suites in alphabetical order.
+func (c *Config) SuiteNames() []string {
+	names := make([]string, 0, len(c.Suites))
+	for name := range c.Suites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse(strings.NewReader(`
+suites:
+  quick:
+    bench: BenchmarkGet
+    count: 3
+    time: 100x
+  storage:
+    packages: [example.com/m/storage/...]
+    exclude_packages: [example.com/m/storage/legacy]
+    time: 5s
+    flags: [-test.short, -db.fixtures=testdata]
+  all:
+`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"all", "quick", "storage"}, c.SuiteNames())
+
+	s, err := c.Suite("storage")
+	require.NoError(t, err)
+	require.Equal(t, &Suite{
+		Packages:        []string{"example.com/m/storage/..."},
+		ExcludePackages: []string{"example.com/m/storage/legacy"},
+		Time:            "5s",
+		Flags:           []string{"-test.short", "-db.fixtures=testdata"},
+	}, s)
+
+	s, err = c.Suite("all")
+	require.NoError(t, err)
+	require.Equal(t, &Suite{}, s)
+
+	_, err = c.Suite("nightly")
+	require.EqualError(t, err, `unknown suite "nightly", defined are all, quick, storage`)
+
+	c, err = Parse(strings.NewReader(""))
+	require.NoError(t, err)
+	_, err = c.Suite("nightly")
+	require.EqualError(t, err, `unknown suite "nightly", no suites are defined in .pyrobench.yaml`)
+
+	c, err = Parse(strings.NewReader("sample_types: [cpu, widgets]\n"))
+	require.NoError(t, err)
+	require.Equal(t, []string{"cpu", "widgets"}, c.SampleTypes)
+}
+
+func TestParsePackages(t *testing.T) {
+	c, err := Parse(strings.NewReader(`
+packages:
+  example.com/m/storage/...:
+    env:
+      TESTDATA_DIR: testdata
+    setup: docker compose up -d
+    teardown: docker compose down
+    workdir: .
+    fixtures: [testdata/large]
+    services:
+      - redis:7
+      - image: docker.io/library/postgres:16
+        env:
+          POSTGRES_PASSWORD: secret
+      - image: ghcr.io/my-org/queue@sha256:abcd
+        name: MQ
+  example.com/m/cache:
+`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"example.com/m/cache", "example.com/m/storage/..."}, c.PackageGlobs())
+	require.Equal(t, &Package{
+		Env:      map[string]string{"TESTDATA_DIR": "testdata"},
+		Setup:    "docker compose up -d",
+		Teardown: "docker compose down",
+		WorkDir:  ".",
+		Fixtures: []string{"testdata/large"},
+		Services: []Service{
+			{Image: "redis:7"},
+			{Image: "docker.io/library/postgres:16", Env: map[string]string{"POSTGRES_PASSWORD": "secret"}},
+			{Image: "ghcr.io/my-org/queue@sha256:abcd", Name: "MQ"},
+		},
+	}, c.Packages["example.com/m/storage/..."])
+	services := c.Packages["example.com/m/storage/..."].Services
+	require.Equal(t, "REDIS", services[0].EnvName())
+	require.Equal(t, "POSTGRES", services[1].EnvName())
+	require.Equal(t, "MQ", services[2].EnvName())
+	require.Equal(t, &Package{}, c.Packages["example.com/m/cache"])
+}
+
+func TestPathSuites(t *testing.T) {
+	c, err := Parse(strings.NewReader(`
+suites:
+  storage:
+  index:
+  proto:
+paths:
+  pkg/storage/...: [storage]
+  pkg/*/index/...: [index, storage]
+  api/*.proto: [proto]
+`))
+	require.NoError(t, err)
+
+	suites, unmatched := c.PathSuites([]string{
+		"pkg/storage/block.go",
+		"pkg/ingester/index/postings.go",
+		"api/push.proto",
+		"pkg/querier/querier.go",
+		"README.md",
+	})
+	require.Equal(t, []string{"index", "proto", "storage"}, suites)
+	require.Equal(t, []string{"pkg/querier/querier.go", "README.md"}, unmatched)
+
+	suites, unmatched = c.PathSuites(nil)
+	require.Empty(t, suites)
+	require.Empty(t, unmatched)
+}
+
+func TestMatchPath(t *testing.T) {
+	for _, tc := range []struct {
+		glob, file string
+		match      bool
+	}{
+		{"pkg/storage/...", "pkg/storage/block.go", true},
+		{"pkg/storage/...", "pkg/storage/tsdb/index.go", true},
+		{"pkg/storage/...", "pkg/storagex/block.go", false},
+		{"pkg/storage/...", "pkg/storage", false},
+		{"./...", "main.go", true},
+		{"pkg/*/index/...", "pkg/ingester/index/postings.go", true},
+		{"pkg/storage/*", "pkg/storage/block.go", true},
+		{"pkg/storage/*", "pkg/storage/tsdb/index.go", false},
+		{"*.go", "main.go", true},
+		{"*.go", "pkg/main.go", false},
+	} {
+		require.Equal(t, tc.match, MatchPath(tc.glob, tc.file), "%s %s", tc.glob, tc.file)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for config, expectedErr := range map[string]string{
+		"suites:\n  quick:\n    bnech: Get\n":                             "field bnech not found",
+		"suites:\n  quick:\n    bench: Get[\n":                            "suite quick: invalid bench regex",
+		"suites:\n  quick:\n    count: -1\n":                              "suite quick: invalid count -1",
+		"suites:\n  quick:\n    time: 5\n":                                `suite quick: invalid time "5"`,
+		"suites:\n  quick:\n    packages: [\"example.com/[\"]\n":          `suite quick: invalid package glob "example.com/["`,
+		"packages:\n  example.com/[:\n    setup: make\n":                  "package example.com/[: invalid package glob",
+		"packages:\n  example.com/m:\n    env:\n      A=B: c\n":           `package example.com/m: invalid environment variable "A=B"`,
+		"packages:\n  example.com/m:\n    workdir: ../x\n":                `package example.com/m: invalid workdir "../x"`,
+		"packages:\n  example.com/m:\n    workdir: /tmp\n":                `package example.com/m: invalid workdir "/tmp"`,
+		"packages:\n  example.com/m:\n    fixtures: [.]\n":                `package example.com/m: invalid fixture "."`,
+		"packages:\n  example.com/m:\n    fixtures: [a/../..]\n":          `package example.com/m: invalid fixture "a/../.."`,
+		"packages:\n  example.com/m:\n    services: [\"\"]\n":             `package example.com/m: invalid service image ""`,
+		"packages:\n  example.com/m:\n    services: [9p:1]\n":             `package example.com/m: invalid name "9P" of service 9p:1`,
+		"packages:\n  example.com/m:\n    services: [redis:6, redis:7]\n": "package example.com/m: duplicate service name REDIS",
+		"limits:\n  max_time: 1h1\n":                                      `limits: invalid max_time "1h1"`,
+		"limits:\n  max_iterations: -1\n":                                 "limits: invalid max_iterations -1",
+		"limits:\n  test_flags: [-db.url=x]\n":                            `limits: invalid test flag "-db.url=x"`,
+		"suites:\n  quick:\n    flags: [-test.count=1]\n":                 "suite quick: flag -test.count is not allowed",
+		"packages:\n  example.com/m:\n    flags: [\"db url\"]\n":          `package example.com/m: invalid flag "db url"`,
+		"policy:\n  daily_budget: 1d\n":                                   `policy: invalid daily_budget "1d"`,
+		"policy:\n  approval_above: -1m\n":                                `policy: invalid approval_above "-1m"`,
+		"paths:\n  pkg/[/...: [quick]\n":                                  "path pkg/[/...: invalid path glob",
+		"paths:\n  ../pkg/...: [quick]\n":                                 "path ../pkg/...: invalid path glob",
+		"paths:\n  pkg/...: []\n":                                         "path pkg/...: no suites given",
+		"paths:\n  pkg/...: [quick]\n":                                    `path pkg/...: unknown suite "quick"`,
+		"sample_types: [cpu, \"\"]\n":                                     "sample_types: empty sample type",
+	} {
+		_, err := Parse(strings.NewReader(config))
+		require.ErrorContains(t, err, expectedErr, config)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	var defaults Limits
+	require.NoError(t, defaults.CheckBenchTime("2s"))
+	require.NoError(t, defaults.CheckBenchTime("60s"))
+	require.NoError(t, defaults.CheckBenchTime("1000x"))
+	require.EqualError(t, defaults.CheckBenchTime("61s"), "time 61s exceeds the maximum of 1m0s")
+	require.EqualError(t, defaults.CheckBenchTime("1000001x"), "time 1000001x exceeds the maximum of 1000000x")
+	require.EqualError(t, defaults.CheckBenchTime("-1s"), `invalid time "-1s", expected a duration like 2s or a number of iterations like 100x`)
+	require.EqualError(t, defaults.CheckBenchTime("x"), `invalid time "x", expected a duration like 2s or a number of iterations like 100x`)
+
+	c, err := Parse(strings.NewReader("limits:\n  max_time: 10s\n  max_iterations: 100\n"))
+	require.NoError(t, err)
+	require.NoError(t, c.Limits.CheckBenchTime("10s"))
+	require.NoError(t, c.Limits.CheckBenchTime("100x"))
+	require.EqualError(t, c.Limits.CheckBenchTime("11s"), "time 11s exceeds the maximum of 10s")
+	require.EqualError(t, c.Limits.CheckBenchTime("101x"), "time 101x exceeds the maximum of 100x")
+}
+
+func TestTestFlags(t *testing.T) {
+	var defaults Limits
+	require.NoError(t, defaults.CheckTestFlag("-test.short"))
+	require.NoError(t, defaults.CheckTestFlag("--test.timeout=5m"))
+	require.EqualError(t, defaults.CheckTestFlag("-test.cpuprofile=/etc/passwd"), "flag -test.cpuprofile is not allowed, only -test.short and -test.timeout of the testing package are")
+	require.EqualError(t, defaults.CheckTestFlag("-db.fixtures=testdata"), "flag -db.fixtures is not allowed, add it to the test_flags of the limits")
+	require.EqualError(t, defaults.CheckTestFlag("db.fixtures"), `invalid flag "db.fixtures", expected -name or -name=value`)
+
+	c, err := Parse(strings.NewReader("limits:\n  test_flags: [--db.fixtures]\n"))
+	require.NoError(t, err)
+	require.NoError(t, c.Limits.CheckTestFlag("-db.fixtures=testdata"))
+	require.NoError(t, c.Limits.CheckTestFlag("-test.short"))
+	require.Error(t, c.Limits.CheckTestFlag("-db.url=x"))
+}
+
+func TestPolicy(t *testing.T) {
+	var defaults Policy
+	require.Zero(t, defaults.Budget())
+	require.Zero(t, defaults.ApprovalThreshold())
+	require.True(t, defaults.MaySetOptions("NONE"))
+	require.True(t, defaults.MayApprove("OWNER"))
+	require.False(t, defaults.MayApprove("CONTRIBUTOR"))
+	require.Equal(t, DefaultApprovers, defaults.ApproverAssociations())
+
+	c, err := Parse(strings.NewReader("policy:\n  option_associations: [member, owner]\n  daily_budget: 2h\n  approval_above: 30m\n  approvers: [owner]\n"))
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Hour, c.Policy.Budget())
+	require.Equal(t, 30*time.Minute, c.Policy.ApprovalThreshold())
+	require.True(t, c.Policy.MaySetOptions("MEMBER"))
+	require.False(t, c.Policy.MaySetOptions("CONTRIBUTOR"))
+	require.False(t, c.Policy.MayApprove("MEMBER"))
+	require.True(t, c.Policy.MayApprove("OWNER"))
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() {
+		require.NoError(t, os.Chdir(wd))
+	})
+
+	// the default file is optional
+	c, err := Load(&Args{})
+	require.NoError(t, err)
+	require.Empty(t, c.Suites)
+
+	// an explicit one is not
+	_, err = Load(&Args{Path: "missing.yaml"})
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, FileName), []byte("suites:\n  quick:\n    count: 2\n"), 0o644))
+	c, err = Load(nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"quick"}, c.SuiteNames())
+}
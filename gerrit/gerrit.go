@@ -0,0 +1,221 @@
+// Package gerrit posts benchmark reports as review messages on Gerrit
+// changes, optionally voting on a label depending on regressions.
+package gerrit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// postTimeout limits how long posting the review may take, it is posted once
+// the benchmarks have finished.
+const postTimeout = time.Minute
+
+type Args struct {
+	URL      string // of the Gerrit server, disables the reporter when empty
+	User     string
+	Password string // HTTP password of the user
+
+	Change   string // number or ID of the change
+	Revision string // commit or patch set number, the benchmarked head commit when empty
+
+	Label            string // label to vote on, no vote when empty
+	VoteOnRegression int    // when benchmarks regressed or failed
+	VoteOnSuccess    int    // when all benchmarks completed without regressions
+}
+
+func AddArgs(cmd *kingpin.CmdClause) *Args {
+	args := &Args{}
+	cmd.Flag("gerrit-url", "Post the final report as review message on a Gerrit change of this server.").Envar("GERRIT_URL").PlaceHolder("URL").StringVar(&args.URL)
+	cmd.Flag("gerrit-user", "Gerrit user to post the review as.").Envar("GERRIT_USER").StringVar(&args.User)
+	cmd.Flag("gerrit-password", "HTTP password of the Gerrit user.").Envar("GERRIT_PASSWORD").StringVar(&args.Password)
+	cmd.Flag("gerrit-change", "Number or ID of the Gerrit change to review.").Envar("GERRIT_CHANGE_NUMBER").StringVar(&args.Change)
+	cmd.Flag("gerrit-revision", "Commit or patch set number of the change to review, the benchmarked head commit by default.").Envar("GERRIT_PATCHSET_REVISION").StringVar(&args.Revision)
+	cmd.Flag("gerrit-label", "Vote on this label of the change, e.g. Verified or Code-Review. No vote when empty.").PlaceHolder("LABEL").StringVar(&args.Label)
+	cmd.Flag("gerrit-vote-on-regression", "Vote, when benchmarks regressed by more than the percentage threshold or failed.").Default("-1").IntVar(&args.VoteOnRegression)
+	cmd.Flag("gerrit-vote-on-success", "Vote, when all benchmarks completed without regressions. Interrupted runs, timeouts and benchmarks skipped for the time budget get no vote.").Default("1").IntVar(&args.VoteOnSuccess)
+	return args
+}
+
+// Enabled returns true, when the report should be posted to Gerrit.
+func (a *Args) Enabled() bool {
+	return a != nil && a.URL != ""
+}
+
+func (a *Args) validate() error {
+	if a.Change == "" {
+		return errors.New("--gerrit-change is required to post to Gerrit")
+	}
+	if _, err := url.Parse(a.URL); err != nil {
+		return fmt.Errorf("invalid --gerrit-url: %w", err)
+	}
+	return nil
+}
+
+// reviewInput is the body of the set review request.
+type reviewInput struct {
+	Message string         `json:"message"`
+	Tag     string         `json:"tag,omitempty"`
+	Labels  map[string]int `json:"labels,omitempty"`
+}
+
+// reviewTag marks the messages as posted by automation, so Gerrit can hide
+// them along with other CI messages.
+const reviewTag = "autogenerated:pyrobench"
+
+type gerritReporter struct {
+	args      *Args
+	client    *http.Client
+	logger    log.Logger
+	threshold float64
+
+	ch     <-chan *report.BenchmarkReport
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewReporter returns a reporter, which posts the final report as review
+// message on the change. Messages can not be edited, so intermediate reports
+// are not posted.
+func NewReporter(logger log.Logger, args *Args, reportArgs *report.Args, ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+	if err := args.validate(); err != nil {
+		return nil, err
+	}
+	r := &gerritReporter{
+		args:      args,
+		client:    http.DefaultClient,
+		logger:    log.With(logger, "module", "gerrit-reporter"),
+		threshold: reportArgs.PercentageThreshold,
+		ch:        ch,
+		stopCh:    make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r, nil
+}
+
+func (r *gerritReporter) run() {
+	defer r.wg.Done()
+
+	var lastReport *report.BenchmarkReport
+	defer func() {
+		if lastReport == nil {
+			return
+		}
+		// an incomplete report has been interrupted, which review accounts for
+		ctx, cancel := context.WithTimeout(context.Background(), postTimeout)
+		defer cancel()
+		if err := r.postReview(ctx, lastReport); err != nil {
+			level.Warn(r.logger).Log("msg", "failed to post review", "change", r.args.Change, "err", err)
+		}
+	}()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case re, ok := <-r.ch:
+			if !ok {
+				return
+			}
+			if re != nil {
+				lastReport = re
+			}
+		}
+	}
+}
+
+func (r *gerritReporter) Stop() error {
+	close(r.stopCh)
+	r.wg.Wait()
+	return nil
+}
+
+// review returns the review of the last report. Failures and regressions
+// are voted on right away, success only once all benchmarks completed.
+func (r *gerritReporter) review(re *report.BenchmarkReport) *reviewInput {
+	in := &reviewInput{
+		Message: Message(re, r.threshold),
+		Tag:     reviewTag,
+	}
+	if r.args.Label == "" || (len(re.Runs) == 0 && re.Error == nil) || r.revision(re) == "" {
+		return in
+	}
+	switch {
+	case re.Error != nil || len(re.Regressions(r.threshold)) > 0:
+		in.Labels = map[string]int{r.args.Label: r.args.VoteOnRegression}
+	case re.Complete():
+		in.Labels = map[string]int{r.args.Label: r.args.VoteOnSuccess}
+	}
+	return in
+}
+
+// revision returns the revision to review, the head commit that has been
+// benchmarked unless given explicitly. It is empty, when the head commit is
+// not known.
+func (r *gerritReporter) revision(re *report.BenchmarkReport) string {
+	if r.args.Revision != "" {
+		return r.args.Revision
+	}
+	return re.HeadRef
+}
+
+func (r *gerritReporter) reviewURL(re *report.BenchmarkReport) string {
+	revision := r.revision(re)
+	if revision == "" {
+		// the message still belongs to the change, e.g. when checking out failed
+		revision = "current"
+	}
+	prefix := ""
+	if r.args.User != "" {
+		// authenticated requests are prefixed
+		prefix = "/a"
+	}
+	return fmt.Sprintf("%s%s/changes/%s/revisions/%s/review",
+		strings.TrimSuffix(r.args.URL, "/"),
+		prefix,
+		url.PathEscape(r.args.Change),
+		url.PathEscape(revision),
+	)
+}
+
+func (r *gerritReporter) postReview(ctx context.Context, re *report.BenchmarkReport) error {
+	body, err := json.Marshal(r.review(re))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.reviewURL(re), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("user-agent", "pyrobench")
+	if r.args.User != "" {
+		req.SetBasicAuth(r.args.User, r.args.Password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	level.Info(r.logger).Log("msg", "posted review", "change", r.args.Change)
+	return nil
+}
@@ -0,0 +1,156 @@
+package gerrit
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func testReport() *report.BenchmarkReport {
+	return &report.BenchmarkReport{
+		BaseRef:   "aaaa",
+		HeadRef:   "bbbb",
+		Finished:  true,
+		Completed: true,
+		Runs: []report.BenchmarkRun{{
+			Name: "example.com/m/a.BenchmarkA",
+			Results: []report.BenchmarkResult{{
+				Name:      "cpu",
+				BaseValue: report.BenchmarkValue{ProfileValue: 100, FlamegraphKey: "base"},
+				HeadValue: report.BenchmarkValue{ProfileValue: 120, FlamegraphKey: "head"},
+			}},
+		}, {
+			Name: "example.com/m/a.BenchmarkB",
+			Results: []report.BenchmarkResult{{
+				Name:      "cpu",
+				BaseValue: report.BenchmarkValue{ProfileValue: 100, FlamegraphKey: "base"},
+				HeadValue: report.BenchmarkValue{ProfileValue: 99, FlamegraphKey: "head"},
+			}},
+		}},
+	}
+}
+
+func TestMessage(t *testing.T) {
+	require.Equal(t, `Benchmark report: aaaa -> bbbb
+
+2 benchmarks compared, 1 regressions above 5 %.
+
+Regressions:
+* example.com/m/a.BenchmarkA: cpu increased by 20 %
+
+Results:
+* example.com/m/a.BenchmarkA (cpu=20 %)
+* example.com/m/a.BenchmarkB (cpu=-1 %)
+`, Message(testReport(), 5))
+
+	require.Equal(t, `Benchmark report
+
+Benchmarks failed: no go.mod
+
+no benchmarks to run
+`, Message(&report.BenchmarkReport{Error: errors.New("no go.mod"), Message: "no benchmarks to run"}, 5))
+
+	re := testReport()
+	re.Runs = append(re.Runs, report.BenchmarkRun{
+		Name:    "example.com/m/a.BenchmarkOld",
+		Removed: true,
+		Results: []report.BenchmarkResult{{
+			Name:      "cpu",
+			BaseValue: report.BenchmarkValue{ProfileValue: 100, FlamegraphKey: "base"},
+		}},
+	})
+	require.Equal(t, `Benchmark report: aaaa -> bbbb
+
+2 benchmarks compared, 1 regressions above 5 %.
+1 benchmarks removed in head ran on base only.
+
+Regressions:
+* example.com/m/a.BenchmarkA: cpu increased by 20 %
+
+Results:
+* example.com/m/a.BenchmarkA (cpu=20 %)
+* example.com/m/a.BenchmarkB (cpu=-1 %)
+* example.com/m/a.BenchmarkOld (removed)
+`, Message(re, 5))
+}
+
+func TestReporter(t *testing.T) {
+	type request struct {
+		path   string
+		user   string
+		review reviewInput
+	}
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in reviewInput
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+		user, _, _ := r.BasicAuth()
+		requests = append(requests, request{path: r.URL.Path, user: user, review: in})
+		_, _ = w.Write([]byte(")]}'\n{}"))
+	}))
+	defer srv.Close()
+
+	post := func(args *Args, threshold float64, reports ...*report.BenchmarkReport) {
+		ch := make(chan *report.BenchmarkReport)
+		r, err := NewReporter(log.NewNopLogger(), args, &report.Args{PercentageThreshold: threshold}, ch)
+		require.NoError(t, err)
+		for _, re := range reports {
+			ch <- re
+		}
+		close(ch)
+		require.NoError(t, r.Stop())
+	}
+
+	// only the final report is posted
+	post(&Args{URL: srv.URL, User: "ci", Password: "secret", Change: "123", Revision: "bbbb", Label: "Verified", VoteOnRegression: -1, VoteOnSuccess: 1}, 5,
+		&report.BenchmarkReport{BaseRef: "aaaa", HeadRef: "bbbb"}, testReport())
+	require.Len(t, requests, 1)
+	require.Equal(t, "/a/changes/123/revisions/bbbb/review", requests[0].path)
+	require.Equal(t, "ci", requests[0].user)
+	require.Equal(t, reviewTag, requests[0].review.Tag)
+	require.Equal(t, map[string]int{"Verified": -1}, requests[0].review.Labels)
+	require.Contains(t, requests[0].review.Message, "1 regressions above 5 %")
+
+	post(&Args{URL: srv.URL, Change: "I8473b95934b5732ac55d26311a706c9c2bde9940", Label: "Verified", VoteOnSuccess: 1}, 50, testReport())
+	require.Len(t, requests, 2)
+	require.Equal(t, "/changes/I8473b95934b5732ac55d26311a706c9c2bde9940/revisions/bbbb/review", requests[1].path, "the benchmarked head is reviewed")
+	require.Equal(t, map[string]int{"Verified": 1}, requests[1].review.Labels)
+
+	// no success vote for incomplete runs
+	interrupted := testReport()
+	interrupted.Completed = false
+	timedOut := testReport()
+	timedOut.Runs[1].TimedOut = true
+	skipped := testReport()
+	skipped.Runs[1].Skipped = true
+	for _, re := range []*report.BenchmarkReport{interrupted, timedOut, skipped} {
+		post(&Args{URL: srv.URL, Change: "123", Label: "Verified", VoteOnSuccess: 1}, 50, re)
+		require.Nil(t, requests[len(requests)-1].review.Labels)
+	}
+	// nor without a known head
+	post(&Args{URL: srv.URL, Change: "123", Label: "Verified", VoteOnRegression: -1}, 5, &report.BenchmarkReport{Error: errors.New("no go.mod")})
+	require.Equal(t, "/changes/123/revisions/current/review", requests[len(requests)-1].path)
+	require.Nil(t, requests[len(requests)-1].review.Labels)
+	requests = requests[:2]
+
+	// no vote without a label or benchmarks
+	post(&Args{URL: srv.URL, Change: "123"}, 5, testReport())
+	post(&Args{URL: srv.URL, Change: "123", Label: "Verified"}, 5, &report.BenchmarkReport{Message: "no benchmarks to run"})
+	require.Len(t, requests, 4)
+	require.Nil(t, requests[2].review.Labels)
+	require.Nil(t, requests[3].review.Labels)
+
+	// nothing is posted without a report
+	post(&Args{URL: srv.URL, Change: "123"}, 5)
+	require.Len(t, requests, 4)
+
+	_, err := NewReporter(log.NewNopLogger(), &Args{URL: srv.URL}, &report.Args{}, nil)
+	require.EqualError(t, err, "--gerrit-change is required to post to Gerrit")
+}
@@ -0,0 +1,60 @@
+package gerrit
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// maxMessageRuns limits the benchmarks listed in the message, review messages
+// are not collapsed by Gerrit.
+const maxMessageRuns = 50
+
+// Message renders the report as plain text review message, which lists the
+// regressions before the results of all benchmarks.
+func Message(re *report.BenchmarkReport, threshold float64) string {
+	var sb strings.Builder
+	sb.WriteString("Benchmark report")
+	if re.BaseRef != "" && re.HeadRef != "" {
+		fmt.Fprintf(&sb, ": %s -> %s", re.BaseRef, re.HeadRef)
+	}
+	sb.WriteString("\n")
+
+	if re.Error != nil {
+		fmt.Fprintf(&sb, "\nBenchmarks failed: %s\n", re.Error)
+	}
+	if re.Message != "" {
+		fmt.Fprintf(&sb, "\n%s\n", re.Message)
+	}
+	if len(re.Runs) == 0 {
+		return sb.String()
+	}
+
+	regressions := re.Regressions(threshold)
+	fmt.Fprintf(&sb, "\n%d benchmarks compared, %d regressions above %s %%.\n", len(re.ComparedRuns()), len(regressions), humanize.CommafWithDigits(threshold, 2))
+	if removed := re.RemovedRuns(); len(removed) > 0 {
+		fmt.Fprintf(&sb, "%d benchmarks removed in head ran on base only.\n", len(removed))
+	}
+	if len(regressions) > 0 {
+		sb.WriteString("\nRegressions:\n")
+		for _, r := range regressions {
+			fmt.Fprintf(&sb, "* %s: %s increased by %s %%\n", r.Run.Name, r.Result.Resource(), humanize.CommafWithDigits(r.Diff, 2))
+			for _, f := range r.Critical {
+				fmt.Fprintf(&sb, "  %s\n", strings.ReplaceAll(f.Markdown(r.Result.Resource()), "`", ""))
+			}
+		}
+	}
+
+	sb.WriteString("\nResults:\n")
+	for i := range re.Runs {
+		if i == maxMessageRuns {
+			fmt.Fprintf(&sb, "* ... and %d more\n", len(re.Runs)-maxMessageRuns)
+			break
+		}
+		fmt.Fprintf(&sb, "* %s %s\n", re.Runs[i].Name, re.Runs[i].Status())
+	}
+	return sb.String()
+}
@@ -0,0 +1,185 @@
+package github
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v63/github"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// Modes of annotating the hot lines added by the change.
+const (
+	lineAnnotationsNone     = "none"
+	lineAnnotationsReview   = "review"
+	lineAnnotationsCheckRun = "check-run"
+)
+
+// maxLineMessages limits the benchmarks described per annotated line.
+const maxLineMessages = 3
+
+// lineAnnotation points to a hot line added by the change.
+type lineAnnotation struct {
+	File     string
+	Line     int
+	Messages []string // one per benchmark, largest share first
+}
+
+func (a *lineAnnotation) message() string {
+	return strings.Join(a.Messages, "\n")
+}
+
+var hunkHeader = regexp.MustCompile(`^@@ -\d+(?:,\d+)? \+(\d+)(?:,\d+)? @@`)
+
+// parseAddedLines returns the line numbers of the new file, which have been
+// added by the unified diff of a file.
+func parseAddedLines(patch string) map[int]bool {
+	lines := make(map[int]bool)
+	var line int
+	for _, l := range strings.Split(patch, "\n") {
+		if m := hunkHeader.FindStringSubmatch(l); m != nil {
+			line, _ = strconv.Atoi(m[1])
+			continue
+		}
+		if line == 0 {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(l, "+"):
+			lines[line] = true
+			line++
+		case strings.HasPrefix(l, "-"), strings.HasPrefix(l, `\`):
+			// removed lines and "\ No newline at end of file"
+		default:
+			line++
+		}
+	}
+	return lines
+}
+
+// addedLines returns the lines of head added since base by file.
+func (gh *githubCommon) addedLines(ctx context.Context, base, head string) (map[string]map[int]bool, error) {
+	cmp, _, err := gh.client.Repositories.CompareCommits(ctx, gh.owner, gh.repo, base, head, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]map[int]bool)
+	for _, f := range cmp.Files {
+		if lines := parseAddedLines(f.GetPatch()); len(lines) > 0 {
+			result[f.GetFilename()] = lines
+		}
+	}
+	return result, nil
+}
+
+// hotAddedLines returns the hot lines of the report's benchmarks, which have
+// been added, the lines with the largest share first.
+func hotAddedLines(re *report.BenchmarkReport, added map[string]map[int]bool, max int) []lineAnnotation {
+	type candidate struct {
+		line    *report.HotLine
+		message string
+	}
+	var candidates []candidate
+	for i := range re.Runs {
+		run := &re.Runs[i]
+		for j := range run.HotLines {
+			l := &run.HotLines[j]
+			if !added[l.File][l.Line] {
+				continue
+			}
+			candidates = append(candidates, candidate{line: l, message: l.Markdown(run.Name)})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].line.Share > candidates[j].line.Share
+	})
+
+	type location struct {
+		file string
+		line int
+	}
+	var result []lineAnnotation
+	index := make(map[location]int)
+	for _, c := range candidates {
+		loc := location{file: c.line.File, line: c.line.Line}
+		idx, ok := index[loc]
+		if !ok {
+			if len(result) == max {
+				continue
+			}
+			idx = len(result)
+			index[loc] = idx
+			result = append(result, lineAnnotation{File: loc.file, Line: loc.line})
+		}
+		if len(result[idx].Messages) < maxLineMessages {
+			result[idx].Messages = append(result[idx].Messages, c.message)
+		}
+	}
+	return result
+}
+
+func hasHotLines(re *report.BenchmarkReport) bool {
+	for i := range re.Runs {
+		if len(re.Runs[i].HotLines) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hotLineAnnotations returns the hot lines of the finished report, which have
+// been added between base and head.
+func (gh *githubCommon) hotLineAnnotations(ctx context.Context, re *report.BenchmarkReport) ([]lineAnnotation, error) {
+	if re.BaseRef == "" || re.HeadRef == "" || gh.maxLineAnnotations <= 0 {
+		return nil, nil
+	}
+	if !hasHotLines(re) {
+		// avoid comparing the commits
+		return nil, nil
+	}
+	added, err := gh.addedLines(ctx, re.BaseRef, re.HeadRef)
+	if err != nil {
+		return nil, err
+	}
+	return hotAddedLines(re, added, gh.maxLineAnnotations), nil
+}
+
+// commentLines comments on the hot lines added by the pull request with a
+// single review, once the report is finished.
+func (gh *gitHubComment) commentLines(ctx context.Context, re *report.BenchmarkReport) error {
+	if !re.Finished || re.Error != nil || re.Help != nil || gh.commented || !gh.features.enabled(featureLineComments) {
+		return nil
+	}
+	annotations, err := gh.hotLineAnnotations(ctx, re)
+	if err != nil {
+		return err
+	}
+	if len(annotations) == 0 {
+		gh.commented = true
+		return nil
+	}
+
+	comments := make([]*github.DraftReviewComment, 0, len(annotations))
+	for i := range annotations {
+		comments = append(comments, &github.DraftReviewComment{
+			Path: github.String(annotations[i].File),
+			Line: github.Int(annotations[i].Line),
+			Side: github.String("RIGHT"),
+			Body: github.String(annotations[i].message()),
+		})
+	}
+	_, _, err = gh.client.PullRequests.CreateReview(ctx, gh.owner, gh.repo, gh.pr, &github.PullRequestReviewRequest{
+		CommitID: github.String(re.HeadRef),
+		Body:     github.String("Added lines with the largest share of the benchmarks' CPU time or allocated memory, in functions which got more expensive."),
+		Event:    github.String(reviewComment),
+		Comments: comments,
+	})
+	if err == nil {
+		gh.commented = true
+	}
+	return gh.features.check(gh.logger, featureLineComments, err)
+}
@@ -0,0 +1,118 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestParseAddedLines(t *testing.T) {
+	patch := `@@ -1,4 +1,5 @@
+ package a
+-func f() {}
++func f() {
++	g()
++}
+ 
+@@ -20,2 +21,3 @@ func h() {
+ 	x := 1
++	y := 2
+\ No newline at end of file`
+	require.Equal(t, map[int]bool{2: true, 3: true, 4: true, 22: true}, parseAddedLines(patch))
+	require.Empty(t, parseAddedLines(""))
+}
+
+func testHotLineReport() *report.BenchmarkReport {
+	return &report.BenchmarkReport{
+		BaseRef:  "ab00",
+		HeadRef:  "ef00",
+		Finished: true,
+		Runs: []report.BenchmarkRun{
+			{
+				Name: "pkg.BenchA",
+				HotLines: []report.HotLine{
+					{Resource: "cpu", Unit: "ns", File: "pkg/a.go", Line: 3, Function: "pkg.f", Value: 2e9, Share: 40, Increase: 1e9},
+					{Resource: "cpu", Unit: "ns", File: "pkg/a.go", Line: 10, Function: "pkg.f", Value: 3e9, Share: 60, Increase: 1e9},
+				},
+			},
+			{
+				Name: "pkg.BenchB",
+				HotLines: []report.HotLine{
+					{Resource: "alloc_space", Unit: "bytes", File: "pkg/a.go", Line: 3, Function: "pkg.f", Value: 1024, Share: 10, Increase: 512},
+					{Resource: "cpu", Unit: "ns", File: "pkg/b.go", Line: 7, Function: "pkg.g", Value: 1e9, Share: 20, Increase: 1e8},
+				},
+			},
+		},
+	}
+}
+
+func TestHotAddedLines(t *testing.T) {
+	added := map[string]map[int]bool{
+		"pkg/a.go": {3: true},
+		"pkg/b.go": {7: true},
+	}
+	require.Equal(t, []lineAnnotation{
+		{File: "pkg/a.go", Line: 3, Messages: []string{
+			"40 % of the CPU time of `pkg.BenchA` (2 s) is spent on this line of `pkg.f`, whose flat CPU time increased by 1 s.",
+			"10 % of the allocated memory of `pkg.BenchB` (1.0 KiB) is spent on this line of `pkg.f`, whose flat allocated memory increased by 512 B.",
+		}},
+		{File: "pkg/b.go", Line: 7, Messages: []string{
+			"20 % of the CPU time of `pkg.BenchB` (1 s) is spent on this line of `pkg.g`, whose flat CPU time increased by 100 ms.",
+		}},
+	}, hotAddedLines(testHotLineReport(), added, 10))
+
+	// limited to the lines with the largest share
+	require.Len(t, hotAddedLines(testHotLineReport(), added, 1), 1)
+	require.Empty(t, hotAddedLines(testHotLineReport(), nil, 10))
+}
+
+func TestCommentLines(t *testing.T) {
+	var reviews []submittedReview
+	gh := testCommentReporter(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/my-org/my-repo/compare/ab00...ef00":
+			_, _ = w.Write([]byte(`{"files":[{"filename":"pkg/b.go","patch":"@@ -6,1 +6,2 @@\n x\n+y"}]}`))
+		case "/repos/my-org/my-repo/pulls/1/reviews":
+			var review submittedReview
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&review))
+			reviews = append(reviews, review)
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	gh.maxLineAnnotations = 10
+
+	// not finished yet
+	re := testHotLineReport()
+	re.Finished = false
+	require.NoError(t, gh.commentLines(context.Background(), re))
+	require.Empty(t, reviews)
+
+	// the lines are only commented on once
+	for i := 0; i < 2; i++ {
+		require.NoError(t, gh.commentLines(context.Background(), testHotLineReport()))
+	}
+	require.Len(t, reviews, 1)
+	require.Equal(t, reviewComment, reviews[0].Event)
+	require.Equal(t, "ef00", reviews[0].CommitID)
+	require.Len(t, reviews[0].Comments, 1)
+	require.Equal(t, "pkg/b.go", reviews[0].Comments[0].Path)
+	require.Equal(t, 7, reviews[0].Comments[0].Line)
+	require.Equal(t, "RIGHT", reviews[0].Comments[0].Side)
+}
+
+type submittedReview struct {
+	CommitID string `json:"commit_id"`
+	Event    string `json:"event"`
+	Comments []struct {
+		Path string `json:"path"`
+		Line int    `json:"line"`
+		Side string `json:"side"`
+	} `json:"comments"`
+}
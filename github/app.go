@@ -0,0 +1,190 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/google/go-github/v63/github"
+)
+
+type AppArgs struct {
+	ID             int64
+	PrivateKeyPath string
+}
+
+func AddAppArgs(cmd *kingpin.CmdClause) *AppArgs {
+	args := &AppArgs{}
+	cmd.Flag("github-app-id", "ID of the GitHub App to authenticate as.").Envar("PYROBENCH_GITHUB_APP_ID").Required().Int64Var(&args.ID)
+	cmd.Flag("github-app-private-key", "Path of the PEM encoded private key of the GitHub App.").Envar("PYROBENCH_GITHUB_APP_PRIVATE_KEY").Required().ExistingFileVar(&args.PrivateKeyPath)
+	return args
+}
+
+// App authenticates as GitHub App and hands out tokens of its installations,
+// which replace the GITHUB_TOKEN of a workflow.
+type App struct {
+	id     int64
+	key    *rsa.PrivateKey
+	client *github.Client
+	now    func() time.Time
+}
+
+func NewApp(args *AppArgs) (*App, error) {
+	data, err := os.ReadFile(args.PrivateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key of the GitHub App: %w", err)
+	}
+	return &App{
+		id:     args.ID,
+		key:    key,
+		client: github.NewClient(nil),
+		now:    time.Now,
+	}, nil
+}
+
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T, expected RSA", key)
+	}
+	return rsaKey, nil
+}
+
+// jwt returns the token authenticating as the app itself, which is valid
+// for a few minutes.
+func (a *App) jwt() (string, error) {
+	now := a.now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]any{
+		// allow for clock drift
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.id, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// installationPermissions are the permissions the comment hook needs: reading
+// the code, commenting, reviewing and reporting check runs.
+func installationPermissions() *github.InstallationPermissions {
+	return &github.InstallationPermissions{
+		Contents:     github.String("read"),
+		Issues:       github.String("write"),
+		PullRequests: github.String("write"),
+		Checks:       github.String("write"),
+	}
+}
+
+// InstallationToken returns a token of the installation, valid for an hour.
+// It is scoped to the repository owner/repo and the permissions of the
+// comment hook, so the code benchmarked can not reach other repositories of
+// the installation, even if it gets hold of the token.
+func (a *App) InstallationToken(ctx context.Context, installationID int64, repository string) (string, error) {
+	_, name, ok := strings.Cut(repository, "/")
+	if !ok || name == "" {
+		return "", fmt.Errorf("invalid repository %q, expected owner/repo", repository)
+	}
+	jwt, err := a.jwt()
+	if err != nil {
+		return "", err
+	}
+	token, _, err := a.client.WithAuthToken(jwt).Apps.CreateInstallationToken(ctx, installationID, &github.InstallationTokenOptions{
+		Repositories: []string{name},
+		Permissions:  installationPermissions(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create token of installation %d: %w", installationID, err)
+	}
+	return token.GetToken(), nil
+}
+
+// WebhookEvent is a webhook delivered to a GitHub App.
+type WebhookEvent struct {
+	Name         string // of the X-GitHub-Event header
+	Action       string
+	Repository   string // owner/repo
+	Installation int64
+	PullRequest  bool   // whether the commented issue is a pull request
+	Comment      string // body of the comment
+	Context      string // GITHUB_CONTEXT of a workflow triggered by the event
+}
+
+// ParseWebhookEvent parses the payload of a webhook. The context of the
+// event is equivalent to the one of an Actions workflow it would trigger.
+func ParseWebhookEvent(name string, payload []byte) (*WebhookEvent, error) {
+	var p struct {
+		Action     string `json:"action"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		Installation struct {
+			ID int64 `json:"id"`
+		} `json:"installation"`
+		Issue struct {
+			PullRequest *struct{} `json:"pull_request"`
+		} `json:"issue"`
+		Comment struct {
+			Body string `json:"body"`
+		} `json:"comment"`
+	}
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+	ghContext, err := json.Marshal(struct {
+		Repository string          `json:"repository"`
+		EventName  string          `json:"event_name"`
+		Event      json.RawMessage `json:"event"`
+	}{p.Repository.FullName, name, payload})
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookEvent{
+		Name:         name,
+		Action:       p.Action,
+		Repository:   p.Repository.FullName,
+		Installation: p.Installation.ID,
+		PullRequest:  p.Issue.PullRequest != nil,
+		Comment:      p.Comment.Body,
+		Context:      string(ghContext),
+	}, nil
+}
@@ -0,0 +1,122 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v63/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppInstallationToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "app.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0o600))
+
+	app, err := NewApp(&AppArgs{ID: 42, PrivateKeyPath: keyPath})
+	require.NoError(t, err)
+	now := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	app.now = func() time.Time { return now }
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/app/installations/7/access_tokens", r.URL.Path)
+		jwt, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		require.True(t, ok)
+		parts := strings.Split(jwt, ".")
+		require.Len(t, parts, 3)
+
+		// signed by the key of the app
+		sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		require.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig))
+
+		data, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, err)
+		var claims struct {
+			IssuedAt  int64  `json:"iat"`
+			ExpiresAt int64  `json:"exp"`
+			Issuer    string `json:"iss"`
+		}
+		require.NoError(t, json.Unmarshal(data, &claims))
+		require.Equal(t, "42", claims.Issuer)
+		require.Equal(t, now.Add(-time.Minute).Unix(), claims.IssuedAt)
+		require.Equal(t, now.Add(9*time.Minute).Unix(), claims.ExpiresAt)
+
+		// scoped to the repository of the event
+		var opts struct {
+			Repositories []string          `json:"repositories"`
+			Permissions  map[string]string `json:"permissions"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&opts))
+		require.Equal(t, []string{"my-repo"}, opts.Repositories)
+		require.Equal(t, map[string]string{"contents": "read", "issues": "write", "pull_requests": "write", "checks": "write"}, opts.Permissions)
+
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"token": "ghs_installation"}`))
+	}))
+	t.Cleanup(srv.Close)
+	baseURL, err := url.Parse(srv.URL + "/")
+	require.NoError(t, err)
+	app.client = github.NewClient(nil)
+	app.client.BaseURL = baseURL
+
+	token, err := app.InstallationToken(context.Background(), 7, "my-org/my-repo")
+	require.NoError(t, err)
+	require.Equal(t, "ghs_installation", token)
+
+	_, err = app.InstallationToken(context.Background(), 7, "my-repo")
+	require.EqualError(t, err, `invalid repository "my-repo", expected owner/repo`)
+}
+
+func TestParseWebhookEvent(t *testing.T) {
+	payload := `{
+		"action": "created",
+		"repository": {"full_name": "my-org/my-repo"},
+		"installation": {"id": 7},
+		"issue": {"number": 1, "pull_request": {"url": "https://api.github.com/repos/my-org/my-repo/pulls/1"}},
+		"comment": {"id": 2, "body": "@pyrobench BenchmarkA", "author_association": "OWNER", "user": {"login": "me"}}
+	}`
+	e, err := ParseWebhookEvent("issue_comment", []byte(payload))
+	require.NoError(t, err)
+	require.Equal(t, "created", e.Action)
+	require.Equal(t, "my-org/my-repo", e.Repository)
+	require.Equal(t, int64(7), e.Installation)
+	require.True(t, e.PullRequest)
+	require.Equal(t, "@pyrobench BenchmarkA", e.Comment)
+
+	// the context is the one of a workflow triggered by the comment
+	h, err := NewCommentHook(context.Background(), nil, &CommentHookArgs{
+		Args:                &Args{Token: "token", Context: e.Context},
+		AllowedAssociations: []string{"owner"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "my-org", h.owner)
+	require.Equal(t, "my-repo", h.repo)
+	require.Equal(t, 1, h.pr)
+	require.Equal(t, int64(2), h.eventCommentID)
+	require.Equal(t, "me", h.author)
+
+	e, err = ParseWebhookEvent("issue_comment", []byte(`{"action": "created", "issue": {"number": 3}}`))
+	require.NoError(t, err)
+	require.False(t, e.PullRequest)
+
+	_, err = ParseWebhookEvent("issue_comment", []byte(`{`))
+	require.Error(t, err)
+}
@@ -0,0 +1,121 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/google/go-github/v63/github"
+)
+
+// cancelPollInterval is how often the pull request is checked for the cancel
+// command and new commits.
+const cancelPollInterval = 30 * time.Second
+
+// SupersededError is the cause of cancelled runs, whose head is outdated by
+// new commits pushed to the pull request.
+type SupersededError struct {
+	HeadSHA string // the pull request's new head
+}
+
+func (e *SupersededError) Error() string {
+	sha := e.HeadSHA
+	if len(sha) > 7 {
+		sha = sha[:7]
+	}
+	return "superseded by " + sha
+}
+
+// Acknowledge reacts to the triggering comment, for commands which are not
+// answered with a comment of their own.
+func (h *CommentHook) Acknowledge(ctx context.Context) error {
+	gh := &gitHubComment{githubCommon: h.githubCommon, logger: h.logger}
+	return gh.react(ctx, "+1")
+}
+
+// WatchCancel returns a context, which gets cancelled once an allowed user
+// comments the cancel command on the pull request or its head moves on from
+// headSHA. Only comments created after the one triggering the hook are
+// considered. An empty headSHA does not track the head.
+func (h *CommentHook) WatchCancel(ctx context.Context, headSHA string) (context.Context, context.CancelFunc) {
+	return h.watchCancel(ctx, cancelPollInterval, headSHA)
+}
+
+func (h *CommentHook) watchCancel(ctx context.Context, interval time.Duration, headSHA string) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	since := h.created
+	if since.IsZero() {
+		since = time.Now()
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			user, err := h.cancelledBy(ctx, since)
+			if err != nil {
+				level.Warn(h.logger).Log("msg", "failed to check for the cancel command", "err", err)
+				continue
+			}
+			if user != "" {
+				level.Info(h.logger).Log("msg", "benchmarks cancelled by comment", "user", user)
+				cancel(fmt.Errorf("cancelled by @%s", user))
+				return
+			}
+			if headSHA == "" {
+				continue
+			}
+			pr, _, err := h.client.PullRequests.Get(ctx, h.owner, h.repo, h.pr)
+			if err != nil {
+				level.Warn(h.logger).Log("msg", "failed to check the head of the pull request", "err", err)
+				continue
+			}
+			if sha := pr.GetHead().GetSHA(); sha != "" && sha != headSHA {
+				level.Info(h.logger).Log("msg", "benchmarks superseded by new commits", "head", headSHA, "new_head", sha)
+				cancel(&SupersededError{HeadSHA: sha})
+				return
+			}
+		}
+	}()
+
+	return ctx, func() { cancel(nil) }
+}
+
+// cancelledBy returns the login of the allowed user, who commented the cancel
+// command after since. It is empty, when nobody did.
+func (h *CommentHook) cancelledBy(ctx context.Context, since time.Time) (string, error) {
+	opts := &github.IssueListCommentsOptions{
+		Since:       &since,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		comments, resp, err := h.client.Issues.ListComments(ctx, h.owner, h.repo, h.pr, opts)
+		if err != nil {
+			return "", err
+		}
+		for _, c := range comments {
+			// since filters by the time of the last update
+			if c.GetID() == h.eventCommentID || !c.GetCreatedAt().After(since) {
+				continue
+			}
+			if !slices.Contains(h.args.AllowedAssociations, strings.ToLower(c.GetAuthorAssociation())) {
+				continue
+			}
+			if _, command, err := parseCommandLine(h.args, strings.NewReader(c.GetBody())); err == nil && command == CommandCancel {
+				return c.GetUser().GetLogin(), nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return "", nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
@@ -0,0 +1,113 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-github/v63/github"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchCancel(t *testing.T) {
+	created := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	comments := `[
+		{"id": 2, "body": "@pyrobench cancel", "author_association": "OWNER", "created_at": "2024-08-01T12:00:00Z", "user": {"login": "trigger"}},
+		{"id": 3, "body": "@pyrobench cancel", "author_association": "OWNER", "created_at": "2024-08-01T11:00:00Z", "updated_at": "2024-08-01T12:01:00Z", "user": {"login": "edited"}},
+		{"id": 4, "body": "@pyrobench cancel", "author_association": "NONE", "created_at": "2024-08-01T12:01:00Z", "user": {"login": "drive-by"}},
+		{"id": 5, "body": "please @pyrobench cancel", "author_association": "MEMBER", "created_at": "2024-08-01T12:02:00Z", "user": {"login": "maintainer"}}
+	]`
+	var since string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/repos/my-org/my-repo/issues/1/comments", r.URL.Path)
+		since = r.URL.Query().Get("since")
+		_, _ = w.Write([]byte(comments))
+	}))
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(srv.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	h := &CommentHook{
+		githubCommon: githubCommon{
+			owner:          "my-org",
+			repo:           "my-repo",
+			pr:             1,
+			eventCommentID: 2,
+			client:         client,
+			features:       newFeatureGate(),
+		},
+		created: created,
+		logger:  log.NewNopLogger(),
+		args: &CommentHookArgs{
+			BotName:             "@pyrobench",
+			AllowedAssociations: []string{"member", "owner"},
+		},
+	}
+
+	ctx, cancel := h.watchCancel(context.Background(), time.Millisecond, "")
+	defer cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context has not been cancelled")
+	}
+	require.EqualError(t, context.Cause(ctx), "cancelled by @maintainer")
+	require.Equal(t, "2024-08-01T12:00:00Z", since)
+
+	// without the command, the context stays alive until it is cancelled
+	comments = `[{"id": 6, "body": "@pyrobench E2E", "author_association": "OWNER", "created_at": "2024-08-01T12:03:00Z"}]`
+	user, err := h.cancelledBy(context.Background(), created)
+	require.NoError(t, err)
+	require.Empty(t, user)
+}
+
+func TestWatchCancelSuperseded(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/my-org/my-repo/issues/1/comments":
+			_, _ = w.Write([]byte(`[]`))
+		case "/repos/my-org/my-repo/pulls/1":
+			_, _ = w.Write([]byte(`{"head": {"sha": "0123456789abcdef"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(srv.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	h := &CommentHook{
+		githubCommon: githubCommon{owner: "my-org", repo: "my-repo", pr: 1, client: client, features: newFeatureGate()},
+		logger:       log.NewNopLogger(),
+		args:         &CommentHookArgs{BotName: "@pyrobench"},
+	}
+
+	ctx, cancel := h.watchCancel(context.Background(), time.Millisecond, "fedcba9876543210")
+	defer cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context has not been cancelled")
+	}
+	var superseded *SupersededError
+	require.True(t, errors.As(context.Cause(ctx), &superseded))
+	require.Equal(t, "0123456789abcdef", superseded.HeadSHA)
+	require.EqualError(t, superseded, "superseded by 0123456")
+
+	// the current head keeps running
+	ctx, cancel = h.watchCancel(context.Background(), time.Millisecond, "0123456789abcdef")
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, ctx.Err())
+	cancel()
+}
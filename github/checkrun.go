@@ -0,0 +1,321 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/google/go-github/v63/github"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+const (
+	checkRunName = "pyrobench"
+
+	// maxCheckRunText is the maximum size of the check run output text
+	// accepted by GitHub.
+	maxCheckRunText = 65535
+
+	// maxCheckRunAnnotations is the maximum number of annotations per API
+	// request.
+	maxCheckRunAnnotations = 50
+)
+
+type gitHubCheckRun struct {
+	githubCommon
+	logger    log.Logger
+	threshold float64
+	template  *template.Template
+
+	checkRunID int64
+
+	ch     <-chan *report.BenchmarkReport
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewCheckRunReporter reports the benchmark results as check run on the head
+// commit. The check run fails, when a benchmark regresses by more than the
+// percentage threshold.
+func NewCheckRunReporter(logger log.Logger, args *Args, reportArgs *report.Args, ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+	ghCommon, _, err := newGitHubRepoCommon(args)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, err := newCustomReportTemplate(reportArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	gh := &gitHubCheckRun{
+		githubCommon: *ghCommon,
+		logger:       log.With(logger, "module", "github-check-run"),
+		threshold:    reportArgs.PercentageThreshold,
+		template:     tmpl,
+		ch:           ch,
+		stopCh:       make(chan struct{}),
+	}
+
+	gh.wg.Add(1)
+	go func() {
+		defer gh.wg.Done()
+		gh.run(context.Background())
+	}()
+
+	return gh, nil
+}
+
+// conclusion returns the conclusion of the finished report. Only completed
+// comparisons succeed, the others are cancelled or neutral, unless they
+// already failed.
+func (gh *gitHubCheckRun) conclusion(re *report.BenchmarkReport) string {
+	switch {
+	case re.Error != nil:
+		return "failure"
+	case re.Interrupted:
+		return "cancelled"
+	case len(re.Runs) == 0:
+		return "neutral"
+	case len(re.Regressions(gh.threshold)) > 0:
+		return "failure"
+	case !re.Complete():
+		// e.g. stopped by a failed compilation, which has not been reported
+		return "neutral"
+	}
+	return "success"
+}
+
+// maxRegressionLines limits the changed lines annotated per regression.
+const maxRegressionLines = 3
+
+// annotations points to the changes, which drove the regressions. Those are
+// the lines added by the change with the largest share of the regressed
+// resource, regressions without any such line point to their benchmark.
+func (gh *gitHubCheckRun) annotations(re *report.BenchmarkReport, added map[string]map[int]bool) []*github.CheckRunAnnotation {
+	var result []*github.CheckRunAnnotation
+	for _, r := range re.Regressions(gh.threshold) {
+		annotationLevel := "warning"
+		message := fmt.Sprintf(
+			"%s increased by %s %% (threshold %s %%)",
+			r.Result.Resource(),
+			humanize.CommafWithDigits(r.Diff, 2),
+			humanize.CommafWithDigits(gh.threshold, 2),
+		)
+		// regressions of critical functions are elevated
+		for _, f := range r.Critical {
+			annotationLevel = "failure"
+			message += "\n" + f.Markdown(r.Result.Resource())
+		}
+		title := fmt.Sprintf("%s regressed", r.Run.Name)
+
+		lines := regressionLines(r, added)
+		if len(lines) == 0 && r.Run.File != "" {
+			result = append(result, &github.CheckRunAnnotation{
+				Path:            github.String(r.Run.File),
+				StartLine:       github.Int(r.Run.Line),
+				EndLine:         github.Int(r.Run.Line),
+				AnnotationLevel: github.String(annotationLevel),
+				Title:           github.String(title),
+				Message:         github.String(message),
+			})
+		}
+		for _, l := range lines {
+			result = append(result, &github.CheckRunAnnotation{
+				Path:            github.String(l.File),
+				StartLine:       github.Int(l.Line),
+				EndLine:         github.Int(l.Line),
+				AnnotationLevel: github.String(annotationLevel),
+				Title:           github.String(title),
+				Message:         github.String(message + "\n" + l.Markdown(r.Run.Name)),
+			})
+		}
+		if len(result) >= maxCheckRunAnnotations {
+			return result[:maxCheckRunAnnotations]
+		}
+	}
+	return result
+}
+
+// regressionLines returns the hot lines of the regressed resource, which
+// have been added, the largest share first.
+func regressionLines(r report.Regression, added map[string]map[int]bool) []*report.HotLine {
+	var lines []*report.HotLine
+	for i := range r.Run.HotLines {
+		l := &r.Run.HotLines[i]
+		if l.Resource == r.Result.Name && added[l.File][l.Line] {
+			lines = append(lines, l)
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Share > lines[j].Share
+	})
+	if len(lines) > maxRegressionLines {
+		lines = lines[:maxRegressionLines]
+	}
+	return lines
+}
+
+// changedLines returns the lines added between base and head, when there are
+// hot lines to annotate. Errors are only logged, the annotations fall back to
+// the benchmarks.
+func (gh *gitHubCheckRun) changedLines(ctx context.Context, re *report.BenchmarkReport) map[string]map[int]bool {
+	if re.BaseRef == "" || re.HeadRef == "" || !hasHotLines(re) {
+		return nil
+	}
+	added, err := gh.addedLines(ctx, re.BaseRef, re.HeadRef)
+	if err != nil {
+		level.Warn(gh.logger).Log("msg", "failed to find the lines added by the change", "err", err)
+		return nil
+	}
+	return added
+}
+
+func (gh *gitHubCheckRun) output(re *report.BenchmarkReport) (*github.CheckRunOutput, error) {
+	text, err := renderReport(gh.template, gh.owner, gh.repo, re)
+	if err != nil {
+		return nil, err
+	}
+	if len(text) > maxCheckRunText {
+		text = strings.ToValidUTF8(text[:maxCheckRunText], "")
+	}
+
+	output := &github.CheckRunOutput{
+		Title: github.String("Benchmark Report"),
+		Text:  github.String(text),
+	}
+
+	summary := "Benchmarks in progress"
+	if re.Finished {
+		regressions := re.Regressions(gh.threshold)
+		summary = fmt.Sprintf("%d benchmarks, %d regressions above %s %%", len(re.Runs), len(regressions), humanize.CommafWithDigits(gh.threshold, 2))
+	}
+	if re.Error != nil {
+		summary = re.Error.Error()
+	}
+	output.Summary = github.String(summary)
+
+	return output, nil
+}
+
+func (gh *gitHubCheckRun) postReport(ctx context.Context, re *report.BenchmarkReport) error {
+	if re.HeadRef == "" || !gh.features.enabled(featureCheckRuns) {
+		// a check run requires the head commit
+		return nil
+	}
+	return gh.features.check(gh.logger, featureCheckRuns, gh.upsertCheckRun(ctx, re))
+}
+
+// addLineAnnotations adds the hot lines added by the change to the
+// annotations of the finished report.
+func (gh *gitHubCheckRun) addLineAnnotations(re *report.BenchmarkReport, added map[string]map[int]bool, output *github.CheckRunOutput) {
+	if re.Error != nil || gh.lineAnnotations != lineAnnotationsCheckRun || gh.maxLineAnnotations <= 0 {
+		return
+	}
+	annotations := hotAddedLines(re, added, gh.maxLineAnnotations)
+	for i := range annotations {
+		if len(output.Annotations) == maxCheckRunAnnotations {
+			break
+		}
+		output.Annotations = append(output.Annotations, &github.CheckRunAnnotation{
+			Path:            github.String(annotations[i].File),
+			StartLine:       github.Int(annotations[i].Line),
+			EndLine:         github.Int(annotations[i].Line),
+			AnnotationLevel: github.String("warning"),
+			Title:           github.String("Hot added line"),
+			Message:         github.String(annotations[i].message()),
+		})
+	}
+}
+
+func (gh *gitHubCheckRun) upsertCheckRun(ctx context.Context, re *report.BenchmarkReport) error {
+	output, err := gh.output(re)
+	if err != nil {
+		return err
+	}
+	if re.Finished {
+		// annotations are accumulated by GitHub, so only add them once
+		added := gh.changedLines(ctx, re)
+		output.Annotations = gh.annotations(re, added)
+		gh.addLineAnnotations(re, added, output)
+	}
+
+	var status, conclusion *string
+	var completedAt *github.Timestamp
+	if re.Finished {
+		status = github.String("completed")
+		conclusion = github.String(gh.conclusion(re))
+		completedAt = &github.Timestamp{Time: time.Now()}
+	} else {
+		status = github.String("in_progress")
+	}
+
+	if gh.checkRunID == 0 {
+		cr, _, err := gh.client.Checks.CreateCheckRun(ctx, gh.owner, gh.repo, github.CreateCheckRunOptions{
+			Name:        checkRunName,
+			HeadSHA:     re.HeadRef,
+			Status:      status,
+			Conclusion:  conclusion,
+			CompletedAt: completedAt,
+			Output:      output,
+		})
+		if err != nil {
+			return err
+		}
+		gh.checkRunID = cr.GetID()
+		return nil
+	}
+
+	_, _, err = gh.client.Checks.UpdateCheckRun(ctx, gh.owner, gh.repo, gh.checkRunID, github.UpdateCheckRunOptions{
+		Name:        checkRunName,
+		Status:      status,
+		Conclusion:  conclusion,
+		CompletedAt: completedAt,
+		Output:      output,
+	})
+	return err
+}
+
+func (gh *gitHubCheckRun) run(ctx context.Context) {
+	var lastReport *report.BenchmarkReport
+	defer func() {
+		// complete the check run if it's not finished
+		if lastReport != nil && !lastReport.Finished {
+			lastReport = lastReport.Copy().WithFinished()
+			if err := gh.postReport(ctx, lastReport); err != nil {
+				level.Warn(gh.logger).Log("msg", "failed to complete check run", "err", err)
+			}
+		}
+	}()
+	for {
+		select {
+		case <-gh.stopCh:
+			return
+		case re, ok := <-gh.ch:
+			if !ok {
+				return
+			}
+			if re == nil {
+				continue
+			}
+			if err := gh.postReport(ctx, re); err != nil {
+				level.Warn(gh.logger).Log("msg", "failed to post check run", "err", err)
+			}
+			lastReport = re
+		}
+	}
+}
+
+func (gh *gitHubCheckRun) Stop() error {
+	close(gh.stopCh)
+	gh.wg.Wait()
+	return nil
+}
@@ -0,0 +1,142 @@
+package github
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestCheckRunConclusion(t *testing.T) {
+	gh := &gitHubCheckRun{threshold: 5}
+
+	result := func(base, head int64) report.BenchmarkResult {
+		return report.BenchmarkResult{
+			Name:      "cpu",
+			Unit:      "ns",
+			BaseValue: report.BenchmarkValue{ProfileValue: base, FlamegraphKey: "base"},
+			HeadValue: report.BenchmarkValue{ProfileValue: head, FlamegraphKey: "head"},
+		}
+	}
+
+	for _, tc := range []struct {
+		name                string
+		r                   *report.BenchmarkReport
+		expectedConclusion  string
+		expectedAnnotations int
+	}{
+		{
+			name:               "error",
+			r:                  &report.BenchmarkReport{Error: errors.New("fatally bad")},
+			expectedConclusion: "failure",
+		},
+		{
+			name:               "no benchmarks",
+			r:                  &report.BenchmarkReport{Message: "no benchmarks to run"},
+			expectedConclusion: "neutral",
+		},
+		{
+			name: "within threshold",
+			r: (&report.BenchmarkReport{Runs: []report.BenchmarkRun{
+				{Name: "pkg1.BenchA", File: "pkg1/a_test.go", Line: 10, Results: []report.BenchmarkResult{result(100, 104)}},
+			}}).WithCompleted(),
+			expectedConclusion: "success",
+		},
+		{
+			name: "interrupted",
+			r: (&report.BenchmarkReport{Runs: []report.BenchmarkRun{
+				{Name: "pkg1.BenchA", File: "pkg1/a_test.go", Line: 10, Results: []report.BenchmarkResult{result(100, 104)}},
+			}}).WithInterrupted(),
+			expectedConclusion: "cancelled",
+		},
+		{
+			// completed by the reporter, e.g. after a failed compilation
+			name: "stopped early",
+			r: (&report.BenchmarkReport{Runs: []report.BenchmarkRun{
+				{Name: "pkg1.BenchA", File: "pkg1/a_test.go", Line: 10, Results: []report.BenchmarkResult{result(100, 104)}},
+				{Name: "pkg1.BenchB", Running: true},
+			}}).WithFinished(),
+			expectedConclusion: "neutral",
+		},
+		{
+			name: "timed out",
+			r: (&report.BenchmarkReport{Runs: []report.BenchmarkRun{
+				{Name: "pkg1.BenchA", File: "pkg1/a_test.go", Line: 10, TimedOut: true, Results: []report.BenchmarkResult{result(100, 104)}},
+			}}).WithCompleted(),
+			expectedConclusion: "neutral",
+		},
+		{
+			name: "regression",
+			r: &report.BenchmarkReport{Runs: []report.BenchmarkRun{
+				{Name: "pkg1.BenchA", File: "pkg1/a_test.go", Line: 10, Results: []report.BenchmarkResult{result(100, 110)}},
+				{Name: "pkg1.BenchB", Results: []report.BenchmarkResult{result(100, 200)}},
+			}},
+			expectedConclusion:  "failure",
+			expectedAnnotations: 1, // BenchB has no known location
+		},
+		{
+			name: "critical function regressed within threshold",
+			r: &report.BenchmarkReport{Runs: []report.BenchmarkRun{
+				{Name: "pkg1.BenchA", File: "pkg1/a_test.go", Line: 10, Results: []report.BenchmarkResult{
+					func() report.BenchmarkResult {
+						r := result(100, 101)
+						r.CriticalFunctions = []report.CriticalFunction{{Name: "pkg1.Hot", Diff: 20}}
+						return r
+					}(),
+				}},
+			}},
+			expectedConclusion:  "failure",
+			expectedAnnotations: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expectedConclusion, gh.conclusion(tc.r))
+			annotations := gh.annotations(tc.r, nil)
+			require.Len(t, annotations, tc.expectedAnnotations)
+			for _, a := range annotations {
+				require.Equal(t, "pkg1/a_test.go", a.GetPath())
+				require.Equal(t, 10, a.GetStartLine())
+			}
+		})
+	}
+}
+
+func TestCheckRunAnnotationsChangedLines(t *testing.T) {
+	gh := &gitHubCheckRun{threshold: 5}
+	re := &report.BenchmarkReport{Runs: []report.BenchmarkRun{{
+		Name: "pkg1.BenchA",
+		File: "pkg1/a_test.go",
+		Line: 10,
+		Results: []report.BenchmarkResult{{
+			Name:      "cpu",
+			Unit:      "ns",
+			BaseValue: report.BenchmarkValue{ProfileValue: 100, FlamegraphKey: "base"},
+			HeadValue: report.BenchmarkValue{ProfileValue: 120, FlamegraphKey: "head"},
+		}},
+		HotLines: []report.HotLine{
+			{Resource: "cpu", Unit: "ns", File: "pkg1/a.go", Line: 5, Function: "pkg1.A", Value: 10, Share: 10, Increase: 5},
+			{Resource: "cpu", Unit: "ns", File: "pkg1/a.go", Line: 7, Function: "pkg1.A", Value: 30, Share: 30, Increase: 5},
+			{Resource: "alloc_space", Unit: "bytes", File: "pkg1/a.go", Line: 9, Function: "pkg1.A", Value: 50, Share: 50, Increase: 5},
+			{Resource: "cpu", Unit: "ns", File: "pkg1/b.go", Line: 3, Function: "pkg1.B", Value: 60, Share: 60, Increase: 5},
+		},
+	}}}
+
+	// the hot lines added by the change drove the regression
+	added := map[string]map[int]bool{"pkg1/a.go": {5: true, 7: true, 9: true}}
+	annotations := gh.annotations(re, added)
+	require.Len(t, annotations, 2)
+	for idx, line := range []int{7, 5} {
+		require.Equal(t, "pkg1/a.go", annotations[idx].GetPath())
+		require.Equal(t, line, annotations[idx].GetStartLine())
+		require.Equal(t, "pkg1.BenchA regressed", annotations[idx].GetTitle())
+		require.Contains(t, annotations[idx].GetMessage(), "cpu increased by 20 %")
+	}
+
+	// without changed hot lines, the benchmark is annotated
+	annotations = gh.annotations(re, map[string]map[int]bool{"pkg1/c.go": {1: true}})
+	require.Len(t, annotations, 1)
+	require.Equal(t, "pkg1/a_test.go", annotations[0].GetPath())
+	require.Equal(t, 10, annotations[0].GetStartLine())
+}
@@ -7,16 +7,33 @@ import (
 	"strings"
 	"sync"
 	"text/template"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
 	"github.com/google/go-github/v63/github"
 	"github.com/grafana/pyrobench/report"
+	"github.com/grafana/pyrobench/report/events"
 )
 
 type Args struct {
 	Token   string
 	Context string
+
+	Reactions       bool   // react to the triggering comment
+	RegressionLabel string // label the PR with this, when it regresses; empty disables labels
+
+	Review             bool   // submit the final report as PR review
+	ReviewOnRegression string // review event used when benchmarks regressed
+	ReviewOnSuccess    string // review event used when no benchmark regressed
+
+	UpdateInterval time.Duration // minimum time between two updates of the comment
+
+	LineAnnotations    string // how to annotate hot added lines, one of none, review or check-run
+	MaxLineAnnotations int    // number of added lines to annotate at most
+
+	DetailsURL  string // of the full report, linked when the comment has to be shortened
+	DetailsGist bool   // upload the full report as gist, when the comment has to be shortened
 }
 
 func addArgs(cmd *kingpin.CmdClause, required bool) *Args {
@@ -29,6 +46,16 @@ func addArgs(cmd *kingpin.CmdClause, required bool) *Args {
 	args := &Args{}
 	f(cmd.Flag("github-context", "Github context to use for the comment hook.").Envar("GITHUB_CONTEXT")).StringVar(&args.Context)
 	f(cmd.Flag("github-token", "Github token for API use.").Envar("GITHUB_TOKEN")).StringVar(&args.Token)
+	cmd.Flag("github-reactions", "React to the comment triggering the benchmarks. Disabled automatically, when the token lacks the permission.").Default("true").BoolVar(&args.Reactions)
+	cmd.Flag("github-regression-label", "Label the pull request with this label, when benchmarks regress. Disabled when empty or the token lacks the permission.").PlaceHolder("LABEL").StringVar(&args.RegressionLabel)
+	cmd.Flag("github-review", "Submit the final report as review of the pull request.").Default("false").BoolVar(&args.Review)
+	cmd.Flag("github-review-on-regression", "Review event to submit, when benchmarks regressed.").Default(reviewRequestChanges).EnumVar(&args.ReviewOnRegression, reviewRequestChanges, reviewComment)
+	cmd.Flag("github-review-on-success", "Review event to submit, when all benchmarks completed without regressions. Interrupted comparisons are submitted as comment.").Default(reviewComment).EnumVar(&args.ReviewOnSuccess, reviewApprove, reviewComment)
+	cmd.Flag("github-line-annotations", "Annotate the lines added by the change, which are responsible for the largest increases of CPU time or allocated memory, either as review comments on the pull request (review) or as annotations of the check run (check-run).").Default(lineAnnotationsNone).EnumVar(&args.LineAnnotations, lineAnnotationsNone, lineAnnotationsReview, lineAnnotationsCheckRun)
+	cmd.Flag("github-max-line-annotations", "Maximum number of added lines to annotate.").Default("10").IntVar(&args.MaxLineAnnotations)
+	cmd.Flag("github-details-url", "URL of the full report, e.g. the uploaded HTML report, linked when the comment has to be shortened to fit the size limit of GitHub.").PlaceHolder("URL").StringVar(&args.DetailsURL)
+	cmd.Flag("github-details-gist", "Upload the full report as secret gist and link it, when the comment has to be shortened to fit the size limit of GitHub. Requires a token allowed to create gists.").Default("false").BoolVar(&args.DetailsGist)
+	cmd.Flag("github-update-interval", "Minimum time between two updates of the comment, intermediate reports are coalesced. Errors and the final report are posted immediately.").Default("10s").DurationVar(&args.UpdateInterval)
 	return args
 }
 
@@ -42,9 +69,13 @@ type githubContext struct {
 	Event      struct {
 		Action  string `json:"action"`
 		Comment struct {
-			ID                int64  `json:"id"`
-			AuthorAssociation string `json:"author_association"`
-			Body              string `json:"body"`
+			ID                int64     `json:"id"`
+			AuthorAssociation string    `json:"author_association"`
+			Body              string    `json:"body"`
+			CreatedAt         time.Time `json:"created_at"`
+			User              struct {
+				Login string `json:"login"`
+			} `json:"user"`
 		} `json:"comment"`
 		Issue struct {
 			Number      int `json:"number"`
@@ -62,15 +93,43 @@ type githubCommon struct {
 	repo           string
 	eventCommentID int64
 
-	client *github.Client
+	client   *github.Client
+	features *featureGate
+
+	regressionLabel    string
+	reviewOnRegression string
+	reviewOnSuccess    string
+	updateInterval     time.Duration
+
+	lineAnnotations    string
+	maxLineAnnotations int
+
+	detailsURL string
 }
 
 func newGitHubCommon(args *Args) (*githubCommon, *githubContext, error) {
+	ghCommon, ghContext, err := newGitHubRepoCommon(args)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ghContext.Event.Issue.PullRequest.URL == "" {
+		return nil, nil, fmt.Errorf("issue is not a pull request")
+	}
+
+	ghCommon.pr = ghContext.Event.Issue.Number
+	ghCommon.eventCommentID = ghContext.Event.Comment.ID
+	return ghCommon, ghContext, nil
+}
+
+// newGitHubRepoCommon only requires the context to identify the repository,
+// it can be used outside of pull request events.
+func newGitHubRepoCommon(args *Args) (*githubCommon, *githubContext, error) {
 	if args.Token == "" {
 		return nil, nil, errors.New("GITHUB_TOKEN is required")
 	}
 
-	if args.Token == "" {
+	if args.Context == "" {
 		return nil, nil, errors.New("GITHUB_CONTEXT is required")
 	}
 
@@ -79,21 +138,40 @@ func newGitHubCommon(args *Args) (*githubCommon, *githubContext, error) {
 		return nil, nil, fmt.Errorf("failed to unmarshal github context: %w", err)
 	}
 
-	if ghContext.Event.Issue.PullRequest.URL == "" {
-		return nil, nil, fmt.Errorf("issue is not a pull request")
-	}
-
 	parts := strings.SplitN(ghContext.Repository, "/", 2)
 	if len(parts) != 2 {
 		return nil, nil, fmt.Errorf("invalid repository: %s", ghContext.Repository)
 	}
 
+	var disabled []string
+	if !args.Reactions {
+		disabled = append(disabled, featureReactions)
+	}
+	if args.RegressionLabel == "" {
+		disabled = append(disabled, featureLabels)
+	}
+	if !args.Review {
+		disabled = append(disabled, featureReviews)
+	}
+	if args.LineAnnotations != lineAnnotationsReview {
+		disabled = append(disabled, featureLineComments)
+	}
+	if !args.DetailsGist {
+		disabled = append(disabled, featureGists)
+	}
+
 	return &githubCommon{
-		pr:             ghContext.Event.Issue.Number,
-		owner:          parts[0],
-		repo:           parts[1],
-		client:         github.NewClient(nil).WithAuthToken(args.Token),
-		eventCommentID: ghContext.Event.Comment.ID,
+		owner:              parts[0],
+		repo:               parts[1],
+		client:             github.NewClient(nil).WithAuthToken(args.Token),
+		features:           newFeatureGate(disabled...),
+		regressionLabel:    args.RegressionLabel,
+		reviewOnRegression: args.ReviewOnRegression,
+		reviewOnSuccess:    args.ReviewOnSuccess,
+		updateInterval:     args.UpdateInterval,
+		lineAnnotations:    args.LineAnnotations,
+		maxLineAnnotations: args.MaxLineAnnotations,
+		detailsURL:         args.DetailsURL,
 	}, &ghContext, nil
 }
 
@@ -106,8 +184,21 @@ type gitHubComment struct {
 	wg       sync.WaitGroup
 	template *template.Template
 
-	commentID int64 // this is a unique identifier for the comment
-	reacted   bool  // have I reacted to source command yet
+	commentID  int64  // this is a unique identifier for the comment
+	commentURL string // of the comment, once created
+	reacted    bool   // have I reacted to source command yet
+	threshold  float64
+	collapse   bool // collapse the runs without significant changes
+	labeled    bool // has the regression label been added
+	reviewed   bool // has the final report been submitted as review
+	commented  bool // have the hot added lines been commented on
+
+	continuationIDs []int64 // comments continuing the report, when it exceeds the size limit
+	maxLength       int     // of a comment body, maxCommentLength when zero
+	gistID          string  // of the gist holding the full report, once uploaded
+
+	signingKey []byte         // key to sign the final report with, nil when disabled
+	events     *events.Writer // receives an event per posted report, nil when disabled
 
 	GitHubCommenter bool
 
@@ -4,16 +4,22 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"path"
 	"regexp"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
+	"github.com/google/go-github/v63/github"
+
+	"github.com/grafana/pyrobench/config"
 	"github.com/grafana/pyrobench/report"
 )
 
@@ -25,8 +31,18 @@ type CommentHookArgs struct {
 }
 
 func AddCommentHookArgs(cmd *kingpin.CmdClause) *CommentHookArgs {
+	return addCommentHookArgs(cmd, AddRequiredArgs(cmd))
+}
+
+// AddAppCommentHookArgs adds the arguments of the comment hook run by a
+// GitHub App, which receives the token and the context with every event.
+func AddAppCommentHookArgs(cmd *kingpin.CmdClause) *CommentHookArgs {
+	return addCommentHookArgs(cmd, AddArgs(cmd))
+}
+
+func addCommentHookArgs(cmd *kingpin.CmdClause, ghArgs *Args) *CommentHookArgs {
 	args := &CommentHookArgs{
-		Args:     AddRequiredArgs(cmd),
+		Args:     ghArgs,
 		Reporter: report.AddArgs(cmd),
 	}
 	cmd.Flag("allowed-associations", "Allowed associations for the comment hook.").Default("collaborator", "contributor", "member", "owner").StringsVar(&args.AllowedAssociations)
@@ -36,7 +52,10 @@ func AddCommentHookArgs(cmd *kingpin.CmdClause) *CommentHookArgs {
 
 type CommentHook struct {
 	githubCommon
-	body string
+	body        string
+	created     time.Time // of the comment triggering the hook
+	author      string    // login of the comment's author
+	association string    // of the comment's author to the repository
 
 	logger log.Logger
 	args   *CommentHookArgs
@@ -65,15 +84,28 @@ func NewCommentHook(ctx context.Context, logger log.Logger, args *CommentHookArg
 		args:   args,
 
 		body:         ghContext.Event.Comment.Body,
+		created:      ghContext.Event.Comment.CreatedAt,
+		author:       ghContext.Event.Comment.User.Login,
+		association:  ghContext.Event.Comment.AuthorAssociation,
 		githubCommon: *ghCommon,
 	}, nil
 
 }
 
+// The options of benchmarks, which are not set by the comment.
+const (
+	DefaultBenchTime  = "2s"
+	DefaultBenchCount = 5
+)
+
 type BenchmarkFilter struct {
-	Regex *Regexp `json:"regex"`
-	Time  *string `json:"time,omitempty"`
-	Count *int    `json:"count,omitempty"`
+	Regex *Regexp  `json:"regex,omitempty"`
+	Suite *string  `json:"suite,omitempty"` // named suite of the configuration file, instead of a regex
+	Path  *string  `json:"path,omitempty"`  // repository relative path, whose changed packages are run instead of a regex
+	Dir   *string  `json:"dir,omitempty"`
+	Time  *string  `json:"time,omitempty"`
+	Count *int     `json:"count,omitempty"`
+	Flags []string `json:"flags,omitempty"` // passed to the test binaries
 }
 
 func BenchmarkFiltersString(b []*BenchmarkFilter) string {
@@ -86,17 +118,27 @@ func BenchmarkFiltersString(b []*BenchmarkFilter) string {
 
 func (b *BenchmarkFilter) String() string {
 	sb := strings.Builder{}
-	if b.Regex == nil {
+	if b.Suite != nil {
+		sb.WriteString(fmt.Sprintf("suite=%s", *b.Suite))
+	} else if b.Path != nil {
+		sb.WriteString(fmt.Sprintf("path=%s", *b.Path))
+	} else if b.Regex == nil {
 		sb.WriteString("nil")
 	} else {
 		sb.WriteString(b.Regex.String())
 	}
+	if b.Dir != nil {
+		sb.WriteString(fmt.Sprintf(" dir=%s", *b.Dir))
+	}
 	if b.Time != nil {
 		sb.WriteString(fmt.Sprintf(" time=%s", *b.Time))
 	}
 	if b.Count != nil {
 		sb.WriteString(fmt.Sprintf(" count=%d", *b.Count))
 	}
+	for _, f := range b.Flags {
+		sb.WriteString(fmt.Sprintf(" flag=%s", f))
+	}
 	return sb.String()
 }
 
@@ -109,26 +151,65 @@ func (r *Regexp) MarshalJSON() ([]byte, error) {
 }
 
 type CommentHookResult struct {
-	Filter []*BenchmarkFilter
-	Base   string
-	Head   string
-	GitURL string
+	Filter  []*BenchmarkFilter
+	Base    string // ref of the base branch
+	BaseSHA string // commit of the base branch the PR is compared to
+	Head    string
+	HeadSHA string
+	GitURL  string // clone URL of the repository the PR targets
+
+	Command string        // given instead of benchmarks, see CommandHelp and friends
+	Invalid *CommandError // the command could not be parsed, reply with the usage instead
+
+	Author      string // login of the user, who requested the benchmarks
+	Association string // of the author to the repository
+	ApprovedBy  string // login of the user, who approved the request, empty when not approved
 }
 
 func (h *CommentHook) ParseBenchmarks(ctx context.Context) (*CommentHookResult, error) {
 
 	// parse the body to see if we need to get active
-	benchmarks, err := parseCommandLine(h.args, strings.NewReader(h.body))
-	if err != nil {
+	benchmarks, command, err := parseCommandLine(h.args, strings.NewReader(h.body))
+	var invalid *CommandError
+	switch {
+	case errors.As(err, &invalid):
+		// the usage lists the benchmarks of the PR, so it is still needed
+		level.Warn(h.logger).Log("msg", "invalid command", "command", invalid.Command, "err", invalid.Err)
+	case err != nil:
 		return nil, fmt.Errorf("failed to parse command line: %w", err)
-	}
-	if len(benchmarks) == 0 {
+	case command == CommandHelp || command == CommandCancel:
+		// the pull request is not needed
+		level.Info(h.logger).Log("msg", "received command", "owner", h.owner, "repo", h.repo, "pr", h.pr, "command", command)
+		return &CommentHookResult{Command: command}, nil
+	case command == CommandList:
+		level.Info(h.logger).Log("msg", "received command", "owner", h.owner, "repo", h.repo, "pr", h.pr, "command", command)
+	case command == CommandApprove:
+		level.Info(h.logger).Log("msg", "received command", "owner", h.owner, "repo", h.repo, "pr", h.pr, "command", command)
+		r, err := h.approvedRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		level.Info(h.logger).Log("msg", "running approved benchmarks", "owner", h.owner, "repo", h.repo, "pr", h.pr, "author", r.Author, "benchmarks", BenchmarkFiltersString(r.Filter))
+		return h.Refresh(ctx, r)
+	case len(benchmarks) == 0:
 		// nothing to do
 		return &CommentHookResult{}, nil
+	default:
+		level.Info(h.logger).Log("msg", "running benchmarks", "owner", h.owner, "repo", h.repo, "pr", h.pr, "benchmarks", BenchmarkFiltersString(benchmarks))
 	}
 
-	level.Info(h.logger).Log("msg", "running benchmarks", "repo", h.owner, "repo", h.repo, "pr", h.args, "benchmarks", BenchmarkFiltersString(benchmarks))
+	return h.Refresh(ctx, &CommentHookResult{
+		Filter:      benchmarks,
+		Command:     command,
+		Invalid:     invalid,
+		Author:      h.author,
+		Association: h.association,
+	})
+}
 
+// Refresh returns the result with the current refs of the pull request, e.g.
+// to rerun the benchmarks after new commits have been pushed.
+func (h *CommentHook) Refresh(ctx context.Context, r *CommentHookResult) (*CommentHookResult, error) {
 	pr, _, err := h.client.PullRequests.Get(ctx, h.owner, h.repo, h.pr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get pull request: %w", err)
@@ -136,20 +217,121 @@ func (h *CommentHook) ParseBenchmarks(ctx context.Context) (*CommentHookResult,
 
 	level.Info(h.logger).Log("msg", "read PRs diff from github api", "owner", h.owner, "repo", h.repo, "pr", h.pr, "base", pr.GetBase().GetRef(), "head", pr.GetHead().GetRef())
 
-	return &CommentHookResult{
-		Filter: benchmarks,
-		Base:   pr.GetBase().GetRef(),
-		Head:   fmt.Sprintf("refs/pull/%d/head", h.pr),
-		GitURL: pr.GetHead().GetRepo().GetCloneURL(),
-	}, nil
+	// the head ref of the PR is also available in the base repository, so
+	// forks do not need to be accessed
+	refreshed := *r
+	refreshed.Base = pr.GetBase().GetRef()
+	refreshed.BaseSHA = pr.GetBase().GetSHA()
+	refreshed.Head = fmt.Sprintf("refs/pull/%d/head", h.pr)
+	refreshed.HeadSHA = pr.GetHead().GetSHA()
+	refreshed.GitURL = pr.GetBase().GetRepo().GetCloneURL()
+	return &refreshed, nil
 }
 
 func (h *CommentHook) Reporter(updateCh <-chan *report.BenchmarkReport) (report.Reporter, error) {
-	return newCommentReporterFromGitHubCommon(h.logger, &h.githubCommon, updateCh)
+	return newCommentReporterFromGitHubCommon(h.logger, &h.githubCommon, h.args.Reporter, updateCh)
+}
+
+// parseDir validates a repository relative directory and returns it in its
+// clean form.
+func parseDir(dir string) (string, error) {
+	return parseRepositoryPath("dir", dir)
+}
+
+func parseRepositoryPath(option, p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("%s must not be empty", option)
+	}
+	if path.IsAbs(p) {
+		return "", fmt.Errorf("%s must be relative to the repository root: %s", option, p)
+	}
+	p = path.Clean(p)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", fmt.Errorf("%s must not leave the repository: %s", option, p)
+	}
+	return p, nil
+}
+
+// parsePath validates a repository relative path, which selects all
+// directories below it with a trailing "/...", and returns it in its clean
+// form.
+func parsePath(p string) (string, error) {
+	dir, recursive := strings.CutSuffix(p, "/...")
+	if p == "..." {
+		dir, recursive = ".", true
+	}
+	dir, err := parseRepositoryPath("path", dir)
+	if err != nil {
+		return "", err
+	}
+	if recursive {
+		return dir + "/...", nil
+	}
+	return dir, nil
+}
+
+// ChangedFiles returns the repository relative paths of the files changed by
+// the pull request, including the previous paths of renamed files. The API
+// lists at most 3000 files.
+func (h *CommentHook) ChangedFiles(ctx context.Context) ([]string, error) {
+	var (
+		files []string
+		opts  = &github.ListOptions{PerPage: 100}
+	)
+	for {
+		page, resp, err := h.client.PullRequests.ListFiles(ctx, h.owner, h.repo, h.pr, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list the changed files of the pull request: %w", err)
+		}
+		for _, f := range page {
+			files = append(files, f.GetFilename())
+			if prev := f.GetPreviousFilename(); prev != "" {
+				files = append(files, prev)
+			}
+		}
+		if resp.NextPage == 0 {
+			return files, nil
+		}
+		opts.Page = resp.NextPage
+	}
 }
 
-func parseCommandLine(args *CommentHookArgs, r io.Reader) ([]*BenchmarkFilter, error) {
-	var result []*BenchmarkFilter
+// BotName returns the name the hook reacts to.
+func (h *CommentHook) BotName() string {
+	return h.args.BotName
+}
+
+// Commands given instead of benchmarks.
+const (
+	CommandHelp    = "help"    // reply with the usage
+	CommandList    = "list"    // reply with the benchmarks of head
+	CommandCancel  = "cancel"  // cancel the benchmarks running for the pull request
+	CommandApprove = "approve" // run the last benchmarks requested on the pull request
+)
+
+var commands = []string{CommandHelp, CommandList, CommandCancel, CommandApprove}
+
+// CommandError is returned for a command, which can not be parsed.
+type CommandError struct {
+	Command string // the line following the bot's name
+	Err     error
+}
+
+func (e *CommandError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// parseCommandLine returns the benchmarks to run or one of the commands
+// instead, which are given on a line of their own.
+func parseCommandLine(args *CommentHookArgs, r io.Reader) ([]*BenchmarkFilter, string, error) {
+	var (
+		result []*BenchmarkFilter
+		cmd    string
+	)
 
 	// go through string line by line
 	scanner := bufio.NewScanner(r)
@@ -160,8 +342,24 @@ func parseCommandLine(args *CommentHookArgs, r io.Reader) ([]*BenchmarkFilter, e
 			continue
 		}
 
-		var current *BenchmarkFilter
-		for _, field := range strings.Fields(scanner.Text()[pos+len(args.BotName):]) {
+		command := strings.TrimSpace(scanner.Text()[pos+len(args.BotName):])
+		invalid := func(err error) error {
+			return &CommandError{Command: command, Err: err}
+		}
+
+		if slices.Contains(commands, command) {
+			if cmd != "" && cmd != command {
+				return nil, "", invalid(fmt.Errorf("command '%s' can not be combined with '%s'", command, cmd))
+			}
+			cmd = command
+			continue
+		}
+
+		var (
+			current *BenchmarkFilter
+			dir     *string // scopes all following benchmarks of the line
+		)
+		for _, field := range strings.Fields(command) {
 			pos := strings.Index(field, "=")
 			if pos < 0 {
 				// new regex
@@ -170,21 +368,56 @@ func parseCommandLine(args *CommentHookArgs, r io.Reader) ([]*BenchmarkFilter, e
 				}
 				re, err := regexp.Compile(field)
 				if err != nil {
-					return nil, fmt.Errorf("failed to compile regex: %w", err)
+					return nil, "", invalid(fmt.Errorf("failed to compile regex: %w", err))
 				}
 
-				current = &BenchmarkFilter{Regex: &Regexp{re}}
+				current = &BenchmarkFilter{Regex: &Regexp{re}, Dir: dir}
+				continue
+			}
+
+			if p := "suite="; strings.HasPrefix(field, p) {
+				// a suite selects benchmarks like a regex
+				if current != nil {
+					result = append(result, current)
+				}
+				name := strings.Clone(field[len(p):])
+				if name == "" {
+					return nil, "", invalid(errors.New("suite must not be empty"))
+				}
+				current = &BenchmarkFilter{Suite: &name, Dir: dir}
+				continue
+			}
+
+			if p := "path="; strings.HasPrefix(field, p) {
+				// the changed packages below a path are selected like a regex
+				if current != nil {
+					result = append(result, current)
+				}
+				pattern, err := parsePath(field[len(p):])
+				if err != nil {
+					return nil, "", invalid(err)
+				}
+				current = &BenchmarkFilter{Path: &pattern, Dir: dir}
+				continue
+			}
+
+			if p := "dir="; strings.HasPrefix(field, p) {
+				d, err := parseDir(field[len(p):])
+				if err != nil {
+					return nil, "", invalid(err)
+				}
+				dir = &d
 				continue
 			}
 
 			if current == nil {
-				return nil, fmt.Errorf("option '%s 'given before benchmark", field)
+				return nil, "", invalid(fmt.Errorf("option '%s 'given before benchmark", field))
 			}
 
 			if p := "count="; strings.HasPrefix(field, p) {
 				count, err := strconv.Atoi(field[len(p):])
 				if err != nil {
-					return nil, fmt.Errorf("failed to parse count: %w", err)
+					return nil, "", invalid(fmt.Errorf("failed to parse count: %w", err))
 				}
 				current.Count = &count
 				continue
@@ -192,21 +425,39 @@ func parseCommandLine(args *CommentHookArgs, r io.Reader) ([]*BenchmarkFilter, e
 
 			if p := "time="; strings.HasPrefix(field, p) {
 				s := strings.Clone(field[len(p):])
+				if err := config.ValidateBenchTime(s); err != nil {
+					return nil, "", invalid(err)
+				}
 				current.Time = &s
 				continue
 			}
 
-			return nil, fmt.Errorf("unknown option: %s", field)
+			if p := "flag="; strings.HasPrefix(field, p) {
+				f := strings.Clone(field[len(p):])
+				if err := config.ValidateTestFlag(f); err != nil {
+					return nil, "", invalid(err)
+				}
+				current.Flags = append(current.Flags, f)
+				continue
+			}
+
+			return nil, "", invalid(fmt.Errorf("unknown option: %s", field))
 
 		}
 		if current != nil {
 			result = append(result, current)
 		}
+		if dir != nil && (current == nil || current.Dir != dir) {
+			return nil, "", invalid(fmt.Errorf("option 'dir=%s' not followed by a benchmark", *dir))
+		}
+	}
+	if cmd != "" && len(result) > 0 {
+		return nil, "", &CommandError{Command: cmd, Err: fmt.Errorf("command '%s' can not be combined with benchmarks", cmd)}
 	}
 	switch err := scanner.Err(); err {
 	case nil:
-		return result, nil
+		return result, cmd, nil
 	default:
-		return nil, fmt.Errorf("failed to read input: %w", err)
+		return nil, "", fmt.Errorf("failed to read input: %w", err)
 	}
 }
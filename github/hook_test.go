@@ -1,10 +1,19 @@
 package github
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+	"testing/iotest"
 
+	"github.com/go-kit/log"
+	"github.com/google/go-github/v63/github"
 	"github.com/stretchr/testify/require"
 )
 
@@ -44,6 +53,31 @@ func TestParseCommandLine(t *testing.T) {
 			line:   "@pyrobench E2E time=5x",
 			result: `[{"regex":"E2E", "time":"5x"}]`,
 		},
+		{
+			name:   "run single benchmark with custom duration",
+			line:   "@pyrobench E2E time=500ms",
+			result: `[{"regex":"E2E", "time":"500ms"}]`,
+		},
+		{
+			name:        "invalid time",
+			line:        "@pyrobench E2E time=5y",
+			expectedErr: `invalid time "5y", expected a duration like 2s or a number of iterations like 100x`,
+		},
+		{
+			name:        "zero iterations",
+			line:        "@pyrobench E2E time=0x",
+			expectedErr: `invalid time "0x"`,
+		},
+		{
+			name:   "run single benchmark with test flags",
+			line:   "@pyrobench E2E flag=-test.short flag=-db.fixtures=testdata",
+			result: `[{"regex":"E2E", "flags":["-test.short","-db.fixtures=testdata"]}]`,
+		},
+		{
+			name:        "flag reserved by pyrobench",
+			line:        "@pyrobench E2E flag=-test.benchtime=1h",
+			expectedErr: "flag -test.benchtime is not allowed",
+		},
 		{
 			name:        "regex error",
 			line:        "@pyrobench E2[E",
@@ -54,6 +88,61 @@ func TestParseCommandLine(t *testing.T) {
 			line:   "@pyrobench E2E\n@pyrobench E4E",
 			result: `[{"regex":"E2E"},{"regex":"E4E"}]`,
 		},
+		{
+			name:   "scope benchmark to directory",
+			line:   "@pyrobench dir=./pkg/storage BenchmarkSeries.*",
+			result: `[{"regex":"BenchmarkSeries.*", "dir":"pkg/storage"}]`,
+		},
+		{
+			name:   "scope multiple benchmarks to directories",
+			line:   "@pyrobench dir=pkg/a BenchA count=2 BenchB dir=pkg/c BenchC",
+			result: `[{"regex":"BenchA", "dir":"pkg/a", "count":2},{"regex":"BenchB", "dir":"pkg/a"},{"regex":"BenchC", "dir":"pkg/c"}]`,
+		},
+		{
+			name:        "dir without benchmark",
+			line:        "@pyrobench BenchA dir=pkg/a",
+			expectedErr: "option 'dir=pkg/a' not followed by a benchmark",
+		},
+		{
+			name:        "dir leaving the repository",
+			line:        "@pyrobench dir=../other BenchA",
+			expectedErr: "dir must not leave the repository: ../other",
+		},
+		{
+			name:   "run suite",
+			line:   "@pyrobench suite=nightly",
+			result: `[{"suite":"nightly"}]`,
+		},
+		{
+			name:   "run suite with custom count next to a benchmark",
+			line:   "@pyrobench suite=nightly count=2 E2E",
+			result: `[{"suite":"nightly", "count":2},{"regex":"E2E"}]`,
+		},
+		{
+			name:        "empty suite",
+			line:        "@pyrobench suite=",
+			expectedErr: "suite must not be empty",
+		},
+		{
+			name:   "run changed packages below a path",
+			line:   "@pyrobench path=./pkg/storage/... count=3",
+			result: `[{"path":"pkg/storage/...", "count":3}]`,
+		},
+		{
+			name:   "run changed packages of a directory",
+			line:   "@pyrobench path=pkg/storage/ E2E",
+			result: `[{"path":"pkg/storage"},{"regex":"E2E"}]`,
+		},
+		{
+			name:   "run changed packages of the repository",
+			line:   "@pyrobench path=./...",
+			result: `[{"path":"./..."}]`,
+		},
+		{
+			name:        "path leaving the repository",
+			line:        "@pyrobench path=../other/...",
+			expectedErr: "path must not leave the repository: ../other",
+		},
 		{
 			name:        "option without benchmark",
 			line:        "@pyrobench count=1",
@@ -61,7 +150,7 @@ func TestParseCommandLine(t *testing.T) {
 		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
-			act, err := parseCommandLine(args, strings.NewReader(tc.line))
+			act, _, err := parseCommandLine(args, strings.NewReader(tc.line))
 
 			if tc.expectedErr != "" {
 				require.ErrorContains(t, err, tc.expectedErr)
@@ -81,3 +170,84 @@ func TestParseCommandLine(t *testing.T) {
 		})
 	}
 }
+
+func TestParseCommandLineInvalid(t *testing.T) {
+	args := &CommentHookArgs{
+		BotName: "@pyrobench",
+	}
+
+	_, _, err := parseCommandLine(args, strings.NewReader("looks good\n@pyrobench  E2E cont=10 \n"))
+	var invalid *CommandError
+	require.True(t, errors.As(err, &invalid))
+	require.Equal(t, "E2E cont=10", invalid.Command)
+	require.EqualError(t, invalid, "unknown option: cont=10")
+
+	// failing to read the comment is not the command's fault
+	_, _, err = parseCommandLine(args, iotest.ErrReader(errors.New("broken")))
+	require.False(t, errors.As(err, &invalid))
+}
+
+func TestParseCommands(t *testing.T) {
+	args := &CommentHookArgs{
+		BotName: "@pyrobench",
+	}
+
+	for _, tc := range []struct {
+		line        string
+		command     string
+		expectedErr string
+	}{
+		{line: "@pyrobench help", command: CommandHelp},
+		{line: "please @pyrobench list ", command: CommandList},
+		{line: "@pyrobench cancel\n@pyrobench cancel", command: CommandCancel},
+		{line: "@pyrobench approve", command: CommandApprove},
+		{line: "@pyrobench help\n@pyrobench list", expectedErr: "command 'list' can not be combined with 'help'"},
+		{line: "@pyrobench cancel\n@pyrobench E2E", expectedErr: "command 'cancel' can not be combined with benchmarks"},
+	} {
+		t.Run(tc.line, func(t *testing.T) {
+			filters, command, err := parseCommandLine(args, strings.NewReader(tc.line))
+			if tc.expectedErr != "" {
+				var invalid *CommandError
+				require.True(t, errors.As(err, &invalid))
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Empty(t, filters)
+			require.Equal(t, tc.command, command)
+		})
+	}
+}
+
+func TestChangedFiles(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/repos/my-org/my-repo/pulls/1/files", r.URL.Path)
+		require.Equal(t, "100", r.URL.Query().Get("per_page"))
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/repos/my-org/my-repo/pulls/1/files?page=2>; rel="next"`, srv.URL))
+			_, _ = w.Write([]byte(`[{"filename": "pkg/storage/block.go"}, {"filename": "pkg/index/new.go", "previous_filename": "pkg/index/old.go"}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"filename": "README.md"}]`))
+	}))
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(srv.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	h := &CommentHook{
+		githubCommon: githubCommon{
+			owner:  "my-org",
+			repo:   "my-repo",
+			pr:     1,
+			client: client,
+		},
+		logger: log.NewNopLogger(),
+	}
+	files, err := h.ChangedFiles(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"pkg/storage/block.go", "pkg/index/new.go", "pkg/index/old.go", "README.md"}, files)
+}
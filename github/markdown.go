@@ -0,0 +1,157 @@
+package github
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// markdownStdout is the path writing the markdown report to stdout.
+const markdownStdout = "-"
+
+// markdownReporter writes the report rendered like the GitHub comment to a
+// file, so external steps can post it themselves.
+type markdownReporter struct {
+	logger     log.Logger
+	template   *template.Template
+	signingKey []byte
+
+	owner, repo string
+	paths       []string
+	stdout      io.Writer
+
+	ch     <-chan *report.BenchmarkReport
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewMarkdownReporter returns a reporter, which (re-)writes the markdown
+// report to the paths on every update. With the path "-" only the final report
+// is written to stdout. The repository linked to is read from
+// $GITHUB_REPOSITORY.
+func NewMarkdownReporter(logger log.Logger, reportArgs *report.Args, ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+	tmpl, err := newCustomReportTemplate(reportArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &markdownReporter{
+		logger:   log.With(logger, "module", "markdown-reporter"),
+		template: tmpl,
+		paths:    reportArgs.MarkdownPaths,
+		stdout:   os.Stdout,
+		ch:       ch,
+		stopCh:   make(chan struct{}),
+	}
+	if reportArgs.SigningKey != "" {
+		r.signingKey = []byte(reportArgs.SigningKey)
+	}
+	if owner, repo, ok := strings.Cut(os.Getenv("GITHUB_REPOSITORY"), "/"); ok {
+		r.owner, r.repo = owner, repo
+	}
+
+	r.wg.Add(1)
+	go r.run()
+
+	return r, nil
+}
+
+// render renders the report like the comment. Final reports are signed, if a
+// signing key is configured.
+func (r *markdownReporter) render(re *report.BenchmarkReport) (string, error) {
+	body, err := renderReport(r.template, r.owner, r.repo, re)
+	if err != nil {
+		return "", err
+	}
+	if r.signingKey != nil && re.Finished {
+		body = report.Sign(r.signingKey, report.Provenance{
+			Repository: r.owner + "/" + r.repo,
+			Head:       re.HeadRef,
+		}, body)
+	}
+	return body, nil
+}
+
+// write writes the report to all paths, to stdout only when final.
+func (r *markdownReporter) write(re *report.BenchmarkReport, final bool) {
+	body, err := r.render(re)
+	if err != nil {
+		level.Warn(r.logger).Log("msg", "failed to render markdown report", "err", err)
+		return
+	}
+	for _, path := range r.paths {
+		if path == markdownStdout {
+			if !final {
+				// stdout is append only, so only the final report is written
+				continue
+			}
+			_, err = fmt.Fprintln(r.stdout, body)
+		} else {
+			err = writeMarkdownFile(path, body)
+		}
+		if err != nil {
+			level.Warn(r.logger).Log("msg", "failed to write markdown report", "path", path, "err", err)
+		}
+	}
+}
+
+// writeMarkdownFile replaces the file at path, so it is never read half
+// written.
+func writeMarkdownFile(path, body string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".pyrobench-report-*.md")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.WriteString(body + "\n"); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (r *markdownReporter) run() {
+	defer r.wg.Done()
+
+	var lastReport *report.BenchmarkReport
+	defer func() {
+		if lastReport == nil {
+			return
+		}
+		r.write(lastReport.Copy().WithFinished(), true)
+	}()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case re, ok := <-r.ch:
+			if !ok {
+				return
+			}
+			if re == nil {
+				continue
+			}
+			lastReport = re
+			r.write(re, false)
+		}
+	}
+}
+
+func (r *markdownReporter) Stop() error {
+	close(r.stopCh)
+	r.wg.Wait()
+	return nil
+}
@@ -0,0 +1,73 @@
+package github
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestMarkdownReporter(t *testing.T) {
+	t.Setenv("GITHUB_REPOSITORY", "my-org/my-repo")
+	path := filepath.Join(t.TempDir(), "report.md")
+
+	ch := make(chan *report.BenchmarkReport)
+	r, err := NewMarkdownReporter(log.NewNopLogger(), &report.Args{MarkdownPaths: []string{path}, SigningKey: "key"}, ch)
+	require.NoError(t, err)
+
+	// intermediate reports are written right away
+	ch <- &report.BenchmarkReport{BaseRef: "abcd", HeadRef: "ef00", Progress: &report.RunProgress{Done: 1, Total: 2}}
+	ch <- nil // wait until the first report has been written
+	body, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "__In progress__")
+	require.NotContains(t, string(body), "pyrobench-signature")
+
+	close(ch)
+	require.NoError(t, r.Stop())
+
+	body, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "__Finished__")
+	require.Contains(t, string(body), "https://github.com/my-org/my-repo/compare/abcd...ef00")
+	p, err := report.Verify([]byte("key"), string(body))
+	require.NoError(t, err)
+	require.Equal(t, "my-org/my-repo", p.Repository)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary files are left behind")
+}
+
+func TestMarkdownReporterStdout(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "report.md")
+	ch := make(chan *report.BenchmarkReport)
+	r, err := NewMarkdownReporter(log.NewNopLogger(), &report.Args{MarkdownPaths: []string{"-", path}}, ch)
+	require.NoError(t, err)
+	stdout := &bytes.Buffer{}
+	r.(*markdownReporter).stdout = stdout
+
+	ch <- &report.BenchmarkReport{BaseRef: "abcd", HeadRef: "ef00"}
+	ch <- &report.BenchmarkReport{BaseRef: "abcd", HeadRef: "ef00"}
+	ch <- nil // wait until the second report has been written
+	require.Empty(t, stdout.String(), "only the final report is printed")
+	body, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "__In progress__")
+
+	close(ch)
+	require.NoError(t, r.Stop())
+
+	require.Equal(t, 1, strings.Count(stdout.String(), "__Finished__"))
+	require.NotContains(t, stdout.String(), "__In progress__")
+	// the file gets the final report as well
+	body, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, stdout.String(), string(body))
+}
@@ -0,0 +1,64 @@
+package github
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// defaultLanguage is the language the report template is written in.
+const defaultLanguage = "en"
+
+// messageCatalogs translate the headers and verdicts of the report template,
+// one JSON object per language mapping the English message to its
+// translation. Numbers and the values of the tables are never translated.
+//
+//go:embed messages/*.json
+var messageCatalogs embed.FS
+
+// Languages returns the languages the report can be rendered in.
+func Languages() []string {
+	langs := []string{defaultLanguage}
+	entries, _ := messageCatalogs.ReadDir("messages")
+	for _, e := range entries {
+		langs = append(langs, strings.TrimSuffix(e.Name(), ".json"))
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+type catalog map[string]string
+
+// loadCatalog returns the messages of the language, nil for the default
+// language.
+func loadCatalog(lang string) (catalog, error) {
+	if lang == "" || lang == defaultLanguage {
+		return nil, nil
+	}
+	data, err := messageCatalogs.ReadFile(path.Join("messages", lang+".json"))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported report language %q, available: %s", lang, strings.Join(Languages(), ", "))
+	}
+	var c catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid message catalog for %q: %w", lang, err)
+	}
+	return c, nil
+}
+
+// translate returns the translation of the message, the message itself if
+// there is none.
+func (c catalog) translate(msg string) string {
+	if t, ok := c[msg]; ok && t != "" {
+		return t
+	}
+	return msg
+}
+
+func (c catalog) funcs() template.FuncMap {
+	return template.FuncMap{"t": c.translate}
+}
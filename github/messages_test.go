@@ -0,0 +1,78 @@
+package github
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestMessageCatalogs(t *testing.T) {
+	require.Equal(t, []string{"de", "en", "es", "fr"}, Languages())
+
+	// every catalog translates every message of the template
+	var messages []string
+	for _, m := range regexp.MustCompile(`\{\{t "([^"]+)"\}\}`).FindAllStringSubmatch(reportTemplate, -1) {
+		messages = append(messages, m[1])
+	}
+	require.NotEmpty(t, messages)
+	for _, lang := range Languages() {
+		c, err := loadCatalog(lang)
+		require.NoError(t, err)
+		if lang == defaultLanguage {
+			require.Nil(t, c)
+			continue
+		}
+		for _, msg := range messages {
+			require.Contains(t, c, msg, "language %s", lang)
+		}
+		require.Len(t, c, len(unique(messages)), "language %s has unused messages", lang)
+	}
+
+	_, err := newReportTemplate("xx")
+	require.ErrorContains(t, err, `unsupported report language "xx", available: de, en, es, fr`)
+}
+
+func unique(s []string) map[string]struct{} {
+	m := make(map[string]struct{}, len(s))
+	for _, v := range s {
+		m[v] = struct{}{}
+	}
+	return m
+}
+
+func TestGithubCommentLanguage(t *testing.T) {
+	tmpl, err := newReportTemplate("de")
+	require.NoError(t, err)
+
+	body, err := renderReport(tmpl, "my-org", "my-repo", &report.BenchmarkReport{
+		Finished: true,
+		Runs: []report.BenchmarkRun{
+			{
+				Name: "pkg1.BenchTestA",
+				Results: []report.BenchmarkResult{
+					{
+						Name:      "cpu",
+						Unit:      "ns",
+						BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+						HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, `### Benchmark-Bericht
+
+__Abgeschlossen__ — 1 Regression
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Ressource | Basis | Head | Diff. % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+</details>
+`, body)
+}
@@ -0,0 +1,96 @@
+package github
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/google/go-github/v63/github"
+)
+
+// Optional features, which require more permissions than writing comments on
+// issues.
+const (
+	featureReactions    = "reactions"
+	featureLabels       = "labels"
+	featureCheckRuns    = "check runs"
+	featureReviews      = "reviews"
+	featureLineComments = "line comments"
+	featureGists        = "gists"
+)
+
+// featurePermissions lists the token permission required by each optional
+// feature.
+var featurePermissions = map[string]string{
+	featureReactions:    "issues: write",
+	featureLabels:       "issues: write, pull-requests: write",
+	featureCheckRuns:    "checks: write",
+	featureReviews:      "pull-requests: write",
+	featureLineComments: "pull-requests: write",
+	featureGists:        "gist (not available to GITHUB_TOKEN)",
+}
+
+// isPermissionError returns true if the GitHub API rejected the request
+// because the token lacks the permission.
+func isPermissionError(err error) bool {
+	var errResp *github.ErrorResponse
+	if !errors.As(err, &errResp) || errResp.Response == nil {
+		return false
+	}
+	switch errResp.Response.StatusCode {
+	case http.StatusForbidden:
+		return true
+	case http.StatusNotFound:
+		// some endpoints hide resources the token can't access
+		return errResp.Message == "Resource not accessible by integration"
+	}
+	return false
+}
+
+// featureGate disables optional features, once the token turns out to lack
+// their permission, so the remaining reporting continues to work.
+type featureGate struct {
+	mtx      sync.Mutex
+	disabled map[string]bool
+}
+
+func newFeatureGate(disabled ...string) *featureGate {
+	g := &featureGate{disabled: make(map[string]bool)}
+	for _, f := range disabled {
+		g.disabled[f] = true
+	}
+	return g
+}
+
+func (g *featureGate) enabled(feature string) bool {
+	if g == nil {
+		return true
+	}
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	return !g.disabled[feature]
+}
+
+// check inspects the error of an API call made for the feature. Permission
+// errors disable the feature and are logged only once, other errors are
+// returned.
+func (g *featureGate) check(logger log.Logger, feature string, err error) error {
+	if g == nil || err == nil || !isPermissionError(err) {
+		return err
+	}
+
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if !g.disabled[feature] {
+		level.Warn(logger).Log(
+			"msg", "token lacks the permission for an optional feature, disabling it",
+			"feature", feature,
+			"required_permission", featurePermissions[feature],
+			"err", err,
+		)
+		g.disabled[feature] = true
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-github/v63/github"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func testCommentReporter(t *testing.T, handler http.Handler) *gitHubComment {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(srv.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	return &gitHubComment{
+		githubCommon: githubCommon{
+			owner:           "my-org",
+			repo:            "my-repo",
+			pr:              1,
+			eventCommentID:  2,
+			client:          client,
+			features:        newFeatureGate(),
+			regressionLabel: "performance-regression",
+		},
+		logger:    log.NewNopLogger(),
+		threshold: 5,
+	}
+}
+
+func TestFeatureGateDisablesOnMissingPermission(t *testing.T) {
+	var reactions, labels atomic.Int32
+	gh := testCommentReporter(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/my-org/my-repo/issues/comments/2/reactions":
+			reactions.Add(1)
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"message":"Resource not accessible by integration"}`))
+		case "/repos/my-org/my-repo/issues/1/labels":
+			labels.Add(1)
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+
+	// missing permissions are not reported as error
+	require.NoError(t, gh.react(context.Background(), "eyes"))
+	require.NoError(t, gh.react(context.Background(), "confused"))
+	require.Equal(t, int32(1), reactions.Load())
+	require.False(t, gh.features.enabled(featureReactions))
+
+	// other features continue to work
+	re := &report.BenchmarkReport{
+		Finished: true,
+		Runs: []report.BenchmarkRun{{
+			Name: "pkg1.BenchA",
+			Results: []report.BenchmarkResult{{
+				Name:      "cpu",
+				BaseValue: report.BenchmarkValue{ProfileValue: 100, FlamegraphKey: "base"},
+				HeadValue: report.BenchmarkValue{ProfileValue: 200, FlamegraphKey: "head"},
+			}},
+		}},
+	}
+	require.NoError(t, gh.label(context.Background(), re))
+	require.NoError(t, gh.label(context.Background(), re))
+	require.Equal(t, int32(1), labels.Load(), "label is only added once")
+	require.True(t, gh.features.enabled(featureLabels))
+}
+
+func TestFeatureGateReturnsOtherErrors(t *testing.T) {
+	gh := testCommentReporter(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	require.Error(t, gh.react(context.Background(), "eyes"))
+	require.True(t, gh.features.enabled(featureReactions))
+}
+
+func TestLabelRemovalNotFound(t *testing.T) {
+	var message atomic.Value
+	gh := testCommentReporter(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodDelete, r.Method)
+		require.Equal(t, "/repos/my-org/my-repo/issues/1/labels/performance-regression", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"` + message.Load().(string) + `"}`))
+	}))
+	re := &report.BenchmarkReport{Finished: true}
+
+	// the pull request does not have the label
+	message.Store("Label does not exist")
+	require.NoError(t, gh.label(context.Background(), re))
+
+	// other not found errors are reported
+	message.Store("Not Found")
+	require.ErrorContains(t, gh.label(context.Background(), re), "404 Not Found")
+	require.True(t, gh.features.enabled(featureLabels))
+
+	// hidden by a missing permission
+	message.Store("Resource not accessible by integration")
+	require.NoError(t, gh.label(context.Background(), re))
+	require.False(t, gh.features.enabled(featureLabels))
+}
@@ -0,0 +1,179 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v63/github"
+
+	"github.com/grafana/pyrobench/config"
+	"github.com/grafana/pyrobench/report"
+)
+
+// requestMarkerPrefix starts the hidden line recording an authorized request
+// in the report comment.
+const requestMarkerPrefix = "<!-- pyrobench-requested "
+
+// requestMarker returns the line recording the request in a comment.
+func requestMarker(r *report.Request) string {
+	return fmt.Sprintf("\n%sauthor=%s time=%s -->\n", requestMarkerPrefix, r.Author, r.Time)
+}
+
+// parseRequestMarker returns the request recorded in the body of a comment.
+func parseRequestMarker(body string) (*report.Request, bool) {
+	idx := strings.Index(body, requestMarkerPrefix)
+	if idx < 0 {
+		return nil, false
+	}
+	line, _, _ := strings.Cut(body[idx+len(requestMarkerPrefix):], "\n")
+	line, ok := strings.CutSuffix(strings.TrimSpace(line), "-->")
+	if !ok {
+		return nil, false
+	}
+	r := &report.Request{}
+	for _, field := range strings.Fields(line) {
+		k, v, _ := strings.Cut(field, "=")
+		switch k {
+		case "author":
+			r.Author = v
+		case "time":
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, false
+			}
+			r.Time = d
+		}
+	}
+	return r, r.Author != ""
+}
+
+// Authorize checks the request against the policy of the repository. Authors,
+// who may approve, and approved requests are exempt from the approval and
+// the daily budget. The requested benchmark time is estimated by the caller
+// for the benchmarks the request matched.
+func (h *CommentHook) Authorize(ctx context.Context, policy *config.Policy, r *CommentHookResult, requested time.Duration) error {
+	if r.ApprovedBy != "" {
+		if !policy.MayApprove(h.association) {
+			return fmt.Errorf("@%s may not approve benchmarks, approvers are %s", r.ApprovedBy, strings.Join(policy.ApproverAssociations(), ", "))
+		}
+		return nil
+	}
+
+	if !policy.MaySetOptions(r.Association) {
+		for _, f := range r.Filter {
+			if f.Count != nil || f.Time != nil || len(f.Flags) > 0 {
+				return fmt.Errorf("benchmark %s: count, time and flags may only be set by %s", f, strings.Join(policy.OptionAssociations, ", "))
+			}
+		}
+	}
+	if policy.MayApprove(r.Association) {
+		return nil
+	}
+
+	approve := fmt.Sprintf("`%s %s`", h.args.BotName, CommandApprove)
+	if threshold := policy.ApprovalThreshold(); threshold > 0 && requested > threshold {
+		return fmt.Errorf("requesting %s of benchmark time needs approval above %s, approvers (%s) can comment %s to run the benchmarks", requested, threshold, strings.Join(policy.ApproverAssociations(), ", "), approve)
+	}
+	if budget := policy.Budget(); budget > 0 {
+		used, err := h.requestedToday(ctx, r.Author)
+		if err != nil {
+			return fmt.Errorf("failed to sum the benchmark time requested today: %w", err)
+		}
+		if used+requested > budget {
+			return fmt.Errorf("@%s requested %s of benchmark time today, another %s exceed the daily budget of %s, approvers (%s) can comment %s to run the benchmarks", r.Author, used, requested, budget, strings.Join(policy.ApproverAssociations(), ", "), approve)
+		}
+	}
+	return nil
+}
+
+// requestedToday sums the benchmark time of the requests of the author, which
+// have been authorized on the repository since the start of the UTC day of
+// the triggering comment. They are recorded in the report comments of the
+// bot, so requests that have been denied do not count.
+func (h *CommentHook) requestedToday(ctx context.Context, author string) (time.Duration, error) {
+	now := h.created
+	if now.IsZero() {
+		now = time.Now()
+	}
+	since := now.UTC().Truncate(24 * time.Hour)
+	opts := &github.IssueListCommentsOptions{
+		Since:       &since,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var total time.Duration
+	for {
+		// number 0 lists the comments of all issues and pull requests
+		comments, resp, err := h.client.Issues.ListComments(ctx, h.owner, h.repo, 0, opts)
+		if err != nil {
+			return 0, err
+		}
+		for _, c := range comments {
+			// since filters by the time of the last update
+			if !h.postedByBot(c) || c.GetCreatedAt().Before(since) {
+				continue
+			}
+			if r, ok := parseRequestMarker(c.GetBody()); ok && r.Author == author {
+				total += r.Time
+			}
+		}
+		if resp.NextPage == 0 {
+			return total, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// postedByBot returns true, if the comment has been posted by a bot or the
+// user pyrobench is mentioned as, so users can not forge the requests of
+// others.
+func (h *CommentHook) postedByBot(c *github.IssueComment) bool {
+	user := c.GetUser()
+	return user.GetType() == "Bot" || strings.EqualFold(user.GetLogin(), strings.TrimPrefix(h.args.BotName, "@"))
+}
+
+// approvedRequest returns the last benchmarks requested on the pull request
+// before the approve command by an allowed user.
+func (h *CommentHook) approvedRequest(ctx context.Context) (*CommentHookResult, error) {
+	opts := &github.IssueListCommentsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	var result *CommentHookResult
+	for {
+		comments, resp, err := h.client.Issues.ListComments(ctx, h.owner, h.repo, h.pr, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list comments: %w", err)
+		}
+		for _, c := range comments {
+			if c.GetID() == h.eventCommentID || (!h.created.IsZero() && !c.GetCreatedAt().Before(h.created)) {
+				continue
+			}
+			if !slices.Contains(h.args.AllowedAssociations, strings.ToLower(c.GetAuthorAssociation())) {
+				continue
+			}
+			benchmarks, command, err := parseCommandLine(h.args, strings.NewReader(c.GetBody()))
+			if err != nil || command != "" || len(benchmarks) == 0 {
+				continue
+			}
+			// the comments are listed in ascending order of creation
+			result = &CommentHookResult{
+				Filter:      benchmarks,
+				Command:     CommandApprove,
+				Author:      c.GetUser().GetLogin(),
+				Association: c.GetAuthorAssociation(),
+				ApprovedBy:  h.author,
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	if result == nil {
+		return nil, errors.New("no benchmarks have been requested before the approval")
+	}
+	return result, nil
+}
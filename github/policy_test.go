@@ -0,0 +1,147 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/google/go-github/v63/github"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/config"
+	"github.com/grafana/pyrobench/report"
+)
+
+func testPolicyHook(t *testing.T, handler http.HandlerFunc) *CommentHook {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(srv.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	return &CommentHook{
+		githubCommon: githubCommon{
+			owner:          "my-org",
+			repo:           "my-repo",
+			pr:             1,
+			eventCommentID: 10,
+			client:         client,
+			features:       newFeatureGate(),
+		},
+		created:     time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC),
+		author:      "contributor",
+		association: "CONTRIBUTOR",
+		logger:      log.NewNopLogger(),
+		args: &CommentHookArgs{
+			BotName:             "@pyrobench",
+			AllowedAssociations: []string{"contributor", "member", "owner"},
+		},
+	}
+}
+
+func TestRequestMarker(t *testing.T) {
+	body := "## Benchmark report\n" + requestMarker(&report.Request{Author: "contributor", Time: 66 * time.Second})
+	r, ok := parseRequestMarker(body)
+	require.True(t, ok)
+	require.Equal(t, &report.Request{Author: "contributor", Time: 66 * time.Second}, r)
+
+	for _, body := range []string{
+		"## Benchmark report\n",
+		"<!-- pyrobench-requested author=contributor time=1m0s",
+		"<!-- pyrobench-requested author=contributor time=long -->",
+		"<!-- pyrobench-requested time=1m0s -->",
+	} {
+		_, ok := parseRequestMarker(body)
+		require.False(t, ok, body)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	var path, since string
+	h := testPolicyHook(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		since = r.URL.Query().Get("since")
+		_, _ = w.Write([]byte(`[
+			{"id": 1, "body": "report <!-- pyrobench-requested author=contributor time=1m0s -->", "created_at": "2024-07-31T23:00:00Z", "updated_at": "2024-08-01T01:00:00Z", "user": {"login": "github-actions[bot]", "type": "Bot"}},
+			{"id": 2, "body": "report <!-- pyrobench-requested author=contributor time=10m0s -->", "created_at": "2024-08-01T08:00:00Z", "user": {"login": "github-actions[bot]", "type": "Bot"}},
+			{"id": 3, "body": "report <!-- pyrobench-requested author=someone-else time=10m0s -->", "created_at": "2024-08-01T09:00:00Z", "user": {"login": "github-actions[bot]", "type": "Bot"}},
+			{"id": 4, "body": "denied, without a request", "created_at": "2024-08-01T09:30:00Z", "user": {"login": "github-actions[bot]", "type": "Bot"}},
+			{"id": 5, "body": "<!-- pyrobench-requested author=contributor time=1h0m0s -->", "created_at": "2024-08-01T10:00:00Z", "user": {"login": "someone-else", "type": "User"}},
+			{"id": 10, "body": "@pyrobench A time=30s count=10", "created_at": "2024-08-01T12:00:00Z", "user": {"login": "contributor", "type": "User"}}
+		]`))
+	})
+	request := func(body string) *CommentHookResult {
+		benchmarks, _, err := parseCommandLine(h.args, strings.NewReader(body))
+		require.NoError(t, err)
+		return &CommentHookResult{Filter: benchmarks, Author: "contributor", Association: "CONTRIBUTOR"}
+	}
+	ctx := context.Background()
+
+	// no policy
+	require.NoError(t, h.Authorize(ctx, &config.Policy{}, request("@pyrobench A time=1m count=10"), 20*time.Minute))
+
+	// options
+	policy := &config.Policy{OptionAssociations: []string{"member", "owner"}}
+	require.NoError(t, h.Authorize(ctx, policy, request("@pyrobench A"), 20*time.Second))
+	require.EqualError(t, h.Authorize(ctx, policy, request("@pyrobench A count=10"), 40*time.Second), "benchmark A count=10: count, time and flags may only be set by member, owner")
+	require.EqualError(t, h.Authorize(ctx, policy, request("@pyrobench A flag=-test.short"), 20*time.Second), "benchmark A flag=-test.short: count, time and flags may only be set by member, owner")
+
+	// approval of the time estimated for the matched benchmarks
+	policy = &config.Policy{ApprovalAbove: "10m"}
+	require.NoError(t, h.Authorize(ctx, policy, request("@pyrobench A"), 10*time.Minute))
+	require.EqualError(t, h.Authorize(ctx, policy, request("@pyrobench A"), 12*time.Minute), "requesting 12m0s of benchmark time needs approval above 10m0s, approvers (member, owner) can comment `@pyrobench approve` to run the benchmarks")
+	approver := request("@pyrobench A time=1m count=6")
+	approver.Association = "OWNER"
+	require.NoError(t, h.Authorize(ctx, policy, approver, 12*time.Minute))
+
+	// daily budget, only the request recorded by comment 2 counts
+	policy = &config.Policy{DailyBudget: "20m"}
+	require.NoError(t, h.Authorize(ctx, policy, request("@pyrobench A"), 10*time.Minute))
+	require.Equal(t, "/repos/my-org/my-repo/issues/comments", path)
+	require.Equal(t, "2024-08-01T00:00:00Z", since)
+	require.EqualError(t, h.Authorize(ctx, policy, request("@pyrobench A"), 12*time.Minute), "@contributor requested 10m0s of benchmark time today, another 12m0s exceed the daily budget of 20m0s, approvers (member, owner) can comment `@pyrobench approve` to run the benchmarks")
+
+	// approved requests are exempt, if the approver may approve
+	approved := request("@pyrobench A time=1m count=6")
+	approved.ApprovedBy = "maintainer"
+	require.EqualError(t, h.Authorize(ctx, policy, approved, 12*time.Minute), "@maintainer may not approve benchmarks, approvers are member, owner")
+	h.association = "MEMBER"
+	require.NoError(t, h.Authorize(ctx, policy, approved, 12*time.Minute))
+}
+
+func TestApprovedRequest(t *testing.T) {
+	comments := `[
+		{"id": 1, "body": "@pyrobench A", "author_association": "CONTRIBUTOR", "created_at": "2024-08-01T10:00:00Z", "user": {"login": "contributor"}},
+		{"id": 2, "body": "@pyrobench B time=1m count=20", "author_association": "CONTRIBUTOR", "created_at": "2024-08-01T11:00:00Z", "user": {"login": "contributor"}},
+		{"id": 3, "body": "@pyrobench C", "author_association": "NONE", "created_at": "2024-08-01T11:30:00Z", "user": {"login": "drive-by"}},
+		{"id": 4, "body": "@pyrobench help", "author_association": "MEMBER", "created_at": "2024-08-01T11:45:00Z", "user": {"login": "maintainer"}},
+		{"id": 10, "body": "@pyrobench approve", "author_association": "MEMBER", "created_at": "2024-08-01T12:00:00Z", "user": {"login": "maintainer"}},
+		{"id": 11, "body": "@pyrobench D", "author_association": "CONTRIBUTOR", "created_at": "2024-08-01T12:01:00Z", "user": {"login": "contributor"}}
+	]`
+	h := testPolicyHook(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/repos/my-org/my-repo/issues/1/comments", r.URL.Path)
+		_, _ = w.Write([]byte(comments))
+	})
+	h.author = "maintainer"
+	h.association = "MEMBER"
+
+	r, err := h.approvedRequest(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, "B time=1m count=20", BenchmarkFiltersString(r.Filter))
+	require.Equal(t, CommandApprove, r.Command)
+	require.Equal(t, "contributor", r.Author)
+	require.Equal(t, "CONTRIBUTOR", r.Association)
+	require.Equal(t, "maintainer", r.ApprovedBy)
+
+	comments = `[{"id": 10, "body": "@pyrobench approve", "author_association": "MEMBER", "created_at": "2024-08-01T12:00:00Z"}]`
+	_, err = h.approvedRequest(context.Background())
+	require.EqualError(t, err, "no benchmarks have been requested before the approval")
+}
@@ -2,13 +2,16 @@ package github
 
 import (
 	"context"
-	"strings"
+	"errors"
+	"net/http"
 	"text/template"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/google/go-github/v63/github"
 	"github.com/grafana/pyrobench/report"
+	"github.com/grafana/pyrobench/report/events"
 )
 
 type CommentReporterArgs struct {
@@ -16,17 +19,17 @@ type CommentReporterArgs struct {
 	Report *report.Args
 }
 
-func NewCommentReporter(logger log.Logger, args *Args, ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+func NewCommentReporter(logger log.Logger, args *Args, reportArgs *report.Args, ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
 	ghCommon, _, err := newGitHubCommon(args)
 	if err != nil {
 		return nil, err
 	}
 
-	return newCommentReporterFromGitHubCommon(logger, ghCommon, ch)
+	return newCommentReporterFromGitHubCommon(logger, ghCommon, reportArgs, ch)
 }
 
-func newCommentReporterFromGitHubCommon(logger log.Logger, ghCommon *githubCommon, ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
-	tmpl, err := template.New("github").Parse(reportTemplate)
+func newCommentReporterFromGitHubCommon(logger log.Logger, ghCommon *githubCommon, reportArgs *report.Args, ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+	tmpl, err := newCustomReportTemplate(reportArgs)
 	if err != nil {
 		return nil, err
 	}
@@ -38,6 +41,14 @@ func newCommentReporterFromGitHubCommon(logger log.Logger, ghCommon *githubCommo
 		stopCh:       make(chan struct{}),
 		template:     tmpl,
 	}
+	if reportArgs != nil {
+		gh.threshold = reportArgs.PercentageThreshold
+		gh.collapse = reportArgs.CollapseUnchanged
+		if reportArgs.SigningKey != "" {
+			gh.signingKey = []byte(reportArgs.SigningKey)
+		}
+		gh.events = reportArgs.Events
+	}
 
 	gh.wg.Add(1)
 	go func() {
@@ -48,7 +59,7 @@ func newCommentReporterFromGitHubCommon(logger log.Logger, ghCommon *githubCommo
 	return gh, nil
 }
 func (gh *gitHubComment) react(ctx context.Context, content string) error {
-	if gh.eventCommentID == 0 {
+	if gh.eventCommentID == 0 || !gh.features.enabled(featureReactions) {
 		return nil
 	}
 	_, _, err := gh.githubCommon.client.Reactions.CreateIssueCommentReaction(
@@ -58,28 +69,89 @@ func (gh *gitHubComment) react(ctx context.Context, content string) error {
 		gh.eventCommentID,
 		content,
 	)
-	return err
+	return gh.features.check(gh.logger, featureReactions, err)
 }
 
+// label adds or removes the regression label, once the report is finished.
+func (gh *gitHubComment) label(ctx context.Context, re *report.BenchmarkReport) error {
+	if !re.Finished || re.Error != nil || re.Help != nil || !gh.features.enabled(featureLabels) {
+		return nil
+	}
+
+	var err error
+	regressed := len(re.Regressions(gh.threshold)) > 0
+	if regressed && !gh.labeled {
+		_, _, err = gh.client.Issues.AddLabelsToIssue(ctx, gh.owner, gh.repo, gh.pr, []string{gh.regressionLabel})
+		gh.labeled = err == nil
+	} else if !regressed {
+		_, err = gh.client.Issues.RemoveLabelForIssue(ctx, gh.owner, gh.repo, gh.pr, gh.regressionLabel)
+		if isLabelNotSet(err) {
+			err = nil
+		}
+	}
+	return gh.features.check(gh.logger, featureLabels, err)
+}
+
+// isLabelNotSet returns true if removing a label failed, because the pull
+// request does not have it. Other not found errors, e.g. of a pull request
+// the token can't access, are not expected.
+func isLabelNotSet(err error) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil &&
+		errResp.Response.StatusCode == http.StatusNotFound &&
+		errResp.Message == "Label does not exist"
+}
+
+// render renders the comment body. Final reports are signed, if a signing
+// key is configured.
 func (gh *gitHubComment) render(re *report.BenchmarkReport) (string, error) {
-	buf := &strings.Builder{}
-	if err := gh.template.Execute(buf, struct {
-		Report  *report.BenchmarkReport
-		Compare string
-	}{
-		Report:  re,
-		Compare: re.MarkdownCompare(gh.owner, gh.repo),
-	}); err != nil {
+	body, err := renderView(gh.template, gh.owner, gh.repo, re, gh.view(re))
+	if err != nil {
 		return "", err
 	}
-	return buf.String(), nil
+	return gh.sign(re, body), nil
+}
+
+// sign signs the body of final reports, if a signing key is configured.
+func (gh *gitHubComment) sign(re *report.BenchmarkReport, body string) string {
+	if gh.signingKey == nil || !re.Finished || re.Help != nil {
+		return body
+	}
+	return report.Sign(gh.signingKey, report.Provenance{
+		Repository: gh.owner + "/" + gh.repo,
+		Head:       re.HeadRef,
+	}, body)
+}
+
+// newReportTemplate parses the report template with the messages of the
+// language, an empty language keeps it in English.
+func newReportTemplate(lang string) (*template.Template, error) {
+	c, err := loadCatalog(lang)
+	if err != nil {
+		return nil, err
+	}
+	thresholds := (*report.Args)(nil).SeverityThresholds()
+	return template.New("github").Funcs(c.funcs()).Funcs(templateFuncs).Funcs(severityFuncs(thresholds)).Parse(reportTemplate)
 }
+
+func reportLanguage(args *report.Args) string {
+	if args == nil {
+		return ""
+	}
+	return args.Language
+}
+
+// renderReport renders the markdown report for the given repository.
+func renderReport(tmpl *template.Template, owner, repo string, re *report.BenchmarkReport) (string, error) {
+	return renderView(tmpl, owner, repo, re, fullView(re))
+}
+
 func (gh *gitHubComment) postReport(ctx context.Context, report *report.BenchmarkReport) error {
-	body, err := gh.render(report)
+	bodies, err := gh.renderComments(ctx, report)
 	if err != nil {
 		return err
 	}
-	if report.Error != nil {
+	if report.Error != nil || (report.Help != nil && report.Help.Invalid()) {
 		if err := gh.react(ctx, "confused"); err != nil {
 			level.Warn(gh.logger).Log("msg", "failed to add reaction to issue comment", "err", err)
 		}
@@ -90,7 +162,22 @@ func (gh *gitHubComment) postReport(ctx context.Context, report *report.Benchmar
 		gh.reacted = true
 	}
 
-	return gh.postComment(ctx, body)
+	if err := gh.label(ctx, report); err != nil {
+		level.Warn(gh.logger).Log("msg", "failed to update regression label", "err", err)
+	}
+
+	if err := gh.postComments(ctx, bodies); err != nil {
+		return err
+	}
+	gh.events.Emit(events.Event{Type: events.ReportPosted, URL: gh.commentURL, Finished: report.Finished})
+
+	if err := gh.submitReview(ctx, report, bodies[0]); err != nil {
+		level.Warn(gh.logger).Log("msg", "failed to submit review", "err", err)
+	}
+	if err := gh.commentLines(ctx, report); err != nil {
+		level.Warn(gh.logger).Log("msg", "failed to comment on hot lines", "err", err)
+	}
+	return nil
 }
 
 func (gh *gitHubComment) postComment(ctx context.Context, body string) error {
@@ -118,34 +205,126 @@ func (gh *gitHubComment) postComment(ctx context.Context, body string) error {
 		},
 	)
 	gh.commentID = resp.GetID()
+	gh.commentURL = resp.GetHTMLURL()
 	return err
 
 }
 
+const (
+	// defaultRetryAfter is used, when a secondary rate limit does not tell
+	// how long to wait.
+	defaultRetryAfter = time.Minute
+	// maxFinalRetryAfter limits how long the final report waits for a rate
+	// limit to pass.
+	maxFinalRetryAfter = 2 * time.Minute
+	finalAttempts      = 3
+)
+
+// retryAfter returns how long to wait before retrying, if the error is caused
+// by a primary or secondary rate limit of GitHub.
+func retryAfter(err error, now time.Time) (time.Duration, bool) {
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		if d := abuseErr.GetRetryAfter(); d > 0 {
+			return d, true
+		}
+		return defaultRetryAfter, true
+	}
+	var rateErr *github.RateLimitError
+	if errors.As(err, &rateErr) {
+		return max(rateErr.Rate.Reset.Time.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// run posts the reports received. Updates within the update interval are
+// coalesced, so only the latest report gets posted. Errors and finished
+// reports are posted right away.
 func (gh *gitHubComment) run(ctx context.Context) {
-	var lastReport *report.BenchmarkReport
+	var (
+		lastReport *report.BenchmarkReport
+		pending    *report.BenchmarkReport // received but not posted yet
+		lastPost   time.Time
+		wait       *time.Timer
+		waitC      <-chan time.Time
+		limited    bool // waiting for a rate limit to pass
+	)
+	schedule := func(d time.Duration) {
+		if wait != nil {
+			wait.Stop()
+		}
+		wait = time.NewTimer(d)
+		waitC = wait.C
+	}
+	flush := func() {
+		waitC = nil
+		if pending == nil {
+			return
+		}
+		err := gh.postReport(ctx, pending)
+		var d time.Duration
+		if d, limited = retryAfter(err, time.Now()); limited {
+			level.Warn(gh.logger).Log("msg", "rate limited by GitHub, retrying later", "retry_after", d)
+			schedule(d)
+			return
+		}
+		if err != nil {
+			level.Warn(gh.logger).Log("msg", "failed to post comment", "err", err)
+		}
+		pending = nil
+		lastPost = time.Now()
+	}
+
 	defer func() {
-		// finish the report if it's not finished
-		if lastReport != nil && !lastReport.Finished {
-			lastReport.Finished = true
-			if err := gh.postReport(ctx, lastReport); err != nil {
-				level.Warn(gh.logger).Log("msg", "failed to post report", "err", err)
+		if wait != nil {
+			wait.Stop()
+		}
+		if lastReport == nil || (pending == nil && lastReport.Finished) {
+			return
+		}
+		// finish the report if it's not finished, on a copy as other
+		// reporters receive the same report
+		lastReport = lastReport.Copy().WithFinished()
+		for attempt := 1; ; attempt++ {
+			err := gh.postReport(ctx, lastReport)
+			d, limited := retryAfter(err, time.Now())
+			if !limited || attempt == finalAttempts || d > maxFinalRetryAfter {
+				if err != nil {
+					level.Warn(gh.logger).Log("msg", "failed to post report", "err", err)
+				}
+				return
+			}
+			level.Warn(gh.logger).Log("msg", "rate limited by GitHub, retrying final report", "retry_after", d)
+			select {
+			case <-ctx.Done():
+				level.Warn(gh.logger).Log("msg", "failed to post report", "err", ctx.Err())
+				return
+			case <-time.After(d):
 			}
 		}
 	}()
 	for {
 		select {
 		case <-gh.stopCh:
-			// finalize something
 			return
+		case <-waitC:
+			flush()
 		case report, ok := <-gh.ch:
 			if !ok {
 				return
 			}
-			if err := gh.postReport(ctx, report); err != nil {
-				level.Warn(gh.logger).Log("msg", "failed to post comment", "err", err)
-			}
 			lastReport = report
+			pending = report
+			immediate := gh.commentID == 0 || report.Finished || report.Error != nil
+			since := time.Since(lastPost)
+			switch {
+			case limited:
+				// posted once the rate limit passed
+			case immediate || since >= gh.updateInterval:
+				flush()
+			case waitC == nil:
+				schedule(gh.updateInterval - since)
+			}
 		}
 	}
 }
@@ -1,17 +1,26 @@
 package github
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
 	"strings"
+	"sync"
 	"testing"
-	"text/template"
+	"time"
 
+	"github.com/google/go-github/v63/github"
+	"github.com/grafana/pyrobench/benchtab"
 	"github.com/grafana/pyrobench/report"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/perf/benchfmt"
+	"golang.org/x/perf/benchmath"
 )
 
 func TestGithubCommentTemplate(t *testing.T) {
-	tmpl, err := template.New("github").Parse(reportTemplate)
+	tmpl, err := newReportTemplate("")
 	require.NoError(t, err)
 
 	gh := &gitHubComment{
@@ -83,6 +92,41 @@ abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
 <details>
     <summary><tt>pkg1.BenchTestB</tt>(scheduled)</summary>
 
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+</details>
+`,
+		},
+		{
+			Name: "benchmarks of several modules",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name:   "example.com/a.BenchTestA",
+						Module: "example.com/a",
+					},
+					{
+						Name:   "example.com/b/c.BenchTestC",
+						Module: "example.com/b",
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>example.com/a.BenchTestA</tt> <sub>example.com/a</sub>(scheduled)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+</details>
+<details>
+    <summary><tt>example.com/b/c.BenchTestC</tt> <sub>example.com/b</sub>(scheduled)</summary>
+
 | Resource | Base | Head | Diff % |
 |----------|-----:|-----:|-------:|
 </details>
@@ -100,40 +144,1034 @@ abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
 							{
 								Name:      "cpu",
 								Unit:      "ns",
-								BaseValue: report.BenchmarkValue{10000000, "a-cpu-base"},
-								HeadValue: report.BenchmarkValue{20000000, "a-cpu-head"},
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 20000000, FlamegraphKey: "a-cpu-head"},
+							},
+							{
+								Name:      "alloc_space",
+								Unit:      "bytes",
+								BaseValue: report.BenchmarkValue{ProfileValue: 2048 * 1024, FlamegraphKey: "a-alloc-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 2047 * 1024, FlamegraphKey: "a-alloc-head"},
+							},
+						},
+					},
+					{
+						Name: "pkg1.BenchTestB",
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression (1 major)
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=100 %, alloc_space=-0.04 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [20 ms](https://flamegraph.com/share/a-cpu-head) | 🔴 [100 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+| alloc_space | [2.0 MiB](https://flamegraph.com/share/a-alloc-base) | [2.0 MiB](https://flamegraph.com/share/a-alloc-head) | ⚪ [-0.04 %](https://flamegraph.com/share/a-alloc-base/a-alloc-head) |
+</details>
+<details>
+    <summary><tt>pkg1.BenchTestB</tt>(scheduled)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+</details>
+`,
+		},
+		{
+			Name: "benchmark timed out",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name:     "pkg1.BenchTestA",
+						TimedOut: true,
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(timed out)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+</details>
+`,
+		},
+		{
+			Name: "base regressed recently",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+								BaselineShift: &report.BaselineShift{
+									From: "0123456789abcdef",
+									To:   "fedcba9876543210",
+									Diff: 20,
+								},
+							},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+
+> :warning: The base itself regressed ` + "`cpu`" + ` by 20 % between ` + "`0123456`" + ` and ` + "`fedcba9`" + `, part of this diff may pre-date this PR.
+
+</details>
+`,
+		},
+		{
+			Name: "within the machine drift",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+								Drift:     -12.5,
+							},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 unchanged
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | ⚪ [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+
+> :information_source: The diff of ` + "`cpu`" + ` is within the machine drift of 12.5 % measured by the latest baseline runs, it might not be caused by this change.
+
+</details>
+`,
+		},
+		{
+			Name: "with locally computed diff profile",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:              "cpu",
+								Unit:              "ns",
+								BaseValue:         report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue:         report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+								DiffFlamegraphKey: "a-cpu-diff",
+							},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) ([what changed](https://flamegraph.com/share/a-cpu-diff)) |
+</details>
+`,
+		},
+		{
+			Name: "critical function regressed",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 10100000, FlamegraphKey: "a-cpu-head"},
+								CriticalFunctions: []report.CriticalFunction{
+									{Name: "pkg1.Hot", Diff: 12.5},
+								},
+							},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=1 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [10.1 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [1 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+
+> :rotating_light: Critical function ` + "`pkg1.Hot`" + ` regressed ` + "`cpu`" + ` by 12.5 %.
+
+</details>
+`,
+		},
+		{
+			Name: "cpu frequency differed",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+							},
+						},
+						CPU: []report.CPUUsage{
+							{Source: "base", Cores: []int{0, 1}, MinFreqKHz: 2000000, MaxFreqKHz: 2200000, AvgFreqKHz: 2100000},
+							{Source: "head", Cores: []int{2}, MinFreqKHz: 3000000, MaxFreqKHz: 3400000, AvgFreqKHz: 3200000},
+						},
+						Warnings: []string{"CPU frequency differed."},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+
+> :warning: CPU frequency differed.
+
+
+<sub>CPU: base on cores 0-1 at 2.00-2.20 GHz, head on cores 2 at 3.00-3.40 GHz</sub>
+
+</details>
+`,
+		},
+		{
+			Name: "downsampled profile",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head", DownsampleFactor: 4.25},
+							},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) (downsampled 4.2x) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+</details>
+`,
+		},
+		{
+			Name: "slow TestMain",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+							},
+						},
+						TestMain: []report.TestMainTiming{
+							{Source: "base", Setup: 2500 * time.Millisecond, Teardown: 100 * time.Millisecond},
+							{Source: "head", Setup: 2600 * time.Millisecond},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+
+<sub>TestMain (excluded): base setup 2.5s, teardown 100ms; head setup 2.6s, teardown 0s</sub>
+
+</details>
+`,
+		},
+		{
+			Name: "skipped by the total time budget",
+			R: &report.BenchmarkReport{
+				BaseRef:  "abcd",
+				HeadRef:  "ef00",
+				Message:  "1 benchmarks have been skipped, as the total time budget of 30m0s has been exhausted.",
+				Finished: true,
+				Runs: []report.BenchmarkRun{
+					{
+						Name:    "pkg1.BenchTestA",
+						Skipped: true,
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__Finished__
+1 benchmarks have been skipped, as the total time budget of 30m0s has been exhausted.
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(skipped, time budget exhausted)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+</details>
+`,
+		},
+		{
+			Name: "progress",
+			R: &report.BenchmarkReport{
+				BaseRef:  "abcd",
+				HeadRef:  "ef00",
+				Progress: &report.RunProgress{Done: 1, Total: 2, Remaining: 8 * time.Minute},
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+							},
+						},
+					},
+					{
+						Name:    "pkg1.BenchTestB",
+						Running: true,
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ ` + "`[==========          ]`" + ` 1/2 done, ~8m remaining — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+</details>
+<details>
+    <summary><tt>pkg1.BenchTestB</tt>(running)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+</details>
+`,
+		},
+		{
+			Name: "preliminary",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name:        "pkg1.BenchTestA",
+						Running:     true,
+						Preliminary: true,
+						Metrics:     []report.BenchmarkMetric{{Unit: "sec/op", Base: 0.000012, Head: 0.000015, HasBase: true, HasHead: true, Delta: "~", Better: -1}},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 unchanged
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(preliminary, sec/op=25 %, running)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| sec/op | 12.00µ | 15.00µ | ⚪ ~ |
+
+> :hourglass_flowing_sand: Preliminary numbers of a single quick run, they are updated once the benchmark ran with its full bench time.
+
+</details>
+`,
+		},
+		{
+			Name: "resource usage",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+							},
+						},
+						Resources: []report.ResourceUsage{
+							{Source: "base", MaxRSS: 100 << 20, GCCycles: 14, GCPauseTotal: 3200 * time.Microsecond},
+							{Source: "head", MaxRSS: 125 << 20, GCCycles: 18, GCPauseTotal: 4000 * time.Microsecond},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+
+<sub>Memory: base max RSS 100 MiB, GC pauses 3.2ms in 14 cycles; head max RSS 125 MiB (+25.0 %), GC pauses 4ms in 18 cycles (+25.0 %)</sub>
+
+</details>
+`,
+		},
+		{
+			Name: "goroutine leak",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+							},
+						},
+						GoroutineLeak: &report.GoroutineLeak{
+							Base: 0,
+							Head: 3,
+							Stacks: []report.LeakedStack{
+								{Count: 3, Stack: "pkg1.worker\n\tpkg1/worker.go:12"},
+							},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+
+> :warning: Head leaks 3 goroutines after the benchmark, base 0.
+
+3 leaked by head:
+` + "```" + `
+pkg1.worker
+	pkg1/worker.go:12
+` + "```" + `
+
+</details>
+`,
+		},
+		{
+			Name: "execution traces",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+							},
+						},
+						Traces: []report.Trace{
+							{Source: "base", Path: "artifacts/pkg1/BenchTestA/trace-base.out", URL: "https://example.com/pkg1/BenchTestA/trace-base.out"},
+							{Source: "head", Path: "artifacts/pkg1/BenchTestA/trace-head.out"},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+
+Execution traces (` + "`go tool trace`" + `): [base](https://example.com/pkg1/BenchTestA/trace-base.out) head ` + "`artifacts/pkg1/BenchTestA/trace-head.out`" + `
+
+</details>
+`,
+		},
+		{
+			Name: "hottest functions",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11200000, FlamegraphKey: "a-cpu-head"},
+							},
+						},
+						Hotspots: []report.FunctionDelta{
+							{Name: "pkg1.encode", Base: 4000000, Head: 5000000},
+							{Name: "pkg1.grow", Base: 0, Head: 300000},
+							{Name: "runtime.memmove", Base: 1000000, Head: 900000},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=12 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11.2 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [12 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+
+<details>
+    <summary>Largest changes of flat CPU time</summary>
+
+| Function | Base | Head | Delta | Diff % |
+|----------|-----:|-----:|------:|-------:|
+| ` + "`pkg1.encode`" + ` | 4 ms | 5 ms | +1 ms | 25 % |
+| ` + "`pkg1.grow`" + ` | 0 s | 300 µs | +300 µs | new |
+| ` + "`runtime.memmove`" + ` | 1 ms | 900 µs | -100 µs | -10 % |
+</details>
+
+</details>
+`,
+		},
+		{
+			Name: "usage of invalid command",
+			R: (&report.BenchmarkReport{}).WithHelp(&report.CommandHelp{
+				BotName: "@pyrobench",
+				Command: "E2[E",
+				Problem: "failed to compile regex: missing closing ]",
+				Usage:   true,
+				Benchmarks: []report.PackageBenchmarks{
+					{Dir: ".", Names: []string{"BenchmarkA", "BenchmarkB"}},
+					{Dir: "pkg/storage", Names: []string{"BenchmarkSeries"}},
+				},
+				MoreBenchmarks: 3,
+			}),
+			expected: strings.Join([]string{
+				"### Benchmark Report",
+				"",
+				":confused: I could not understand the command `E2[E`: failed to compile regex: missing closing ]",
+				"",
+				"Usage:",
+				"",
+				"```",
+				"@pyrobench [dir=<dir>] <regex>|suite=<name>|path=<path> [count=<n>] [time=<benchtime>] [flag=<flag>] ...",
+				"@pyrobench help|list|cancel|approve",
+				"```",
+				"",
+				"- `<regex>` selects the benchmarks by a regular expression",
+				"- `suite=<name>` runs a suite of the configuration file",
+				"- `path=<path>` runs the benchmarks of the packages changed below a path, e.g. ./pkg/storage/...",
+				"- `dir=<dir>` limits the following benchmarks to a directory",
+				"- `count=<n>` sets how often the benchmarks are run",
+				"- `time=<benchtime>` sets the -benchtime of the benchmarks, e.g. 2s or 100x",
+				"- `flag=<flag>` passes a flag to the test binaries, e.g. -test.short, can be repeated",
+				"- `help` shows this help",
+				"- `list` lists the available benchmarks",
+				"- `cancel` cancels the benchmarks running for the pull request",
+				"- `approve` runs the last benchmarks requested, which need the approval of a maintainer",
+				"",
+				"Example: `@pyrobench dir=pkg/storage BenchmarkSeries count=10`",
+				"",
+				"<details>",
+				"    <summary>Available benchmarks</summary>",
+				"",
+				"- `.`: `BenchmarkA`, `BenchmarkB`",
+				"- `pkg/storage`: `BenchmarkSeries`",
+				"- 3 more",
+				"",
+				"</details>",
+				"",
+			}, "\n"),
+		},
+		{
+			Name: "list",
+			R: (&report.BenchmarkReport{}).WithHelp(&report.CommandHelp{
+				BotName:    "@pyrobench",
+				Benchmarks: []report.PackageBenchmarks{{Dir: "pkg/storage", Names: []string{"BenchmarkSeries"}}},
+			}),
+			expected: strings.Join([]string{
+				"### Benchmark Report",
+				"",
+				"<details open>",
+				"    <summary>Available benchmarks</summary>",
+				"",
+				"- `pkg/storage`: `BenchmarkSeries`",
+				"",
+				"</details>",
+				"",
+			}, "\n"),
+		},
+		{
+			Name: "code size",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				CodeSize: []report.PackageCodeSize{
+					{
+						ImportPath: "pkg1",
+						Base:       &report.CodeSize{TextSize: 1 << 20, Functions: 100, Exported: 20},
+						Head:       &report.CodeSize{TextSize: 1<<20 + 1<<18, Functions: 140, Exported: 21},
+					},
+					{
+						ImportPath: "pkg2",
+						Head:       &report.CodeSize{TextSize: 4096, Functions: 3, Exported: 1},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary>Code size</summary>
+
+| Package | Text size | Diff % | Functions | Exported |
+|---------|----------:|-------:|----------:|---------:|
+| ` + "`pkg1`" + ` | 1.0 MiB → 1.3 MiB | +25.0 % | 100 → 140 | 20 → 21 |
+| ` + "`pkg2`" + ` | n/a → 4.0 KiB | n/a | n/a → 3 | n/a → 1 |
+</details>
+`,
+		},
+		{
+			Name: "build",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Build: []report.PackageBuild{
+					{
+						ImportPath: "pkg1",
+						Base:       &report.Build{BinarySize: 4 << 20, CompileTime: 2 * time.Second},
+						Head:       &report.Build{BinarySize: 5 << 20, CompileTime: 3 * time.Second},
+					},
+					{
+						ImportPath: "pkg2",
+						Base:       &report.Build{BinarySize: 1 << 20, Cached: true},
+						Head:       &report.Build{BinarySize: 1 << 20, CompileTime: 1500 * time.Millisecond},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary>Build</summary>
+
+| Package | Binary size | Diff % | Compile time | Diff % |
+|---------|------------:|-------:|-------------:|-------:|
+| ` + "`pkg1`" + ` | 4.0 MiB → 5.0 MiB | +25.0 % | 2s → 3s | +50.0 % |
+| ` + "`pkg2`" + ` | 1.0 MiB → 1.0 MiB | +0.0 % | cached → 1.5s | n/a |
+</details>
+`,
+		},
+		{
+			Name: "noisy environment",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Environment: &report.Environment{
+					GoVersion:   "go1.22.5",
+					OS:          "linux",
+					Arch:        "amd64",
+					CPU:         "AMD EPYC 7B13",
+					NumCPU:      8,
+					Kernel:      "6.1.0",
+					Governor:    "powersave",
+					LoadAverage: 0.5,
+					Issues:      []string{"CPU frequency governor is powersave instead of performance"},
+				},
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+							},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+
+> :warning: CPU frequency governor is powersave instead of performance
+
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+</details>
+
+<sub>Environment: go1.22.5, linux/amd64, 8 x AMD EPYC 7B13, kernel 6.1.0, governor powersave, load 0.50</sub>
+`,
+		},
+		{
+			Name: "removed benchmarks",
+			R: &report.BenchmarkReport{
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-head"},
+							},
+						},
+					},
+					{
+						Name:    "pkg1.BenchTestOld",
+						Removed: true,
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 20000000, FlamegraphKey: "old-cpu-base"},
 							},
 							{
 								Name:      "alloc_space",
 								Unit:      "bytes",
-								BaseValue: report.BenchmarkValue{2048 * 1024, "a-alloc-base"},
-								HeadValue: report.BenchmarkValue{2047 * 1024, "a-alloc-head"},
+								BaseValue: report.BenchmarkValue{ProfileValue: 2048, FlamegraphKey: "old-alloc-base"},
 							},
 						},
+						Metrics: []report.BenchmarkMetric{{Unit: "sec/op", Base: 0.02, HasBase: true}},
 					},
 					{
-						Name: "pkg1.BenchTestB",
+						Name:    "pkg2.BenchTestGone",
+						Removed: true,
+						Running: true,
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 unchanged
+
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=0 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [10 ms](https://flamegraph.com/share/a-cpu-head) | ⚪ [0 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+</details>
+<details>
+    <summary>Removed benchmarks (2)</summary>
+
+These benchmarks only exist in base, they ran on base only.
+
+| Benchmark | Base |
+|-----------|------|
+| ` + "`pkg1.BenchTestOld`" + ` | cpu [20 ms](https://flamegraph.com/share/old-cpu-base), alloc_space [2.0 KiB](https://flamegraph.com/share/old-alloc-base), sec/op 20.00m |
+| ` + "`pkg2.BenchTestGone`" + ` | running |
+</details>
+`,
+		},
+		{
+			Name: "normalized environment",
+			R: &report.BenchmarkReport{
+				Environment: &report.Environment{
+					OS:        "linux",
+					Arch:      "amd64",
+					NumCPU:    8,
+					Variables: []string{"GOGC=100", "GOMAXPROCS=8", "TZ=UTC"},
+				},
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-head"},
+							},
+						},
 					},
 				},
 			},
 			expected: `### Benchmark Report
 
+__In progress__ — 1 unchanged
+
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=0 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [10 ms](https://flamegraph.com/share/a-cpu-head) | ⚪ [0 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+</details>
+
+<sub>Environment: linux/amd64, 8 CPUs<br>Normalized environment: <code>GOGC=100 GOMAXPROCS=8 TZ=UTC</code></sub>
+`,
+		},
+		{
+			Name: "different toolchains",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Environment: &report.Environment{
+					OS:            "linux",
+					Arch:          "amd64",
+					NumCPU:        8,
+					BaseGoVersion: "go1.21.13",
+					HeadGoVersion: "go1.22.5",
+					Issues:        []string{"Base is built with go1.21.13 and head with go1.22.5, the results include the changes of the compiler and the runtime. Use --toolchain to build both with the same toolchain."},
+				},
+			},
+			expected: `### Benchmark Report
+
 __In progress__
 
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+
+<sub>Go toolchain: base go1.21.13, head go1.22.5</sub>
+
+> :warning: Base is built with go1.21.13 and head with go1.22.5, the results include the changes of the compiler and the runtime. Use --toolchain to build both with the same toolchain.
+
+
+<sub>Environment: linux/amd64, 8 CPUs</sub>
+`,
+		},
+		{
+			Name: "with benchmark metrics",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name: "pkg1.BenchTestA",
+						Results: []report.BenchmarkResult{
+							{
+								Name:      "cpu",
+								Unit:      "ns",
+								BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+								HeadValue: report.BenchmarkValue{ProfileValue: 11000000, FlamegraphKey: "a-cpu-head"},
+							},
+						},
+						Metrics: []report.BenchmarkMetric{
+							{Unit: "sec/op", Base: 0.000012, Head: 0.0000125, HasBase: true, HasHead: true, Delta: "+4.17%", Better: -1},
+							{Unit: "B/op", Base: 2048, Head: 2048, HasBase: true, HasHead: true, Delta: "~", Better: -1},
+							{Unit: "rows/s", Head: 1500, HasHead: true, Better: 1},
+							{Unit: "hits/op", Base: 0.5, Head: 0.75, HasBase: true, HasHead: true, Delta: "+50.00%", Better: 1},
+							{Unit: "misses/op", Base: 0.5, Head: 0.25, HasBase: true, HasHead: true, Delta: "-50.00%"},
+						},
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
 abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
 <details>
-    <summary><tt>pkg1.BenchTestA</tt>(cpu=100 %, alloc_space=-0.04 %)</summary>
+    <summary><tt>pkg1.BenchTestA</tt>(cpu=10 %)</summary>
+
+| Resource | Base | Head | Diff % |
+|----------|-----:|-----:|-------:|
+| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [11 ms](https://flamegraph.com/share/a-cpu-head) | 🟡 [10 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
+| sec/op | 12.00µ | 12.50µ | 🟡 +4.17% (worse) |
+| B/op | 2.000Ki | 2.000Ki | ⚪ ~ |
+| rows/s | n/a | 1.500k | n/a |
+| hits/op | 500.0m | 750.0m | 🟢 +50.00% (better) |
+| misses/op | 500.0m | 250.0m | ⚪ -50.00% |
+</details>
+`,
+		},
+		{
+			Name: "with PGO",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name:     "pkg1.BenchTestA",
+						Metrics:  []report.BenchmarkMetric{{Unit: "sec/op", Base: 0.000012, Head: 0.0000125, HasBase: true, HasHead: true, Delta: "+4.17%", Better: -1}},
+						PGODelta: "-3.50%",
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__ — 1 regression
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+<details>
+    <summary><tt>pkg1.BenchTestA</tt>(scheduled)</summary>
 
 | Resource | Base | Head | Diff % |
 |----------|-----:|-----:|-------:|
-| cpu | [10 ms](https://flamegraph.com/share/a-cpu-base) | [20 ms](https://flamegraph.com/share/a-cpu-head) | [100 %](https://flamegraph.com/share/a-cpu-base/a-cpu-head) |
-| alloc_space | [2.0 MiB](https://flamegraph.com/share/a-alloc-base) | [2.0 MiB](https://flamegraph.com/share/a-alloc-head) | [-0.04 %](https://flamegraph.com/share/a-alloc-base/a-alloc-head) |
+| sec/op | 12.00µ | 12.50µ | 🟡 +4.17% (worse) |
+
+<sub>Head compiled with PGO from the CPU profile of base: sec/op -3.50%</sub>
+
 </details>
+`,
+		},
+		{
+			Name: "with benchstat tables",
+			R: &report.BenchmarkReport{
+				BaseRef: "abcd",
+				HeadRef: "ef00",
+				Runs: []report.BenchmarkRun{
+					{
+						Name:            "pkg1.BenchTestA",
+						BenchStatTables: benchStatTables(t),
+					},
+				},
+			},
+			expected: `### Benchmark Report
+
+__In progress__
+
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
 <details>
-    <summary><tt>pkg1.BenchTestB</tt>(scheduled)</summary>
+    <summary><tt>pkg1.BenchTestA</tt>(scheduled)</summary>
 
 | Resource | Base | Head | Diff % |
 |----------|-----:|-----:|-------:|
+
+<details>
+    <summary>benchstat</summary>
+
+` + "`goos: linux`" + `
+
+|     | base sec/op | head sec/op |                vs base |
+| --- | ----------: | ----------: | ---------------------: |
+|     | 1.000µ ± 1% | 2.000µ ± 1% | +100.00% (p=0.002 n=6) |
+</details>
+
 </details>
 `,
 		},
@@ -145,3 +1183,222 @@ abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
 		})
 	}
 }
+
+func TestGithubCommentSigned(t *testing.T) {
+	tmpl, err := newReportTemplate("")
+	require.NoError(t, err)
+
+	gh := &gitHubComment{
+		template: tmpl,
+		githubCommon: githubCommon{
+			owner: "my-org",
+			repo:  "my-repo",
+		},
+		signingKey: []byte("secret"),
+	}
+
+	// reports in progress are not signed
+	body, err := gh.render(&report.BenchmarkReport{BaseRef: "abcd", HeadRef: "ef00"})
+	require.NoError(t, err)
+	_, err = report.Verify(gh.signingKey, body)
+	require.ErrorIs(t, err, report.ErrNotSigned)
+
+	body, err = gh.render(&report.BenchmarkReport{BaseRef: "abcd", HeadRef: "ef00", Finished: true})
+	require.NoError(t, err)
+	p, err := report.Verify(gh.signingKey, body)
+	require.NoError(t, err)
+	require.Equal(t, report.Provenance{Repository: "my-org/my-repo", Head: "ef00"}, *p)
+}
+
+// testCommentServer records the bodies of the comment created and edited.
+type testCommentServer struct {
+	mu    sync.Mutex
+	posts []string
+	// rateLimited is the number of edits still to be rejected by a
+	// secondary rate limit
+	rateLimited int
+}
+
+func (s *testCommentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPatch && r.URL.Path == "/repos/my-org/my-repo/issues/comments/5" && s.rateLimited > 0:
+		s.rateLimited--
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"message":"You have exceeded a secondary rate limit.","documentation_url":"https://docs.github.com/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits"}`))
+		return
+	case r.URL.Path == "/repos/my-org/my-repo/issues/1/comments" || r.URL.Path == "/repos/my-org/my-repo/issues/comments/5":
+		var c struct {
+			Body string `json:"body"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&c)
+		s.posts = append(s.posts, c.Body)
+		_, _ = w.Write([]byte(`{"id":5}`))
+		return
+	case r.Method == http.MethodDelete:
+		// the regression label is not set
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message":"Label does not exist"}`))
+		return
+	}
+	_, _ = w.Write([]byte(`{}`))
+}
+
+func (s *testCommentServer) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var result []string
+	for _, p := range s.posts {
+		msg := "?"
+		for _, line := range strings.Split(p, "\n") {
+			if strings.HasPrefix(line, "update ") {
+				msg = line
+			}
+		}
+		if strings.Contains(p, "__Finished__") {
+			msg += " (finished)"
+		}
+		result = append(result, msg)
+	}
+	return result
+}
+
+func startTestComment(t *testing.T, srv *testCommentServer, interval time.Duration) (*gitHubComment, chan<- *report.BenchmarkReport) {
+	tmpl, err := newReportTemplate("")
+	require.NoError(t, err)
+	ch := make(chan *report.BenchmarkReport)
+	gh := testCommentReporter(t, srv)
+	gh.template = tmpl
+	gh.ch = ch
+	gh.stopCh = make(chan struct{})
+	gh.updateInterval = interval
+	gh.wg.Add(1)
+	go func() {
+		defer gh.wg.Done()
+		gh.run(context.Background())
+	}()
+	return gh, ch
+}
+
+func update(i int) *report.BenchmarkReport {
+	return &report.BenchmarkReport{Message: fmt.Sprintf("update %d", i)}
+}
+
+func TestGithubCommentDebounce(t *testing.T) {
+	srv := &testCommentServer{}
+	gh, ch := startTestComment(t, srv, 200*time.Millisecond)
+
+	// the first report creates the comment right away, the others are
+	// coalesced
+	for i := 1; i <= 5; i++ {
+		ch <- update(i)
+	}
+	require.Eventually(t, func() bool { return len(srv.messages()) == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"update 1", "update 5"}, srv.messages())
+
+	// the final report is not delayed
+	finished := update(6)
+	finished.Finished = true
+	ch <- finished
+	require.Eventually(t, func() bool { return len(srv.messages()) == 3 }, 100*time.Millisecond, 10*time.Millisecond)
+
+	require.NoError(t, gh.Stop())
+	require.Equal(t, []string{"update 1", "update 5", "update 6 (finished)"}, srv.messages())
+}
+
+func TestGithubCommentRateLimited(t *testing.T) {
+	srv := &testCommentServer{rateLimited: 1}
+	gh, ch := startTestComment(t, srv, 0)
+
+	ch <- update(1)
+	// rejected, retried after a second with the latest report
+	ch <- update(2)
+	last := update(3)
+	ch <- last
+	require.Eventually(t, func() bool { return len(srv.messages()) == 2 }, 3*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"update 1", "update 3"}, srv.messages())
+
+	// the report is finished on stop, without changing the one received
+	require.NoError(t, gh.Stop())
+	require.Equal(t, []string{"update 1", "update 3", "update 3 (finished)"}, srv.messages())
+	require.False(t, last.Finished)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Now()
+	_, ok := retryAfter(nil, now)
+	require.False(t, ok)
+	_, ok = retryAfter(errors.New("boom"), now)
+	require.False(t, ok)
+
+	wait := 30 * time.Second
+	d, ok := retryAfter(&github.AbuseRateLimitError{RetryAfter: &wait}, now)
+	require.True(t, ok)
+	require.Equal(t, 30*time.Second, d)
+
+	d, ok = retryAfter(&github.AbuseRateLimitError{}, now)
+	require.True(t, ok)
+	require.Equal(t, defaultRetryAfter, d)
+
+	d, ok = retryAfter(fmt.Errorf("wrapped: %w", &github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: now.Add(time.Minute)}}}), now)
+	require.True(t, ok)
+	require.Equal(t, time.Minute, d)
+}
+
+func benchStatTables(t *testing.T) *benchtab.Tables {
+	builder, filter, err := benchtab.NewDefaultBuilder()
+	require.NoError(t, err)
+	for i, source := range []string{"base", "head"} {
+		var lines []string
+		for _, d := range []int{0, 10, -10, 5, -5, 0} {
+			lines = append(lines, fmt.Sprintf("BenchmarkA-8 100 %d ns/op", (i+1)*(1000+d)))
+		}
+		r := benchfmt.NewReader(strings.NewReader("goos: linux\n"+strings.Join(lines, "\n")+"\n"), source)
+		for r.Scan() {
+			if res, ok := r.Result().(*benchfmt.Result); ok {
+				_, _ = filter.Apply(res)
+				res.SetConfig("source", source)
+				builder.Add(res)
+			}
+		}
+		require.NoError(t, r.Err())
+	}
+	return builder.ToTables(benchtab.TableOpts{Confidence: 0.95, Thresholds: &benchmath.DefaultThresholds})
+}
+
+func TestGithubCommentPlatformMatrix(t *testing.T) {
+	tmpl, err := newReportTemplate("")
+	require.NoError(t, err)
+	gh := &gitHubComment{
+		template:     tmpl,
+		githubCommon: githubCommon{owner: "my-org", repo: "my-repo"},
+	}
+	result := func(base, head int64) []report.BenchmarkResult {
+		return []report.BenchmarkResult{{
+			Name:      "cpu",
+			BaseValue: report.BenchmarkValue{ProfileValue: base, FlamegraphKey: "a"},
+			HeadValue: report.BenchmarkValue{ProfileValue: head, FlamegraphKey: "b"},
+		}}
+	}
+	body, err := gh.render(&report.BenchmarkReport{
+		BaseRef:  "abcd",
+		HeadRef:  "ef00",
+		Finished: true,
+		Runs: []report.BenchmarkRun{
+			{Name: "pkg.BenchmarkA", Platform: "linux/amd64", Results: result(100, 101)},
+			{Name: "pkg.BenchmarkB", Platform: "linux/amd64", Results: result(100, 100)},
+			{Name: "pkg.BenchmarkA", Platform: "linux/arm64", Results: result(100, 130)},
+		},
+	})
+	require.NoError(t, err)
+	require.Contains(t, body, `abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+
+| Benchmark | linux/amd64 | linux/arm64 |
+|-----------|------|------|
+| `+"`pkg.BenchmarkA`"+` | ⚪ (cpu=1 %) | 🔴 (cpu=30 %) |
+| `+"`pkg.BenchmarkB`"+` | ⚪ (cpu=0 %) | n/a |
+`)
+	require.Contains(t, body, "<summary><tt>pkg.BenchmarkA</tt> <sub>linux/arm64</sub>(cpu=30 %)</summary>")
+}
@@ -0,0 +1,53 @@
+package github
+
+import (
+	"context"
+
+	"github.com/google/go-github/v63/github"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// Review events, see https://docs.github.com/en/rest/pulls/reviews#create-a-review-for-a-pull-request
+const (
+	reviewApprove        = "APPROVE"
+	reviewRequestChanges = "REQUEST_CHANGES"
+	reviewComment        = "COMMENT"
+)
+
+// reviewEvent returns the review event for the verdict of the report. The
+// verdict of success is only given for completed comparisons, interrupted
+// ones are submitted as comment.
+func (gh *gitHubComment) reviewEvent(re *report.BenchmarkReport) string {
+	if re.Error != nil {
+		return reviewComment
+	}
+	if len(re.Regressions(gh.threshold)) > 0 {
+		return gh.reviewOnRegression
+	}
+	if !re.Complete() {
+		return reviewComment
+	}
+	return gh.reviewOnSuccess
+}
+
+// submitReview submits the finished report as review of the pull request, so
+// the verdict shows up in the review timeline and can block merging.
+func (gh *gitHubComment) submitReview(ctx context.Context, re *report.BenchmarkReport, body string) error {
+	if !re.Finished || re.Help != nil || gh.reviewed || !gh.features.enabled(featureReviews) {
+		return nil
+	}
+
+	review := &github.PullRequestReviewRequest{
+		Body:  github.String(body),
+		Event: github.String(gh.reviewEvent(re)),
+	}
+	if re.HeadRef != "" {
+		review.CommitID = github.String(re.HeadRef)
+	}
+	_, _, err := gh.client.PullRequests.CreateReview(ctx, gh.owner, gh.repo, gh.pr, review)
+	if err == nil {
+		gh.reviewed = true
+	}
+	return gh.features.check(gh.logger, featureReviews, err)
+}
@@ -0,0 +1,92 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestSubmitReview(t *testing.T) {
+	regressed := &report.BenchmarkReport{
+		HeadRef:  "ef00",
+		Finished: true,
+		Runs: []report.BenchmarkRun{{
+			Name: "pkg1.BenchA",
+			Results: []report.BenchmarkResult{{
+				Name:      "cpu",
+				BaseValue: report.BenchmarkValue{ProfileValue: 100, FlamegraphKey: "base"},
+				HeadValue: report.BenchmarkValue{ProfileValue: 200, FlamegraphKey: "head"},
+			}},
+		}},
+	}
+
+	for _, tc := range []struct {
+		name          string
+		r             *report.BenchmarkReport
+		expectedEvent string // empty when no review is expected
+	}{
+		{
+			name: "in progress",
+			r:    &report.BenchmarkReport{HeadRef: "ef00"},
+		},
+		{
+			name:          "regressed",
+			r:             regressed,
+			expectedEvent: reviewRequestChanges,
+		},
+		{
+			name:          "no regression",
+			r:             (&report.BenchmarkReport{HeadRef: "ef00"}).WithCompleted(),
+			expectedEvent: reviewApprove,
+		},
+		{
+			// finished by the comment reporter, once it has been stopped
+			name:          "interrupted",
+			r:             (&report.BenchmarkReport{HeadRef: "ef00", Runs: []report.BenchmarkRun{{Name: "pkg1.BenchA", Running: true}}}).WithFinished(),
+			expectedEvent: reviewComment,
+		},
+		{
+			name:          "cancelled",
+			r:             (&report.BenchmarkReport{HeadRef: "ef00"}).WithInterrupted(),
+			expectedEvent: reviewComment,
+		},
+		{
+			name:          "error",
+			r:             &report.BenchmarkReport{Error: errors.New("fatally bad"), Finished: true},
+			expectedEvent: reviewComment,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var reviews []map[string]string
+			gh := testCommentReporter(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, "/repos/my-org/my-repo/pulls/1/reviews", r.URL.Path)
+				var review map[string]string
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&review))
+				reviews = append(reviews, review)
+				_, _ = w.Write([]byte(`{}`))
+			}))
+			gh.reviewOnRegression = reviewRequestChanges
+			gh.reviewOnSuccess = reviewApprove
+
+			// the review is only submitted once
+			for i := 0; i < 2; i++ {
+				require.NoError(t, gh.submitReview(context.Background(), tc.r, "body"))
+			}
+
+			if tc.expectedEvent == "" {
+				require.Empty(t, reviews)
+				return
+			}
+			require.Len(t, reviews, 1)
+			require.Equal(t, tc.expectedEvent, reviews[0]["event"])
+			require.Equal(t, "body", reviews[0]["body"])
+			require.Equal(t, tc.r.HeadRef, reviews[0]["commit_id"])
+		})
+	}
+}
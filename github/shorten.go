@@ -0,0 +1,255 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/google/go-github/v63/github"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// maxCommentLength is the maximum size of the body of a GitHub comment.
+const maxCommentLength = 65536
+
+// gistFilename is the name of the full report in the gist.
+const gistFilename = "pyrobench-report.md"
+
+// reportView selects, which parts of the report are rendered by the template.
+type reportView struct {
+	Detailed  []report.BenchmarkRun // rendered with all their details
+	Summary   []report.BenchmarkRun // rendered as rows of the summary table
+	Collapsed []report.BenchmarkRun // without significant changes, rendered as rows of a collapsed table
+
+	Shortened  bool   // details have been left out to fit the size limit
+	Brief      bool   // leave out the removed benchmarks, code size and build sections
+	DetailsURL string // of the full report, empty when unknown
+
+	Part, Parts int // of the comments the report is split into, 0 when not split
+}
+
+func fullView(re *report.BenchmarkReport) reportView {
+	return reportView{Detailed: re.ComparedRuns()}
+}
+
+// focusedView collapses the finished runs without significant changes, to
+// keep the report focused on the interesting ones. Runs still waiting for
+// their results are always detailed.
+func focusedView(re *report.BenchmarkReport, threshold float64) reportView {
+	var v reportView
+	for _, r := range re.ComparedRuns() {
+		if len(r.Results) > 0 && !r.Running && !r.Preliminary && !r.Changed(threshold) {
+			v.Collapsed = append(v.Collapsed, r)
+		} else {
+			v.Detailed = append(v.Detailed, r)
+		}
+	}
+	return v
+}
+
+// view returns the view of the report posted as comment.
+func (gh *gitHubComment) view(re *report.BenchmarkReport) reportView {
+	if gh.collapse {
+		return focusedView(re, gh.threshold)
+	}
+	return fullView(re)
+}
+
+// shortenedViews returns the views tried in order, when the full report
+// exceeds the size limit: first the runs without significant changes are
+// collapsed, then the details of the remaining runs are left out as well.
+func shortenedViews(re *report.BenchmarkReport, threshold float64, detailsURL string) []reportView {
+	var changed, unchanged []report.BenchmarkRun
+	for _, r := range re.ComparedRuns() {
+		if r.Changed(threshold) {
+			changed = append(changed, r)
+		} else {
+			unchanged = append(unchanged, r)
+		}
+	}
+	return []reportView{
+		{Detailed: changed, Collapsed: unchanged, Shortened: true, DetailsURL: detailsURL},
+		{Summary: changed, Collapsed: unchanged, Shortened: true, Brief: true, DetailsURL: detailsURL},
+	}
+}
+
+// split returns the view for part i of n, which contains its share of the
+// rows of the summary tables.
+func (v reportView) split(i, n int) reportView {
+	rows := len(v.Summary) + len(v.Collapsed)
+	lo, hi := rows*i/n, rows*(i+1)/n
+	clip := func(runs []report.BenchmarkRun, offset int) []report.BenchmarkRun {
+		from := min(max(lo-offset, 0), len(runs))
+		to := min(max(hi-offset, 0), len(runs))
+		return runs[from:to]
+	}
+	part := v
+	part.Summary = clip(v.Summary, 0)
+	part.Collapsed = clip(v.Collapsed, len(v.Summary))
+	part.Part, part.Parts = i+1, n
+	return part
+}
+
+// shortenReport renders the report into as few comments as possible, each of
+// them at most maxLength long after being finished by sign. The report is
+// only split into several comments, when even the summary of all runs
+// exceeds the size limit.
+func shortenReport(tmpl *template.Template, owner, repo string, re *report.BenchmarkReport, views []reportView, maxLength int, sign func(string) string) ([]string, error) {
+	var last string
+	for _, v := range views {
+		body, err := renderView(tmpl, owner, repo, re, v)
+		if err != nil {
+			return nil, err
+		}
+		last = sign(body)
+		if len(last) <= maxLength {
+			return []string{last}, nil
+		}
+	}
+
+	v := views[len(views)-1]
+	rows := len(v.Summary) + len(v.Collapsed)
+	for n := max(2, (len(last)+maxLength-1)/maxLength); n <= rows; n++ {
+		bodies, err := renderParts(tmpl, owner, repo, re, v, n, maxLength, sign)
+		if err != nil {
+			return nil, err
+		}
+		if bodies != nil {
+			return bodies, nil
+		}
+	}
+	return nil, fmt.Errorf("report exceeds the size limit of %d characters per comment", maxLength)
+}
+
+// renderParts renders the view split into n comments, it returns nil, when
+// one of them exceeds maxLength.
+func renderParts(tmpl *template.Template, owner, repo string, re *report.BenchmarkReport, v reportView, n, maxLength int, sign func(string) string) ([]string, error) {
+	bodies := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		body, err := renderView(tmpl, owner, repo, re, v.split(i, n))
+		if err != nil {
+			return nil, err
+		}
+		body = sign(body)
+		if len(body) > maxLength {
+			return nil, nil
+		}
+		bodies = append(bodies, body)
+	}
+	return bodies, nil
+}
+
+// renderComments renders the bodies of the comments showing the report. When
+// the report exceeds the size limit of a comment, it is shortened and the
+// details are linked, either from a gist holding the full report or from the
+// configured details URL.
+func (gh *gitHubComment) renderComments(ctx context.Context, re *report.BenchmarkReport) ([]string, error) {
+	maxLength := gh.maxLength
+	if maxLength == 0 {
+		maxLength = maxCommentLength
+	}
+	full, err := renderReport(gh.template, gh.owner, gh.repo, re)
+	if err != nil {
+		return nil, err
+	}
+	body := full
+	if gh.collapse {
+		// the gist still holds the details of all runs
+		body, err = renderView(gh.template, gh.owner, gh.repo, re, gh.view(re))
+		if err != nil {
+			return nil, err
+		}
+	}
+	sign := func(body string) string { return gh.sign(re, body) }
+	if body := sign(body); len(body) <= maxLength {
+		return []string{body}, nil
+	}
+
+	detailsURL := gh.detailsURL
+	if url, err := gh.uploadGist(ctx, re, full); err != nil {
+		return nil, err
+	} else if url != "" {
+		detailsURL = url
+	}
+	return shortenReport(gh.template, gh.owner, gh.repo, re, shortenedViews(re, gh.threshold, detailsURL), maxLength, sign)
+}
+
+// uploadGist uploads the full report of finished benchmarks as secret gist
+// and returns its URL. Reports in progress are not uploaded, to not create a
+// new revision for every update.
+func (gh *gitHubComment) uploadGist(ctx context.Context, re *report.BenchmarkReport, full string) (string, error) {
+	if !re.Finished || !gh.features.enabled(featureGists) {
+		return "", nil
+	}
+	gist := &github.Gist{
+		Description: github.String(fmt.Sprintf("Benchmark report of %s/%s#%d", gh.owner, gh.repo, gh.pr)),
+		Files: map[github.GistFilename]github.GistFile{
+			gistFilename: {Content: github.String(full)},
+		},
+	}
+
+	var err error
+	if gh.gistID != "" {
+		gist, _, err = gh.client.Gists.Edit(ctx, gh.gistID, gist)
+	} else {
+		gist.Public = github.Bool(false)
+		gist, _, err = gh.client.Gists.Create(ctx, gist)
+	}
+	if err != nil {
+		return "", gh.features.check(gh.logger, featureGists, err)
+	}
+	gh.gistID = gist.GetID()
+	return gist.GetHTMLURL(), nil
+}
+
+// postComments posts the bodies as the comment of the report followed by its
+// continuation comments. Continuation comments no longer needed are deleted.
+func (gh *gitHubComment) postComments(ctx context.Context, bodies []string) error {
+	if err := gh.postComment(ctx, bodies[0]); err != nil {
+		return err
+	}
+	for i, body := range bodies[1:] {
+		if i < len(gh.continuationIDs) {
+			if _, _, err := gh.client.Issues.EditComment(ctx, gh.owner, gh.repo, gh.continuationIDs[i], &github.IssueComment{Body: &body}); err != nil {
+				return err
+			}
+			continue
+		}
+		c, _, err := gh.client.Issues.CreateComment(ctx, gh.owner, gh.repo, gh.pr, &github.IssueComment{Body: &body})
+		if err != nil {
+			return err
+		}
+		gh.continuationIDs = append(gh.continuationIDs, c.GetID())
+	}
+	for len(gh.continuationIDs) > len(bodies)-1 {
+		id := gh.continuationIDs[len(gh.continuationIDs)-1]
+		if _, err := gh.client.Issues.DeleteComment(ctx, gh.owner, gh.repo, id); err != nil {
+			return fmt.Errorf("failed to delete continuation comment: %w", err)
+		}
+		gh.continuationIDs = gh.continuationIDs[:len(gh.continuationIDs)-1]
+	}
+	return nil
+}
+
+// renderView renders the parts of the report selected by the view.
+func renderView(tmpl *template.Template, owner, repo string, re *report.BenchmarkReport, v reportView) (string, error) {
+	buf := &strings.Builder{}
+	if err := tmpl.Execute(buf, struct {
+		Report  *report.BenchmarkReport
+		Compare string
+		reportView
+	}{
+		Report:     re,
+		Compare:    re.MarkdownCompare(owner, repo),
+		reportView: v,
+	}); err != nil {
+		return "", err
+	}
+	if re.Request != nil && v.Part <= 1 {
+		// the first comment records the request for the daily budget
+		buf.WriteString(requestMarker(re.Request))
+	}
+	return buf.String(), nil
+}
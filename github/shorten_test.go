@@ -0,0 +1,185 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func shortenTestReport(unchanged int) *report.BenchmarkReport {
+	result := func(base, head int64) []report.BenchmarkResult {
+		return []report.BenchmarkResult{{
+			Name:      "cpu",
+			BaseValue: report.BenchmarkValue{ProfileValue: base, FlamegraphKey: "a"},
+			HeadValue: report.BenchmarkValue{ProfileValue: head, FlamegraphKey: "b"},
+		}}
+	}
+	re := &report.BenchmarkReport{
+		BaseRef:  "abcd",
+		HeadRef:  "ef00",
+		Finished: true,
+		Runs:     []report.BenchmarkRun{{Name: "pkg.BenchmarkSlower", Results: result(100, 120)}},
+	}
+	for i := 0; i < unchanged; i++ {
+		re.Runs = append(re.Runs, report.BenchmarkRun{Name: fmt.Sprintf("pkg.BenchmarkSame%d", i), Results: result(100, 101)})
+	}
+	return re
+}
+
+func TestShortenReport(t *testing.T) {
+	tmpl, err := newReportTemplate("")
+	require.NoError(t, err)
+	sign := func(body string) string { return body }
+	re := shortenTestReport(2)
+	views := shortenedViews(re, 5, "https://example.com/report.html")
+
+	full, err := renderReport(tmpl, "my-org", "my-repo", re)
+	require.NoError(t, err)
+
+	// the unchanged runs are collapsed
+	bodies, err := shortenReport(tmpl, "my-org", "my-repo", re, views, len(full)-1, sign)
+	require.NoError(t, err)
+	require.Len(t, bodies, 1)
+	require.Contains(t, bodies[0], "<summary><tt>pkg.BenchmarkSlower</tt>")
+	require.NotContains(t, bodies[0], "<summary><tt>pkg.BenchmarkSame0</tt>")
+	require.Contains(t, bodies[0], `### Benchmark Report
+
+__Finished__ — 1 regression, 2 unchanged
+abcd -> ef00 ([compare](https://github.com/my-org/my-repo/compare/abcd...ef00))
+
+> :scissors: The report has been shortened to fit into a GitHub comment. [Full report](https://example.com/report.html)
+`)
+	require.True(t, strings.HasSuffix(bodies[0], `</details>
+<details>
+    <summary>2 benchmarks with no significant change</summary>
+
+| Benchmark | Status |
+|-----------|--------|
+| `+"`pkg.BenchmarkSame0`"+` | (cpu=1 %) |
+| `+"`pkg.BenchmarkSame1`"+` | (cpu=1 %) |
+</details>
+`), bodies[0])
+
+	// the details of all runs are left out
+	bodies, err = shortenReport(tmpl, "my-org", "my-repo", re, views, len(bodies[0])-1, sign)
+	require.NoError(t, err)
+	require.Len(t, bodies, 1)
+	require.NotContains(t, bodies[0], "<summary><tt>")
+	require.Contains(t, bodies[0], `
+| Benchmark | Status |
+|-----------|--------|
+| `+"`pkg.BenchmarkSlower`"+` | (cpu=20 %) |
+<details>`)
+
+	// the summary tables are split into several comments
+	re = shortenTestReport(100)
+	views = shortenedViews(re, 5, "")
+	bodies, err = shortenReport(tmpl, "my-org", "my-repo", re, views, 2000, sign)
+	require.NoError(t, err)
+	require.Greater(t, len(bodies), 2)
+	var rows int
+	for i, body := range bodies {
+		require.LessOrEqual(t, len(body), 2000)
+		require.True(t, strings.HasPrefix(body, fmt.Sprintf("### Benchmark Report (%d/%d)", i+1, len(bodies))), body)
+		rows += strings.Count(body, "| `pkg.Benchmark")
+	}
+	require.Equal(t, 101, rows)
+	require.Contains(t, bodies[0], "__Finished__")
+	require.NotContains(t, bodies[1], "__Finished__")
+
+	// only the first comment records the request
+	re.Request = &report.Request{Author: "contributor", Time: time.Minute}
+	bodies, err = shortenReport(tmpl, "my-org", "my-repo", re, views, 2000, sign)
+	require.NoError(t, err)
+	require.Greater(t, len(bodies), 2)
+	for i, body := range bodies {
+		_, ok := parseRequestMarker(body)
+		require.Equal(t, i == 0, ok, i)
+	}
+
+	_, err = shortenReport(tmpl, "my-org", "my-repo", re, views, 100, sign)
+	require.Error(t, err)
+}
+
+func TestCollapseUnchanged(t *testing.T) {
+	tmpl, err := newReportTemplate("")
+	require.NoError(t, err)
+	re := shortenTestReport(1)
+	re.Finished = false
+	re.Runs = append(re.Runs, report.BenchmarkRun{Name: "pkg.BenchmarkRunning", Running: true})
+
+	gh := &gitHubComment{
+		template:     tmpl,
+		githubCommon: githubCommon{owner: "my-org", repo: "my-repo"},
+		threshold:    5,
+	}
+	body, err := gh.render(re)
+	require.NoError(t, err)
+	require.Contains(t, body, "<summary><tt>pkg.BenchmarkSame0</tt>")
+
+	gh.collapse = true
+	body, err = gh.render(re)
+	require.NoError(t, err)
+	require.Contains(t, body, "<summary><tt>pkg.BenchmarkSlower</tt>")
+	require.Contains(t, body, "<summary><tt>pkg.BenchmarkRunning</tt>")
+	require.NotContains(t, body, "<summary><tt>pkg.BenchmarkSame0</tt>")
+	require.True(t, strings.HasSuffix(body, `</details>
+<details>
+    <summary>1 benchmark with no significant change</summary>
+
+| Benchmark | Status |
+|-----------|--------|
+| `+"`pkg.BenchmarkSame0`"+` | (cpu=1 %) |
+</details>
+`), body)
+}
+
+// testContinuationServer records the requests made for the comments.
+type testContinuationServer struct {
+	mu       sync.Mutex
+	requests []string
+	nextID   int
+}
+
+func (s *testContinuationServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	switch r.Method {
+	case http.MethodPost:
+		s.nextID++
+		fmt.Fprintf(w, `{"id":%d}`, s.nextID)
+	case http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		_, _ = w.Write([]byte(`{}`))
+	}
+}
+
+func TestPostComments(t *testing.T) {
+	srv := &testContinuationServer{}
+	gh := testCommentReporter(t, srv)
+	ctx := context.Background()
+
+	require.NoError(t, gh.postComments(ctx, []string{"a", "b", "c"}))
+	require.Equal(t, []int64{2, 3}, gh.continuationIDs)
+	require.NoError(t, gh.postComments(ctx, []string{"a", "b"}))
+	require.Equal(t, []int64{2}, gh.continuationIDs)
+
+	require.Equal(t, []string{
+		"POST /repos/my-org/my-repo/issues/1/comments",
+		"POST /repos/my-org/my-repo/issues/1/comments",
+		"POST /repos/my-org/my-repo/issues/1/comments",
+		"PATCH /repos/my-org/my-repo/issues/comments/1",
+		"PATCH /repos/my-org/my-repo/issues/comments/2",
+		"DELETE /repos/my-org/my-repo/issues/comments/3",
+	}, srv.requests)
+}
@@ -0,0 +1,171 @@
+package github
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// gitHubStepSummary writes the final report to the files of a GitHub Actions
+// step, so later steps of the workflow can use the results.
+type gitHubStepSummary struct {
+	logger    log.Logger
+	threshold float64
+	template  *template.Template
+
+	owner, repo string
+	summaryPath string // $GITHUB_STEP_SUMMARY
+	outputPath  string // $GITHUB_OUTPUT
+
+	ch     <-chan *report.BenchmarkReport
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewStepSummaryReporter appends the rendered markdown report to
+// $GITHUB_STEP_SUMMARY and the key findings to $GITHUB_OUTPUT, once the
+// benchmarks have finished.
+func NewStepSummaryReporter(logger log.Logger, reportArgs *report.Args, ch <-chan *report.BenchmarkReport) (report.Reporter, error) {
+	summaryPath := os.Getenv("GITHUB_STEP_SUMMARY")
+	outputPath := os.Getenv("GITHUB_OUTPUT")
+	if summaryPath == "" && outputPath == "" {
+		return nil, fmt.Errorf("neither GITHUB_STEP_SUMMARY nor GITHUB_OUTPUT is set")
+	}
+
+	tmpl, err := newCustomReportTemplate(reportArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	gh := &gitHubStepSummary{
+		logger:      log.With(logger, "module", "github-step-summary"),
+		threshold:   reportArgs.PercentageThreshold,
+		template:    tmpl,
+		summaryPath: summaryPath,
+		outputPath:  outputPath,
+		ch:          ch,
+		stopCh:      make(chan struct{}),
+	}
+	if owner, repo, ok := strings.Cut(os.Getenv("GITHUB_REPOSITORY"), "/"); ok {
+		gh.owner, gh.repo = owner, repo
+	}
+
+	gh.wg.Add(1)
+	go gh.run()
+
+	return gh, nil
+}
+
+// outputs returns the key findings of the report as step outputs.
+func outputs(re *report.BenchmarkReport, threshold float64) [][2]string {
+	maxCPUDiff := math.NaN()
+	for _, run := range re.Runs {
+		for _, res := range run.Results {
+			if !strings.HasPrefix(res.Name, "cpu") {
+				continue
+			}
+			d, ok := res.Diff()
+			if !ok {
+				continue
+			}
+			if math.IsNaN(maxCPUDiff) || d > maxCPUDiff {
+				maxCPUDiff = d
+			}
+		}
+	}
+	if math.IsNaN(maxCPUDiff) {
+		maxCPUDiff = 0
+	}
+
+	failed := "false"
+	if re.Error != nil {
+		failed = "true"
+	}
+
+	return [][2]string{
+		{"benchmarks", strconv.Itoa(len(re.Runs))},
+		{"regressions", strconv.Itoa(len(re.Regressions(threshold)))},
+		{"max-cpu-diff", strconv.FormatFloat(maxCPUDiff, 'f', 2, 64)},
+		{"failed", failed},
+	}
+}
+
+func appendFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (gh *gitHubStepSummary) write(re *report.BenchmarkReport) error {
+	if gh.summaryPath != "" {
+		body, err := renderReport(gh.template, gh.owner, gh.repo, re)
+		if err != nil {
+			return err
+		}
+		if err := appendFile(gh.summaryPath, body+"\n"); err != nil {
+			return fmt.Errorf("failed to write step summary: %w", err)
+		}
+	}
+
+	if gh.outputPath != "" {
+		var sb strings.Builder
+		for _, kv := range outputs(re, gh.threshold) {
+			fmt.Fprintf(&sb, "%s=%s\n", kv[0], kv[1])
+		}
+		if err := appendFile(gh.outputPath, sb.String()); err != nil {
+			return fmt.Errorf("failed to write step outputs: %w", err)
+		}
+	}
+	return nil
+}
+
+func (gh *gitHubStepSummary) run() {
+	defer gh.wg.Done()
+
+	var lastReport *report.BenchmarkReport
+	defer func() {
+		// the files are append only, so only write the last report once
+		if lastReport == nil {
+			return
+		}
+		lastReport = lastReport.Copy().WithFinished()
+		if err := gh.write(lastReport); err != nil {
+			level.Warn(gh.logger).Log("msg", "failed to write step summary", "err", err)
+		}
+	}()
+	for {
+		select {
+		case <-gh.stopCh:
+			return
+		case re, ok := <-gh.ch:
+			if !ok {
+				return
+			}
+			if re == nil {
+				continue
+			}
+			lastReport = re
+		}
+	}
+}
+
+func (gh *gitHubStepSummary) Stop() error {
+	close(gh.stopCh)
+	gh.wg.Wait()
+	return nil
+}
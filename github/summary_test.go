@@ -0,0 +1,62 @@
+package github
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestStepSummaryReporter(t *testing.T) {
+	dir := t.TempDir()
+	summaryPath := filepath.Join(dir, "summary.md")
+	outputPath := filepath.Join(dir, "output")
+	t.Setenv("GITHUB_STEP_SUMMARY", summaryPath)
+	t.Setenv("GITHUB_OUTPUT", outputPath)
+	t.Setenv("GITHUB_REPOSITORY", "my-org/my-repo")
+
+	ch := make(chan *report.BenchmarkReport)
+	r, err := NewStepSummaryReporter(log.NewNopLogger(), &report.Args{PercentageThreshold: 5}, ch)
+	require.NoError(t, err)
+
+	ch <- &report.BenchmarkReport{BaseRef: "abcd", HeadRef: "ef00"}
+	ch <- &report.BenchmarkReport{
+		BaseRef: "abcd",
+		HeadRef: "ef00",
+		Runs: []report.BenchmarkRun{
+			{
+				Name: "pkg1.BenchTestA",
+				Results: []report.BenchmarkResult{
+					{
+						Name:      "cpu",
+						Unit:      "ns",
+						BaseValue: report.BenchmarkValue{ProfileValue: 10000000, FlamegraphKey: "a-cpu-base"},
+						HeadValue: report.BenchmarkValue{ProfileValue: 12500000, FlamegraphKey: "a-cpu-head"},
+					},
+					{
+						Name:      "alloc_space",
+						Unit:      "bytes",
+						BaseValue: report.BenchmarkValue{ProfileValue: 1000, FlamegraphKey: "a-mem-base"},
+						HeadValue: report.BenchmarkValue{ProfileValue: 1010, FlamegraphKey: "a-mem-head"},
+					},
+				},
+			},
+		},
+	}
+	close(ch)
+	require.NoError(t, r.Stop())
+
+	summary, err := os.ReadFile(summaryPath)
+	require.NoError(t, err)
+	require.Contains(t, string(summary), "__Finished__")
+	require.Contains(t, string(summary), "https://github.com/my-org/my-repo/compare/abcd...ef00")
+	require.NotContains(t, string(summary), "__In progress__")
+
+	output, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	require.Equal(t, "benchmarks=1\nregressions=1\nmax-cpu-diff=25.00\nfailed=false\n", string(output))
+}
@@ -0,0 +1,126 @@
+package github
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// templateFuncs are available to the built-in and custom report templates.
+// None of them has side effects or access to anything but their arguments.
+var templateFuncs = template.FuncMap{
+	"humanize": humanizeNumber,
+	"bytes":    humanizeBytes,
+	"emoji":    emoji,
+	"link":     link,
+}
+
+func humanizeNumber(v any) (string, error) {
+	switch n := v.(type) {
+	case int:
+		return humanize.Comma(int64(n)), nil
+	case int64:
+		return humanize.Comma(n), nil
+	case uint64:
+		return humanize.Comma(int64(n)), nil
+	case float64:
+		return humanize.CommafWithDigits(n, 2), nil
+	}
+	return "", fmt.Errorf("humanize: unsupported value %T", v)
+}
+
+func humanizeBytes(v any) (string, error) {
+	switch n := v.(type) {
+	case int:
+		return humanize.IBytes(uint64(max(n, 0))), nil
+	case int64:
+		return humanize.IBytes(uint64(max(n, 0))), nil
+	case uint64:
+		return humanize.IBytes(n), nil
+	case float64:
+		return humanize.IBytes(uint64(max(n, 0))), nil
+	}
+	return "", fmt.Errorf("bytes: unsupported value %T", v)
+}
+
+// severityFuncs classify the results, metrics and runs by the thresholds.
+func severityFuncs(t report.SeverityThresholds) template.FuncMap {
+	return template.FuncMap{
+		"severity": func(v any) (report.Severity, error) {
+			switch x := v.(type) {
+			case report.BenchmarkResult:
+				return t.Result(&x), nil
+			case *report.BenchmarkResult:
+				return t.Result(x), nil
+			case report.BenchmarkMetric:
+				return t.Metric(&x), nil
+			case *report.BenchmarkMetric:
+				return t.Metric(x), nil
+			case report.BenchmarkRun:
+				return t.Run(&x), nil
+			case *report.BenchmarkRun:
+				return t.Run(x), nil
+			}
+			return report.SeverityUnknown, fmt.Errorf("severity: unsupported value %T", v)
+		},
+		"summarize": t.Summary,
+	}
+}
+
+var emojiName = regexp.MustCompile(`^[a-z0-9_+-]+$`)
+
+// emoji returns the GitHub shortcode of the emoji, e.g. :rocket: for rocket.
+func emoji(name string) (string, error) {
+	if !emojiName.MatchString(name) {
+		return "", fmt.Errorf("emoji: invalid name %q", name)
+	}
+	return ":" + name + ":", nil
+}
+
+var linkTextEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
+
+// link returns a markdown link, only http(s) URLs are linked.
+func link(text, href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("link: unsupported URL %q", href)
+	}
+	return "[" + linkTextEscaper.Replace(text) + "](" + strings.ReplaceAll(u.String(), ")", "%29") + ")", nil
+}
+
+// newCustomReportTemplate returns the report template of the arguments: the
+// custom one, if given, otherwise the built-in one. Custom templates can use
+// the templates defined by the built-in one, e.g. {{template "summary" .}}.
+func newCustomReportTemplate(args *report.Args) (*template.Template, error) {
+	tmpl, err := newReportTemplate(reportLanguage(args))
+	if err != nil {
+		return nil, err
+	}
+	if args != nil {
+		tmpl = tmpl.Funcs(severityFuncs(args.SeverityThresholds()))
+	}
+	if args == nil || args.Template == "" || args.Template == report.BuiltinTemplate {
+		return tmpl, nil
+	}
+	path := args.Template
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report template: %w", err)
+	}
+	custom, err := tmpl.New("custom").Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid report template %s: %w", path, err)
+	}
+	// fail right away rather than with the first report
+	if _, err := renderReport(custom, "owner", "repo", &report.BenchmarkReport{}); err != nil {
+		return nil, fmt.Errorf("invalid report template %s: %w", path, err)
+	}
+	return custom, nil
+}
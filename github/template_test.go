@@ -0,0 +1,75 @@
+package github
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestCustomReportTemplate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "report.tmpl")
+	require.NoError(t, os.WriteFile(path, []byte(`## {{emoji "rocket"}} {{t "Benchmark Report"}}
+{{- range .Detailed }}
+- {{link .Name "https://example.com/runs?name=a)b"}}
+{{- range .Results }} {{.Name}} {{humanize .HeadValue.ProfileValue}} {{bytes 2048}}{{ end }}
+{{- end }}
+{{- template "summary" . }}
+`), 0o644))
+
+	tmpl, err := newCustomReportTemplate(&report.Args{Template: path, Language: "de"})
+	require.NoError(t, err)
+	body, err := renderReport(tmpl, "my-org", "my-repo", &report.BenchmarkReport{
+		Finished: true,
+		Runs: []report.BenchmarkRun{{
+			Name:    "pkg1.[BenchTestA]",
+			Results: []report.BenchmarkResult{{Name: "cpu", Unit: "ns", HeadValue: report.BenchmarkValue{ProfileValue: 11000000}}},
+		}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, `## :rocket: Benchmark-Bericht
+- [pkg1.\[BenchTestA\]](https://example.com/runs?name=a%29b) cpu 11,000,000 2.0 KiB
+`, body)
+
+	// the file of the working directory is ignored for builtin
+	builtin, err := newCustomReportTemplate(&report.Args{Template: report.BuiltinTemplate})
+	require.NoError(t, err)
+	require.Equal(t, "github", builtin.Name())
+
+	// errors are reported before the first report
+	require.NoError(t, os.WriteFile(path, []byte(`{{ exec "id" }}`), 0o644))
+	_, err = newCustomReportTemplate(&report.Args{Template: path})
+	require.ErrorContains(t, err, `function "exec" not defined`)
+	require.NoError(t, os.WriteFile(path, []byte(`{{ link "x" "javascript:alert(1)" }}`), 0o644))
+	_, err = newCustomReportTemplate(&report.Args{Template: path})
+	require.ErrorContains(t, err, "unsupported URL")
+	_, err = newCustomReportTemplate(&report.Args{Template: filepath.Join(dir, "missing.tmpl")})
+	require.Error(t, err)
+}
+
+func TestCustomReportTemplateIgnoresWorkingDirectory(t *testing.T) {
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.Chdir(dir))
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	// e.g. the head of a pull request
+	require.NoError(t, os.MkdirAll(".pyrobench", 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(".pyrobench", "report.tmpl"), []byte(`{{len .Detailed}} benchmarks`), 0o644))
+	for _, args := range []*report.Args{nil, {}} {
+		tmpl, err := newCustomReportTemplate(args)
+		require.NoError(t, err)
+		require.Equal(t, "github", tmpl.Name(), "the built-in template is used")
+	}
+
+	tmpl, err := newCustomReportTemplate(&report.Args{Template: filepath.Join(".pyrobench", "report.tmpl")})
+	require.NoError(t, err)
+	body, err := renderReport(tmpl, "my-org", "my-repo", &report.BenchmarkReport{})
+	require.NoError(t, err)
+	require.Equal(t, "0 benchmarks", body)
+}
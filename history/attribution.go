@@ -0,0 +1,54 @@
+package history
+
+import (
+	"math"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// FindBaselineShift checks whether the base value of a benchmark resource
+// moved by more than threshold percent within the recorded history of the
+// base's ancestors. ancestors are ordered from newest to oldest and start with
+// the base commit itself.
+//
+// It returns the commit range in which the baseline moved, or nil if the
+// history shows no such movement.
+func FindBaselineShift(records []Record, benchmark, resource string, baseValue float64, ancestors []string, threshold float64) *report.BaselineShift {
+	if len(ancestors) < 2 || baseValue == 0 {
+		return nil
+	}
+
+	// use the latest record per commit
+	values := make(map[string]Record)
+	for _, r := range records {
+		if r.Benchmark != benchmark || r.Resource != resource {
+			continue
+		}
+		if prev, ok := values[r.Commit]; ok && prev.Time.After(r.Time) {
+			continue
+		}
+		values[r.Commit] = r
+	}
+
+	to := ancestors[0]
+	for _, commit := range ancestors[1:] {
+		r, ok := values[commit]
+		if !ok || r.Value == 0 {
+			continue
+		}
+
+		diff := (baseValue - r.Value) / r.Value * 100
+		if math.Abs(diff) <= threshold {
+			// still the same baseline, keep on looking further back
+			to = commit
+			continue
+		}
+
+		return &report.BaselineShift{
+			From: commit,
+			To:   to,
+			Diff: diff,
+		}
+	}
+	return nil
+}
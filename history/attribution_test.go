@@ -0,0 +1,56 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestFindBaselineShift(t *testing.T) {
+	now := time.Now()
+	rec := func(commit string, value float64) Record {
+		return Record{Time: now, Commit: commit, Benchmark: "pkg.BenchmarkA", Resource: "cpu", Value: value}
+	}
+	ancestors := []string{"c5", "c4", "c3", "c2", "c1"}
+
+	for _, tc := range []struct {
+		name     string
+		records  []Record
+		base     float64
+		expected *report.BaselineShift
+	}{
+		{
+			name: "no history",
+			base: 100,
+		},
+		{
+			name:    "stable baseline",
+			records: []Record{rec("c4", 101), rec("c2", 99)},
+			base:    100,
+		},
+		{
+			name:     "baseline regressed",
+			records:  []Record{rec("c4", 101), rec("c3", 100), rec("c2", 80), rec("c1", 80)},
+			base:     100,
+			expected: &report.BaselineShift{From: "c2", To: "c3", Diff: 25},
+		},
+		{
+			name:     "regressed directly before base",
+			records:  []Record{rec("c4", 50)},
+			base:     100,
+			expected: &report.BaselineShift{From: "c4", To: "c5", Diff: 100},
+		},
+		{
+			name:    "other benchmarks are ignored",
+			records: []Record{{Time: now, Commit: "c4", Benchmark: "pkg.BenchmarkB", Resource: "cpu", Value: 50}},
+			base:    100,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, FindBaselineShift(tc.records, "pkg.BenchmarkA", "cpu", tc.base, ancestors, 5))
+		})
+	}
+}
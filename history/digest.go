@@ -0,0 +1,179 @@
+package history
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Movement is how a benchmark resource changed over a period.
+type Movement struct {
+	Benchmark string
+	Resource  string
+	Unit      string
+
+	First, Last float64 // oldest and newest value within the period
+	Diff        float64 // change from first to last in percent
+	Commits     int     // number of commits measured
+
+	// Commit introduced the largest single change, StepDiff is that change
+	// in percent.
+	Commit   string
+	StepDiff float64
+
+	// Noise is the median absolute change between consecutive commits in
+	// percent. Unlike the spread of the values, a single real change does
+	// not make a benchmark noisy.
+	Noise float64
+}
+
+// Digest summarizes the movements of all benchmarks within a period.
+type Digest struct {
+	Since, Until time.Time
+
+	Regressions  []Movement // largest increase first
+	Improvements []Movement // largest decrease first
+	Noisiest     []Movement // largest noise first
+}
+
+// BuildDigest summarizes the records within the period. When commits are
+// given, only records of these commits are considered and they are ordered
+// like them, from newest to oldest. Otherwise they are ordered by the time of
+// their record. Movements up to threshold percent are neither regressions
+// nor improvements, every list is limited to top entries.
+func BuildDigest(records []Record, since, until time.Time, commits []string, threshold float64, top int) *Digest {
+	position := make(map[string]int, len(commits))
+	for idx, c := range commits {
+		// newest commit gets the highest position
+		position[c] = len(commits) - idx
+	}
+
+	type key struct{ benchmark, resource string }
+	type point struct {
+		Record
+		pos int
+	}
+	series := make(map[key][]point)
+	units := make(map[key]string)
+	for _, r := range records {
+		if r.Time.Before(since) || !r.Time.Before(until) {
+			continue
+		}
+		pos := 0
+		if len(commits) > 0 {
+			var ok bool
+			if pos, ok = position[r.Commit]; !ok {
+				continue
+			}
+		}
+		k := key{r.Benchmark, r.Resource}
+		units[k] = r.Unit
+
+		// use the latest record per commit
+		points := series[k]
+		idx := -1
+		for i := range points {
+			if points[i].Commit == r.Commit {
+				idx = i
+			}
+		}
+		if idx < 0 {
+			series[k] = append(points, point{r, pos})
+		} else if r.Time.After(points[idx].Time) {
+			points[idx] = point{r, pos}
+		}
+	}
+
+	d := &Digest{Since: since, Until: until}
+	var movements []Movement
+	for k, points := range series {
+		if len(points) < 2 {
+			continue
+		}
+		sort.SliceStable(points, func(i, j int) bool {
+			if points[i].pos != points[j].pos {
+				return points[i].pos < points[j].pos
+			}
+			return points[i].Time.Before(points[j].Time)
+		})
+
+		first, last := points[0].Value, points[len(points)-1].Value
+		if first == 0 {
+			continue
+		}
+		m := Movement{
+			Benchmark: k.benchmark,
+			Resource:  k.resource,
+			Unit:      units[k],
+			First:     first,
+			Last:      last,
+			Diff:      (last - first) / first * 100,
+			Commits:   len(points),
+		}
+		var steps []float64
+		for i := 1; i < len(points); i++ {
+			prev := points[i-1].Value
+			if prev == 0 {
+				continue
+			}
+			step := (points[i].Value - prev) / prev * 100
+			steps = append(steps, math.Abs(step))
+			if math.Abs(step) > math.Abs(m.StepDiff) {
+				m.StepDiff = step
+				m.Commit = points[i].Commit
+			}
+		}
+		m.Noise = median(steps)
+		movements = append(movements, m)
+	}
+
+	// deterministic order for equal values
+	sort.Slice(movements, func(i, j int) bool {
+		if movements[i].Benchmark != movements[j].Benchmark {
+			return movements[i].Benchmark < movements[j].Benchmark
+		}
+		return movements[i].Resource < movements[j].Resource
+	})
+
+	for _, m := range movements {
+		if m.Diff > threshold {
+			d.Regressions = append(d.Regressions, m)
+		} else if m.Diff < -threshold {
+			d.Improvements = append(d.Improvements, m)
+		}
+	}
+	sort.SliceStable(d.Regressions, func(i, j int) bool { return d.Regressions[i].Diff > d.Regressions[j].Diff })
+	sort.SliceStable(d.Improvements, func(i, j int) bool { return d.Improvements[i].Diff < d.Improvements[j].Diff })
+
+	// a single step tells nothing about the noise
+	for _, m := range movements {
+		if m.Commits > 2 && m.Noise > 0 {
+			d.Noisiest = append(d.Noisiest, m)
+		}
+	}
+	sort.SliceStable(d.Noisiest, func(i, j int) bool { return d.Noisiest[i].Noise > d.Noisiest[j].Noise })
+
+	d.Regressions = limit(d.Regressions, top)
+	d.Improvements = limit(d.Improvements, top)
+	d.Noisiest = limit(d.Noisiest, top)
+	return d
+}
+
+func limit(m []Movement, top int) []Movement {
+	if top > 0 && len(m) > top {
+		return m[:top]
+	}
+	return m
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	mid := len(values) / 2
+	if len(values)%2 == 0 {
+		return (values[mid-1] + values[mid]) / 2
+	}
+	return values[mid]
+}
@@ -0,0 +1,67 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildDigest(t *testing.T) {
+	now := time.Date(2024, 8, 20, 12, 0, 0, 0, time.UTC)
+	since := now.Add(-7 * 24 * time.Hour)
+	rec := func(benchmark, commit string, value float64, age time.Duration) Record {
+		return Record{Time: now.Add(-age), Commit: commit, Benchmark: benchmark, Resource: "cpu", Unit: "ns", Value: value}
+	}
+	// newest first, like git rev-list
+	commits := []string{"c5", "c4", "c3", "c2", "c1"}
+
+	records := []Record{
+		// regressed in c3
+		rec("pkg.BenchmarkA", "c1", 100, 5*24*time.Hour),
+		rec("pkg.BenchmarkA", "c2", 101, 4*24*time.Hour),
+		rec("pkg.BenchmarkA", "c3", 150, 3*24*time.Hour),
+		rec("pkg.BenchmarkA", "c4", 149, 2*24*time.Hour),
+		// improved, measured twice for c4
+		rec("pkg.BenchmarkB", "c2", 200, 4*24*time.Hour),
+		rec("pkg.BenchmarkB", "c4", 300, 2*24*time.Hour),
+		rec("pkg.BenchmarkB", "c4", 100, 24*time.Hour),
+		// noisy but unchanged
+		rec("pkg.BenchmarkC", "c1", 100, 5*24*time.Hour),
+		rec("pkg.BenchmarkC", "c2", 120, 4*24*time.Hour),
+		rec("pkg.BenchmarkC", "c3", 90, 3*24*time.Hour),
+		rec("pkg.BenchmarkC", "c4", 101, 2*24*time.Hour),
+		// before the period
+		rec("pkg.BenchmarkD", "c0", 10, 10*24*time.Hour),
+		rec("pkg.BenchmarkD", "c1", 100, 5*24*time.Hour),
+		// pull request commit not on the branch
+		rec("pkg.BenchmarkE", "c3", 100, 3*24*time.Hour),
+		rec("pkg.BenchmarkE", "pr", 1000, 3*24*time.Hour-time.Hour),
+	}
+
+	d := BuildDigest(records, since, now, commits, 5, 10)
+
+	require.Len(t, d.Regressions, 1)
+	a := d.Regressions[0]
+	require.Equal(t, "pkg.BenchmarkA", a.Benchmark)
+	require.InDelta(t, 49, a.Diff, 0.001)
+	require.Equal(t, "c3", a.Commit)
+	require.Equal(t, 4, a.Commits)
+
+	require.Len(t, d.Improvements, 1)
+	require.Equal(t, "pkg.BenchmarkB", d.Improvements[0].Benchmark)
+	require.InDelta(t, -50, d.Improvements[0].Diff, 0.001)
+
+	require.Equal(t, []string{"pkg.BenchmarkC", "pkg.BenchmarkA"}, []string{d.Noisiest[0].Benchmark, d.Noisiest[1].Benchmark})
+	require.Len(t, d.Noisiest, 2)
+
+	// limited to the top entries
+	d = BuildDigest(records, since, now, commits, 5, 1)
+	require.Len(t, d.Noisiest, 1)
+
+	// without commits, records are ordered by time
+	d = BuildDigest(records, since, now, nil, 5, 10)
+	require.Len(t, d.Regressions, 2)
+	require.Equal(t, "pkg.BenchmarkE", d.Regressions[0].Benchmark)
+	require.Equal(t, "pkg.BenchmarkA", d.Regressions[1].Benchmark)
+}
@@ -0,0 +1,80 @@
+package history
+
+import (
+	"sort"
+	"time"
+)
+
+// BaselineRef marks the records of baseline runs, which measure the default
+// branch on a schedule.
+const BaselineRef = "baseline"
+
+// Drift is how the measurement of a benchmark resource moved between two
+// baseline runs of the same commit. As the code did not change, it is caused
+// by the machines running the benchmarks.
+type Drift struct {
+	Benchmark string
+	Resource  string
+	Unit      string
+	Commit    string
+
+	Previous, Current float64
+	Diff              float64   // change from previous to current in percent
+	Time              time.Time // of the current measurement
+}
+
+// Drifts returns the latest drift of every benchmark resource found in the
+// records of baseline runs, ordered by benchmark and resource.
+func Drifts(records []Record) []Drift {
+	type key struct{ benchmark, resource, commit string }
+	measurements := make(map[key][]Record)
+	for _, r := range records {
+		if r.Ref != BaselineRef {
+			continue
+		}
+		k := key{r.Benchmark, r.Resource, r.Commit}
+		measurements[k] = append(measurements[k], r)
+	}
+
+	type series struct{ benchmark, resource string }
+	latest := make(map[series]Drift)
+	for k, m := range measurements {
+		sort.SliceStable(m, func(i, j int) bool { return m[i].Time.Before(m[j].Time) })
+		current := m[len(m)-1]
+		// base and head of the same run are no separate measurements
+		idx := len(m) - 2
+		for idx >= 0 && m[idx].Time.Equal(current.Time) {
+			idx--
+		}
+		if idx < 0 || m[idx].Value == 0 {
+			continue
+		}
+		previous := m[idx]
+		s := series{k.benchmark, k.resource}
+		if d, ok := latest[s]; ok && !current.Time.After(d.Time) {
+			continue
+		}
+		latest[s] = Drift{
+			Benchmark: k.benchmark,
+			Resource:  k.resource,
+			Unit:      current.Unit,
+			Commit:    k.commit,
+			Previous:  previous.Value,
+			Current:   current.Value,
+			Diff:      (current.Value - previous.Value) / previous.Value * 100,
+			Time:      current.Time,
+		}
+	}
+
+	result := make([]Drift, 0, len(latest))
+	for _, d := range latest {
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Benchmark != result[j].Benchmark {
+			return result[i].Benchmark < result[j].Benchmark
+		}
+		return result[i].Resource < result[j].Resource
+	})
+	return result
+}
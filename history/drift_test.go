@@ -0,0 +1,40 @@
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrifts(t *testing.T) {
+	now := time.Date(2024, 8, 20, 2, 0, 0, 0, time.UTC)
+	rec := func(benchmark, commit string, value float64, age time.Duration, ref string) Record {
+		return Record{Time: now.Add(-age), Commit: commit, Ref: ref, Benchmark: benchmark, Resource: "cpu", Unit: "ns", Value: value}
+	}
+	day := 24 * time.Hour
+
+	drifts := Drifts([]Record{
+		// c1 measured on two nights, then c2 on two nights
+		rec("pkg.BenchmarkA", "c1", 100, 3*day, BaselineRef),
+		rec("pkg.BenchmarkA", "c1", 110, 2*day, BaselineRef),
+		rec("pkg.BenchmarkA", "c2", 200, 2*day, BaselineRef),
+		rec("pkg.BenchmarkA", "c2", 190, 0, BaselineRef),
+		// base and head of a single run
+		rec("pkg.BenchmarkB", "c2", 100, 0, BaselineRef),
+		rec("pkg.BenchmarkB", "c2", 120, 0, BaselineRef),
+		// pull requests are not baseline runs
+		rec("pkg.BenchmarkC", "c2", 100, day, ""),
+		rec("pkg.BenchmarkC", "c2", 150, 0, ""),
+	})
+	require.Equal(t, []Drift{{
+		Benchmark: "pkg.BenchmarkA",
+		Resource:  "cpu",
+		Unit:      "ns",
+		Commit:    "c2",
+		Previous:  200,
+		Current:   190,
+		Diff:      -5,
+		Time:      now,
+	}}, drifts)
+}
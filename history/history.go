@@ -0,0 +1,99 @@
+// Package history persists benchmark measurements across runs, so results can
+// be put into the context of earlier commits.
+package history
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+type Args struct {
+	Path string
+}
+
+func AddArgs(cmd *kingpin.CmdClause) *Args {
+	args := &Args{}
+	cmd.Flag("history-file", "Path of a JSON lines file to store benchmark results in and to read earlier results from.").PlaceHolder("PATH").StringVar(&args.Path)
+	return args
+}
+
+// Enabled returns true if a history store has been configured.
+func (a *Args) Enabled() bool {
+	return a != nil && a.Path != ""
+}
+
+// Record is a single measurement of a benchmark resource at a commit.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Commit    string    `json:"commit"`
+	Ref       string    `json:"ref,omitempty"`
+	Benchmark string    `json:"benchmark"`
+	Resource  string    `json:"resource"`
+	Unit      string    `json:"unit"`
+	Value     float64   `json:"value"`
+}
+
+type Store interface {
+	Append(ctx context.Context, records ...Record) error
+	Load(ctx context.Context) ([]Record, error)
+}
+
+// NewStore returns the store configured by the arguments.
+func NewStore(args *Args) (Store, error) {
+	if !args.Enabled() {
+		return nil, errors.New("no history store configured")
+	}
+	return &fileStore{path: args.Path}, nil
+}
+
+type fileStore struct {
+	path string
+}
+
+func (s *fileStore) Append(_ context.Context, records ...Record) error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *fileStore) Load(_ context.Context) ([]Record, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []Record
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var r Record
+		if err := dec.Decode(&r); err != nil {
+			return nil, fmt.Errorf("failed to decode history record: %w", err)
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
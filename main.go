@@ -4,13 +4,16 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 
 	"github.com/grafana/pyrobench/bench"
+	"github.com/grafana/pyrobench/report"
 )
 
 var (
@@ -20,6 +23,7 @@ var (
 
 var cfg struct {
 	verbose bool
+	quiet   bool
 }
 
 func checkError(err error) int {
@@ -32,35 +36,122 @@ func checkError(err error) int {
 
 func main() {
 	ctx := context.Background()
-	b, err := bench.New(logger)
-	if err != nil {
-		os.Exit(checkError(err))
-	}
 
 	app := kingpin.New(filepath.Base(os.Args[0]), "Compare Golang Mirco Benchmarks using CPU/Memory profiles.").UsageWriter(consoleOutput)
 	app.Flag("verbose", "Enable verbose logging.").Short('v').Default("0").BoolVar(&cfg.verbose)
+	app.Flag("quiet", "Only print the final summary.").Short('q').Default("0").BoolVar(&cfg.quiet)
 
 	compareCmd, compareArgs := bench.AddCompareCommand(app)
 
+	planCmd, planArgs := bench.AddPlanCommand(app)
+
 	gitHubCommentHookCmd, githubCommentHookArgs := bench.AddGitHubCommentHookCommand(app)
 
+	diffProfilesCmd, diffProfilesArgs := bench.AddDiffProfilesCommand(app)
+
+	watchCmd, watchArgs := bench.AddWatchCommand(app)
+
+	verifyCmd, verifyArgs := bench.AddVerifyCommand(app)
+
+	digestCmd, digestArgs := bench.AddDigestCommand(app)
+
+	baselineCmd, baselineArgs := bench.AddBaselineCommand(app)
+
+	uploaderCmd, uploaderArgs := bench.AddUploaderCommand(app)
+
+	mergeReportsCmd, mergeReportsArgs := bench.AddMergeReportsCommand(app)
+
+	runCmd, runArgs := bench.AddRunCommand(app)
+
+	agentCmd, agentArgs := bench.AddAgentCommand(app)
+
+	serverCmd, serverArgs := bench.AddServerCommand(app)
+
 	// parse command line arguments
 	parsedCmd := kingpin.MustParse(app.Parse(os.Args[1:]))
 
+	opts := []bench.Option{bench.WithOutput(os.Stdout, cfg.quiet)}
+	if !cfg.quiet && report.IsTerminal(consoleOutput) {
+		bar := report.NewProgressBar(consoleOutput)
+		logger = log.NewLogfmtLogger(bar.Wrap(consoleOutput))
+		opts = append(opts, bench.WithProgress(bar))
+	}
+
 	// enable verbose logging if requested
-	if !cfg.verbose {
+	if cfg.quiet {
+		logger = level.NewFilter(logger, level.AllowWarn())
+	} else if !cfg.verbose {
 		logger = level.NewFilter(logger, level.AllowInfo())
 	}
 
+	b, err := bench.New(logger, opts...)
+	if err != nil {
+		os.Exit(checkError(err))
+	}
+
 	switch parsedCmd {
 	case compareCmd.FullCommand():
 		if err := b.Compare(ctx, compareArgs); err != nil {
 			os.Exit(checkError(err))
 		}
+	case planCmd.FullCommand():
+		if err := b.Compare(ctx, planArgs); err != nil {
+			os.Exit(checkError(err))
+		}
 	case gitHubCommentHookCmd.FullCommand():
 		if err := b.GitHubCommentHook(ctx, githubCommentHookArgs); err != nil {
 			os.Exit(checkError(err))
 		}
+	case diffProfilesCmd.FullCommand():
+		if err := b.DiffProfiles(ctx, diffProfilesArgs); err != nil {
+			os.Exit(checkError(err))
+		}
+	case watchCmd.FullCommand():
+		// stop watching gracefully, so worktrees get cleaned up
+		watchCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := b.Watch(watchCtx, watchArgs); err != nil {
+			os.Exit(checkError(err))
+		}
+	case verifyCmd.FullCommand():
+		if err := b.Verify(ctx, verifyArgs); err != nil {
+			os.Exit(checkError(err))
+		}
+	case digestCmd.FullCommand():
+		if err := b.Digest(ctx, digestArgs); err != nil {
+			os.Exit(checkError(err))
+		}
+	case baselineCmd.FullCommand():
+		if err := b.Baseline(ctx, baselineArgs); err != nil {
+			os.Exit(checkError(err))
+		}
+	case uploaderCmd.FullCommand():
+		uploaderCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := b.Uploader(uploaderCtx, uploaderArgs); err != nil {
+			os.Exit(checkError(err))
+		}
+	case mergeReportsCmd.FullCommand():
+		if err := b.MergeReports(ctx, mergeReportsArgs); err != nil {
+			os.Exit(checkError(err))
+		}
+	case runCmd.FullCommand():
+		if err := b.Compare(ctx, runArgs); err != nil {
+			os.Exit(checkError(err))
+		}
+	case agentCmd.FullCommand():
+		agentCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := b.Agent(agentCtx, agentArgs); err != nil {
+			os.Exit(checkError(err))
+		}
+	case serverCmd.FullCommand():
+		// finish gracefully, so worktrees get cleaned up
+		serverCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := b.Server(serverCtx, serverArgs); err != nil {
+			os.Exit(checkError(err))
+		}
 	default:
 		_ = level.Error(logger).Log("msg", "unknown command", "cmd", parsedCmd)
 	}
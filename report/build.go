@@ -0,0 +1,69 @@
+package report
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Build describes the compilation of a package's test binary.
+type Build struct {
+	BinarySize  int64         // bytes of the test binary
+	CompileTime time.Duration // wall time of compiling the test binary
+	Cached      bool          // fetched from the binary cache instead of compiled
+}
+
+// PackageBuild compares the test binary of a package between base and head.
+type PackageBuild struct {
+	ImportPath string
+	Base, Head *Build // nil when the package has not been compiled on that side
+}
+
+func (p *PackageBuild) values(f func(*Build) string) string {
+	switch {
+	case p.Base == nil:
+		return "n/a → " + f(p.Head)
+	case p.Head == nil:
+		return f(p.Base) + " → n/a"
+	}
+	return f(p.Base) + " → " + f(p.Head)
+}
+
+// BinarySizeMarkdown shows the size of the test binaries of base and head.
+func (p *PackageBuild) BinarySizeMarkdown() string {
+	return p.values(func(b *Build) string { return humanize.IBytes(uint64(b.BinarySize)) })
+}
+
+// BinarySizeDiffMarkdown shows the change of the size of the test binary.
+func (p *PackageBuild) BinarySizeDiffMarkdown() string {
+	if p.Base == nil || p.Head == nil || p.Base.BinarySize == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f %%", float64(p.Head.BinarySize-p.Base.BinarySize)/float64(p.Base.BinarySize)*100)
+}
+
+// CompileTimeMarkdown shows how long compiling the test binaries of base and
+// head took. Binaries from the binary cache are marked as cached.
+func (p *PackageBuild) CompileTimeMarkdown() string {
+	return p.values(func(b *Build) string {
+		if b.Cached {
+			return "cached"
+		}
+		return b.CompileTime.Round(10 * time.Millisecond).String()
+	})
+}
+
+// CompileTimeDiffMarkdown shows the change of the compile time, n/a when
+// either binary has been cached.
+func (p *PackageBuild) CompileTimeDiffMarkdown() string {
+	if p.Base == nil || p.Head == nil || p.Base.Cached || p.Head.Cached || p.Base.CompileTime == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f %%", float64(p.Head.CompileTime-p.Base.CompileTime)/float64(p.Base.CompileTime)*100)
+}
+
+// String summarizes the comparison in a single line.
+func (p *PackageBuild) String() string {
+	return fmt.Sprintf("%s: binary %s (%s), compile time %s (%s)", p.ImportPath, p.BinarySizeMarkdown(), p.BinarySizeDiffMarkdown(), p.CompileTimeMarkdown(), p.CompileTimeDiffMarkdown())
+}
@@ -0,0 +1,59 @@
+package report
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/dustin/go-humanize"
+)
+
+// CodeSize describes the functions a package contributes to its test binary.
+type CodeSize struct {
+	TextSize  int64 // bytes of the functions in the text section
+	Functions int   // including closures and generic instantiations
+	Exported  int   // exported functions and methods
+}
+
+// PackageCodeSize compares the code size of a package between base and head.
+type PackageCodeSize struct {
+	ImportPath string
+	Base, Head *CodeSize // nil when the package has not been compiled on that side
+}
+
+func (p *PackageCodeSize) values(f func(*CodeSize) string) string {
+	switch {
+	case p.Base == nil:
+		return "n/a → " + f(p.Head)
+	case p.Head == nil:
+		return f(p.Base) + " → n/a"
+	}
+	return f(p.Base) + " → " + f(p.Head)
+}
+
+// TextSizeMarkdown shows the text size of base and head.
+func (p *PackageCodeSize) TextSizeMarkdown() string {
+	return p.values(func(c *CodeSize) string { return humanize.IBytes(uint64(c.TextSize)) })
+}
+
+// FunctionsMarkdown shows the number of functions of base and head.
+func (p *PackageCodeSize) FunctionsMarkdown() string {
+	return p.values(func(c *CodeSize) string { return strconv.Itoa(c.Functions) })
+}
+
+// ExportedMarkdown shows the number of exported functions of base and head.
+func (p *PackageCodeSize) ExportedMarkdown() string {
+	return p.values(func(c *CodeSize) string { return strconv.Itoa(c.Exported) })
+}
+
+// DiffMarkdown shows the change of the text size.
+func (p *PackageCodeSize) DiffMarkdown() string {
+	if p.Base == nil || p.Head == nil || p.Base.TextSize == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f %%", float64(p.Head.TextSize-p.Base.TextSize)/float64(p.Base.TextSize)*100)
+}
+
+// String summarizes the comparison in a single line.
+func (p *PackageCodeSize) String() string {
+	return fmt.Sprintf("%s: text %s (%s), functions %s, exported %s", p.ImportPath, p.TextSizeMarkdown(), p.DiffMarkdown(), p.FunctionsMarkdown(), p.ExportedMarkdown())
+}
@@ -0,0 +1,318 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+)
+
+// consoleResources are the results shown by the console table, in the order
+// of their columns.
+var consoleResources = []string{"cpu", "alloc_space", "alloc_objects"}
+
+// ANSI escape sequences of the colors used by the console table.
+const (
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorCyan   = "\033[36m"
+	colorReset  = "\033[0m"
+)
+
+type consoleCell struct {
+	text  string
+	color string // empty for the default color
+}
+
+type consoleReporter struct {
+	w         io.Writer
+	live      bool // redraw the table in place
+	color     bool
+	threshold float64
+
+	lines   int             // of the table drawn last, in live mode
+	printed map[string]bool // finished runs already printed, in plain mode
+
+	ch     <-chan *BenchmarkReport
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewConsoleReporter returns a reporter showing a table of the benchmarks with
+// their status and diffs on f. On a terminal the table is redrawn on every
+// report and regressions are colored, unless NO_COLOR is set. Otherwise every
+// benchmark is printed as a single line once it finished, followed by the
+// table of the final report.
+func NewConsoleReporter(f *os.File, args *Args, ch <-chan *BenchmarkReport) Reporter {
+	live := IsTerminal(f)
+	return newConsoleReporter(f, live, live && os.Getenv("NO_COLOR") == "", args.PercentageThreshold, ch)
+}
+
+func newConsoleReporter(w io.Writer, live, color bool, threshold float64, ch <-chan *BenchmarkReport) *consoleReporter {
+	r := &consoleReporter{
+		w:         w,
+		live:      live,
+		color:     color,
+		threshold: threshold,
+		printed:   make(map[string]bool),
+		ch:        ch,
+		stopCh:    make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+func (r *consoleReporter) Stop() error {
+	close(r.stopCh)
+	r.wg.Wait()
+	return nil
+}
+
+func (r *consoleReporter) run() {
+	defer r.wg.Done()
+
+	var lastReport *BenchmarkReport
+	defer func() {
+		if lastReport == nil {
+			return
+		}
+		if r.live {
+			r.redraw(lastReport)
+			return
+		}
+		r.printFinished(lastReport)
+		r.write(r.table(lastReport))
+	}()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case re, ok := <-r.ch:
+			if !ok {
+				return
+			}
+			if re == nil || len(re.Runs) == 0 {
+				continue
+			}
+			lastReport = re
+			if r.live {
+				r.redraw(re)
+			} else {
+				r.printFinished(re)
+			}
+		}
+	}
+}
+
+func (r *consoleReporter) write(lines []string) {
+	var buf bytes.Buffer
+	for _, l := range lines {
+		buf.WriteString(l)
+		buf.WriteString("\n")
+	}
+	_, _ = r.w.Write(buf.Bytes())
+}
+
+// redraw replaces the table drawn last by the table of the report.
+func (r *consoleReporter) redraw(re *BenchmarkReport) {
+	lines := r.table(re)
+	var buf bytes.Buffer
+	if r.lines > 0 {
+		// move to the first line of the previous table and clear the screen below
+		fmt.Fprintf(&buf, "\033[%dA\r\033[J", r.lines)
+	}
+	_, _ = r.w.Write(buf.Bytes())
+	r.write(lines)
+	r.lines = len(lines)
+}
+
+// printFinished prints a line for every run, which finished since the
+// previous report.
+func (r *consoleReporter) printFinished(re *BenchmarkReport) {
+	var lines []string
+	for i := range re.Runs {
+		run := &re.Runs[i]
+		status := consoleStatus(run)
+		switch {
+		case r.printed[run.Name]:
+			continue
+		case status.text == "done", status.text == "removed", run.TimedOut && !run.Running, run.Skipped, run.Constraint != "":
+		default:
+			continue
+		}
+		r.printed[run.Name] = true
+		parts := []string{run.Name, status.text}
+		for j, c := range r.diffs(run) {
+			if c.text == "-" {
+				continue
+			}
+			name := strings.ToLower(consoleHeader[2+j])
+			parts = append(parts, name+" "+c.text)
+		}
+		lines = append(lines, strings.Join(parts, " "))
+	}
+	r.write(lines)
+}
+
+var consoleHeader = []string{"BENCHMARK", "STATUS", "CPU", "ALLOC_SPACE", "ALLOC_OBJECTS", "SEC/OP"}
+
+// table renders the report as table, preceded by the compared commits and the
+// progress and followed by a legend of the markers.
+func (r *consoleReporter) table(re *BenchmarkReport) []string {
+	title := "Benchmark report"
+	if re.BaseRef != "" && re.HeadRef != "" {
+		title += fmt.Sprintf(": %s -> %s", shortCommit(re.BaseRef), shortCommit(re.HeadRef))
+	}
+	if re.Progress != nil {
+		title += fmt.Sprintf(" (%s)", re.Progress)
+	}
+	lines := []string{title}
+	if re.Error != nil {
+		lines = append(lines, r.colorize(consoleCell{text: "Error: " + re.Error.Error(), color: colorRed}))
+	}
+	if re.Message != "" {
+		lines = append(lines, re.Message)
+	}
+
+	rows := make([][]consoleCell, 0, len(re.Runs)+1)
+	header := make([]consoleCell, len(consoleHeader))
+	for i, h := range consoleHeader {
+		header[i] = consoleCell{text: h}
+	}
+	rows = append(rows, header)
+	for i := range re.Runs {
+		run := &re.Runs[i]
+		row := []consoleCell{{text: run.Name}, consoleStatus(run)}
+		rows = append(rows, append(row, r.diffs(run)...))
+	}
+	lines = append(lines, r.renderRows(rows)...)
+	return append(lines, fmt.Sprintf("! regressed by more than %s %%, ? unstable samples, * significant according to benchstat", humanize.CommafWithDigits(r.threshold, 2)))
+}
+
+// renderRows pads the cells to the width of their column. The width is taken
+// before coloring, so the escape sequences do not count.
+func (r *consoleReporter) renderRows(rows [][]consoleCell) []string {
+	var widths []int
+	for _, row := range rows {
+		for i, c := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], len([]rune(c.text)))
+		}
+	}
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		var sb strings.Builder
+		for i, c := range row {
+			if i > 0 {
+				sb.WriteString("  ")
+			}
+			if i < len(row)-1 {
+				c.text += strings.Repeat(" ", widths[i]-len([]rune(c.text)))
+			}
+			sb.WriteString(r.colorize(c))
+		}
+		lines = append(lines, strings.TrimRight(sb.String(), " "))
+	}
+	return lines
+}
+
+func (r *consoleReporter) colorize(c consoleCell) string {
+	if !r.color || c.color == "" {
+		return c.text
+	}
+	return c.color + c.text + colorReset
+}
+
+func consoleStatus(run *BenchmarkRun) consoleCell {
+	switch {
+	case run.Running:
+		return consoleCell{text: "running", color: colorCyan}
+	case run.Constraint != "":
+		return consoleCell{text: "skipped", color: colorYellow}
+	case run.Preliminary:
+		return consoleCell{text: "preliminary", color: colorYellow}
+	case run.TimedOut:
+		return consoleCell{text: "timed out", color: colorRed}
+	case run.Skipped:
+		return consoleCell{text: "skipped", color: colorYellow}
+	case run.Removed && (len(run.Results) > 0 || len(run.Metrics) > 0):
+		return consoleCell{text: "removed"}
+	case len(run.Results) > 0 || len(run.Metrics) > 0:
+		return consoleCell{text: "done"}
+	case run.Reason == "tbd":
+		return consoleCell{text: "detecting"}
+	}
+	return consoleCell{text: "scheduled"}
+}
+
+// diffs returns the cells of the resources followed by the change of sec/op.
+// Results of several GOMAXPROCS values are shown next to each other.
+func (r *consoleReporter) diffs(run *BenchmarkRun) []consoleCell {
+	cells := make([]consoleCell, 0, len(consoleResources)+1)
+	for _, name := range consoleResources {
+		var (
+			parts []string
+			color string
+		)
+		for i := range run.Results {
+			res := &run.Results[i]
+			if res.Name != name {
+				continue
+			}
+			d, ok := res.Diff()
+			if !ok {
+				continue
+			}
+			s := fmt.Sprintf("%+.1f%%", d)
+			if res.GOMAXPROCS != 0 {
+				s = fmt.Sprintf("%d:%s", res.GOMAXPROCS, s)
+			}
+			switch {
+			case res.Unstable():
+				s += "?"
+				if color == "" {
+					color = colorYellow
+				}
+			case d > r.threshold || len(res.CriticalRegressions()) > 0:
+				s += "!"
+				color = colorRed
+			case d < -r.threshold && color == "":
+				color = colorGreen
+			}
+			parts = append(parts, s)
+		}
+		if len(parts) == 0 {
+			cells = append(cells, consoleCell{text: "-"})
+			continue
+		}
+		cells = append(cells, consoleCell{text: strings.Join(parts, " "), color: color})
+	}
+
+	cell := consoleCell{text: "-"}
+	for i := range run.Metrics {
+		m := &run.Metrics[i]
+		if m.Unit != "sec/op" || m.Delta == "" {
+			continue
+		}
+		cell.text = m.Delta
+		if m.Significant() {
+			cell.text += "*"
+		}
+		switch {
+		case m.Regressed():
+			cell.color = colorRed
+		case m.Improved():
+			cell.color = colorGreen
+		}
+		break
+	}
+	return append(cells, cell)
+}
@@ -0,0 +1,114 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is written by the reporter's goroutine.
+type syncBuffer struct {
+	mtx sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.buf.String()
+}
+
+func consoleTestReport(finished bool) *BenchmarkReport {
+	re := &BenchmarkReport{
+		BaseRef:  "aaaaaaaaaaaa",
+		HeadRef:  "bbbbbbbbbbbb",
+		Progress: &RunProgress{Done: 1, Total: 2},
+		Runs: []BenchmarkRun{
+			{
+				Name: "pkg.BenchmarkA",
+				Results: []BenchmarkResult{
+					{
+						Name:      "cpu",
+						BaseValue: BenchmarkValue{ProfileValue: 100, FlamegraphKey: "a"},
+						HeadValue: BenchmarkValue{ProfileValue: 120, FlamegraphKey: "b"},
+					},
+					{
+						Name:        "alloc_space",
+						BaseValue:   BenchmarkValue{ProfileValue: 100, FlamegraphKey: "a"},
+						HeadValue:   BenchmarkValue{ProfileValue: 80, FlamegraphKey: "b"},
+						Instability: []Instability{{Source: "head"}},
+					},
+					{
+						Name:      "alloc_objects",
+						BaseValue: BenchmarkValue{ProfileValue: 100, FlamegraphKey: "a"},
+						HeadValue: BenchmarkValue{ProfileValue: 90, FlamegraphKey: "b"},
+					},
+				},
+				Metrics: []BenchmarkMetric{
+					{Unit: "sec/op", Base: 1, Head: 1.2, HasBase: true, HasHead: true, Delta: "+20.00%", Better: -1},
+				},
+			},
+			{Name: "pkg.BenchmarkB", Running: !finished},
+		},
+	}
+	if finished {
+		re.Progress.Done = 2
+		re.Runs[1].Results = []BenchmarkResult{{
+			Name:      "cpu",
+			BaseValue: BenchmarkValue{ProfileValue: 100, FlamegraphKey: "a"},
+			HeadValue: BenchmarkValue{ProfileValue: 101, FlamegraphKey: "b"},
+		}}
+		re.Runs[1].Metrics = []BenchmarkMetric{{Unit: "sec/op", HasBase: true, HasHead: true, Delta: "~"}}
+	}
+	return re
+}
+
+func TestConsoleReporterPlain(t *testing.T) {
+	out := &syncBuffer{}
+	ch := make(chan *BenchmarkReport)
+	r := newConsoleReporter(out, false, false, 5, ch)
+	ch <- consoleTestReport(false)
+	ch <- consoleTestReport(true)
+	close(ch)
+	require.NoError(t, r.Stop())
+
+	require.Equal(t, strings.Join([]string{
+		"pkg.BenchmarkA done cpu +20.0%! alloc_space -20.0%? alloc_objects -10.0% sec/op +20.00%*",
+		"pkg.BenchmarkB done cpu +1.0% sec/op ~",
+		"Benchmark report: aaaaaaa -> bbbbbbb (2/2 done)",
+		"BENCHMARK       STATUS  CPU      ALLOC_SPACE  ALLOC_OBJECTS  SEC/OP",
+		"pkg.BenchmarkA  done    +20.0%!  -20.0%?      -10.0%         +20.00%*",
+		"pkg.BenchmarkB  done    +1.0%    -            -              ~",
+		"! regressed by more than 5 %, ? unstable samples, * significant according to benchstat",
+		"",
+	}, "\n"), out.String())
+}
+
+func TestConsoleReporterLive(t *testing.T) {
+	out := &syncBuffer{}
+	ch := make(chan *BenchmarkReport)
+	r := newConsoleReporter(out, true, true, 5, ch)
+	ch <- consoleTestReport(false)
+	ch <- consoleTestReport(true)
+	close(ch)
+	require.NoError(t, r.Stop())
+
+	// the table of 5 lines is redrawn in place for every report
+	tables := strings.Split(out.String(), "\033[5A\r\033[J")
+	require.Len(t, tables, 3)
+	require.Contains(t, tables[0], "(1/2 done)")
+	require.Contains(t, tables[0], colorCyan+"running"+colorReset)
+	require.Contains(t, tables[0], colorRed+"+20.0%!"+colorReset)
+	require.Contains(t, tables[0], colorYellow+"-20.0%?    "+colorReset)
+	require.Contains(t, tables[0], colorGreen+"-10.0%       "+colorReset)
+	require.Contains(t, tables[2], "(2/2 done)")
+}
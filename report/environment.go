@@ -0,0 +1,79 @@
+package report
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Environment describes the machine the benchmarks ran on, so results can be
+// reproduced and noisy machines be spotted.
+type Environment struct {
+	GoVersion   string
+	OS          string
+	Arch        string
+	CPU         string // model name
+	NumCPU      int
+	Kernel      string
+	Governor    string  // CPU frequency scaling governor, empty if unknown
+	Turbo       string  // "on", "off" or empty if unknown
+	LoadAverage float64 // 1 minute load average before benchmarking, 0 if unknown
+	Issues      []string
+	Variables   []string // normalized environment of the test binaries, empty when inherited
+
+	BaseGoVersion string // toolchain base is built with, empty without base or if unknown
+	HeadGoVersion string // toolchain head is built with, empty if unknown
+	Toolchain     string // base, head or system when both are built with the same toolchain
+}
+
+// String summarizes the environment in a single line.
+func (e *Environment) String() string {
+	var parts []string
+	if e.GoVersion != "" {
+		parts = append(parts, e.GoVersion)
+	}
+	if e.OS != "" {
+		parts = append(parts, e.OS+"/"+e.Arch)
+	}
+	if e.CPU != "" {
+		parts = append(parts, fmt.Sprintf("%d x %s", e.NumCPU, e.CPU))
+	} else if e.NumCPU > 0 {
+		parts = append(parts, fmt.Sprintf("%d CPUs", e.NumCPU))
+	}
+	if e.Kernel != "" {
+		parts = append(parts, "kernel "+e.Kernel)
+	}
+	if e.Governor != "" {
+		parts = append(parts, "governor "+e.Governor)
+	}
+	if e.Turbo != "" {
+		parts = append(parts, "turbo "+e.Turbo)
+	}
+	if e.LoadAverage > 0 {
+		parts = append(parts, "load "+strconv.FormatFloat(e.LoadAverage, 'f', 2, 64))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ToolchainsString summarizes the toolchains base and head are built with in a
+// single line, empty if they are unknown.
+func (e *Environment) ToolchainsString() string {
+	var s string
+	switch {
+	case e.HeadGoVersion == "":
+		return ""
+	case e.BaseGoVersion == "" || e.BaseGoVersion == e.HeadGoVersion:
+		s = e.HeadGoVersion
+	default:
+		s = "base " + e.BaseGoVersion + ", head " + e.HeadGoVersion
+	}
+	if e.Toolchain != "" {
+		s += " (--toolchain=" + e.Toolchain + ")"
+	}
+	return s
+}
+
+// VariablesString lists the normalized environment in a single line.
+func (e *Environment) VariablesString() string {
+	return strings.Join(e.Variables, " ")
+}
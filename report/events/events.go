@@ -0,0 +1,123 @@
+// Package events writes the lifecycle of a benchmark comparison as newline
+// delimited JSON, so external tools can follow the progress of a run.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Type is the kind of a lifecycle event.
+type Type string
+
+const (
+	DiscoveryComplete Type = "discovery_complete" // packages and benchmarks of base and head are known
+	CompileStart      Type = "compile_start"      // a test binary is being compiled
+	CompileEnd        Type = "compile_end"
+	BenchStart        Type = "bench_start" // a benchmark starts running on base and head
+	BenchEnd          Type = "bench_end"
+	UploadDone        Type = "upload_done"   // a profile has been uploaded
+	ReportPosted      Type = "report_posted" // a report has been posted to GitHub
+)
+
+// Event is a single line of the stream. Only the fields relevant to its type
+// are set.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       Type      `json:"type"`
+	Package    string    `json:"package,omitempty"`
+	Benchmark  string    `json:"benchmark,omitempty"`
+	Source     string    `json:"source,omitempty"`  // base or head, diff for uploaded diff profiles
+	Profile    string    `json:"profile,omitempty"` // sample type of an uploaded profile
+	Packages   int       `json:"packages,omitempty"`
+	Benchmarks int       `json:"benchmarks,omitempty"`
+	Duration   float64   `json:"duration_seconds,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Finished   bool      `json:"finished,omitempty"` // the posted report is the final one
+	Error      string    `json:"error,omitempty"`
+}
+
+// Writer writes events to a stream. A nil Writer discards them, so callers
+// do not need to check whether events are enabled.
+type Writer struct {
+	mtx    sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	err    error // first write error, returned by Close
+	now    func() time.Time
+}
+
+// New writes the events to w.
+func New(w io.Writer) *Writer {
+	return &Writer{w: w, now: time.Now}
+}
+
+// Open creates the file at path to write the events to, "-" writes them to
+// stdout. An empty path disables the events and returns a nil Writer.
+func Open(path string) (*Writer, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-":
+		return New(os.Stdout), nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := New(f)
+	w.closer = f
+	return w, nil
+}
+
+// Emit writes the event as a single line, its time defaults to now.
+func (w *Writer) Emit(e Event) {
+	if w == nil {
+		return
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if e.Time.IsZero() {
+		e.Time = w.now().UTC()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err := w.w.Write(append(data, '\n')); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// Close closes the underlying file and returns the first error writing to it.
+func (w *Writer) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	if w.closer != nil {
+		if err := w.closer.Close(); err != nil && w.err == nil {
+			w.err = err
+		}
+		w.closer = nil
+	}
+	return w.err
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the writer.
+func NewContext(ctx context.Context, w *Writer) context.Context {
+	return context.WithValue(ctx, contextKey{}, w)
+}
+
+// FromContext returns the writer of the context, nil when there is none.
+func FromContext(ctx context.Context) *Writer {
+	w, _ := ctx.Value(contextKey{}).(*Writer)
+	return w
+}
@@ -0,0 +1,50 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := New(buf)
+	w.now = func() time.Time { return time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC) }
+
+	w.Emit(Event{Type: DiscoveryComplete, Packages: 2, Benchmarks: 5})
+	w.Emit(Event{Type: CompileEnd, Package: "example.com/m/a", Source: "head", Duration: 1.5})
+	require.NoError(t, w.Close())
+	require.Equal(t, `{"time":"2024-08-01T12:00:00Z","type":"discovery_complete","packages":2,"benchmarks":5}
+{"time":"2024-08-01T12:00:00Z","type":"compile_end","package":"example.com/m/a","source":"head","duration_seconds":1.5}
+`, buf.String())
+
+	// without a writer, events are discarded
+	ctx := context.Background()
+	require.Nil(t, FromContext(ctx))
+	FromContext(ctx).Emit(Event{Type: BenchStart})
+	require.NoError(t, FromContext(ctx).Close())
+
+	ctx = NewContext(ctx, w)
+	require.Same(t, w, FromContext(ctx))
+}
+
+func TestOpen(t *testing.T) {
+	w, err := Open("")
+	require.NoError(t, err)
+	require.Nil(t, w)
+
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+	w, err = Open(path)
+	require.NoError(t, err)
+	w.Emit(Event{Type: ReportPosted, URL: "https://github.com/my-org/my-repo/pull/1#issuecomment-1", Finished: true})
+	require.NoError(t, w.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"type":"report_posted","url":"https://github.com/my-org/my-repo/pull/1#issuecomment-1","finished":true}`+"\n")
+}
@@ -0,0 +1,121 @@
+package html
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"strings"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+const (
+	flamegraphWidth     = 1200
+	flamegraphRowHeight = 16
+	// flamegraphCharWidth is the approximate width of a character of the
+	// labels.
+	flamegraphCharWidth = 7
+)
+
+// diffFrame is a frame of the base and head flamegraphs merged, its values
+// are the shares of the respective total.
+type diffFrame struct {
+	name       string
+	base, head float64
+	children   []diffFrame
+}
+
+// mergeFlamegraphs merges the children of base and head, which are both
+// sorted by name. Either of them is nil for frames missing on one side.
+func mergeFlamegraphs(base, head *report.FlameNode, baseTotal, headTotal int64) []diffFrame {
+	var (
+		frames []diffFrame
+		b, h   []report.FlameNode
+	)
+	if base != nil {
+		b = base.Children
+	}
+	if head != nil {
+		h = head.Children
+	}
+	for len(b) > 0 || len(h) > 0 {
+		var bn, hn *report.FlameNode
+		switch {
+		case len(h) == 0 || (len(b) > 0 && b[0].Name < h[0].Name):
+			bn, b = &b[0], b[1:]
+		case len(b) == 0 || h[0].Name < b[0].Name:
+			hn, h = &h[0], h[1:]
+		default:
+			bn, hn, b, h = &b[0], &h[0], b[1:], h[1:]
+		}
+		f := diffFrame{children: mergeFlamegraphs(bn, hn, baseTotal, headTotal)}
+		if bn != nil {
+			f.name, f.base = bn.Name, float64(bn.Value)/float64(baseTotal)
+		}
+		if hn != nil {
+			f.name, f.head = hn.Name, float64(hn.Value)/float64(headTotal)
+		}
+		frames = append(frames, f)
+	}
+	return frames
+}
+
+func diffFlamegraphDepth(frames []diffFrame) int {
+	depth := 0
+	for _, f := range frames {
+		depth = max(depth, diffFlamegraphDepth(f.children)+1)
+	}
+	return depth
+}
+
+// diffColor returns red for frames, whose share grew from base to head, and
+// green for the ones which shrank. The larger the change, the more saturated
+// the color.
+func diffColor(f diffFrame) string {
+	change := (f.head - f.base) / (f.head + f.base)
+	hue := 0
+	if change < 0 {
+		hue = 130
+	}
+	return fmt.Sprintf("hsl(%d,%d%%,%d%%)", hue, int(math.Round(math.Abs(change)*70)), 85-int(math.Round(math.Abs(change)*25)))
+}
+
+// diffFlamegraph renders base and head flamegraph of the result as a single
+// SVG. The width of a frame is the sum of its shares in base and head, so
+// frames only present on one side are visible as well. It is empty, when the
+// flamegraph of either side has not been kept.
+func diffFlamegraph(r report.BenchmarkResult) template.HTML {
+	base, head := r.BaseValue.Flamegraph, r.HeadValue.Flamegraph
+	if base == nil || head == nil || base.Value <= 0 || head.Value <= 0 {
+		return ""
+	}
+	frames := mergeFlamegraphs(base, head, base.Value, head.Value)
+	height := diffFlamegraphDepth(frames) * flamegraphRowHeight
+	if height == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg class="diff" width="%d" height="%d" viewBox="0 0 %d %d">`, flamegraphWidth, height, flamegraphWidth, height)
+	// base and head shares add up to two at the root
+	scale := float64(flamegraphWidth) / 2
+	var draw func(frames []diffFrame, x float64, level int)
+	draw = func(frames []diffFrame, x float64, level int) {
+		for _, f := range frames {
+			w := (f.base + f.head) * scale
+			y := level * flamegraphRowHeight
+			name := html.EscapeString(f.name)
+			fmt.Fprintf(&sb, `<g><title>%s (base %.1f %%, head %.1f %%)</title><rect x="%.1f" y="%d" width="%.1f" height="%d" fill="%s" stroke="#fff" stroke-width="0.5"/>`,
+				name, f.base*100, f.head*100, x, y, w, flamegraphRowHeight, diffColor(f))
+			if chars := int(w/flamegraphCharWidth) - 1; chars >= 4 {
+				fmt.Fprintf(&sb, `<text x="%.1f" y="%d">%s</text>`, x+3, y+flamegraphRowHeight-4, html.EscapeString(shortenFrame(f.name, chars)))
+			}
+			sb.WriteString(`</g>`)
+			draw(f.children, x, level+1)
+			x += w
+		}
+	}
+	draw(frames, 0, 0)
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
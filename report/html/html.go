@@ -0,0 +1,144 @@
+// Package html renders benchmark reports as a standalone HTML page, which can
+// be archived as a CI artifact.
+package html
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dustin/go-humanize"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+//go:embed report.html.tmpl
+var reportTemplate string
+
+var funcMap = template.FuncMap{
+	"diff": func(r report.BenchmarkResult) string {
+		d, ok := r.Diff()
+		if !ok {
+			return "n/a"
+		}
+		if r.Unstable() {
+			return "unstable"
+		}
+		return humanize.CommafWithDigits(d, 2) + " %"
+	},
+	"diffValue": func(r report.BenchmarkResult) float64 {
+		d, _ := r.Diff()
+		return d
+	},
+	"diffFlamegraph": diffFlamegraph,
+	"sparkline":      sparkline,
+	"thumbnail":      thumbnail,
+}
+
+// sparkline renders the delta as a small horizontal bar centred on zero.
+// Deltas are capped at ±100 %.
+func sparkline(r report.BenchmarkResult) template.HTML {
+	const (
+		width  = 100
+		height = 12
+	)
+	d, ok := r.Diff()
+	if !ok {
+		return ""
+	}
+	d = math.Max(-100, math.Min(100, d))
+	w := math.Abs(d) / 100 * width / 2
+	x := float64(width) / 2
+	color := "#2da44e"
+	if d > 0 {
+		color = "#cf222e"
+	} else {
+		x -= w
+	}
+	return template.HTML(fmt.Sprintf(
+		`<svg class="spark" width="%d" height="%d" viewBox="0 0 %d %d"><line x1="%d" y1="0" x2="%d" y2="%d" stroke="#8c959f"/><rect x="%.1f" y="2" width="%.1f" height="%d" fill="%s"/></svg>`,
+		width, height, width, height, width/2, width/2, height, x, w, height-4, color,
+	))
+}
+
+// Render writes the report as HTML page to w.
+func Render(w io.Writer, r *report.BenchmarkReport) error {
+	tmpl, err := template.New("html").Funcs(funcMap).Parse(reportTemplate)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, r)
+}
+
+type htmlReporter struct {
+	logger log.Logger
+	path   string
+
+	ch     <-chan *report.BenchmarkReport
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewReporter returns a reporter, which (re-)writes the HTML report to path
+// on every update.
+func NewReporter(logger log.Logger, path string, ch <-chan *report.BenchmarkReport) report.Reporter {
+	r := &htmlReporter{
+		logger: log.With(logger, "module", "html-reporter"),
+		path:   path,
+		ch:     ch,
+		stopCh: make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+func (r *htmlReporter) write(re *report.BenchmarkReport) error {
+	f, err := os.CreateTemp(filepath.Dir(r.path), ".pyrobench-report-*.html")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := Render(f, re); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), r.path)
+}
+
+func (r *htmlReporter) run() {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case re, ok := <-r.ch:
+			if !ok {
+				return
+			}
+			if re == nil {
+				continue
+			}
+			if err := r.write(re); err != nil {
+				level.Warn(r.logger).Log("msg", "failed to write html report", "path", r.path, "err", err)
+			}
+		}
+	}
+}
+
+func (r *htmlReporter) Stop() error {
+	close(r.stopCh)
+	r.wg.Wait()
+	return nil
+}
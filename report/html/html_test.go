@@ -0,0 +1,91 @@
+package html
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestRender(t *testing.T) {
+	buf := new(strings.Builder)
+	require.NoError(t, Render(buf, &report.BenchmarkReport{
+		BaseRef: "abcd",
+		HeadRef: "ef00",
+		Runs: []report.BenchmarkRun{
+			{
+				Name: "pkg1.BenchTestA",
+				Results: []report.BenchmarkResult{
+					{
+						Name: "cpu",
+						Unit: "ns",
+						BaseValue: report.BenchmarkValue{
+							ProfileValue:  10000000,
+							FlamegraphKey: "a-cpu-base",
+							Flamegraph: &report.FlameNode{Name: "total", Value: 100, Children: []report.FlameNode{
+								{Name: "runtime.gcBgMarkWorker", Value: 50},
+								{Name: "testing.(*B).launch", Value: 50, Children: []report.FlameNode{{Name: "example.com/pkg1.BenchTestA", Value: 50}}},
+							}},
+						},
+						HeadValue: report.BenchmarkValue{
+							ProfileValue:  20000000,
+							FlamegraphKey: "a-cpu-head",
+							Flamegraph: &report.FlameNode{Name: "total", Value: 100, Children: []report.FlameNode{
+								{Name: "runtime.gcBgMarkWorker", Value: 25},
+								{Name: "testing.(*B).launch", Value: 75, Children: []report.FlameNode{{Name: "example.com/pkg1.BenchTestA", Value: 75}}},
+							}},
+						},
+					},
+				},
+			},
+			{
+				Name: "pkg1.BenchTestB",
+			},
+		},
+	}))
+
+	body := buf.String()
+	require.Contains(t, body, `<a href="https://flamegraph.com/share/a-cpu-base">10 ms</a>`)
+	require.Contains(t, body, `<td class="num" data-sort="100">100 %</td>`)
+	require.Contains(t, body, `<a href="https://flamegraph.com/share/a-cpu-base/a-cpu-head">Flamegraph</a>`)
+	// the diff flamegraph is inlined as well
+	require.Contains(t, body, `<svg class="diff" width="1200" height="32" viewBox="0 0 1200 32">`)
+	require.Contains(t, body, `<g><title>testing.(*B).launch (base 50.0 %, head 75.0 %)</title><rect x="450.0" y="0" width="750.0" height="16"`)
+	require.NotContains(t, body, "<iframe")
+	require.Contains(t, body, `<tt>pkg1.BenchTestB</tt></td><td>(scheduled)</td>`)
+	// thumbnails of the flamegraphs are inlined
+	require.Contains(t, body, `<svg class="flame" width="160" height="24" viewBox="0 0 160 24"><title>head</title>`)
+	require.Contains(t, body, `<g><title>testing.(*B).launch (75.0 %)</title><rect x="40.0" y="0" width="120.0" height="12"`)
+	require.Contains(t, body, `<text x="42.0" y="21">pkg1.BenchTestA</text>`)
+	// regressions are rendered red
+	require.Contains(t, body, `fill="#cf222e"`)
+}
+
+func TestDiffFlamegraph(t *testing.T) {
+	base := &report.FlameNode{Name: "total", Value: 200, Children: []report.FlameNode{
+		{Name: "main.a", Value: 100},
+		{Name: "main.removed", Value: 100},
+	}}
+	head := &report.FlameNode{Name: "total", Value: 50, Children: []report.FlameNode{
+		{Name: "main.a", Value: 25},
+		{Name: "main.added", Value: 25},
+	}}
+	require.Equal(t, []diffFrame{
+		{name: "main.a", base: 0.5, head: 0.5},
+		{name: "main.added", head: 0.5},
+		{name: "main.removed", base: 0.5},
+	}, mergeFlamegraphs(base, head, base.Value, head.Value))
+
+	svg := string(diffFlamegraph(report.BenchmarkResult{
+		BaseValue: report.BenchmarkValue{Flamegraph: base},
+		HeadValue: report.BenchmarkValue{Flamegraph: head},
+	}))
+	// unchanged frames are grey, added ones red and removed ones green
+	require.Contains(t, svg, `<title>main.a (base 50.0 %, head 50.0 %)</title><rect x="0.0" y="0" width="600.0" height="16" fill="hsl(0,0%,85%)"`)
+	require.Contains(t, svg, `<title>main.added (base 0.0 %, head 50.0 %)</title><rect x="600.0" y="0" width="300.0" height="16" fill="hsl(0,70%,60%)"`)
+	require.Contains(t, svg, `<title>main.removed (base 50.0 %, head 0.0 %)</title><rect x="900.0" y="0" width="300.0" height="16" fill="hsl(130,70%,60%)"`)
+
+	require.Empty(t, diffFlamegraph(report.BenchmarkResult{HeadValue: report.BenchmarkValue{Flamegraph: head}}))
+}
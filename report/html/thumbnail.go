@@ -0,0 +1,87 @@
+package html
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html"
+	"html/template"
+	"strings"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+const (
+	thumbnailWidth     = 160
+	thumbnailRowHeight = 12
+	// thumbnailCharWidth is the approximate width of a character of the
+	// labels, which are only drawn into frames wide enough.
+	thumbnailCharWidth = 5
+	// thumbnailLevels is the number of levels below the root drawn.
+	thumbnailLevels = 3
+)
+
+// thumbnail renders the top of a flamegraph as static SVG. The root is at the
+// top and the width of every frame is its share of the total value.
+func thumbnail(label string, n *report.FlameNode) template.HTML {
+	if n == nil || n.Value <= 0 {
+		return ""
+	}
+	height := min(thumbnailDepth(n)-1, thumbnailLevels) * thumbnailRowHeight
+	if height <= 0 {
+		return ""
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg class="flame" width="%d" height="%d" viewBox="0 0 %d %d"><title>%s</title>`, thumbnailWidth, height, thumbnailWidth, height, html.EscapeString(label))
+	scale := float64(thumbnailWidth) / float64(n.Value)
+	var draw func(children []report.FlameNode, x float64, level int)
+	draw = func(children []report.FlameNode, x float64, level int) {
+		if level >= thumbnailLevels {
+			return
+		}
+		for _, c := range children {
+			w := float64(c.Value) * scale
+			y := level * thumbnailRowHeight
+			name := html.EscapeString(c.Name)
+			fmt.Fprintf(&sb, `<g><title>%s (%.1f %%)</title><rect x="%.1f" y="%d" width="%.1f" height="%d" fill="%s" stroke="#fff" stroke-width="0.5"/>`,
+				name, float64(c.Value)/float64(n.Value)*100, x, y, w, thumbnailRowHeight, frameColor(c.Name))
+			if chars := int(w/thumbnailCharWidth) - 1; chars >= 4 {
+				fmt.Fprintf(&sb, `<text x="%.1f" y="%d">%s</text>`, x+2, y+thumbnailRowHeight-3, html.EscapeString(shortenFrame(c.Name, chars)))
+			}
+			sb.WriteString(`</g>`)
+			draw(c.Children, x, level+1)
+			x += w
+		}
+	}
+	draw(n.Children, 0, 0)
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
+
+func thumbnailDepth(n *report.FlameNode) int {
+	depth := 0
+	for i := range n.Children {
+		depth = max(depth, thumbnailDepth(&n.Children[i]))
+	}
+	return depth + 1
+}
+
+// frameColor returns a warm color, which is the same for a function in all
+// thumbnails.
+func frameColor(name string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	v := h.Sum32()
+	return fmt.Sprintf("hsl(%d,%d%%,%d%%)", v%50, 70+v/50%20, 55+v/1000%15)
+}
+
+// shortenFrame cuts the package path of the function name and truncates it
+// to chars characters.
+func shortenFrame(name string, chars int) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if r := []rune(name); len(r) > chars {
+		return string(r[:chars-1]) + "…"
+	}
+	return name
+}
@@ -0,0 +1,195 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// jsonReport is the serialized form of a report, errors are kept as their
+// message.
+type jsonReport struct {
+	*BenchmarkReport
+	Error string `json:",omitempty"`
+}
+
+// WriteJSON writes the report as JSON to w. The benchstat tables are kept
+// rendered as Markdown, so a report read back can still be posted.
+func WriteJSON(w io.Writer, r *BenchmarkReport) error {
+	c := *r
+	c.Runs = make([]BenchmarkRun, len(r.Runs))
+	for i := range r.Runs {
+		c.Runs[i] = r.Runs[i]
+		c.Runs[i].BenchStat = r.Runs[i].BenchStatMarkdown()
+	}
+	jr := jsonReport{BenchmarkReport: &c}
+	if r.Error != nil {
+		jr.Error = r.Error.Error()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&jr)
+}
+
+// ReadJSON reads a report written by WriteJSON.
+func ReadJSON(r io.Reader) (*BenchmarkReport, error) {
+	jr := jsonReport{BenchmarkReport: &BenchmarkReport{}}
+	if err := json.NewDecoder(r).Decode(&jr); err != nil {
+		return nil, err
+	}
+	if jr.Error != "" {
+		jr.BenchmarkReport.Error = errors.New(jr.Error)
+	}
+	return jr.BenchmarkReport, nil
+}
+
+// ReadJSONFile reads the report written by WriteJSON to path.
+func ReadJSONFile(path string) (*BenchmarkReport, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := ReadJSON(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading report %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Merge combines the partial reports of the shards of one comparison into a
+// single report. The reports need to compare the same commits.
+func Merge(reports ...*BenchmarkReport) (*BenchmarkReport, error) {
+	if len(reports) == 0 {
+		return nil, errors.New("no reports to merge")
+	}
+	result := &BenchmarkReport{
+		BaseRef:   reports[0].BaseRef,
+		HeadRef:   reports[0].HeadRef,
+		Finished:  true,
+		Completed: true,
+	}
+	var (
+		errs     []string
+		messages []string
+		codeSize = make(map[string]PackageCodeSize)
+		build    = make(map[string]PackageBuild)
+	)
+	for i, r := range reports {
+		if r.BaseRef != result.BaseRef || r.HeadRef != result.HeadRef {
+			return nil, fmt.Errorf("report %d compares %s -> %s instead of %s -> %s", i+1, r.BaseRef, r.HeadRef, result.BaseRef, result.HeadRef)
+		}
+		result.Runs = append(result.Runs, r.Runs...)
+		result.Finished = result.Finished && r.Finished
+		result.Completed = result.Completed && r.Completed
+		result.Interrupted = result.Interrupted || r.Interrupted
+		if result.Environment == nil {
+			result.Environment = r.Environment
+		}
+		if r.Error != nil {
+			errs = append(errs, r.Error.Error())
+		}
+		if r.Message != "" {
+			messages = append(messages, r.Message)
+		}
+		for _, s := range r.CodeSize {
+			codeSize[s.ImportPath] = s
+		}
+		for _, s := range r.Build {
+			build[s.ImportPath] = s
+		}
+	}
+	if len(errs) > 0 {
+		result.Error = errors.New(strings.Join(errs, "\n"))
+	}
+	result.Message = strings.Join(messages, "\n")
+	for _, s := range codeSize {
+		result.CodeSize = append(result.CodeSize, s)
+	}
+	sort.Slice(result.CodeSize, func(i, j int) bool {
+		return result.CodeSize[i].ImportPath < result.CodeSize[j].ImportPath
+	})
+	for _, s := range build {
+		result.Build = append(result.Build, s)
+	}
+	sort.Slice(result.Build, func(i, j int) bool {
+		return result.Build[i].ImportPath < result.Build[j].ImportPath
+	})
+	return result, nil
+}
+
+type jsonReporter struct {
+	logger log.Logger
+	path   string
+
+	ch     <-chan *BenchmarkReport
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewJSONReporter returns a reporter, which (re-)writes the report as JSON to
+// path on every update. The reports of several shards can be combined with
+// Merge.
+func NewJSONReporter(logger log.Logger, path string, ch <-chan *BenchmarkReport) Reporter {
+	r := &jsonReporter{
+		logger: log.With(logger, "module", "json-reporter"),
+		path:   path,
+		ch:     ch,
+		stopCh: make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+func (r *jsonReporter) write(re *BenchmarkReport) error {
+	f, err := os.CreateTemp(filepath.Dir(r.path), ".pyrobench-report-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := WriteJSON(f, re); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), r.path)
+}
+
+func (r *jsonReporter) run() {
+	defer r.wg.Done()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case re, ok := <-r.ch:
+			if !ok {
+				return
+			}
+			if re == nil {
+				continue
+			}
+			if err := r.write(re); err != nil {
+				level.Warn(r.logger).Log("msg", "failed to write json report", "path", r.path, "err", err)
+			}
+		}
+	}
+}
+
+func (r *jsonReporter) Stop() error {
+	close(r.stopCh)
+	r.wg.Wait()
+	return nil
+}
@@ -0,0 +1,83 @@
+package report
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	r := &BenchmarkReport{
+		BaseRef: "aaaa",
+		HeadRef: "bbbb",
+		Runs: []BenchmarkRun{{
+			Name:      "BenchmarkFoo",
+			BenchStat: "| sec/op |",
+			Results: []BenchmarkResult{{
+				Name:      "cpu",
+				Unit:      "ns",
+				BaseValue: BenchmarkValue{ProfileValue: 100},
+				HeadValue: BenchmarkValue{ProfileValue: 120},
+			}},
+		}},
+		Error:       errors.New("benchmark failed"),
+		Finished:    true,
+		Environment: &Environment{GoVersion: "go1.22.5", NumCPU: 4},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteJSON(&buf, r))
+	actual, err := ReadJSON(&buf)
+	require.NoError(t, err)
+	require.EqualError(t, actual.Error, "benchmark failed")
+	actual.Error = r.Error
+	require.Equal(t, r, actual)
+	require.Equal(t, "| sec/op |", actual.Runs[0].BenchStatMarkdown())
+}
+
+func TestMerge(t *testing.T) {
+	shard0 := &BenchmarkReport{
+		BaseRef:  "aaaa",
+		HeadRef:  "bbbb",
+		Runs:     []BenchmarkRun{{Name: "BenchmarkA"}},
+		Finished: true,
+		CodeSize: []PackageCodeSize{{ImportPath: "b"}, {ImportPath: "a"}},
+		Build:    []PackageBuild{{ImportPath: "b"}},
+	}
+	shard1 := &BenchmarkReport{
+		BaseRef:     "aaaa",
+		HeadRef:     "bbbb",
+		Runs:        []BenchmarkRun{{Name: "BenchmarkB"}, {Name: "BenchmarkC"}},
+		Error:       errors.New("BenchmarkC failed"),
+		Finished:    true,
+		Environment: &Environment{NumCPU: 4},
+		CodeSize:    []PackageCodeSize{{ImportPath: "a"}},
+		Build:       []PackageBuild{{ImportPath: "a"}},
+	}
+
+	merged, err := Merge(shard0, shard1)
+	require.NoError(t, err)
+	require.Equal(t, &BenchmarkReport{
+		BaseRef:     "aaaa",
+		HeadRef:     "bbbb",
+		Runs:        []BenchmarkRun{{Name: "BenchmarkA"}, {Name: "BenchmarkB"}, {Name: "BenchmarkC"}},
+		Error:       errors.New("BenchmarkC failed"),
+		Finished:    true,
+		Environment: &Environment{NumCPU: 4},
+		CodeSize:    []PackageCodeSize{{ImportPath: "a"}, {ImportPath: "b"}},
+		Build:       []PackageBuild{{ImportPath: "a"}, {ImportPath: "b"}},
+	}, merged)
+
+	shard1.Finished = false
+	merged, err = Merge(shard0, shard1)
+	require.NoError(t, err)
+	require.False(t, merged.Finished)
+
+	_, err = Merge(shard0, &BenchmarkReport{BaseRef: "aaaa", HeadRef: "cccc"})
+	require.EqualError(t, err, "report 2 compares aaaa -> cccc instead of aaaa -> bbbb")
+
+	_, err = Merge()
+	require.EqualError(t, err, "no reports to merge")
+}
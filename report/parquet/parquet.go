@@ -0,0 +1,157 @@
+// Package parquet exports the samples of benchmark reports as Parquet file,
+// which can be ingested by data warehouses for long-term analysis.
+package parquet
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// Row is a single sample of a benchmark run, together with the metadata of
+// the report.
+type Row struct {
+	Time       time.Time `parquet:"time,timestamp(millisecond)"`
+	BaseRef    string    `parquet:"base_ref,dict"`
+	HeadRef    string    `parquet:"head_ref,dict"`
+	Benchmark  string    `parquet:"benchmark,dict"`
+	Source     string    `parquet:"source,dict"`
+	Unit       string    `parquet:"unit,dict"`
+	Value      float64   `parquet:"value"`
+	Iterations int64     `parquet:"iterations"`
+	GOOS       string    `parquet:"goos,dict"`
+	GOARCH     string    `parquet:"goarch,dict"`
+	CPU        string    `parquet:"cpu,dict"`
+	Config     string    `parquet:"config"` // remaining configuration as key=value pairs
+	TimedOut   bool      `parquet:"timed_out"`
+}
+
+// configColumns are stored in their own column.
+var configColumns = map[string]struct{}{"goos": {}, "goarch": {}, "cpu": {}}
+
+// Rows flattens the samples of the report into rows.
+func Rows(re *report.BenchmarkReport, t time.Time) []Row {
+	var rows []Row
+	for _, run := range re.Runs {
+		for _, s := range run.Samples {
+			var config []string
+			for k, v := range s.Config {
+				if _, ok := configColumns[k]; ok {
+					continue
+				}
+				config = append(config, k+"="+v)
+			}
+			sort.Strings(config)
+
+			rows = append(rows, Row{
+				Time:       t,
+				BaseRef:    re.BaseRef,
+				HeadRef:    re.HeadRef,
+				Benchmark:  run.Name,
+				Source:     s.Source,
+				Unit:       s.Unit,
+				Value:      s.Value,
+				Iterations: int64(s.Iterations),
+				GOOS:       s.Config["goos"],
+				GOARCH:     s.Config["goarch"],
+				CPU:        s.Config["cpu"],
+				Config:     strings.Join(config, " "),
+				TimedOut:   run.TimedOut,
+			})
+		}
+	}
+	return rows
+}
+
+// Write writes the samples of the report in Parquet format.
+func Write(w io.Writer, re *report.BenchmarkReport, t time.Time) error {
+	pw := parquet.NewGenericWriter[Row](w)
+	if _, err := pw.Write(Rows(re, t)); err != nil {
+		return err
+	}
+	return pw.Close()
+}
+
+type parquetReporter struct {
+	logger log.Logger
+	path   string
+
+	ch     <-chan *report.BenchmarkReport
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewReporter returns a reporter, which writes the samples of the last report
+// to path, once the benchmarks have finished.
+func NewReporter(logger log.Logger, path string, ch <-chan *report.BenchmarkReport) report.Reporter {
+	r := &parquetReporter{
+		logger: log.With(logger, "module", "parquet-reporter"),
+		path:   path,
+		ch:     ch,
+		stopCh: make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+func (r *parquetReporter) write(re *report.BenchmarkReport) error {
+	f, err := os.CreateTemp(filepath.Dir(r.path), ".pyrobench-export-*.parquet")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := Write(f, re, time.Now()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), r.path)
+}
+
+func (r *parquetReporter) run() {
+	defer r.wg.Done()
+
+	var lastReport *report.BenchmarkReport
+	defer func() {
+		if lastReport == nil {
+			return
+		}
+		if err := r.write(lastReport); err != nil {
+			level.Warn(r.logger).Log("msg", "failed to write parquet export", "path", r.path, "err", err)
+		}
+	}()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case re, ok := <-r.ch:
+			if !ok {
+				return
+			}
+			if re == nil {
+				continue
+			}
+			lastReport = re
+		}
+	}
+}
+
+func (r *parquetReporter) Stop() error {
+	close(r.stopCh)
+	r.wg.Wait()
+	return nil
+}
@@ -0,0 +1,52 @@
+package parquet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestWrite(t *testing.T) {
+	ts := time.Date(2024, 8, 1, 12, 0, 0, 0, time.UTC)
+	re := &report.BenchmarkReport{
+		BaseRef: "abcd",
+		HeadRef: "ef00",
+		Runs: []report.BenchmarkRun{
+			{
+				Name: "pkg1.BenchTestA",
+				Samples: []report.Sample{
+					{Source: "base", Unit: "sec/op", Value: 0.01, Iterations: 100, Config: map[string]string{"goos": "linux", "goarch": "amd64", "pkg": "pkg1", "name": "BenchTestA"}},
+					{Source: "head", Unit: "sec/op", Value: 0.02, Iterations: 50, Config: map[string]string{"goos": "linux", "goarch": "amd64", "pkg": "pkg1", "name": "BenchTestA"}},
+				},
+			},
+			{
+				Name: "pkg1.BenchTestB",
+			},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, Write(buf, re, ts))
+
+	rows, err := parquet.Read[Row](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, Row{
+		Time:       ts,
+		BaseRef:    "abcd",
+		HeadRef:    "ef00",
+		Benchmark:  "pkg1.BenchTestA",
+		Source:     "head",
+		Unit:       "sec/op",
+		Value:      0.02,
+		Iterations: 50,
+		GOOS:       "linux",
+		GOARCH:     "amd64",
+		Config:     "name=BenchTestA pkg=pkg1",
+	}, rows[1])
+}
@@ -0,0 +1,184 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress tracks the progress of the phases (e.g. compile, run, upload) of a
+// benchmark comparison.
+type Progress interface {
+	// Add adds n steps to the total of the phase.
+	Add(phase string, n int)
+	// Done marks a single step of the phase as done.
+	Done(phase string)
+	// Stop finishes the progress output.
+	Stop()
+}
+
+// IsTerminal returns true if the file is an interactive terminal.
+func IsTerminal(f *os.File) bool {
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice != 0
+}
+
+type noopProgress struct{}
+
+func (noopProgress) Add(string, int) {}
+func (noopProgress) Done(string)     {}
+func (noopProgress) Stop()           {}
+
+// NewNoopProgress returns a progress, which does not output anything.
+func NewNoopProgress() Progress {
+	return noopProgress{}
+}
+
+type phaseProgress struct {
+	name        string
+	done, total int
+}
+
+// ProgressBar renders the progress of all phases in a single line, which gets
+// redrawn on every change.
+type ProgressBar struct {
+	mtx     sync.Mutex
+	w       io.Writer
+	phases  []*phaseProgress
+	visible bool
+}
+
+// NewProgressBar returns a progress bar writing to w, which should be a
+// terminal.
+func NewProgressBar(w io.Writer) *ProgressBar {
+	return &ProgressBar{w: w}
+}
+
+func (p *ProgressBar) phase(name string) *phaseProgress {
+	for _, ph := range p.phases {
+		if ph.name == name {
+			return ph
+		}
+	}
+	ph := &phaseProgress{name: name}
+	p.phases = append(p.phases, ph)
+	return ph
+}
+
+func (p *ProgressBar) Add(phase string, n int) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.phase(phase).total += n
+	p.draw()
+}
+
+func (p *ProgressBar) Done(phase string) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	p.phase(phase).done++
+	p.draw()
+}
+
+func (p *ProgressBar) Stop() {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if p.visible {
+		fmt.Fprintln(p.w)
+		p.visible = false
+	}
+}
+
+func (p *ProgressBar) line() string {
+	var done, total int
+	parts := make([]string, 0, len(p.phases))
+	for _, ph := range p.phases {
+		done += ph.done
+		total += ph.total
+		parts = append(parts, fmt.Sprintf("%s %d/%d", ph.name, ph.done, ph.total))
+	}
+	return fmt.Sprintf("%s %s", bar(done, total), strings.Join(parts, " | "))
+}
+
+// bar draws a bar filled in proportion to done of total.
+func bar(done, total int) string {
+	const width = 20
+	filled := 0
+	if total > 0 {
+		filled = min(done, total) * width / total
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "]"
+}
+
+// RunProgress summarizes how many benchmarks have finished and how long the
+// remaining ones are expected to take.
+type RunProgress struct {
+	Done      int
+	Total     int
+	Remaining time.Duration // estimated, 0 if unknown
+}
+
+// String renders the progress, e.g. "3/17 done, ~8m remaining".
+func (p *RunProgress) String() string {
+	s := fmt.Sprintf("%d/%d done", p.Done, p.Total)
+	if p.Remaining > 0 && p.Done < p.Total {
+		s += ", " + formatRemaining(p.Remaining) + " remaining"
+	}
+	return s
+}
+
+// Markdown renders the progress with a bar in front.
+func (p *RunProgress) Markdown() string {
+	return "`" + bar(p.Done, p.Total) + "` " + p.String()
+}
+
+func formatRemaining(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "<1m"
+	case d < time.Hour:
+		return fmt.Sprintf("~%dm", int(d.Round(time.Minute)/time.Minute))
+	}
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("~%dh%dm", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+func (p *ProgressBar) clear() {
+	if p.visible {
+		fmt.Fprint(p.w, "\r\033[K")
+	}
+}
+
+func (p *ProgressBar) draw() {
+	p.clear()
+	fmt.Fprint(p.w, p.line())
+	p.visible = true
+}
+
+type progressBarWriter struct {
+	p *ProgressBar
+	w io.Writer
+}
+
+func (w *progressBarWriter) Write(b []byte) (int, error) {
+	w.p.mtx.Lock()
+	defer w.p.mtx.Unlock()
+	visible := w.p.visible
+	w.p.clear()
+	n, err := w.w.Write(b)
+	if visible {
+		fmt.Fprint(w.p.w, w.p.line())
+	}
+	return n, err
+}
+
+// Wrap returns a writer, which moves the progress bar out of the way while
+// writing to w. This allows logging to the same terminal.
+func (p *ProgressBar) Wrap(w io.Writer) io.Writer {
+	return &progressBarWriter{p: p, w: w}
+}
@@ -0,0 +1,39 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressBar(t *testing.T) {
+	buf := new(bytes.Buffer)
+	p := NewProgressBar(buf)
+
+	p.Add("compile", 2)
+	p.Done("compile")
+	p.Add("run", 2)
+	require.Equal(t, "[=====               ] compile 1/2 | run 0/2", p.line())
+
+	// writing through the wrapper clears the bar and redraws it afterwards
+	buf.Reset()
+	_, err := fmt.Fprint(p.Wrap(buf), "log line\n")
+	require.NoError(t, err)
+	require.Equal(t, "\r\033[Klog line\n[=====               ] compile 1/2 | run 0/2", buf.String())
+
+	buf.Reset()
+	p.Stop()
+	require.Equal(t, "\n", buf.String())
+}
+
+func TestRunProgress(t *testing.T) {
+	require.Equal(t, "0/17 done", (&RunProgress{Total: 17}).String())
+	require.Equal(t, "3/17 done, ~8m remaining", (&RunProgress{Done: 3, Total: 17, Remaining: 8*time.Minute + 10*time.Second}).String())
+	require.Equal(t, "3/17 done, <1m remaining", (&RunProgress{Done: 3, Total: 17, Remaining: 30 * time.Second}).String())
+	require.Equal(t, "3/17 done, ~1h25m remaining", (&RunProgress{Done: 3, Total: 17, Remaining: 85 * time.Minute}).String())
+	require.Equal(t, "17/17 done", (&RunProgress{Done: 17, Total: 17, Remaining: time.Minute}).String())
+	require.Equal(t, "`[=====               ]` 1/4 done", (&RunProgress{Done: 1, Total: 4}).Markdown())
+}
@@ -1,27 +1,67 @@
 package report
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
+	"time"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/dustin/go-humanize"
 	"github.com/go-kit/log"
 	"github.com/google/go-github/v63/github"
+	"golang.org/x/perf/benchunit"
+
 	"github.com/grafana/pyrobench/benchtab"
+	"github.com/grafana/pyrobench/report/events"
 )
 
 const baseURL = "https://flamegraph.com"
 
+// OfflineKeyPrefix starts the keys of the profiles kept offline instead of
+// being uploaded, which can not be linked.
+const OfflineKeyPrefix = "offline-"
+
+// PendingKeyPrefix starts the keys of the profiles queued for a separate
+// uploader, which can not be linked until they are replaced by the key of
+// the upload.
+const PendingKeyPrefix = "pending-"
+
+// unlinked returns whether the profile of the key can not be linked.
+func unlinked(key string) bool {
+	return strings.HasPrefix(key, OfflineKeyPrefix) || strings.HasPrefix(key, PendingKeyPrefix)
+}
+
 type BenchmarkReport struct {
-	BaseRef  string
-	HeadRef  string
-	Runs     []BenchmarkRun
-	Error    error
-	Message  string
-	Finished bool
+	BaseRef     string
+	HeadRef     string
+	Runs        []BenchmarkRun
+	Error       error
+	Message     string
+	Finished    bool
+	Completed   bool         // all benchmarks have been run, unlike Finished, which reporters also set on reports stopped early
+	Interrupted bool         // the comparison has been cancelled, e.g. as a newer head superseded it
+	Environment *Environment // machine the benchmarks ran on, nil if unknown
+	Progress    *RunProgress // progress of the benchmark runs, nil before they are scheduled
+	Help        *CommandHelp // replaces the report, when the command needs explaining
+
+	CodeSize []PackageCodeSize // packages whose compiled code changed
+	Build    []PackageBuild    // packages whose test binaries changed
+
+	Request *Request // authorized request of a comment, nil otherwise
+}
+
+// Request is the benchmark time requested in a comment, once it has been
+// authorized. It is recorded in the report, so the daily budget of the author
+// only counts the requests that ran.
+type Request struct {
+	Author string
+	Time   time.Duration // estimated for the benchmarks the request matched
 }
 
 func (r *BenchmarkReport) MarkdownCompare(githubOwner, githubRepo string) string {
@@ -39,6 +79,143 @@ func (r *BenchmarkReport) MarkdownCompare(githubOwner, githubRepo string) string
 	)
 }
 
+// Regression is a benchmark resource, which got worse by more than the
+// percentage threshold.
+type Regression struct {
+	Run      *BenchmarkRun
+	Result   *BenchmarkResult
+	Diff     float64
+	Critical []CriticalFunction // critical functions, which regressed
+}
+
+// Modules returns the distinct modules of the benchmarks in order of their
+// first appearance.
+func (r *BenchmarkReport) Modules() []string {
+	var modules []string
+	for _, run := range r.Runs {
+		if run.Module != "" && !slices.Contains(modules, run.Module) {
+			modules = append(modules, run.Module)
+		}
+	}
+	return modules
+}
+
+// ComparedRuns returns the runs of the benchmarks existing in head.
+func (r *BenchmarkReport) ComparedRuns() []BenchmarkRun {
+	runs := make([]BenchmarkRun, 0, len(r.Runs))
+	for _, run := range r.Runs {
+		if !run.Removed {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// Platforms returns the platforms of the matrix the runs ran on, sorted. It is
+// empty without a matrix.
+func (r *BenchmarkReport) Platforms() []string {
+	seen := make(map[string]bool)
+	var platforms []string
+	for _, run := range r.Runs {
+		if run.Platform != "" && !seen[run.Platform] {
+			seen[run.Platform] = true
+			platforms = append(platforms, run.Platform)
+		}
+	}
+	slices.Sort(platforms)
+	return platforms
+}
+
+// PlatformRow holds the runs of a benchmark on each of the platforms of the
+// matrix, nil where it did not run on a platform.
+type PlatformRow struct {
+	Name string
+	Runs []*BenchmarkRun // in the order of Platforms
+}
+
+// PlatformMatrix returns a row per benchmark compared on the platforms of the
+// matrix, in the order of their first run. It is empty without a matrix.
+func (r *BenchmarkReport) PlatformMatrix() []PlatformRow {
+	platforms := r.Platforms()
+	if len(platforms) == 0 {
+		return nil
+	}
+	column := make(map[string]int, len(platforms))
+	for i, p := range platforms {
+		column[p] = i
+	}
+	var rows []PlatformRow
+	index := make(map[string]int)
+	for i := range r.Runs {
+		run := &r.Runs[i]
+		if run.Removed || run.Platform == "" {
+			continue
+		}
+		idx, ok := index[run.Name]
+		if !ok {
+			idx = len(rows)
+			index[run.Name] = idx
+			rows = append(rows, PlatformRow{Name: run.Name, Runs: make([]*BenchmarkRun, len(platforms))})
+		}
+		rows[idx].Runs[column[run.Platform]] = run
+	}
+	return rows
+}
+
+// RemovedRuns returns the runs of the benchmarks, which only exist in base.
+func (r *BenchmarkReport) RemovedRuns() []BenchmarkRun {
+	var runs []BenchmarkRun
+	for _, run := range r.Runs {
+		if run.Removed {
+			runs = append(runs, run)
+		}
+	}
+	return runs
+}
+
+// Regressions returns all benchmark results of the report, whose head value
+// exceeds the base value by more than threshold percent. Results with a
+// regression of a critical function are always included.
+func (r *BenchmarkReport) Regressions(threshold float64) []Regression {
+	var regressions []Regression
+	for i := range r.Runs {
+		regressions = append(regressions, r.Runs[i].Regressions(threshold)...)
+	}
+	return regressions
+}
+
+// Copy returns a copy of the report, whose fields and lists of runs, code
+// sizes and builds can be changed without affecting r. The values they refer
+// to, e.g. the results of the runs, are shared and must not be changed.
+func (r *BenchmarkReport) Copy() *BenchmarkReport {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.Runs = slices.Clone(r.Runs)
+	c.CodeSize = slices.Clone(r.CodeSize)
+	c.Build = slices.Clone(r.Build)
+	return &c
+}
+
+// MapFlamegraphKeys replaces the flamegraph keys of all results by the ones f
+// returns. The runs and results are cloned first, as reports share them.
+func (r *BenchmarkReport) MapFlamegraphKeys(f func(key string) string) {
+	r.Runs = slices.Clone(r.Runs)
+	for i := range r.Runs {
+		results := slices.Clone(r.Runs[i].Results)
+		for j := range results {
+			res := &results[j]
+			for _, key := range []*string{&res.BaseValue.FlamegraphKey, &res.HeadValue.FlamegraphKey, &res.DiffFlamegraphKey} {
+				if *key != "" {
+					*key = f(*key)
+				}
+			}
+		}
+		r.Runs[i].Results = results
+	}
+}
+
 func (r *BenchmarkReport) WithMessage(message string) *BenchmarkReport {
 	r.Message = message
 	return r
@@ -49,31 +226,521 @@ func (r *BenchmarkReport) WithFinished() *BenchmarkReport {
 	return r
 }
 
+// WithCompleted marks the report as final report of a comparison, which ran
+// all of its benchmarks.
+func (r *BenchmarkReport) WithCompleted() *BenchmarkReport {
+	r.Completed = true
+	return r.WithFinished()
+}
+
+// WithInterrupted marks the report as final report of a cancelled comparison.
+func (r *BenchmarkReport) WithInterrupted() *BenchmarkReport {
+	r.Interrupted = true
+	return r.WithFinished()
+}
+
+// Complete returns true, when the comparison completed and all of its
+// benchmarks ran to the end, so its verdict covers all of them.
+func (r *BenchmarkReport) Complete() bool {
+	if !r.Completed || r.Interrupted || r.Error != nil {
+		return false
+	}
+	for _, run := range r.Runs {
+		if run.TimedOut || run.Skipped || run.Running {
+			return false
+		}
+	}
+	return true
+}
+
 func (r *BenchmarkReport) WithError(err error) *BenchmarkReport {
 	r.Error = err
 	return r.WithFinished()
 }
 
+func (r *BenchmarkReport) WithHelp(h *CommandHelp) *BenchmarkReport {
+	r.Help = h
+	return r.WithFinished()
+}
+
+// CommandHelp explains how to trigger benchmarks from a comment.
+type CommandHelp struct {
+	BotName string
+	Command string // which could not be parsed, empty when the help was asked for
+	Problem string // why the command could not be parsed
+	Usage   bool   // explain the syntax of the commands
+
+	Benchmarks     []PackageBenchmarks // available in head
+	MoreBenchmarks int                 // not listed to keep the comment short
+}
+
+// Invalid returns true, if the help replies to an invalid command.
+func (h *CommandHelp) Invalid() bool {
+	return h.Problem != ""
+}
+
+// PackageBenchmarks are the benchmarks of a package.
+type PackageBenchmarks struct {
+	Dir   string // relative to the repository root
+	Names []string
+}
+
 type BenchmarkRun struct {
 	Name            string
+	Module          string // path of the module containing the benchmark
 	Reason          string
 	Results         []BenchmarkResult
-	BenchStatTables *benchtab.Tables
+	BenchStatTables *benchtab.Tables  `json:"-"`
+	BenchStat       string            // BenchStatTables rendered as Markdown, kept in JSON reports
+	Metrics         []BenchmarkMetric // values reported by the benchmark itself
+	TimedOut        bool              // at least one of the benchmark runs exceeded its timeout
+	Running         bool              // the benchmark is currently running
+	Skipped         bool              // not run, as the total time budget has been exhausted
+	Removed         bool              // only exists in base, so it ran on base only
+	Preliminary     bool              // only numbers of a quick run with a short bench time are known yet
+	Constraint      string            // build constraint excluding the benchmark from the target platform, it did not run
+	Platform        string            // GOOS/GOARCH of the platform matrix the benchmark ran on, empty without a matrix
+
+	// File and Line locate the benchmark function, File is relative to the
+	// repository root.
+	File string
+	Line int
+
+	Samples []Sample // all measurements of base and head
+
+	PGODelta string // change of sec/op of head compiled with PGO against base, empty without PGO
+
+	CPU       []CPUUsage       // cores and frequencies observed while running
+	TestMain  []TestMainTiming // time spent outside of the benchmarks
+	Resources []ResourceUsage  // memory high-water mark and GC pressure of the test binaries
+	Warnings  []string         // conditions which might affect the validity of the results
+
+	GoroutineLeak *GoroutineLeak  // nil unless head leaks more goroutines than base
+	Traces        []Trace         // execution traces captured after the benchmark regressed
+	Hotspots      []FunctionDelta // functions with the largest change of flat CPU time
+	HotLines      []HotLine       // lines of head with the largest flat values in regressed functions
+}
+
+// FunctionDelta is the change of a function's flat CPU time. The base value is
+// scaled to the iterations of head.
+type FunctionDelta struct {
+	Name       string
+	Base, Head int64 // in nanoseconds
+}
+
+func (f *FunctionDelta) BaseMarkdown() string {
+	return (&BenchmarkValue{ProfileValue: f.Base}).Format("ns")
+}
+
+func (f *FunctionDelta) HeadMarkdown() string {
+	return (&BenchmarkValue{ProfileValue: f.Head}).Format("ns")
+}
+
+// DeltaMarkdown returns the signed difference, e.g. "+1.2 ms".
+func (f *FunctionDelta) DeltaMarkdown() string {
+	d := f.Head - f.Base
+	sign := "+"
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	return sign + (&BenchmarkValue{ProfileValue: d}).Format("ns")
+}
+
+// DiffMarkdown returns the change relative to base, "new" when the function
+// did not show up in base.
+func (f *FunctionDelta) DiffMarkdown() string {
+	if f.Base == 0 {
+		return "new"
+	}
+	return humanize.CommafWithDigits(float64(f.Head-f.Base)/float64(f.Base)*100, 2) + " %"
+}
+
+// HotLine is a line of head with a large flat value of a profile, within a
+// function whose flat value increased against base.
+type HotLine struct {
+	Resource string // name of the profile, e.g. cpu or alloc_space
+	Unit     string // of the values, e.g. ns or bytes
+	File     string // relative to the repository root
+	Line     int
+	Function string
+	Value    int64   // flat value of the line in head
+	Share    float64 // percentage of the head profile's total value
+	Increase int64   // of the function's flat value, the base scaled to head
+}
+
+// resourceNames are the names of the profiles in prose.
+var resourceNames = map[string]string{
+	"cpu":           "CPU time",
+	"alloc_space":   "allocated memory",
+	"alloc_objects": "allocated objects",
+	"inuse_space":   "retained memory",
+	"inuse_objects": "retained objects",
+}
+
+// Markdown describes the line's share of the benchmark's resource usage.
+func (l *HotLine) Markdown(benchmark string) string {
+	name := resourceNames[l.Resource]
+	if name == "" {
+		name = l.Resource
+	}
+	return fmt.Sprintf(
+		"%s %% of the %s of `%s` (%s) is spent on this line of `%s`, whose flat %s increased by %s.",
+		humanize.CommafWithDigits(l.Share, 2),
+		name,
+		benchmark,
+		(&BenchmarkValue{ProfileValue: l.Value}).Format(l.Unit),
+		l.Function,
+		name,
+		(&BenchmarkValue{ProfileValue: l.Increase}).Format(l.Unit),
+	)
+}
+
+// Trace is an execution trace of either base or head, written by the test
+// binary's -test.trace flag.
+type Trace struct {
+	Source string
+	Path   string // artifact path of the trace
+	URL    string // where the artifact is published, empty when unknown
+}
+
+// Markdown links to the trace, if it is published, or names its path.
+func (t *Trace) Markdown() string {
+	if t.URL != "" {
+		return fmt.Sprintf("[%s](%s)", t.Source, t.URL)
+	}
+	return fmt.Sprintf("%s `%s`", t.Source, t.Path)
+}
+
+// CPUUsage describes on which cores the benchmark of either base or head ran
+// and their frequencies during the run.
+type CPUUsage struct {
+	Source     string
+	Cores      []int // sorted
+	MinFreqKHz uint64
+	MaxFreqKHz uint64
+	AvgFreqKHz uint64
+}
+
+// FormatFrequency formats a frequency given in kHz.
+func FormatFrequency(khz uint64) string {
+	return strconv.FormatFloat(float64(khz)/1e6, 'f', 2, 64) + " GHz"
+}
+
+// formatCores formats a sorted list of cores as ranges, e.g. "0-3,6".
+func formatCores(cores []int) string {
+	var parts []string
+	for i := 0; i < len(cores); {
+		j := i
+		for j+1 < len(cores) && cores[j+1] == cores[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(cores[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", cores[i], cores[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+func (u *CPUUsage) String() string {
+	s := fmt.Sprintf("%s on cores %s", u.Source, formatCores(u.Cores))
+	if u.AvgFreqKHz > 0 {
+		s += fmt.Sprintf(" at %s-%s", strings.TrimSuffix(FormatFrequency(u.MinFreqKHz), " GHz"), FormatFrequency(u.MaxFreqKHz))
+	}
+	return s
+}
+
+// Regressions returns the results of the run, which regressed by more than
+// threshold percent or regressed in a critical function. The diff of unstable
+// results is not trusted.
+func (r *BenchmarkRun) Regressions(threshold float64) []Regression {
+	var regressions []Regression
+	for j := range r.Results {
+		res := &r.Results[j]
+		d, ok := res.Diff()
+		critical := res.CriticalRegressions()
+		if !ok || ((res.Unstable() || d <= math.Max(threshold, math.Abs(res.Drift))) && len(critical) == 0) {
+			continue
+		}
+		regressions = append(regressions, Regression{Run: r, Result: res, Diff: d, Critical: critical})
+	}
+	return regressions
+}
+
+// Changed returns true, when the run regressed or improved by more than
+// threshold percent, benchstat found a significant change of a metric or the
+// run raised warnings. Runs without results have not changed.
+func (r *BenchmarkRun) Changed(threshold float64) bool {
+	if len(r.Regressions(threshold)) > 0 || r.TimedOut || len(r.Warnings) > 0 || r.GoroutineLeak != nil {
+		return true
+	}
+	for i := range r.Results {
+		res := &r.Results[i]
+		if d, ok := res.Diff(); ok && !res.Unstable() && d < -math.Max(threshold, math.Abs(res.Drift)) {
+			return true
+		}
+	}
+	for i := range r.Metrics {
+		if r.Metrics[i].Significant() {
+			return true
+		}
+	}
+	return false
+}
+
+// BenchStatMarkdown returns the benchstat tables of the run as Markdown, it is
+// empty without tables. Reports read from JSON keep the rendered tables.
+func (r *BenchmarkRun) BenchStatMarkdown() string {
+	if r.BenchStatTables == nil {
+		return r.BenchStat
+	}
+	buf := &strings.Builder{}
+	if err := benchtab.RenderMarkdown(buf, r.BenchStatTables); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(buf.String())
+}
+
+// CPUMarkdown summarizes the CPU usage of base and head.
+func (r *BenchmarkRun) CPUMarkdown() string {
+	parts := make([]string, 0, len(r.CPU))
+	for i := range r.CPU {
+		parts = append(parts, r.CPU[i].String())
+	}
+	return "CPU: " + strings.Join(parts, ", ")
+}
+
+// TestMainTiming is the time the test binary of either base or head spent
+// in TestMain before and after running the benchmarks. It is not part of the
+// benchmark results.
+type TestMainTiming struct {
+	Source   string
+	Setup    time.Duration
+	Teardown time.Duration
+}
+
+// testMainThreshold is the time spent outside of the benchmarks, from which
+// on it is worth mentioning in the report.
+const testMainThreshold = time.Second
+
+// TestMainMarkdown summarizes the setup and teardown time of base and head.
+// It is empty, when neither took long enough to be relevant.
+func (r *BenchmarkRun) TestMainMarkdown() string {
+	var relevant bool
+	parts := make([]string, 0, len(r.TestMain))
+	for _, t := range r.TestMain {
+		if t.Setup >= testMainThreshold || t.Teardown >= testMainThreshold {
+			relevant = true
+		}
+		parts = append(parts, fmt.Sprintf(
+			"%s setup %s, teardown %s",
+			t.Source,
+			t.Setup.Round(time.Millisecond),
+			t.Teardown.Round(time.Millisecond),
+		))
+	}
+	if !relevant {
+		return ""
+	}
+	return "TestMain (excluded): " + strings.Join(parts, "; ")
+}
+
+// ResourceUsage is the peak memory and the garbage collection pressure of
+// the test binary of either base or head. Unlike the profiles, it covers the
+// whole process.
+type ResourceUsage struct {
+	Source       string
+	MaxRSS       int64         // bytes, 0 if unknown
+	GCCycles     int           // 0 if not traced
+	GCPauseTotal time.Duration // stop the world pauses of all cycles
+}
+
+func percentChange(base, head float64) string {
+	if base == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%+.1f %%)", (head-base)/base*100)
+}
+
+// ResourcesMarkdown summarizes the resource usage of base and head. The
+// head's values are compared with the base.
+func (r *BenchmarkRun) ResourcesMarkdown() string {
+	var base *ResourceUsage
+	for i := range r.Resources {
+		if r.Resources[i].Source == "base" {
+			base = &r.Resources[i]
+		}
+	}
+
+	parts := make([]string, 0, len(r.Resources))
+	for i := range r.Resources {
+		u := &r.Resources[i]
+		compare := base != nil && u != base
+		var values []string
+		if u.MaxRSS > 0 {
+			v := "max RSS " + humanize.IBytes(uint64(u.MaxRSS))
+			if compare {
+				v += percentChange(float64(base.MaxRSS), float64(u.MaxRSS))
+			}
+			values = append(values, v)
+		}
+		if u.GCCycles > 0 {
+			v := fmt.Sprintf("GC pauses %s in %d cycles", u.GCPauseTotal.Round(time.Microsecond), u.GCCycles)
+			if compare {
+				v += percentChange(float64(base.GCPauseTotal), float64(u.GCPauseTotal))
+			}
+			values = append(values, v)
+		}
+		if len(values) > 0 {
+			parts = append(parts, u.Source+" "+strings.Join(values, ", "))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Memory: " + strings.Join(parts, "; ")
+}
+
+// GoroutineLeak compares the goroutines still running after the benchmarks
+// of base and head finished.
+type GoroutineLeak struct {
+	Base, Head int           // number of leaked goroutines
+	Stacks     []LeakedStack // leaked more often by head than by base, most first
+}
+
+// LeakedStack is a stack of goroutines leaked by head.
+type LeakedStack struct {
+	Count int    // leaked by head in excess of base
+	Stack string // one line per frame, innermost first
+}
+
+// Markdown summarizes how many goroutines base and head leaked.
+func (l *GoroutineLeak) Markdown() string {
+	return fmt.Sprintf("Head leaks %d goroutines after the benchmark, base %d.", l.Head, l.Base)
+}
+
+// BenchmarkMetric is a unit reported by the benchmark itself, like sec/op,
+// B/op, allocs/op or a custom unit of b.ReportMetric, summarized by
+// benchstat.
+type BenchmarkMetric struct {
+	Unit             string
+	Base, Head       float64 // center of the samples
+	HasBase, HasHead bool
+	Delta            string // benchstat's delta, "~" when not significant
+	Better           int    // +1 if higher values are better, -1 if lower values are better, 0 if unknown
+}
+
+// Significant returns true, when benchstat found a statistically significant
+// difference between base and head.
+func (m *BenchmarkMetric) Significant() bool {
+	return m.Delta != "" && m.Delta != "~"
+}
+
+// direction returns +1 if head is an improvement over base, -1 if it is a
+// regression and 0 if it is neither or the direction of the unit is unknown.
+func (m *BenchmarkMetric) direction() int {
+	if !m.Significant() || m.Better == 0 {
+		return 0
+	}
+	switch {
+	case m.Head > m.Base:
+		return m.Better
+	case m.Head < m.Base:
+		return -m.Better
+	}
+	return 0
+}
+
+// Improved returns true, when head is significantly better than base.
+func (m *BenchmarkMetric) Improved() bool {
+	return m.direction() > 0
+}
+
+// Regressed returns true, when head is significantly worse than base.
+func (m *BenchmarkMetric) Regressed() bool {
+	return m.direction() < 0
+}
+
+func (m *BenchmarkMetric) format(v float64) string {
+	var values []float64
+	if m.HasBase {
+		values = append(values, m.Base)
+	}
+	if m.HasHead {
+		values = append(values, m.Head)
+	}
+	scaler := benchunit.CommonScale(values, benchunit.ClassOf(m.Unit))
+	return scaler.Format(v)
+}
+
+func (m *BenchmarkMetric) BaseMarkdown() string {
+	if !m.HasBase {
+		return "n/a"
+	}
+	return m.format(m.Base)
+}
+
+func (m *BenchmarkMetric) HeadMarkdown() string {
+	if !m.HasHead {
+		return "n/a"
+	}
+	return m.format(m.Head)
+}
+
+func (m *BenchmarkMetric) DeltaMarkdown() string {
+	if m.Delta == "" {
+		return "n/a"
+	}
+	switch m.direction() {
+	case 1:
+		return m.Delta + " (better)"
+	case -1:
+		return m.Delta + " (worse)"
+	}
+	return m.Delta
+}
+
+// Sample is a single measurement reported by the testing package.
+type Sample struct {
+	Source     string // base or head
+	Unit       string
+	Value      float64
+	Iterations int
+	Config     map[string]string // configuration of the benchmark run, like goos or cpu
+	Time       time.Time         // when the run finished, zero when unknown
 }
 
 func (r *BenchmarkRun) Status() string {
 	if len(r.Results) == 0 {
-		if r.Reason == "tbd" {
+		if r.Constraint != "" {
+			return "(skipped: build constraints " + r.Constraint + ")"
+		} else if r.Preliminary {
+			return r.preliminaryStatus()
+		} else if r.TimedOut {
+			return "(timed out)"
+		} else if r.Running {
+			return "(running)"
+		} else if r.Skipped {
+			return "(skipped, time budget exhausted)"
+		} else if r.Reason == "tbd" {
 			return "(detect code changes)"
 		} else {
 			return "(scheduled)"
 		}
 	}
+	if r.Removed {
+		if r.TimedOut {
+			return "(removed, timed out)"
+		}
+		return "(removed)"
+	}
 	var sb strings.Builder
 	sb.WriteString("(")
 	first := true
 	for _, result := range r.Results {
-		d, ok := result.diff()
+		d, ok := result.Diff()
 		if !ok {
 			continue
 		}
@@ -83,27 +750,80 @@ func (r *BenchmarkRun) Status() string {
 			sb.WriteString(", ")
 		}
 
-		sb.WriteString(result.Name)
+		sb.WriteString(result.Resource())
 		sb.WriteString("=")
+		if result.Unstable() {
+			sb.WriteString("unstable")
+			continue
+		}
 		sb.WriteString(humanize.CommafWithDigits(d, 2))
 		sb.WriteString(" %")
 	}
+	if r.TimedOut {
+		if !first {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("timed out")
+	}
 
 	sb.WriteString(")")
 
 	return sb.String()
 }
 
+// preliminaryStatus returns the status of a run, of which only the numbers of
+// the quick estimate are known, with the change of sec/op they show.
+func (r *BenchmarkRun) preliminaryStatus() string {
+	parts := []string{"preliminary"}
+	for _, m := range r.Metrics {
+		if m.Unit != "sec/op" || !m.HasBase || !m.HasHead || m.Base == 0 {
+			continue
+		}
+		diff := math.Round((m.Head-m.Base)/m.Base*10000) / 100
+		parts = append(parts, "sec/op="+humanize.CommafWithDigits(diff, 2)+" %")
+	}
+	if r.Running {
+		parts = append(parts, "running")
+	} else if r.Skipped {
+		parts = append(parts, "skipped, time budget exhausted")
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
 type BenchmarkValue struct {
 	ProfileValue  int64
 	FlamegraphKey string
+	ExploreURL    string // link to the profile in Grafana Explore, empty when not pushed to Pyroscope
+
+	// DownsampleFactor is the ratio of the original to the uploaded number
+	// of samples. The profile got downsampled to fit the size limit, when it
+	// is larger than 1.
+	DownsampleFactor float64
+
+	// Flamegraph holds the top levels of the flamegraph of the profile, nil
+	// when the profile has not been kept.
+	Flamegraph *FlameNode
 }
 
-func (v *BenchmarkValue) markdown(unit string) string {
-	if v.FlamegraphKey == "" {
-		return "n/a"
+// FlameNode is a frame of a flamegraph. Its value includes the
+// values of its children, which are sorted by name.
+type FlameNode struct {
+	Name     string
+	Value    int64
+	Children []FlameNode
+}
+
+// Downsampled describes how much the uploaded profile got downsampled, it is
+// empty when it was not.
+func (v *BenchmarkValue) Downsampled() string {
+	if v.DownsampleFactor <= 1 {
+		return ""
 	}
+	return "downsampled " + strconv.FormatFloat(v.DownsampleFactor, 'f', 1, 64) + "x"
+}
 
+// Format returns the human readable value in the given unit.
+func (v *BenchmarkValue) Format(unit string) string {
 	var val string
 	switch unit {
 	case "ns":
@@ -112,14 +832,103 @@ func (v *BenchmarkValue) markdown(unit string) string {
 		val = humanize.IBytes(uint64(v.ProfileValue))
 	case "":
 		val = humanize.SI(float64(v.ProfileValue), "")
+	default:
+		// units of custom sample types
+		val = strings.TrimSpace(humanize.SI(float64(v.ProfileValue), "")) + " " + unit
+	}
+	return strings.TrimSpace(val)
+}
+
+// FlamegraphURL returns the link to the flamegraph of the value's profile, it
+// is empty when the profile has been kept offline or not been uploaded yet.
+func (v *BenchmarkValue) FlamegraphURL() string {
+	if unlinked(v.FlamegraphKey) {
+		return ""
+	}
+	return fmt.Sprintf("%s/share/%s", baseURL, v.FlamegraphKey)
+}
+
+func (v *BenchmarkValue) markdown(unit string) string {
+	if v.FlamegraphKey == "" {
+		return "n/a"
+	}
+
+	md := v.Format(unit)
+	if url := v.FlamegraphURL(); url != "" {
+		md = fmt.Sprintf("[%s](%s)", md, url)
+	}
+	if v.ExploreURL != "" {
+		md += fmt.Sprintf(" ([explore](%s))", v.ExploreURL)
+	}
+	if d := v.Downsampled(); d != "" {
+		md += " (" + d + ")"
+	}
+	return md
+}
+
+// BaselineShift records that the base value itself moved within the commits
+// From..To according to the history of earlier runs.
+type BaselineShift struct {
+	From, To string
+	Diff     float64 // percentage the baseline moved
+}
+
+func shortCommit(c string) string {
+	if len(c) > 7 {
+		return c[:7]
 	}
-	val = strings.TrimSpace(val)
+	return c
+}
+
+func (s *BaselineShift) Markdown(resource string) string {
+	verb := "regressed"
+	if s.Diff < 0 {
+		verb = "improved"
+	}
+	return fmt.Sprintf(
+		"The base itself %s `%s` by %s %% between `%s` and `%s`, part of this diff may pre-date this PR.",
+		verb,
+		resource,
+		humanize.CommafWithDigits(math.Abs(s.Diff), 2),
+		shortCommit(s.From),
+		shortCommit(s.To),
+	)
+}
+
+// WithinDrift returns true, when the diff does not exceed the machine drift
+// measured by the baseline runs.
+func (r *BenchmarkResult) WithinDrift() bool {
+	d, ok := r.Diff()
+	return ok && r.Drift != 0 && math.Abs(d) <= math.Abs(r.Drift)
+}
+
+// DriftMarkdown explains, that the diff might be caused by the machines.
+func (r *BenchmarkResult) DriftMarkdown() string {
+	return fmt.Sprintf(
+		"The diff of `%s` is within the machine drift of %s %% measured by the latest baseline runs, it might not be caused by this change.",
+		r.Resource(),
+		humanize.CommafWithDigits(math.Abs(r.Drift), 2),
+	)
+}
 
+// CriticalFunction is a function marked as critical, whose cumulative value
+// changed significantly between base and head.
+type CriticalFunction struct {
+	Name string
+	Diff float64 // percentage of the change
+}
+
+func (f *CriticalFunction) Markdown(resource string) string {
+	verb := "regressed"
+	if f.Diff < 0 {
+		verb = "improved"
+	}
 	return fmt.Sprintf(
-		"[%s](%s/share/%s)",
-		val,
-		baseURL,
-		v.FlamegraphKey,
+		"Critical function `%s` %s `%s` by %s %%.",
+		f.Name,
+		verb,
+		resource,
+		humanize.CommafWithDigits(math.Abs(f.Diff), 2),
 	)
 }
 
@@ -127,7 +936,54 @@ func (v *BenchmarkValue) markdown(unit string) string {
 type BenchmarkResult struct {
 	Name                 string
 	Unit                 string
+	GOMAXPROCS           int // the benchmark ran with, 0 for the default of the machine
 	BaseValue, HeadValue BenchmarkValue
+	BaselineShift        *BaselineShift // set when the history shows the base moved recently
+	Drift                float64        // machine drift in percent measured by the latest baseline runs, 0 when unknown
+	DiffFlamegraphKey    string         // key of an uploaded diff profile (head - base), empty when only kept locally
+	Instability          []Instability  // sides whose samples spread too much to trust the diff
+	CriticalFunctions    []CriticalFunction
+}
+
+// Resource returns the name of the result's resource. It is suffixed with
+// GOMAXPROCS like the benchmark names of go test, when it has been set.
+func (r *BenchmarkResult) Resource() string {
+	if r.GOMAXPROCS == 0 {
+		return r.Name
+	}
+	return fmt.Sprintf("%s-%d", r.Name, r.GOMAXPROCS)
+}
+
+// CriticalRegressions returns the critical functions, which regressed.
+func (r *BenchmarkResult) CriticalRegressions() []CriticalFunction {
+	var result []CriticalFunction
+	for _, f := range r.CriticalFunctions {
+		if f.Diff > 0 {
+			result = append(result, f)
+		}
+	}
+	return result
+}
+
+// BaseMarkdown lists the values of base, e.g. of a removed benchmark, or its
+// status while it has not run yet.
+func (r *BenchmarkRun) BaseMarkdown() string {
+	var parts []string
+	for i := range r.Results {
+		res := &r.Results[i]
+		if res.BaseValue.FlamegraphKey != "" {
+			parts = append(parts, res.Resource()+" "+res.BaseMarkdown())
+		}
+	}
+	for i := range r.Metrics {
+		if m := &r.Metrics[i]; m.HasBase {
+			parts = append(parts, m.Unit+" "+m.BaseMarkdown())
+		}
+	}
+	if len(parts) == 0 {
+		return strings.Trim(r.Status(), "()")
+	}
+	return strings.Join(parts, ", ")
 }
 
 func (r *BenchmarkResult) BaseMarkdown() string {
@@ -138,27 +994,56 @@ func (r *BenchmarkResult) HeadMarkdown() string {
 	return r.HeadValue.markdown(r.Unit)
 }
 
-func (r *BenchmarkResult) diff() (float64, bool) {
+// Diff returns the difference between head and base in percent of the base
+// value. It returns false, when one of the values is missing.
+func (r *BenchmarkResult) Diff() (float64, bool) {
 	if r.BaseValue.FlamegraphKey == "" || r.HeadValue.FlamegraphKey == "" {
 		return 0, false
 	}
+	if r.BaseValue.ProfileValue == 0 && r.HeadValue.ProfileValue == 0 {
+		// e.g. no memory in use at the end of either benchmark
+		return 0, true
+	}
 
 	return float64(r.HeadValue.ProfileValue-r.BaseValue.ProfileValue) / float64(r.BaseValue.ProfileValue) * 100, true
 }
 
+// DiffFlamegraphURL returns the link to flamegraph.com comparing base and
+// head profile, it is empty when one of them can not be linked.
+func (r *BenchmarkResult) DiffFlamegraphURL() string {
+	if unlinked(r.BaseValue.FlamegraphKey) || unlinked(r.HeadValue.FlamegraphKey) {
+		return ""
+	}
+	return fmt.Sprintf("%s/share/%s/%s", baseURL, r.BaseValue.FlamegraphKey, r.HeadValue.FlamegraphKey)
+}
+
+// ChangesFlamegraphURL returns the link to the flamegraph of the diff profile,
+// showing only what changed between base and head. It is empty when no diff
+// profile has been uploaded.
+func (r *BenchmarkResult) ChangesFlamegraphURL() string {
+	if r.DiffFlamegraphKey == "" || unlinked(r.DiffFlamegraphKey) {
+		return ""
+	}
+	return fmt.Sprintf("%s/share/%s", baseURL, r.DiffFlamegraphKey)
+}
+
 func (r *BenchmarkResult) DiffMarkdown() string {
-	diff, ok := r.diff()
+	diff, ok := r.Diff()
 	if !ok {
 		return "n/a"
 	}
+	if r.Unstable() {
+		return r.unstableMarkdown()
+	}
 
-	return fmt.Sprintf(
-		"[%s %%](%s/share/%s/%s)",
-		humanize.CommafWithDigits(diff, 2),
-		baseURL,
-		r.BaseValue.FlamegraphKey,
-		r.HeadValue.FlamegraphKey,
-	)
+	md := humanize.CommafWithDigits(diff, 2) + " %"
+	if url := r.DiffFlamegraphURL(); url != "" {
+		md = fmt.Sprintf("[%s](%s)", md, url)
+	}
+	if url := r.ChangesFlamegraphURL(); url != "" {
+		md += fmt.Sprintf(" ([what changed](%s))", url)
+	}
+	return md
 }
 
 type gitHubComment struct {
@@ -177,17 +1062,57 @@ type gitHubComment struct {
 	wg     sync.WaitGroup
 }
 
+// The templates of the GitHub reports.
+const (
+	BuiltinTemplate = "builtin" // the template compiled into pyrobench
+)
+
 type Args struct {
-	GitHubCommenter     bool
-	ConsoleCommenter    bool
-	PercentageThreshold float64 // percentage of difference between the base and the value that will trigger a warning
+	GitHubCommenter          bool
+	GitHubCheckRun           bool
+	GitHubStepSummary        bool
+	ConsoleCommenter         bool
+	HTMLPath                 string   // path of the standalone HTML report, empty when disabled
+	JSONPath                 string   // path of the JSON report, which can be merged with others, empty when disabled
+	MarkdownPaths            []string // paths of the markdown report, "-" for stdout, empty when disabled
+	ParquetPath              string   // path of the Parquet export of all samples, empty when disabled
+	SamplesPath              string   // path of the CSV export of all samples, empty when disabled
+	PercentageThreshold      float64  // percentage of difference between the base and the value that will trigger a warning
+	MajorPercentageThreshold float64  // percentage of a regression, from which on it is classified as major
+	UnstableThreshold        float64  // coefficient of variation in percent, beyond which results are reported as unstable, 0 disables
+
+	CriticalPercentageThreshold float64 // same as PercentageThreshold, but for functions marked as critical
+
+	SigningKey string // key to sign the final report with, disabled when empty
+	Language   string // language of the headers and verdicts of the GitHub reports
+	Template   string // path of the template of the GitHub reports, the built-in one when empty
+
+	CollapseUnchanged bool // collapse the runs without significant changes in the GitHub comment
+
+	EventsPath string         // path of the NDJSON lifecycle events, "-" for stdout, empty when disabled
+	Events     *events.Writer // opened from EventsPath by the command, nil when disabled
 }
 
 func AddArgs(cmd *kingpin.CmdClause) *Args {
 	args := &Args{}
 	cmd.Flag("github-commenter", "Enable reporting with github commenter").Default("false").BoolVar(&args.GitHubCommenter)
-	cmd.Flag("console-commenter", "Enable reporting with console commenter").Default("false").BoolVar(&args.ConsoleCommenter)
+	cmd.Flag("github-check-run", "Enable reporting as GitHub check run on the head commit, which fails when benchmarks regress more than the percentage threshold.").Default("false").BoolVar(&args.GitHubCheckRun)
+	cmd.Flag("github-step-summary", "Write the report to $GITHUB_STEP_SUMMARY and its key findings to $GITHUB_OUTPUT, once the benchmarks have finished.").Default("false").BoolVar(&args.GitHubStepSummary)
+	cmd.Flag("console-commenter", "Show a table of the benchmarks with their status and diffs on stdout. On a terminal the table is updated live and regressions are colored, otherwise finished benchmarks are printed line by line.").Default("false").BoolVar(&args.ConsoleCommenter)
+	cmd.Flag("report-html", "Write a standalone HTML report to this path.").PlaceHolder("PATH").StringVar(&args.HTMLPath)
+	cmd.Flag("report-json", "Write the report as JSON to this path. The reports of several shards can be combined with merge-reports.").PlaceHolder("PATH").StringVar(&args.JSONPath)
+	cmd.Flag("report-markdown", "Write the markdown report, as posted by the GitHub commenter, to this path. Use - to print the final report to stdout. Can be repeated, e.g. to write a file and print to stdout.").PlaceHolder("PATH").StringsVar(&args.MarkdownPaths)
+	cmd.Flag("export-parquet", "Export all samples of the benchmark runs with their metadata as Parquet file to this path.").PlaceHolder("PATH").StringVar(&args.ParquetPath)
+	cmd.Flag("samples-out", "Write every sample of the benchmark runs, with its benchmark, source, ref, metric, value, iteration and timestamp, as CSV file to this path.").PlaceHolder("PATH.csv").StringVar(&args.SamplesPath)
 	cmd.Flag("percentage-threshold", "Percentage of difference between the base and the value that will trigger a warning").Default("5").Float64Var(&args.PercentageThreshold)
+	cmd.Flag("collapse-unchanged", "Collapse the benchmarks without significant changes in the GitHub comment into a single table of their names and diffs.").Default("true").BoolVar(&args.CollapseUnchanged)
+	cmd.Flag("major-percentage-threshold", "Percentage of a regression, from which on it is marked as major regression (🔴) instead of a minor one (🟡) in the GitHub reports.").Default("20").Float64Var(&args.MajorPercentageThreshold)
+	cmd.Flag("unstable-threshold", "Coefficient of variation in percent of the samples of base or head, beyond which a result is reported as unstable instead of its diff. Bimodal samples are reported as unstable as well. 0 disables the analysis.").Default("10").Float64Var(&args.UnstableThreshold)
+	cmd.Flag("critical-percentage-threshold", "Percentage of difference of functions marked with //pyrobench:critical, that will trigger a warning regardless of the benchmark's difference.").Default("1").Float64Var(&args.CriticalPercentageThreshold)
+	cmd.Flag("signing-key", "Sign the final report posted to GitHub with this key (HMAC-SHA256), so it can be checked with the verify command.").Envar("PYROBENCH_SIGNING_KEY").StringVar(&args.SigningKey)
+	cmd.Flag("events-out", "Write machine-readable lifecycle events (discovery, compilation, benchmark runs, uploads, posted reports) as newline delimited JSON to this path. Use - for stdout.").PlaceHolder("PATH").StringVar(&args.EventsPath)
+	cmd.Flag("report-lang", "Language of the headers and verdicts of the GitHub reports, one of en, de, es, fr. The tables and numbers are not translated.").Default("en").StringVar(&args.Language)
+	cmd.Flag("report-template", "Go template replacing the built-in one of the GitHub reports. It is only read from this path, never from the checked out code, so pull requests can not change how their results are reported.").PlaceHolder("PATH").StringVar(&args.Template)
 	return args
 }
 
@@ -195,77 +1120,110 @@ type Reporter interface {
 	Stop() error
 }
 
-func NewConsoleReporter(ch <-chan *BenchmarkReport) Reporter {
-	r := &consoleReporter{
-		ch:     ch,
-		stopCh: make(chan struct{}),
-	}
-	r.wg.Add(1)
-	go r.run()
-	return r
-}
+// NewReporterFunc creates a reporter consuming the reports from the channel.
+type NewReporterFunc func(<-chan *BenchmarkReport) (Reporter, error)
 
-type consoleReporter struct {
-	ch     <-chan *BenchmarkReport
-	stopCh chan struct{}
-	wg     sync.WaitGroup
+type multiReporter struct {
+	reporters []Reporter
+	stop      chan struct{}  // closed by Stop, ends the fan out when ch stays open
+	delivered sync.WaitGroup // done once every report has been passed to the reporters
 }
 
-func (r *consoleReporter) Stop() error {
-	close(r.stopCh)
-	r.wg.Wait()
-	return nil
+// Stop waits for the reports received so far to be delivered to every
+// reporter, before stopping them, so none of them misses the final report.
+func (m *multiReporter) Stop() error {
+	close(m.stop)
+	m.delivered.Wait()
+	var err error
+	for _, r := range m.reporters {
+		err = errors.Join(err, r.Stop())
+	}
+	return err
 }
 
-func (r *consoleReporter) run() {
-	defer r.wg.Done()
+// NewMulti fans out the reports received on ch to all reporters created by
+// the given constructors. Every reporter receives a copy of each report, see
+// BenchmarkReport.Copy, and is fed by a queue of its own, so a slow reporter
+// does not delay the others. The returned reporter stops all of them.
+func NewMulti(ch <-chan *BenchmarkReport, constructors ...NewReporterFunc) (Reporter, error) {
+	if len(constructors) == 1 {
+		return constructors[0](ch)
+	}
 
-	for {
-		select {
-		case <-r.stopCh:
-			return
-		case report := <-r.ch:
-			if report == nil {
-				continue
+	m := &multiReporter{stop: make(chan struct{})}
+	ins := make([]chan *BenchmarkReport, 0, len(constructors))
+	outs := make([]chan *BenchmarkReport, 0, len(constructors))
+	for _, c := range constructors {
+		out := make(chan *BenchmarkReport)
+		r, err := c(out)
+		if err != nil {
+			for i, r := range m.reporters {
+				close(outs[i])
+				_ = r.Stop()
 			}
+			return nil, err
+		}
+		m.reporters = append(m.reporters, r)
+		ins = append(ins, make(chan *BenchmarkReport))
+		outs = append(outs, out)
+	}
 
-			if len(report.Runs) == 0 {
-				continue
+	m.delivered.Add(len(ins) + 1)
+	for i := range ins {
+		go func() {
+			defer m.delivered.Done()
+			queueReports(ins[i], outs[i])
+		}()
+	}
+	go func() {
+		defer m.delivered.Done()
+		defer func() {
+			for _, in := range ins {
+				close(in)
 			}
-
-			fmt.Printf("Benchmark Report\n")
-			fmt.Printf("Base: %s\n", report.BaseRef)
-			fmt.Printf("Head: %s\n", report.HeadRef)
-
-			for _, run := range report.Runs {
-				fmt.Printf("\nBenchmark: %s\n", run.Name)
-				fmt.Println("Markdown")
-				for _, result := range run.Results {
-					fmt.Printf("  %s\n", result.BaseMarkdown())
-					fmt.Printf("  %s\n", result.HeadMarkdown())
-				}
-
-				if run.BenchStatTables == nil {
-					continue
+		}()
+		for {
+			select {
+			case report, ok := <-ch:
+				if !ok {
+					return
 				}
-
-				tables := run.BenchStatTables.Tables
-				for _, table := range tables {
-					printTable(table)
+				for _, in := range ins {
+					in <- report
 				}
+			case <-m.stop:
+				return
 			}
 		}
-	}
-}
+	}()
 
-func printTable(table *benchtab.Table) {
-	fmt.Println("cols", table.Cols)
-	fmt.Println("rows", table.Rows)
-	fmt.Println("unit", table.Unit)
+	return m, nil
+}
 
-	fmt.Println("summary")
-	for _, col := range table.Cols {
-		fmt.Println("  col", col.String(), "summary", table.Summary[col].Summary)
+// queueReports passes copies of the reports from in on to out, queueing them
+// until the reporter consumes them. out is closed once in is closed and all
+// queued reports have been consumed.
+func queueReports(in <-chan *BenchmarkReport, out chan<- *BenchmarkReport) {
+	defer close(out)
+	var queue []*BenchmarkReport
+	for in != nil || len(queue) > 0 {
+		var (
+			send chan<- *BenchmarkReport
+			next *BenchmarkReport
+		)
+		if len(queue) > 0 {
+			send, next = out, queue[0]
+		}
+		select {
+		case re, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			queue = append(queue, re.Copy())
+		case send <- next:
+			queue = queue[1:]
+		}
 	}
 }
 
@@ -0,0 +1,177 @@
+package report
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOfflineMarkdown(t *testing.T) {
+	r := BenchmarkResult{
+		Name:      "cpu",
+		BaseValue: BenchmarkValue{ProfileValue: 100, FlamegraphKey: OfflineKeyPrefix + "a"},
+		HeadValue: BenchmarkValue{ProfileValue: 110, FlamegraphKey: OfflineKeyPrefix + "b"},
+	}
+	require.Equal(t, "100", r.BaseMarkdown())
+	require.Equal(t, "110", r.HeadMarkdown())
+	require.Equal(t, "10 %", r.DiffMarkdown())
+	require.Empty(t, r.DiffFlamegraphURL())
+
+	r.HeadValue.FlamegraphKey = "b"
+	require.Equal(t, "[110](https://flamegraph.com/share/b)", r.HeadMarkdown())
+	require.Equal(t, "10 %", r.DiffMarkdown(), "base has been kept offline")
+}
+
+func TestDiffWithoutSamples(t *testing.T) {
+	r := BenchmarkResult{
+		Name:      "inuse_space",
+		BaseValue: BenchmarkValue{FlamegraphKey: "a"},
+		HeadValue: BenchmarkValue{FlamegraphKey: "b"},
+	}
+	d, ok := r.Diff()
+	require.True(t, ok)
+	require.Zero(t, d)
+	require.Empty(t, (&BenchmarkRun{Results: []BenchmarkResult{r}}).Regressions(5))
+}
+
+func TestPlatformMatrix(t *testing.T) {
+	re := &BenchmarkReport{Runs: []BenchmarkRun{
+		{Name: "pkg.BenchmarkA", Platform: "linux/arm64"},
+		{Name: "pkg.BenchmarkB", Platform: "linux/arm64"},
+		{Name: "pkg.BenchmarkA", Platform: "linux/amd64"},
+		{Name: "pkg.BenchmarkOld", Platform: "linux/amd64", Removed: true},
+	}}
+	require.Equal(t, []string{"linux/amd64", "linux/arm64"}, re.Platforms())
+
+	rows := re.PlatformMatrix()
+	require.Len(t, rows, 2)
+	require.Equal(t, "pkg.BenchmarkA", rows[0].Name)
+	require.Same(t, &re.Runs[2], rows[0].Runs[0])
+	require.Same(t, &re.Runs[0], rows[0].Runs[1])
+	require.Equal(t, "pkg.BenchmarkB", rows[1].Name)
+	require.Nil(t, rows[1].Runs[0])
+
+	require.Nil(t, (&BenchmarkReport{Runs: []BenchmarkRun{{Name: "pkg.BenchmarkA"}}}).PlatformMatrix())
+}
+
+// funcReporter runs its function on the reports until the channel closes.
+type funcReporter struct {
+	done chan struct{}
+}
+
+func newFuncReporter(f func(<-chan *BenchmarkReport)) NewReporterFunc {
+	return func(ch <-chan *BenchmarkReport) (Reporter, error) {
+		r := &funcReporter{done: make(chan struct{})}
+		go func() {
+			defer close(r.done)
+			f(ch)
+		}()
+		return r, nil
+	}
+}
+
+func (r *funcReporter) Stop() error {
+	<-r.done
+	return nil
+}
+
+func TestMulti(t *testing.T) {
+	var received []*BenchmarkReport
+	finishing := newFuncReporter(func(ch <-chan *BenchmarkReport) {
+		for re := range ch {
+			re.Finished = true
+			re.Runs = append(re.Runs, BenchmarkRun{Name: "BenchmarkAdded"})
+		}
+	})
+	collecting := newFuncReporter(func(ch <-chan *BenchmarkReport) {
+		for re := range ch {
+			received = append(received, re)
+		}
+	})
+	// consumes the reports slowly, without delaying the others
+	lagging := newFuncReporter(func(ch <-chan *BenchmarkReport) {
+		for range ch {
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	ch := make(chan *BenchmarkReport)
+	r, err := NewMulti(ch, finishing, lagging, collecting)
+	require.NoError(t, err)
+	re := &BenchmarkReport{Runs: []BenchmarkRun{{Name: "BenchmarkA"}}}
+	for i := 0; i < 3; i++ {
+		ch <- re
+	}
+	close(ch)
+	require.NoError(t, r.Stop())
+
+	require.Len(t, received, 3)
+	for _, got := range received {
+		require.NotSame(t, re, got)
+		require.False(t, got.Finished)
+		require.Equal(t, re.Runs, got.Runs)
+	}
+	require.False(t, re.Finished)
+	require.Len(t, re.Runs, 1)
+}
+
+// lastReporter keeps the last report received, until it is stopped, like the
+// reporters writing files do.
+type lastReporter struct {
+	mtx    sync.Mutex
+	last   *BenchmarkReport
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+func (r *lastReporter) Stop() error {
+	close(r.stopCh)
+	r.wg.Wait()
+	return nil
+}
+
+func TestMultiDeliversFinalReport(t *testing.T) {
+	var reporters []*lastReporter
+	constructor := func(ch <-chan *BenchmarkReport) (Reporter, error) {
+		r := &lastReporter{stopCh: make(chan struct{})}
+		reporters = append(reporters, r)
+		r.wg.Add(1)
+		go func() {
+			defer r.wg.Done()
+			for {
+				select {
+				case <-r.stopCh:
+					return
+				case re, ok := <-ch:
+					if !ok {
+						return
+					}
+					// falls behind the reports sent
+					time.Sleep(time.Millisecond)
+					r.mtx.Lock()
+					r.last = re
+					r.mtx.Unlock()
+				}
+			}
+		}()
+		return r, nil
+	}
+
+	ch := make(chan *BenchmarkReport)
+	m, err := NewMulti(ch, constructor, constructor)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		ch <- &BenchmarkReport{}
+	}
+	ch <- (&BenchmarkReport{}).WithFinished()
+	// the channel is not closed, e.g. when the comparison failed
+	require.NoError(t, m.Stop())
+
+	require.Len(t, reporters, 2)
+	for _, r := range reporters {
+		require.NotNil(t, r.last)
+		require.True(t, r.last.Finished)
+	}
+}
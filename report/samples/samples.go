@@ -0,0 +1,159 @@
+// Package samples exports every sample of the benchmark runs as CSV file, so
+// they can be analyzed with other tools, e.g. R or pandas.
+package samples
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+// Header are the columns of the CSV file.
+var Header = []string{"benchmark", "source", "ref", "metric", "value", "iteration", "iterations", "timestamp", "config"}
+
+// Records flattens the samples of the report into records. The iteration
+// counts the samples of a benchmark, source, metric and configuration from 0
+// in the order they have been measured.
+func Records(re *report.BenchmarkReport) [][]string {
+	var records [][]string
+	for _, run := range re.Runs {
+		iterations := make(map[string]int)
+		for _, s := range run.Samples {
+			var config []string
+			for k, v := range s.Config {
+				config = append(config, k+"="+v)
+			}
+			sort.Strings(config)
+			cfg := strings.Join(config, " ")
+
+			ref := ""
+			switch s.Source {
+			case "base":
+				ref = re.BaseRef
+			case "head", "pgo":
+				ref = re.HeadRef
+			}
+			key := s.Source + "\x00" + s.Unit + "\x00" + cfg
+			iteration := iterations[key]
+			iterations[key]++
+
+			var ts string
+			if !s.Time.IsZero() {
+				ts = s.Time.UTC().Format(time.RFC3339Nano)
+			}
+			name := run.Name
+			if run.Platform != "" {
+				name += " (" + run.Platform + ")"
+			}
+			records = append(records, []string{
+				name,
+				s.Source,
+				ref,
+				s.Unit,
+				strconv.FormatFloat(s.Value, 'g', -1, 64),
+				strconv.Itoa(iteration),
+				strconv.Itoa(s.Iterations),
+				ts,
+				cfg,
+			})
+		}
+	}
+	return records
+}
+
+// Write writes the samples of the report as CSV, with a header.
+func Write(w io.Writer, re *report.BenchmarkReport) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(Header); err != nil {
+		return err
+	}
+	if err := cw.WriteAll(Records(re)); err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+type samplesReporter struct {
+	logger log.Logger
+	path   string
+
+	ch     <-chan *report.BenchmarkReport
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewReporter returns a reporter, which writes the samples of the last report
+// to path, once the benchmarks have finished.
+func NewReporter(logger log.Logger, path string, ch <-chan *report.BenchmarkReport) report.Reporter {
+	r := &samplesReporter{
+		logger: log.With(logger, "module", "samples-reporter"),
+		path:   path,
+		ch:     ch,
+		stopCh: make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go r.run()
+	return r
+}
+
+func (r *samplesReporter) write(re *report.BenchmarkReport) error {
+	f, err := os.CreateTemp(filepath.Dir(r.path), ".pyrobench-samples-*.csv")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := Write(f, re); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), r.path)
+}
+
+func (r *samplesReporter) run() {
+	defer r.wg.Done()
+
+	var lastReport *report.BenchmarkReport
+	defer func() {
+		if lastReport == nil {
+			return
+		}
+		if err := r.write(lastReport); err != nil {
+			level.Warn(r.logger).Log("msg", "failed to write samples", "path", r.path, "err", err)
+		}
+	}()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		case re, ok := <-r.ch:
+			if !ok {
+				return
+			}
+			if re == nil {
+				continue
+			}
+			lastReport = re
+		}
+	}
+}
+
+func (r *samplesReporter) Stop() error {
+	close(r.stopCh)
+	r.wg.Wait()
+	return nil
+}
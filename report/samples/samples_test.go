@@ -0,0 +1,43 @@
+package samples
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/grafana/pyrobench/report"
+)
+
+func TestWrite(t *testing.T) {
+	ts := time.Date(2024, 8, 1, 12, 0, 0, 500, time.UTC)
+	config := map[string]string{"pkg": "pkg1", "name": "BenchTestA"}
+	re := &report.BenchmarkReport{
+		BaseRef: "abcd",
+		HeadRef: "ef00",
+		Runs: []report.BenchmarkRun{
+			{
+				Name: "pkg1.BenchTestA",
+				Samples: []report.Sample{
+					{Source: "base", Unit: "sec/op", Value: 0.01, Iterations: 100, Config: config, Time: ts},
+					{Source: "base", Unit: "B/op", Value: 512, Iterations: 100, Config: config, Time: ts},
+					{Source: "head", Unit: "sec/op", Value: 0.02, Iterations: 50, Config: config, Time: ts},
+					{Source: "base", Unit: "sec/op", Value: 0.0125, Iterations: 100, Config: config},
+				},
+			},
+			{
+				Name: "pkg1.BenchTestB",
+			},
+		},
+	}
+
+	buf := new(bytes.Buffer)
+	require.NoError(t, Write(buf, re))
+	require.Equal(t, `benchmark,source,ref,metric,value,iteration,iterations,timestamp,config
+pkg1.BenchTestA,base,abcd,sec/op,0.01,0,100,2024-08-01T12:00:00.0000005Z,name=BenchTestA pkg=pkg1
+pkg1.BenchTestA,base,abcd,B/op,512,0,100,2024-08-01T12:00:00.0000005Z,name=BenchTestA pkg=pkg1
+pkg1.BenchTestA,head,ef00,sec/op,0.02,0,50,2024-08-01T12:00:00.0000005Z,name=BenchTestA pkg=pkg1
+pkg1.BenchTestA,base,abcd,sec/op,0.0125,1,100,,name=BenchTestA pkg=pkg1
+`, buf.String())
+}
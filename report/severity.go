@@ -0,0 +1,169 @@
+package report
+
+import (
+	"math"
+)
+
+// Severity classifies the change of a result or metric between base and
+// head.
+type Severity int
+
+const (
+	SeverityUnknown         Severity = iota // not compared, e.g. a side is missing
+	SeverityImproved                        // better by more than the threshold or significantly
+	SeverityUnchanged                       // within the threshold, not significant or unstable
+	SeverityMinorRegression                 // worse by more than the threshold or significantly
+	SeverityMajorRegression                 // worse by more than the major threshold
+)
+
+// Icon returns the icon shown next to the diff, empty when unknown.
+func (s Severity) Icon() string {
+	switch s {
+	case SeverityImproved:
+		return "🟢"
+	case SeverityUnchanged:
+		return "⚪"
+	case SeverityMinorRegression:
+		return "🟡"
+	case SeverityMajorRegression:
+		return "🔴"
+	}
+	return ""
+}
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityImproved:
+		return "improvement"
+	case SeverityUnchanged:
+		return "unchanged"
+	case SeverityMinorRegression:
+		return "minor regression"
+	case SeverityMajorRegression:
+		return "major regression"
+	}
+	return "unknown"
+}
+
+// Regressed returns true for minor and major regressions.
+func (s Severity) Regressed() bool {
+	return s >= SeverityMinorRegression
+}
+
+// SeverityThresholds are the percentages classifying the changes.
+type SeverityThresholds struct {
+	Minor float64 // changes beyond are improvements or regressions, like the percentage threshold
+	Major float64 // regressions beyond are major
+}
+
+// SeverityThresholds returns the thresholds of the arguments, the defaults of
+// the flags without arguments.
+func (args *Args) SeverityThresholds() SeverityThresholds {
+	if args == nil {
+		return SeverityThresholds{Minor: 5, Major: 20}
+	}
+	return SeverityThresholds{Minor: args.PercentageThreshold, Major: args.MajorPercentageThreshold}
+}
+
+// Result classifies the diff of the profiles like Regressions: the measured
+// drift of the machine widens the threshold, unstable results are
+// unchanged and regressed critical functions are at least a minor regression.
+func (t SeverityThresholds) Result(r *BenchmarkResult) Severity {
+	d, ok := r.Diff()
+	if !ok {
+		return SeverityUnknown
+	}
+	margin := math.Max(t.Minor, math.Abs(r.Drift))
+	critical := len(r.CriticalRegressions()) > 0
+	switch {
+	case r.Unstable() && !critical:
+		return SeverityUnchanged
+	case d > math.Max(t.Major, margin):
+		return SeverityMajorRegression
+	case d > margin || critical:
+		return SeverityMinorRegression
+	case d < -margin && !r.Unstable():
+		return SeverityImproved
+	}
+	return SeverityUnchanged
+}
+
+// Metric classifies a metric of benchstat by its significance, significant
+// regressions beyond the major threshold are major.
+func (t SeverityThresholds) Metric(m *BenchmarkMetric) Severity {
+	switch {
+	case !m.HasBase || !m.HasHead:
+		return SeverityUnknown
+	case m.Improved():
+		return SeverityImproved
+	case m.Regressed():
+		if m.Base != 0 && math.Abs((m.Head-m.Base)/m.Base*100) > t.Major {
+			return SeverityMajorRegression
+		}
+		return SeverityMinorRegression
+	}
+	return SeverityUnchanged
+}
+
+// Run classifies the run by its worst result or metric. Runs improving
+// without any regression are improvements.
+func (t SeverityThresholds) Run(r *BenchmarkRun) Severity {
+	worst := SeverityUnknown
+	for i := range r.Results {
+		worst = worse(worst, t.Result(&r.Results[i]))
+	}
+	for i := range r.Metrics {
+		worst = worse(worst, t.Metric(&r.Metrics[i]))
+	}
+	return worst
+}
+
+// worse returns the severity dominating the summary of a run: regressions
+// over improvements over unchanged results.
+func worse(a, b Severity) Severity {
+	rank := func(s Severity) int {
+		if s == SeverityImproved {
+			// between unchanged and the regressions
+			return int(SeverityUnchanged) + 1
+		}
+		if s.Regressed() {
+			return int(s) + 1
+		}
+		return int(s)
+	}
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+// SeveritySummary counts the runs of a report by their severity.
+type SeveritySummary struct {
+	Regressions  int // minor and major
+	Major        int
+	Unchanged    int
+	Improvements int
+}
+
+// Summary counts the compared runs of the report, it is nil when none has
+// been compared yet.
+func (t SeverityThresholds) Summary(re *BenchmarkReport) *SeveritySummary {
+	var s SeveritySummary
+	for i := range re.Runs {
+		switch sev := t.Run(&re.Runs[i]); {
+		case sev.Regressed():
+			s.Regressions++
+			if sev == SeverityMajorRegression {
+				s.Major++
+			}
+		case sev == SeverityUnchanged:
+			s.Unchanged++
+		case sev == SeverityImproved:
+			s.Improvements++
+		}
+	}
+	if s == (SeveritySummary{}) {
+		return nil
+	}
+	return &s
+}
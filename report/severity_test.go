@@ -0,0 +1,85 @@
+package report
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeverity(t *testing.T) {
+	thresholds := SeverityThresholds{Minor: 5, Major: 20}
+	result := func(base, head int64) BenchmarkResult {
+		return BenchmarkResult{
+			Name:      "cpu",
+			BaseValue: BenchmarkValue{ProfileValue: base, FlamegraphKey: "base"},
+			HeadValue: BenchmarkValue{ProfileValue: head, FlamegraphKey: "head"},
+		}
+	}
+
+	for _, tc := range []struct {
+		name     string
+		result   BenchmarkResult
+		expected Severity
+	}{
+		{"improved", result(100, 90), SeverityImproved},
+		{"unchanged", result(100, 103), SeverityUnchanged},
+		{"minor", result(100, 110), SeverityMinorRegression},
+		{"major", result(100, 130), SeverityMajorRegression},
+		{"missing base", BenchmarkResult{HeadValue: BenchmarkValue{ProfileValue: 100, FlamegraphKey: "head"}}, SeverityUnknown},
+		{"within drift", func() BenchmarkResult { r := result(100, 110); r.Drift = 12; return r }(), SeverityUnchanged},
+		{"unstable", func() BenchmarkResult {
+			r := result(100, 130)
+			r.Instability = []Instability{{Source: "head"}}
+			return r
+		}(), SeverityUnchanged},
+		{"critical", func() BenchmarkResult {
+			r := result(100, 101)
+			r.CriticalFunctions = []CriticalFunction{{Name: "main.hot", Diff: 10}}
+			return r
+		}(), SeverityMinorRegression},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, thresholds.Result(&tc.result))
+		})
+	}
+
+	metric := func(base, head float64, delta string) *BenchmarkMetric {
+		return &BenchmarkMetric{Unit: "sec/op", Base: base, Head: head, HasBase: true, HasHead: true, Delta: delta, Better: -1}
+	}
+	require.Equal(t, SeverityUnchanged, thresholds.Metric(metric(100, 150, "~")), "not significant")
+	require.Equal(t, SeverityImproved, thresholds.Metric(metric(100, 98, "-2.00%")))
+	require.Equal(t, SeverityMinorRegression, thresholds.Metric(metric(100, 102, "+2.00%")), "significant, but below the major threshold")
+	require.Equal(t, SeverityMajorRegression, thresholds.Metric(metric(100, 150, "+50.00%")))
+	require.Equal(t, SeverityUnknown, thresholds.Metric(&BenchmarkMetric{Unit: "sec/op", HasHead: true}))
+
+	require.Equal(t, "🟢", SeverityImproved.Icon())
+	require.Equal(t, "⚪", SeverityUnchanged.Icon())
+	require.Equal(t, "🟡", SeverityMinorRegression.Icon())
+	require.Equal(t, "🔴", SeverityMajorRegression.Icon())
+	require.Empty(t, SeverityUnknown.Icon())
+}
+
+func TestSeveritySummary(t *testing.T) {
+	thresholds := SeverityThresholds{Minor: 5, Major: 20}
+	run := func(head int64) BenchmarkRun {
+		return BenchmarkRun{Results: []BenchmarkResult{{
+			Name:      "cpu",
+			BaseValue: BenchmarkValue{ProfileValue: 100, FlamegraphKey: "base"},
+			HeadValue: BenchmarkValue{ProfileValue: head, FlamegraphKey: "head"},
+		}}}
+	}
+
+	require.Nil(t, thresholds.Summary(&BenchmarkReport{Runs: []BenchmarkRun{{Name: "scheduled"}}}))
+
+	// a run regressing one resource and improving another regressed
+	mixed := run(130)
+	mixed.Results = append(mixed.Results, BenchmarkResult{
+		Name:      "alloc_space",
+		BaseValue: BenchmarkValue{ProfileValue: 100, FlamegraphKey: "base"},
+		HeadValue: BenchmarkValue{ProfileValue: 50, FlamegraphKey: "head"},
+	})
+	require.Equal(t, SeverityMajorRegression, thresholds.Run(&mixed))
+
+	re := &BenchmarkReport{Runs: []BenchmarkRun{mixed, run(110), run(100), run(101), run(90), {Name: "scheduled"}}}
+	require.Equal(t, &SeveritySummary{Regressions: 2, Major: 1, Unchanged: 2, Improvements: 1}, thresholds.Summary(re))
+}
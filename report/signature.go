@@ -0,0 +1,92 @@
+package report
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// signaturePrefix starts the line holding the signature of a report. It is an
+// HTML comment, so it is not visible in rendered Markdown.
+const signaturePrefix = "<!-- pyrobench-signature v1 "
+
+// Provenance describes what a signed report claims to be about.
+type Provenance struct {
+	Repository string // owner/repo
+	Head       string // commit the benchmarks ran for
+}
+
+// ErrNotSigned is returned by Verify, when the body carries no signature.
+var ErrNotSigned = errors.New("report is not signed")
+
+func signaturePayload(key []byte, p Provenance, body string) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "pyrobench-v1\n%s\n%s\n", p.Repository, p.Head)
+	mac.Write([]byte(normalizeNewlines(body)))
+	return mac.Sum(nil)
+}
+
+// GitHub might hand out bodies with CRLF line endings.
+func normalizeNewlines(s string) string {
+	return strings.ReplaceAll(s, "\r\n", "\n")
+}
+
+// Sign appends an HMAC-SHA256 signature of the body and its provenance to
+// the body. Anyone knowing the key can then verify, that the body has been
+// posted by the benchmarking workflow for that repository and commit.
+func Sign(key []byte, p Provenance, body string) string {
+	body = normalizeNewlines(body)
+	if !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	return fmt.Sprintf(
+		"%s%srepository=%s head=%s hmac-sha256=%s -->\n",
+		body,
+		signaturePrefix,
+		p.Repository,
+		p.Head,
+		hex.EncodeToString(signaturePayload(key, p, body)),
+	)
+}
+
+// Verify checks the signature of a body signed by Sign and returns the
+// provenance it was signed for.
+func Verify(key []byte, signed string) (*Provenance, error) {
+	signed = normalizeNewlines(signed)
+	idx := strings.LastIndex(signed, signaturePrefix)
+	if idx < 0 {
+		return nil, ErrNotSigned
+	}
+	body, line := signed[:idx], strings.TrimSpace(signed[idx+len(signaturePrefix):])
+	line, ok := strings.CutSuffix(line, "-->")
+	if !ok {
+		return nil, errors.New("malformed signature")
+	}
+
+	var p Provenance
+	var sig []byte
+	for _, field := range strings.Fields(line) {
+		k, v, _ := strings.Cut(field, "=")
+		switch k {
+		case "repository":
+			p.Repository = v
+		case "head":
+			p.Head = v
+		case "hmac-sha256":
+			var err error
+			if sig, err = hex.DecodeString(v); err != nil {
+				return nil, fmt.Errorf("malformed signature: %w", err)
+			}
+		}
+	}
+	if sig == nil {
+		return nil, errors.New("malformed signature")
+	}
+	if !hmac.Equal(sig, signaturePayload(key, p, body)) {
+		return nil, errors.New("invalid signature")
+	}
+	return &p, nil
+}
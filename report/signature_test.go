@@ -0,0 +1,38 @@
+package report
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignature(t *testing.T) {
+	key := []byte("secret")
+	p := Provenance{Repository: "my-org/my-repo", Head: "ef00"}
+	body := "### Benchmark Report\n\n__Finished__\n"
+
+	signed := Sign(key, p, body)
+	require.True(t, strings.HasPrefix(signed, body))
+
+	actual, err := Verify(key, signed)
+	require.NoError(t, err)
+	require.Equal(t, p, *actual)
+
+	// GitHub might return the body with CRLF line endings
+	actual, err = Verify(key, strings.ReplaceAll(signed, "\n", "\r\n"))
+	require.NoError(t, err)
+	require.Equal(t, p, *actual)
+
+	_, err = Verify([]byte("other"), signed)
+	require.EqualError(t, err, "invalid signature")
+
+	_, err = Verify(key, strings.Replace(signed, "Finished", "Finishde", 1))
+	require.EqualError(t, err, "invalid signature")
+
+	_, err = Verify(key, strings.Replace(signed, "head=ef00", "head=ef01", 1))
+	require.EqualError(t, err, "invalid signature")
+
+	_, err = Verify(key, body)
+	require.ErrorIs(t, err, ErrNotSigned)
+}
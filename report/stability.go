@@ -0,0 +1,63 @@
+package report
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"golang.org/x/perf/benchunit"
+)
+
+// Instability describes the samples of base or head, which spread too much to
+// trust the diff of a result.
+type Instability struct {
+	Source  string  // base or head
+	Unit    string  // of the samples, e.g. sec/op
+	Samples int     // number of samples
+	CV      float64 // coefficient of variation in percent
+	Bimodal bool    // the samples form two clusters
+
+	Min, Median, Max float64
+}
+
+// String describes the spread of the samples, e.g. "head sec/op: 6 samples
+// between 1.000m and 1.500m, median 1.020m, CV 20.5 %, bimodal".
+func (i *Instability) String() string {
+	cls := benchunit.ClassOf(i.Unit)
+	s := fmt.Sprintf(
+		"%s %s: %d samples between %s and %s, median %s, CV %s %%",
+		i.Source,
+		i.Unit,
+		i.Samples,
+		benchunit.Scale(i.Min, cls),
+		benchunit.Scale(i.Max, cls),
+		benchunit.Scale(i.Median, cls),
+		humanize.CommafWithDigits(i.CV, 1),
+	)
+	if i.Bimodal {
+		s += ", bimodal"
+	}
+	return s
+}
+
+// Unstable returns true, when the samples of base or head spread too much to
+// trust the diff.
+func (r *BenchmarkResult) Unstable() bool {
+	return len(r.Instability) > 0
+}
+
+// Spread describes the samples of the unstable sides.
+func (r *BenchmarkResult) Spread() string {
+	parts := make([]string, 0, len(r.Instability))
+	for i := range r.Instability {
+		parts = append(parts, r.Instability[i].String())
+	}
+	return strings.Join(parts, "; ")
+}
+
+// unstableMarkdown replaces the diff of an unstable result, the spread of the
+// samples is shown as tooltip.
+func (r *BenchmarkResult) unstableMarkdown() string {
+	return fmt.Sprintf(`:warning: <abbr title="%s">unstable</abbr>`, html.EscapeString(r.Spread()))
+}
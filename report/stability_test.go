@@ -0,0 +1,35 @@
+package report
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnstableResult(t *testing.T) {
+	run := &BenchmarkRun{Results: []BenchmarkResult{{
+		Name:      "cpu",
+		BaseValue: BenchmarkValue{ProfileValue: 100, FlamegraphKey: "base"},
+		HeadValue: BenchmarkValue{ProfileValue: 150, FlamegraphKey: "head"},
+	}}}
+	require.Len(t, run.Regressions(5), 1)
+
+	res := &run.Results[0]
+	res.Instability = []Instability{{
+		Source:  "head",
+		Unit:    "sec/op",
+		Samples: 6,
+		CV:      3.21,
+		Bimodal: true,
+		Min:     0.001,
+		Median:  0.0011,
+		Max:     0.0015,
+	}}
+	require.Equal(t, "head sec/op: 6 samples between 1.000m and 1.500m, median 1.100m, CV 3.2 %, bimodal", res.Spread())
+	require.Equal(t, `:warning: <abbr title="head sec/op: 6 samples between 1.000m and 1.500m, median 1.100m, CV 3.2 %, bimodal">unstable</abbr>`, res.DiffMarkdown())
+	require.Equal(t, "(cpu=unstable)", run.Status())
+	require.Empty(t, run.Regressions(5), "the diff of unstable results is not trusted")
+
+	res.CriticalFunctions = []CriticalFunction{{Name: "main.hot", Diff: 20}}
+	require.Len(t, run.Regressions(5), 1, "critical functions still regress")
+}